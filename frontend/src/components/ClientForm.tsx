@@ -16,6 +16,7 @@ interface OAuthClient {
   redirect_uris: string[]
   scopes: string[]
   grant_types: string[]
+  client_type?: 'confidential' | 'public'
   active: boolean
   created_at: string
   updated_at: string
@@ -27,6 +28,7 @@ interface ClientFormData {
   redirect_uris: string[]
   scopes: string[]
   grant_types: string[]
+  client_type: 'confidential' | 'public'
   active: boolean
 }
 
@@ -59,6 +61,7 @@ export default function ClientForm({ client, onSubmit, onCancel }: ClientFormPro
     redirect_uris: client?.redirect_uris || [''],
     scopes: client?.scopes || ['read', 'openid'],
     grant_types: client?.grant_types || ['authorization_code', 'refresh_token'],
+    client_type: client?.client_type || 'confidential',
     active: client?.active ?? true
   })
 
@@ -80,13 +83,14 @@ export default function ClientForm({ client, onSubmit, onCancel }: ClientFormPro
   const handleSubmit = (e: React.FormEvent) => {
     e.preventDefault()
     
-    // Filter out empty redirect URIs
+    // Filter out empty redirect URIs; new clients always start active and the type can't be changed later
+    const { active, client_type, ...rest } = formData
     const cleanedData = {
-      ...formData,
+      ...(client ? { ...rest, active } : { ...rest, client_type }),
       redirect_uris: formData.redirect_uris.filter(uri => uri.trim() !== '')
     }
     
-    onSubmit(cleanedData)
+    onSubmit(cleanedData as ClientFormData)
   }
 
   const handleScopeChange = (scope: string, checked: boolean) => {
@@ -165,6 +169,28 @@ export default function ClientForm({ client, onSubmit, onCancel }: ClientFormPro
         </div>
       </div>
 
+      <div className="space-y-2">
+        <Label htmlFor="client_type">Client Type</Label>
+        <Select
+          value={formData.client_type}
+          disabled={!!client}
+          onValueChange={(value) => setFormData(prev => ({
+            ...prev,
+            client_type: value as 'confidential' | 'public',
+            // Public clients can't use grants that need a secret
+            grant_types: value === 'public' ? prev.grant_types.filter(gt => gt !== 'client_credentials') : prev.grant_types
+          }))}
+        >
+          <SelectTrigger>
+            <SelectValue />
+          </SelectTrigger>
+          <SelectContent>
+            <SelectItem value="confidential">Confidential (server-side app with a client secret)</SelectItem>
+            <SelectItem value="public">Public (SPA or native app, PKCE with S256, no secret)</SelectItem>
+          </SelectContent>
+        </Select>
+      </div>
+
       <div className="space-y-2">
         <Label htmlFor="description">Description</Label>
         <Textarea
@@ -234,7 +260,7 @@ export default function ClientForm({ client, onSubmit, onCancel }: ClientFormPro
       <div className="space-y-3">
         <Label>Grant Types</Label>
         <div className="grid grid-cols-2 gap-2">
-          {AVAILABLE_GRANT_TYPES.map((grantType) => (
+          {AVAILABLE_GRANT_TYPES.filter(gt => formData.client_type !== 'public' || gt !== 'client_credentials').map((grantType) => (
             <div key={grantType} className="flex items-center space-x-2">
               <Checkbox
                 id={`grant-${grantType}`}
@@ -11,7 +11,7 @@ import { toast } from 'sonner'
 import ClientForm from './ClientForm'
 import AccessDenied from './AccessDenied'
 import { usePermissions } from '@/hooks/usePermissions'
-import { apiClient } from '@/lib/api'
+import { apiClient, errorMessage } from '@/lib/api'
 import { useTenant } from '@/contexts/TenantContext'
 
 interface OAuthClient {
@@ -23,11 +23,12 @@ interface OAuthClient {
   scopes: string[]
   grant_types: string[]
   active: boolean
+  version?: number
   created_at: string
   updated_at: string
+  client_type?: 'confidential' | 'public'
   // Frontend-only fields for display
   clientSecret?: string
-  type?: 'confidential' | 'public'
 }
 
 interface ClientFormData {
@@ -36,6 +37,7 @@ interface ClientFormData {
   redirect_uris: string[]
   scopes: string[]
   grant_types: string[]
+  client_type: 'confidential' | 'public'
   active: boolean
 }
 
@@ -120,14 +122,20 @@ export default function ClientManagement() {
       setClients(prev => [...prev, clientWithSecret])
       setIsDialogOpen(false)
       setSelectedClient(null)
-      
+
+      // Public clients have no secret to show
+      if (!newClient.client_secret) {
+        toast.success('OAuth client created successfully')
+        return
+      }
+
       // Make the secret visible immediately and show important notice
       setVisibleSecrets(prev => new Set([...prev, newClient.id]))
       setNewlyCreatedClient(clientWithSecret)
       toast.success('OAuth client created successfully - make sure to copy the client secret!')
     } catch (error) {
       console.error('Error creating client:', error)
-      toast.error('Failed to create client')
+      toast.error(errorMessage(error, 'Failed to create client'))
     }
   }
 
@@ -135,7 +143,7 @@ export default function ClientManagement() {
     if (!selectedClient) return
     
     try {
-      const updatedClient = await apiClient.clients.update(selectedClient.id, clientData)
+      const updatedClient = await apiClient.clients.update(selectedClient.id, { ...clientData, version: selectedClient.version })
       setClients(prev => prev.map(client => 
         client.id === selectedClient.id ? updatedClient : client
       ))
@@ -144,7 +152,7 @@ export default function ClientManagement() {
       toast.success('OAuth client updated successfully')
     } catch (error) {
       console.error('Error updating client:', error)
-      toast.error('Failed to update client')
+      toast.error(errorMessage(error, 'Failed to update client'))
     }
   }
 
@@ -375,6 +383,9 @@ export default function ClientManagement() {
                       </div>
                     </TableCell>
                     <TableCell>
+                      {client.client_type === 'public' ? (
+                        <span className="text-sm text-muted-foreground">None (PKCE)</span>
+                      ) : (
                       <div className="flex items-center space-x-2">
                         <code className="text-sm bg-muted px-2 py-1 rounded">
                           {visibleSecrets.has(client.id) ? (client.clientSecret || 'Not available') : '••••••••••••••••'}
@@ -402,10 +413,11 @@ export default function ClientManagement() {
                           <RefreshCw size={12} />
                         </Button>
                       </div>
+                      )}
                     </TableCell>
                     <TableCell>
-                      <Badge className={getTypeBadgeColor(client.type || 'public')}>
-                        {client.type || 'public'}
+                      <Badge className={getTypeBadgeColor(client.client_type || 'confidential')}>
+                        {client.client_type || 'confidential'}
                       </Badge>
                     </TableCell>
                     <TableCell>
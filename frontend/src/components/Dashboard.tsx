@@ -7,17 +7,33 @@ import { useTenant } from '@/contexts/TenantContext'
 
 interface ActivityItem {
   id: string
-  type: 'user' | 'group' | 'client'
-  action: string
-  target: string
-  timestamp: string
+  type: string
+  email?: string
+  user_id?: string
+  client_id?: string
+  target?: string
+  reason?: string
+  created_at: string
+}
+
+const activityActions: Record<string, string> = {
+  login_success: 'Signed in',
+  login_failure: 'Failed login',
+  two_factor_enabled: 'Enabled two-factor authentication',
+  client_secret_rotated: 'Rotated client secret of',
+  group_member_added: 'Added to group',
+  group_member_removed: 'Removed from group',
+  provider_updated: 'Updated social provider',
+  email_changed: 'Changed email address',
+  user_deactivated: 'Deactivated user',
+  user_deleted: 'Deleted user',
 }
 
 export default function Dashboard() {
   const [users, setUsers] = useState<any[]>([])
   const [groups, setGroups] = useState<any[]>([])
   const [clients, setClients] = useState<any[]>([])
-  const [activity] = useState<ActivityItem[]>([])
+  const [activity, setActivity] = useState<ActivityItem[]>([])
   const [loading, setLoading] = useState(true)
   const [error, setError] = useState<string | null>(null)
   const { onTenantChange, activeTenant } = useTenant()
@@ -28,6 +44,7 @@ export default function Dashboard() {
       setUsers([])
       setGroups([])
       setClients([])
+      setActivity([])
       setLoading(false)
       setError(null)
       return
@@ -39,16 +56,18 @@ export default function Dashboard() {
       // Ensure localStorage is updated before making API calls
       localStorage.setItem('activeTenantId', activeTenant.id)
       
-      const [usersData, groupsData, clientsData] = await Promise.all([
+      const [usersData, groupsData, clientsData, activityData] = await Promise.all([
         apiClient.users.getAll(),
         apiClient.groups.getAll(),
-        apiClient.clients.getAll()
+        apiClient.clients.getAll(),
+        apiClient.dashboard.getActivity({ limit: 5 }).catch(() => null)
       ])
       
       // Ensure we always have arrays, even if API returns null
       setUsers(Array.isArray(usersData) ? usersData : [])
       setGroups(Array.isArray(groupsData) ? groupsData : [])
       setClients(Array.isArray(clientsData) ? clientsData : [])
+      setActivity(Array.isArray(activityData?.events) ? activityData.events : [])
       setError(null)
     } catch (error) {
       console.error('Failed to fetch dashboard data:', error)
@@ -57,6 +76,7 @@ export default function Dashboard() {
       setUsers([])
       setGroups([])
       setClients([])
+      setActivity([])
     } finally {
       setLoading(false)
     }
@@ -192,13 +212,14 @@ export default function Dashboard() {
                 {recentActivity.map((item) => (
                   <div key={item.id} className="flex items-center space-x-4">
                     <Badge variant="outline" className="capitalize">
-                      {item.type}
+                      {item.type.replace(/_/g, ' ')}
                     </Badge>
                     <div className="flex-1">
                       <p className="text-sm">
-                        {item.action} <span className="font-medium">{item.target}</span>
+                        {activityActions[item.type] ?? item.type}{' '}
+                        <span className="font-medium">{item.email || item.target || item.client_id || item.user_id}</span>
                       </p>
-                      <p className="text-xs text-muted-foreground">{item.timestamp}</p>
+                      <p className="text-xs text-muted-foreground">{new Date(item.created_at).toLocaleString()}</p>
                     </div>
                   </div>
                 ))}
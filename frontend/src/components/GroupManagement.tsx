@@ -17,6 +17,7 @@ interface Group {
   description: string
   scopes: string[]
   members: string[]
+  version?: number
   created_at: string
   updated_at: string
 }
@@ -111,7 +112,7 @@ export default function GroupManagement() {
     if (!selectedGroup) return
     
     try {
-      const updatedGroup = await apiClient.groups.update(selectedGroup.id, groupData)
+      const updatedGroup = await apiClient.groups.update(selectedGroup.id, { ...groupData, version: selectedGroup.version })
       setGroups(safeGroups.map(group =>
         group.id === selectedGroup.id ? updatedGroup : group
       ))
@@ -10,7 +10,7 @@ import { toast } from 'sonner'
 import ScopeForm from './ScopeForm'
 import AccessDenied from './AccessDenied'
 import { usePermissions } from '@/hooks/usePermissions'
-import { apiClient } from '@/lib/api'
+import { apiClient, errorMessage } from '@/lib/api'
 
 interface Scope {
   id: string
@@ -132,7 +132,7 @@ export default function ScopeManagement() {
       toast.success('Scope created successfully')
     } catch (error) {
       console.error('Error creating scope:', error)
-      toast.error('Failed to create scope')
+      toast.error(errorMessage(error, 'Failed to create scope'))
     }
   }
 
@@ -140,7 +140,9 @@ export default function ScopeManagement() {
     if (!selectedScope) return
     
     try {
-      await apiClient.scopes.update(selectedScope.id, scopeData)
+      // Scope names are immutable; the update only takes the remaining fields
+      const { name, ...updateData } = scopeData
+      await apiClient.scopes.update(selectedScope.id, updateData)
       setScopes(prev => prev.map(scope => 
         scope.id === selectedScope.id 
           ? { ...scope, ...scopeData, updated_at: new Date().toISOString() }
@@ -151,7 +153,7 @@ export default function ScopeManagement() {
       toast.success('Scope updated successfully')
     } catch (error) {
       console.error('Error updating scope:', error)
-      toast.error('Failed to update scope')
+      toast.error(errorMessage(error, 'Failed to update scope'))
     }
   }
 
@@ -28,6 +28,10 @@ interface SocialProvider {
   tokenUrl: string
   userInfoUrl: string
   configured: boolean
+  useGlobal?: boolean // sign in with the platform's shared credentials
+  globalAvailable?: boolean
+  directoryTenant?: string // Microsoft: common, organizations, consumers or a directory ID/domain
+  syncGroups?: boolean // Microsoft: import group memberships from Microsoft Graph
 }
 
 const defaultProviders: SocialProvider[] = [
@@ -82,6 +86,32 @@ const defaultProviders: SocialProvider[] = [
     tokenUrl: 'https://appleid.apple.com/auth/token',
     userInfoUrl: '',
     configured: false
+  },
+  {
+    id: 'microsoft',
+    name: 'Microsoft',
+    enabled: true,
+    clientId: '',
+    clientSecret: '',
+    redirectUrl: `${import.meta.env.VITE_API_BASE_URL || 'https://oauth2.imsc.eu'}/auth/microsoft/callback`,
+    scopes: ['openid', 'profile', 'email', 'User.Read'],
+    authUrl: 'https://login.microsoftonline.com/common/oauth2/v2.0/authorize',
+    tokenUrl: 'https://login.microsoftonline.com/common/oauth2/v2.0/token',
+    userInfoUrl: 'https://graph.microsoft.com/v1.0/me',
+    configured: false
+  },
+  {
+    id: 'linkedin',
+    name: 'LinkedIn',
+    enabled: true,
+    clientId: '',
+    clientSecret: '',
+    redirectUrl: `${import.meta.env.VITE_API_BASE_URL || 'https://oauth2.imsc.eu'}/auth/linkedin/callback`,
+    scopes: ['openid', 'profile', 'email'],
+    authUrl: 'https://www.linkedin.com/oauth/v2/authorization',
+    tokenUrl: 'https://www.linkedin.com/oauth/v2/accessToken',
+    userInfoUrl: 'https://api.linkedin.com/v2/userinfo',
+    configured: false
   }
 ]
 
@@ -108,6 +138,19 @@ const providerIcons = {
     <svg viewBox="0 0 24 24" className="w-6 h-6" fill="currentColor">
       <path d="M12.152 6.896c-.948 0-2.415-1.078-3.96-1.04-2.04.027-3.91 1.183-4.961 3.014-2.117 3.675-.546 9.103 1.519 12.09 1.013 1.454 2.208 3.09 3.792 3.039 1.52-.065 2.09-.987 3.935-.987 1.831 0 2.35.987 3.96.948 1.637-.026 2.676-1.48 3.676-2.948 1.156-1.688 1.636-3.325 1.662-3.415-.039-.013-3.182-1.221-3.22-4.857-.026-3.04 2.48-4.494 2.597-4.559-1.429-2.09-3.623-2.324-4.39-2.376-2-.156-3.675 1.09-4.61 1.09zM15.53 3.83c.843-1.012 1.4-2.427 1.245-3.83-1.207.052-2.662.805-3.532 1.818-.78.896-1.454 2.338-1.273 3.714 1.338.104 2.715-.688 3.559-1.701" />
     </svg>
+  ),
+  microsoft: (
+    <svg viewBox="0 0 24 24" className="w-6 h-6">
+      <path fill="#F25022" d="M1 1h10.5v10.5H1z" />
+      <path fill="#7FBA00" d="M12.5 1H23v10.5H12.5z" />
+      <path fill="#00A4EF" d="M1 12.5h10.5V23H1z" />
+      <path fill="#FFB900" d="M12.5 12.5H23V23H12.5z" />
+    </svg>
+  ),
+  linkedin: (
+    <svg viewBox="0 0 24 24" className="w-6 h-6" fill="#0A66C2">
+      <path d="M20.45 20.45h-3.56v-5.57c0-1.33-.02-3.04-1.85-3.04-1.85 0-2.14 1.45-2.14 2.94v5.67H9.35V9h3.41v1.56h.05c.48-.9 1.64-1.85 3.37-1.85 3.6 0 4.27 2.37 4.27 5.46v6.28zM5.34 7.43a2.06 2.06 0 1 1 0-4.13 2.06 2.06 0 0 1 0 4.13zM7.12 20.45H3.56V9h3.56v11.45zM22.22 0H1.77C.79 0 0 .77 0 1.73v20.54C0 23.23.79 24 1.77 24h20.45c.98 0 1.78-.77 1.78-1.73V1.73C24 .77 23.2 0 22.22 0z" />
+    </svg>
   )
 }
 
@@ -156,6 +199,28 @@ const setupGuides = {
       'Generate private key and create JWT client secret'
     ],
     docsUrl: 'https://developer.apple.com/documentation/sign_in_with_apple'
+  },
+  microsoft: {
+    title: 'Microsoft Entra ID App Registration',
+    steps: [
+      'Go to the Microsoft Entra admin center → App registrations',
+      'Create a new registration and choose the supported account types',
+      `Add a Web redirect URI: ${import.meta.env.VITE_API_BASE_URL || 'https://oauth2.imsc.eu'}/auth/microsoft/callback`,
+      'Create a client secret under Certificates & secrets',
+      'Grant GroupMember.Read.All to import group memberships (optional)',
+      'Copy the Application (client) ID and the client secret'
+    ],
+    docsUrl: 'https://learn.microsoft.com/en-us/entra/identity-platform/quickstart-register-app'
+  },
+  linkedin: {
+    title: 'LinkedIn App Setup',
+    steps: [
+      'Go to LinkedIn Developers (linkedin.com/developers) and create an app',
+      'Add the "Sign In with LinkedIn using OpenID Connect" product',
+      `Add the authorized redirect URL: ${import.meta.env.VITE_API_BASE_URL || 'https://oauth2.imsc.eu'}/auth/linkedin/callback`,
+      'Copy the Client ID and Client Secret'
+    ],
+    docsUrl: 'https://learn.microsoft.com/en-us/linkedin/consumer/integrations/self-serve/sign-in-with-linkedin-v2'
   }
 }
 
@@ -255,6 +320,9 @@ export default function SocialLoginSetup() {
         clientId: updatedProvider.clientId,
         clientSecret: updatedProvider.clientSecret,
         redirectUrl: tenantCallbackUrl,
+        useGlobal: !!updatedProvider.useGlobal,
+        directoryTenant: updatedProvider.directoryTenant || '',
+        syncGroups: !!updatedProvider.syncGroups,
       })
 
       setProviders(prev => prev.map(p => 
@@ -262,7 +330,9 @@ export default function SocialLoginSetup() {
           ? { 
               ...updatedProvider, 
               redirectUrl: tenantCallbackUrl,
-              configured: !!(updatedProvider.clientId && updatedProvider.clientSecret) 
+              configured: updatedProvider.useGlobal
+                ? !!updatedProvider.globalAvailable
+                : !!(updatedProvider.clientId && updatedProvider.clientSecret)
             }
           : p
       ))
@@ -595,6 +665,19 @@ function ProviderConfigForm({ provider, onSave, onCancel }: ProviderConfigFormPr
 
       {/* Configuration Form */}
       <form onSubmit={handleSubmit} className="space-y-4">
+        {provider.globalAvailable && (
+          <div className="flex items-center space-x-2">
+            <Switch
+              id="useGlobal"
+              checked={!!formData.useGlobal}
+              onCheckedChange={(checked) => setFormData(prev => ({ ...prev, useGlobal: checked }))}
+            />
+            <Label htmlFor="useGlobal">Use the platform's shared {provider.name} app</Label>
+          </div>
+        )}
+
+        {!formData.useGlobal && (
+        <>
         <div className="grid grid-cols-2 gap-4">
           <div className="space-y-2">
             <Label htmlFor="clientId">Client ID *</Label>
@@ -631,6 +714,33 @@ function ProviderConfigForm({ provider, onSave, onCancel }: ProviderConfigFormPr
             This URL must be configured in your {provider.name} OAuth application
           </p>
         </div>
+        </>
+        )}
+
+        {provider.id === 'microsoft' && (
+          <div className="grid grid-cols-2 gap-4">
+            <div className="space-y-2">
+              <Label htmlFor="directoryTenant">Directory</Label>
+              <Input
+                id="directoryTenant"
+                value={formData.directoryTenant || ''}
+                onChange={(e) => setFormData(prev => ({ ...prev, directoryTenant: e.target.value }))}
+                placeholder="common"
+              />
+              <p className="text-xs text-muted-foreground">
+                common, organizations, consumers, or a directory ID or domain to allow only its accounts
+              </p>
+            </div>
+            <div className="flex items-center space-x-2">
+              <Switch
+                id="syncGroups"
+                checked={!!formData.syncGroups}
+                onCheckedChange={(checked) => setFormData(prev => ({ ...prev, syncGroups: checked }))}
+              />
+              <Label htmlFor="syncGroups">Import group memberships</Label>
+            </div>
+          </div>
+        )}
 
         <div className="flex items-center space-x-2">
           <Switch
@@ -153,12 +153,13 @@ export default function UserForm({ user, groups, onSubmit, onCancel }: UserFormP
 
   const handleSubmit = (e: React.FormEvent) => {
     e.preventDefault()
-    // Exclude password when updating existing user (backend may ignore it)
+    // Exclude password when updating existing user; new users always start active
     if (user) {
       const { password, ...rest } = formData as any
       onSubmit(rest)
     } else {
-      onSubmit(formData as any)
+      const { active, ...rest } = formData as any
+      onSubmit(rest)
     }
   }
 
@@ -8,7 +8,7 @@ import { Table, TableBody, TableCell, TableHead, TableHeader, TableRow } from '@
 import { Plus, Search, Edit, Trash2 } from 'lucide-react'
 import { toast } from 'sonner'
 import UserForm from './UserForm'
-import { apiClient } from '@/lib/api'
+import { apiClient, errorMessage } from '@/lib/api'
 import AccessDenied from './AccessDenied'
 import { usePermissions } from '@/hooks/usePermissions'
 import { useTenant } from '@/contexts/TenantContext'
@@ -22,6 +22,7 @@ interface User {
   active: boolean
   groups: string[]
   scopes: string[]
+  version?: number
   created_at: string
   updated_at: string
 }
@@ -115,7 +116,7 @@ export default function UserManagement() {
       toast.success('User created successfully')
     } catch (error) {
       console.error('Failed to create user:', error)
-      toast.error('Failed to create user')
+      toast.error(errorMessage(error, 'Failed to create user'))
       // Keep dialog open on error
     }
   }
@@ -124,7 +125,7 @@ export default function UserManagement() {
     if (!selectedUser) return
     
     try {
-      const updatedUser = await apiClient.users.update(selectedUser.id, userData)
+      const updatedUser = await apiClient.users.update(selectedUser.id, { ...userData, version: selectedUser.version })
       setUsers(safeUsers.map(user =>
         user.id === selectedUser.id
           ? { ...user, ...updatedUser, updated_at: new Date().toISOString() }
@@ -144,7 +145,7 @@ export default function UserManagement() {
       toast.success('User updated successfully')
     } catch (error) {
       console.error('Failed to update user:', error)
-      toast.error('Failed to update user')
+      toast.error(errorMessage(error, 'Failed to update user'))
       // Keep dialog open on error
     }
   }
@@ -1,6 +1,12 @@
-import { createContext, useContext, useEffect, useState, ReactNode } from 'react'
+import { createContext, useContext, useEffect, useRef, useState, ReactNode } from 'react'
 import { authService, User } from '@/lib/auth'
 
+// How often the session is kept alive, how long before the access token expires it is renewed,
+// and how recently the user must have been active for the renewal
+const HEARTBEAT_INTERVAL_MS = 60 * 1000
+const RENEW_BEFORE_EXPIRY_SECONDS = 120
+const RENEW_ACTIVITY_WINDOW_MS = 30 * 60 * 1000
+
 interface AuthContextType {
   user: User | null
   isLoading: boolean
@@ -45,6 +51,45 @@ export function AuthProvider({ children }: AuthProviderProps) {
     checkAuth()
   }, [])
 
+  // While the user works in the app, keep the server's SSO session alive and renew the access
+  // token silently before it expires. Idle users let the session time out; once it has, the
+  // renewal fails and they are signed out when the token expires.
+  const lastActivity = useRef(Date.now())
+  useEffect(() => {
+    if (!user) {
+      return
+    }
+
+    const recordActivity = () => { lastActivity.current = Date.now() }
+    const events = ['mousedown', 'keydown', 'scroll', 'touchstart']
+    events.forEach((event) => window.addEventListener(event, recordActivity, { passive: true }))
+
+    const keepAlive = async () => {
+      const expiresIn = authService.tokenExpiresIn()
+      if (expiresIn !== null && expiresIn < RENEW_BEFORE_EXPIRY_SECONDS) {
+        const active = Date.now() - lastActivity.current < RENEW_ACTIVITY_WINDOW_MS
+        if (active && await authService.silentRenew()) {
+          return
+        }
+        if (expiresIn <= 0) {
+          console.info('[AuthContext] session ended, signing out')
+          authService.logout()
+          setUser(null)
+        }
+        return
+      }
+      if (Date.now() - lastActivity.current < HEARTBEAT_INTERVAL_MS) {
+        await authService.heartbeat()
+      }
+    }
+
+    const timer = setInterval(keepAlive, HEARTBEAT_INTERVAL_MS)
+    return () => {
+      clearInterval(timer)
+      events.forEach((event) => window.removeEventListener(event, recordActivity))
+    }
+  }, [user])
+
   const login = () => {
     console.info('[AuthContext] login requested')
     authService.startLogin()
@@ -3,6 +3,47 @@ import { config } from './config'
 
 const API_BASE = config.apiBaseUrl
 
+// FieldError is a single violation reported by the server's request validation
+export interface FieldError {
+  field: string
+  rule: string
+  param?: string
+  message: string
+}
+
+export class ApiError extends Error {
+  status: number
+  code?: string
+  fieldErrors: FieldError[]
+
+  constructor(status: number, message: string, code?: string, fieldErrors: FieldError[] = []) {
+    super(message)
+    this.name = 'ApiError'
+    this.status = status
+    this.code = code
+    this.fieldErrors = fieldErrors
+  }
+
+  // Maps JSON field names (e.g. "redirect_uris[1]") to their first error message
+  get fieldErrorMap(): Record<string, string> {
+    const map: Record<string, string> = {}
+    for (const fieldError of this.fieldErrors) {
+      if (!map[fieldError.field]) {
+        map[fieldError.field] = fieldError.message
+      }
+    }
+    return map
+  }
+}
+
+// Returns a user-facing message for a failed request, preferring server validation messages
+export function errorMessage(error: unknown, fallback: string): string {
+  if (error instanceof ApiError && error.fieldErrors.length > 0) {
+    return error.fieldErrors.map(fieldError => fieldError.message).join(', ')
+  }
+  return fallback
+}
+
 class ApiClient {
   private async request<T>(
     endpoint: string, 
@@ -19,11 +60,16 @@ class ApiClient {
         },
       })
 
+      const contentType = response.headers.get('content-type')
+
       if (!response.ok) {
-        throw new Error(`API request failed: ${response.status} ${response.statusText}`)
+        if (contentType && contentType.includes('application/json')) {
+          const body = await response.json().catch(() => ({}))
+          throw new ApiError(response.status, body.message || response.statusText, body.error, body.fields || [])
+        }
+        throw new ApiError(response.status, `API request failed: ${response.status} ${response.statusText}`)
       }
 
-      const contentType = response.headers.get('content-type')
       if (contentType && contentType.includes('application/json')) {
         return await response.json()
       }
@@ -96,6 +142,14 @@ class ApiClient {
 
   dashboard = {
     getStats: () => this.get<any>('/api/v1/dashboard/stats'),
+    getActivity: (params: { type?: string[]; limit?: number; cursor?: string } = {}) => {
+      const query = new URLSearchParams()
+      if (params.type?.length) query.set('type', params.type.join(','))
+      if (params.limit) query.set('limit', params.limit.toString())
+      if (params.cursor) query.set('cursor', params.cursor)
+      const suffix = query.toString() ? `?${query}` : ''
+      return this.get<any>(`/api/v1/dashboard/activity${suffix}`)
+    },
   }
 
   scopes = {
@@ -110,6 +164,10 @@ class ApiClient {
     getById: (id: string) => this.get<any>(`/api/v1/social/providers/${id}`),
     update: (id: string, data: any) => this.put<any>(`/api/v1/social/providers/${id}`, data),
     test: (id: string) => this.post<any>(`/api/v1/social/providers/${id}/test`),
+    getGroupMappings: (id: string) => this.get<any>(`/api/v1/social/providers/${id}/group-mappings`),
+    updateGroupMappings: (id: string, data: any) => this.put<any>(`/api/v1/social/providers/${id}/group-mappings`, data),
+    getCatalog: () => this.get<any[]>('/api/v1/social/catalog'),
+    updateCatalog: (id: string, data: any) => this.put<any>(`/api/v1/social/catalog/${id}`, data),
   }
 
   twoFactor = {
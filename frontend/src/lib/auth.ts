@@ -19,6 +19,24 @@ export interface AuthTokens {
   stored_at?: number
 }
 
+// Reported by GET /api/v1/session/heartbeat
+export interface SessionHeartbeat {
+  active: boolean
+  user_id: string
+  token_expires_at?: string
+  token_expires_in?: number
+  sso_session: {
+    expires_at: string
+    expires_in: number
+    max_expires_at: string
+    idle_timeout: number
+  } | null
+}
+
+// Message the callback page posts to the app from the hidden iframe of a silent renewal
+const SILENT_RENEW_MESSAGE = 'silent-renew-callback'
+const SILENT_RENEW_TIMEOUT_MS = 10000
+
 class AuthService {
   private readonly STORAGE_KEY = 'auth_tokens'
   private readonly CODE_VERIFIER_KEY = 'code_verifier'
@@ -143,11 +161,23 @@ class AuthService {
       throw new Error('Code verifier not found')
     }
 
+    // Codes of direct social logins belong to the server's built-in direct-social-login client
+    const clientId = state === 'direct-social-login' ? 'direct-social-login' : config.oauth.clientId
+    const tokens = await this.exchangeCode(code, clientId, codeVerifier)
+    this.storeTokens(tokens)
+
+    localStorage.removeItem(this.CODE_VERIFIER_KEY)
+    localStorage.removeItem('oauth_state')
+
+    return this.getCurrentUser()
+  }
+
+  private async exchangeCode(code: string, clientId: string, codeVerifier: string | null): Promise<AuthTokens> {
     const tokenData = new FormData()
     tokenData.append('grant_type', 'authorization_code')
     tokenData.append('code', code)
     tokenData.append('redirect_uri', config.oauth.redirectUri)
-    tokenData.append('client_id', config.oauth.clientId)
+    tokenData.append('client_id', clientId)
     
     // Only add code verifier if it exists (not needed for direct social login)
     if (codeVerifier) {
@@ -177,12 +207,102 @@ class AuthService {
 
     const tokens: AuthTokens = await response.json()
     console.info('[auth] received tokens', { hasAccessToken: !!tokens.access_token, hasIdToken: !!tokens.id_token })
-    this.storeTokens(tokens)
+    return tokens
+  }
 
-    localStorage.removeItem(this.CODE_VERIFIER_KEY)
-    localStorage.removeItem('oauth_state')
+  // Renews the tokens without interrupting the user: the authorization request runs with
+  // prompt=none in a hidden iframe and is answered from the server's SSO session. Resolves to
+  // false when the user has to sign in again (login_required, consent_required, ...).
+  async silentRenew(): Promise<boolean> {
+    const codeVerifier = this.generateCodeVerifier()
+    const codeChallenge = await this.generateCodeChallenge(codeVerifier)
+    const state = crypto.randomUUID()
 
-    return this.getCurrentUser()
+    const params = new URLSearchParams({
+      response_type: 'code',
+      client_id: config.oauth.clientId,
+      redirect_uri: config.oauth.redirectUri,
+      scope: config.oauth.scope,
+      state: state,
+      code_challenge: codeChallenge,
+      code_challenge_method: 'S256',
+      prompt: 'none'
+    })
+
+    const search = await new Promise<string | null>((resolve) => {
+      const iframe = document.createElement('iframe')
+      iframe.style.display = 'none'
+
+      const finish = (result: string | null) => {
+        window.removeEventListener('message', onMessage)
+        clearTimeout(timer)
+        iframe.remove()
+        resolve(result)
+      }
+      const onMessage = (event: MessageEvent) => {
+        if (event.origin !== window.location.origin || event.source !== iframe.contentWindow || event.data?.type !== SILENT_RENEW_MESSAGE) {
+          return
+        }
+        finish(event.data.search)
+      }
+      const timer = setTimeout(() => finish(null), SILENT_RENEW_TIMEOUT_MS)
+
+      window.addEventListener('message', onMessage)
+      iframe.src = TenantUrlBuilder.buildLegacyOAuthAuthorizeUrl(params)
+      document.body.appendChild(iframe)
+    })
+
+    const result = new URLSearchParams(search || '')
+    const code = result.get('code')
+    if (!code || result.get('state') !== state) {
+      console.info('[auth] silent renew failed', { error: search === null ? 'timeout' : result.get('error') })
+      return false
+    }
+
+    try {
+      this.storeTokens(await this.exchangeCode(code, config.oauth.clientId, codeVerifier))
+      console.info('[auth] silent renew succeeded')
+      return true
+    } catch (error) {
+      console.error('[auth] silent renew token exchange failed:', error)
+      return false
+    }
+  }
+
+  // Called on start-up: in the hidden iframe of a silent renewal the callback only hands its
+  // result to the app. Returns true when the page is such an iframe and must not render.
+  completeSilentRenew(): boolean {
+    if (window.parent === window || window.location.pathname !== new URL(config.oauth.redirectUri).pathname) {
+      return false
+    }
+    window.parent.postMessage({ type: SILENT_RENEW_MESSAGE, search: window.location.search }, window.location.origin)
+    return true
+  }
+
+  // Keeps the server's SSO session alive and reports how long it and the access token last
+  async heartbeat(): Promise<SessionHeartbeat | null> {
+    try {
+      const response = await this.makeAuthenticatedRequest(`${config.apiBaseUrl}/api/v1/session/heartbeat`, {
+        credentials: 'include'
+      })
+      if (!response.ok) {
+        console.warn('[auth] heartbeat failed', { status: response.status })
+        return null
+      }
+      return await response.json()
+    } catch (error) {
+      console.warn('[auth] heartbeat failed:', error)
+      return null
+    }
+  }
+
+  // Seconds until the stored access token expires, null when unknown
+  tokenExpiresIn(): number | null {
+    const tokens = this.getStoredTokens()
+    if (!tokens || !tokens.stored_at) {
+      return null
+    }
+    return Math.floor((tokens.stored_at + tokens.expires_in * 1000 - Date.now()) / 1000)
   }
 
   async getCurrentUser(): Promise<User> {
@@ -282,11 +402,13 @@ class AuthService {
       // Step 1: Authenticate with credentials and get authorization code
       const loginUrl = TenantUrlBuilder.buildLegacyDirectLoginUrl()
       
+      // Credentials are included so the browser keeps the server's SSO session cookie
       const response = await fetch(loginUrl, {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
         },
+        credentials: 'include',
         body: JSON.stringify({
           email,
           password,
@@ -2,6 +2,23 @@ import { config } from './config';
 import { authService } from './auth';
 import { Tenant, CreateTenantRequest, UpdateTenantRequest } from '@/types/tenant';
 
+// The API uses snake_case keys while the tenant types use camelCase; keys are converted in both
+// directions so settings aren't dropped by the server's strict decoding.
+const toSnakeCase = (key: string) => key.replace(/[A-Z]/g, (c) => `_${c.toLowerCase()}`);
+const toCamelCase = (key: string) => key.replace(/_([a-z])/g, (_, c) => c.toUpperCase());
+
+function convertKeys(value: any, convert: (key: string) => string): any {
+  if (Array.isArray(value)) {
+    return value.map((item) => convertKeys(item, convert));
+  }
+  if (value && typeof value === 'object') {
+    return Object.fromEntries(
+      Object.entries(value).map(([key, item]) => [convert(key), convertKeys(item, convert)])
+    );
+  }
+  return value;
+}
+
 class TenantService {
   private readonly baseUrl = `${config.apiBaseUrl}/api/v1/tenants`;
 
@@ -12,7 +29,7 @@ class TenantService {
       throw new Error('Failed to fetch tenants');
     }
     
-    return response.json();
+    return convertKeys(await response.json(), toCamelCase);
   }
 
   async getTenantById(id: string): Promise<Tenant> {
@@ -22,13 +39,13 @@ class TenantService {
       throw new Error('Failed to fetch tenant');
     }
     
-    return response.json();
+    return convertKeys(await response.json(), toCamelCase);
   }
 
   async createTenant(tenantData: CreateTenantRequest): Promise<Tenant> {
     const response = await authService.makeAuthenticatedRequest(this.baseUrl, {
       method: 'POST',
-      body: JSON.stringify(tenantData),
+      body: JSON.stringify(convertKeys(tenantData, toSnakeCase)),
     });
     
     if (!response.ok) {
@@ -36,13 +53,13 @@ class TenantService {
       throw new Error(`Failed to create tenant: ${errorText}`);
     }
     
-    return response.json();
+    return convertKeys(await response.json(), toCamelCase);
   }
 
   async updateTenant(id: string, tenantData: UpdateTenantRequest): Promise<Tenant> {
     const response = await authService.makeAuthenticatedRequest(`${this.baseUrl}/${id}`, {
       method: 'PUT',
-      body: JSON.stringify(tenantData),
+      body: JSON.stringify(convertKeys(tenantData, toSnakeCase)),
     });
     
     if (!response.ok) {
@@ -50,7 +67,7 @@ class TenantService {
       throw new Error(`Failed to update tenant: ${errorText}`);
     }
     
-    return response.json();
+    return convertKeys(await response.json(), toCamelCase);
   }
 
   async deleteTenant(id: string): Promise<void> {
@@ -4,12 +4,16 @@ import { ErrorBoundary } from "react-error-boundary";
 
 import App from './App.tsx'
 import { ErrorFallback } from './ErrorFallback.tsx'
+import { authService } from './lib/auth'
 
 import "./main.css"
 import "./index.css"
 
-createRoot(document.getElementById('root')!).render(
-  <ErrorBoundary FallbackComponent={ErrorFallback}>
-    <App />
-   </ErrorBoundary>
-)
+// The hidden iframe of a silent token renewal only reports the callback to the app
+if (!authService.completeSilentRenew()) {
+  createRoot(document.getElementById('root')!).render(
+    <ErrorBoundary FallbackComponent={ErrorFallback}>
+      <App />
+     </ErrorBoundary>
+  )
+}
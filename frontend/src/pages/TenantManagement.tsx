@@ -37,7 +37,7 @@ export const TenantManagement: React.FC = () => {
       setError(null);
 
       if (editingTenant) {
-        const updatedTenant = await tenantService.updateTenant(editingTenant.id!, data as UpdateTenantRequest);
+        const updatedTenant = await tenantService.updateTenant(editingTenant.id!, { ...(data as UpdateTenantRequest), version: editingTenant.version });
         setSelectedTenant(updatedTenant);
       } else {
         const newTenant = await tenantService.createTenant(data as CreateTenantRequest);
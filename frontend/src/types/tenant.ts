@@ -5,11 +5,53 @@ export interface TenantBranding {
   secondaryColor?: string;
 }
 
+export interface TenantQuotas {
+  maxUsers?: number; // 0 = unlimited
+  maxClients?: number;
+  maxTokensPerMonth?: number;
+}
+
+export interface KeyRotationPolicy {
+  intervalDays?: number; // 0 = rotate on demand only
+  gracePeriodHours?: number; // 0 = 24 hours
+  prepublishHours?: number; // 0 = 24 hours
+}
+
+export interface ProvisioningRule {
+  provider?: string;
+  emailDomain?: string;
+  externalGroup?: string; // group reported by the provider
+  groups?: string[];
+  scopes?: string[];
+}
+
+export interface ProvisioningPolicy {
+  mode?: 'auto' | 'disabled'; // default auto
+  allowedDomains?: string[]; // empty = any
+  defaultGroups?: string[];
+  defaultScopes?: string[]; // empty = read, openid, profile, email
+  rules?: ProvisioningRule[];
+}
+
+export interface FlowLifetimes {
+  authCodeSeconds?: number; // 30-1800, 0 = platform default
+  stateCookieSeconds?: number; // 60-3600
+  twoFactorSessionSeconds?: number; // 60-3600
+}
+
 export interface TenantSettings {
   allowUserRegistration?: boolean;
   requireTwoFactor?: boolean;
   sessionTimeout?: number; // in minutes
   customBranding?: TenantBranding;
+  quotas?: TenantQuotas;
+  keyRotation?: KeyRotationPolicy;
+  confirmEmailChange?: boolean; // email changes must also be confirmed from the old address
+  provisioning?: ProvisioningPolicy;
+  lifetimes?: FlowLifetimes;
+  codeBinding?: 'off' | 'user_agent' | 'user_agent_ip'; // bind authorization codes to the browser
+  allowSmsTwoFactor?: boolean; // users may use SMS codes as second factor
+  loginIdentifiers?: ('email' | 'username' | 'phone')[]; // tried in order; empty = email
 }
 
 export interface Tenant {
@@ -19,6 +61,7 @@ export interface Tenant {
   subdomain?: string;
   active?: boolean;
   settings?: TenantSettings;
+  version?: number;
   createdAt?: string;
   updatedAt?: string;
 }
@@ -35,4 +78,5 @@ export interface UpdateTenantRequest {
   domain: string;
   subdomain: string;
   settings: TenantSettings;
+  version?: number; // version the edit is based on; stale updates are rejected with 409
 }
\ No newline at end of file
@@ -0,0 +1,542 @@
+// Package app wires the services, handlers, routes and background jobs of the server. main uses it
+// to run the server; tests use it to exercise the real router against a test database.
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/autodiscovery"
+	"oauth2-openid-server/config"
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/handlers"
+	"oauth2-openid-server/logging"
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/routes"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// App is a fully wired server
+type App struct {
+	Deps        *routes.Dependencies
+	Router      *mux.Router
+	Scheduler   *services.Scheduler
+	Config      *config.Reloader
+	LoadShedder *middleware.LoadShedder
+}
+
+// New creates the services and handlers, prepares the database (indexes, migrations and
+// defaults) and registers the routes and background jobs. The scheduler is not started.
+func New(cfg *config.Config, db *database.MongoDB) (*App, error) {
+	// The log level and CORS origins follow configuration reloads
+	reloader := config.NewReloader(cfg)
+	applyLogLevel := func(cfg *config.Config) {
+		if cfg.LogLevel == "" {
+			return
+		}
+		if err := logging.SetLevel(cfg.LogLevel); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	applyLogLevel(cfg)
+	reloader.OnReload(applyLogLevel)
+
+	// Set before the services create their circuit breakers
+	db.SetBreakerPolicy(database.BreakerPolicy{
+		FailureThreshold: cfg.MongoBreakerThreshold,
+		OpenDuration:     time.Duration(cfg.MongoBreakerOpenSeconds) * time.Second,
+	})
+	loadShedder := middleware.NewLoadShedder(middleware.LoadShedding{
+		MaxConcurrent: cfg.LoadShedMaxConcurrent,
+		MaxQueue:      cfg.LoadShedMaxQueue,
+		QueueTimeout:  time.Duration(cfg.LoadShedQueueTimeoutMS) * time.Millisecond,
+	}, priorityRequest)
+
+	tenantService := services.NewTenantService(db)
+	userService := services.NewUserService(db)
+	groupService := services.NewGroupService(db)
+	clientService := services.NewClientService(db)
+	scopeService := services.NewScopeService(db.Database)
+	lifetimes := services.NewLifetimes(cfg.AuthCodeLifetime, cfg.StateCookieLifetime, cfg.TwoFactorSessionLifetime)
+	oauthService := services.NewOAuthService(db, cfg.JWTSecret, lifetimes)
+	socialAuthService := services.NewSocialAuthService(userService, db)
+	// Stateless mode seals flow state into the values the browser carries, so replicas behind a
+	// round-robin load balancer need no sticky sessions or flow store
+	var flowSealer *services.FlowSealer
+	if cfg.FlowStateMode == config.FlowStateStateless {
+		flowSealer = services.NewFlowSealer(cfg.FlowStateKey)
+	} else if cfg.FlowStateMode != config.FlowStateStore {
+		log.Printf("Warning: Unknown FLOW_STATE_MODE %q, keeping flow state in the database", cfg.FlowStateMode)
+	}
+	socialLoginStateService := services.NewSocialLoginStateService(db, flowSealer)
+	twoFactorService := services.NewTwoFactorService(db, lifetimes)
+	cryptoKeyService := services.NewCryptoKeyService(db)
+	membershipService := services.NewMembershipService(db)
+	scopeUsageService := services.NewScopeUsageService(db)
+	clientMetricsService := services.NewClientMetricsService(db)
+	clientRateLimitService := services.NewClientRateLimitService(db)
+	tenantDiscoveryService := services.NewTenantDiscoveryService(db)
+	clusterEvents := services.NewClusterEventBus(db)
+	appAssignmentService := services.NewAppAssignmentService(db)
+	auditService := services.NewAuditService(db)
+	reportService := services.NewReportService(db, auditService, tenantService)
+	legacyUsageService := services.NewLegacyUsageService(db)
+	quotaService := services.NewQuotaService(db)
+	sessionService := services.NewSessionService(db)
+	// Sign-outs elsewhere in the cluster are applied here too, so they win over concurrent writes
+	clusterEvents.Subscribe(services.ClusterEventSessionRevoked, sessionService.ApplyRevocationEvent)
+	// Browsers signed in at the server, for silent token renewal with prompt=none
+	ssoSessionService := services.NewSSOSessionService(db, cfg.SSOSessionIdleTimeout, cfg.SSOSessionLifetime)
+	if err := ssoSessionService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create SSO session indexes: %v", err)
+	}
+
+	// Sources of breached passwords for tenants that refuse them: the range API with the bloom
+	// filter as fallback, or in offline mode only the bloom filter
+	var breachCheckers []services.PasswordBreachChecker
+	if cfg.PasswordBreachMode == config.PasswordBreachOnline {
+		breachCheckers = append(breachCheckers, services.NewHIBPRangeChecker(cfg.PasswordBreachAPIURL, time.Duration(cfg.PasswordBreachCacheTTL)*time.Second))
+	}
+	if cfg.PasswordBreachBloomFile != "" {
+		if filter, err := services.LoadBloomFilterChecker(cfg.PasswordBreachBloomFile); err != nil {
+			log.Printf("Warning: Failed to load breached password bloom filter: %v", err)
+		} else {
+			breachCheckers = append(breachCheckers, filter)
+		}
+	}
+	passwordBreachService := services.NewPasswordBreachService(tenantService, breachCheckers...)
+
+	// Signs the cookies of the social login and SSO flows
+	cookieService := services.NewCookieService(cfg.CookieSecret, time.Duration(cfg.CookieKeyRotation)*time.Second)
+
+	// Notification channels used for out-of-band user prompts (e.g. CIBA approvals) and expiry warnings
+	notifiers := []services.Notifier{services.NewLogNotifier()}
+	if cfg.NotificationWebhookURL != "" {
+		notifiers = append(notifiers, services.NewWebhookNotifier(cfg.NotificationWebhookURL))
+	}
+	if cfg.SMTPHost != "" {
+		notifiers = append(notifiers, services.NewEmailNotifier(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom))
+	}
+	notifier := services.NewMultiNotifier(notifiers...)
+
+	// SMS gateway for phone verification and SMS sign-in codes
+	var smsGateway services.SMSGateway = services.NewLogSMSGateway()
+	if cfg.TwilioAccountSID != "" {
+		smsGateway = services.NewTwilioSMSGateway(cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioFromNumber)
+	}
+
+	// Exporters delivering metering events to the billing system
+	var meteringExporters []services.MeteringExporter
+	if cfg.MeteringWebhookURL != "" {
+		meteringExporters = append(meteringExporters, services.NewWebhookMeteringExporter(cfg.MeteringWebhookURL))
+	}
+	if cfg.MeteringKafkaRESTURL != "" {
+		meteringExporters = append(meteringExporters, services.NewKafkaMeteringExporter(cfg.MeteringKafkaRESTURL, cfg.MeteringKafkaTopic))
+	}
+	meteringService := services.NewMeteringService(db, meteringExporters...)
+	cibaService := services.NewCIBAService(db, userService, oauthService, notifier)
+	consentService := services.NewConsentService(db)
+	emailChangeService := services.NewEmailChangeService(db, notifier, cfg.WebBaseURL)
+	accountLinkService := services.NewAccountLinkService(db, notifier, cfg.WebBaseURL, passwordBreachService)
+	smsOTPService := services.NewSMSOTPService(db, smsGateway)
+	userMergeService := services.NewUserMergeService(db)
+	translationService := services.NewTranslationService(db, tenantService)
+	authorizeFlowService := services.NewAuthorizeFlowService(db, clientService, userService, twoFactorService, smsOTPService, consentService, oauthService, flowSealer)
+
+	// Initialize default social providers service
+	socialProviderService := services.NewSocialProviderService(db)
+
+	// Create setup service
+	setupTokenKey := ""
+	if flowSealer != nil {
+		setupTokenKey = cfg.FlowStateKey
+	}
+	setupService := services.NewSetupService(db, tenantService, userService, scopeService, groupService, socialProviderService, clientService, cfg.WebBaseURL, setupTokenKey)
+
+	// Ensure indexes backing user search exist
+	if err := userService.EnsureSearchIndexes(); err != nil {
+		log.Printf("Warning: Failed to create user search indexes: %v", err)
+	}
+	if err := userService.BackfillSearchKeys(); err != nil {
+		log.Printf("Warning: Failed to backfill user search keys: %v", err)
+	}
+	if err := clientService.EnsureSearchIndexes(); err != nil {
+		log.Printf("Warning: Failed to create client search indexes: %v", err)
+	}
+	if err := userService.EnsureIdentifierIndexes(); err != nil {
+		log.Printf("Warning: Failed to create login identifier indexes: %v", err)
+	}
+
+	// Route the collections of tenants with their own storage before their indexes are created
+	if err := tenantService.LoadStoragePlacements(); err != nil {
+		log.Printf("Warning: Failed to load tenant storage placements: %v", err)
+	}
+
+	if err := tenantService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create tenant indexes: %v", err)
+	}
+	if err := scopeUsageService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create scope usage indexes: %v", err)
+	}
+	if err := clientMetricsService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create client metrics indexes: %v", err)
+	}
+	if err := clientRateLimitService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create client rate limit indexes: %v", err)
+	}
+	if err := tenantDiscoveryService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create tenant discovery indexes: %v", err)
+	}
+	if err := clusterEvents.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create cluster event indexes: %v", err)
+	}
+	if err := oauthService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create access token indexes: %v", err)
+	}
+	if err := consentService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create consent receipt indexes: %v", err)
+	}
+	if err := auditService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create audit event indexes: %v", err)
+	}
+	if err := legacyUsageService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create legacy route usage indexes: %v", err)
+	}
+	if err := quotaService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create tenant usage indexes: %v", err)
+	}
+	if err := meteringService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create metering event indexes: %v", err)
+	}
+	if err := emailChangeService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create email change indexes: %v", err)
+	}
+	if err := accountLinkService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create password setup indexes: %v", err)
+	}
+	if err := smsOTPService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create SMS code indexes: %v", err)
+	}
+	if err := socialLoginStateService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create social login state indexes: %v", err)
+	}
+	if err := authorizeFlowService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create authorize flow indexes: %v", err)
+	}
+
+	// Convert legacy group references (names, group-side only memberships) to group IDs
+	if _, err := membershipService.MigrateGroupReferences(); err != nil {
+		log.Printf("Warning: Failed to migrate group memberships: %v", err)
+	}
+
+	// Administrators of the default tenant of installations older than the platform operator role
+	if defaultTenant, err := tenantService.GetDefaultTenant(); err == nil {
+		if err := groupService.GrantPlatformOperator(defaultTenant.ID.Hex()); err != nil {
+			log.Printf("Warning: Failed to grant the platform operator role: %v", err)
+		}
+	}
+
+	// Check if initial setup is required
+	setupRequired, err := setupService.IsSetupRequired()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check setup status: %w", err)
+	}
+
+	if setupRequired {
+		log.Printf("Database is empty - Initial setup required")
+		if _, err := setupService.GenerateSetupToken(); err != nil {
+			return nil, fmt.Errorf("failed to generate setup token: %w", err)
+		}
+	} else {
+		// Initialize default tenant if none exist (backwards compatibility)
+		if err := tenantService.InitializeDefaultTenant(); err != nil {
+			log.Printf("Warning: Failed to initialize default tenant: %v", err)
+		}
+	}
+
+	if !setupRequired {
+		// Initialize default scopes if none exist for default tenant
+		if err := scopeService.InitializeDefaultScopes(""); err != nil {
+			log.Printf("Warning: Failed to initialize default scopes: %v", err)
+		}
+
+		// Initialize default groups if none exist for default tenant
+		if err := groupService.InitializeDefaultGroups(""); err != nil {
+			log.Printf("Warning: Failed to initialize default groups: %v", err)
+		}
+
+		// Initialize default social providers if none exist for default tenant
+		if err := socialProviderService.InitializeDefaultProviders(""); err != nil {
+			log.Printf("Warning: Failed to initialize default social providers: %v", err)
+		}
+
+		// Seed the built-in system clients of the server's own login flows
+		if err := clientService.InitializeSystemClients("", cfg.WebBaseURL); err != nil {
+			log.Printf("Warning: Failed to initialize system clients: %v", err)
+		}
+
+		// Add providers and system clients introduced after a tenant was created
+		if tenants, err := tenantService.GetAllTenants(); err == nil {
+			for _, tenant := range tenants {
+				if err := socialProviderService.InitializeDefaultProviders(tenant.ID.Hex()); err != nil {
+					log.Printf("Warning: Failed to initialize social providers of tenant %s: %v", tenant.Name, err)
+				}
+				if err := clientService.InitializeSystemClients(tenant.ID.Hex(), cfg.WebBaseURL); err != nil {
+					log.Printf("Warning: Failed to initialize system clients of tenant %s: %v", tenant.Name, err)
+				}
+			}
+		}
+
+		// The web application reads role claims from the ID tokens of its frontend-client
+		if err := clientService.MigrateFrontendClientRoles(); err != nil {
+			log.Printf("Warning: Failed to migrate the frontend clients: %v", err)
+		}
+
+		// Initialize the global social provider catalog tenants can opt into
+		if err := socialProviderService.InitializeGlobalCatalog(); err != nil {
+			log.Printf("Warning: Failed to initialize global social provider catalog: %v", err)
+		}
+	}
+
+	// The platform signing keys don't belong to a tenant, so they are created even before setup:
+	// the JWKS endpoints must never publish an empty key set
+	if err := cryptoKeyService.InitializeDefaultKeys(context.Background()); err != nil {
+		log.Printf("Warning: Failed to initialize default cryptographic keys: %v", err)
+	}
+
+	// CAPTCHA and proof-of-work checks on the tenants' login and registration endpoints
+	botProtectionService := services.NewBotProtectionService(db, cfg.JWTSecret)
+	if err := botProtectionService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create bot protection indexes: %v", err)
+	}
+
+	// Static credentials of integrations that don't use OAuth, sent in the X-API-Key header
+	apiKeyService := services.NewAPIKeyService(db, auditService)
+	if err := apiKeyService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create API key indexes: %v", err)
+	}
+
+	// Expiring group memberships and the access reviews confirming memberships are still needed
+	if err := membershipService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create group membership indexes: %v", err)
+	}
+	accessReviewService := services.NewAccessReviewService(db, membershipService, auditService)
+	if err := accessReviewService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create access review indexes: %v", err)
+	}
+
+	// Flags rolling out new grants and flows per deployment and per tenant
+	featureFlagService := services.NewFeatureFlagService(db)
+	if err := featureFlagService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create feature flag indexes: %v", err)
+	}
+
+	authHandler := handlers.NewAuthHandler(userService, oauthService, socialAuthService, twoFactorService, cibaService, translationService, auditService, consentService, botProtectionService, featureFlagService, ssoSessionService, cookieService)
+	tenantSettingsChangeService := services.NewTenantSettingsChangeService(db)
+	tenantBootstrapService := services.NewTenantBootstrapService(db, tenantService, userService, scopeService, groupService, socialProviderService, clientService, cfg.WebBaseURL)
+	tenantHandler := handlers.NewTenantHandler(tenantService, socialProviderService, scopeService, groupService, clientService, tenantSettingsChangeService, tenantBootstrapService, cfg.WebBaseURL)
+	userDeactivationService := services.NewUserDeactivationService(db, sessionService, auditService, notifier)
+	userLifecycleService := services.NewUserLifecycleService(db, userDeactivationService)
+	if err := userLifecycleService.MigrateStatuses(); err != nil {
+		log.Printf("Warning: Failed to migrate user lifecycle states: %v", err)
+	}
+	userHandler := handlers.NewUserHandler(userService, tenantService, groupService, membershipService, consentService, emailChangeService, userDeactivationService, userLifecycleService, services.NewRegistrationNotifier(notifier), botProtectionService, passwordBreachService)
+	groupHandler := handlers.NewGroupHandler(groupService, membershipService, auditService)
+	clientHandler := handlers.NewClientHandler(clientService, clientMetricsService, appAssignmentService, auditService)
+	scopeHandler := handlers.NewScopeHandler(scopeService, scopeUsageService)
+	dashboardHandler := handlers.NewDashboardHandler(userService, groupService, clientService, auditService, db)
+	socialAuthHandler := handlers.NewSocialAuthHandler(socialAuthService, socialProviderService, socialLoginStateService, oauthService, twoFactorService, translationService, auditService, cookieService, cfg)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService, userService, oauthService, auditService)
+	setupHandler := handlers.NewSetupHandler(setupService)
+	autodiscoveryHandler := autodiscovery.NewHandler(autodiscovery.CapabilityBackchannelAuthentication).WithCapabilityFilter(func(tenantID string, capability autodiscovery.Capability) bool {
+		feature, flagged := capabilityFeatures[capability]
+		return !flagged || featureFlagService.Enabled(feature, tenantID)
+	})
+	jwksHandler := handlers.NewJWKSHandler(cryptoKeyService)
+	cibaHandler := handlers.NewCIBAHandler(cibaService, oauthService, featureFlagService)
+	authorizeFlowHandler := handlers.NewAuthorizeFlowHandler(authorizeFlowService, botProtectionService, ssoSessionService, cookieService)
+	translationHandler := handlers.NewTranslationHandler(translationService)
+	reportHandler := handlers.NewReportHandler(reportService, auditService)
+	apiVersionHandler := handlers.NewAPIVersionHandler(legacyUsageService, routes.LegacyDeprecations())
+	quotaHandler := handlers.NewQuotaHandler(quotaService)
+	meteringHandler := handlers.NewMeteringHandler(meteringService)
+	keyHandler := handlers.NewKeyHandler(cryptoKeyService, oauthService)
+	sessionHandler := handlers.NewSessionHandler(sessionService, auditService, ssoSessionService, cookieService)
+	emailChangeHandler := handlers.NewEmailChangeHandler(emailChangeService, auditService)
+	accountLinkHandler := handlers.NewAccountLinkHandler(accountLinkService, socialAuthService, auditService)
+	playgroundHandler := handlers.NewPlaygroundHandler(clientService, tenantService, translationService)
+	userMergeHandler := handlers.NewUserMergeHandler(userMergeService)
+	socialCatalogHandler := handlers.NewSocialCatalogHandler(socialProviderService)
+	smsOTPHandler := handlers.NewSMSOTPHandler(smsOTPService)
+	appPortalHandler := handlers.NewAppPortalHandler(appAssignmentService, services.NewAppLaunchService(db, cfg.JWTSecret), userService, tenantService, translationService)
+	maintenanceService := services.NewMaintenanceService(db)
+	maintenanceHandler := handlers.NewMaintenanceHandler(maintenanceService, tenantService)
+	configHandler := handlers.NewConfigHandler(reloader)
+	tokenDebugHandler := handlers.NewTokenDebugHandler(oauthService)
+	consentHandler := handlers.NewConsentHandler(consentService)
+	webFingerHandler := handlers.NewWebFingerHandler(tenantService)
+	tenantDiscoveryHandler := handlers.NewTenantDiscoveryHandler(tenantDiscoveryService, tenantService, auditService)
+
+	// Long-running admin operations run as background jobs
+	jobService := services.NewJobService(db)
+	jobService.Register(models.JobUserImport, services.UserImportJob(userService, membershipService, tenantService, passwordBreachService))
+	jobService.Register(models.JobTokenRevocation, services.TokenRevocationJob(db))
+	jobService.Register(models.JobTenantPurge, services.TenantPurgeJob(db))
+	if err := jobService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create job indexes: %v", err)
+	}
+	jobHandler := handlers.NewJobHandler(jobService, tenantService)
+	botProtectionHandler := handlers.NewBotProtectionHandler(botProtectionService)
+	featureFlagHandler := handlers.NewFeatureFlagHandler(featureFlagService, tenantService)
+
+	// Retried create requests with an Idempotency-Key header return the original response
+	idempotencyService := services.NewIdempotencyService(db)
+	if err := idempotencyService.EnsureIndexes(); err != nil {
+		log.Printf("Warning: Failed to create idempotency key indexes: %v", err)
+	}
+
+	// Setup all dependencies for routes
+	deps := &routes.Dependencies{
+		// Services
+		TenantService:     tenantService,
+		UserService:       userService,
+		GroupService:      groupService,
+		ClientService:     clientService,
+		ScopeService:      scopeService,
+		OAuthService:      oauthService,
+		SocialAuthService: socialAuthService,
+		TwoFactorService:  twoFactorService,
+		SetupService:      setupService,
+		ConsentService:    consentService,
+		MembershipService: membershipService,
+
+		LegacyUsageService: legacyUsageService,
+		MaintenanceService: maintenanceService,
+		IdempotencyService: idempotencyService,
+		APIKeyService:      apiKeyService,
+
+		// Handlers
+		AuthHandler:            authHandler,
+		TenantHandler:          tenantHandler,
+		UserHandler:            userHandler,
+		GroupHandler:           groupHandler,
+		ClientHandler:          clientHandler,
+		ScopeHandler:           scopeHandler,
+		DashboardHandler:       dashboardHandler,
+		SocialAuthHandler:      socialAuthHandler,
+		TwoFactorHandler:       twoFactorHandler,
+		SetupHandler:           setupHandler,
+		AutodiscoveryHandler:   autodiscoveryHandler,
+		JWKSHandler:            jwksHandler,
+		CIBAHandler:            cibaHandler,
+		AuthorizeFlowHandler:   authorizeFlowHandler,
+		TranslationHandler:     translationHandler,
+		ReportHandler:          reportHandler,
+		APIVersionHandler:      apiVersionHandler,
+		QuotaHandler:           quotaHandler,
+		MeteringHandler:        meteringHandler,
+		KeyHandler:             keyHandler,
+		SessionHandler:         sessionHandler,
+		EmailChangeHandler:     emailChangeHandler,
+		AccountLinkHandler:     accountLinkHandler,
+		PlaygroundHandler:      playgroundHandler,
+		UserMergeHandler:       userMergeHandler,
+		UserLifecycleHandler:   handlers.NewUserLifecycleHandler(userLifecycleService),
+		UserBulkHandler:        handlers.NewUserBulkHandler(services.NewUserBulkService(db, userLifecycleService, accountLinkService, sessionService), auditService),
+		SocialCatalogHandler:   socialCatalogHandler,
+		SMSOTPHandler:          smsOTPHandler,
+		AppPortalHandler:       appPortalHandler,
+		MaintenanceHandler:     maintenanceHandler,
+		FeatureFlagHandler:     featureFlagHandler,
+		ConfigHandler:          configHandler,
+		TokenDebugHandler:      tokenDebugHandler,
+		ConsentHandler:         consentHandler,
+		WebFingerHandler:       webFingerHandler,
+		TenantDiscoveryHandler: tenantDiscoveryHandler,
+		ClusterHandler:         handlers.NewClusterHandler(services.NewClusterLockService(db), clusterEvents, db.HomeRegion(), db, loadShedder),
+		JobHandler:             jobHandler,
+		BotProtectionHandler:   botProtectionHandler,
+		APIKeyHandler:          handlers.NewAPIKeyHandler(apiKeyService, auditService),
+		AccessReviewHandler:    handlers.NewAccessReviewHandler(accessReviewService, membershipService, auditService),
+	}
+
+	// Background maintenance jobs
+	scheduler := services.NewScheduler()
+	scheduler.Every("client-secret-expiry-notifications", time.Hour, func() error {
+		return clientService.NotifyExpiringSecrets(notifier)
+	})
+	scheduler.Every("weekly-tenant-reports", time.Hour, func() error {
+		return reportService.SendWeeklyReports(notifier)
+	})
+	scheduler.Every("metering-export", time.Minute, func() error {
+		_, err := meteringService.ExportPending()
+		return err
+	})
+	scheduler.Every("tenant-settings-changes", time.Minute, tenantSettingsChangeService.ApplyDueChanges)
+	scheduler.Every("tenant-storage-placements", time.Minute, tenantService.LoadStoragePlacements)
+	scheduler.Every("group-membership-reconciliation", 24*time.Hour, func() error {
+		_, err := membershipService.Reconcile("")
+		return err
+	})
+	scheduler.Every("group-membership-expiry", time.Minute, func() error {
+		_, err := membershipService.ExpireMemberships(auditService)
+		return err
+	})
+	scheduler.Every("access-reviews", time.Minute, accessReviewService.RunDue)
+	scheduler.Every("background-jobs", 5*time.Second, jobService.RunPending)
+	scheduler.Every("cluster-events", 5*time.Second, clusterEvents.Poll)
+	scheduler.Every("analytics-connection-check", 15*time.Second, func() error {
+		// Only changes of availability are worth logging, which CheckAnalytics does
+		db.CheckAnalytics()
+		return nil
+	})
+	scheduler.Every("signing-key-rotation", time.Hour, func() error {
+		if err := cryptoKeyService.RotateDueKeys(); err != nil {
+			return err
+		}
+		return cryptoKeyService.CleanupExpiredKeys(context.Background())
+	})
+
+	return &App{
+		Deps:        deps,
+		Router:      routes.SetupRoutes(deps),
+		Scheduler:   scheduler,
+		Config:      reloader,
+		LoadShedder: loadShedder,
+	}, nil
+}
+
+// priorityRequest reports the requests that may use the slots the load shedder reserves: token
+// requests, so signed-in clients keep working through an overload, and health checks, so the
+// instance isn't taken out of rotation for being busy
+func priorityRequest(r *http.Request) bool {
+	return r.URL.Path == "/health" || strings.HasSuffix(r.URL.Path, "/oauth/token")
+}
+
+// Handler returns the HTTP handler serving all routes, with CORS applied, requests beyond the
+// concurrency limit shed and requests written to the access log on stdout
+func (a *App) Handler() http.Handler {
+	handler := middleware.CORS(func() []string { return a.Config.Current().CORSAllowedOrigins })(a.Router)
+	handler = a.LoadShedder.Middleware(handler)
+	return middleware.AccessLog(os.Stdout, middleware.RouteTemplate(a.Router), a.accessLogSampling)(handler)
+}
+
+func (a *App) accessLogSampling() middleware.AccessLogSampling {
+	cfg := a.Config.Current()
+	return middleware.AccessLogSampling{Rate: cfg.AccessLogSampleRate, OAuthRate: cfg.AccessLogOAuthSampleRate}
+}
+
+// capabilityFeatures are the discovery capabilities rolled out behind a feature flag. Tenants the
+// flag is off for don't see the capability in their discovery documents.
+var capabilityFeatures = map[autodiscovery.Capability]string{
+	autodiscovery.CapabilityBackchannelAuthentication: services.FeatureCIBA,
+	autodiscovery.CapabilityDeviceAuthorization:       services.FeatureDeviceCode,
+}
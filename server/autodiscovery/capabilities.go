@@ -0,0 +1,45 @@
+package autodiscovery
+
+// Capability is an optional endpoint of the server. The discovery documents only advertise the
+// capabilities that are registered with the handler, so they never point at routes that don't
+// exist yet.
+type Capability string
+
+const (
+	CapabilityBackchannelAuthentication Capability = "backchannel_authentication"   // CIBA, poll mode
+	CapabilityRevocation                Capability = "revocation"                   // RFC 7009
+	CapabilityIntrospection             Capability = "introspection"                // RFC 7662
+	CapabilityDeviceAuthorization       Capability = "device_authorization"         // RFC 8628
+	CapabilityPushedAuthorization       Capability = "pushed_authorization_request" // RFC 9126
+)
+
+// capabilityPaths are the endpoints of the capabilities, relative to the issuer
+var capabilityPaths = map[Capability]string{
+	CapabilityBackchannelAuthentication: "/oauth/bc-authorize",
+	CapabilityRevocation:                "/oauth/revoke",
+	CapabilityIntrospection:             "/oauth/introspect",
+	CapabilityDeviceAuthorization:       "/oauth/device_authorization",
+	CapabilityPushedAuthorization:       "/oauth/par",
+}
+
+// capabilitySet holds the registered capabilities
+type capabilitySet map[Capability]bool
+
+func newCapabilitySet(capabilities []Capability) capabilitySet {
+	set := capabilitySet{}
+	for _, capability := range capabilities {
+		if _, ok := capabilityPaths[capability]; !ok {
+			panic("autodiscovery: unknown capability " + string(capability))
+		}
+		set[capability] = true
+	}
+	return set
+}
+
+// endpoint returns the capability's endpoint under the issuer, or "" when it isn't registered
+func (s capabilitySet) endpoint(issuer string, capability Capability) string {
+	if !s[capability] {
+		return ""
+	}
+	return issuer + capabilityPaths[capability]
+}
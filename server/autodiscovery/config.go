@@ -5,34 +5,50 @@ import (
 	"net/http"
 )
 
+// AuthorizationServerMetadata represents the OAuth 2.0 Authorization Server Metadata (RFC 8414).
+// The OpenID Connect Discovery document is a superset of it.
+type AuthorizationServerMetadata struct {
+	Issuer                                 string   `json:"issuer"`
+	AuthorizationEndpoint                  string   `json:"authorization_endpoint"`
+	TokenEndpoint                          string   `json:"token_endpoint"`
+	JWKSUri                                string   `json:"jwks_uri"`
+	ScopesSupported                        []string `json:"scopes_supported"`
+	ResponseTypesSupported                 []string `json:"response_types_supported"`
+	ResponseModesSupported                 []string `json:"response_modes_supported"`
+	GrantTypesSupported                    []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported      []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported          []string `json:"code_challenge_methods_supported"`
+	RevocationEndpoint                     string   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint                  string   `json:"introspection_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint            string   `json:"device_authorization_endpoint,omitempty"`
+	PushedAuthorizationRequestEndpoint     string   `json:"pushed_authorization_request_endpoint,omitempty"`
+	BackchannelAuthenticationEndpoint      string   `json:"backchannel_authentication_endpoint,omitempty"`
+	BackchannelTokenDeliveryModesSupported []string `json:"backchannel_token_delivery_modes_supported,omitempty"`
+	BackchannelUserCodeParameterSupported  bool     `json:"backchannel_user_code_parameter_supported"`
+}
+
 // OpenIDConfiguration represents the OpenID Connect Discovery metadata
 type OpenIDConfiguration struct {
-	Issuer                                    string   `json:"issuer"`
-	AuthorizationEndpoint                     string   `json:"authorization_endpoint"`
-	TokenEndpoint                            string   `json:"token_endpoint"`
-	UserinfoEndpoint                         string   `json:"userinfo_endpoint"`
-	JWKSUri                                  string   `json:"jwks_uri"`
-	ScopesSupported                          []string `json:"scopes_supported"`
-	ResponseTypesSupported                   []string `json:"response_types_supported"`
-	ResponseModesSupported                   []string `json:"response_modes_supported"`
-	GrantTypesSupported                      []string `json:"grant_types_supported"`
-	TokenEndpointAuthMethodsSupported        []string `json:"token_endpoint_auth_methods_supported"`
-	CodeChallengeMethodsSupported            []string `json:"code_challenge_methods_supported"`
-	SubjectTypesSupported                    []string `json:"subject_types_supported"`
-	IDTokenSigningAlgValuesSupported         []string `json:"id_token_signing_alg_values_supported"`
-	ClaimsSupported                          []string `json:"claims_supported"`
+	AuthorizationServerMetadata
+	UserinfoEndpoint                 string   `json:"userinfo_endpoint"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+	ACRValuesSupported               []string `json:"acr_values_supported"`
 }
 
 // ConfigBuilder builds OpenID Connect Discovery configuration
 type ConfigBuilder struct {
-	baseURL  string
-	tenantID string
+	baseURL      string
+	tenantID     string
+	capabilities capabilitySet
 }
 
 // NewConfigBuilder creates a new configuration builder
 func NewConfigBuilder(baseURL string) *ConfigBuilder {
 	return &ConfigBuilder{
-		baseURL: baseURL,
+		baseURL:      baseURL,
+		capabilities: capabilitySet{},
 	}
 }
 
@@ -42,65 +58,104 @@ func (cb *ConfigBuilder) WithTenant(tenantID string) *ConfigBuilder {
 	return cb
 }
 
-// Build creates the OpenID Connect Discovery configuration
-func (cb *ConfigBuilder) Build() *OpenIDConfiguration {
-	var issuer, authEndpoint, tokenEndpoint, userinfoEndpoint string
-	
+// WithCapabilities advertises the endpoints of the given capabilities
+func (cb *ConfigBuilder) WithCapabilities(capabilities ...Capability) *ConfigBuilder {
+	cb.capabilities = newCapabilitySet(capabilities)
+	return cb
+}
+
+// issuer returns the issuer identifier, which all endpoints are relative to
+func (cb *ConfigBuilder) issuer() string {
 	if cb.tenantID != "" {
 		// Tenant-specific endpoints
-		tenantBase := cb.baseURL + "/tenant/" + cb.tenantID
-		issuer = tenantBase
-		authEndpoint = tenantBase + "/oauth/authorize"
-		tokenEndpoint = tenantBase + "/oauth/token"
-		userinfoEndpoint = tenantBase + "/api/v1/users/me"
-	} else {
-		// Legacy endpoints
-		issuer = cb.baseURL
-		authEndpoint = cb.baseURL + "/oauth/authorize"
-		tokenEndpoint = cb.baseURL + "/oauth/token"
-		userinfoEndpoint = cb.baseURL + "/api/v1/users/me"
+		return cb.baseURL + "/tenant/" + cb.tenantID
 	}
-	
-	return &OpenIDConfiguration{
+	// Legacy endpoints
+	return cb.baseURL
+}
+
+// BuildServerMetadata creates the OAuth 2.0 Authorization Server Metadata
+func (cb *ConfigBuilder) BuildServerMetadata() *AuthorizationServerMetadata {
+	issuer := cb.issuer()
+
+	grantTypes := []string{"authorization_code", "implicit", "refresh_token"}
+	if cb.capabilities[CapabilityBackchannelAuthentication] {
+		grantTypes = append(grantTypes, "urn:openid:params:grant-type:ciba")
+	}
+	if cb.capabilities[CapabilityDeviceAuthorization] {
+		grantTypes = append(grantTypes, "urn:ietf:params:oauth:grant-type:device_code")
+	}
+
+	metadata := &AuthorizationServerMetadata{
 		Issuer:                issuer,
-		AuthorizationEndpoint: authEndpoint,
-		TokenEndpoint:         tokenEndpoint,
-		UserinfoEndpoint:      userinfoEndpoint,
-		JWKSUri:              issuer + "/.well-known/jwks.json",
+		AuthorizationEndpoint: issuer + "/oauth/authorize",
+		TokenEndpoint:         issuer + "/oauth/token",
+		JWKSUri:               issuer + "/.well-known/jwks.json",
 		ScopesSupported: []string{
 			"openid", "profile", "email", "read", "write", "admin",
 		},
 		ResponseTypesSupported: []string{
-			"code", "token", "id_token", "code token", "code id_token", 
+			"code", "token", "id_token", "code token", "code id_token",
 			"token id_token", "code token id_token",
 		},
 		ResponseModesSupported: []string{
 			"query", "fragment", "form_post",
 		},
-		GrantTypesSupported: []string{
-			"authorization_code", "implicit", "refresh_token",
-		},
+		GrantTypesSupported: grantTypes,
 		TokenEndpointAuthMethodsSupported: []string{
 			"client_secret_basic", "client_secret_post", "none",
 		},
 		CodeChallengeMethodsSupported: []string{
 			"S256", "plain",
 		},
+		RevocationEndpoint:                 cb.capabilities.endpoint(issuer, CapabilityRevocation),
+		IntrospectionEndpoint:              cb.capabilities.endpoint(issuer, CapabilityIntrospection),
+		DeviceAuthorizationEndpoint:        cb.capabilities.endpoint(issuer, CapabilityDeviceAuthorization),
+		PushedAuthorizationRequestEndpoint: cb.capabilities.endpoint(issuer, CapabilityPushedAuthorization),
+		BackchannelAuthenticationEndpoint:  cb.capabilities.endpoint(issuer, CapabilityBackchannelAuthentication),
+	}
+	if metadata.BackchannelAuthenticationEndpoint != "" {
+		metadata.BackchannelTokenDeliveryModesSupported = []string{"poll"}
+	}
+	return metadata
+}
+
+// Build creates the OpenID Connect Discovery configuration
+func (cb *ConfigBuilder) Build() *OpenIDConfiguration {
+	metadata := cb.BuildServerMetadata()
+
+	return &OpenIDConfiguration{
+		AuthorizationServerMetadata: *metadata,
+		UserinfoEndpoint:            metadata.Issuer + "/api/v1/users/me",
 		SubjectTypesSupported: []string{
 			"public",
 		},
 		IDTokenSigningAlgValuesSupported: []string{
-			"HS256", "RS256",
+			"HS256", "RS256", "ES256",
 		},
 		ClaimsSupported: []string{
-			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", 
-			"email", "email_verified", "name", "groups", "scopes", "tenant_id",
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"email", "email_verified", "name", "groups", "scopes", "tenant_id", "acr",
+			"picture", "locale", "zoneinfo",
+		},
+		// "1" is a single-factor login, "2" a login with a second factor
+		ACRValuesSupported: []string{
+			"1", "2",
 		},
 	}
 }
 
 // WriteJSON writes the configuration as JSON to the response writer
 func (config *OpenIDConfiguration) WriteJSON(w http.ResponseWriter) error {
+	return writeJSON(w, config)
+}
+
+// WriteJSON writes the metadata as JSON to the response writer
+func (metadata *AuthorizationServerMetadata) WriteJSON(w http.ResponseWriter) error {
+	return writeJSON(w, metadata)
+}
+
+func writeJSON(w http.ResponseWriter, document interface{}) error {
 	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(config)
-}
\ No newline at end of file
+	return json.NewEncoder(w).Encode(document)
+}
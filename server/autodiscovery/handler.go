@@ -2,33 +2,56 @@ package autodiscovery
 
 import (
 	"net/http"
+
+	"oauth2-openid-server/services"
 )
 
-// Handler provides HTTP handlers for OpenID Connect Discovery endpoints
-type Handler struct{}
+// Handler provides HTTP handlers for OpenID Connect Discovery and OAuth 2.0 Authorization Server
+// Metadata endpoints
+type Handler struct {
+	capabilities []Capability
+	filter       CapabilityFilter
+}
+
+// CapabilityFilter reports whether a registered capability is enabled for a tenant ("" for the
+// documents served without a tenant), so capabilities can be rolled out gradually
+type CapabilityFilter func(tenantID string, capability Capability) bool
+
+// NewHandler creates a new autodiscovery handler advertising the given optional capabilities
+func NewHandler(capabilities ...Capability) *Handler {
+	newCapabilitySet(capabilities) // fail at startup on unknown capabilities
+	return &Handler{capabilities: capabilities}
+}
+
+// WithCapabilityFilter leaves the capabilities the filter rejects for a tenant out of its documents
+func (h *Handler) WithCapabilityFilter(filter CapabilityFilter) *Handler {
+	h.filter = filter
+	return h
+}
 
-// NewHandler creates a new autodiscovery handler
-func NewHandler() *Handler {
-	return &Handler{}
+// newBuilder returns a configuration builder for the request's base URL and the capabilities
+// enabled for the tenant, so both discovery documents are generated from the same registry
+func (h *Handler) newBuilder(r *http.Request, tenantID string) *ConfigBuilder {
+	capabilities := h.capabilities
+	if h.filter != nil {
+		capabilities = nil
+		for _, capability := range h.capabilities {
+			if h.filter(tenantID, capability) {
+				capabilities = append(capabilities, capability)
+			}
+		}
+	}
+	return NewConfigBuilder(h.getBaseURL(r)).WithCapabilities(capabilities...)
 }
 
 // getBaseURL extracts the base URL from the HTTP request
 func (h *Handler) getBaseURL(r *http.Request) string {
-	scheme := "https"
-	if r.Header.Get("X-Forwarded-Proto") != "" {
-		scheme = r.Header.Get("X-Forwarded-Proto")
-	} else if r.TLS == nil {
-		scheme = "http"
-	}
-	
-	return scheme + "://" + r.Host
+	return services.RequestBaseURL(r)
 }
 
 // LegacyDiscoveryHandler handles the legacy /.well-known/openid_configuration endpoint
 func (h *Handler) LegacyDiscoveryHandler(w http.ResponseWriter, r *http.Request) {
-	baseURL := h.getBaseURL(r)
-	
-	config := NewConfigBuilder(baseURL).Build()
+	config := h.newBuilder(r, "").Build()
 	
 	if err := config.WriteJSON(w); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -39,14 +62,37 @@ func (h *Handler) LegacyDiscoveryHandler(w http.ResponseWriter, r *http.Request)
 // TenantDiscoveryHandler handles tenant-specific /.well-known/openid_configuration endpoint
 func (h *Handler) TenantDiscoveryHandler(tenantIDGetter func(*http.Request) string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		baseURL := h.getBaseURL(r)
 		tenantID := tenantIDGetter(r)
 		
-		config := NewConfigBuilder(baseURL).WithTenant(tenantID).Build()
+		config := h.newBuilder(r, tenantID).WithTenant(tenantID).Build()
 		
 		if err := config.WriteJSON(w); err != nil {
 			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 			return
 		}
 	}
-}
\ No newline at end of file
+}
+
+// LegacyServerMetadataHandler handles the legacy /.well-known/oauth-authorization-server endpoint
+func (h *Handler) LegacyServerMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	metadata := h.newBuilder(r, "").BuildServerMetadata()
+
+	if err := metadata.WriteJSON(w); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// TenantServerMetadataHandler handles the tenant-specific /.well-known/oauth-authorization-server
+// endpoint (RFC 8414)
+func (h *Handler) TenantServerMetadataHandler(tenantIDGetter func(*http.Request) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := tenantIDGetter(r)
+		metadata := h.newBuilder(r, tenantID).WithTenant(tenantID).BuildServerMetadata()
+
+		if err := metadata.WriteJSON(w); err != nil {
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+	}
+}
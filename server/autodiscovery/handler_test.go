@@ -148,4 +148,100 @@ func TestXForwardedProtoHeader(t *testing.T) {
 	if config.Issuer != expectedIssuer {
 		t.Errorf("Expected HTTPS issuer from X-Forwarded-Proto %s, got %s", expectedIssuer, config.Issuer)
 	}
-}
\ No newline at end of file
+}
+func TestServerMetadataHandlers(t *testing.T) {
+	handler := NewHandler(CapabilityBackchannelAuthentication)
+
+	req := httptest.NewRequest("GET", "https://example.com/.well-known/oauth-authorization-server", nil)
+	w := httptest.NewRecorder()
+	handler.LegacyServerMetadataHandler(w, req)
+
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if metadata["issuer"] != "https://example.com" {
+		t.Errorf("Expected issuer https://example.com, got %v", metadata["issuer"])
+	}
+	if _, ok := metadata["userinfo_endpoint"]; ok {
+		t.Error("Expected no OpenID Connect fields in the authorization server metadata")
+	}
+
+	w = httptest.NewRecorder()
+	handler.TenantServerMetadataHandler(func(*http.Request) string { return "tenant-123" })(w, req)
+	metadata = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if metadata["backchannel_authentication_endpoint"] != "https://example.com/tenant/tenant-123/oauth/bc-authorize" {
+		t.Errorf("Expected the tenant backchannel endpoint, got %v", metadata["backchannel_authentication_endpoint"])
+	}
+}
+
+func TestCapabilityRegistry(t *testing.T) {
+	baseURL := "https://example.com"
+
+	// Unregistered capabilities are left out of both documents
+	config := NewConfigBuilder(baseURL).Build()
+	if config.RevocationEndpoint != "" || config.IntrospectionEndpoint != "" || config.BackchannelAuthenticationEndpoint != "" {
+		t.Errorf("Expected no optional endpoints without capabilities, got %+v", config.AuthorizationServerMetadata)
+	}
+	if len(config.BackchannelTokenDeliveryModesSupported) != 0 {
+		t.Error("Expected no backchannel delivery modes without the backchannel capability")
+	}
+
+	builder := NewConfigBuilder(baseURL).WithTenant("t1").WithCapabilities(CapabilityRevocation, CapabilityIntrospection, CapabilityDeviceAuthorization, CapabilityPushedAuthorization)
+	metadata := builder.BuildServerMetadata()
+	expected := map[string]string{
+		"revocation_endpoint":                   baseURL + "/tenant/t1/oauth/revoke",
+		"introspection_endpoint":                baseURL + "/tenant/t1/oauth/introspect",
+		"device_authorization_endpoint":         baseURL + "/tenant/t1/oauth/device_authorization",
+		"pushed_authorization_request_endpoint": baseURL + "/tenant/t1/oauth/par",
+	}
+	got := map[string]string{
+		"revocation_endpoint":                   metadata.RevocationEndpoint,
+		"introspection_endpoint":                metadata.IntrospectionEndpoint,
+		"device_authorization_endpoint":         metadata.DeviceAuthorizationEndpoint,
+		"pushed_authorization_request_endpoint": metadata.PushedAuthorizationRequestEndpoint,
+	}
+	for field, want := range expected {
+		if got[field] != want {
+			t.Errorf("Expected %s %s, got %s", field, want, got[field])
+		}
+	}
+
+	// The OpenID document carries the same server metadata
+	if oidc := builder.Build(); oidc.AuthorizationServerMetadata.RevocationEndpoint != metadata.RevocationEndpoint {
+		t.Errorf("Expected the discovery documents to agree, got %+v and %+v", oidc.AuthorizationServerMetadata, metadata)
+	}
+}
+
+func TestCapabilityFilter(t *testing.T) {
+	handler := NewHandler(CapabilityBackchannelAuthentication, CapabilityRevocation).WithCapabilityFilter(func(tenantID string, capability Capability) bool {
+		return capability != CapabilityBackchannelAuthentication || tenantID == "pilot"
+	})
+
+	req := httptest.NewRequest("GET", "https://example.com/.well-known/openid-configuration", nil)
+	for tenantID, wantCIBA := range map[string]bool{"pilot": true, "other": false} {
+		w := httptest.NewRecorder()
+		handler.TenantDiscoveryHandler(func(*http.Request) string { return tenantID })(w, req)
+
+		var config OpenIDConfiguration
+		if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if got := config.BackchannelAuthenticationEndpoint != ""; got != wantCIBA {
+			t.Errorf("Tenant %s: expected backchannel endpoint advertised = %v, got %q", tenantID, wantCIBA, config.BackchannelAuthenticationEndpoint)
+		}
+		cibaGrant := false
+		for _, grantType := range config.GrantTypesSupported {
+			cibaGrant = cibaGrant || grantType == "urn:openid:params:grant-type:ciba"
+		}
+		if cibaGrant != wantCIBA {
+			t.Errorf("Tenant %s: expected CIBA grant advertised = %v, got %v", tenantID, wantCIBA, config.GrantTypesSupported)
+		}
+		if config.RevocationEndpoint == "" {
+			t.Errorf("Tenant %s: expected unfiltered capabilities to stay advertised", tenantID)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"oauth2-openid-server/services"
+)
+
+// build_breach_filter builds the bloom filter PASSWORD_BREACH_BLOOM_FILE points to, for checking
+// breached passwords without network access. The input lists SHA-1 hashes one per line,
+// optionally followed by ":COUNT" as in the Have I Been Pwned password downloads.
+//
+//	go run ./cmd/build_breach_filter [-fp RATE] [-min-count N] -o FILTER HASHES_FILE
+func main() {
+	output := flag.String("o", "", "File the filter is written to (required)")
+	falsePositiveRate := flag.Float64("fp", 0.001, "Share of clean passwords wrongly reported as breached")
+	minCount := flag.Int("min-count", 1, "Leave out hashes seen fewer times in breaches")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -o FILTER [flags] HASHES_FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *output == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+
+	// The filter is sized for the number of hashes, so the input is read twice
+	var entries uint64
+	if err := eachHash(input, *minCount, func(string) error {
+		entries++
+		return nil
+	}); err != nil {
+		log.Fatalf("failed to read %s: %v", input, err)
+	}
+
+	filter := services.NewBloomFilterChecker(entries, *falsePositiveRate)
+	if err := eachHash(input, *minCount, filter.AddHash); err != nil {
+		log.Fatalf("failed to read %s: %v", input, err)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("failed to create %s: %v", *output, err)
+	}
+	writer := bufio.NewWriter(file)
+	size, err := filter.WriteTo(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("failed to write %s: %v", *output, err)
+	}
+
+	fmt.Printf("Wrote %d hashes to %s (%d bytes)\n", entries, *output, size)
+}
+
+// eachHash calls add with every hash of the input seen at least minCount times
+func eachHash(path string, minCount int, add func(hash string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		hash, count, hasCount := strings.Cut(text, ":")
+		if hasCount {
+			seen, err := strconv.Atoi(count)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid count %q", line, count)
+			}
+			if seen < minCount {
+				continue
+			}
+		}
+		if err := add(hash); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
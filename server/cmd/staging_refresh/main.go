@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"oauth2-openid-server/config"
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/services"
+)
+
+// staging_refresh copies tenants from the configured (production) database into a staging
+// database for load tests, with personal data pseudonymized and secrets removed. The tenants'
+// existing staging data is replaced.
+//
+//	go run ./cmd/staging_refresh -target-uri URI [-target-db NAME] [-password PASSWORD] [-key KEY] TENANT_ID...
+func main() {
+	targetURI := flag.String("target-uri", "", "Connection string of the staging cluster (required)")
+	targetDB := flag.String("target-db", "", "Staging database name (default: DATABASE_NAME)")
+	password := flag.String("password", "", "Password every cloned user can sign in with (default: none)")
+	key := flag.String("key", "", "Key of the pseudonyms, to keep them stable across refreshes (default: random)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -target-uri URI [flags] TENANT_ID...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *targetURI == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if *targetDB == "" {
+		*targetDB = cfg.DatabaseName
+	}
+	if *targetURI == cfg.MongoURI && *targetDB == cfg.DatabaseName {
+		log.Fatal("the staging database must not be the source database")
+	}
+
+	source, err := database.NewMongoDBWithOptions(cfg.MongoURI, cfg.DatabaseName, cfg.Mongo)
+	if err != nil {
+		log.Fatalf("failed to connect to the source database: %v", err)
+	}
+	defer source.Close()
+	if err := source.ConnectRegions(cfg.MongoHomeRegion, cfg.MongoRegionURIs, cfg.Mongo); err != nil {
+		log.Fatalf("failed to connect to regional databases: %v", err)
+	}
+	// Tenants with their own database or prefix are read from there
+	if err := services.NewTenantService(source).LoadStoragePlacements(); err != nil {
+		log.Fatalf("failed to load tenant storage placements: %v", err)
+	}
+
+	target, err := database.NewMongoDB(*targetURI, *targetDB)
+	if err != nil {
+		log.Fatalf("failed to connect to the staging database: %v", err)
+	}
+	defer target.Close()
+
+	cloner, err := services.NewStagingCloneService(source, target, services.StagingCloneOptions{
+		Password: *password,
+		Key:      []byte(*key),
+	})
+	if err != nil {
+		log.Fatalf("failed to start the staging refresh: %v", err)
+	}
+
+	for _, tenantID := range flag.Args() {
+		report, err := cloner.CloneTenant(tenantID)
+		if err != nil {
+			log.Fatalf("failed to clone tenant %s: %v", tenantID, err)
+		}
+
+		fmt.Printf("Tenant %s:\n", tenantID)
+		names := make([]string, 0, len(report.Collections))
+		for name := range report.Collections {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %s: %d\n", name, report.Collections[name])
+		}
+	}
+}
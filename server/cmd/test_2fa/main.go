@@ -24,7 +24,7 @@ func main() {
 	defer db.Close()
 
 	userService := services.NewUserService(db)
-	twoFactorService := services.NewTwoFactorService(db)
+	twoFactorService := services.NewTwoFactorService(db, services.DefaultLifetimes())
 
 	// Create a test user if not exists
 	testEmail := "test@example.com"
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
-	"strconv"
+	"strings"
+
+	"oauth2-openid-server/database"
 
 	"github.com/joho/godotenv"
 )
@@ -14,6 +16,18 @@ type SocialProvider struct {
 	Enabled      bool
 }
 
+// Flow state modes
+const (
+	FlowStateStore     = "store"
+	FlowStateStateless = "stateless"
+)
+
+// Password breach modes
+const (
+	PasswordBreachOnline  = "online"
+	PasswordBreachOffline = "offline"
+)
+
 type Config struct {
 	Port           string
 	MongoURI       string
@@ -26,21 +40,126 @@ type Config struct {
 	TokenServerURL string
 	WebBaseURL     string // Frontend/web application base URL
 
+	// MongoDB client tuning: pool sizes, timeouts, read preference and write concern, plus read
+	// preferences of heavy read paths (MONGO_READ_PREFERENCE_TOKEN_VALIDATION, ..._DASHBOARD, ..._AUDIT)
+	Mongo database.ClientOptions
+
+	// Optional connection serving the dashboard, report and audit queries, e.g. the analytics
+	// nodes of the main cluster; they fall back to MONGO_URI while it is unavailable
+	MongoAnalyticsURI string
+
+	// Data residency: the region of MONGO_URI's cluster and the clusters of other regions
+	// (MONGO_REGIONS=eu,us with MONGO_URI_EU, MONGO_URI_US) tenants can be placed in
+	MongoHomeRegion string
+	MongoRegionURIs map[string]string
+
+	// Reloadable settings, applied without a restart on SIGHUP or POST /api/v1/config/reload
+	CORSAllowedOrigins []string // origins browsers may call the API from (localhost is always allowed)
+	LogLevel           string   // debug, info, warn or error
+
+	// Fractions (0 to 1) of successful requests written to the access log; failures are always
+	// written. OAuth and OpenID Connect endpoints have their own rate.
+	AccessLogSampleRate      float64
+	AccessLogOAuthSampleRate float64
+
+	// Lifetimes of login artifacts in seconds (tenants may override them within the allowed ranges)
+	AuthCodeLifetime         int // authorization codes, 30-1800 (default 600)
+	StateCookieLifetime      int // social login state cookies, 60-3600 (default 600)
+	TwoFactorSessionLifetime int // pending two-factor verifications, 60-3600 (default 600)
+
+	// SSO sessions in seconds: browsers signed in at the server renew tokens silently (prompt=none)
+	// until the session is idle this long, and at most for the lifetime
+	SSOSessionIdleTimeout int // 300-86400 (default 1800)
+	SSOSessionLifetime    int // 900-2592000 (default 43200)
+
+	// Where social login states, headless authorize flows and setup tokens are kept: "store" (the
+	// database) or "stateless" (sealed into the values the browser carries)
+	FlowStateMode string
+	FlowStateKey  string // secret sealing stateless flow state, shared by all replicas (defaults to JWTSecret)
+
+	// Cookies set by the server are signed with keys derived from the secret, a new one every
+	// rotation period; cookies signed with earlier keys stay valid until they expire
+	CookieSecret      string // shared by all replicas (defaults to JWTSecret)
+	CookieKeyRotation int    // seconds, at least 3600 (default 86400)
+
+	// Breached password lookups for tenants that reject compromised passwords: "online" asks the
+	// Have I Been Pwned range API (only the first 5 hex characters of the SHA-1 are sent) and falls
+	// back to the bloom filter file; "offline" only uses the bloom filter file
+	PasswordBreachMode      string
+	PasswordBreachAPIURL    string // range API base URL, e.g. a self-hosted mirror
+	PasswordBreachBloomFile string // filter built with cmd/build_breach_filter
+	PasswordBreachCacheTTL  int    // seconds range API answers are cached (default 86400)
+
+	// Overload protection: requests beyond the concurrency limit wait in a bounded queue and are
+	// answered with 503 after the queue timeout (a tenth of the slots is kept for the token
+	// endpoint and health checks); services stop side writes to MongoDB for a while after
+	// repeated timeouts
+	LoadShedMaxConcurrent   int // requests handled at once, 0 disables shedding (default 512)
+	LoadShedMaxQueue        int // requests waiting for a slot (default 1024)
+	LoadShedQueueTimeoutMS  int // milliseconds a request waits for a slot (default 2000)
+	MongoBreakerThreshold   int // consecutive timeouts opening a service's circuit (default 5)
+	MongoBreakerOpenSeconds int // seconds an open circuit rejects calls (default 10)
+
+	// Notifications
+	NotificationWebhookURL string // Optional webhook receiving user notifications (CIBA prompts, etc.)
+	SMTPHost               string // Optional SMTP server for email notifications
+	SMTPPort               string
+	SMTPUsername           string
+	SMTPPassword           string
+	SMTPFrom               string
+
+	// SMS (phone verification and SMS sign-in codes are logged when Twilio is not configured)
+	TwilioAccountSID string
+	TwilioAuthToken  string
+	TwilioFromNumber string
+
+	// Billing/metering exports (events are only exported when at least one is configured)
+	MeteringWebhookURL   string // Optional webhook receiving batches of metering events
+	MeteringKafkaRESTURL string // Optional Kafka REST proxy metering events are produced to
+	MeteringKafkaTopic   string
+
 	// Social login providers
 	Google   SocialProvider
 	GitHub   SocialProvider
 	Facebook SocialProvider
 	Apple    SocialProvider
+
+	// ConfigFile is the JSON file the settings were read from, if any
+	ConfigFile string
+
+	// settings holds the effective value of every setting, for telling what a reload changed
+	settings map[string]string
 }
 
+// Load reads the configuration and validates it. Settings come from the environment, then from
+// the JSON file named by CONFIG_FILE, then from defaults. A .env file in the working directory is
+// loaded into the environment first.
 func Load() (*Config, error) {
 	godotenv.Load()
+	return loadFrom(os.Getenv("CONFIG_FILE"), os.Getenv)
+}
+
+func loadFrom(path string, env func(string) string) (*Config, error) {
+	src, err := newSource(path, env)
+	if err != nil {
+		return nil, err
+	}
+	config := load(src)
+	if err := problemsError(append(src.allProblems(), config.problems()...)); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func load(src *source) *Config {
+	getEnv := src.get
+	getEnvAsInt := src.getInt
 
 	config := &Config{
 		Port:           getEnv("PORT", "8080"),
 		MongoURI:       getEnv("MONGO_URI", "mongodb://localhost:27017"),
 		DatabaseName:   getEnv("DATABASE_NAME", "oauth2_server"),
-		JWTSecret:      getEnv("JWT_SECRET", "your-secret-key"),
+		JWTSecret:      getEnv("JWT_SECRET", ""),
 		ClientID:       getEnv("CLIENT_ID", "oauth2-client"),
 		ClientSecret:   getEnv("CLIENT_SECRET", "oauth2-secret"),
 		RedirectURL:    getEnv("REDIRECT_URL", "https://oauth2.imsc.eu/callback"),
@@ -48,6 +167,62 @@ func Load() (*Config, error) {
 		TokenServerURL: getEnv("TOKEN_SERVER_URL", "https://oauth2.imsc.eu/oauth/token"),
 		WebBaseURL:     getEnv("WEB_BASE_URL", "https://authy.imsc.eu"),
 
+		Mongo: database.ClientOptions{
+			MaxPoolSize:            getEnvAsInt("MONGO_MAX_POOL_SIZE", 0),
+			MinPoolSize:            getEnvAsInt("MONGO_MIN_POOL_SIZE", 0),
+			MaxConnIdleTime:        src.getMilliseconds("MONGO_MAX_CONN_IDLE_TIME_MS"),
+			ConnectTimeout:         src.getMilliseconds("MONGO_CONNECT_TIMEOUT_MS"),
+			ServerSelectionTimeout: src.getMilliseconds("MONGO_SERVER_SELECTION_TIMEOUT_MS"),
+			SocketTimeout:          src.getMilliseconds("MONGO_SOCKET_TIMEOUT_MS"),
+			ReadPreference:         getEnv("MONGO_READ_PREFERENCE", ""),
+			WriteConcern:           getEnv("MONGO_WRITE_CONCERN", ""),
+			ReadPathPreferences:    map[database.ReadPath]string{},
+		},
+
+		CORSAllowedOrigins: src.getList("CORS_ALLOWED_ORIGINS", defaultCORSAllowedOrigins),
+		LogLevel:           getEnv("LOG_LEVEL", "info"),
+
+		AccessLogSampleRate:      src.getFloat("ACCESS_LOG_SAMPLE_RATE", 1),
+		AccessLogOAuthSampleRate: src.getFloat("ACCESS_LOG_OAUTH_SAMPLE_RATE", 1),
+
+		AuthCodeLifetime:         getEnvAsInt("AUTH_CODE_LIFETIME", 600),
+		StateCookieLifetime:      getEnvAsInt("STATE_COOKIE_LIFETIME", 600),
+		TwoFactorSessionLifetime: getEnvAsInt("TWO_FACTOR_SESSION_LIFETIME", 600),
+		SSOSessionIdleTimeout:    getEnvAsInt("SSO_SESSION_IDLE_TIMEOUT", 1800),
+		SSOSessionLifetime:       getEnvAsInt("SSO_SESSION_LIFETIME", 43200),
+
+		FlowStateMode: getEnv("FLOW_STATE_MODE", FlowStateStore),
+		FlowStateKey:  getEnv("FLOW_STATE_KEY", ""),
+
+		CookieSecret:      getEnv("COOKIE_SECRET", ""),
+		CookieKeyRotation: getEnvAsInt("COOKIE_KEY_ROTATION", 86400),
+
+		PasswordBreachMode:      getEnv("PASSWORD_BREACH_MODE", PasswordBreachOnline),
+		PasswordBreachAPIURL:    getEnv("PASSWORD_BREACH_API_URL", "https://api.pwnedpasswords.com"),
+		PasswordBreachBloomFile: getEnv("PASSWORD_BREACH_BLOOM_FILE", ""),
+		PasswordBreachCacheTTL:  getEnvAsInt("PASSWORD_BREACH_CACHE_TTL", 86400),
+
+		LoadShedMaxConcurrent:   getEnvAsInt("LOAD_SHED_MAX_CONCURRENT", 512),
+		LoadShedMaxQueue:        getEnvAsInt("LOAD_SHED_MAX_QUEUE", 1024),
+		LoadShedQueueTimeoutMS:  getEnvAsInt("LOAD_SHED_QUEUE_TIMEOUT_MS", 2000),
+		MongoBreakerThreshold:   getEnvAsInt("MONGO_BREAKER_THRESHOLD", 5),
+		MongoBreakerOpenSeconds: getEnvAsInt("MONGO_BREAKER_OPEN_SECONDS", 10),
+
+		NotificationWebhookURL: getEnv("NOTIFICATION_WEBHOOK_URL", ""),
+		SMTPHost:               getEnv("SMTP_HOST", ""),
+		SMTPPort:               getEnv("SMTP_PORT", "587"),
+		SMTPUsername:           getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:           getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:               getEnv("SMTP_FROM", "no-reply@imsc.eu"),
+
+		TwilioAccountSID: getEnv("TWILIO_ACCOUNT_SID", ""),
+		TwilioAuthToken:  getEnv("TWILIO_AUTH_TOKEN", ""),
+		TwilioFromNumber: getEnv("TWILIO_FROM_NUMBER", ""),
+
+		MeteringWebhookURL:   getEnv("METERING_WEBHOOK_URL", ""),
+		MeteringKafkaRESTURL: getEnv("METERING_KAFKA_REST_URL", ""),
+		MeteringKafkaTopic:   getEnv("METERING_KAFKA_TOPIC", "ims-authy-metering"),
+
 		// Social login providers configuration
 		Google: SocialProvider{
 			ClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
@@ -75,21 +250,36 @@ func Load() (*Config, error) {
 		},
 	}
 
-	return config, nil
-}
+	for _, path := range database.ReadPaths {
+		config.Mongo.ReadPathPreferences[path] = getEnv(readPathSetting(path), "")
+	}
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	config.MongoAnalyticsURI = getEnv("MONGO_ANALYTICS_URI", "")
+	config.MongoHomeRegion = getEnv("MONGO_HOME_REGION", "")
+	config.MongoRegionURIs = map[string]string{}
+	for _, region := range src.getList("MONGO_REGIONS", nil) {
+		config.MongoRegionURIs[region] = getEnv(regionURISetting(region), "")
 	}
-	return defaultValue
-}
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
+	if config.CookieSecret == "" {
+		config.CookieSecret = config.JWTSecret
 	}
-	return defaultValue
+	if config.FlowStateKey == "" {
+		config.FlowStateKey = config.JWTSecret
+	}
+	config.ConfigFile = src.path
+	config.settings = src.values
+
+	return config
+}
+
+// regionURISetting names the setting of a region's connection string, e.g. MONGO_URI_EU
+func regionURISetting(region string) string {
+	return "MONGO_URI_" + strings.ToUpper(strings.ReplaceAll(region, "-", "_"))
+}
+
+// readPathSetting names the setting of a read path's read preference, e.g.
+// MONGO_READ_PREFERENCE_DASHBOARD
+func readPathSetting(path database.ReadPath) string {
+	return "MONGO_READ_PREFERENCE_" + strings.ToUpper(string(path))
 }
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func envOf(values map[string]string) func(string) string {
+	return func(key string) string { return values[key] }
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadValidation(t *testing.T) {
+	cfg, err := loadFrom("", envOf(map[string]string{"JWT_SECRET": testSecret}))
+	if err != nil {
+		t.Fatalf("loadFrom() with defaults error = %v", err)
+	}
+	if cfg.LogLevel != "info" || len(cfg.CORSAllowedOrigins) != len(defaultCORSAllowedOrigins) {
+		t.Errorf("defaults = %q, %v", cfg.LogLevel, cfg.CORSAllowedOrigins)
+	}
+
+	_, err = loadFrom("", envOf(map[string]string{
+		"JWT_SECRET":              "short",
+		"MONGO_URI":               "localhost:27017",
+		"WEB_BASE_URL":            "authy.example.com",
+		"PORT":                    "http",
+		"AUTH_CODE_LIFETIME":      "ten minutes",
+		"FLOW_STATE_MODE":         "cookie",
+		"LOG_LEVEL":               "verbose",
+		"ACCESS_LOG_SAMPLE_RATE":  "2",
+		"PASSWORD_BREACH_MODE":    "offline",
+		"COOKIE_KEY_ROTATION":     "60",
+		"MONGO_BREAKER_THRESHOLD": "0",
+	}))
+	if err == nil {
+		t.Fatal("loadFrom() accepted an invalid configuration")
+	}
+	for _, setting := range []string{"JWT_SECRET", "MONGO_URI", "WEB_BASE_URL", "PORT", "AUTH_CODE_LIFETIME", "FLOW_STATE_MODE", "LOG_LEVEL", "ACCESS_LOG_SAMPLE_RATE", "PASSWORD_BREACH_BLOOM_FILE", "COOKIE_KEY_ROTATION", "MONGO_BREAKER_THRESHOLD"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("error doesn't describe %s: %v", setting, err)
+		}
+	}
+
+	if _, err := loadFrom("", envOf(nil)); err == nil || !strings.Contains(err.Error(), "JWT_SECRET is required") {
+		t.Errorf("loadFrom() without JWT_SECRET error = %v", err)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"JWT_SECRET": "`+testSecret+`",
+		"PORT": 9090,
+		"LOG_LEVEL": "debug",
+		"CORS_ALLOWED_ORIGINS": ["https://app.example.com", "https://admin.example.com"]
+	}`)
+
+	// The environment overrides the file
+	cfg, err := loadFrom(path, envOf(map[string]string{"LOG_LEVEL": "warn"}))
+	if err != nil {
+		t.Fatalf("loadFrom() error = %v", err)
+	}
+	if cfg.Port != "9090" || cfg.LogLevel != "warn" || cfg.ConfigFile != path {
+		t.Errorf("config = port %q, log level %q, file %q", cfg.Port, cfg.LogLevel, cfg.ConfigFile)
+	}
+	if want := []string{"https://app.example.com", "https://admin.example.com"}; !reflect.DeepEqual(cfg.CORSAllowedOrigins, want) {
+		t.Errorf("CORSAllowedOrigins = %v, want %v", cfg.CORSAllowedOrigins, want)
+	}
+
+	path = writeConfigFile(t, `{"JWT_SECRET": "`+testSecret+`", "LOG_LEVL": "debug"}`)
+	if _, err := loadFrom(path, envOf(nil)); err == nil || !strings.Contains(err.Error(), "LOG_LEVL") {
+		t.Errorf("loadFrom() with an unknown setting error = %v", err)
+	}
+}
+
+func TestLoadMongoOptions(t *testing.T) {
+	cfg, err := loadFrom("", envOf(map[string]string{
+		"JWT_SECRET":                        testSecret,
+		"MONGO_MAX_POOL_SIZE":               "200",
+		"MONGO_MIN_POOL_SIZE":               "10",
+		"MONGO_SERVER_SELECTION_TIMEOUT_MS": "2500",
+		"MONGO_WRITE_CONCERN":               "majority",
+		"MONGO_READ_PREFERENCE_DASHBOARD":   "secondaryPreferred",
+		"MONGO_ANALYTICS_URI":               "mongodb://analytics-1/?readPreference=secondary",
+	}))
+	if err != nil {
+		t.Fatalf("loadFrom() error = %v", err)
+	}
+	if cfg.Mongo.MaxPoolSize != 200 || cfg.Mongo.MinPoolSize != 10 || cfg.Mongo.ServerSelectionTimeout != 2500*time.Millisecond {
+		t.Errorf("Mongo = %+v", cfg.Mongo)
+	}
+	if got := cfg.Mongo.ReadPathPreferences[database.ReadPathDashboard]; got != "secondaryPreferred" {
+		t.Errorf("dashboard read preference = %q, want secondaryPreferred", got)
+	}
+	if got := cfg.Mongo.ReadPathPreferences[database.ReadPathTokenValidation]; got != "" {
+		t.Errorf("token validation read preference = %q, want the client's", got)
+	}
+	if cfg.MongoAnalyticsURI != "mongodb://analytics-1/?readPreference=secondary" {
+		t.Errorf("MongoAnalyticsURI = %q", cfg.MongoAnalyticsURI)
+	}
+
+	_, err = loadFrom("", envOf(map[string]string{
+		"JWT_SECRET":                             testSecret,
+		"MONGO_MAX_POOL_SIZE":                    "5",
+		"MONGO_MIN_POOL_SIZE":                    "10",
+		"MONGO_SOCKET_TIMEOUT_MS":                "-1",
+		"MONGO_READ_PREFERENCE":                  "fastest",
+		"MONGO_READ_PREFERENCE_TOKEN_VALIDATION": "anywhere",
+		"MONGO_WRITE_CONCERN":                    "0",
+		"MONGO_ANALYTICS_URI":                    "postgres://analytics",
+	}))
+	if err == nil {
+		t.Fatal("loadFrom() accepted invalid MongoDB options")
+	}
+	for _, setting := range []string{"MONGO_MIN_POOL_SIZE", "MONGO_SOCKET_TIMEOUT_MS", "MONGO_READ_PREFERENCE:", "MONGO_READ_PREFERENCE_TOKEN_VALIDATION", "MONGO_WRITE_CONCERN", "MONGO_ANALYTICS_URI"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("error doesn't describe %s: %v", setting, err)
+		}
+	}
+}
+
+func TestLoadMongoRegions(t *testing.T) {
+	cfg, err := loadFrom("", envOf(map[string]string{
+		"JWT_SECRET":         testSecret,
+		"MONGO_HOME_REGION":  "us",
+		"MONGO_REGIONS":      "eu, ap-south",
+		"MONGO_URI_EU":       "mongodb://eu-1,eu-2/?replicaSet=eu",
+		"MONGO_URI_AP_SOUTH": "mongodb+srv://ap.example.com",
+	}))
+	if err != nil {
+		t.Fatalf("loadFrom() error = %v", err)
+	}
+	if cfg.MongoHomeRegion != "us" || len(cfg.MongoRegionURIs) != 2 || cfg.MongoRegionURIs["eu"] != "mongodb://eu-1,eu-2/?replicaSet=eu" ||
+		cfg.MongoRegionURIs["ap-south"] != "mongodb+srv://ap.example.com" {
+		t.Errorf("regions = %q %v", cfg.MongoHomeRegion, cfg.MongoRegionURIs)
+	}
+
+	_, err = loadFrom("", envOf(map[string]string{
+		"JWT_SECRET":        testSecret,
+		"MONGO_HOME_REGION": "eu",
+		"MONGO_REGIONS":     "eu,us,EU-West",
+	}))
+	if err == nil {
+		t.Fatal("loadFrom() accepted invalid regions")
+	}
+	for _, problem := range []string{"home region eu", "MONGO_URI_US", `"EU-West"`} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("error doesn't describe %s: %v", problem, err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writeConfigFile(t, `{"JWT_SECRET": "`+testSecret+`", "LOG_LEVEL": "info"}`)
+	load := func() (*Config, error) { return loadFrom(path, envOf(nil)) }
+
+	cfg, err := load()
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	reloader := NewReloader(cfg)
+	reloader.load = load
+	var notified *Config
+	reloader.OnReload(func(cfg *Config) { notified = cfg })
+
+	// Reloadable settings are applied, others are kept until a restart
+	if err := os.WriteFile(path, []byte(`{"JWT_SECRET": "`+testSecret+`x", "LOG_LEVEL": "debug", "CORS_ALLOWED_ORIGINS": "https://app.example.com"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := reloader.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if want := []string{"CORS_ALLOWED_ORIGINS", "LOG_LEVEL"}; !reflect.DeepEqual(result.Applied, want) {
+		t.Errorf("Applied = %v, want %v", result.Applied, want)
+	}
+	if want := []string{"JWT_SECRET"}; !reflect.DeepEqual(result.RestartRequired, want) {
+		t.Errorf("RestartRequired = %v, want %v", result.RestartRequired, want)
+	}
+	current := reloader.Current()
+	if current.LogLevel != "debug" || current.JWTSecret != testSecret || notified != current {
+		t.Errorf("after reload: log level %q, secret changed %v, listener notified %v", current.LogLevel, current.JWTSecret != testSecret, notified == current)
+	}
+
+	// An invalid configuration is rejected and the current one kept
+	if err := os.WriteFile(path, []byte(`{"JWT_SECRET": "`+testSecret+`", "LOG_LEVEL": "loud"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("Reload() accepted an invalid configuration")
+	}
+	if reloader.Current() != current {
+		t.Error("a failed reload replaced the configuration")
+	}
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// reloadableSettings can change while the server runs. Everything else is read once at startup
+// and needs a restart.
+var reloadableSettings = map[string]bool{
+	"CORS_ALLOWED_ORIGINS":         true,
+	"LOG_LEVEL":                    true,
+	"ACCESS_LOG_SAMPLE_RATE":       true,
+	"ACCESS_LOG_OAUTH_SAMPLE_RATE": true,
+}
+
+// ReloadResult tells which settings a reload applied and which changed but need a restart
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Reloader holds the current configuration and re-reads it on request. A reload that fails
+// validation keeps the current configuration.
+type Reloader struct {
+	mu        sync.RWMutex
+	current   *Config
+	load      func() (*Config, error)
+	listeners []func(*Config)
+}
+
+// NewReloader starts from cfg and reloads with Load
+func NewReloader(cfg *Config) *Reloader {
+	return &Reloader{current: cfg, load: Load}
+}
+
+// Current returns the configuration in effect
+func (r *Reloader) Current() *Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// OnReload registers a function called with the new configuration after every applied reload
+func (r *Reloader) OnReload(listener func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, listener)
+}
+
+// Reload reads the configuration again and applies the reloadable settings
+func (r *Reloader) Reload() (*ReloadResult, error) {
+	next, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	current := r.current
+	result := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	for _, key := range changedSettings(current.settings, next.settings) {
+		if reloadableSettings[key] {
+			result.Applied = append(result.Applied, key)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+
+	updated := *current
+	updated.CORSAllowedOrigins = next.CORSAllowedOrigins
+	updated.LogLevel = next.LogLevel
+	updated.AccessLogSampleRate = next.AccessLogSampleRate
+	updated.AccessLogOAuthSampleRate = next.AccessLogOAuthSampleRate
+	updated.settings = map[string]string{}
+	for key, value := range current.settings {
+		updated.settings[key] = value
+	}
+	for _, key := range result.Applied {
+		updated.settings[key] = next.settings[key]
+	}
+	r.current = &updated
+	listeners := append([]func(*Config){}, r.listeners...)
+	r.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(&updated)
+	}
+	return result, nil
+}
+
+// ReloadOnSignal reloads the configuration whenever the process receives SIGHUP. The returned
+// function stops listening.
+func (r *Reloader) ReloadOnSignal() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				result, err := r.Reload()
+				if err != nil {
+					log.Printf("Warning: Configuration reload failed, keeping the current configuration: %v", err)
+					continue
+				}
+				logReload(result)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func logReload(result *ReloadResult) {
+	log.Printf("Configuration reloaded, applied: %v", result.Applied)
+	if len(result.RestartRequired) > 0 {
+		log.Printf("Warning: Changed settings that need a restart to take effect: %v", result.RestartRequired)
+	}
+}
+
+// changedSettings returns the names of the settings whose values differ, sorted
+func changedSettings(current, next map[string]string) []string {
+	var changed []string
+	for key, value := range next {
+		if current[key] != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range current {
+		if _, ok := next[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
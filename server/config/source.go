@@ -0,0 +1,164 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSAllowedOrigins are the origins allowed when CORS_ALLOWED_ORIGINS isn't set
+var defaultCORSAllowedOrigins = []string{
+	"https://authy.imsc.eu",
+	"https://oauth2.imsc.eu",
+	"http://localhost:5173",
+	"http://localhost:3000",
+	"http://localhost:8080",
+}
+
+// source looks settings up in the environment, then in the config file, then falls back to the
+// default. It remembers the effective values and the problems found while reading them.
+type source struct {
+	path     string
+	env      func(string) string
+	file     map[string]string
+	values   map[string]string
+	problems []string
+}
+
+// newSource reads the config file at path, if any. The file is a JSON object keyed by the
+// setting names, e.g. {"LOG_LEVEL": "debug", "CORS_ALLOWED_ORIGINS": ["https://app.example.com"]}.
+func newSource(path string, env func(string) string) (*source, error) {
+	src := &source{path: path, env: env, file: map[string]string{}, values: map[string]string{}}
+	if path == "" {
+		return src, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			src.file[key] = v
+		case json.Number:
+			src.file[key] = v.String()
+		case bool:
+			src.file[key] = strconv.FormatBool(v)
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				text, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("config file %s: %s must be a list of strings", path, key)
+				}
+				items = append(items, text)
+			}
+			src.file[key] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("config file %s: %s must be a string, number, boolean or list of strings", path, key)
+		}
+	}
+	return src, nil
+}
+
+// get returns the setting's value, or the default when it isn't set
+func (s *source) get(key, defaultValue string) string {
+	value := s.env(key)
+	if value == "" {
+		value = s.file[key]
+	}
+	if value == "" {
+		value = defaultValue
+	}
+	s.values[key] = value
+	return value
+}
+
+// getInt returns the setting as a whole number. Values that aren't one are reported.
+func (s *source) getInt(key string, defaultValue int) int {
+	value := s.get(key, "")
+	if value == "" {
+		s.values[key] = strconv.Itoa(defaultValue)
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		s.problems = append(s.problems, fmt.Sprintf("%s must be a whole number, got %q", key, value))
+		return defaultValue
+	}
+	return intValue
+}
+
+// getFloat returns the setting as a number. Values that aren't one are reported.
+func (s *source) getFloat(key string, defaultValue float64) float64 {
+	value := s.get(key, strconv.FormatFloat(defaultValue, 'f', -1, 64))
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		s.problems = append(s.problems, fmt.Sprintf("%s must be a number, got %q", key, value))
+		return defaultValue
+	}
+	return floatValue
+}
+
+// getMilliseconds returns a duration given in milliseconds; 0 keeps the driver's default
+func (s *source) getMilliseconds(key string) time.Duration {
+	return time.Duration(s.getInt(key, 0)) * time.Millisecond
+}
+
+// getList returns a comma separated setting as a list
+func (s *source) getList(key string, defaultValue []string) []string {
+	value := s.get(key, strings.Join(defaultValue, ","))
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// allProblems lists unparsable values and config file entries that aren't settings, which are
+// usually typos
+func (s *source) allProblems() []string {
+	problems := append([]string(nil), s.problems...)
+	var unknown []string
+	for key := range s.file {
+		if _, ok := s.values[key]; !ok && key != "CONFIG_FILE" {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("%s in %s is not a known setting", key, s.path))
+	}
+	return problems
+}
+
+// ValidationError lists everything wrong with a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func problemsError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
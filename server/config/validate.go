@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/logging"
+)
+
+// minSecretLength is the shortest accepted JWT and flow state secret (256 bits for HS256)
+const minSecretLength = 32
+
+// Validate checks that the required settings are present and well-formed, describing every
+// problem at once so a broken deployment can be fixed in one go
+func (c *Config) Validate() error {
+	return problemsError(c.problems())
+}
+
+func (c *Config) problems() []string {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problem("PORT must be a port number between 1 and 65535, got %q", c.Port)
+	}
+
+	if c.MongoURI == "" {
+		problem("MONGO_URI is required")
+	} else if u, err := url.Parse(c.MongoURI); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+		problem("MONGO_URI must be a mongodb:// or mongodb+srv:// connection string")
+	}
+	if c.MongoAnalyticsURI != "" {
+		if u, err := url.Parse(c.MongoAnalyticsURI); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+			problem("MONGO_ANALYTICS_URI must be a mongodb:// or mongodb+srv:// connection string")
+		}
+	}
+	if c.DatabaseName == "" {
+		problem("DATABASE_NAME is required")
+	}
+	problems = append(problems, mongoProblems(c.Mongo)...)
+	problems = append(problems, regionProblems(c.MongoHomeRegion, c.MongoRegionURIs)...)
+
+	if c.JWTSecret == "" {
+		problem("JWT_SECRET is required (at least %d characters)", minSecretLength)
+	} else if len(c.JWTSecret) < minSecretLength {
+		problem("JWT_SECRET must be at least %d characters, got %d", minSecretLength, len(c.JWTSecret))
+	}
+
+	if !isAbsoluteHTTPURL(c.WebBaseURL) {
+		problem("WEB_BASE_URL must be an absolute http(s) URL, got %q", c.WebBaseURL)
+	}
+
+	switch c.FlowStateMode {
+	case FlowStateStore:
+	case FlowStateStateless:
+		if len(c.FlowStateKey) < minSecretLength {
+			problem("FLOW_STATE_KEY must be at least %d characters in stateless mode", minSecretLength)
+		}
+	default:
+		problem("FLOW_STATE_MODE must be %q or %q, got %q", FlowStateStore, FlowStateStateless, c.FlowStateMode)
+	}
+
+	if c.CookieSecret != c.JWTSecret && len(c.CookieSecret) < minSecretLength {
+		problem("COOKIE_SECRET must be at least %d characters", minSecretLength)
+	}
+	if c.CookieKeyRotation < 3600 {
+		problem("COOKIE_KEY_ROTATION must be at least 3600 seconds, got %d", c.CookieKeyRotation)
+	}
+
+	switch c.PasswordBreachMode {
+	case PasswordBreachOnline:
+		if !isAbsoluteHTTPURL(c.PasswordBreachAPIURL) {
+			problem("PASSWORD_BREACH_API_URL must be an absolute http(s) URL, got %q", c.PasswordBreachAPIURL)
+		}
+	case PasswordBreachOffline:
+		if c.PasswordBreachBloomFile == "" {
+			problem("PASSWORD_BREACH_BLOOM_FILE is required in offline mode")
+		}
+	default:
+		problem("PASSWORD_BREACH_MODE must be %q or %q, got %q", PasswordBreachOnline, PasswordBreachOffline, c.PasswordBreachMode)
+	}
+	if c.PasswordBreachCacheTTL < 0 {
+		problem("PASSWORD_BREACH_CACHE_TTL must not be negative")
+	}
+
+	if c.LoadShedMaxConcurrent > 0 {
+		if c.LoadShedMaxQueue < 0 {
+			problem("LOAD_SHED_MAX_QUEUE must not be negative")
+		}
+		if c.LoadShedQueueTimeoutMS < 1 {
+			problem("LOAD_SHED_QUEUE_TIMEOUT_MS must be at least 1, got %d", c.LoadShedQueueTimeoutMS)
+		}
+	}
+	if c.MongoBreakerThreshold < 1 {
+		problem("MONGO_BREAKER_THRESHOLD must be at least 1, got %d", c.MongoBreakerThreshold)
+	}
+	if c.MongoBreakerOpenSeconds < 1 {
+		problem("MONGO_BREAKER_OPEN_SECONDS must be at least 1, got %d", c.MongoBreakerOpenSeconds)
+	}
+
+	for _, origin := range c.CORSAllowedOrigins {
+		if !isAbsoluteHTTPURL(origin) {
+			problem("CORS_ALLOWED_ORIGINS entry %q must be an absolute http(s) origin", origin)
+		}
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		problem("LOG_LEVEL: %v", err)
+	}
+	if c.AccessLogSampleRate < 0 || c.AccessLogSampleRate > 1 {
+		problem("ACCESS_LOG_SAMPLE_RATE must be between 0 and 1, got %v", c.AccessLogSampleRate)
+	}
+	if c.AccessLogOAuthSampleRate < 0 || c.AccessLogOAuthSampleRate > 1 {
+		problem("ACCESS_LOG_OAUTH_SAMPLE_RATE must be between 0 and 1, got %v", c.AccessLogOAuthSampleRate)
+	}
+
+	return problems
+}
+
+// mongoProblems checks the MongoDB client tuning
+func mongoProblems(opts database.ClientOptions) []string {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if opts.MaxPoolSize < 0 || opts.MinPoolSize < 0 {
+		problem("MONGO_MAX_POOL_SIZE and MONGO_MIN_POOL_SIZE must not be negative")
+	} else if opts.MaxPoolSize > 0 && opts.MinPoolSize > opts.MaxPoolSize {
+		problem("MONGO_MIN_POOL_SIZE (%d) must not exceed MONGO_MAX_POOL_SIZE (%d)", opts.MinPoolSize, opts.MaxPoolSize)
+	}
+	timeouts := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"MONGO_MAX_CONN_IDLE_TIME_MS", opts.MaxConnIdleTime},
+		{"MONGO_CONNECT_TIMEOUT_MS", opts.ConnectTimeout},
+		{"MONGO_SERVER_SELECTION_TIMEOUT_MS", opts.ServerSelectionTimeout},
+		{"MONGO_SOCKET_TIMEOUT_MS", opts.SocketTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			problem("%s must not be negative", timeout.key)
+		}
+	}
+
+	if opts.ReadPreference != "" {
+		if _, err := database.ParseReadPreference(opts.ReadPreference); err != nil {
+			problem("MONGO_READ_PREFERENCE: %v", err)
+		}
+	}
+	for _, path := range database.ReadPaths {
+		if mode := opts.ReadPathPreferences[path]; mode != "" {
+			if _, err := database.ParseReadPreference(mode); err != nil {
+				problem("%s: %v", readPathSetting(path), err)
+			}
+		}
+	}
+	if opts.WriteConcern != "" {
+		if _, err := database.ParseWriteConcern(opts.WriteConcern); err != nil {
+			problem("MONGO_WRITE_CONCERN: %v", err)
+		}
+	}
+	return problems
+}
+
+// regionProblems checks the data residency regions and their clusters
+func regionProblems(home string, uris map[string]string) []string {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if home != "" && !database.ValidRegion(home) {
+		problem("MONGO_HOME_REGION must be lowercase letters, digits and hyphens (at most 32), got %q", home)
+	}
+	for region, uri := range uris {
+		if !database.ValidRegion(region) {
+			problem("MONGO_REGIONS entry %q must be lowercase letters, digits and hyphens (at most 32)", region)
+			continue
+		}
+		if region == home {
+			problem("MONGO_REGIONS must not list the home region %s, which is served by MONGO_URI", region)
+			continue
+		}
+		if u, err := url.Parse(uri); uri == "" || err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+			problem("%s must be a mongodb:// or mongodb+srv:// connection string", regionURISetting(region))
+		}
+	}
+	return problems
+}
+
+func isAbsoluteHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
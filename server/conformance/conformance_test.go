@@ -0,0 +1,437 @@
+// Package conformance exercises the OAuth 2.0 / OpenID Connect endpoints through the real router
+// and asserts spec-level behaviour: exact error codes, token formats and claim presence. The
+// tests need a MongoDB server (see database/dbtest) and are skipped without TEST_MONGO_URI.
+package conformance
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/app"
+	"oauth2-openid-server/config"
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	redirectURI  = "https://rp.example.com/callback"
+	userEmail    = "jane@example.com"
+	userPassword = "correct horse battery staple"
+	clientSecret = "rp-secret"
+)
+
+// testServer is the real router on a fresh database with a default and a second tenant, each
+// with a user, a public client and a confidential client
+type testServer struct {
+	*httptest.Server
+	tenants []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	db := dbtest.New(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(userPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	server := &testServer{}
+	for i, name := range []string{"Default", "Acme"} {
+		tenantID := primitive.NewObjectID()
+		server.tenants = append(server.tenants, tenantID.Hex())
+
+		dbtest.Insert(t, db, "tenants", &models.Tenant{ID: tenantID, Name: name, Active: true, IsDefault: i == 0, CreatedAt: now, UpdatedAt: now})
+		dbtest.Insert(t, db, "users", &models.User{ID: primitive.NewObjectID(), TenantID: tenantID.Hex(), Email: userEmail, PasswordHash: string(hash),
+			Scopes: []string{"openid", "profile", "email", "read"}, Active: true, CreatedAt: now, UpdatedAt: now})
+		dbtest.Insert(t, db, "clients",
+			&models.Client{ID: primitive.NewObjectID(), TenantID: tenantID.Hex(), ClientID: "public-" + tenantID.Hex(), Name: "Public RP",
+				RedirectURIs: []string{redirectURI}, Scopes: []string{"openid", "profile", "email", "read"}, Active: true, CreatedAt: now, UpdatedAt: now},
+			&models.Client{ID: primitive.NewObjectID(), TenantID: tenantID.Hex(), ClientID: "confidential-" + tenantID.Hex(), ClientSecret: clientSecret,
+				Name: "Confidential RP", RedirectURIs: []string{redirectURI}, Scopes: []string{"openid", "read"}, Active: true, CreatedAt: now, UpdatedAt: now})
+	}
+
+	application, err := app.New(&config.Config{JWTSecret: "conformance-secret", WebBaseURL: "https://app.example.com"}, db)
+	if err != nil {
+		t.Fatalf("Failed to build the server: %v", err)
+	}
+
+	server.Server = httptest.NewServer(application.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// noRedirects returns a client that reports redirects instead of following them
+func noRedirects() *http.Client {
+	return &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+}
+
+func decodeJSON(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Expected a JSON body from %s: %v", resp.Request.URL.Path, err)
+	}
+}
+
+func postJSON(t *testing.T, url, csrf string, body interface{}) map[string]interface{} {
+	t.Helper()
+	payload, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(string(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	if csrf != "" {
+		req.Header.Set("X-CSRF-Token", csrf)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s returned %d", url, resp.StatusCode)
+	}
+	var result map[string]interface{}
+	decodeJSON(t, resp, &result)
+	return result
+}
+
+func postForm(t *testing.T, endpoint string, form url.Values, username, password string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if username != "" {
+		req.SetBasicAuth(url.QueryEscape(username), url.QueryEscape(password))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// expectOAuthError asserts an RFC 6749 section 5.2 error response
+func expectOAuthError(t *testing.T, resp *http.Response, status int, code string) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Errorf("Expected status %d for %s, got %d", status, code, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Expected a JSON error for %s, got %q", code, ct)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	decodeJSON(t, resp, &body)
+	if body.Error != code {
+		t.Errorf("Expected error %q, got %q", code, body.Error)
+	}
+}
+
+func discovery(t *testing.T, server *testServer, tenantID string) map[string]interface{} {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/.well-known/" + tenantID + "/openid_configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Discovery returned %d", resp.StatusCode)
+	}
+	var doc map[string]interface{}
+	decodeJSON(t, resp, &doc)
+	return doc
+}
+
+func pkcePair() (verifier, challenge string) {
+	verifier = "conformance-code-verifier-0123456789-abcdefghijklmnopqrstuvwxyz"
+	hash := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// authorize runs the headless authorization flow and returns the code from the redirect
+func authorize(t *testing.T, server *testServer, tenantID, clientID, challenge string) string {
+	t.Helper()
+	base := server.URL + "/tenant/" + tenantID + "/api/v1/authorize/flows"
+
+	flow := postJSON(t, base, "", map[string]string{
+		"client_id": clientID, "redirect_uri": redirectURI, "response_type": "code", "scope": "openid profile email",
+		"state": "xyz", "code_challenge": challenge, "code_challenge_method": "S256",
+	})
+	flowID, _ := flow["flow_id"].(string)
+	flow = postJSON(t, base+"/"+flowID+"/credentials", flow["csrf_token"].(string), map[string]string{"email": userEmail, "password": userPassword})
+	if flow["step"] == "consent" {
+		flow = postJSON(t, base+"/"+flowID+"/consent", flow["csrf_token"].(string), map[string]interface{}{"approve": true})
+	}
+
+	redirect, err := url.Parse(flow["redirect_to"].(string))
+	if err != nil {
+		t.Fatalf("Invalid redirect: %v", err)
+	}
+	if redirect.Query().Get("state") != "xyz" {
+		t.Errorf("Expected the state to be returned unchanged, got %q", redirect.Query().Get("state"))
+	}
+	code := redirect.Query().Get("code")
+	if code == "" {
+		t.Fatalf("Expected a code in %s", redirect)
+	}
+	return code
+}
+
+// leftHalfHash is the at_hash / c_hash of a value in an HS256 or RS256 signed ID token
+func leftHalfHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+func parseJWT(t *testing.T, token string) jwt.MapClaims {
+	t.Helper()
+	if strings.Count(token, ".") != 2 {
+		t.Fatalf("Expected a compact JWS, got %q", token)
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		t.Fatalf("Invalid JWT: %v", err)
+	}
+	return claims
+}
+
+func TestDiscoveryDocument(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, tenantID := range server.tenants {
+		doc := discovery(t, server, tenantID)
+
+		for _, field := range []string{"issuer", "authorization_endpoint", "token_endpoint", "jwks_uri", "userinfo_endpoint",
+			"response_types_supported", "subject_types_supported", "id_token_signing_alg_values_supported"} {
+			if doc[field] == nil {
+				t.Errorf("Discovery for tenant %s is missing %s", tenantID, field)
+			}
+		}
+		issuer := server.URL + "/tenant/" + tenantID
+		if doc["issuer"] != issuer {
+			t.Errorf("Expected issuer %s, got %v", issuer, doc["issuer"])
+		}
+		if !strings.HasPrefix(doc["token_endpoint"].(string), issuer+"/") || !strings.HasPrefix(doc["authorization_endpoint"].(string), issuer+"/") {
+			t.Errorf("Expected the endpoints to live under the issuer, got %v", doc)
+		}
+		for field, value := range map[string]string{
+			"response_types_supported":              "code",
+			"code_challenge_methods_supported":      "S256",
+			"token_endpoint_auth_methods_supported": "client_secret_basic",
+		} {
+			if !containsValue(doc[field], value) {
+				t.Errorf("Expected %s to contain %q, got %v", field, value, doc[field])
+			}
+		}
+	}
+}
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, tenantID := range server.tenants {
+		doc := discovery(t, server, tenantID)
+		clientID := "public-" + tenantID
+		verifier, challenge := pkcePair()
+		code := authorize(t, server, tenantID, clientID, challenge)
+
+		resp := postForm(t, doc["token_endpoint"].(string), url.Values{
+			"grant_type": {"authorization_code"}, "code": {code}, "client_id": {clientID},
+			"redirect_uri": {redirectURI}, "code_verifier": {verifier},
+		}, "", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Token request returned %d", resp.StatusCode)
+		}
+		if cc := resp.Header.Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("Expected Cache-Control: no-store on token responses, got %q", cc)
+		}
+		var tokens map[string]interface{}
+		decodeJSON(t, resp, &tokens)
+
+		if !strings.EqualFold(tokens["token_type"].(string), "Bearer") {
+			t.Errorf("Expected a Bearer token, got %v", tokens["token_type"])
+		}
+		if expiresIn, ok := tokens["expires_in"].(float64); !ok || expiresIn <= 0 {
+			t.Errorf("Expected a positive expires_in, got %v", tokens["expires_in"])
+		}
+		parseJWT(t, tokens["access_token"].(string))
+
+		idToken := parseJWT(t, tokens["id_token"].(string))
+		for _, claim := range []string{"iss", "sub", "aud", "exp", "iat"} {
+			if idToken[claim] == nil {
+				t.Errorf("ID token is missing the %s claim", claim)
+			}
+		}
+		if idToken["iss"] != doc["issuer"] {
+			t.Errorf("Expected the ID token issuer %v, got %v", doc["issuer"], idToken["iss"])
+		}
+		if !containsValue(idToken["aud"], clientID) {
+			t.Errorf("Expected the ID token audience to contain %s, got %v", clientID, idToken["aud"])
+		}
+		if want := leftHalfHash(tokens["access_token"].(string)); idToken["at_hash"] != want {
+			t.Errorf("Expected at_hash %s, got %v", want, idToken["at_hash"])
+		}
+		if want := leftHalfHash(code); idToken["c_hash"] != want {
+			t.Errorf("Expected c_hash %s, got %v", want, idToken["c_hash"])
+		}
+		if idToken["email"] == nil {
+			t.Errorf("Expected the email scope to release the email claim")
+		}
+		for _, claim := range []string{"scopes", "groups"} {
+			if idToken[claim] != nil {
+				t.Errorf("Expected no %s claim without the groups scope or role claims, got %v", claim, idToken[claim])
+			}
+		}
+
+		// Codes are single use
+		resp = postForm(t, doc["token_endpoint"].(string), url.Values{
+			"grant_type": {"authorization_code"}, "code": {code}, "client_id": {clientID},
+			"redirect_uri": {redirectURI}, "code_verifier": {verifier},
+		}, "", "")
+		expectOAuthError(t, resp, http.StatusBadRequest, "invalid_grant")
+
+		// Refresh
+		resp = postForm(t, doc["token_endpoint"].(string), url.Values{
+			"grant_type": {"refresh_token"}, "refresh_token": {tokens["refresh_token"].(string)}, "client_id": {clientID},
+		}, "", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Refresh returned %d", resp.StatusCode)
+		}
+		var refreshed map[string]interface{}
+		decodeJSON(t, resp, &refreshed)
+		parseJWT(t, refreshed["access_token"].(string))
+
+		// UserInfo
+		req, _ := http.NewRequest(http.MethodGet, doc["userinfo_endpoint"].(string), nil)
+		req.Header.Set("Authorization", "Bearer "+refreshed["access_token"].(string))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("UserInfo returned %d", resp.StatusCode)
+		}
+		var userInfo map[string]interface{}
+		decodeJSON(t, resp, &userInfo)
+		if userInfo["sub"] != idToken["sub"] {
+			t.Errorf("Expected the UserInfo sub %v to match the ID token, got %v", idToken["sub"], userInfo["sub"])
+		}
+		if userInfo["email"] != userEmail {
+			t.Errorf("Expected the email claim, got %v", userInfo["email"])
+		}
+	}
+}
+
+func TestTokenEndpointErrors(t *testing.T) {
+	server := newTestServer(t)
+	tenantID := server.tenants[1]
+	tokenEndpoint := discovery(t, server, tenantID)["token_endpoint"].(string)
+
+	t.Run("unsupported grant type", func(t *testing.T) {
+		resp := postForm(t, tokenEndpoint, url.Values{"grant_type": {"password"}}, "", "")
+		expectOAuthError(t, resp, http.StatusBadRequest, "unsupported_grant_type")
+	})
+
+	t.Run("missing code", func(t *testing.T) {
+		resp := postForm(t, tokenEndpoint, url.Values{"grant_type": {"authorization_code"}, "client_id": {"public-" + tenantID}}, "", "")
+		expectOAuthError(t, resp, http.StatusBadRequest, "invalid_request")
+	})
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		_, challenge := pkcePair()
+		code := authorize(t, server, tenantID, "public-"+tenantID, challenge)
+		resp := postForm(t, tokenEndpoint, url.Values{
+			"grant_type": {"authorization_code"}, "code": {code}, "client_id": {"public-" + tenantID},
+			"redirect_uri": {redirectURI}, "code_verifier": {"not-the-verifier-not-the-verifier-not-the-verifier"},
+		}, "", "")
+		expectOAuthError(t, resp, http.StatusBadRequest, "invalid_grant")
+	})
+
+	t.Run("wrong client secret with Basic", func(t *testing.T) {
+		resp := postForm(t, tokenEndpoint, url.Values{"grant_type": {"authorization_code"}, "code": {"unknown"}, "redirect_uri": {redirectURI}},
+			"confidential-"+tenantID, "wrong")
+		if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Error("Expected a WWW-Authenticate challenge")
+		}
+		expectOAuthError(t, resp, http.StatusUnauthorized, "invalid_client")
+	})
+
+	t.Run("both client authentication methods", func(t *testing.T) {
+		resp := postForm(t, tokenEndpoint, url.Values{"grant_type": {"authorization_code"}, "code": {"unknown"}, "client_secret": {clientSecret}},
+			"confidential-"+tenantID, clientSecret)
+		expectOAuthError(t, resp, http.StatusBadRequest, "invalid_request")
+	})
+
+	t.Run("code of another tenant", func(t *testing.T) {
+		otherTenant := server.tenants[0]
+		verifier, challenge := pkcePair()
+		code := authorize(t, server, otherTenant, "public-"+otherTenant, challenge)
+		resp := postForm(t, server.URL+"/tenant/"+tenantID+"/oauth/token", url.Values{
+			"grant_type": {"authorization_code"}, "code": {code}, "client_id": {"public-" + otherTenant},
+			"redirect_uri": {redirectURI}, "code_verifier": {verifier},
+		}, "", "")
+		expectOAuthError(t, resp, http.StatusBadRequest, "invalid_grant")
+	})
+
+	t.Run("invalid refresh token", func(t *testing.T) {
+		resp := postForm(t, tokenEndpoint, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"bogus"}, "client_id": {"public-" + tenantID}}, "", "")
+		expectOAuthError(t, resp, http.StatusBadRequest, "invalid_grant")
+	})
+}
+
+func TestAuthorizationEndpointErrors(t *testing.T) {
+	server := newTestServer(t)
+	tenantID := server.tenants[1]
+	authorizeEndpoint := discovery(t, server, tenantID)["authorization_endpoint"].(string)
+
+	// An unregistered redirect URI must not be redirected to
+	resp, err := noRedirects().Get(authorizeEndpoint + "?" + url.Values{
+		"client_id": {"public-" + tenantID}, "redirect_uri": {"https://evil.example.com/cb"}, "response_type": {"code"},
+	}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Location") != "" {
+		t.Errorf("Expected an error page without redirect, got %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// Other errors are returned to the registered redirect URI with the state
+	resp, err = noRedirects().Get(authorizeEndpoint + "?" + url.Values{
+		"client_id": {"public-" + tenantID}, "redirect_uri": {redirectURI}, "response_type": {"token"}, "state": {"abc"},
+	}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || location == nil || !strings.HasPrefix(location.String(), redirectURI) {
+		t.Fatalf("Expected a redirect to the client, got %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if location.Query().Get("error") != "unsupported_response_type" || location.Query().Get("state") != "abc" {
+		t.Errorf("Expected error=unsupported_response_type with the state, got %s", location.RawQuery)
+	}
+}
+
+func containsValue(list interface{}, value string) bool {
+	switch v := list.(type) {
+	case string:
+		return v == value
+	case []interface{}:
+		for _, item := range v {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// analyticsPingTimeout bounds the availability checks of the analytics connection
+const analyticsPingTimeout = 2 * time.Second
+
+// AnalyticsReadPaths are the read paths served by the analytics connection when one is configured.
+// Token validation always reads from the main connection.
+var AnalyticsReadPaths = []ReadPath{ReadPathDashboard, ReadPathAudit}
+
+// analyticsConnection is a separate connection for read-heavy queries, e.g. to the analytics
+// nodes of the main cluster, so they don't compete with token issuance
+type analyticsConnection struct {
+	database  *mongo.Database
+	available atomic.Bool
+}
+
+// ConnectAnalytics connects to the cluster serving the analytics read paths. The connection
+// string's own read preference applies; the other client options are the main connection's. An
+// unreachable cluster doesn't fail startup: the analytics reads fall back to the main connection
+// until CheckAnalytics reaches it.
+func (m *MongoDB) ConnectAnalytics(uri string, opts ClientOptions) error {
+	opts.ReadPreference = ""
+	clientOptions := options.Client().ApplyURI(uri)
+	if err := opts.apply(clientOptions); err != nil {
+		return fmt.Errorf("invalid MongoDB client options: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the MongoDB analytics cluster: %w", err)
+	}
+
+	analytics := &analyticsConnection{database: client.Database(m.Database.Name())}
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), analyticsPingTimeout)
+	defer cancelPing()
+	if err := client.Ping(pingCtx, nil); err != nil {
+		log.Printf("Warning: MongoDB analytics cluster unavailable, analytics reads use the main connection: %v", err)
+	} else {
+		analytics.available.Store(true)
+		log.Println("Connected to the MongoDB analytics cluster")
+	}
+	m.analytics = analytics
+	return nil
+}
+
+// CheckAnalytics pings the analytics cluster and routes the analytics reads to it or back to the
+// main connection accordingly. It returns the ping's error, nil when no analytics connection is
+// configured.
+func (m *MongoDB) CheckAnalytics() error {
+	if m.analytics == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), analyticsPingTimeout)
+	defer cancel()
+	err := m.analytics.database.Client().Ping(ctx, nil)
+
+	if previous := m.analytics.available.Swap(err == nil); previous != (err == nil) {
+		if err == nil {
+			log.Println("MongoDB analytics cluster available again, analytics reads use it")
+		} else {
+			log.Printf("Warning: MongoDB analytics cluster unavailable, analytics reads use the main connection: %v", err)
+		}
+	}
+	return err
+}
+
+// AnalyticsAvailable reports whether the analytics read paths are currently served by the
+// analytics connection
+func (m *MongoDB) AnalyticsAvailable() bool {
+	return m.analytics != nil && m.analytics.available.Load()
+}
+
+// analyticsDatabase returns the analytics cluster's database for a read path, or nil when the
+// path is read from the main connection
+func (m *MongoDB) analyticsDatabase(path ReadPath) *mongo.Database {
+	if !m.AnalyticsAvailable() {
+		return nil
+	}
+	for _, analyticsPath := range AnalyticsReadPaths {
+		if analyticsPath == path {
+			return m.analytics.database
+		}
+	}
+	return nil
+}
+
+// closeAnalytics disconnects the analytics cluster
+func (m *MongoDB) closeAnalytics(ctx context.Context) {
+	if m.analytics == nil {
+		return
+	}
+	if err := m.analytics.database.Client().Disconnect(ctx); err != nil {
+		log.Printf("Warning: Failed to disconnect from the MongoDB analytics cluster: %v", err)
+	}
+}
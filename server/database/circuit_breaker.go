@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrCircuitOpen is returned without calling MongoDB while a service's circuit is open
+var ErrCircuitOpen = errors.New("database calls of this service are suspended after repeated timeouts")
+
+// Circuit breaker states of a service
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// BreakerPolicy configures when a service's circuit opens and for how long
+type BreakerPolicy struct {
+	// FailureThreshold consecutive timeouts or network errors open the circuit
+	FailureThreshold int
+	// OpenDuration is how long an open circuit rejects calls before a trial call is let through
+	OpenDuration time.Duration
+}
+
+// DefaultBreakerPolicy gives up on a service's MongoDB calls for a few seconds after five
+// consecutive timeouts
+var DefaultBreakerPolicy = BreakerPolicy{
+	FailureThreshold: 5,
+	OpenDuration:     10 * time.Second,
+}
+
+// BreakerHealth is the circuit state and call statistics of a service since startup
+type BreakerHealth struct {
+	Service             string     `json:"service"`
+	State               string     `json:"state"`
+	Calls               int64      `json:"calls"`
+	Failures            int64      `json:"failures"`
+	Rejected            int64      `json:"rejected"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+// CircuitBreaker stops a service's MongoDB calls while MongoDB keeps timing out, so requests
+// fail fast instead of piling up behind a slow cluster. Only timeouts and network errors count
+// as failures; other errors (not found, duplicate keys) are answers of a healthy cluster.
+type CircuitBreaker struct {
+	policy BreakerPolicy
+	now    func() time.Time
+
+	mu           sync.Mutex
+	health       BreakerHealth
+	trialPending bool
+}
+
+func newCircuitBreaker(service string, policy BreakerPolicy) *CircuitBreaker {
+	return &CircuitBreaker{
+		policy: policy,
+		now:    time.Now,
+		health: BreakerHealth{Service: service, State: CircuitClosed},
+	}
+}
+
+// Do calls fn unless the circuit is open, and records its outcome
+func (b *CircuitBreaker) Do(fn func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// allow reports whether a call may be made. An open circuit lets a single trial call through
+// once OpenDuration has passed.
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.health.State {
+	case CircuitOpen:
+		if b.now().Sub(*b.health.OpenedAt) < b.policy.OpenDuration {
+			b.health.Rejected++
+			return false
+		}
+		b.health.State = CircuitHalfOpen
+		b.trialPending = true
+		return true
+	case CircuitHalfOpen:
+		if b.trialPending {
+			b.health.Rejected++
+			return false
+		}
+		b.trialPending = true
+		return true
+	}
+	return true
+}
+
+// record updates the statistics and circuit state after a call
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.health.Calls++
+	b.trialPending = false
+	if !IsUnavailable(err) {
+		if b.health.State != CircuitClosed {
+			log.Printf("MongoDB circuit of %s closed again", b.health.Service)
+		}
+		b.health.State = CircuitClosed
+		b.health.ConsecutiveFailures = 0
+		b.health.OpenedAt = nil
+		return
+	}
+
+	now := b.now()
+	b.health.Failures++
+	b.health.ConsecutiveFailures++
+	b.health.LastError = err.Error()
+	b.health.LastFailureAt = &now
+	if b.health.State == CircuitHalfOpen || b.health.ConsecutiveFailures >= b.policy.FailureThreshold {
+		if b.health.State != CircuitOpen {
+			log.Printf("Warning: MongoDB circuit of %s opened after %d consecutive failures: %v", b.health.Service, b.health.ConsecutiveFailures, err)
+		}
+		b.health.State = CircuitOpen
+		b.health.OpenedAt = &now
+	}
+}
+
+// snapshot returns a copy of the breaker's health
+func (b *CircuitBreaker) snapshot() BreakerHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.health
+}
+
+// IsUnavailable reports whether err means MongoDB didn't answer in time or couldn't be reached,
+// including calls rejected by an open circuit
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded) ||
+		mongo.IsTimeout(err) || mongo.IsNetworkError(err)
+}
+
+// breakerRegistry holds the circuit breakers of the services sharing a connection
+type breakerRegistry struct {
+	mu       sync.Mutex
+	policy   BreakerPolicy
+	breakers map[string]*CircuitBreaker
+}
+
+func newBreakerRegistry() *breakerRegistry {
+	return &breakerRegistry{policy: DefaultBreakerPolicy, breakers: map[string]*CircuitBreaker{}}
+}
+
+// Breaker returns the circuit breaker of a service, creating it closed. Services call MongoDB
+// through it for work the request can do without, so a slow cluster doesn't hold requests up.
+func (m *MongoDB) Breaker(service string) *CircuitBreaker {
+	m.breakers.mu.Lock()
+	defer m.breakers.mu.Unlock()
+
+	breaker, ok := m.breakers.breakers[service]
+	if !ok {
+		breaker = newCircuitBreaker(service, m.breakers.policy)
+		m.breakers.breakers[service] = breaker
+	}
+	return breaker
+}
+
+// SetBreakerPolicy changes the policy of the circuit breakers created afterwards
+func (m *MongoDB) SetBreakerPolicy(policy BreakerPolicy) {
+	m.breakers.mu.Lock()
+	defer m.breakers.mu.Unlock()
+	m.breakers.policy = policy
+}
+
+// BreakerHealth reports the circuit breakers ordered by service name
+func (m *MongoDB) BreakerHealth() []BreakerHealth {
+	m.breakers.mu.Lock()
+	breakers := make([]*CircuitBreaker, 0, len(m.breakers.breakers))
+	for _, breaker := range m.breakers.breakers {
+		breakers = append(breakers, breaker)
+	}
+	m.breakers.mu.Unlock()
+
+	health := make([]BreakerHealth, 0, len(breakers))
+	for _, breaker := range breakers {
+		health = append(health, breaker.snapshot())
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Service < health[j].Service })
+	return health
+}
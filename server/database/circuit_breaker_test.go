@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker("audit_events", BreakerPolicy{FailureThreshold: 2, OpenDuration: 10 * time.Second})
+	breaker.now = func() time.Time { return now }
+
+	calls := 0
+	timeout := func() error { calls++; return context.DeadlineExceeded }
+	succeed := func() error { calls++; return nil }
+
+	// Answers of a healthy cluster don't count as failures
+	notFound := errors.New("not found")
+	for i := 0; i < 3; i++ {
+		breaker.Do(func() error { return notFound })
+	}
+	if state := breaker.snapshot().State; state != CircuitClosed {
+		t.Fatalf("state after ordinary errors = %s", state)
+	}
+
+	breaker.Do(timeout)
+	breaker.Do(timeout)
+	if err := breaker.Do(succeed); err != ErrCircuitOpen || calls != 2 {
+		t.Fatalf("Do() while open = %v after %d calls", err, calls)
+	}
+
+	// After the open duration one trial call is let through; a failure opens the circuit again
+	now = now.Add(10 * time.Second)
+	breaker.Do(timeout)
+	if err := breaker.Do(succeed); err != ErrCircuitOpen {
+		t.Fatalf("Do() after a failed trial = %v", err)
+	}
+
+	now = now.Add(10 * time.Second)
+	if err := breaker.Do(succeed); err != nil {
+		t.Fatalf("trial call error = %v", err)
+	}
+	health := breaker.snapshot()
+	if health.State != CircuitClosed || health.Rejected != 2 || health.Failures != 3 {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	if !IsUnavailable(ErrCircuitOpen) || !IsUnavailable(context.DeadlineExceeded) {
+		t.Error("IsUnavailable() missed a timeout")
+	}
+	if IsUnavailable(nil) || IsUnavailable(errors.New("duplicate key")) {
+		t.Error("IsUnavailable() reported an ordinary error")
+	}
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// ReadPath names a heavy read path whose read preference can be set apart from the client's, e.g.
+// to send dashboard aggregations to secondaries
+type ReadPath string
+
+const (
+	ReadPathTokenValidation ReadPath = "token_validation" // access token lookups of authenticated requests
+	ReadPathDashboard       ReadPath = "dashboard"        // dashboard statistics, charts and reports
+	ReadPathAudit           ReadPath = "audit"            // activity feeds, login history and audit summaries
+)
+
+// ReadPaths lists the read paths with a configurable read preference
+var ReadPaths = []ReadPath{ReadPathTokenValidation, ReadPathDashboard, ReadPathAudit}
+
+// ClientOptions tune the MongoDB client. Zero values keep the driver's defaults or what the
+// connection string sets.
+type ClientOptions struct {
+	MaxPoolSize            int
+	MinPoolSize            int
+	MaxConnIdleTime        time.Duration
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+	ReadPreference         string              // primary, primaryPreferred, secondary, secondaryPreferred or nearest
+	WriteConcern           string              // "majority" or the number of members that acknowledge a write
+	ReadPathPreferences    map[ReadPath]string // read preferences of read paths, overriding ReadPreference
+}
+
+// ParseReadPreference parses a read preference mode such as "secondaryPreferred"
+func ParseReadPreference(mode string) (*readpref.ReadPref, error) {
+	parsed, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, err
+	}
+	return readpref.New(parsed)
+}
+
+// ParseWriteConcern parses "majority" or a number of acknowledging members (at least 1: the
+// server's results, such as matched counts, are needed)
+func ParseWriteConcern(value string) (*writeconcern.WriteConcern, error) {
+	if value == "majority" {
+		return writeconcern.Majority(), nil
+	}
+	members, err := strconv.Atoi(value)
+	if err != nil || members < 1 {
+		return nil, fmt.Errorf("write concern must be \"majority\" or a number of members of at least 1, got %q", value)
+	}
+	return &writeconcern.WriteConcern{W: members}, nil
+}
+
+// apply sets the non-zero options on the driver's client options
+func (o ClientOptions) apply(clientOptions *options.ClientOptions) error {
+	if o.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(uint64(o.MaxPoolSize))
+	}
+	if o.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(uint64(o.MinPoolSize))
+	}
+	if o.MaxConnIdleTime > 0 {
+		clientOptions.SetMaxConnIdleTime(o.MaxConnIdleTime)
+	}
+	if o.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(o.ConnectTimeout)
+	}
+	if o.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(o.ServerSelectionTimeout)
+	}
+	if o.SocketTimeout > 0 {
+		clientOptions.SetSocketTimeout(o.SocketTimeout)
+	}
+	if o.ReadPreference != "" {
+		readPreference, err := ParseReadPreference(o.ReadPreference)
+		if err != nil {
+			return err
+		}
+		clientOptions.SetReadPreference(readPreference)
+	}
+	if o.WriteConcern != "" {
+		writeConcern, err := ParseWriteConcern(o.WriteConcern)
+		if err != nil {
+			return err
+		}
+		clientOptions.SetWriteConcern(writeConcern)
+	}
+	return nil
+}
+
+// readPathPreferences parses the read preferences of the read paths
+func (o ClientOptions) readPathPreferences() (map[ReadPath]*readpref.ReadPref, error) {
+	preferences := map[ReadPath]*readpref.ReadPref{}
+	for path, mode := range o.ReadPathPreferences {
+		if mode == "" {
+			continue
+		}
+		readPreference, err := ParseReadPreference(mode)
+		if err != nil {
+			return nil, fmt.Errorf("read preference of %s: %w", path, err)
+		}
+		preferences[path] = readPreference
+	}
+	return preferences, nil
+}
+
+// ReadCollection returns a collection for a heavy read path: on the analytics connection for the
+// AnalyticsReadPaths while it is available, otherwise read with the path's read preference when one
+// is configured. Reads from secondaries may lag behind recent writes.
+func (m *MongoDB) ReadCollection(path ReadPath, name string) *mongo.Collection {
+	if analytics := m.analyticsDatabase(path); analytics != nil {
+		return analytics.Collection(name)
+	}
+	readPreference, ok := m.readPaths[path]
+	if !ok {
+		return m.GetCollection(name)
+	}
+	return m.Database.Collection(name, options.Collection().SetReadPreference(readPreference))
+}
+
+// hintedIndexes remembers the indexes that are built, so queries only hint indexes that exist: a
+// hint naming a missing or still building index fails the query
+type hintedIndexes struct {
+	mu    sync.RWMutex
+	ready map[string]bool // "collection.index"
+}
+
+// CreateHintedIndexes creates named indexes that queries hint with Hint. The hints take effect
+// once the indexes are built.
+func (m *MongoDB) CreateHintedIndexes(ctx context.Context, collection string, models []mongo.IndexModel) error {
+	names, err := m.GetCollection(collection).Indexes().CreateMany(ctx, models)
+	if err != nil {
+		return err
+	}
+
+	m.hints.mu.Lock()
+	defer m.hints.mu.Unlock()
+	for _, name := range names {
+		m.hints.ready[collection+"."+name] = true
+	}
+	return nil
+}
+
+// Hint returns the index name for a query option's hint, or nil (no hint) until the index was
+// created with CreateHintedIndexes
+func (m *MongoDB) Hint(collection, index string) interface{} {
+	m.hints.mu.RLock()
+	defer m.hints.mu.RUnlock()
+	if !m.hints.ready[collection+"."+index] {
+		return nil
+	}
+	return index
+}
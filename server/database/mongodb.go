@@ -8,18 +8,41 @@ import (
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type MongoDB struct {
 	Client   *mongo.Client
 	Database *mongo.Database
+
+	routing    *tenantRouting
+	homeRegion string // data residency region of the main cluster, see ConnectRegions
+	readPaths  map[ReadPath]*readpref.ReadPref
+	analytics  *analyticsConnection // see ConnectAnalytics
+	hints      *hintedIndexes
+	breakers   *breakerRegistry // see Breaker
 }
 
 func NewMongoDB(uri, dbName string) (*MongoDB, error) {
+	return NewMongoDBWithOptions(uri, dbName, ClientOptions{})
+}
+
+// NewMongoDBWithOptions connects with tuned client options. They override the same options in
+// the connection string.
+func NewMongoDBWithOptions(uri, dbName string, opts ClientOptions) (*MongoDB, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	clientOptions := options.Client().ApplyURI(uri)
+	if err := opts.apply(clientOptions); err != nil {
+		return nil, fmt.Errorf("invalid MongoDB client options: %w", err)
+	}
+	readPaths, err := opts.readPathPreferences()
+	if err != nil {
+		return nil, fmt.Errorf("invalid MongoDB client options: %w", err)
+	}
+
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
@@ -33,17 +56,37 @@ func NewMongoDB(uri, dbName string) (*MongoDB, error) {
 	database := client.Database(dbName)
 
 	return &MongoDB{
-		Client:   client,
-		Database: database,
+		Client:    client,
+		Database:  database,
+		routing:   newTenantRouting(),
+		readPaths: readPaths,
+		hints:     &hintedIndexes{ready: map[string]bool{}},
+		breakers:  newBreakerRegistry(),
 	}, nil
 }
 
+// NewMongoDBWithClient wraps an existing connection, e.g. one shared by tests, without pinging it
+func NewMongoDBWithClient(client *mongo.Client, dbName string) *MongoDB {
+	return &MongoDB{
+		Client:    client,
+		Database:  client.Database(dbName),
+		routing:   newTenantRouting(),
+		readPaths: map[ReadPath]*readpref.ReadPref{},
+		hints:     &hintedIndexes{ready: map[string]bool{}},
+		breakers:  newBreakerRegistry(),
+	}
+}
+
 func (m *MongoDB) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	m.closeRegions(ctx)
+	m.closeAnalytics(ctx)
 	return m.Client.Disconnect(ctx)
 }
 
+// GetCollection returns a collection of the shared database. It doesn't route tenants: the
+// collections in TenantScopedCollections must be looked up with TenantCollection instead.
 func (m *MongoDB) GetCollection(name string) *mongo.Collection {
 	return m.Database.Collection(name)
 }
\ No newline at end of file
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	regionPattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+	// ErrRegionUnavailable is returned for tenants of a region this instance has no cluster for
+	ErrRegionUnavailable = errors.New("the tenant's region is not served by this instance")
+
+	// ErrResidencyUnsupported is returned for placing a tenant in a region other than the main
+	// cluster's, see CheckResidency
+	ErrResidencyUnsupported = errors.New("tenants can't be placed in another region: their users, tokens and sessions stay on the main cluster")
+
+	unavailableOnce     sync.Once
+	unavailableDatabase *mongo.Database
+)
+
+// CheckResidency rejects placing a tenant in a region other than the main cluster's. Only the
+// tenant-scoped collections follow a placement while users, clients, tokens and sessions stay on
+// the main cluster, so a regional placement can't keep a tenant's personal data in its region.
+// Tenants placed in a region before are still served there and can be moved back.
+func (m *MongoDB) CheckResidency(placement Placement) error {
+	if m.normalize(placement).Region != "" {
+		return ErrResidencyUnsupported
+	}
+	return nil
+}
+
+// ValidRegion reports whether a region name is usable, e.g. "eu" or "us-east"
+func ValidRegion(region string) bool {
+	return regionPattern.MatchString(region)
+}
+
+// ConnectRegions connects to the clusters of the data residency regions. home is the region of the
+// main cluster ("" when it has none); uris maps the other regions to their connection strings.
+// Every cluster uses the main database name.
+func (m *MongoDB) ConnectRegions(home string, uris map[string]string, opts ClientOptions) error {
+	m.homeRegion = home
+	for region, uri := range uris {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		clientOptions := options.Client().ApplyURI(uri)
+		if err := opts.apply(clientOptions); err != nil {
+			cancel()
+			return fmt.Errorf("invalid MongoDB client options: %w", err)
+		}
+		client, err := mongo.Connect(ctx, clientOptions)
+		if err == nil {
+			err = client.Ping(ctx, nil)
+		}
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to connect to the MongoDB cluster of region %s: %w", region, err)
+		}
+		m.AddRegion(region, client.Database(m.Database.Name()))
+		log.Printf("Connected to the MongoDB cluster of region %s", region)
+	}
+	return nil
+}
+
+// AddRegion serves a region's tenant-scoped collections from database
+func (m *MongoDB) AddRegion(region string, database *mongo.Database) {
+	m.routing.mu.Lock()
+	defer m.routing.mu.Unlock()
+	m.routing.regions[region] = database
+}
+
+// HomeRegion is the region of the main cluster, "" when it isn't tied to one
+func (m *MongoDB) HomeRegion() string {
+	return m.homeRegion
+}
+
+// Regions lists the regions this instance can store tenant data in
+func (m *MongoDB) Regions() []string {
+	m.routing.mu.RLock()
+	defer m.routing.mu.RUnlock()
+
+	regions := make([]string, 0, len(m.routing.regions)+1)
+	if m.homeRegion != "" {
+		regions = append(regions, m.homeRegion)
+	}
+	for region := range m.routing.regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// ServesRegion reports whether this instance can store data in the region. "" is the main cluster.
+func (m *MongoDB) ServesRegion(region string) bool {
+	if region == "" || region == m.homeRegion {
+		return true
+	}
+	m.routing.mu.RLock()
+	defer m.routing.mu.RUnlock()
+	return m.routing.regions[region] != nil
+}
+
+// regionDatabase returns the main database of a region's cluster. Regions without a connected
+// cluster get a disconnected database whose every query fails, so a tenant's data never ends up
+// in another region.
+func (m *MongoDB) regionDatabase(region string) *mongo.Database {
+	if region == "" || region == m.homeRegion {
+		return m.Database
+	}
+	m.routing.mu.RLock()
+	database := m.routing.regions[region]
+	m.routing.mu.RUnlock()
+	if database == nil {
+		return unavailableRegionDatabase()
+	}
+	return database
+}
+
+// unavailableRegionDatabase is a database on a client that was disconnected right away: queries
+// on it fail with mongo.ErrClientDisconnected without reaching any server
+func unavailableRegionDatabase() *mongo.Database {
+	unavailableOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://region-unavailable.invalid"))
+		if err != nil {
+			panic(fmt.Sprintf("failed to create the unavailable region client: %v", err))
+		}
+		client.Disconnect(ctx)
+		unavailableDatabase = client.Database("region_unavailable")
+	})
+	return unavailableDatabase
+}
+
+// closeRegions disconnects the regional clusters
+func (m *MongoDB) closeRegions(ctx context.Context) {
+	m.routing.mu.RLock()
+	defer m.routing.mu.RUnlock()
+	for region, database := range m.routing.regions {
+		if err := database.Client().Disconnect(ctx); err != nil {
+			log.Printf("Warning: Failed to disconnect from the MongoDB cluster of region %s: %v", region, err)
+		}
+	}
+}
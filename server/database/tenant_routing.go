@@ -0,0 +1,329 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TenantScopedCollections can be moved to a tenant's own database or collection prefix. Every
+// query on them names the tenant, so they are looked up with TenantCollection. Routing isn't
+// transparent: GetCollection and ReadCollection always return the shared collection, so code
+// reading or writing these collections must use TenantCollection or ReadTenantCollection. Other
+// collections, including users, clients, tokens and sessions, always stay in the shared database.
+var TenantScopedCollections = []string{"audit_events", "consents", "consent_receipts"}
+
+// Placement is where a tenant's scoped collections live. The zero value is the shared database.
+type Placement struct {
+	Region           string // data residency region whose cluster holds them (empty: the main cluster)
+	Database         string // own database on the region's cluster (empty: the shared database)
+	CollectionPrefix string // prefix of the collection names, e.g. "acme_" for acme_audit_events
+}
+
+// IsShared reports whether the placement is the shared database and collection names
+func (p Placement) IsShared() bool {
+	return p.Region == "" && p.Database == "" && p.CollectionPrefix == ""
+}
+
+var (
+	databaseNamePattern     = regexp.MustCompile(`^[A-Za-z0-9_-]{1,63}$`)
+	collectionPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_]{0,32}$`)
+
+	// reservedDatabases are the system databases of a MongoDB server
+	reservedDatabases = map[string]bool{"admin": true, "local": true, "config": true}
+
+	ErrInvalidPlacement = errors.New("invalid tenant storage placement")
+)
+
+// ValidatePlacement checks that a placement names a usable database and collection prefix
+func ValidatePlacement(placement Placement) error {
+	if placement.Region != "" && !ValidRegion(placement.Region) {
+		return fmt.Errorf("%w: region names may only contain lowercase letters, digits and hyphens (at most 32)", ErrInvalidPlacement)
+	}
+	if placement.Database != "" {
+		if !databaseNamePattern.MatchString(placement.Database) {
+			return fmt.Errorf("%w: database names may only contain letters, digits, underscores and hyphens (at most 63)", ErrInvalidPlacement)
+		}
+		if reservedDatabases[placement.Database] {
+			return fmt.Errorf("%w: %s is a system database", ErrInvalidPlacement, placement.Database)
+		}
+	}
+	if !collectionPrefixPattern.MatchString(placement.CollectionPrefix) {
+		return fmt.Errorf("%w: collection prefixes may only contain letters, digits and underscores (at most 32)", ErrInvalidPlacement)
+	}
+	return nil
+}
+
+// normalize maps the home region and the shared database named explicitly to empty names, so a
+// placement that resolves to the shared collections is recognized as shared
+func (m *MongoDB) normalize(placement Placement) Placement {
+	if placement.Region == m.homeRegion {
+		placement.Region = ""
+	}
+	if placement.Database == m.Database.Name() {
+		placement.Database = ""
+	}
+	return placement
+}
+
+// tenantRouting maps tenants to their placements and remembers the indexes of the scoped
+// collections so isolated collections get them as well
+type tenantRouting struct {
+	mu         sync.RWMutex
+	placements map[string]Placement
+	indexes    map[string][]mongo.IndexModel
+	regions    map[string]*mongo.Database // main databases of the regional clusters
+}
+
+func newTenantRouting() *tenantRouting {
+	return &tenantRouting{
+		placements: map[string]Placement{},
+		indexes:    map[string][]mongo.IndexModel{},
+		regions:    map[string]*mongo.Database{},
+	}
+}
+
+func isTenantScoped(name string) bool {
+	for _, scoped := range TenantScopedCollections {
+		if scoped == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantCollection returns the collection holding a tenant's documents: the tenant's own one
+// when the collection is tenant-scoped and the tenant has a placement, otherwise the shared one
+func (m *MongoDB) TenantCollection(tenantID, name string) *mongo.Collection {
+	if !isTenantScoped(name) {
+		return m.GetCollection(name)
+	}
+
+	m.routing.mu.RLock()
+	placement := m.routing.placements[tenantID]
+	m.routing.mu.RUnlock()
+	return m.placedCollection(placement, name)
+}
+
+// ReadTenantCollection is TenantCollection for a heavy read path (see ReadCollection). Tenants
+// placed in another region are always read from that region's cluster.
+func (m *MongoDB) ReadTenantCollection(path ReadPath, tenantID, name string) *mongo.Collection {
+	if !isTenantScoped(name) {
+		return m.ReadCollection(path, name)
+	}
+
+	m.routing.mu.RLock()
+	placement := m.routing.placements[tenantID]
+	m.routing.mu.RUnlock()
+	if placement.Region != "" {
+		return m.placedCollection(placement, name)
+	}
+
+	database := m.Database
+	if analytics := m.analyticsDatabase(path); analytics != nil {
+		database = analytics
+	}
+	if placement.Database != "" {
+		database = database.Client().Database(placement.Database)
+	}
+	collectionOptions := options.Collection()
+	if readPreference, ok := m.readPaths[path]; ok && database.Client() == m.Client {
+		collectionOptions.SetReadPreference(readPreference)
+	}
+	return database.Collection(placement.CollectionPrefix+name, collectionOptions)
+}
+
+// HasTenantPlacement reports whether the tenant's scoped collections are routed away from the
+// shared ones on this instance
+func (m *MongoDB) HasTenantPlacement(tenantID string) bool {
+	m.routing.mu.RLock()
+	defer m.routing.mu.RUnlock()
+	_, ok := m.routing.placements[tenantID]
+	return ok
+}
+
+// TenantCollections returns the shared collection and every tenant's own collection of a name,
+// for platform-wide jobs such as index creation. Collections in regions this instance doesn't
+// serve are left out.
+func (m *MongoDB) TenantCollections(name string) []*mongo.Collection {
+	collections := []*mongo.Collection{m.GetCollection(name)}
+	if !isTenantScoped(name) {
+		return collections
+	}
+
+	m.routing.mu.RLock()
+	placements := make([]Placement, 0, len(m.routing.placements))
+	for _, placement := range m.routing.placements {
+		placements = append(placements, placement)
+	}
+	m.routing.mu.RUnlock()
+
+	seen := map[Placement]bool{{}: true}
+	for _, placement := range placements {
+		if !seen[placement] && m.ServesRegion(placement.Region) {
+			seen[placement] = true
+			collections = append(collections, m.placedCollection(placement, name))
+		}
+	}
+	return collections
+}
+
+// placedCollection returns a scoped collection of a placement. It never falls back to another
+// region: collections in regions without a connected cluster fail every query.
+func (m *MongoDB) placedCollection(placement Placement, name string) *mongo.Collection {
+	database := m.regionDatabase(placement.Region)
+	if placement.Database != "" {
+		database = database.Client().Database(placement.Database)
+	}
+	return database.Collection(placement.CollectionPrefix + name)
+}
+
+// SetTenantPlacement routes a tenant's scoped collections to the placement, creating the
+// registered indexes there when it changed. It doesn't move existing documents; see MoveTenant.
+func (m *MongoDB) SetTenantPlacement(tenantID string, placement Placement) {
+	placement = m.normalize(placement)
+
+	m.routing.mu.Lock()
+	if m.routing.placements[tenantID] == placement {
+		m.routing.mu.Unlock()
+		return
+	}
+	if placement.IsShared() {
+		delete(m.routing.placements, tenantID)
+	} else {
+		m.routing.placements[tenantID] = placement
+	}
+	indexes := make(map[string][]mongo.IndexModel, len(m.routing.indexes))
+	for name, models := range m.routing.indexes {
+		indexes[name] = models
+	}
+	m.routing.mu.Unlock()
+
+	if placement.IsShared() {
+		return
+	}
+	if !m.ServesRegion(placement.Region) {
+		log.Printf("Warning: Tenant %s is stored in region %s, which this instance has no cluster for", tenantID, placement.Region)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for name, models := range indexes {
+		if _, err := m.placedCollection(placement, name).Indexes().CreateMany(ctx, models); err != nil {
+			log.Printf("Warning: Failed to create %s indexes for tenant %s: %v", name, tenantID, err)
+		}
+	}
+}
+
+// EnsureTenantIndexes creates indexes on every collection of a tenant-scoped name and remembers
+// them for tenants placed later
+func (m *MongoDB) EnsureTenantIndexes(ctx context.Context, name string, models []mongo.IndexModel) error {
+	m.routing.mu.Lock()
+	m.routing.indexes[name] = models
+	m.routing.mu.Unlock()
+
+	for _, collection := range m.TenantCollections(name) {
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveBatchSize is how many documents MoveTenant copies per insert
+const moveBatchSize = 1000
+
+// MoveTenant routes a tenant's scoped collections from one placement to another and moves the
+// documents already stored there. New documents go to the new placement right away, so nothing
+// written during the move is lost; reads may miss documents that haven't been copied yet. Copying
+// skips documents that already exist, so an interrupted move can simply be repeated. Moves can't
+// go into another region (see CheckResidency); moving a tenant out of its region needs that
+// region's cluster, and is the only operation that reads and writes across regions.
+func (m *MongoDB) MoveTenant(ctx context.Context, tenantID string, from, to Placement) error {
+	if err := ValidatePlacement(to); err != nil {
+		return err
+	}
+	if err := m.CheckResidency(to); err != nil {
+		return err
+	}
+	from, to = m.normalize(from), m.normalize(to)
+	if !m.ServesRegion(from.Region) || !m.ServesRegion(to.Region) {
+		return ErrRegionUnavailable
+	}
+
+	m.SetTenantPlacement(tenantID, to)
+	if from == to {
+		return nil
+	}
+
+	for _, name := range TenantScopedCollections {
+		source := m.placedCollection(from, name)
+		filter := bson.M{"tenant_id": tenantID}
+
+		if err := copyDocuments(ctx, source, m.placedCollection(to, name), filter); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", name, err)
+		}
+		if _, err := source.DeleteMany(ctx, filter); err != nil {
+			return fmt.Errorf("failed to remove moved %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// copyDocuments inserts the matching documents of source into target in batches
+func copyDocuments(ctx context.Context, source, target *mongo.Collection, filter bson.M) error {
+	cursor, err := source.Find(ctx, filter, options.Find().SetBatchSize(moveBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	flush := func(batch []interface{}) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := target.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+		if err != nil && !onlyDuplicateKeyErrors(err) {
+			return err
+		}
+		return nil
+	}
+
+	batch := make([]interface{}, 0, moveBatchSize)
+	for cursor.Next(ctx) {
+		batch = append(batch, bson.Raw(append([]byte(nil), cursor.Current...)))
+		if len(batch) == moveBatchSize {
+			if err := flush(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush(batch)
+}
+
+// onlyDuplicateKeyErrors reports whether an unordered insert only failed on documents that were
+// already copied
+func onlyDuplicateKeyErrors(err error) bool {
+	bulkErr, ok := err.(mongo.BulkWriteException)
+	if !ok || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type AccessReviewHandler struct {
+	accessReviewService *services.AccessReviewService
+	membershipService   *services.MembershipService
+	auditService        *services.AuditService
+}
+
+// StartAccessReviewRequest opens an access review campaign. Groups are IDs or names; omitted, every
+// group of the tenant is reviewed. DueAt defaults to two weeks from now.
+type StartAccessReviewRequest struct {
+	Name            string     `json:"name" validate:"required,max=100"`
+	Groups          []string   `json:"groups" validate:"max=100,dive,max=100"`
+	DueAt           *time.Time `json:"due_at"`
+	RepeatEveryDays int        `json:"repeat_every_days" validate:"min=0,max=366"` // 0: the campaign runs once
+	RevokeUndecided bool       `json:"revoke_undecided"`                           // memberships nobody decided on are removed
+}
+
+// AccessReviewDecisionRequest approves or revokes a membership under review
+type AccessReviewDecisionRequest struct {
+	Decision string `json:"decision" validate:"required,oneof=approve revoke"`
+	Comment  string `json:"comment" validate:"max=500"`
+}
+
+func NewAccessReviewHandler(accessReviewService *services.AccessReviewService, membershipService *services.MembershipService, auditService *services.AuditService) *AccessReviewHandler {
+	return &AccessReviewHandler{
+		accessReviewService: accessReviewService,
+		membershipService:   membershipService,
+		auditService:        auditService,
+	}
+}
+
+// StartAccessReview opens a campaign and lists the memberships of its groups for review
+func (h *AccessReviewHandler) StartAccessReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req StartAccessReviewRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+
+	// Groups may be given by name or ID; they are stored as IDs
+	groupIDs, err := h.membershipService.ResolveGroupIDs(tenantID, req.Groups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	campaign := &models.AccessReviewCampaign{
+		Name:            req.Name,
+		GroupIDs:        groupIDs,
+		RevokeUndecided: req.RevokeUndecided,
+		RepeatEveryDays: req.RepeatEveryDays,
+	}
+	if req.DueAt != nil {
+		campaign.DueAt = *req.DueAt
+	}
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+		campaign.CreatedBy = claims.UserID
+	}
+
+	err = h.accessReviewService.Start(tenantID, campaign)
+	if errors.Is(err, services.ErrAccessReviewDueInPast) {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start access review: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(campaign)
+}
+
+// GetAccessReviews lists the tenant's campaigns, newest first
+func (h *AccessReviewHandler) GetAccessReviews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	campaigns, err := h.accessReviewService.List(middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to get access reviews: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_reviews": campaigns})
+}
+
+// GetAccessReview returns a campaign and all of its items
+func (h *AccessReviewHandler) GetAccessReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	campaign, items, err := h.accessReviewService.Get(middleware.GetTenantIDFromRequest(r), mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrAccessReviewNotFound) {
+		http.Error(w, "Access review not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get access review: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_review": campaign, "items": items})
+}
+
+// ApplyAccessReview completes a campaign before its due date and removes the revoked memberships
+func (h *AccessReviewHandler) ApplyAccessReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	actorID := ""
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+		actorID = claims.UserID
+	}
+
+	campaign, err := h.accessReviewService.Apply(middleware.GetTenantIDFromRequest(r), mux.Vars(r)["id"], actorID)
+	if errors.Is(err, services.ErrAccessReviewNotFound) {
+		http.Error(w, "Access review not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrAccessReviewClosed) {
+		writeErrorResponse(w, http.StatusConflict, "access_review_closed", err.Error(), nil)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to apply access review: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(campaign)
+}
+
+// GetPendingAccessReviews lists the memberships waiting for the caller's decision
+func (h *AccessReviewHandler) GetPendingAccessReviews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	items, err := h.accessReviewService.Pending(middleware.GetTenantIDFromRequest(r), claims.UserID, containsValue(claims.Scopes, services.AdminScope))
+	if err != nil {
+		http.Error(w, "Failed to get pending access reviews: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// DecideAccessReviewItem records the caller's decision on a membership under review
+func (h *AccessReviewHandler) DecideAccessReviewItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	var req AccessReviewDecisionRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	vars := mux.Vars(r)
+	item, err := h.accessReviewService.Decide(tenantID, vars["id"], vars["itemId"], claims.UserID, containsValue(claims.Scopes, services.AdminScope), req.Decision, req.Comment)
+	switch {
+	case errors.Is(err, services.ErrAccessReviewNotFound), errors.Is(err, services.ErrAccessReviewItemNotFound):
+		http.Error(w, "Access review item not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrAccessReviewClosed):
+		writeErrorResponse(w, http.StatusConflict, "access_review_closed", err.Error(), nil)
+		return
+	case errors.Is(err, services.ErrAccessReviewNotReviewer), errors.Is(err, services.ErrAccessReviewSelf):
+		writeErrorResponse(w, http.StatusForbidden, "access_denied", err.Error(), nil)
+		return
+	case errors.Is(err, services.ErrInvalidAccessReviewChoice):
+		writeErrorResponse(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	case err != nil:
+		http.Error(w, "Failed to record decision: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordActivity(h.auditService, r, &models.AuditEvent{TenantID: tenantID, Type: models.AuditEventAccessReviewDecision, UserID: item.UserID, Target: item.GroupID, Reason: item.Decision})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
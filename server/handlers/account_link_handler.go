@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type AccountLinkHandler struct {
+	accountLinkService *services.AccountLinkService
+	socialAuthService  *services.SocialAuthService
+	auditService       *services.AuditService
+}
+
+type CompletePasswordSetupRequest struct {
+	Token    string `json:"token" validate:"required,max=100"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// LoginMethodsResponse lists how the signed-in user can sign in, and which enabled providers
+// could still be linked
+type LoginMethodsResponse struct {
+	*services.LoginMethods
+	LinkableProviders []string `json:"linkable_providers"`
+}
+
+// PasswordSetupResponse describes a pending password setup
+type PasswordSetupResponse struct {
+	Status    string    `json:"status"` // "pending" or "completed"
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewAccountLinkHandler(accountLinkService *services.AccountLinkService, socialAuthService *services.SocialAuthService, auditService *services.AuditService) *AccountLinkHandler {
+	return &AccountLinkHandler{
+		accountLinkService: accountLinkService,
+		socialAuthService:  socialAuthService,
+		auditService:       auditService,
+	}
+}
+
+// GetMyLoginMethods reports whether the signed-in user has a password and two-factor
+// authentication, and which social identities are linked to the account
+func (h *AccountLinkHandler) GetMyLoginMethods(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	methods, err := h.accountLinkService.LoginMethods(claims.UserID, claims.TenantID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	response := LoginMethodsResponse{LoginMethods: methods, LinkableProviders: []string{}}
+	for _, provider := range h.socialAuthService.GetEnabledProviders(claims.TenantID) {
+		linked := false
+		for _, identity := range methods.SocialIdentities {
+			linked = linked || identity.Provider == provider
+		}
+		if !linked {
+			response.LinkableProviders = append(response.LinkableProviders, provider)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RequestMyPasswordSetup emails the signed-in user a link for adding a password to an account
+// that only signs in through social providers
+func (h *AccountLinkHandler) RequestMyPasswordSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	request, err := h.accountLinkService.RequestPasswordSetup(claims.UserID, claims.TenantID)
+	if !writePasswordSetupError(w, err) {
+		return
+	}
+
+	writePasswordSetup(w, http.StatusAccepted, request)
+}
+
+// CompletePasswordSetup sets a password with the token from a password setup link. It is public:
+// the token itself authorizes the change.
+func (h *AccountLinkHandler) CompletePasswordSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompletePasswordSetupRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	request, err := h.accountLinkService.CompletePasswordSetup(req.Token, req.Password)
+	if !writePasswordSetupError(w, err) {
+		return
+	}
+
+	if h.auditService != nil {
+		h.auditService.RecordRequest(r, &models.AuditEvent{
+			TenantID: request.TenantID,
+			Type:     models.AuditEventPasswordSet,
+			UserID:   request.UserID,
+			Email:    request.Email,
+		})
+	}
+
+	writePasswordSetup(w, http.StatusOK, request)
+}
+
+// ResetUserPassword removes the password of user {id} and emails the user a link for choosing a
+// new one. Admins and the managers of the user's groups may use it; they never see the link.
+func (h *AccountLinkHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	tenantID := middleware.GetTenantIDFromRequest(r)
+
+	request, err := h.accountLinkService.RequirePasswordReset(userID, tenantID)
+	if err != nil && err.Error() == "user not found" {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !writePasswordSetupError(w, err) {
+		return
+	}
+
+	recordActivity(h.auditService, r, &models.AuditEvent{
+		TenantID: tenantID,
+		Type:     models.AuditEventPasswordResetForced,
+		UserID:   userID,
+		Email:    request.Email,
+	})
+
+	writePasswordSetup(w, http.StatusAccepted, request)
+}
+
+// writePasswordSetupError writes the response for a failed password setup and reports whether
+// the operation succeeded
+func writePasswordSetupError(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case services.ErrPasswordAlreadySet:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case services.ErrPasswordSetupNoAddress:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case services.ErrPasswordSetupNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case services.ErrPasswordSetupExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+	default:
+		if !writePasswordBreachError(w, err) {
+			http.Error(w, "Failed to set up password: "+err.Error(), http.StatusInternalServerError)
+		}
+	}
+	return false
+}
+
+func writePasswordSetup(w http.ResponseWriter, status int, request *models.PasswordSetupRequest) {
+	response := PasswordSetupResponse{
+		Status:    "pending",
+		Email:     request.Email,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if request.UsedAt != nil {
+		response.Status = "completed"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
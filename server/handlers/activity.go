@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+)
+
+// recordActivity stores an audit event for the activity feed, attributing it to the signed-in
+// user unless that user is the subject of the event
+func recordActivity(auditService *services.AuditService, r *http.Request, event *models.AuditEvent) {
+	if auditService == nil {
+		return
+	}
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil && claims.UserID != event.UserID {
+		event.ActorID = claims.UserID
+	}
+	auditService.RecordRequest(r, event)
+}
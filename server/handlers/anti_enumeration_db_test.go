@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type recordingNotifier chan *services.Notification
+
+func (n recordingNotifier) Notify(notification *services.Notification) error {
+	n <- notification
+	return nil
+}
+
+// TestAntiEnumerationMode answers registrations and failed logins alike whether or not the
+// account exists, and explains taken emails to their owner by email
+func TestAntiEnumerationMode(t *testing.T) {
+	db := dbtest.New(t)
+	now := time.Now()
+	tenantID := primitive.NewObjectID()
+	dbtest.Insert(t, db, "tenants", &models.Tenant{ID: tenantID, Name: "Acme", Active: true, CreatedAt: now, UpdatedAt: now,
+		Settings: models.TenantSettings{AllowUserRegistration: true, AntiEnumeration: true}})
+
+	userService := services.NewUserService(db)
+	for _, user := range []*models.User{
+		{TenantID: tenantID.Hex(), Email: "jane@acme.com", PasswordHash: "password1", Active: true},
+		{TenantID: tenantID.Hex(), Email: "gone@acme.com", PasswordHash: "password1", Active: false},
+	} {
+		if err := userService.CreateUser(user); err != nil {
+			t.Fatalf("CreateUser() error = %v", err)
+		}
+	}
+
+	notifications := make(recordingNotifier, 1)
+	tenantService := services.NewTenantService(db)
+	users := NewUserHandler(userService, tenantService, services.NewGroupService(db), services.NewMembershipService(db),
+		services.NewConsentService(db), nil, nil, nil, services.NewRegistrationNotifier(notifications), services.NewBotProtectionService(db, "test-secret"), nil)
+	oauthService := services.NewOAuthService(db, "test-secret", services.DefaultLifetimes())
+	auth := NewAuthHandler(userService, oauthService, services.NewSocialAuthService(userService, db),
+		services.NewTwoFactorService(db, services.DefaultLifetimes()),
+		services.NewCIBAService(db, userService, oauthService, services.NewLogNotifier()),
+		services.NewTranslationService(db, tenantService), services.NewAuditService(db), services.NewConsentService(db), services.NewBotProtectionService(db, "test-secret"), services.NewFeatureFlagService(db), services.NewSSOSessionService(db, 0, 0), services.NewCookieService("test-secret", 0))
+
+	send := func(handler http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), middleware.TenantIDKey, tenantID.Hex()))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	existing := send(users.RegisterUser, "/api/v1/register", `{"email":"jane@acme.com","password":"password2"}`)
+	created := send(users.RegisterUser, "/api/v1/register", `{"email":"john@acme.com","password":"password2"}`)
+	if existing.Code != http.StatusAccepted || existing.Code != created.Code || existing.Body.String() != created.Body.String() {
+		t.Errorf("registrations answered %d %s and %d %s, want the same 202", existing.Code, existing.Body, created.Code, created.Body)
+	}
+	select {
+	case notification := <-notifications:
+		if notification.Recipient != "jane@acme.com" || notification.Type != services.NotificationRegistrationExistingAccount {
+			t.Errorf("notification = %+v, want an existing account notice to jane@acme.com", notification)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the owner of the existing account to be notified")
+	}
+	if user, _ := userService.GetUserByEmailAndTenant("john@acme.com", tenantID.Hex()); user == nil {
+		t.Error("Expected the new user to be created")
+	}
+
+	unknown := send(auth.Login, "/login", `{"email":"nobody@acme.com","password":"password1"}`)
+	disabled := send(auth.Login, "/login", `{"email":"gone@acme.com","password":"password1"}`)
+	if unknown.Code != http.StatusUnauthorized || disabled.Code != unknown.Code || disabled.Body.String() != unknown.Body.String() {
+		t.Errorf("logins answered %d %s and %d %s, want the same 401", unknown.Code, unknown.Body, disabled.Code, disabled.Body)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type APIKeyHandler struct {
+	apiKeyService *services.APIKeyService
+	auditService  *services.AuditService
+}
+
+// CreateAPIKeyRequest issues an API key. ExpiresAt is optional; keys without it don't expire.
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"max=50,dive,max=100"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse is the only response that includes the API key itself
+type CreateAPIKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService, auditService *services.AuditService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+		auditService:  auditService,
+	}
+}
+
+// GetAPIKeys lists the tenant's API keys, including revoked ones
+func (h *APIKeyHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := h.apiKeyService.List(middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to get API keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"api_keys": keys})
+}
+
+// CreateAPIKey issues an API key for an integration. The key is only shown in this response.
+// API keys can't issue further keys.
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if middleware.IsAPIKeyRequest(r) {
+		writeErrorResponse(w, http.StatusForbidden, "api_key_forbidden", "API keys can't manage API keys", nil)
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	createdBy := ""
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+		createdBy = claims.UserID
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	apiKey, key, err := h.apiKeyService.Create(tenantID, req.Name, req.Scopes, req.ExpiresAt, createdBy)
+	if errors.Is(err, services.ErrAPIKeyReservedScope) || errors.Is(err, services.ErrAPIKeyExpiryInPast) {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordActivity(h.auditService, r, &models.AuditEvent{TenantID: tenantID, Type: models.AuditEventAPIKeyCreated, UserID: apiKey.PrincipalID(), Target: apiKey.ID.Hex()})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+// RevokeAPIKey stops an API key from working
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if middleware.IsAPIKeyRequest(r) {
+		writeErrorResponse(w, http.StatusForbidden, "api_key_forbidden", "API keys can't manage API keys", nil)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	apiKey, err := h.apiKeyService.Revoke(tenantID, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordActivity(h.auditService, r, &models.AuditEvent{TenantID: tenantID, Type: models.AuditEventAPIKeyRevoked, UserID: apiKey.PrincipalID(), Target: apiKey.ID.Hex()})
+
+	w.WriteHeader(http.StatusNoContent)
+}
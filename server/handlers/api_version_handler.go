@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"oauth2-openid-server/services"
+)
+
+// APIVersionInfo describes an API version served under Path
+type APIVersionInfo struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Status  string `json:"status"` // stable, preview or deprecated
+}
+
+// apiVersions lists the API versions in the order they were introduced
+var apiVersions = []APIVersionInfo{
+	{Version: "v1", Path: "/api/v1", Status: "stable"},
+	{Version: "v2", Path: "/api/v2", Status: "preview"},
+}
+
+type APIVersionHandler struct {
+	legacyUsageService *services.LegacyUsageService
+	deprecations       []services.RouteDeprecation
+}
+
+func NewAPIVersionHandler(legacyUsageService *services.LegacyUsageService, deprecations []services.RouteDeprecation) *APIVersionHandler {
+	return &APIVersionHandler{
+		legacyUsageService: legacyUsageService,
+		deprecations:       deprecations,
+	}
+}
+
+// GetVersions lists the available API versions and the deprecated routes with their sunset dates
+func (h *APIVersionHandler) GetVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"versions":     apiVersions,
+		"deprecations": h.deprecations,
+	})
+}
+
+// GetLegacyUsage reports how often the deprecated routes were called in the last ?days=
+// (default 30), per route and across all tenants
+func (h *APIVersionHandler) GetLegacyUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := services.DefaultLegacyUsageDays
+	if daysParam := r.URL.Query().Get("days"); daysParam != "" {
+		parsed, err := strconv.Atoi(daysParam)
+		if err != nil || parsed <= 0 || parsed > services.MaxLegacyUsageDays {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	report, err := h.legacyUsageService.GetUsageReport(h.deprecations, days)
+	if err != nil {
+		http.Error(w, "Failed to get legacy route usage: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
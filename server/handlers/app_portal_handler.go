@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type AppPortalHandler struct {
+	assignmentService  *services.AppAssignmentService
+	launchService      *services.AppLaunchService
+	userService        *services.UserService
+	tenantService      *services.TenantService
+	translationService *services.TranslationService
+}
+
+// CreateLaunchLinkRequest picks what a launch link signs in to. Empty fields take the
+// application's scopes, its first redirect URI and the default lifetime.
+type CreateLaunchLinkRequest struct {
+	Scopes      []string `json:"scopes" validate:"max=50,dive,max=100"`
+	RedirectURI string   `json:"redirect_uri" validate:"max=2000"`
+	RelayState  string   `json:"relay_state" validate:"max=1000"`       // passed to the application as state, e.g. a deep link
+	ExpiresIn   int      `json:"expires_in" validate:"min=0,max=86400"` // seconds
+}
+
+// LaunchLinkResponse is a signed launch link
+type LaunchLinkResponse struct {
+	LaunchURL string    `json:"launch_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PortalTenant describes the tenant the application portal is rendered for
+type PortalTenant struct {
+	ID       string                `json:"id"`
+	Name     string                `json:"name"`
+	Branding models.TenantBranding `json:"branding"`
+}
+
+func NewAppPortalHandler(assignmentService *services.AppAssignmentService, launchService *services.AppLaunchService, userService *services.UserService, tenantService *services.TenantService, translationService *services.TranslationService) *AppPortalHandler {
+	return &AppPortalHandler{
+		assignmentService:  assignmentService,
+		launchService:      launchService,
+		userService:        userService,
+		tenantService:      tenantService,
+		translationService: translationService,
+	}
+}
+
+// GetMyApplications lists the applications the signed-in user can launch from an SSO portal
+func (h *AppPortalHandler) GetMyApplications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userService.GetUserByIDAndTenant(claims.UserID, claims.TenantID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	applications, err := h.assignmentService.UserApplications(user)
+	if err != nil {
+		http.Error(w, "Failed to list applications: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tenant := PortalTenant{ID: claims.TenantID}
+	if t, err := h.tenantService.GetTenantByID(claims.TenantID); err == nil {
+		tenant.Name = t.Name
+		tenant.Branding = t.Settings.CustomBranding
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenant":       tenant,
+		"applications": applications,
+	})
+}
+
+// CreateLaunchLink returns a signed link that signs the signed-in user in to one of their
+// applications, for portal tiles and deep links from the dashboard
+func (h *AppPortalHandler) CreateLaunchLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateLaunchLinkRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	user, err := h.userService.GetUserByIDAndTenant(claims.UserID, claims.TenantID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresIn) * time.Second
+	token, launch, err := h.launchService.CreateLaunch(user, mux.Vars(r)["clientId"], req.Scopes, req.RedirectURI, req.RelayState, ttl)
+	switch {
+	case errors.Is(err, services.ErrAppLaunchNotAllowed):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrAppLaunchScope), errors.Is(err, services.ErrAppLaunchRedirectURI), errors.Is(err, services.ErrAppLaunchPKCE):
+		writeErrorResponse(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	case err != nil:
+		http.Error(w, "Failed to create launch link: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(LaunchLinkResponse{
+		LaunchURL: requestBaseURL(r) + "/tenant/" + claims.TenantID + "/launch?token=" + url.QueryEscape(token),
+		ExpiresAt: launch.ExpiresAt,
+	})
+}
+
+// LaunchApplication follows a launch link: it starts the authorization request the link was
+// signed for, which signs the user in as usual and sends the code to the application
+func (h *AppPortalHandler) LaunchApplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	launch, err := h.launchService.Resolve(tenantID, r.URL.Query().Get("token"))
+	if err != nil {
+		t := h.translationService.LocalizerForRequest(r, tenantID)
+		branding := models.TenantBranding{}
+		if tenant, err := h.tenantService.GetTenantByID(tenantID); err == nil {
+			branding = tenant.Settings.CustomBranding
+		}
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrAppLaunchNotAllowed) {
+			status = http.StatusForbidden
+		}
+		writeErrorPage(w, t, branding, status, err.Error())
+		return
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", launch.ClientID)
+	params.Set("redirect_uri", launch.RedirectURI)
+	params.Set("scope", strings.Join(launch.Scopes, " "))
+	params.Set("login_hint", launch.LoginHint)
+	if launch.RelayState != "" {
+		params.Set("state", launch.RelayState)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, "/tenant/"+tenantID+"/oauth/authorize?"+params.Encode(), http.StatusFound)
+}
@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
 
+	"oauth2-openid-server/i18n"
 	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
 	"oauth2-openid-server/services"
 )
 
@@ -16,12 +22,22 @@ type AuthHandler struct {
 	oauthService      *services.OAuthService
 	socialAuthService *services.SocialAuthService
 	twoFactorService  *services.TwoFactorService
+	cibaService       *services.CIBAService
+	translationService *services.TranslationService
+	auditService       *services.AuditService
+	consentService     *services.ConsentService
+	botProtection      *services.BotProtectionService
+	featureFlags       *services.FeatureFlagService
+	ssoSessions        *services.SSOSessionService
+	cookies            *services.CookieService
 }
 
 type LoginRequest struct {
 	Email                 string `json:"email"`
+	Identifier            string `json:"identifier,omitempty"` // email, username or phone, as the tenant allows; defaults to email
 	Password              string `json:"password"`
 	TwoFACode             string `json:"two_fa_code,omitempty"`
+	CaptchaToken          string `json:"captcha_token,omitempty"` // bot protection challenge response
 	// OAuth PKCE parameters for secure authentication
 	ClientID              string `json:"client_id,omitempty"`
 	RedirectURI           string `json:"redirect_uri,omitempty"`
@@ -38,15 +54,40 @@ type AuthorizeRequest struct {
 	State        string `json:"state"`
 }
 
-func NewAuthHandler(userService *services.UserService, oauthService *services.OAuthService, socialAuthService *services.SocialAuthService, twoFactorService *services.TwoFactorService) *AuthHandler {
+func NewAuthHandler(userService *services.UserService, oauthService *services.OAuthService, socialAuthService *services.SocialAuthService, twoFactorService *services.TwoFactorService, cibaService *services.CIBAService, translationService *services.TranslationService, auditService *services.AuditService, consentService *services.ConsentService, botProtection *services.BotProtectionService, featureFlags *services.FeatureFlagService, ssoSessions *services.SSOSessionService, cookies *services.CookieService) *AuthHandler {
 	return &AuthHandler{
 		userService:       userService,
 		oauthService:      oauthService,
 		socialAuthService: socialAuthService,
 		twoFactorService:  twoFactorService,
+		cibaService:       cibaService,
+		translationService: translationService,
+		auditService:       auditService,
+		consentService:     consentService,
+		botProtection:      botProtection,
+		featureFlags:       featureFlags,
+		ssoSessions:        ssoSessions,
+		cookies:            cookies,
 	}
 }
 
+// recordLogin stores a login_success or login_failure audit event for a login attempt
+func (h *AuthHandler) recordLogin(r *http.Request, tenantID, email string, user *models.User, reason string) {
+	event := &models.AuditEvent{
+		TenantID: tenantID,
+		Type:     models.AuditEventLoginSuccess,
+		Email:    email,
+		Reason:   reason,
+	}
+	if reason != "" {
+		event.Type = models.AuditEventLoginFailure
+	}
+	if user != nil {
+		event.UserID = user.ID.Hex()
+	}
+	h.auditService.RecordRequest(r, event)
+}
+
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -55,45 +96,77 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Get tenant ID from request context
 	tenantID := middleware.GetTenantIDFromRequest(r)
+	t := h.translationService.LocalizerForRequest(r, tenantID)
 	if tenantID == "" {
-		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		http.Error(w, t.T("error.tenant_required"), http.StatusBadRequest)
 		return
 	}
 
 	var loginReq LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&loginReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &loginReq) {
 		return
 	}
 
-	user, err := h.userService.GetUserByEmailAndTenant(loginReq.Email, tenantID)
+	if !checkBotProtection(w, r, h.botProtection, tenantID, models.BotEndpointLogin, loginReq.CaptchaToken) {
+		return
+	}
+
+	if loginReq.Identifier == "" {
+		loginReq.Identifier = loginReq.Email
+	}
+	if loginReq.Email == "" {
+		loginReq.Email = loginReq.Identifier
+	}
+
+	user, err := h.userService.GetUserByLoginIdentifier(loginReq.Identifier, tenantID)
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		h.userService.CheckCredentials(nil, loginReq.Password)
+		h.recordLogin(r, tenantID, loginReq.Email, nil, "unknown_user")
+		http.Error(w, t.T("error.invalid_credentials"), http.StatusUnauthorized)
 		return
 	}
 
-	if !h.userService.ValidatePassword(user, loginReq.Password) {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	if !h.userService.CheckCredentials(user, loginReq.Password) {
+		h.recordLogin(r, tenantID, loginReq.Email, user, "invalid_password")
+		http.Error(w, t.T("error.invalid_credentials"), http.StatusUnauthorized)
 		return
 	}
 
 	if !user.Active {
-		http.Error(w, "Account disabled", http.StatusForbidden)
+		// e.g. account_suspended or account_pending
+		h.recordLogin(r, tenantID, loginReq.Email, user, "account_"+user.LifecycleStatus())
+		// In anti-enumeration mode disabled accounts fail like unknown ones
+		if h.userService.AntiEnumeration(tenantID) {
+			http.Error(w, t.T("error.invalid_credentials"), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, t.T("error.account_disabled"), http.StatusForbidden)
 		return
 	}
 
-	// Check if 2FA is required
-	twoFactorRequired, err := h.twoFactorService.IsTwoFactorRequired(user.ID.Hex())
+	// Check if 2FA is required by the client, the user's groups, the tenant or the user
+	mfa, err := h.twoFactorService.EvaluateMFAPolicy(user.ID.Hex(), loginReq.ClientID)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, t.T("error.internal"), http.StatusInternalServerError)
 		return
 	}
+	twoFactorRequired := mfa.Required
 
+	acr := services.ACRSingleFactor
 	if twoFactorRequired {
+		if !mfa.Enrolled {
+			h.recordLogin(r, tenantID, loginReq.Email, user, "mfa_enrollment_required")
+			http.Error(w, t.T("error.mfa_enrollment_required"), http.StatusForbidden)
+			return
+		}
+
 		if loginReq.TwoFACode == "" {
 			// First step: credentials verified, but 2FA required
+			methods, _ := h.twoFactorService.TwoFactorMethods(user.ID.Hex())
 			response := map[string]interface{}{
 				"two_factor_required": true,
+				"two_factor_methods": methods, // "totp" and/or "sms" (request a code from /api/v1/2fa/sms/send)
+				"mfa_policy":         mfa,
 				"user_id":            user.ID.Hex(),
 				"message":            "Two-factor authentication required",
 			}
@@ -105,15 +178,25 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		// Second step: verify 2FA code
 		valid, err := h.twoFactorService.VerifyTwoFactor(user.ID.Hex(), loginReq.TwoFACode)
 		if err != nil || !valid {
-			http.Error(w, "Invalid two-factor authentication code", http.StatusUnauthorized)
+			h.recordLogin(r, tenantID, loginReq.Email, user, "invalid_two_factor")
+			http.Error(w, t.T("error.invalid_two_factor"), http.StatusUnauthorized)
 			return
 		}
+		acr = services.ACRMultiFactor
 	}
 
+	h.recordLogin(r, tenantID, loginReq.Email, user, "")
+
 	// Check if PKCE parameters are provided for secure OAuth flow
 	if loginReq.ClientID != "" && loginReq.RedirectURI != "" && loginReq.CodeChallenge != "" {
+		if !h.oauthService.HasApplicationAccess(loginReq.ClientID, user) {
+			http.Error(w, t.T("error.application_access_denied"), http.StatusForbidden)
+			return
+		}
+		h.oauthService.RecordClientEvent(loginReq.ClientID, services.ClientMetricLogins)
+
 		// Use PKCE OAuth flow - generate authorization code
-		scopes := []string{"read", "openid", "profile", "email"}
+		scopes := h.oauthService.DefaultScopes(tenantID, services.DefaultScopesDirectLogin)
 		if len(user.Scopes) > 0 {
 			scopes = user.Scopes // Use user's actual scopes
 		}
@@ -126,17 +209,26 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 			scopes,
 			loginReq.CodeChallenge,
 			loginReq.CodeChallengeMethod,
+			services.RequestCodeBinding(r),
+			acr,
 		)
+		if errors.Is(err, services.ErrPKCERequired) || errors.Is(err, services.ErrPKCEMethodNotAllowed) || errors.Is(err, services.ErrInvalidCodeChallenge) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Failed to create authorization code", http.StatusInternalServerError)
 			return
 		}
 
+		// The browser stays signed in, so the client can renew its tokens with prompt=none
+		h.startSSOSession(w, r, tenantID, user.ID.Hex(), acr, loginReq.ClientID)
+
 		response := map[string]interface{}{
 			"user_id": user.ID.Hex(),
 			"email":   user.Email,
 			"scopes":  user.Scopes,
-			"groups":  user.Groups,
+			"groups":  h.userService.GetGroupNames(user),
 			"two_factor_verified": twoFactorRequired,
 			"code":    authCode,  // Return authorization code for PKCE flow
 			"state":   loginReq.State,
@@ -148,7 +240,10 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fallback: Generate OAuth tokens for backward compatibility
-	tokens, err := h.oauthService.GenerateDirectLoginTokens(user.ID.Hex(), tenantID, user.Scopes, r)
+	tokens, err := h.oauthService.GenerateDirectLoginTokens(user.ID.Hex(), tenantID, user.Scopes, acr, r)
+	if writeQuotaExceeded(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to generate authentication tokens", http.StatusInternalServerError)
 		return
@@ -158,7 +253,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		"user_id": user.ID.Hex(),
 		"email":   user.Email,
 		"scopes":  user.Scopes,
-		"groups":  user.Groups,
+		"groups":  h.userService.GetGroupNames(user),
 		"two_factor_verified": twoFactorRequired,
 		"tokens": tokens,
 	}
@@ -180,12 +275,14 @@ func (h *AuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 
 	// Get tenant ID from request context
 	tenantID := middleware.GetTenantIDFromRequest(r)
+	t := h.translationService.LocalizerForRequest(r, tenantID)
 	if tenantID == "" {
-		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		writeErrorPage(w, t, models.TenantBranding{}, http.StatusBadRequest, t.T("error.tenant_required"))
 		return
 	}
 
 	clientID := r.FormValue("client_id")
+	middleware.SetAccessLogClient(r, clientID)
 	redirectURI := r.FormValue("redirect_uri")
 	responseType := r.FormValue("response_type")
 	scope := r.FormValue("scope")
@@ -194,29 +291,71 @@ func (h *AuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 	codeChallenge := r.FormValue("code_challenge")
 	codeChallengeMethod := r.FormValue("code_challenge_method")
 
+	// Errors about the client or redirect URI are shown to the user; everything after that is
+	// reported back to the client
+	if err := h.oauthService.ValidateAuthorizeClient(clientID, redirectURI, tenantID); err != nil {
+		writeErrorPage(w, t, h.oauthService.TenantBranding(tenantID), http.StatusBadRequest, t.T("error.invalid_redirect_uri"))
+		return
+	}
+
 	if responseType != "code" {
-		http.Error(w, "Unsupported response type", http.StatusBadRequest)
+		redirectAuthorizeError(w, r, redirectURI, state, "unsupported_response_type", t.T("error.unsupported_response_type"))
+		return
+	}
+	if err := h.oauthService.ValidateCodeChallenge(clientID, tenantID, codeChallenge, codeChallengeMethod); err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "invalid_request", err.Error())
 		return
 	}
 
-	requestedScopes := strings.Fields(scope)
+	requestedScopes, err := h.oauthService.RequestedScopes(tenantID, clientID, scope)
+	if err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "invalid_scope", err.Error())
+		return
+	}
+	h.oauthService.RecordRequestedScopes(tenantID, clientID, requestedScopes)
 
 	// Get user's actual permissions from database within tenant context
 	user, err := h.userService.GetUserByIDAndTenant(userID, tenantID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
+		redirectAuthorizeError(w, r, redirectURI, state, "access_denied", t.T("error.user_not_found"))
+		return
+	}
+
+	if !h.oauthService.HasApplicationAccess(clientID, user) {
+		writeErrorPage(w, t, h.oauthService.TenantBranding(tenantID), http.StatusForbidden, t.T("error.application_access_denied"))
 		return
 	}
 
+	// This page only collects a password, so it can't satisfy a multi-factor requirement; such
+	// logins have to go through the authorization flow API
+	mfa, err := h.twoFactorService.EvaluateMFAPolicy(userID, clientID)
+	if err != nil || mfa.Required {
+		redirectAuthorizeError(w, r, redirectURI, state, "interaction_required", "Multi-factor authentication is required for this sign-in")
+		return
+	}
+	h.oauthService.RecordClientEvent(clientID, services.ClientMetricLogins)
+
 	// Only grant scopes that the user actually has permission for
-	var grantedScopes []string
-	for _, requestedScope := range requestedScopes {
-		for _, userScope := range user.Scopes {
-			if requestedScope == userScope {
-				grantedScopes = append(grantedScopes, requestedScope)
-				break
+	grantedScopes := userGrantedScopes(requestedScopes, user)
+
+	// Apply the user's consent choices: from the consent form if it was submitted, otherwise the
+	// scopes the user declined earlier for this client stay declined
+	if r.FormValue("consent_form") != "" {
+		approvedScopes := append([]string{}, r.Form["approved_scope"]...)
+		var declinedClaims []string
+		for _, claim := range services.OptionalClaims {
+			if !containsValue(r.Form["share_claim"], claim) {
+				declinedClaims = append(declinedClaims, claim)
 			}
 		}
+		decision := services.ResolveConsent(grantedScopes, approvedScopes, declinedClaims)
+		if err := h.consentService.GrantConsent(userID, clientID, tenantID, decision); err != nil {
+			log.Printf("Warning: Failed to record consent of user %s for client %s: %v", userID, clientID, err)
+		}
+		h.oauthService.RecordClientEvent(clientID, services.ClientMetricConsents)
+		grantedScopes = decision.ApprovedScopes
+	} else {
+		grantedScopes = h.consentService.FilterGranted(userID, clientID, tenantID, grantedScopes)
 	}
 
 	// If no valid scopes, grant minimal read access
@@ -224,15 +363,18 @@ func (h *AuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		grantedScopes = []string{"read"}
 	}
 
-	code, err := h.oauthService.CreateAuthorizationCode(clientID, userID, tenantID, redirectURI, grantedScopes, codeChallenge, codeChallengeMethod)
+	code, err := h.oauthService.CreateAuthorizationCode(clientID, userID, tenantID, redirectURI, grantedScopes, codeChallenge, codeChallengeMethod, services.RequestCodeBinding(r), services.ACRSingleFactor)
 	if err != nil {
-		http.Error(w, "Failed to create authorization code", http.StatusInternalServerError)
+		redirectAuthorizeError(w, r, redirectURI, state, "server_error", "Failed to create authorization code")
 		return
 	}
 
+	// The browser stays signed in, so clients can renew tokens with prompt=none
+	h.startSSOSession(w, r, tenantID, userID, services.ACRSingleFactor, "")
+
 	redirectURL, err := url.Parse(redirectURI)
 	if err != nil {
-		http.Error(w, "Invalid redirect URI", http.StatusBadRequest)
+		http.Error(w, t.T("error.invalid_redirect_uri"), http.StatusBadRequest)
 		return
 	}
 
@@ -254,38 +396,56 @@ func (h *AuthHandler) showAuthorizePage(w http.ResponseWriter, r *http.Request)
 	codeChallenge := r.URL.Query().Get("code_challenge")
 	codeChallengeMethod := r.URL.Query().Get("code_challenge_method")
 
-	// Get enabled social providers
-	tenantID := "" // Default tenant for auth handler
-	enabledProviders := h.socialAuthService.GetEnabledProviders(tenantID)
-	socialButtons := ""
-	
-	for _, provider := range enabledProviders {
-		providerURL := fmt.Sprintf("/auth/%s/oauth?client_id=%s&redirect_uri=%s&scope=%s&state=%s&code_challenge=%s&code_challenge_method=%s",
-			provider, clientID, redirectURI, scope, state, codeChallenge, codeChallengeMethod)
-		
-		var buttonClass, buttonText string
-		switch provider {
-		case "google":
-			buttonClass = "google-btn"
-			buttonText = "Continue with Google"
-		case "github":
-			buttonClass = "github-btn"
-			buttonText = "Continue with GitHub"
-		case "facebook":
-			buttonClass = "facebook-btn"
-			buttonText = "Continue with Facebook"
-		case "apple":
-			buttonClass = "apple-btn"
-			buttonText = "Continue with Apple"
-		default:
-			buttonClass = "social-btn"
-			buttonText = "Continue with " + provider
-		}
-		
-		socialButtons += fmt.Sprintf(`
-			<a href="%s" class="social-button %s">%s</a>
-		`, providerURL, buttonClass, buttonText)
+	requestTenantID := middleware.GetTenantIDFromRequest(r)
+	t := h.translationService.LocalizerForRequest(r, requestTenantID)
+	if err := h.oauthService.ValidateAuthorizeClient(clientID, redirectURI, requestTenantID); err != nil {
+		writeErrorPage(w, t, h.oauthService.TenantBranding(requestTenantID), http.StatusBadRequest, t.T("error.invalid_redirect_uri"))
+		return
+	}
+	if r.URL.Query().Get("response_type") != "code" {
+		redirectAuthorizeError(w, r, redirectURI, state, "unsupported_response_type", t.T("error.unsupported_response_type"))
+		return
+	}
+	if err := h.oauthService.ValidateCodeChallenge(clientID, requestTenantID, codeChallenge, codeChallengeMethod); err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "invalid_request", err.Error())
+		return
+	}
+	if _, err := h.oauthService.RequestedScopes(requestTenantID, clientID, scope); err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "invalid_scope", err.Error())
+		return
 	}
+	h.oauthService.RecordClientEvent(clientID, services.ClientMetricAuthorizeRequests)
+
+	// prompt=none renews silently from the SSO session, or reports why the user has to interact
+	if r.URL.Query().Get("prompt") == "none" {
+		h.silentAuthorize(w, r, requestTenantID)
+		return
+	}
+
+	client, _ := h.oauthService.AuthorizeClient(clientID, requestTenantID)
+
+	// Social login buttons of the tenant the page is shown for
+	hint := loginHint(r)
+	socialParams := url.Values{
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {scope},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {codeChallengeMethod},
+	}
+	if hint != "" {
+		socialParams.Set("login_hint", hint)
+	}
+	providers := h.socialAuthService.GetEnabledProviderConfigs(requestTenantID)
+
+	// An idp_hint naming one of the tenant's providers skips the login form, e.g. for B2B SSO
+	if provider, ok := idpHintProvider(r, providers); ok {
+		http.Redirect(w, r, socialLoginURL(socialAuthBasePath(r), provider, socialParams), http.StatusFound)
+		return
+	}
+
+	socialButtons := socialLoginButtons(t, providers, socialAuthBasePath(r), socialParams, h.oauthService.TenantBranding(requestTenantID))
 
 	socialSection := ""
 	if socialButtons != "" {
@@ -293,14 +453,15 @@ func (h *AuthHandler) showAuthorizePage(w http.ResponseWriter, r *http.Request)
 		<div class="social-section">
 			%s
 		</div>
-		<div class="divider">or sign in with email</div>`, socialButtons)
+		<div class="divider">%s</div>`, socialButtons, html.EscapeString(t.T("page.authorize.divider")))
 	}
 
-	html := fmt.Sprintf(`
+	page := fmt.Sprintf(`
 <!DOCTYPE html>
-<html>
+<html lang="%s">
 <head>
-    <title>OAuth2 Authorization</title>
+    <meta charset="utf-8">
+    <title>%s</title>
     <style>
         body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; max-width: 400px; margin: 50px auto; padding: 20px; background: #f5f5f5; }
         .container { background: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
@@ -322,27 +483,34 @@ func (h *AuthHandler) showAuthorizePage(w http.ResponseWriter, r *http.Request)
         .button-group button { flex: 1; }
         .deny-btn { background: #dc3545; }
         .deny-btn:hover { background: #c82333; }
+        .client-logo { max-height: 48px; max-width: 160px; margin-bottom: 8px; }
+        .client-links { font-size: 13px; color: #666; }
     </style>
 </head>
 <body>
     <div class="container">
-        <h2>Authorization Required</h2>
-        <p>Application is requesting access to your account.</p>
+        <h2>%s</h2>
+        %s
         
         <div class="scopes">
-            <strong>Requested permissions:</strong><br>
+            <strong>%s</strong><br>
+            %s
+        </div>
+
+        <div class="scopes">
+            <strong>%s</strong><br>
             %s
         </div>
 
         %s
 
-        <form method="post">
+        <form method="post" id="authorize-form">
             <div class="form-group">
-                <label for="email">Email:</label>
-                <input type="email" id="email" name="email" required>
+                <label for="email">%s</label>
+                <input type="text" id="email" name="email" value="%s" autocomplete="username" required>
             </div>
             <div class="form-group">
-                <label for="password">Password:</label>
+                <label for="password">%s</label>
                 <input type="password" id="password" name="password" required>
             </div>
             
@@ -354,10 +522,11 @@ func (h *AuthHandler) showAuthorizePage(w http.ResponseWriter, r *http.Request)
             <input type="hidden" name="code_challenge" value="%s">
             <input type="hidden" name="code_challenge_method" value="%s">
             <input type="hidden" name="user_id" id="user_id">
+            <input type="hidden" name="consent_form" value="1">
             
             <div class="button-group">
-                <button type="button" onclick="authorize()">Authorize</button>
-                <button type="button" onclick="deny()" class="deny-btn">Deny</button>
+                <button type="button" onclick="authorize()">%s</button>
+                <button type="button" onclick="deny()" class="deny-btn">%s</button>
             </div>
         </form>
 
@@ -367,7 +536,7 @@ func (h *AuthHandler) showAuthorizePage(w http.ResponseWriter, r *http.Request)
             const password = document.getElementById('password').value;
             
             if (!email || !password) {
-                alert('Please enter email and password');
+                alert(%s);
                 return;
             }
 
@@ -385,10 +554,10 @@ func (h *AuthHandler) showAuthorizePage(w http.ResponseWriter, r *http.Request)
                     document.getElementById('user_id').value = userData.user_id;
                     document.querySelector('form').submit();
                 } else {
-                    alert('Invalid credentials');
+                    alert(%s);
                 }
             } catch (error) {
-                alert('Login failed');
+                alert(%s);
             }
         }
 
@@ -403,13 +572,91 @@ func (h *AuthHandler) showAuthorizePage(w http.ResponseWriter, r *http.Request)
     </div>
 </body>
 </html>`,
-        scope,
+        t.Locale(), html.EscapeString(t.T("page.authorize.title")),
+        html.EscapeString(t.T("page.authorize.heading")), consentClientInfo(t, client), html.EscapeString(t.T("page.authorize.requested_permissions")),
+        consentScopeOptions(services.ParseScopes(scope)),
+        html.EscapeString(t.T("page.authorize.share_information")),
+        consentClaimOptions(t),
         socialSection,
+        html.EscapeString(t.T("page.authorize.email")), html.EscapeString(hint), html.EscapeString(t.T("page.authorize.password")),
         clientID, redirectURI, scope, state, codeChallenge, codeChallengeMethod,
+        html.EscapeString(t.T("page.authorize.authorize")), html.EscapeString(t.T("page.authorize.deny")),
+        jsString(t.T("page.authorize.missing_credentials")), jsString(t.T("error.invalid_credentials")), jsString(t.T("page.authorize.login_failed")),
         redirectURI, state)
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(html))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", t.Locale())
+	w.Write([]byte(page))
+}
+
+// consentClientInfo tells the user who is requesting access: the client's logo, its name linked
+// to its home page, and its privacy policy and terms of service. The URLs were validated as http(s)
+// URLs when the client was saved.
+func consentClientInfo(t *i18n.Localizer, client *models.Client) string {
+	if client == nil {
+		return "<p>" + html.EscapeString(t.T("page.authorize.intro")) + "</p>"
+	}
+
+	name := "<strong>" + html.EscapeString(client.Name) + "</strong>"
+	if client.ClientURI != "" {
+		name = fmt.Sprintf(`<a href="%s" target="_blank" rel="noopener noreferrer">%s</a>`, html.EscapeString(client.ClientURI), name)
+	}
+	// The name is placed after escaping the sentence, so the link markup survives
+	info := "<p>" + strings.Replace(html.EscapeString(t.T("page.consent.heading", "\x00")), "\x00", name, 1) + "</p>"
+	if client.LogoURI != "" {
+		info = fmt.Sprintf(`<img src="%s" alt="" class="client-logo">`, html.EscapeString(client.LogoURI)) + info
+	}
+
+	var links []string
+	if client.PolicyURI != "" {
+		links = append(links, fmt.Sprintf(`<a href="%s" target="_blank" rel="noopener noreferrer">%s</a>`, html.EscapeString(client.PolicyURI), html.EscapeString(t.T("page.consent.privacy_policy"))))
+	}
+	if client.TOSURI != "" {
+		links = append(links, fmt.Sprintf(`<a href="%s" target="_blank" rel="noopener noreferrer">%s</a>`, html.EscapeString(client.TOSURI), html.EscapeString(t.T("page.consent.terms_of_service"))))
+	}
+	if len(links) > 0 {
+		info += `<p class="client-links">` + strings.Join(links, " · ") + "</p>"
+	}
+	return info
+}
+
+// consentScopeOptions renders a checkbox per requested scope; required scopes cannot be unchecked
+func consentScopeOptions(scopes []string) string {
+	options := ""
+	for _, scope := range scopes {
+		escaped := html.EscapeString(scope)
+		if containsValue(services.RequiredConsentScopes, scope) {
+			options += fmt.Sprintf(`<label><input type="checkbox" checked disabled> %s</label>`, escaped)
+			continue
+		}
+		options += fmt.Sprintf(`<label><input type="checkbox" name="approved_scope" value="%s" form="authorize-form" checked> %s</label>`, escaped, escaped)
+	}
+	return options
+}
+
+// consentClaimOptions renders a checkbox per optional claim the user can withhold
+func consentClaimOptions(t *i18n.Localizer) string {
+	options := ""
+	for _, claim := range services.OptionalClaims {
+		options += fmt.Sprintf(`<label><input type="checkbox" name="share_claim" value="%s" form="authorize-form" checked> %s</label>`,
+			claim, html.EscapeString(t.T("page.authorize.claim."+claim)))
+	}
+	return options
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// jsString encodes a value as a JavaScript string literal that is safe to embed in a script block
+func jsString(value string) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
 }
 
 func (h *AuthHandler) Token(w http.ResponseWriter, r *http.Request) {
@@ -418,36 +665,180 @@ func (h *AuthHandler) Token(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	grantType := r.FormValue("grant_type")
-	if grantType != "authorization_code" {
-		http.Error(w, "Unsupported grant type", http.StatusBadRequest)
+	// Every token request counts against the client's rate limit, failed ones included
+	if clientID, _, _, err := clientCredentials(r); err == nil && writeClientRateLimited(w, h.oauthService.ReserveTokenRequest(clientID)) {
 		return
 	}
 
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		h.handleAuthorizationCodeGrant(w, r)
+	case "refresh_token":
+		h.handleRefreshTokenGrant(w, r)
+	case services.CIBAGrantType:
+		if !featureEnabled(h.featureFlags, services.FeatureCIBA, middleware.GetTenantIDFromRequest(r)) {
+			writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "The CIBA grant is not enabled for this tenant")
+			return
+		}
+		h.handleCIBAGrant(w, r)
+	default:
+		t := h.translationService.LocalizerForRequest(r, middleware.GetTenantIDFromRequest(r))
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", t.T("error.unsupported_grant_type"))
+	}
+}
+
+// ClientSigningKey returns the symmetric key a confidential client verifies its HS256 ID tokens
+// with, as an "oct" JWK. The client authenticates with client_secret_basic or client_secret_post.
+func (h *AuthHandler) ClientSigningKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID, clientSecret, basic, err := clientCredentials(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, err := h.oauthService.ValidateClient(clientID, clientSecret)
+	if err != nil {
+		writeInvalidClient(w, basic, err.Error())
+		return
+	}
+
+	key, keyID, err := h.oauthService.ClientSigningKey(client)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to load signing key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(JWK{
+		Kty: "oct",
+		Use: "sig",
+		Alg: "HS256",
+		Kid: keyID,
+		K:   base64.RawURLEncoding.EncodeToString(key),
+	})
+}
+
+// handleAuthorizationCodeGrant exchanges an authorization code for tokens
+func (h *AuthHandler) handleAuthorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
-	clientID := r.FormValue("client_id")
-	clientSecret := r.FormValue("client_secret")
+	clientID, clientSecret, basic, err := clientCredentials(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
 	codeVerifier := r.FormValue("code_verifier")
 	redirectURI := r.FormValue("redirect_uri")
+	if code == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "code is required")
+		return
+	}
 
 	var tokenResponse *services.TokenResponse
-	var err error
+	tenantID := middleware.GetExplicitTenantID(r)
 
-	// Support both PKCE (code_verifier) and traditional (client_secret) flows
-	if codeVerifier != "" {
-		tokenResponse, err = h.oauthService.ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, redirectURI, r)
+	// Support both PKCE (code_verifier) and traditional (client_secret) flows. A secret sent along
+	// with a verifier must be valid too; public clients may not send one at all.
+	if codeVerifier != "" && clientSecret != "" {
+		if _, err = h.oauthService.ValidateClient(clientID, clientSecret); err == nil {
+			tokenResponse, err = h.oauthService.ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, redirectURI, tenantID, r)
+		}
+	} else if codeVerifier != "" {
+		tokenResponse, err = h.oauthService.ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, redirectURI, tenantID, r)
 	} else if clientSecret != "" {
-		tokenResponse, err = h.oauthService.ExchangeCodeForTokens(code, clientID, clientSecret, redirectURI, r)
+		tokenResponse, err = h.oauthService.ExchangeCodeForTokens(code, clientID, clientSecret, redirectURI, tenantID, r)
 	} else {
 		// Handle direct social login without client_secret or code_verifier
 		// This is for authorization codes created by the social auth handler
-		tokenResponse, err = h.oauthService.ExchangeCodeForTokensDirectSocialLogin(code, clientID, redirectURI, r)
+		tokenResponse, err = h.oauthService.ExchangeCodeForTokensDirectSocialLogin(code, clientID, redirectURI, tenantID, r)
+	}
+	if writeOAuthQuotaExceeded(w, err) || writeClientRateLimited(w, err) {
+		return
+	}
+	if err != nil && isClientAuthError(err) {
+		writeInvalidClient(w, basic, err.Error())
+		return
+	}
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokenResponse)
+}
+
+// handleRefreshTokenGrant exchanges a refresh token for a new access token
+func (h *AuthHandler) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.FormValue("refresh_token")
+	if refreshToken == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		return
+	}
+
+	clientID, clientSecret, basic, err := clientCredentials(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	tokenResponse, err := h.oauthService.RefreshTokens(refreshToken, clientID, clientSecret, r.FormValue("scope"), middleware.GetExplicitTenantID(r), r)
+	if writeOAuthQuotaExceeded(w, err) || writeClientRateLimited(w, err) {
+		return
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		switch {
+		case isClientAuthError(err):
+			writeInvalidClient(w, basic, err.Error())
+		case err.Error() == "requested scope exceeds original grant":
+			writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		default:
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokenResponse)
+}
+
+// handleCIBAGrant lets a client poll for the result of a backchannel authentication request
+func (h *AuthHandler) handleCIBAGrant(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, basic, err := clientCredentials(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if _, err := h.oauthService.ValidateClient(clientID, clientSecret); err != nil {
+		writeInvalidClient(w, basic, "Client authentication failed")
+		return
+	}
+
+	tokenResponse, err := h.cibaService.PollToken(r.FormValue("auth_req_id"), clientID, middleware.GetExplicitTenantID(r), r)
+	if writeOAuthQuotaExceeded(w, err) || writeClientRateLimited(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrAuthorizationPending), errors.Is(err, services.ErrSlowDown),
+		errors.Is(err, services.ErrAccessDenied), errors.Is(err, services.ErrExpiredToken):
+		writeOAuthError(w, http.StatusBadRequest, err.Error(), "")
+		return
+	case errors.Is(err, services.ErrInvalidAuthReqID), errors.Is(err, services.ErrTenantMismatch), errors.Is(err, services.ErrUserDeactivated):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	case err != nil:
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to poll the authentication request")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	json.NewEncoder(w).Encode(tokenResponse)
-}
\ No newline at end of file
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// csrfHeader carries the per-step CSRF token issued with every flow response
+const csrfHeader = "X-CSRF-Token"
+
+type AuthorizeFlowHandler struct {
+	flowService   *services.AuthorizeFlowService
+	botProtection *services.BotProtectionService
+	ssoSessions   *services.SSOSessionService
+	cookies       *services.CookieService
+}
+
+type StartAuthorizeFlowRequest struct {
+	ClientID            string `json:"client_id" validate:"required"`
+	RedirectURI         string `json:"redirect_uri" validate:"required,url"`
+	ResponseType        string `json:"response_type" validate:"oneof=code"`
+	Scope               string `json:"scope" validate:"max=2000"`
+	State               string `json:"state" validate:"max=2000"`
+	CodeChallenge       string `json:"code_challenge" validate:"max=128"`
+	CodeChallengeMethod string `json:"code_challenge_method" validate:"oneof=plain S256"`
+}
+
+type FlowCredentialsRequest struct {
+	Email    string `json:"email" validate:"required,max=254"` // or username/phone, as the tenant allows
+	Password string `json:"password" validate:"required,max=72"`
+	// CaptchaToken is the bot protection challenge response
+	CaptchaToken string `json:"captcha_token,omitempty" validate:"max=4096"`
+}
+
+type FlowTwoFactorRequest struct {
+	Code string `json:"code" validate:"required,max=16"`
+}
+
+// FlowConsentRequest is the user's consent decision. Scopes, when present, lists the optional
+// scopes the user approved; omitted scopes are declined. DeclinedClaims withholds individual claims.
+type FlowConsentRequest struct {
+	Approve        bool     `json:"approve"`
+	Scopes         []string `json:"scopes,omitempty" validate:"max=100"`
+	DeclinedClaims []string `json:"declined_claims,omitempty" validate:"dive,oneof=email name groups"`
+}
+
+type AuthorizeFlowResponse struct {
+	FlowID          string    `json:"flow_id"`
+	Step            string    `json:"step"`
+	CSRFToken       string    `json:"csrf_token,omitempty"`
+	ClientID        string    `json:"client_id"`
+	ClientName      string    `json:"client_name"`
+	RequestedScopes []string  `json:"requested_scopes"`
+	GrantedScopes   []string  `json:"granted_scopes,omitempty"`
+	OptionalScopes  []string  `json:"optional_scopes,omitempty"`
+	OptionalClaims  []string  `json:"optional_claims,omitempty"`
+	RedirectTo      string    `json:"redirect_to,omitempty"`
+	ExpiresAt       time.Time `json:"expires_at"`
+
+	models.ClientMetadata // logo and links of the client, for the consent step
+}
+
+func NewAuthorizeFlowHandler(flowService *services.AuthorizeFlowService, botProtection *services.BotProtectionService, ssoSessions *services.SSOSessionService, cookies *services.CookieService) *AuthorizeFlowHandler {
+	return &AuthorizeFlowHandler{
+		flowService:   flowService,
+		botProtection: botProtection,
+		ssoSessions:   ssoSessions,
+		cookies:       cookies,
+	}
+}
+
+// StartFlow begins a headless authorization flow and returns the first step
+func (h *AuthorizeFlowHandler) StartFlow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+
+	var req StartAuthorizeFlowRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.ResponseType == "" {
+		req.ResponseType = "code"
+	}
+
+	flow, err := h.flowService.StartFlow(tenantID, req.ClientID, req.RedirectURI, req.ResponseType, req.Scope, req.State, req.CodeChallenge, req.CodeChallengeMethod, services.RequestCodeBinding(r))
+	if err != nil {
+		http.Error(w, "Failed to start authorization flow: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.writeStep(w, r, flow)
+}
+
+// GetFlow returns the current step of a flow (without a CSRF token)
+func (h *AuthorizeFlowHandler) GetFlow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flow, err := h.flowService.GetFlow(mux.Vars(r)["flowId"], middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		h.writeFlowError(w, err)
+		return
+	}
+
+	h.writeFlow(w, flow, false)
+}
+
+// SubmitCredentials handles the login identifier/password step
+func (h *AuthorizeFlowHandler) SubmitCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FlowCredentialsRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if !checkBotProtection(w, r, h.botProtection, tenantID, models.BotEndpointLogin, req.CaptchaToken) {
+		return
+	}
+
+	flow, err := h.flowService.SubmitCredentials(mux.Vars(r)["flowId"], tenantID, r.Header.Get(csrfHeader), req.Email, req.Password)
+	if err != nil {
+		h.writeFlowError(w, err)
+		return
+	}
+
+	h.writeStep(w, r, flow)
+}
+
+// SendTwoFactorSMS texts a sign-in code to the user during the two-factor step
+func (h *AuthorizeFlowHandler) SendTwoFactorSMS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := h.flowService.SendTwoFactorSMS(mux.Vars(r)["flowId"], middleware.GetTenantIDFromRequest(r), r.Header.Get(csrfHeader))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+	case services.ErrFlowNotFound, services.ErrFlowExpired, services.ErrInvalidCSRFToken, services.ErrInvalidFlowStep:
+		h.writeFlowError(w, err)
+	default:
+		writeSMSOTPError(w, err)
+	}
+}
+
+// SubmitTwoFactor handles the two-factor authentication step
+func (h *AuthorizeFlowHandler) SubmitTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FlowTwoFactorRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	flow, err := h.flowService.SubmitTwoFactor(mux.Vars(r)["flowId"], middleware.GetTenantIDFromRequest(r), r.Header.Get(csrfHeader), req.Code)
+	if err != nil {
+		h.writeFlowError(w, err)
+		return
+	}
+
+	h.writeStep(w, r, flow)
+}
+
+// SubmitConsent handles the consent step and returns the client redirect
+func (h *AuthorizeFlowHandler) SubmitConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FlowConsentRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	flow, err := h.flowService.SubmitConsent(mux.Vars(r)["flowId"], middleware.GetTenantIDFromRequest(r), r.Header.Get(csrfHeader), req.Approve, req.Scopes, req.DeclinedClaims)
+	if err != nil {
+		h.writeFlowError(w, err)
+		return
+	}
+
+	h.writeStep(w, r, flow)
+}
+
+// writeStep answers a submitted step. Completing the flow signs the browser in, so clients can
+// renew tokens with prompt=none.
+func (h *AuthorizeFlowHandler) writeStep(w http.ResponseWriter, r *http.Request, flow *models.AuthorizeFlow) {
+	if flow.Step == models.AuthorizeFlowStepComplete {
+		startSSOSession(w, r, h.ssoSessions, h.cookies, flow.TenantID, flow.UserID, flow.ACR, "")
+	}
+	h.writeFlow(w, flow, true)
+}
+
+func (h *AuthorizeFlowHandler) writeFlow(w http.ResponseWriter, flow *models.AuthorizeFlow, includeCSRF bool) {
+	response := AuthorizeFlowResponse{
+		FlowID:          flow.FlowID,
+		Step:            flow.Step,
+		ClientID:        flow.ClientID,
+		ClientName:      flow.ClientName,
+		ClientMetadata:  flow.ClientMetadata,
+		RequestedScopes: flow.RequestedScopes,
+		GrantedScopes:   flow.GrantedScopes,
+		RedirectTo:      flow.RedirectTo,
+		ExpiresAt:       flow.ExpiresAt,
+	}
+	if includeCSRF && flow.Step != models.AuthorizeFlowStepComplete {
+		response.CSRFToken = flow.CSRFToken
+	}
+	if flow.Step == models.AuthorizeFlowStepConsent {
+		response.OptionalScopes = services.OptionalConsentScopes(flow.GrantedScopes)
+		response.OptionalClaims = services.OptionalClaims
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *AuthorizeFlowHandler) writeFlowError(w http.ResponseWriter, err error) {
+	switch err {
+	case services.ErrFlowNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case services.ErrFlowExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+	case services.ErrInvalidCSRFToken:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case services.ErrInvalidFlowStep:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case services.ErrInvalidCredentials, services.ErrInvalidTwoFactor:
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case services.ErrTooManyAttempts:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case services.ErrMFAEnrollmentRequired, services.ErrApplicationAccessDenied:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Authorization flow failed: "+err.Error(), http.StatusInternalServerError)
+	}
+}
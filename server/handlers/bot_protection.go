@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+	"oauth2-openid-server/validation"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// captchaTokenHeader carries the challenge response for clients that can't add it to the body
+	captchaTokenHeader = "X-Captcha-Token"
+	// bypassKeyHeader carries a tenant's bot protection bypass key
+	bypassKeyHeader = "X-Bot-Bypass-Key"
+)
+
+// BotProtectionHandler manages the tenant's bot protection policy and bypass keys and hands
+// challenges to login and registration pages
+type BotProtectionHandler struct {
+	botProtection *services.BotProtectionService
+}
+
+// UpdateBotProtectionRequest replaces the tenant's bot protection policy. An empty secret_key
+// keeps the stored one.
+type UpdateBotProtectionRequest struct {
+	Provider      string   `json:"provider" validate:"oneof=recaptcha hcaptcha turnstile pow"`
+	SiteKey       string   `json:"site_key" validate:"max=200"`
+	SecretKey     string   `json:"secret_key" validate:"max=500"`
+	Endpoints     []string `json:"endpoints" validate:"dive,oneof=login register"`
+	MinScore      float64  `json:"min_score"`
+	PoWDifficulty int      `json:"pow_difficulty" validate:"min=0,max=28"`
+}
+
+type CreateBypassKeyRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// CreateBypassKeyResponse is the only response that includes the bypass key itself
+type CreateBypassKeyResponse struct {
+	*models.BotBypassKey
+	Key string `json:"key"`
+}
+
+func NewBotProtectionHandler(botProtection *services.BotProtectionService) *BotProtectionHandler {
+	return &BotProtectionHandler{
+		botProtection: botProtection,
+	}
+}
+
+// checkBotProtection enforces the tenant's bot protection on a public endpoint. The challenge
+// response comes from the body (token) or the X-Captcha-Token header. It writes a 403 (or 503 when
+// the CAPTCHA provider can't be asked) and returns false when the request doesn't pass.
+func checkBotProtection(w http.ResponseWriter, r *http.Request, botProtection *services.BotProtectionService, tenantID, endpoint, token string) bool {
+	if botProtection == nil {
+		return true
+	}
+	if token == "" {
+		token = r.Header.Get(captchaTokenHeader)
+	}
+
+	err := botProtection.Verify(tenantID, endpoint, services.BotCheck{
+		Token:     token,
+		BypassKey: r.Header.Get(bypassKeyHeader),
+		RemoteIP:  services.ClientIP(r),
+	})
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, services.ErrBotCheckFailed) {
+		writeErrorResponse(w, http.StatusForbidden, "bot_check_failed", "Solve the bot protection challenge and try again", nil)
+		return false
+	}
+	log.Printf("Bot protection check for tenant %s failed: %v", tenantID, err)
+	writeErrorResponse(w, http.StatusServiceUnavailable, "bot_check_unavailable", "Bot protection is temporarily unavailable", nil)
+	return false
+}
+
+// GetChallenge tells login and registration pages which challenge to present. It needs no token.
+func (h *BotProtectionHandler) GetChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	challenge, err := h.botProtection.Challenge(middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to create challenge: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(challenge)
+}
+
+// GetPolicy returns the tenant's bot protection policy without its secret key
+func (h *BotProtectionHandler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	policy, err := h.botProtection.GetPolicy(middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to get bot protection policy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdatePolicy replaces the tenant's bot protection policy
+func (h *BotProtectionHandler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UpdateBotProtectionRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.MinScore < 0 || req.MinScore > 1 {
+		fields := validation.Errors{}
+		fields.Add("min_score", "max", "min_score must be between 0 and 1")
+		writeValidationErrors(w, "validation_failed", "Request validation failed", fields)
+		return
+	}
+
+	policy := &models.BotProtectionPolicy{
+		TenantID:      middleware.GetTenantIDFromRequest(r),
+		Provider:      req.Provider,
+		SiteKey:       req.SiteKey,
+		SecretKey:     req.SecretKey,
+		Endpoints:     req.Endpoints,
+		MinScore:      req.MinScore,
+		PoWDifficulty: req.PoWDifficulty,
+	}
+	if err := h.botProtection.SetPolicy(policy); err != nil {
+		if errors.Is(err, services.ErrBotSecretKeyMissing) {
+			fields := validation.Errors{}
+			fields.Add("secret_key", "required", "secret_key is required for "+req.Provider)
+			writeValidationErrors(w, "validation_failed", "Request validation failed", fields)
+			return
+		}
+		http.Error(w, "Failed to update bot protection policy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// GetBypassKeys lists the tenant's bypass keys without the keys themselves
+func (h *BotProtectionHandler) GetBypassKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := h.botProtection.ListBypassKeys(middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to get bypass keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"bypass_keys": keys})
+}
+
+// CreateBypassKey issues a bypass key for trusted automation. The key is only shown in this response.
+func (h *BotProtectionHandler) CreateBypassKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateBypassKeyRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	createdBy := ""
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+		createdBy = claims.UserID
+	}
+
+	record, key, err := h.botProtection.CreateBypassKey(middleware.GetTenantIDFromRequest(r), req.Name, createdBy)
+	if err != nil {
+		http.Error(w, "Failed to create bypass key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateBypassKeyResponse{BotBypassKey: record, Key: key})
+}
+
+// DeleteBypassKey revokes a bypass key
+func (h *BotProtectionHandler) DeleteBypassKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := h.botProtection.DeleteBypassKey(middleware.GetTenantIDFromRequest(r), mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrBypassKeyNotFound) {
+		http.Error(w, "Bypass key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete bypass key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
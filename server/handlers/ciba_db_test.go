@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestCIBAPolling starts a backchannel request and polls the token endpoint for it until the
+// user approves, and for another request until it expires
+func TestCIBAPolling(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, Email: "jane@example.com", Scopes: []string{"openid"}, Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), ClientID: "kiosk", ClientSecret: "secret", Name: "Kiosk",
+		GrantTypes: []string{services.CIBAGrantType}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	userService := services.NewUserService(db)
+	oauthService := services.NewOAuthService(db, "test-secret", services.DefaultLifetimes())
+	tenantService := services.NewTenantService(db)
+	cibaService := services.NewCIBAService(db, userService, oauthService, services.NewLogNotifier())
+	featureFlags := services.NewFeatureFlagService(db)
+	ciba := NewCIBAHandler(cibaService, oauthService, featureFlags)
+	auth := NewAuthHandler(userService, oauthService, services.NewSocialAuthService(userService, db),
+		services.NewTwoFactorService(db, services.DefaultLifetimes()), cibaService,
+		services.NewTranslationService(db, tenantService), services.NewAuditService(db), services.NewConsentService(db), services.NewBotProtectionService(db, "test-secret"), featureFlags, services.NewSSOSessionService(db, 0, 0), services.NewCookieService("test-secret", 0))
+
+	start := func() string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		ciba.BackchannelAuthorize(rec, tokenRequest(url.Values{"login_hint": {"jane@example.com"}, "scope": {"openid"}}, basicAuthorization("kiosk", "secret")))
+		var response BackchannelAuthResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil || rec.Code != http.StatusOK || response.AuthReqID == "" {
+			t.Fatalf("BackchannelAuthorize() = %d %+v (%v)", rec.Code, response, err)
+		}
+		if response.Interval != 5 || response.ExpiresIn != 300 {
+			t.Errorf("Expected a 5s interval and 300s expiry, got %+v", response)
+		}
+		return response.AuthReqID
+	}
+	poll := func(authReqID string) (*httptest.ResponseRecorder, OAuthErrorResponse) {
+		rec := httptest.NewRecorder()
+		auth.Token(rec, tokenRequest(url.Values{"grant_type": {services.CIBAGrantType}, "auth_req_id": {authReqID}}, basicAuthorization("kiosk", "secret")))
+		var body OAuthErrorResponse
+		if rec.Code != http.StatusOK {
+			json.NewDecoder(rec.Body).Decode(&body)
+		}
+		return rec, body
+	}
+
+	authReqID := start()
+	for _, want := range []string{"authorization_pending", "slow_down"} {
+		if rec, body := poll(authReqID); rec.Code != http.StatusBadRequest || body.Error != want {
+			t.Errorf("Expected 400 %s, got %d %+v", want, rec.Code, body)
+		}
+	}
+
+	// The user approves with their own access token
+	userTokens, err := oauthService.IssueTokens(userID.Hex(), "", "kiosk", []string{"openid"}, "", httptest.NewRequest(http.MethodPost, "/oauth/token", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	approve := httptest.NewRequest(http.MethodPost, "/api/v1/ciba/requests/"+authReqID+"/approve", nil)
+	approve.Header.Set("Authorization", "Bearer "+userTokens.AccessToken)
+	approve = mux.SetURLVars(approve, map[string]string{"authReqId": authReqID})
+	rec := httptest.NewRecorder()
+	ciba.ApproveRequest(rec, approve)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ApproveRequest() = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec, _ = poll(authReqID)
+	var tokens services.TokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil || rec.Code != http.StatusOK || tokens.IDToken == "" {
+		t.Fatalf("Expected tokens after approval, got %d (%v)", rec.Code, err)
+	}
+	if rec, body := poll(authReqID); rec.Code != http.StatusBadRequest || body.Error != "invalid_grant" {
+		t.Errorf("Expected the consumed auth_req_id to be an invalid grant, got %d %+v", rec.Code, body)
+	}
+
+	expiredID := start()
+	if _, err := db.GetCollection("backchannel_auth_requests").UpdateOne(context.Background(), bson.M{"auth_req_id": expiredID},
+		bson.M{"$set": bson.M{"expires_at": now.Add(-time.Second)}}); err != nil {
+		t.Fatal(err)
+	}
+	if rec, body := poll(expiredID); rec.Code != http.StatusBadRequest || body.Error != "expired_token" {
+		t.Errorf("Expected 400 expired_token, got %d %+v", rec.Code, body)
+	}
+}
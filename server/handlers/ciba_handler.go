@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type CIBAHandler struct {
+	cibaService  *services.CIBAService
+	oauthService *services.OAuthService
+	featureFlags *services.FeatureFlagService
+}
+
+type BackchannelAuthResponse struct {
+	AuthReqID string `json:"auth_req_id"`
+	ExpiresIn int    `json:"expires_in"`
+	Interval  int    `json:"interval"`
+}
+
+func NewCIBAHandler(cibaService *services.CIBAService, oauthService *services.OAuthService, featureFlags *services.FeatureFlagService) *CIBAHandler {
+	return &CIBAHandler{
+		cibaService:  cibaService,
+		oauthService: oauthService,
+		featureFlags: featureFlags,
+	}
+}
+
+// BackchannelAuthorize handles the CIBA backchannel authentication endpoint (/oauth/bc-authorize)
+func (h *CIBAHandler) BackchannelAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if !featureEnabled(h.featureFlags, services.FeatureCIBA, tenantID) {
+		writeOAuthError(w, http.StatusNotFound, "invalid_request", "Backchannel authentication is not enabled for this tenant")
+		return
+	}
+
+	clientID, clientSecret, basic, err := clientCredentials(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, err := h.oauthService.ValidateClient(clientID, clientSecret)
+	if err != nil {
+		writeInvalidClient(w, basic, "Client authentication failed")
+		return
+	}
+
+	if client.TenantID != "" && client.TenantID != tenantID {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client does not belong to this tenant")
+		return
+	}
+
+	allowed := false
+	for _, grantType := range client.GrantTypes {
+		if grantType == services.CIBAGrantType {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "Client is not registered for the CIBA grant")
+		return
+	}
+
+	loginHint := r.FormValue("login_hint")
+	if loginHint == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "login_hint is required")
+		return
+	}
+
+	authReq, err := h.cibaService.StartAuthentication(client, loginHint, r.FormValue("scope"), r.FormValue("binding_message"), tenantID)
+	if services.IsInvalidScope(err) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrUnknownUser):
+		writeOAuthError(w, http.StatusBadRequest, "unknown_user_id", "The login_hint does not identify a valid user")
+		return
+	case errors.Is(err, services.ErrOpenIDScopeRequired):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	case errors.Is(err, services.ErrLoginHintRequired):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	case err != nil:
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to start authentication request")
+		return
+	}
+
+	response := BackchannelAuthResponse{
+		AuthReqID: authReq.AuthReqID,
+		ExpiresIn: int(authReq.ExpiresAt.Sub(authReq.CreatedAt).Seconds()),
+		Interval:  authReq.Interval,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetPendingRequests lists backchannel requests awaiting the current user's decision
+func (h *CIBAHandler) GetPendingRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	requests, err := h.cibaService.GetPendingRequestsForUser(claims.UserID, claims.TenantID)
+	if err != nil {
+		http.Error(w, "Failed to fetch authentication requests: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+// ApproveRequest lets the current user approve a pending backchannel request
+func (h *CIBAHandler) ApproveRequest(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, true)
+}
+
+// DenyRequest lets the current user deny a pending backchannel request
+func (h *CIBAHandler) DenyRequest(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, false)
+}
+
+func (h *CIBAHandler) decide(w http.ResponseWriter, r *http.Request, approve bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	authReqID := mux.Vars(r)["authReqId"]
+	if approve {
+		err = h.cibaService.ApproveRequest(authReqID, claims.UserID)
+	} else {
+		err = h.cibaService.DenyRequest(authReqID, claims.UserID)
+	}
+	if errors.Is(err, services.ErrAuthRequestNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to answer the authentication request", http.StatusInternalServerError)
+		return
+	}
+
+	status := "denied"
+	if approve {
+		status = "approved"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"auth_req_id": authReqID,
+		"status":      status,
+	})
+}
+
+// authenticate validates the bearer token of the user answering a backchannel request
+func (h *CIBAHandler) authenticate(r *http.Request) (*services.Claims, error) {
+	authHeader := r.Header.Get("Authorization")
+	tokenParts := strings.SplitN(authHeader, " ", 2)
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return nil, errors.New("authorization header required")
+	}
+
+	claims, err := h.oauthService.ValidateAccessToken(tokenParts[1])
+	if err != nil || claims.UserID == "" {
+		return nil, errors.New("invalid or expired token")
+	}
+
+	return claims, nil
+}
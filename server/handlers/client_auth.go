@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+)
+
+var (
+	errMalformedBasicAuth = errors.New("malformed Basic authorization header")
+	errMultipleClientAuth = errors.New("client authenticated with more than one method")
+	errClientIDMismatch   = errors.New("client_id does not match the authenticated client")
+)
+
+// clientCredentials extracts the client credentials of a token endpoint request. Clients may
+// authenticate with client_secret_basic (HTTP Basic) or client_secret_post (form parameters),
+// but not both. Basic credentials are form-urlencoded before being base64 encoded (RFC 6749
+// section 2.3.1), so they are decoded here. The second return value reports whether Basic
+// authentication was used, so callers can answer failures with a WWW-Authenticate challenge.
+// The client ID is recorded in the request's access log line.
+func clientCredentials(r *http.Request) (clientID, clientSecret string, basic bool, err error) {
+	clientID, clientSecret, basic, err = parseClientCredentials(r)
+	if err == nil {
+		middleware.SetAccessLogClient(r, clientID)
+	}
+	return clientID, clientSecret, basic, err
+}
+
+func parseClientCredentials(r *http.Request) (clientID, clientSecret string, basic bool, err error) {
+	formID := r.FormValue("client_id")
+	formSecret := r.FormValue("client_secret")
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return formID, formSecret, false, nil
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		if strings.HasPrefix(strings.ToLower(header), "basic ") {
+			return "", "", true, errMalformedBasicAuth
+		}
+		// Not Basic (e.g. a bearer token); fall back to the form parameters
+		return formID, formSecret, false, nil
+	}
+
+	if clientID, err = url.QueryUnescape(username); err != nil {
+		return "", "", true, errMalformedBasicAuth
+	}
+	if clientSecret, err = url.QueryUnescape(password); err != nil {
+		return "", "", true, errMalformedBasicAuth
+	}
+
+	if formSecret != "" {
+		return "", "", true, errMultipleClientAuth
+	}
+	if formID != "" && formID != clientID {
+		return "", "", true, errClientIDMismatch
+	}
+
+	return clientID, clientSecret, true, nil
+}
+
+// writeInvalidClient answers a failed client authentication. When the client used HTTP Basic
+// the response is a 401 carrying a WWW-Authenticate challenge, as RFC 6749 section 5.2 requires.
+func writeInvalidClient(w http.ResponseWriter, basic bool, description string) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	writeOAuthError(w, http.StatusUnauthorized, "invalid_client", description)
+}
+
+// isClientAuthError reports whether err is one of the client authentication failures returned by
+// the OAuth service.
+func isClientAuthError(err error) bool {
+	if errors.Is(err, services.ErrPublicClientSecret) {
+		return true
+	}
+	switch err.Error() {
+	case "invalid client credentials", "invalid client", "client secret expired":
+		return true
+	}
+	return false
+}
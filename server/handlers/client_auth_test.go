@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func tokenRequest(form url.Values, authorization string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return req
+}
+
+func basicAuthorization(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func TestClientCredentialsBasic(t *testing.T) {
+	// RFC 6749 section 2.3.1: credentials are form-urlencoded before base64 encoding
+	req := tokenRequest(url.Values{"grant_type": {"authorization_code"}},
+		basicAuthorization(url.QueryEscape("my client"), url.QueryEscape("s3cr:t+%/")))
+
+	clientID, clientSecret, basic, err := clientCredentials(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !basic {
+		t.Error("Expected Basic authentication to be reported")
+	}
+	if clientID != "my client" || clientSecret != "s3cr:t+%/" {
+		t.Errorf("Expected decoded credentials, got %q / %q", clientID, clientSecret)
+	}
+}
+
+func TestClientCredentialsPost(t *testing.T) {
+	req := tokenRequest(url.Values{"client_id": {"app"}, "client_secret": {"secret"}}, "")
+
+	clientID, clientSecret, basic, err := clientCredentials(req)
+	if err != nil || basic || clientID != "app" || clientSecret != "secret" {
+		t.Errorf("Expected form credentials, got %q / %q (basic=%v, err=%v)", clientID, clientSecret, basic, err)
+	}
+}
+
+func TestClientCredentialsRejectsAmbiguousRequests(t *testing.T) {
+	tests := []struct {
+		name          string
+		form          url.Values
+		authorization string
+		want          error
+	}{
+		{"both methods", url.Values{"client_secret": {"secret"}}, basicAuthorization("app", "secret"), errMultipleClientAuth},
+		{"mismatched client_id", url.Values{"client_id": {"other"}}, basicAuthorization("app", "secret"), errClientIDMismatch},
+		{"malformed header", url.Values{}, "Basic not-base64!", errMalformedBasicAuth},
+		{"bad escaping", url.Values{}, basicAuthorization("app", "%zz"), errMalformedBasicAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := clientCredentials(tokenRequest(tt.form, tt.authorization)); err != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestWriteInvalidClientChallenge(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeInvalidClient(rec, true, "invalid client credentials")
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", rec.Code)
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("Expected a WWW-Authenticate challenge for Basic clients")
+	}
+}
@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"oauth2-openid-server/middleware"
 	"oauth2-openid-server/models"
@@ -12,24 +15,125 @@ import (
 )
 
 type ClientHandler struct {
-	clientService *services.ClientService
+	clientService        *services.ClientService
+	clientMetricsService *services.ClientMetricsService
+	assignmentService    *services.AppAssignmentService
+	auditService         *services.AuditService
 }
 
 type CreateClientRequest struct {
-	Name         string   `json:"name"`
-	Description  string   `json:"description"`
-	RedirectURIs []string `json:"redirect_uris"`
-	Scopes       []string `json:"scopes"`
-	GrantTypes   []string `json:"grant_types"`
+	Name                  string     `json:"name" validate:"required,max=100"`
+	Description           string     `json:"description" validate:"max=500"`
+	RedirectURIs          []string   `json:"redirect_uris" validate:"required,dive,url"`
+	Scopes                []string   `json:"scopes" validate:"dive,max=100"`
+	GrantTypes            []string   `json:"grant_types" validate:"dive,oneof=authorization_code refresh_token client_credentials urn:openid:params:grant-type:ciba"`
+	Contacts              []string   `json:"contacts" validate:"dive,email"`
+	ClientSecretExpiresAt *time.Time `json:"client_secret_expires_at,omitempty"`
+	ClientType            string     `json:"client_type" validate:"oneof=confidential public"` // public clients get no secret and must use PKCE (S256)
+	RequireMFA            bool       `json:"require_mfa"`                                      // every sign-in to the client needs a second factor
+	RequireS256PKCE       bool       `json:"require_s256_pkce"`                                // PKCE challenges must use S256
+	AssignmentRequired    bool       `json:"assignment_required"`                              // only assigned groups and users may sign in
+	IDTokenRoles          bool       `json:"id_token_roles"`                                   // ID tokens carry the user's scopes and groups
+	IDTokenAlg            string     `json:"id_token_signed_response_alg" validate:"oneof=HS256 RS256 ES256"`
+
+	GroupsClaim        models.GroupsClaimPolicy  `json:"groups_claim"`
+	RefreshTokenPolicy models.RefreshTokenPolicy `json:"refresh_token_policy"`
+	RateLimits         models.ClientRateLimits   `json:"rate_limits"`
+	models.ClientMetadata
 }
 
+// UpdateClientRequest changes a client. Name and redirect URIs are required; every other field
+// keeps its current value when omitted (null), so forms showing only some settings don't reset
+// the others. Empty lists and strings clear a setting.
 type UpdateClientRequest struct {
-	Name         string   `json:"name"`
-	Description  string   `json:"description"`
-	RedirectURIs []string `json:"redirect_uris"`
-	Scopes       []string `json:"scopes"`
-	GrantTypes   []string `json:"grant_types"`
-	Active       bool     `json:"active"`
+	Name                  string     `json:"name" validate:"required,max=100"`
+	Description           *string    `json:"description" validate:"max=500"`
+	RedirectURIs          []string   `json:"redirect_uris" validate:"required,dive,url"`
+	Scopes                []string   `json:"scopes" validate:"dive,max=100"`
+	GrantTypes            []string   `json:"grant_types" validate:"dive,oneof=authorization_code refresh_token client_credentials urn:openid:params:grant-type:ciba"`
+	Contacts              []string   `json:"contacts" validate:"dive,email"`
+	ClientSecretExpiresAt *time.Time `json:"client_secret_expires_at,omitempty"`
+	Active                *bool      `json:"active"`
+	RequireMFA            *bool      `json:"require_mfa"`
+	RequireS256PKCE       *bool      `json:"require_s256_pkce"`
+	AssignmentRequired    *bool      `json:"assignment_required"`
+	IDTokenRoles          *bool      `json:"id_token_roles"`
+	IDTokenAlg            *string    `json:"id_token_signed_response_alg" validate:"oneof=HS256 RS256 ES256"`
+	Version               *int64     `json:"version,omitempty"` // alternative to the If-Match header
+
+	GroupsClaim        *models.GroupsClaimPolicy  `json:"groups_claim"`
+	RefreshTokenPolicy *models.RefreshTokenPolicy `json:"refresh_token_policy"`
+	RateLimits         *models.ClientRateLimits   `json:"rate_limits"`
+	ClientMetadataUpdate
+}
+
+// ClientMetadataUpdate changes the consent screen metadata of a client
+type ClientMetadataUpdate struct {
+	LogoURI   *string `json:"logo_uri" validate:"max=2048,weburl"`
+	ClientURI *string `json:"client_uri" validate:"max=2048,weburl"`
+	PolicyURI *string `json:"policy_uri" validate:"max=2048,weburl"`
+	TOSURI    *string `json:"tos_uri" validate:"max=2048,weburl"`
+}
+
+// applyTo sets the fields of the request on the stored client
+func (req *UpdateClientRequest) applyTo(client *models.Client) {
+	client.Name = req.Name
+	client.RedirectURIs = req.RedirectURIs
+	if req.Scopes != nil {
+		client.Scopes = req.Scopes
+	}
+	if req.GrantTypes != nil {
+		client.GrantTypes = req.GrantTypes
+	}
+	if req.Contacts != nil {
+		client.Contacts = req.Contacts
+	}
+	if req.ClientSecretExpiresAt != nil {
+		client.ClientSecretExpiresAt = req.ClientSecretExpiresAt
+	}
+
+	if req.Description != nil {
+		client.Description = *req.Description
+	}
+	if req.Active != nil {
+		client.Active = *req.Active
+	}
+	if req.RequireMFA != nil {
+		client.RequireMFA = *req.RequireMFA
+	}
+	if req.RequireS256PKCE != nil {
+		client.RequireS256PKCE = *req.RequireS256PKCE
+	}
+	if req.AssignmentRequired != nil {
+		client.AssignmentRequired = *req.AssignmentRequired
+	}
+	if req.IDTokenRoles != nil {
+		client.IDTokenRoles = *req.IDTokenRoles
+	}
+	if req.IDTokenAlg != nil {
+		client.IDTokenSignedResponseAlg = *req.IDTokenAlg
+	}
+	if req.GroupsClaim != nil {
+		client.GroupsClaim = *req.GroupsClaim
+	}
+	if req.RefreshTokenPolicy != nil {
+		client.RefreshTokenPolicy = *req.RefreshTokenPolicy
+	}
+	if req.RateLimits != nil {
+		client.RateLimits = *req.RateLimits
+	}
+	if req.LogoURI != nil {
+		client.LogoURI = *req.LogoURI
+	}
+	if req.ClientURI != nil {
+		client.ClientURI = *req.ClientURI
+	}
+	if req.PolicyURI != nil {
+		client.PolicyURI = *req.PolicyURI
+	}
+	if req.TOSURI != nil {
+		client.TOSURI = *req.TOSURI
+	}
 }
 
 type ClientResponse struct {
@@ -37,9 +141,12 @@ type ClientResponse struct {
 	ClientSecret string `json:"client_secret,omitempty"`
 }
 
-func NewClientHandler(clientService *services.ClientService) *ClientHandler {
+func NewClientHandler(clientService *services.ClientService, clientMetricsService *services.ClientMetricsService, assignmentService *services.AppAssignmentService, auditService *services.AuditService) *ClientHandler {
 	return &ClientHandler{
-		clientService: clientService,
+		clientService:        clientService,
+		clientMetricsService: clientMetricsService,
+		assignmentService:    assignmentService,
+		auditService:         auditService,
 	}
 }
 
@@ -52,18 +159,7 @@ func (h *ClientHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	var createReq CreateClientRequest
-	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if createReq.Name == "" {
-		http.Error(w, "Client name is required", http.StatusBadRequest)
-		return
-	}
-
-	if len(createReq.RedirectURIs) == 0 {
-		http.Error(w, "At least one redirect URI is required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &createReq) {
 		return
 	}
 
@@ -73,7 +169,21 @@ func (h *ClientHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
 		RedirectURIs: createReq.RedirectURIs,
 		Scopes:       createReq.Scopes,
 		GrantTypes:   createReq.GrantTypes,
+		Contacts:     createReq.Contacts,
+		ClientType:   createReq.ClientType,
+		RequireMFA:   createReq.RequireMFA,
+		IDTokenRoles: createReq.IDTokenRoles,
 		TenantID:     tenantID,
+
+		IDTokenSignedResponseAlg: createReq.IDTokenAlg,
+
+		ClientSecretExpiresAt: createReq.ClientSecretExpiresAt,
+		AssignmentRequired:    createReq.AssignmentRequired,
+		RequireS256PKCE:       createReq.RequireS256PKCE,
+		GroupsClaim:           createReq.GroupsClaim,
+		RefreshTokenPolicy:    createReq.RefreshTokenPolicy,
+		RateLimits:            createReq.RateLimits,
+		ClientMetadata:        createReq.ClientMetadata,
 	}
 
 	if client.Scopes == nil {
@@ -84,6 +194,13 @@ func (h *ClientHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.clientService.CreateClient(client); err != nil {
+		if writeQuotaExceeded(w, err) {
+			return
+		}
+		if errors.Is(err, services.ErrPublicClientGrant) || errors.Is(err, services.ErrInvalidClientType) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to create client: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -107,26 +224,18 @@ func (h *ClientHandler) GetClients(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tenantID := middleware.GetTenantIDFromRequest(r)
-	activeOnly := r.URL.Query().Get("active") == "true"
-
-	var clients []*models.Client
-	var err error
-
-	if activeOnly {
-		clients, err = h.clientService.GetActiveClients(tenantID)
-	} else {
-		clients, err = h.clientService.GetAllClients(tenantID)
+	filter, err := parseClientFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	clients, err := h.clientService.SearchClients(tenantID, filter)
 	if err != nil {
 		http.Error(w, "Failed to get clients: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if clients == nil {
-		clients = []*models.Client{}
-	}
-
 	for _, client := range clients {
 		client.ClientSecret = ""
 	}
@@ -135,6 +244,41 @@ func (h *ClientHandler) GetClients(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(clients)
 }
 
+// parseClientFilter reads the client list filters: ?name= (substring), ?scope=, ?grant_type=,
+// ?active=true|false and the inclusive ?created_from= and ?created_to= dates (YYYY-MM-DD, UTC)
+func parseClientFilter(r *http.Request) (services.ClientFilter, error) {
+	query := r.URL.Query()
+	filter := services.ClientFilter{
+		Name:      query.Get("name"),
+		Scope:     query.Get("scope"),
+		GrantType: query.Get("grant_type"),
+	}
+
+	if activeParam := query.Get("active"); activeParam != "" {
+		active, err := strconv.ParseBool(activeParam)
+		if err != nil {
+			return filter, errors.New("Invalid active, expected true or false")
+		}
+		filter.Active = &active
+	}
+	if fromParam := query.Get("created_from"); fromParam != "" {
+		from, err := time.Parse(reportDateLayout, fromParam)
+		if err != nil {
+			return filter, errors.New("Invalid created_from date, expected YYYY-MM-DD")
+		}
+		filter.CreatedFrom = &from
+	}
+	if toParam := query.Get("created_to"); toParam != "" {
+		to, err := time.Parse(reportDateLayout, toParam)
+		if err != nil {
+			return filter, errors.New("Invalid created_to date, expected YYYY-MM-DD")
+		}
+		to = to.AddDate(0, 0, 1)
+		filter.CreatedTo = &to
+	}
+	return filter, nil
+}
+
 func (h *ClientHandler) GetClient(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -153,6 +297,7 @@ func (h *ClientHandler) GetClient(w http.ResponseWriter, r *http.Request) {
 
 	client.ClientSecret = ""
 
+	setETag(w, client.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(client)
 }
@@ -168,38 +313,30 @@ func (h *ClientHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	var updateReq UpdateClientRequest
-	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &updateReq) {
 		return
 	}
 
-	if updateReq.Name == "" {
-		http.Error(w, "Client name is required", http.StatusBadRequest)
+	version, ok := expectedVersion(w, r, updateReq.Version)
+	if !ok {
 		return
 	}
 
-	if len(updateReq.RedirectURIs) == 0 {
-		http.Error(w, "At least one redirect URI is required", http.StatusBadRequest)
+	client, err := h.clientService.GetClientByID(clientID, tenantID)
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
 		return
 	}
+	updateReq.applyTo(client)
 
-	client := &models.Client{
-		Name:         updateReq.Name,
-		Description:  updateReq.Description,
-		RedirectURIs: updateReq.RedirectURIs,
-		Scopes:       updateReq.Scopes,
-		GrantTypes:   updateReq.GrantTypes,
-		Active:       updateReq.Active,
-	}
-
-	if client.Scopes == nil {
-		client.Scopes = []string{}
-	}
-	if client.GrantTypes == nil {
-		client.GrantTypes = []string{"authorization_code", "refresh_token"}
-	}
-
-	if err := h.clientService.UpdateClient(clientID, tenantID, client); err != nil {
+	if err := h.clientService.UpdateClient(clientID, tenantID, client, version); err != nil {
+		if writeVersionConflict(w, err) {
+			return
+		}
+		if errors.Is(err, services.ErrPublicClientGrant) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to update client: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -212,6 +349,7 @@ func (h *ClientHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
 
 	updatedClient.ClientSecret = ""
 
+	setETag(w, updatedClient.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedClient)
 }
@@ -227,6 +365,10 @@ func (h *ClientHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	if err := h.clientService.DeleteClient(clientID, tenantID); err != nil {
+		if errors.Is(err, services.ErrSystemClient) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to delete client: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -282,17 +424,157 @@ func (h *ClientHandler) RegenerateSecret(w http.ResponseWriter, r *http.Request)
 	clientID := vars["id"]
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
-	newSecret, err := h.clientService.RegenerateClientSecret(clientID, tenantID)
+	var client *models.Client
+	var newSecret string
+	var err error
+
+	// The old secret is revoked immediately unless the tenant policy or grace_hours grants a
+	// rotation window; grace_hours=0 overrides the policy
+	if graceHours := r.URL.Query().Get("grace_hours"); graceHours != "" {
+		hours, convErr := strconv.Atoi(graceHours)
+		if convErr != nil || hours < 0 {
+			http.Error(w, "Invalid grace_hours", http.StatusBadRequest)
+			return
+		}
+		client, newSecret, err = h.clientService.RotateClientSecret(clientID, tenantID, time.Duration(hours)*time.Hour)
+	} else {
+		client, newSecret, err = h.clientService.RegenerateClientSecret(clientID, tenantID)
+	}
+	if errors.Is(err, services.ErrPublicClientSecret) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to regenerate client secret: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordActivity(h.auditService, r, &models.AuditEvent{TenantID: tenantID, Type: models.AuditEventClientSecretRotated, ClientID: client.ClientID})
 
-	response := map[string]string{
-		"client_secret": newSecret,
-		"message":       "Client secret regenerated successfully",
+	response := map[string]interface{}{
+		"client_secret":              newSecret,
+		"client_secret_expires_at":   client.ClientSecretExpiresAt,
+		"previous_secret_expires_at": client.PreviousSecretExpiresAt,
+		"message":                    "Client secret regenerated successfully",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+// GetClientMetrics reports the client's authorization funnel over the last ?days= (default 30):
+// authorize requests, logins, consents, codes issued and exchanged, and exchange failures by
+// reason, along with its current usage against its rate limits
+func (h *ClientHandler) GetClientMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := services.DefaultClientMetricsDays
+	if daysParam := r.URL.Query().Get("days"); daysParam != "" {
+		parsed, err := strconv.Atoi(daysParam)
+		if err != nil || parsed <= 0 || parsed > services.MaxClientMetricsDays {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	client, err := h.clientService.GetClientByID(mux.Vars(r)["id"], middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	report, err := h.clientMetricsService.GetReport(client.ClientID, days)
+	if err != nil {
+		http.Error(w, "Failed to fetch client metrics", http.StatusInternalServerError)
+		return
+	}
+	report.RateLimits, err = h.clientMetricsService.GetRateLimitUsage(client)
+	if err != nil {
+		http.Error(w, "Failed to fetch client rate limit usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// AssignmentRequest assigns a client to a group (ID or name) or a user (ID)
+type AssignmentRequest struct {
+	Type string `json:"type" validate:"required,oneof=group user"`
+	ID   string `json:"id" validate:"required,max=100"`
+}
+
+// GetAssignments lists the groups and users assigned to a client
+func (h *ClientHandler) GetAssignments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := h.clientService.GetClientByID(mux.Vars(r)["id"], middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.assignmentService.GetAssignments(client))
+}
+
+// AddAssignment gives a group or user access to a client
+func (h *ClientHandler) AddAssignment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := h.clientService.GetClientByID(mux.Vars(r)["id"], middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	var req AssignmentRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if err := h.assignmentService.Assign(client, req.Type, req.ID); err != nil {
+		http.Error(w, "Failed to assign client: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err = h.clientService.GetClientByID(client.ID.Hex(), client.TenantID)
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h.assignmentService.GetAssignments(client))
+}
+
+// RemoveAssignment revokes a group's or user's access to a client
+func (h *ClientHandler) RemoveAssignment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	client, err := h.clientService.GetClientByID(vars["id"], middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.assignmentService.Revoke(client, vars["type"], vars["principalId"]); err != nil {
+		http.Error(w, "Failed to revoke assignment: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
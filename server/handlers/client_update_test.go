@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+// TestUpdateClientRequestKeepsOmittedSettings applies the body the client form sends, which only
+// carries the basic fields, and checks that the other settings survive
+func TestUpdateClientRequestKeepsOmittedSettings(t *testing.T) {
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	stored := models.Client{
+		Name:                     "Portal",
+		Description:              "Customer portal",
+		RedirectURIs:             []string{"https://portal.example.com/callback"},
+		Scopes:                   []string{"openid"},
+		GrantTypes:               []string{"authorization_code"},
+		Contacts:                 []string{"ops@example.com"},
+		Active:                   true,
+		RequireMFA:               true,
+		RequireS256PKCE:          true,
+		AssignmentRequired:       true,
+		IDTokenRoles:             true,
+		IDTokenSignedResponseAlg: models.IDTokenAlgRS256,
+		GroupsClaim:              models.GroupsClaimPolicy{Mode: "relevant", MaxTokenBytes: 2048},
+		RefreshTokenPolicy:       models.RefreshTokenPolicy{RotateOnUse: true, IdleTimeoutDays: 7},
+		RateLimits:               models.ClientRateLimits{TokenRequestsPerMinute: 30},
+		ClientSecretExpiresAt:    &expires,
+		ClientMetadata:           models.ClientMetadata{LogoURI: "https://portal.example.com/logo.png", PolicyURI: "https://portal.example.com/privacy"},
+	}
+
+	var req UpdateClientRequest
+	body := `{"name": "Customer Portal", "description": "Portal", "redirect_uris": ["https://portal.example.com/cb"],
+		"scopes": ["openid", "email"], "grant_types": ["authorization_code", "refresh_token"], "active": false, "version": 3}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	client := stored
+	req.applyTo(&client)
+
+	want := stored
+	want.Name, want.Description, want.Active = "Customer Portal", "Portal", false
+	want.RedirectURIs = []string{"https://portal.example.com/cb"}
+	want.Scopes = []string{"openid", "email"}
+	want.GrantTypes = []string{"authorization_code", "refresh_token"}
+	if !reflect.DeepEqual(client, want) {
+		t.Errorf("applyTo() = %+v, want %+v", client, want)
+	}
+
+	// Settings sent explicitly are changed, including cleared ones
+	body = `{"name": "Portal", "redirect_uris": ["https://portal.example.com/cb"], "require_mfa": false,
+		"contacts": [], "logo_uri": "", "groups_claim": {"mode": "all"}}`
+	req = UpdateClientRequest{}
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	req.applyTo(&client)
+	if client.RequireMFA || len(client.Contacts) != 0 || client.LogoURI != "" || client.GroupsClaim.Mode != "all" {
+		t.Errorf("applyTo() didn't apply the explicit settings: %+v", client)
+	}
+	if !client.IDTokenRoles || client.PolicyURI == "" || !client.RequireS256PKCE {
+		t.Errorf("applyTo() changed omitted settings: %+v", client)
+	}
+}
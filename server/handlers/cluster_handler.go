@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+)
+
+// ClusterHandler shows how this instance takes part in a multi-instance, multi-region deployment
+type ClusterHandler struct {
+	locks       *services.ClusterLockService
+	events      *services.ClusterEventBus
+	region      string
+	db          *database.MongoDB
+	loadShedder *middleware.LoadShedder
+}
+
+func NewClusterHandler(locks *services.ClusterLockService, events *services.ClusterEventBus, region string, db *database.MongoDB, loadShedder *middleware.LoadShedder) *ClusterHandler {
+	return &ClusterHandler{
+		locks:       locks,
+		events:      events,
+		region:      region,
+		db:          db,
+		loadShedder: loadShedder,
+	}
+}
+
+// ClusterStatusResponse describes the instance answering the request and the cluster locks
+type ClusterStatusResponse struct {
+	InstanceID string                     `json:"instance_id"`
+	Region     string                     `json:"region,omitempty"`
+	Locks      []models.ClusterLock       `json:"locks"` // leases currently held by any instance
+	Events     services.ClusterEventStats `json:"events"`
+}
+
+// LoadStatusResponse describes the overload protection of the instance answering the request
+type LoadStatusResponse struct {
+	InstanceID string                      `json:"instance_id"`
+	Requests   middleware.LoadShedderStats `json:"requests"`
+	Breakers   []database.BreakerHealth    `json:"breakers"` // MongoDB circuit breakers by service
+}
+
+// GetLoadStatus reports the requests being handled and shed, and the MongoDB circuit breakers of
+// the instance answering the request
+func (h *ClusterHandler) GetLoadStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoadStatusResponse{
+		InstanceID: services.InstanceID(),
+		Requests:   h.loadShedder.Stats(),
+		Breakers:   h.db.BreakerHealth(),
+	})
+}
+
+// GetClusterStatus returns the instance's identity, the held cluster locks and what the instance
+// received from the cluster event bus
+func (h *ClusterHandler) GetClusterStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	locks, err := h.locks.HeldLocks()
+	if err != nil {
+		http.Error(w, "Failed to list cluster locks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ClusterStatusResponse{
+		InstanceID: services.InstanceID(),
+		Region:     h.region,
+		Locks:      locks,
+		Events:     h.events.Stats(),
+	})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"oauth2-openid-server/services"
+)
+
+// VersionConflictResponse is returned with 409 Conflict when an update was based on a stale version
+type VersionConflictResponse struct {
+	Error          string `json:"error"`
+	Message        string `json:"message"`
+	CurrentVersion int64  `json:"current_version"`
+}
+
+// setETag exposes a resource version as a strong ETag for use in If-Match
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// parseIfMatch parses an If-Match header carrying a single version ETag ("3", W/"3" or *)
+func parseIfMatch(header string) (int64, error) {
+	value := strings.TrimSpace(header)
+	if value == "*" {
+		return services.AnyVersion, nil
+	}
+
+	value = strings.TrimPrefix(value, "W/")
+	value = strings.Trim(value, `"`)
+
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version < 0 {
+		return 0, errors.New("Invalid If-Match header")
+	}
+	return version, nil
+}
+
+// expectedVersion returns the version an update is based on, taken from the If-Match header
+// or else from the version field of the request body. It writes 428 Precondition Required if
+// neither is present and returns false.
+func expectedVersion(w http.ResponseWriter, r *http.Request, bodyVersion *int64) (int64, bool) {
+	if header := r.Header.Get("If-Match"); header != "" {
+		version, err := parseIfMatch(header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return 0, false
+		}
+		return version, true
+	}
+
+	if bodyVersion != nil {
+		return *bodyVersion, true
+	}
+
+	http.Error(w, "If-Match header or version field required", http.StatusPreconditionRequired)
+	return 0, false
+}
+
+// writeVersionConflict writes a 409 response carrying the current version if err is a
+// version conflict and reports whether it did
+func writeVersionConflict(w http.ResponseWriter, err error) bool {
+	conflict, ok := services.IsVersionConflict(err)
+	if !ok {
+		return false
+	}
+
+	setETag(w, conflict.CurrentVersion)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(VersionConflictResponse{
+		Error:          "version_conflict",
+		Message:        "The resource was modified by someone else. Reload it and retry.",
+		CurrentVersion: conflict.CurrentVersion,
+	})
+	return true
+}
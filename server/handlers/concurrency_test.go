@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"oauth2-openid-server/services"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	cases := []struct {
+		header  string
+		version int64
+		wantErr bool
+	}{
+		{`"3"`, 3, false},
+		{`W/"7"`, 7, false},
+		{`12`, 12, false},
+		{`*`, services.AnyVersion, false},
+		{`"abc"`, 0, true},
+		{`"-2"`, 0, true},
+	}
+
+	for _, c := range cases {
+		version, err := parseIfMatch(c.header)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseIfMatch(%q) expected error", c.header)
+			}
+			continue
+		}
+		if err != nil || version != c.version {
+			t.Errorf("parseIfMatch(%q) = %d, %v; want %d", c.header, version, err, c.version)
+		}
+	}
+}
+
+func TestExpectedVersionRequiresPrecondition(t *testing.T) {
+	w := httptest.NewRecorder()
+	if _, ok := expectedVersion(w, httptest.NewRequest("PUT", "/api/v1/users/1", nil), nil); ok {
+		t.Fatal("Expected missing version to be rejected")
+	}
+	if w.Code != 428 {
+		t.Errorf("Expected 428, got %d", w.Code)
+	}
+
+	bodyVersion := int64(4)
+	req := httptest.NewRequest("PUT", "/api/v1/users/1", nil)
+	req.Header.Set("If-Match", `"5"`)
+	version, ok := expectedVersion(httptest.NewRecorder(), req, &bodyVersion)
+	if !ok || version != 5 {
+		t.Errorf("Expected If-Match to take precedence, got %d", version)
+	}
+}
+
+func TestWriteVersionConflict(t *testing.T) {
+	if writeVersionConflict(httptest.NewRecorder(), errors.New("user not found")) {
+		t.Error("Expected other errors to be ignored")
+	}
+
+	w := httptest.NewRecorder()
+	if !writeVersionConflict(w, &services.VersionConflictError{CurrentVersion: 9}) {
+		t.Fatal("Expected conflict to be written")
+	}
+	if w.Code != 409 || w.Header().Get("ETag") != `"9"` {
+		t.Errorf("Expected 409 with ETag \"9\", got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"oauth2-openid-server/config"
+	"oauth2-openid-server/middleware"
+)
+
+// ConfigHandler lets the platform operator reload the server configuration without a restart
+type ConfigHandler struct {
+	reloader *config.Reloader
+}
+
+func NewConfigHandler(reloader *config.Reloader) *ConfigHandler {
+	return &ConfigHandler{
+		reloader: reloader,
+	}
+}
+
+// ReloadConfig re-reads the configuration and applies the settings that can change at runtime
+// (CORS origins, log level). Other changed settings are reported as needing a restart.
+func (h *ConfigHandler) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !middleware.IsPlatformOperator(r) {
+		http.Error(w, "The server configuration is managed by the platform operator", http.StatusForbidden)
+		return
+	}
+
+	result, err := h.reloader.Reload()
+	if err != nil {
+		http.Error(w, "Configuration reload failed, keeping the current configuration: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Configuration reloaded, applied: %v, restart required: %v", result.Applied, result.RestartRequired)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/i18n"
+	"oauth2-openid-server/models"
+)
+
+func TestConsentClientInfo(t *testing.T) {
+	client := &models.Client{
+		Name: `Acme <b>CRM</b>`,
+		ClientMetadata: models.ClientMetadata{
+			LogoURI:   "https://acme.com/logo.png",
+			ClientURI: "https://acme.com",
+			PolicyURI: "https://acme.com/privacy",
+		},
+	}
+	info := consentClientInfo(i18n.NewLocalizer("en", nil), client)
+
+	if strings.Contains(info, "<b>CRM</b>") {
+		t.Error("Expected the client name to be escaped")
+	}
+	for _, want := range []string{
+		`<img src="https://acme.com/logo.png"`,
+		`<a href="https://acme.com" target="_blank" rel="noopener noreferrer"><strong>Acme &lt;b&gt;CRM&lt;/b&gt;</strong></a> would like to access your account`,
+		`<a href="https://acme.com/privacy" target="_blank" rel="noopener noreferrer">Privacy policy</a>`,
+	} {
+		if !strings.Contains(info, want) {
+			t.Errorf("Expected %q in %s", want, info)
+		}
+	}
+	if strings.Contains(info, "Terms of service") {
+		t.Error("Expected no terms of service link without tos_uri")
+	}
+
+	if info := consentClientInfo(i18n.NewLocalizer("en", nil), nil); !strings.Contains(info, "Application is requesting access") {
+		t.Errorf("Expected the generic intro for an unknown client, got %s", info)
+	}
+}
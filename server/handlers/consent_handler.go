@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// ConsentHandler exports consent receipts for auditors and lets users withdraw their consents
+type ConsentHandler struct {
+	consentService *services.ConsentService
+}
+
+func NewConsentHandler(consentService *services.ConsentService) *ConsentHandler {
+	return &ConsentHandler{
+		consentService: consentService,
+	}
+}
+
+// ExportConsents exports the tenant's consent receipts (grants and withdrawals) as ?format=csv
+// (default) or json, optionally for a single ?client_id= and the inclusive dates ?from=&to=.
+// Without dates every receipt is exported.
+func (h *ConsentHandler) ExportConsents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := services.ConsentReceiptFilter{ClientID: query.Get("client_id")}
+	filename := "consent-receipts"
+	if query.Get("from") != "" || query.Get("to") != "" {
+		from, to, err := parseReportPeriod(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.From, filter.To = from, to
+		filename += "-" + from.Format(reportDateLayout) + "-" + to.AddDate(0, 0, -1).Format(reportDateLayout)
+	}
+
+	format := query.Get("format")
+	if format != "" && format != "csv" && format != "json" {
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.consentService.ExportReceipts(tenantID, filter)
+	if err != nil {
+		http.Error(w, "Failed to export consent receipts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == "json" {
+		data, err := json.Marshal(map[string]interface{}{"receipts": entries})
+		if err != nil {
+			http.Error(w, "Failed to export consent receipts", http.StatusInternalServerError)
+			return
+		}
+		writeReportFile(w, "application/json", filename+".json", data)
+		return
+	}
+
+	data, err := services.ConsentReceiptsCSV(entries)
+	if err != nil {
+		http.Error(w, "Failed to export consent receipts", http.StatusInternalServerError)
+		return
+	}
+	writeReportFile(w, "text/csv; charset=utf-8", filename+".csv", data)
+}
+
+// WithdrawMyConsent withdraws the current user's consent for a client. The client asks for
+// consent again on the next authorization.
+func (h *ConsentHandler) WithdrawMyConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.consentService.RevokeConsent(claims.UserID, mux.Vars(r)["clientId"], claims.TenantID)
+	if err != nil {
+		if errors.Is(err, services.ErrConsentNotFound) {
+			http.Error(w, "Consent not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to withdraw consent: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
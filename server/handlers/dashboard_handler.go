@@ -3,14 +3,20 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"oauth2-openid-server/database"
 	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
 	"oauth2-openid-server/services"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -18,6 +24,7 @@ type DashboardHandler struct {
 	userService   *services.UserService
 	groupService  *services.GroupService
 	clientService *services.ClientService
+	auditService  *services.AuditService
 	db            *database.MongoDB
 }
 
@@ -59,11 +66,12 @@ type ClientUsageStats struct {
 	Tokens     int64  `json:"tokens"`
 }
 
-func NewDashboardHandler(userService *services.UserService, groupService *services.GroupService, clientService *services.ClientService, db *database.MongoDB) *DashboardHandler {
+func NewDashboardHandler(userService *services.UserService, groupService *services.GroupService, clientService *services.ClientService, auditService *services.AuditService, db *database.MongoDB) *DashboardHandler {
 	return &DashboardHandler{
 		userService:   userService,
 		groupService:  groupService,
 		clientService: clientService,
+		auditService:  auditService,
 		db:            db,
 	}
 }
@@ -121,7 +129,7 @@ func (h *DashboardHandler) GetDashboardStats(w http.ResponseWriter, r *http.Requ
 		stats.ActiveTokens = tokenStats.Active
 	}
 
-	recentActivity, err := h.getRecentActivity()
+	recentActivity, err := h.getRecentActivity(tenantID)
 	if err == nil {
 		stats.RecentActivity = recentActivity
 	}
@@ -154,7 +162,7 @@ func (h *DashboardHandler) getTokenStats() (*TokenStats, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	tokenCollection := h.db.GetCollection("access_tokens")
+	tokenCollection := h.collection("access_tokens")
 
 	total, err := tokenCollection.CountDocuments(ctx, bson.M{})
 	if err != nil {
@@ -175,13 +183,94 @@ func (h *DashboardHandler) getTokenStats() (*TokenStats, error) {
 	}, nil
 }
 
-func (h *DashboardHandler) getRecentActivity() ([]ActivityItem, error) {
+// GetActivity returns the tenant's activity feed, newest first. ?type= restricts it to a
+// comma-separated list of event types; ?actor=, ?user= and ?target= to the events made by a user,
+// concerning a user or concerning a resource. ?limit= sets the page size and ?cursor= continues
+// from the next_cursor of the previous page.
+func (h *DashboardHandler) GetActivity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	var types []string
+	for _, eventType := range strings.Split(r.URL.Query().Get("type"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			types = append(types, eventType)
+		}
+	}
+
+	limit := 0
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	filter := services.ActivityFilter{
+		Types:   types,
+		ActorID: r.URL.Query().Get("actor"),
+		UserID:  r.URL.Query().Get("user"),
+		Target:  r.URL.Query().Get("target"),
+	}
+	page, err := h.auditService.ListActivity(tenantID, filter, r.URL.Query().Get("cursor"), limit)
+	if errors.Is(err, services.ErrInvalidActivityCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get activity", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// activityMessages describe the audit events shown in the dashboard's recent activity
+var activityMessages = map[string]string{
+	models.AuditEventLoginFailure:        "Failed login",
+	models.AuditEventTwoFactorEnabled:    "Two-factor authentication enabled",
+	models.AuditEventClientSecretRotated: "Client secret rotated",
+	models.AuditEventGroupMemberAdded:    "User added to group",
+	models.AuditEventGroupMemberRemoved:  "User removed from group",
+	models.AuditEventProviderUpdated:     "Social provider configuration updated",
+}
+
+// activityItem turns an audit event into an entry of the dashboard's recent activity
+func activityItem(event *models.AuditEvent) ActivityItem {
+	message := activityMessages[event.Type]
+	switch {
+	case event.Email != "":
+		message += ": " + event.Email
+	case event.Target != "":
+		message += ": " + event.Target
+	}
+	return ActivityItem{
+		Type:      event.Type,
+		Message:   message,
+		Timestamp: event.CreatedAt,
+		UserID:    event.UserID,
+		ClientID:  event.ClientID,
+	}
+}
+
+func (h *DashboardHandler) getRecentActivity(tenantID string) ([]ActivityItem, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var activities []ActivityItem
 
-	userCollection := h.db.GetCollection("users")
+	userCollection := h.collection("users")
 	opts := options.Find().SetLimit(10).SetSort(bson.M{"created_at": -1})
 	cursor, err := userCollection.Find(ctx, bson.M{}, opts)
 	if err == nil {
@@ -203,7 +292,7 @@ func (h *DashboardHandler) getRecentActivity() ([]ActivityItem, error) {
 		}
 	}
 
-	clientCollection := h.db.GetCollection("clients")
+	clientCollection := h.collection("clients")
 	opts = options.Find().SetLimit(10).SetSort(bson.M{"created_at": -1})
 	cursor, err = clientCollection.Find(ctx, bson.M{}, opts)
 	if err == nil {
@@ -225,6 +314,21 @@ func (h *DashboardHandler) getRecentActivity() ([]ActivityItem, error) {
 		}
 	}
 
+	if h.auditService != nil && tenantID != "" {
+		types := make([]string, 0, len(activityMessages))
+		for eventType := range activityMessages {
+			types = append(types, eventType)
+		}
+		if page, err := h.auditService.ListActivity(tenantID, services.ActivityFilter{Types: types}, "", 10); err == nil {
+			for _, event := range page.Events {
+				activities = append(activities, activityItem(event))
+			}
+		}
+	}
+
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].Timestamp.After(activities[j].Timestamp)
+	})
 	return activities[:min(len(activities), 20)], nil
 }
 
@@ -232,7 +336,7 @@ func (h *DashboardHandler) getUserRegistrations() ([]RegistrationStats, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	userCollection := h.db.GetCollection("users")
+	userCollection := h.collection("users")
 	
 	pipeline := []bson.M{
 		{
@@ -285,7 +389,7 @@ func (h *DashboardHandler) getTokenUsage() ([]TokenUsageStats, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	tokenCollection := h.db.GetCollection("access_tokens")
+	tokenCollection := h.collection("access_tokens")
 	
 	pipeline := []bson.M{
 		{
@@ -311,7 +415,7 @@ func (h *DashboardHandler) getTokenUsage() ([]TokenUsageStats, error) {
 		},
 	}
 
-	cursor, err := tokenCollection.Aggregate(ctx, pipeline)
+	cursor, err := tokenCollection.Aggregate(ctx, pipeline, h.recentTokensHint())
 	if err != nil {
 		return []TokenUsageStats{}, nil
 	}
@@ -338,7 +442,7 @@ func (h *DashboardHandler) getClientUsage(tenantID string) ([]ClientUsageStats,
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	tokenCollection := h.db.GetCollection("access_tokens")
+	tokenCollection := h.collection("access_tokens")
 	
 	pipeline := []bson.M{
 		{
@@ -362,7 +466,7 @@ func (h *DashboardHandler) getClientUsage(tenantID string) ([]ClientUsageStats,
 		},
 	}
 
-	cursor, err := tokenCollection.Aggregate(ctx, pipeline)
+	cursor, err := tokenCollection.Aggregate(ctx, pipeline, h.recentTokensHint())
 	if err != nil {
 		return []ClientUsageStats{}, nil
 	}
@@ -397,4 +501,15 @@ func min(a, b int) int {
 		return a
 	}
 	return b
-}
\ No newline at end of file
+}
+
+// collection returns a collection read with the dashboard's read preference, so the statistics
+// can be served by secondaries
+func (h *DashboardHandler) collection(name string) *mongo.Collection {
+	return h.db.ReadCollection(database.ReadPathDashboard, name)
+}
+
+// recentTokensHint makes the aggregations over recently issued tokens use the created_at index
+func (h *DashboardHandler) recentTokensHint() *options.AggregateOptions {
+	return options.Aggregate().SetHint(h.db.Hint("access_tokens", services.AccessTokenCreatedIndex))
+}
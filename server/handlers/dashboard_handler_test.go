@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+)
+
+func TestGetActivityRejectsInvalidRequests(t *testing.T) {
+	h := &DashboardHandler{}
+
+	tests := []struct {
+		name   string
+		query  string
+		tenant string
+	}{
+		{name: "no tenant", query: ""},
+		{name: "invalid limit", query: "?limit=ten", tenant: "tenant-1"},
+		{name: "negative limit", query: "?limit=-5", tenant: "tenant-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/dashboard/activity"+tt.query, nil)
+			if tt.tenant != "" {
+				r = r.WithContext(context.WithValue(r.Context(), middleware.TenantIDKey, tt.tenant))
+			}
+			w := httptest.NewRecorder()
+			h.GetActivity(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestActivityItem(t *testing.T) {
+	createdAt := time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)
+
+	item := activityItem(&models.AuditEvent{Type: models.AuditEventGroupMemberAdded, UserID: "u1", Target: "g1", CreatedAt: createdAt})
+	if item.Message != "User added to group: g1" || item.UserID != "u1" || !item.Timestamp.Equal(createdAt) {
+		t.Errorf("Unexpected activity item %+v", item)
+	}
+
+	item = activityItem(&models.AuditEvent{Type: models.AuditEventLoginFailure, Email: "jane@acme.com", Target: "ignored"})
+	if item.Message != "Failed login: jane@acme.com" {
+		t.Errorf("Expected the email to describe a failed login, got %q", item.Message)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultScopesResponse shows a tenant's configured default scope sets next to the ones in effect
+type DefaultScopesResponse struct {
+	Configured models.DefaultScopeSets `json:"configured"`
+	Effective  models.DefaultScopeSets `json:"effective"`
+}
+
+// GetDefaultScopes returns the scopes a tenant gives to registrations, social and direct logins
+func (h *TenantHandler) GetDefaultScopes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenant, err := h.tenantService.GetTenantByID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	h.writeDefaultScopes(w, tenant.Settings)
+}
+
+// UpdateDefaultScopes replaces a tenant's default scope sets. Every scope must exist in the tenant.
+func (h *TenantHandler) UpdateDefaultScopes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := mux.Vars(r)["id"]
+	tenant, err := h.tenantService.GetTenantByID(tenantID)
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	var sets models.DefaultScopeSets
+	if !decodeRequest(w, r, &sets) {
+		return
+	}
+
+	scopes, err := h.scopeService.GetAllScopes(tenantID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve scopes", http.StatusInternalServerError)
+		return
+	}
+	if unknown := unknownScopes(scopes, sets.Registration, sets.Social, sets.DirectLogin); len(unknown) > 0 {
+		http.Error(w, "Unknown scopes: "+strings.Join(unknown, ", "), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.tenantService.UpdateDefaultScopes(tenantID, sets); err != nil {
+		http.Error(w, "Failed to update default scopes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tenant.Settings.DefaultScopes = sets
+	h.writeDefaultScopes(w, tenant.Settings)
+}
+
+func (h *TenantHandler) writeDefaultScopes(w http.ResponseWriter, settings models.TenantSettings) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DefaultScopesResponse{
+		Configured: settings.DefaultScopes,
+		Effective:  services.EffectiveDefaultScopes(settings),
+	})
+}
+
+// unknownScopes returns the names in the sets that aren't scopes of the tenant. Tenants without
+// scope definitions accept any name, like authorization requests do.
+func unknownScopes(scopes []models.Scope, sets ...[]string) []string {
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	known := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		known[scope.Name] = true
+	}
+
+	var unknown []string
+	for _, set := range sets {
+		for _, name := range set {
+			if !known[name] && !containsValue(unknown, name) {
+				unknown = append(unknown, name)
+			}
+		}
+	}
+	return unknown
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+)
+
+// ElevateRequest re-authenticates a signed-in user for an elevated token
+type ElevateRequest struct {
+	Password  string `json:"password" validate:"required,max=256"`
+	TwoFACode string `json:"two_fa_code" validate:"required,max=64"` // TOTP, SMS or backup code
+}
+
+// Elevate exchanges the caller's token, their password and a second factor for a short-lived
+// token carrying the elevated scope that destructive administration requests require
+func (h *AuthHandler) Elevate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ElevateRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	user, err := h.userService.GetUserByIDAndTenant(claims.UserID, claims.TenantID)
+	if err != nil || !user.Active {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !h.userService.ValidatePassword(user, req.Password) {
+		h.recordElevation(r, claims, "invalid_password")
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	mfa, err := h.twoFactorService.EvaluateMFAPolicy(claims.UserID, "")
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !mfa.Enrolled {
+		h.recordElevation(r, claims, "mfa_enrollment_required")
+		http.Error(w, services.ErrMFAEnrollmentRequired.Error(), http.StatusForbidden)
+		return
+	}
+
+	valid, err := h.twoFactorService.VerifyTwoFactor(claims.UserID, req.TwoFACode)
+	if err != nil || !valid {
+		h.recordElevation(r, claims, "invalid_two_factor")
+		http.Error(w, "Invalid two-factor authentication code", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := h.oauthService.IssueElevatedToken(user, claims.TenantID, claims.ClientID, claims.Scopes, r)
+	if err == services.ErrElevationNotAllowed {
+		h.recordElevation(r, claims, "not_allowed")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if writeQuotaExceeded(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to issue elevated token", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordElevation(r, claims, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(token)
+}
+
+// recordElevation stores an elevation_granted or elevation_failure audit event
+func (h *AuthHandler) recordElevation(r *http.Request, claims *services.Claims, reason string) {
+	event := &models.AuditEvent{
+		TenantID: claims.TenantID,
+		Type:     models.AuditEventElevationGranted,
+		UserID:   claims.UserID,
+		ClientID: claims.ClientID,
+		Reason:   reason,
+	}
+	if reason != "" {
+		event.Type = models.AuditEventElevationFailure
+	}
+	h.auditService.RecordRequest(r, event)
+}
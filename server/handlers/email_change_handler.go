@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+)
+
+type EmailChangeHandler struct {
+	emailChangeService *services.EmailChangeService
+	auditService       *services.AuditService
+}
+
+type EmailChangeRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+type VerifyEmailChangeRequest struct {
+	Token string `json:"token" validate:"required,max=100"`
+}
+
+// EmailChangeResponse describes the state of an email change
+type EmailChangeResponse struct {
+	Status                       string    `json:"status"` // "pending" or "completed"
+	NewEmail                     string    `json:"new_email"`
+	NewEmailVerified             bool      `json:"new_email_verified"`
+	OldEmailConfirmationRequired bool      `json:"old_email_confirmation_required"`
+	OldEmailConfirmed            bool      `json:"old_email_confirmed"`
+	ExpiresAt                    time.Time `json:"expires_at"`
+}
+
+func NewEmailChangeHandler(emailChangeService *services.EmailChangeService, auditService *services.AuditService) *EmailChangeHandler {
+	return &EmailChangeHandler{
+		emailChangeService: emailChangeService,
+		auditService:       auditService,
+	}
+}
+
+// RequestMyEmailChange starts changing the signed-in user's email address. The new address has
+// to be verified through the emailed link before it takes effect.
+func (h *EmailChangeHandler) RequestMyEmailChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req EmailChangeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	change, err := h.emailChangeService.RequestEmailChange(claims.UserID, claims.TenantID, req.Email)
+	if !writeEmailChangeError(w, err) {
+		return
+	}
+
+	writeEmailChange(w, http.StatusAccepted, change)
+}
+
+// VerifyEmailChange confirms an email change with the token from a verification link. It is
+// public: the token itself authorizes the confirmation.
+func (h *EmailChangeHandler) VerifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req VerifyEmailChangeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	change, err := h.emailChangeService.VerifyEmailChange(req.Token)
+	if !writeEmailChangeError(w, err) {
+		return
+	}
+
+	if change.CompletedAt != nil && h.auditService != nil {
+		h.auditService.RecordRequest(r, &models.AuditEvent{
+			TenantID: change.TenantID,
+			Type:     models.AuditEventEmailChanged,
+			UserID:   change.UserID,
+			Email:    change.NewEmail,
+		})
+	}
+
+	writeEmailChange(w, http.StatusOK, change)
+}
+
+// writeEmailChangeError writes the response for a failed email change operation and reports
+// whether the operation succeeded
+func writeEmailChangeError(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case services.ErrEmailInUse:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case services.ErrEmailUnchanged:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case services.ErrEmailChangeNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case services.ErrEmailChangeExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+	default:
+		http.Error(w, "Failed to change email: "+err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+func writeEmailChange(w http.ResponseWriter, status int, change *models.EmailChangeRequest) {
+	response := EmailChangeResponse{
+		Status:                       "pending",
+		NewEmail:                     change.NewEmail,
+		NewEmailVerified:             change.NewEmailVerifiedAt != nil,
+		OldEmailConfirmationRequired: change.RequiresOldEmailConfirmation(),
+		OldEmailConfirmed:            change.OldEmailConfirmedAt != nil,
+		ExpiresAt:                    change.ExpiresAt,
+	}
+	if change.CompletedAt != nil {
+		response.Status = "completed"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"oauth2-openid-server/i18n"
+	"oauth2-openid-server/models"
+)
+
+// brandColorPattern restricts tenant colors to hex values before they are placed in a style block
+var brandColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{3,8}$`)
+
+// writeErrorPage renders a tenant-branded HTML page for browser-facing authorization errors that
+// must not be sent to the client, such as an unknown client or an unregistered redirect URI.
+func writeErrorPage(w http.ResponseWriter, t *i18n.Localizer, branding models.TenantBranding, status int, message string) {
+	color := "#007cba"
+	if brandColorPattern.MatchString(branding.PrimaryColor) {
+		color = branding.PrimaryColor
+	}
+
+	header := ""
+	if branding.LogoURL != "" {
+		header = fmt.Sprintf(`<img src="%s" alt="%s" class="logo">`, html.EscapeString(branding.LogoURL), html.EscapeString(branding.CompanyName))
+	} else if branding.CompanyName != "" {
+		header = fmt.Sprintf(`<div class="company">%s</div>`, html.EscapeString(branding.CompanyName))
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html>
+<html lang="%s">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>%s</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; max-width: 400px; margin: 50px auto; padding: 20px; background: #f5f5f5; }
+        .container { background: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); border-top: 4px solid %s; }
+        .logo { max-height: 48px; margin-bottom: 16px; }
+        .company { font-weight: 600; margin-bottom: 16px; color: %s; }
+        .message { color: #444; }
+        button { background: %s; color: white; padding: 12px 24px; border: none; border-radius: 6px; cursor: pointer; font-size: 14px; font-weight: 500; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        %s
+        <h2>%s</h2>
+        <p class="message">%s</p>
+        <button type="button" onclick="history.back()">%s</button>
+    </div>
+</body>
+</html>`,
+		t.Locale(), html.EscapeString(t.T("page.error.title")),
+		color, color, color,
+		header,
+		html.EscapeString(t.T("page.error.title")), html.EscapeString(message),
+		html.EscapeString(t.T("page.error.back")))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", t.Locale())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write([]byte(page))
+}
+
+// redirectAuthorizeError returns an authorization error to the client as described in RFC 6749
+// section 4.1.2.1. The redirect URI must already have been validated against the client.
+func redirectAuthorizeError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, description string) {
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, description, http.StatusBadRequest)
+		return
+	}
+
+	query := redirectURL.Query()
+	query.Set("error", code)
+	if description != "" {
+		query.Set("error_description", description)
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	redirectURL.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/i18n"
+	"oauth2-openid-server/models"
+)
+
+func TestWriteErrorPageEscapesBranding(t *testing.T) {
+	rec := httptest.NewRecorder()
+	branding := models.TenantBranding{
+		CompanyName:  `<script>alert(1)</script>`,
+		PrimaryColor: `red; } body { display: none`,
+	}
+	writeErrorPage(rec, i18n.NewLocalizer("en", nil), branding, http.StatusBadRequest, "Invalid redirect URI")
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected an HTML page, got %q", ct)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>alert(1)") {
+		t.Error("Expected the company name to be escaped")
+	}
+	if strings.Contains(body, "display: none") {
+		t.Error("Expected a non-hex brand color to be ignored")
+	}
+	if !strings.Contains(body, "Invalid redirect URI") {
+		t.Error("Expected the error message on the page")
+	}
+}
+
+func TestRedirectAuthorizeError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+	redirectAuthorizeError(rec, req, "https://app.example.com/callback?keep=1", "xyz", "unsupported_response_type", "Unsupported response type")
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect, got %d", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid Location header: %v", err)
+	}
+	query := location.Query()
+	if query.Get("error") != "unsupported_response_type" || query.Get("state") != "xyz" || query.Get("keep") != "1" {
+		t.Errorf("Unexpected redirect query: %s", location.RawQuery)
+	}
+	if query.Get("error_description") != "Unsupported response type" {
+		t.Errorf("Expected an error_description, got %q", query.Get("error_description"))
+	}
+}
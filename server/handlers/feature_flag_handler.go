@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// FeatureFlagHandler manages the flags that roll out new auth capabilities
+type FeatureFlagHandler struct {
+	featureFlags  *services.FeatureFlagService
+	tenantService *services.TenantService
+}
+
+func NewFeatureFlagHandler(featureFlags *services.FeatureFlagService, tenantService *services.TenantService) *FeatureFlagHandler {
+	return &FeatureFlagHandler{
+		featureFlags:  featureFlags,
+		tenantService: tenantService,
+	}
+}
+
+type UpdateFeatureFlagRequest struct {
+	Enabled           bool `json:"enabled"`
+	RolloutPercentage *int `json:"rollout_percentage,omitempty" validate:"min=0,max=100"` // deployment flags only (default: 100)
+}
+
+// FeatureFlagsResponse lists the stored flags of a scope and what they resolve to
+type FeatureFlagsResponse struct {
+	Flags     []*models.FeatureFlag       `json:"flags"`
+	Effective []services.FeatureFlagState `json:"effective"`
+}
+
+// GetDeploymentFlags returns the deployment-wide flags
+func (h *FeatureFlagHandler) GetDeploymentFlags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.writeFlags(w, models.FeatureFlagScopeDeployment, "")
+}
+
+// UpdateDeploymentFlag sets a deployment-wide flag and its rollout percentage
+func (h *FeatureFlagHandler) UpdateDeploymentFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.update(w, r, models.FeatureFlagScopeDeployment)
+}
+
+// DeleteDeploymentFlag resets a deployment-wide flag to its default
+func (h *FeatureFlagHandler) DeleteDeploymentFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.delete(w, r, models.FeatureFlagScopeDeployment)
+}
+
+// GetTenantFlags returns a tenant's overrides and the state of every flag for the tenant
+func (h *FeatureFlagHandler) GetTenantFlags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID, ok := h.tenant(w, r)
+	if !ok {
+		return
+	}
+	h.writeFlags(w, tenantID, tenantID)
+}
+
+// UpdateTenantFlag overrides a flag for one tenant
+func (h *FeatureFlagHandler) UpdateTenantFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if tenantID, ok := h.tenant(w, r); ok {
+		h.update(w, r, tenantID)
+	}
+}
+
+// DeleteTenantFlag removes a tenant's override, so the deployment flag applies again
+func (h *FeatureFlagHandler) DeleteTenantFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if tenantID, ok := h.tenant(w, r); ok {
+		h.delete(w, r, tenantID)
+	}
+}
+
+func (h *FeatureFlagHandler) update(w http.ResponseWriter, r *http.Request, scope string) {
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req UpdateFeatureFlagRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	flag := &models.FeatureFlag{
+		Name:              mux.Vars(r)["name"],
+		Scope:             scope,
+		Enabled:           req.Enabled,
+		RolloutPercentage: 100,
+		UpdatedBy:         claims.UserID,
+	}
+	if req.RolloutPercentage != nil {
+		flag.RolloutPercentage = *req.RolloutPercentage
+	}
+
+	if err := h.featureFlags.Set(flag); err != nil {
+		if errors.Is(err, services.ErrUnknownFeatureFlag) {
+			http.Error(w, "Unknown feature flag", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update feature flag: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
+
+func (h *FeatureFlagHandler) delete(w http.ResponseWriter, r *http.Request, scope string) {
+	if err := h.featureFlags.Delete(scope, mux.Vars(r)["name"]); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownFeatureFlag):
+			http.Error(w, "Unknown feature flag", http.StatusNotFound)
+		case errors.Is(err, services.ErrFeatureFlagNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to delete feature flag: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *FeatureFlagHandler) writeFlags(w http.ResponseWriter, scope, tenantID string) {
+	flags, err := h.featureFlags.List(scope)
+	if err != nil {
+		http.Error(w, "Failed to get feature flags", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FeatureFlagsResponse{
+		Flags:     flags,
+		Effective: h.featureFlags.States(tenantID),
+	})
+}
+
+// tenant returns the tenant of a /tenants/{id} route if it exists
+func (h *FeatureFlagHandler) tenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tenantID := mux.Vars(r)["id"]
+	if _, err := h.tenantService.GetTenantByID(tenantID); err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return "", false
+	}
+	return tenantID, true
+}
+
+// featureEnabled reports whether a feature flag is on for a tenant, using the flag's default when
+// no flag service is configured
+func featureEnabled(featureFlags *services.FeatureFlagService, name, tenantID string) bool {
+	if featureFlags == nil {
+		return services.FeatureDefault(name)
+	}
+	return featureFlags.Enabled(name, tenantID)
+}
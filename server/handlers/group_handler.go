@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"oauth2-openid-server/middleware"
 	"oauth2-openid-server/models"
@@ -12,30 +14,42 @@ import (
 )
 
 type GroupHandler struct {
-	groupService *services.GroupService
+	groupService      *services.GroupService
+	membershipService *services.MembershipService
+	auditService      *services.AuditService
 }
 
 type CreateGroupRequest struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Scopes      []string `json:"scopes"`
+	Name        string   `json:"name" validate:"required,max=100"`
+	Description string   `json:"description" validate:"max=500"`
+	Scopes      []string `json:"scopes" validate:"dive,max=100"`
 	Members     []string `json:"members"`
+	Owners      []string `json:"owners" validate:"max=20,dive,max=64"`   // users reviewing the members in access reviews
+	Managers    []string `json:"managers" validate:"max=20,dive,max=64"` // users administering the members
+	RequireMFA  bool     `json:"require_mfa"`                            // members must use a second factor to sign in
 }
 
 type UpdateGroupRequest struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Scopes      []string `json:"scopes"`
+	Name        string   `json:"name" validate:"required,max=100"`
+	Description string   `json:"description" validate:"max=500"`
+	Scopes      []string `json:"scopes" validate:"dive,max=100"`
 	Members     []string `json:"members"`
+	Owners      []string `json:"owners" validate:"max=20,dive,max=64"`   // omitted: unchanged
+	Managers    []string `json:"managers" validate:"max=20,dive,max=64"` // omitted: unchanged
+	RequireMFA  bool     `json:"require_mfa"`
+	Version     *int64   `json:"version,omitempty"` // alternative to the If-Match header
 }
 
 type AddMemberRequest struct {
-	UserID string `json:"user_id"`
+	UserID    string     `json:"user_id" validate:"required"`
+	ExpiresAt *time.Time `json:"expires_at"` // the membership ends then; omitted: it doesn't expire
 }
 
-func NewGroupHandler(groupService *services.GroupService) *GroupHandler {
+func NewGroupHandler(groupService *services.GroupService, membershipService *services.MembershipService, auditService *services.AuditService) *GroupHandler {
 	return &GroupHandler{
-		groupService: groupService,
+		groupService:      groupService,
+		membershipService: membershipService,
+		auditService:      auditService,
 	}
 }
 
@@ -48,13 +62,7 @@ func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	var createReq CreateGroupRequest
-	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if createReq.Name == "" {
-		http.Error(w, "Group name is required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &createReq) {
 		return
 	}
 
@@ -68,22 +76,33 @@ func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		Name:        createReq.Name,
 		Description: createReq.Description,
 		Scopes:      createReq.Scopes,
-		Members:     createReq.Members,
+		Members:     []string{},
+		Owners:      createReq.Owners,
+		Managers:    createReq.Managers,
+		RequireMFA:  createReq.RequireMFA,
 		TenantID:    tenantID,
 	}
 
 	if group.Scopes == nil {
 		group.Scopes = []string{}
 	}
-	if group.Members == nil {
-		group.Members = []string{}
-	}
 
 	if err := h.groupService.CreateGroup(group); err != nil {
 		http.Error(w, "Failed to create group: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Memberships are recorded on the users; the member list is derived from them
+	if len(createReq.Members) > 0 {
+		if err := h.membershipService.SetGroupMembers(group.ID.Hex(), tenantID, createReq.Members); err != nil {
+			http.Error(w, "Failed to set group members: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if created, err := h.groupService.GetGroupByID(group.ID.Hex(), tenantID); err == nil {
+			group = created
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(group)
@@ -127,6 +146,7 @@ func (h *GroupHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, group.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(group)
 }
@@ -142,13 +162,12 @@ func (h *GroupHandler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	var updateReq UpdateGroupRequest
-	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &updateReq) {
 		return
 	}
 
-	if updateReq.Name == "" {
-		http.Error(w, "Group name is required", http.StatusBadRequest)
+	version, ok := expectedVersion(w, r, updateReq.Version)
+	if !ok {
 		return
 	}
 
@@ -158,31 +177,54 @@ func (h *GroupHandler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	current, err := h.groupService.GetGroupByID(groupID, tenantID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
 	group := &models.Group{
 		Name:        updateReq.Name,
 		Description: updateReq.Description,
 		Scopes:      updateReq.Scopes,
-		Members:     updateReq.Members,
+		Members:     current.Members,
+		Owners:      current.Owners,
+		Managers:    current.Managers,
+		RequireMFA:  updateReq.RequireMFA,
+	}
+	if updateReq.Owners != nil {
+		group.Owners = updateReq.Owners
+	}
+	if updateReq.Managers != nil {
+		group.Managers = updateReq.Managers
 	}
 
 	if group.Scopes == nil {
 		group.Scopes = []string{}
 	}
-	if group.Members == nil {
-		group.Members = []string{}
-	}
 
-	if err := h.groupService.UpdateGroup(groupID, tenantID, group); err != nil {
+	if err := h.groupService.UpdateGroup(groupID, tenantID, group, version); err != nil {
+		if writeVersionConflict(w, err) {
+			return
+		}
 		http.Error(w, "Failed to update group: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if updateReq.Members != nil {
+		if err := h.membershipService.SetGroupMembers(groupID, tenantID, updateReq.Members); err != nil {
+			http.Error(w, "Failed to set group members: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	updatedGroup, err := h.groupService.GetGroupByID(groupID, tenantID)
 	if err != nil {
 		http.Error(w, "Failed to get updated group", http.StatusInternalServerError)
 		return
 	}
 
+	setETag(w, updatedGroup.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedGroup)
 }
@@ -202,6 +244,11 @@ func (h *GroupHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.membershipService.RemoveGroup(groupID, tenantID); err != nil {
+		http.Error(w, "Failed to update group memberships: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -216,20 +263,29 @@ func (h *GroupHandler) AddMember(w http.ResponseWriter, r *http.Request) {
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	var addReq AddMemberRequest
-	if err := json.NewDecoder(r.Body).Decode(&addReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &addReq) {
 		return
 	}
 
-	if addReq.UserID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
-		return
+	// Group managers only move users between the groups they manage; they can't pull other users
+	// of the tenant into their groups
+	if !middleware.IsUserAdministrator(r) {
+		claims := middleware.GetClaimsFromRequest(r)
+		if claims == nil || !h.membershipService.ManagesUser(tenantID, claims.UserID, addReq.UserID) {
+			writeErrorResponse(w, http.StatusForbidden, "group_manager_required", "Group managers may only add members of the groups they manage", nil)
+			return
+		}
 	}
 
-	if err := h.groupService.AddMemberToGroup(groupID, addReq.UserID, tenantID); err != nil {
+	if err := h.membershipService.AddMemberUntil(groupID, addReq.UserID, tenantID, addReq.ExpiresAt); err != nil {
+		if errors.Is(err, services.ErrMembershipExpiryInPast) {
+			writeErrorResponse(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
 		http.Error(w, "Failed to add member: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordActivity(h.auditService, r, &models.AuditEvent{TenantID: tenantID, Type: models.AuditEventGroupMemberAdded, UserID: addReq.UserID, Target: groupID})
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Member added successfully"})
@@ -246,15 +302,39 @@ func (h *GroupHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
 	userID := vars["userId"]
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
-	if err := h.groupService.RemoveMemberFromGroup(groupID, userID, tenantID); err != nil {
+	if err := h.membershipService.RemoveMember(groupID, userID, tenantID); err != nil {
 		http.Error(w, "Failed to remove member: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordActivity(h.auditService, r, &models.AuditEvent{TenantID: tenantID, Type: models.AuditEventGroupMemberRemoved, UserID: userID, Target: groupID})
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Member removed successfully"})
 }
 
+// ReconcileMemberships repairs dangling group references and rebuilds member lists for the tenant
+func (h *GroupHandler) ReconcileMemberships(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.membershipService.Reconcile(tenantID)
+	if err != nil {
+		http.Error(w, "Failed to reconcile memberships: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
 func (h *GroupHandler) GetUserGroups(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
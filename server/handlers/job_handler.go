@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+	"oauth2-openid-server/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// JobHandler starts long-running admin operations as background jobs and reports their progress
+type JobHandler struct {
+	jobService    *services.JobService
+	tenantService *services.TenantService
+}
+
+// ImportUsersRequest is the body of a bulk user import
+type ImportUsersRequest struct {
+	Users []services.UserImportItem `json:"users" validate:"required,max=10000"`
+}
+
+// RevokeTokensRequest selects the tokens of the tenant to revoke
+type RevokeTokensRequest struct {
+	ClientID string `json:"client_id" validate:"max=128"`
+	UserID   string `json:"user_id" validate:"max=64"`
+	All      bool   `json:"all"` // required to revoke every token of the tenant
+}
+
+func NewJobHandler(jobService *services.JobService, tenantService *services.TenantService) *JobHandler {
+	return &JobHandler{
+		jobService:    jobService,
+		tenantService: tenantService,
+	}
+}
+
+// GetJobs lists the tenant's recent jobs, newest first
+func (h *JobHandler) GetJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobs, err := h.jobService.List(middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to get jobs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": jobs})
+}
+
+// GetJob reports the status, progress and result of a job
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, err := h.jobService.Get(middleware.GetTenantIDFromRequest(r), mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrJobNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// ImportUsers starts a job creating the given users in the tenant
+func (h *JobHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	var req ImportUsersRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	// The validator doesn't descend into the users, so each one is validated on its own
+	var errs validation.Errors
+	for i := range req.Users {
+		for _, fieldErr := range validation.Struct(&req.Users[i]) {
+			prefix := fmt.Sprintf("users[%d].", i)
+			fieldErr.Field, fieldErr.Message = prefix+fieldErr.Field, prefix+fieldErr.Message
+			errs = append(errs, fieldErr)
+		}
+	}
+	if errs != nil {
+		writeValidationErrors(w, "validation_failed", "Request validation failed", errs)
+		return
+	}
+
+	h.submit(w, r, tenantID, models.JobUserImport, services.UserImportParams{Users: req.Users})
+}
+
+// RevokeTokens starts a job revoking the tenant's refresh and access tokens of a client, a user
+// or, with "all", every token
+func (h *JobHandler) RevokeTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	var req RevokeTokensRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.ClientID == "" && req.UserID == "" && !req.All {
+		http.Error(w, "Name a client_id or user_id, or set all to revoke every token of the tenant", http.StatusBadRequest)
+		return
+	}
+
+	h.submit(w, r, tenantID, models.JobTokenRevocation, services.TokenRevocationParams{ClientID: req.ClientID, UserID: req.UserID})
+}
+
+// PurgeTenant starts a job permanently deleting a deactivated tenant and its data. Only the
+// platform operator can purge tenants.
+func (h *JobHandler) PurgeTenant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !middleware.IsPlatformOperator(r) {
+		http.Error(w, "Tenants are purged by the platform operator", http.StatusForbidden)
+		return
+	}
+
+	purgeID := mux.Vars(r)["id"]
+	if defaultTenant, err := h.tenantService.GetDefaultTenant(); err == nil && defaultTenant.ID.Hex() == purgeID {
+		http.Error(w, "The default tenant can't be purged", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.tenantService.GetTenantByID(purgeID); err == nil {
+		http.Error(w, "Deactivate the tenant before purging it", http.StatusConflict)
+		return
+	}
+
+	h.submit(w, r, middleware.GetTenantIDFromRequest(r), models.JobTenantPurge, services.TenantPurgeParams{TenantID: purgeID})
+}
+
+// submit queues a job and answers 202 Accepted with the job and its status URL
+func (h *JobHandler) submit(w http.ResponseWriter, r *http.Request, tenantID, jobType string, params interface{}) {
+	createdBy := ""
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+		createdBy = claims.UserID
+	}
+
+	job, err := h.jobService.Submit(tenantID, jobType, createdBy, params)
+	if err != nil {
+		http.Error(w, "Failed to start job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID.Hex())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
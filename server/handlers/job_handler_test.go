@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/middleware"
+)
+
+func TestJobRequestsRejectedBeforeQueueing(t *testing.T) {
+	h := &JobHandler{}
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name:    "invalid imported user",
+			path:    "/api/v1/users/import",
+			body:    `{"users":[{"email":"jane@acme.com","password":"secret1"},{"email":"not-an-email","password":"secret1"}]}`,
+			handler: h.ImportUsers,
+			want:    `"field":"users[1].email"`,
+		},
+		{
+			name:    "empty import",
+			path:    "/api/v1/users/import",
+			body:    `{"users":[]}`,
+			handler: h.ImportUsers,
+			want:    `"field":"users"`,
+		},
+		{
+			name:    "revocation without filter",
+			path:    "/api/v1/tokens/revoke",
+			body:    `{}`,
+			handler: h.RevokeTokens,
+			want:    "set all to revoke every token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), middleware.TenantIDKey, "tenant-1"))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("Expected 400 mentioning %s, got %d %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -10,24 +10,31 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"log"
 	"math/big"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"oauth2-openid-server/models"
 	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
 )
 
 // JWKSHandler handles JSON Web Key Set endpoints
 type JWKSHandler struct {
-	jwtSecret       string
 	cryptoKeyService *services.CryptoKeyService
 }
 
+// jwksMaxAge caps how long relying parties may cache the key set
+const jwksMaxAge = time.Hour
+
 // NewJWKSHandler creates a new JWKS handler
-func NewJWKSHandler(jwtSecret string, cryptoKeyService *services.CryptoKeyService) *JWKSHandler {
+func NewJWKSHandler(cryptoKeyService *services.CryptoKeyService) *JWKSHandler {
 	return &JWKSHandler{
-		jwtSecret:       jwtSecret,
 		cryptoKeyService: cryptoKeyService,
 	}
 }
@@ -38,7 +45,7 @@ type JWK struct {
 	Use string `json:"use"`           // Public Key Use
 	Alg string `json:"alg"`           // Algorithm
 	Kid string `json:"kid"`           // Key ID
-	K   string `json:"k,omitempty"`   // Key Value (for symmetric keys)
+	K   string `json:"k,omitempty"`   // Key Value (symmetric keys, only served to the owning client)
 	N   string `json:"n,omitempty"`   // Modulus (for RSA keys)
 	E   string `json:"e,omitempty"`   // Exponent (for RSA keys)
 	X   string `json:"x,omitempty"`   // X coordinate (for EC keys)
@@ -51,7 +58,8 @@ type JWKSet struct {
 	Keys []JWK `json:"keys"`
 }
 
-// GetJWKS handles the JWKS endpoint
+// GetJWKS handles the JWKS endpoint. Only public keys are published, including next keys ahead
+// of their activation. Responses carry an ETag and are cacheable until the next key change.
 func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -61,54 +69,69 @@ func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var keys []JWK
-
-	// HMAC-SHA256 key (symmetric) - keep for backward compatibility
-	hmacKeyID := h.generateKeyID()
-	hmacJWK := JWK{
-		Kty: "oct",   // Octet sequence (symmetric key)
-		Use: "sig",   // Signature
-		Alg: "HS256", // HMAC SHA-256
-		Kid: hmacKeyID,
-		K:   base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString([]byte(h.jwtSecret)),
-	}
-	keys = append(keys, hmacJWK)
-
-	// Load RSA and ECDSA keys from database
-	dbKeys, err := h.cryptoKeyService.GetActiveKeys(ctx)
+	// Load RSA and ECDSA keys from database: the platform keys plus the tenant's own keys
+	dbKeys, err := h.cryptoKeyService.GetActiveKeysForTenant(ctx, mux.Vars(r)["tenantId"])
 	if err != nil {
 		http.Error(w, "Failed to load cryptographic keys", http.StatusInternalServerError)
 		return
 	}
 
-	// Convert database keys to JWKs
+	// Stable order so the ETag only changes when the key set does
+	sort.Slice(dbKeys, func(i, j int) bool {
+		return dbKeys[i].KeyID < dbKeys[j].KeyID
+	})
+
+	keys := []JWK{}
 	for _, dbKey := range dbKeys {
 		jwk, err := h.convertToJWK(&dbKey)
 		if err != nil {
 			// Log error but continue with other keys
+			log.Printf("Warning: Failed to publish key %s: %v", dbKey.KeyID, err)
 			continue
 		}
 		keys = append(keys, jwk)
 	}
 
-	jwkSet := JWKSet{
-		Keys: keys,
+	body, err := json.Marshal(JWKSet{Keys: keys})
+	if err != nil {
+		http.Error(w, "Failed to encode JWKS", http.StatusInternalServerError)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "max-age=3600") // Cache for 1 hour
-	
-	if err := json.NewEncoder(w).Encode(jwkSet); err != nil {
-		http.Error(w, "Failed to encode JWKS", http.StatusInternalServerError)
+	hash := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(hash[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksCacheSeconds(dbKeys, time.Now())))
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// jwksCacheSeconds returns how long the key set may be cached: until the next key activation or
+// expiry, at most jwksMaxAge
+func jwksCacheSeconds(keys []models.CryptoKey, now time.Time) int {
+	maxAge := jwksMaxAge
+	if next := services.NextKeyChange(keys, now); next != nil && next.Sub(now) < maxAge {
+		maxAge = next.Sub(now)
+	}
+	return int(maxAge.Seconds())
 }
 
-// generateKeyID creates a unique key identifier based on the secret
-func (h *JWKSHandler) generateKeyID() string {
-	hash := sha256.Sum256([]byte(h.jwtSecret))
-	// Use first 8 bytes of hash as key ID
-	return base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(hash[:8])
+// etagMatches reports whether an If-None-Match header matches the ETag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
 }
 
 // convertToJWK converts a database CryptoKey to a JWK
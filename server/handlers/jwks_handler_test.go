@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+func TestJWKSCacheSeconds(t *testing.T) {
+	now := time.Now()
+
+	if got := jwksCacheSeconds(nil, now); got != 3600 {
+		t.Errorf("Expected the maximum cache time without key changes, got %d", got)
+	}
+
+	activatesAt := now.Add(10 * time.Minute)
+	keys := []models.CryptoKey{{Active: true}, {Active: true, ActivatesAt: &activatesAt}}
+	if got := jwksCacheSeconds(keys, now); got != 600 {
+		t.Errorf("Expected caching until the next key activation, got %d", got)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := `"abc"`
+
+	for _, header := range []string{`"abc"`, `W/"abc"`, `"x", "abc"`, "*"} {
+		if !etagMatches(header, etag) {
+			t.Errorf("Expected %q to match %s", header, etag)
+		}
+	}
+	for _, header := range []string{"", `"abcd"`, "abc"} {
+		if etagMatches(header, etag) {
+			t.Errorf("Expected %q not to match %s", header, etag)
+		}
+	}
+}
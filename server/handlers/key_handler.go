@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type KeyHandler struct {
+	cryptoKeyService *services.CryptoKeyService
+	oauthService     *services.OAuthService
+}
+
+type RotateKeysRequest struct {
+	GracePeriodHours int `json:"grace_period_hours" validate:"min=0,max=8760"` // 0 = tenant policy
+	// A canary rotation signs only this percentage of new tokens with the new keys until it is
+	// promoted or rolled back (0 = rotate right away)
+	CanaryPercent     int `json:"canary_percent" validate:"min=0,max=99"`
+	CanaryMaxFailures int `json:"canary_max_failures" validate:"min=2"` // distinct clients reporting failures that roll the canary back (0 = manual only)
+}
+
+// KeyCanaryRequest promotes or rolls back a canary rotation
+type KeyCanaryRequest struct {
+	GracePeriodHours int `json:"grace_period_hours" validate:"min=0,max=8760"` // how long the replaced or rolled back keys stay published, 0 = tenant policy
+}
+
+// KeyResponse is the public part of a signing key
+type KeyResponse struct {
+	KeyID     string           `json:"kid"`
+	KeyType   string           `json:"key_type"`
+	Algorithm string           `json:"alg"`
+	PublicKey string           `json:"public_key"`
+	Status    models.KeyStatus `json:"status"`
+	Region    string           `json:"region,omitempty"` // region of the instance that created it
+	CreatedAt time.Time        `json:"created_at"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+	RevokedAt *time.Time       `json:"revoked_at,omitempty"`
+
+	CanaryPercent      int        `json:"canary_percent,omitempty"`
+	CanaryMaxFailures  int        `json:"canary_max_failures,omitempty"`
+	CanaryTokens       int        `json:"canary_tokens,omitempty"`   // tokens signed while it was a canary
+	CanaryFailures     int        `json:"canary_failures,omitempty"` // failures reported by clients of the key's tenant
+	RolledBackAt       *time.Time `json:"rolled_back_at,omitempty"`
+	ValidationFailures int        `json:"validation_failures,omitempty"` // tokens relying parties reported they failed to validate
+	FailingClients     int        `json:"failing_clients,omitempty"`     // distinct clients of the key's tenant among the reporters
+	LastFailure        string     `json:"last_failure,omitempty"`
+}
+
+func NewKeyHandler(cryptoKeyService *services.CryptoKeyService, oauthService *services.OAuthService) *KeyHandler {
+	return &KeyHandler{
+		cryptoKeyService: cryptoKeyService,
+		oauthService:     oauthService,
+	}
+}
+
+// ListKeys returns the public parts of the tenant's signing keys
+func (h *KeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keys, err := h.cryptoKeyService.ListKeys(ctx, middleware.GetTenantIDFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to list keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": keyResponses(keys),
+	})
+}
+
+// RotateKeys creates new signing keys; the previous keys stay published for the grace period.
+// With a canary percentage the new keys only sign that share of new tokens at first.
+func (h *KeyHandler) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RotateKeysRequest
+	if r.ContentLength != 0 && !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	var keys []models.CryptoKey
+	var err error
+	if req.CanaryPercent > 0 {
+		keys, err = h.cryptoKeyService.StartKeyCanary(ctx, tenantID, req.CanaryPercent, req.CanaryMaxFailures)
+	} else {
+		keys, err = h.cryptoKeyService.RotateTenantKeys(ctx, tenantID, time.Duration(req.GracePeriodHours)*time.Hour)
+	}
+	if err == services.ErrKeyChangeInProgress || err == services.ErrKeyCanaryRunning {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to rotate keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": keyResponses(keys),
+	})
+}
+
+// PromoteKeyCanary makes the canary keys the current signing keys
+func (h *KeyHandler) PromoteKeyCanary(w http.ResponseWriter, r *http.Request) {
+	h.finishKeyCanary(w, r, h.cryptoKeyService.PromoteKeyCanary)
+}
+
+// RollbackKeyCanary stops signing with the canary keys
+func (h *KeyHandler) RollbackKeyCanary(w http.ResponseWriter, r *http.Request) {
+	h.finishKeyCanary(w, r, h.cryptoKeyService.RollbackKeyCanary)
+}
+
+func (h *KeyHandler) finishKeyCanary(w http.ResponseWriter, r *http.Request, finish func(context.Context, string, time.Duration) ([]models.CryptoKey, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req KeyCanaryRequest
+	if r.ContentLength != 0 && !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	keys, err := finish(ctx, middleware.GetTenantIDFromRequest(r), time.Duration(req.GracePeriodHours)*time.Hour)
+	if err == services.ErrNoKeyCanary || err == services.ErrKeyChangeInProgress {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to finish the canary rotation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": keyResponses(keys),
+	})
+}
+
+// ReportValidationFailure lets a relying party report a token it failed to validate, by the
+// kid in the token header and an optional error description. The client authenticates like at
+// the token endpoint. Reports of enough distinct clients for canary keys roll the canary back.
+func (h *KeyHandler) ReportValidationFailure(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID, clientSecret, basic, err := clientCredentials(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	client, err := h.oauthService.ValidateClient(clientID, clientSecret)
+	if err != nil {
+		writeInvalidClient(w, basic, err.Error())
+		return
+	}
+
+	keyID := r.FormValue("kid")
+	if keyID == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "kid is required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = h.cryptoKeyService.RecordValidationFailure(ctx, middleware.GetTenantIDFromRequest(r), client.ClientID, keyID, r.FormValue("error"))
+	if err == services.ErrKeyNotFound {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Unknown kid")
+		return
+	}
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to record the validation failure")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeKey withdraws a compromised key immediately
+func (h *KeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := h.cryptoKeyService.RevokeKey(ctx, middleware.GetTenantIDFromRequest(r), mux.Vars(r)["kid"])
+	if err == services.ErrKeyNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err == services.ErrKeyChangeInProgress {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func keyResponses(keys []models.CryptoKey) []KeyResponse {
+	now := time.Now()
+	responses := make([]KeyResponse, len(keys))
+	for i := range keys {
+		key := &keys[i]
+		responses[i] = KeyResponse{
+			KeyID:     key.KeyID,
+			KeyType:   key.KeyType,
+			Algorithm: key.Algorithm,
+			PublicKey: string(key.PublicKey),
+			Status:    key.Status(now),
+			Region:    key.Region,
+			CreatedAt: key.CreatedAt,
+			ExpiresAt: key.ExpiresAt,
+			RevokedAt: key.RevokedAt,
+
+			CanaryPercent:      key.CanaryPercent,
+			CanaryMaxFailures:  key.CanaryMaxFailures,
+			CanaryTokens:       key.CanaryTokens,
+			CanaryFailures:     key.CanaryFailures,
+			RolledBackAt:       key.RolledBackAt,
+			ValidationFailures: key.ValidationFailures,
+			FailingClients:     len(key.FailingClients),
+			LastFailure:        key.LastFailure,
+		}
+	}
+	return responses
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// MaintenanceHandler manages the maintenance modes that freeze logins and token issuance
+type MaintenanceHandler struct {
+	maintenanceService *services.MaintenanceService
+	tenantService      *services.TenantService
+}
+
+func NewMaintenanceHandler(maintenanceService *services.MaintenanceService, tenantService *services.TenantService) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		maintenanceService: maintenanceService,
+		tenantService:      tenantService,
+	}
+}
+
+type UpdateMaintenanceRequest struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message" validate:"max=500"`
+	RetryAfter int        `json:"retry_after" validate:"min=0,max=86400"` // seconds (default: 300)
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+}
+
+// GetGlobalMaintenance returns the platform-wide maintenance mode
+func (h *MaintenanceHandler) GetGlobalMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.requirePlatform(w, r) {
+		return
+	}
+	h.writeMode(w, models.MaintenanceScopeGlobal)
+}
+
+// UpdateGlobalMaintenance turns the platform-wide maintenance mode on or off
+func (h *MaintenanceHandler) UpdateGlobalMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.requirePlatform(w, r) {
+		return
+	}
+	h.update(w, r, models.MaintenanceScopeGlobal)
+}
+
+// GetTenantMaintenance returns a tenant's maintenance mode
+func (h *MaintenanceHandler) GetTenantMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := mux.Vars(r)["id"]
+	if _, err := h.tenantService.GetTenantByID(tenantID); err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	h.writeMode(w, tenantID)
+}
+
+// UpdateTenantMaintenance turns a tenant's login freeze on or off
+func (h *MaintenanceHandler) UpdateTenantMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := mux.Vars(r)["id"]
+	if _, err := h.tenantService.GetTenantByID(tenantID); err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	h.update(w, r, tenantID)
+}
+
+func (h *MaintenanceHandler) update(w http.ResponseWriter, r *http.Request, scope string) {
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req UpdateMaintenanceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	var mode *models.MaintenanceMode
+	var err error
+	if req.Enabled {
+		mode = &models.MaintenanceMode{
+			Scope:      scope,
+			Message:    req.Message,
+			RetryAfter: req.RetryAfter,
+			EndsAt:     req.EndsAt,
+			UpdatedBy:  claims.UserID,
+		}
+		err = h.maintenanceService.Enable(mode)
+	} else {
+		mode, err = h.maintenanceService.Disable(scope, claims.UserID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update maintenance mode: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mode)
+}
+
+func (h *MaintenanceHandler) writeMode(w http.ResponseWriter, scope string) {
+	mode, err := h.maintenanceService.Get(scope)
+	if err != nil {
+		http.Error(w, "Failed to get maintenance mode", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mode)
+}
+
+// requirePlatform only lets the platform operator manage the global maintenance mode
+func (h *MaintenanceHandler) requirePlatform(w http.ResponseWriter, r *http.Request) bool {
+	if !middleware.IsPlatformOperator(r) {
+		http.Error(w, "The global maintenance mode is managed by the platform operator", http.StatusForbidden)
+		return false
+	}
+	return true
+}
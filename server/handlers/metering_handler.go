@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/services"
+)
+
+type MeteringHandler struct {
+	meteringService *services.MeteringService
+}
+
+func NewMeteringHandler(meteringService *services.MeteringService) *MeteringHandler {
+	return &MeteringHandler{
+		meteringService: meteringService,
+	}
+}
+
+// GetRollups returns the billable usage (active users, tokens issued, MFA verifications) per
+// tenant for ?period=YYYY-MM (default: current month), optionally limited to ?tenant_id=
+func (h *MeteringHandler) GetRollups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	rollups, err := h.meteringService.GetRollups(query.Get("tenant_id"), query.Get("period"))
+	if err == services.ErrInvalidUsagePeriod {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get metering rollups: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rollups": rollups,
+	})
+}
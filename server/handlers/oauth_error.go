@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// OAuthErrorResponse is the RFC 6749 error body returned by OAuth protocol endpoints
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// writeOAuthError writes a JSON OAuth error response with the given status code
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"oauth2-openid-server/services"
+)
+
+// checkPasswordBreach refuses a password the tenant's breach policy doesn't allow. It writes the
+// error response and returns false when the password can't be used.
+func checkPasswordBreach(w http.ResponseWriter, passwordBreach *services.PasswordBreachService, tenantID, password string) bool {
+	err := passwordBreach.Check(tenantID, password)
+	if err == nil {
+		return true
+	}
+	if !writePasswordBreachError(w, err) {
+		http.Error(w, "Failed to check password: "+err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// writePasswordBreachError writes the response for a password refused by the tenant's breach
+// policy and reports whether err was such a refusal
+func writePasswordBreachError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrPasswordBreached):
+		writeErrorResponse(w, http.StatusBadRequest, "password_breached", "This password has appeared in a data breach, choose a different one", nil)
+	case errors.Is(err, services.ErrPasswordBreachUnavailable):
+		writeErrorResponse(w, http.StatusServiceUnavailable, "password_check_unavailable", "Passwords can't be checked right now, try again later", nil)
+	default:
+		return false
+	}
+	return true
+}
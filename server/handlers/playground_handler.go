@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+)
+
+// playgroundScope is the scope the playground asks for unless the user changes it
+const playgroundScope = "openid profile email"
+
+// PlaygroundHandler serves the tenant's playground: a page that runs an authorization code flow
+// with PKCE against the built-in playground client and shows the tokens it gets, so integrators
+// can check a tenant's configuration without writing a client.
+type PlaygroundHandler struct {
+	clientService      *services.ClientService
+	tenantService      *services.TenantService
+	translationService *services.TranslationService
+}
+
+func NewPlaygroundHandler(clientService *services.ClientService, tenantService *services.TenantService, translationService *services.TranslationService) *PlaygroundHandler {
+	return &PlaygroundHandler{
+		clientService:      clientService,
+		tenantService:      tenantService,
+		translationService: translationService,
+	}
+}
+
+// ShowPlayground renders the playground page. The page is its own redirect URI: the flow starts
+// and ends in the browser, which exchanges the code with its PKCE verifier.
+func (h *PlaygroundHandler) ShowPlayground(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	t := h.translationService.LocalizerForRequest(r, tenantID)
+	tenant, err := h.tenantService.GetTenantByID(tenantID)
+	if err != nil || !tenant.Settings.PlaygroundEnabled {
+		writeErrorPage(w, t, models.TenantBranding{}, http.StatusNotFound, services.ErrPlaygroundDisabled.Error())
+		return
+	}
+
+	base := requestBaseURL(r) + "/tenant/" + tenantID
+	pageURL := base + "/playground"
+	if err := h.clientService.InitializePlaygroundClient(tenantID, pageURL); err != nil {
+		log.Printf("Failed to set up the playground client of tenant %s: %v", tenantID, err)
+		http.Error(w, "Failed to set up the playground", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Write([]byte(playgroundPage(tenant, base, pageURL)))
+}
+
+// playgroundPage renders the playground of a tenant whose endpoints are under base
+func playgroundPage(tenant *models.Tenant, base, pageURL string) string {
+	color := "#007cba"
+	if brandColorPattern.MatchString(tenant.Settings.CustomBranding.PrimaryColor) {
+		color = tenant.Settings.CustomBranding.PrimaryColor
+	}
+
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Playground - %s</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; max-width: 860px; margin: 40px auto; padding: 20px; background: #f5f5f5; }
+        .container { background: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); border-top: 4px solid %s; }
+        .step { color: #666; font-size: 14px; }
+        input { width: 100%%; padding: 10px; border: 1px solid #ddd; border-radius: 6px; box-sizing: border-box; }
+        button { background: %s; color: white; padding: 12px 24px; border: none; border-radius: 6px; cursor: pointer; font-size: 14px; font-weight: 500; margin-top: 12px; }
+        pre { background: #f8f9fa; padding: 12px; border-radius: 6px; overflow-x: auto; font-size: 13px; white-space: pre-wrap; word-break: break-all; }
+        .error { color: #dc3545; }
+        dt { font-weight: 600; margin-top: 8px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h2>%s playground</h2>
+        <p class="step">Signs in through an authorization code flow with PKCE against the built-in client
+        <code>%s</code> and shows the tokens the tenant issues.</p>
+        <dl>
+            <dt>Authorization endpoint</dt><dd><code id="authorize-endpoint"></code></dd>
+            <dt>Token endpoint</dt><dd><code id="token-endpoint"></code></dd>
+            <dt>Redirect URI</dt><dd><code id="redirect-uri"></code></dd>
+        </dl>
+        <label for="scope">Scope</label>
+        <input type="text" id="scope">
+        <button type="button" onclick="start()">Sign in</button>
+        <div id="result"></div>
+    </div>
+
+    <script>
+        const clientId = %s;
+        const base = %s;
+        const redirectUri = %s;
+        const endpoints = {
+            authorize: base + '/oauth/authorize',
+            token: base + '/oauth/token',
+            userinfo: base + '/api/v1/users/me'
+        };
+        document.getElementById('authorize-endpoint').textContent = endpoints.authorize;
+        document.getElementById('token-endpoint').textContent = endpoints.token;
+        document.getElementById('redirect-uri').textContent = redirectUri;
+        document.getElementById('scope').value = sessionStorage.getItem('playground_scope') || %s;
+
+        function base64url(bytes) {
+            return btoa(String.fromCharCode(...new Uint8Array(bytes))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+        }
+
+        function randomString() {
+            return base64url(crypto.getRandomValues(new Uint8Array(32)));
+        }
+
+        async function start() {
+            const verifier = randomString();
+            const state = randomString();
+            const challenge = base64url(await crypto.subtle.digest('SHA-256', new TextEncoder().encode(verifier)));
+            const scope = document.getElementById('scope').value;
+            sessionStorage.setItem('playground_verifier', verifier);
+            sessionStorage.setItem('playground_state', state);
+            sessionStorage.setItem('playground_scope', scope);
+
+            const params = new URLSearchParams({
+                response_type: 'code',
+                client_id: clientId,
+                redirect_uri: redirectUri,
+                scope: scope,
+                state: state,
+                code_challenge: challenge,
+                code_challenge_method: 'S256'
+            });
+            window.location.href = endpoints.authorize + '?' + params.toString();
+        }
+
+        function decodeJWT(token) {
+            const parts = (token || '').split('.');
+            if (parts.length !== 3) return null;
+            const decode = part => JSON.parse(decodeURIComponent(escape(atob(part.replace(/-/g, '+').replace(/_/g, '/')))));
+            try {
+                return { header: decode(parts[0]), payload: decode(parts[1]) };
+            } catch (e) {
+                return null;
+            }
+        }
+
+        function show(title, value, isError) {
+            const section = document.createElement('div');
+            const heading = document.createElement('h3');
+            heading.textContent = title;
+            if (isError) heading.className = 'error';
+            const body = document.createElement('pre');
+            body.textContent = typeof value === 'string' ? value : JSON.stringify(value, null, 2);
+            section.appendChild(heading);
+            section.appendChild(body);
+            document.getElementById('result').appendChild(section);
+        }
+
+        async function finish(query) {
+            const verifier = sessionStorage.getItem('playground_verifier');
+            const state = sessionStorage.getItem('playground_state');
+            sessionStorage.removeItem('playground_verifier');
+            sessionStorage.removeItem('playground_state');
+            history.replaceState(null, '', redirectUri);
+
+            if (query.get('error')) {
+                show('Authorization failed: ' + query.get('error'), query.get('error_description') || '', true);
+                return;
+            }
+            if (!verifier || query.get('state') !== state) {
+                show('State mismatch', 'The response does not belong to a sign-in started on this page. Start again.', true);
+                return;
+            }
+
+            const response = await fetch(endpoints.token, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+                body: new URLSearchParams({
+                    grant_type: 'authorization_code',
+                    code: query.get('code'),
+                    redirect_uri: redirectUri,
+                    client_id: clientId,
+                    code_verifier: verifier
+                })
+            });
+            const tokens = await response.json();
+            if (!response.ok) {
+                show('Token exchange failed', tokens, true);
+                return;
+            }
+
+            show('Token response', tokens);
+            for (const name of ['access_token', 'id_token']) {
+                const decoded = decodeJWT(tokens[name]);
+                if (decoded) show('Decoded ' + name, decoded);
+            }
+
+            const userinfo = await fetch(endpoints.userinfo, { headers: { Authorization: 'Bearer ' + tokens.access_token } });
+            show('UserInfo (' + userinfo.status + ')', await userinfo.text(), !userinfo.ok);
+        }
+
+        const query = new URLSearchParams(window.location.search);
+        if (query.get('code') || query.get('error')) {
+            finish(query).catch(e => show('Request failed', e.message, true));
+        }
+    </script>
+</body>
+</html>`,
+		html.EscapeString(tenant.Name),
+		color, color,
+		html.EscapeString(tenant.Name),
+		models.SystemClientPlayground,
+		jsString(models.SystemClientPlayground), jsString(base), jsString(pageURL), jsString(playgroundScope))
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestPlaygroundPageEscapesTenant(t *testing.T) {
+	tenant := &models.Tenant{Name: `<script>alert(1)</script>`}
+	tenant.Settings.CustomBranding.PrimaryColor = `red; } body { display: none`
+	page := playgroundPage(tenant, "https://auth.example.com/tenant/t1", "https://auth.example.com/tenant/t1/playground")
+
+	if strings.Contains(page, "<script>alert(1)") {
+		t.Error("Expected the tenant name to be escaped")
+	}
+	if strings.Contains(page, "display: none") {
+		t.Error("Expected a non-hex brand color to be ignored")
+	}
+	if !strings.Contains(page, `const redirectUri = "https://auth.example.com/tenant/t1/playground";`) {
+		t.Error("Expected the page to use its own URL as redirect URI")
+	}
+	if strings.Contains(page, "%!") {
+		t.Error("Expected every format verb of the page to be filled in")
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// QuotaExceededResponse is returned with 403 Forbidden when a request would exceed a tenant quota
+type QuotaExceededResponse struct {
+	Error    string `json:"error"`
+	Message  string `json:"message"`
+	Resource string `json:"resource"`
+	Limit    int64  `json:"limit"`
+	Used     int64  `json:"used"`
+}
+
+// writeQuotaExceeded writes a 403 response if err is a quota violation and reports whether it did
+func writeQuotaExceeded(w http.ResponseWriter, err error) bool {
+	exceeded, ok := services.IsQuotaExceeded(err)
+	if !ok {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(QuotaExceededResponse{
+		Error:    "quota_exceeded",
+		Message:  exceeded.Error(),
+		Resource: exceeded.Resource,
+		Limit:    exceeded.Limit,
+		Used:     exceeded.Used,
+	})
+	return true
+}
+
+// writeOAuthQuotaExceeded reports a token quota violation on the token endpoint as an RFC 6749
+// error and reports whether err was one
+func writeOAuthQuotaExceeded(w http.ResponseWriter, err error) bool {
+	exceeded, ok := services.IsQuotaExceeded(err)
+	if !ok {
+		return false
+	}
+
+	writeOAuthError(w, http.StatusForbidden, "access_denied", exceeded.Error())
+	return true
+}
+
+// writeClientRateLimited reports a throttled client on the token endpoint as 429 Too Many
+// Requests, with a Retry-After header when known
+func writeClientRateLimited(w http.ResponseWriter, err error) bool {
+	limited, ok := services.IsClientRateLimited(err)
+	if !ok {
+		return false
+	}
+
+	if limited.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+	}
+	writeOAuthError(w, http.StatusTooManyRequests, "temporarily_unavailable", limited.Error())
+	return true
+}
+
+type QuotaHandler struct {
+	quotaService *services.QuotaService
+}
+
+func NewQuotaHandler(quotaService *services.QuotaService) *QuotaHandler {
+	return &QuotaHandler{
+		quotaService: quotaService,
+	}
+}
+
+// GetUsage returns the current tenant's usage against its quotas for ?period=YYYY-MM
+// (default: current month)
+func (h *QuotaHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	h.writeTenantUsage(w, r, tenantID)
+}
+
+// GetTenantUsage returns a tenant's usage against its quotas for ?period=YYYY-MM
+func (h *QuotaHandler) GetTenantUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.writeTenantUsage(w, r, mux.Vars(r)["id"])
+}
+
+func (h *QuotaHandler) writeTenantUsage(w http.ResponseWriter, r *http.Request, tenantID string) {
+	usage, err := h.quotaService.GetTenantUsage(tenantID, r.URL.Query().Get("period"))
+	if err == services.ErrInvalidUsagePeriod {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// GetAllTenantUsage returns the usage of all tenants for ?period=YYYY-MM, for billing exports
+func (h *QuotaHandler) GetAllTenantUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	usage, err := h.quotaService.GetAllTenantUsage(r.URL.Query().Get("period"))
+	if err == services.ErrInvalidUsagePeriod {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get tenant usage: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenants": usage,
+	})
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+)
+
+const reportDateLayout = "2006-01-02"
+
+type ReportHandler struct {
+	reportService *services.ReportService
+	auditService  *services.AuditService
+}
+
+func NewReportHandler(reportService *services.ReportService, auditService *services.AuditService) *ReportHandler {
+	return &ReportHandler{
+		reportService: reportService,
+		auditService:  auditService,
+	}
+}
+
+// ExportDashboard exports the tenant's activity report for ?from=&to= (inclusive dates, default
+// the last 7 days) as ?format=csv (default), pdf or json
+func (h *ReportHandler) ExportDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseReportPeriod(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.reportService.BuildReport(tenantID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to build report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("dashboard-%s-%s", from.Format(reportDateLayout), to.AddDate(0, 0, -1).Format(reportDateLayout))
+
+	switch r.URL.Query().Get("format") {
+	case "", "csv":
+		data, err := report.CSV()
+		if err != nil {
+			http.Error(w, "Failed to export report", http.StatusInternalServerError)
+			return
+		}
+		writeReportFile(w, "text/csv; charset=utf-8", filename+".csv", data)
+	case "pdf":
+		writeReportFile(w, "application/pdf", filename+".pdf", report.PDF())
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	default:
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+	}
+}
+
+// GetAuditSummary returns the number of audit events per type for ?from=&to= as ?format=json
+// (default), csv or pdf
+func (h *ReportHandler) GetAuditSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseReportPeriod(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	counts, err := h.auditService.CountByType(tenantID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to get audit summary: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	lastDay := to.AddDate(0, 0, -1).Format(reportDateLayout)
+	filename := fmt.Sprintf("audit-summary-%s-%s", from.Format(reportDateLayout), lastDay)
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"from":   from,
+			"to":     to,
+			"events": counts,
+		})
+	case "csv":
+		rows := [][]string{{"Audit event", "Count"}}
+		for _, count := range counts {
+			rows = append(rows, []string{count.Type, strconv.FormatInt(count.Count, 10)})
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".csv\"")
+		csv.NewWriter(w).WriteAll(rows)
+	case "pdf":
+		lines := []string{
+			"Audit summary",
+			fmt.Sprintf("Period: %s to %s", from.Format(reportDateLayout), lastDay),
+			"",
+		}
+		for _, count := range counts {
+			lines = append(lines, fmt.Sprintf("  %-24s %d", count.Type, count.Count))
+		}
+		writeReportFile(w, "application/pdf", filename+".pdf", services.RenderTextPDF(lines))
+	default:
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+	}
+}
+
+func writeReportFile(w http.ResponseWriter, contentType, filename string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.Write(data)
+}
+
+// parseReportPeriod reads the inclusive ?from= and ?to= dates (YYYY-MM-DD, UTC) and returns the
+// period as [from, to) with to at midnight after the last day. It defaults to the last 7 days.
+func parseReportPeriod(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	to := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	if toParam := r.URL.Query().Get("to"); toParam != "" {
+		parsed, err := time.Parse(reportDateLayout, toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid to date, expected YYYY-MM-DD")
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+
+	from := to.AddDate(0, 0, -7)
+	if fromParam := r.URL.Query().Get("from"); fromParam != "" {
+		parsed, err := time.Parse(reportDateLayout, fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid from date, expected YYYY-MM-DD")
+		}
+		from = parsed
+	}
+
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, errors.New("The from date must not be after the to date")
+	}
+	if to.Sub(from) > services.MaxReportPeriod {
+		return time.Time{}, time.Time{}, errors.New("Report period is too long")
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseReportPeriod(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		query    string
+		wantFrom string
+		wantTo   string
+		wantErr  bool
+	}{
+		{name: "default last 7 days", query: "", wantFrom: "2024-03-04", wantTo: "2024-03-11"},
+		{name: "explicit inclusive range", query: "?from=2024-02-01&to=2024-02-29", wantFrom: "2024-02-01", wantTo: "2024-03-01"},
+		{name: "only to", query: "?to=2024-01-31", wantFrom: "2024-01-25", wantTo: "2024-02-01"},
+		{name: "single day", query: "?from=2024-03-05&to=2024-03-05", wantFrom: "2024-03-05", wantTo: "2024-03-06"},
+		{name: "reversed", query: "?from=2024-03-05&to=2024-03-01", wantErr: true},
+		{name: "invalid date", query: "?from=03/01/2024", wantErr: true},
+		{name: "too long", query: "?from=2022-01-01&to=2024-01-01", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/dashboard/export"+tt.query, nil)
+			from, to, err := parseReportPeriod(r, now)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %s - %s", from, to)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if from.Format(reportDateLayout) != tt.wantFrom || to.Format(reportDateLayout) != tt.wantTo {
+				t.Errorf("Expected %s - %s, got %s - %s", tt.wantFrom, tt.wantTo, from.Format(reportDateLayout), to.Format(reportDateLayout))
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"oauth2-openid-server/middleware"
 	"oauth2-openid-server/models"
@@ -10,27 +11,29 @@ import (
 )
 
 type ScopeHandler struct {
-	scopeService *services.ScopeService
+	scopeService      *services.ScopeService
+	scopeUsageService *services.ScopeUsageService
 }
 
-func NewScopeHandler(scopeService *services.ScopeService) *ScopeHandler {
+func NewScopeHandler(scopeService *services.ScopeService, scopeUsageService *services.ScopeUsageService) *ScopeHandler {
 	return &ScopeHandler{
-		scopeService: scopeService,
+		scopeService:      scopeService,
+		scopeUsageService: scopeUsageService,
 	}
 }
 
 type CreateScopeRequest struct {
-	Name        string `json:"name"`
-	DisplayName string `json:"display_name"`
-	Description string `json:"description"`
-	Category    string `json:"category"`
+	Name        string `json:"name" validate:"required,max=100"`
+	DisplayName string `json:"display_name" validate:"max=100"`
+	Description string `json:"description" validate:"max=500"`
+	Category    string `json:"category" validate:"max=50"`
 	Active      bool   `json:"active"`
 }
 
 type UpdateScopeRequest struct {
-	DisplayName string `json:"display_name"`
-	Description string `json:"description"`
-	Category    string `json:"category"`
+	DisplayName string `json:"display_name" validate:"max=100"`
+	Description string `json:"description" validate:"max=500"`
+	Category    string `json:"category" validate:"max=50"`
 	Active      bool   `json:"active"`
 }
 
@@ -49,12 +52,36 @@ func (h *ScopeHandler) GetAllScopes(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(scopes)
 }
 
+// GetScopeUsage reports requested/granted scope counts per client over the last ?days= (default 30)
+// and lists the scopes each client is allowed but has not been granted in that window.
+func (h *ScopeHandler) GetScopeUsage(w http.ResponseWriter, r *http.Request) {
+	tenantID := middleware.GetTenantIDFromRequest(r)
+
+	days := services.DefaultScopeUsageDays
+	if daysParam := r.URL.Query().Get("days"); daysParam != "" {
+		parsed, err := strconv.Atoi(daysParam)
+		if err != nil || parsed <= 0 || parsed > services.MaxScopeUsageDays {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	report, err := h.scopeUsageService.GetUsageReport(tenantID, r.URL.Query().Get("client_id"), days)
+	if err != nil {
+		http.Error(w, "Failed to fetch scope usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
 func (h *ScopeHandler) CreateScope(w http.ResponseWriter, r *http.Request) {
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	var req CreateScopeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -90,8 +117,7 @@ func (h *ScopeHandler) UpdateScope(w http.ResponseWriter, r *http.Request) {
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	var req UpdateScopeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type SessionHandler struct {
+	sessionService *services.SessionService
+	auditService   *services.AuditService
+	ssoSessions    *services.SSOSessionService
+	cookies        *services.CookieService
+}
+
+// SessionHeartbeatResponse tells a browser app how long it can keep going before renewing its
+// token, and until when a silent renewal (prompt=none) can succeed
+type SessionHeartbeatResponse struct {
+	Active         bool              `json:"active"`
+	UserID         string            `json:"user_id"`
+	TokenExpiresAt *time.Time        `json:"token_expires_at,omitempty"`
+	TokenExpiresIn int               `json:"token_expires_in,omitempty"` // seconds
+	SSOSession     *SSOSessionStatus `json:"sso_session"`                // null: silent renewal fails with login_required
+}
+
+// SSOSessionStatus describes the browser's SSO session after the heartbeat extended it
+type SSOSessionStatus struct {
+	ExpiresAt    time.Time `json:"expires_at"` // without further activity
+	ExpiresIn    int       `json:"expires_in"` // seconds
+	MaxExpiresAt time.Time `json:"max_expires_at"`
+	IdleTimeout  int       `json:"idle_timeout"` // seconds each activity extends the session by
+}
+
+func NewSessionHandler(sessionService *services.SessionService, auditService *services.AuditService, ssoSessions *services.SSOSessionService, cookies *services.CookieService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+		auditService:   auditService,
+		ssoSessions:    ssoSessions,
+		cookies:        cookies,
+	}
+}
+
+// Heartbeat keeps the signed-in user's SSO session alive while a browser app is in use. Apps send
+// the access token and, with credentials included, the SSO session cookie; a cookie of another user
+// is ignored.
+func (h *SessionHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	response := SessionHeartbeatResponse{Active: true, UserID: claims.UserID}
+	if claims.ExpiresAt != nil {
+		expiresAt := claims.ExpiresAt.Time
+		response.TokenExpiresAt = &expiresAt
+		response.TokenExpiresIn = int(time.Until(expiresAt).Seconds())
+	}
+
+	if session, err := ssoSessionFromRequest(r, h.ssoSessions, h.cookies, claims.TenantID); err == nil && session.UserID == claims.UserID {
+		if err := h.ssoSessions.Touch(session); err != nil {
+			log.Printf("Warning: Failed to extend SSO session of user %s: %v", session.UserID, err)
+		}
+		response.SSOSession = &SSOSessionStatus{
+			ExpiresAt:    session.ExpiresAt,
+			ExpiresIn:    int(time.Until(session.ExpiresAt).Seconds()),
+			MaxExpiresAt: session.MaxExpiresAt,
+			IdleTimeout:  int(h.ssoSessions.IdleTimeout().Seconds()),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Logout signs the browser out of the authorization server: its SSO session of the signed-in user
+// ends and the cookie is cleared, so silent renewals fail with login_required
+func (h *SessionHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if session, err := ssoSessionFromRequest(r, h.ssoSessions, h.cookies, claims.TenantID); err == nil && session.UserID == claims.UserID {
+		if err := h.ssoSessions.End(session.UserID, session.TenantID, session.ID.Hex()); err != nil && err != services.ErrSSOSessionNotFound {
+			http.Error(w, "Failed to end session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if h.cookies != nil {
+		h.cookies.Clear(w, r, services.SSOSessionCookie)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMySessions lists the signed-in user's active grants and SSO sessions
+func (h *SessionHandler) GetMySessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.sessionService.ListUserSessions(claims.UserID, claims.TenantID)
+	if err != nil {
+		http.Error(w, "Failed to list sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+// RevokeMySession signs the user out of one of their grants or SSO sessions
+func (h *SessionHandler) RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.sessionService.RevokeUserSession(claims.UserID, claims.TenantID, mux.Vars(r)["sessionId"])
+	if err == services.ErrSessionNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMyLogins lists the signed-in user's recent login attempts (?limit=, at most 50)
+func (h *SessionHandler) GetMyLogins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	logins, err := h.auditService.ListUserLogins(claims.UserID, claims.TenantID, limit)
+	if err != nil {
+		http.Error(w, "Failed to list logins: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"logins": logins,
+	})
+}
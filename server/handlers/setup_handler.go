@@ -38,11 +38,10 @@ func (h *SetupHandler) ValidateSetupToken(w http.ResponseWriter, r *http.Request
 	}
 
 	var req struct {
-		Token string `json:"token"`
+		Token string `json:"token" validate:"required"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -68,38 +67,7 @@ func (h *SetupHandler) PerformSetup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var setupReq services.SetupRequest
-	if err := json.NewDecoder(r.Body).Decode(&setupReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate required fields
-	if setupReq.SetupToken == "" {
-		http.Error(w, "Setup token is required", http.StatusBadRequest)
-		return
-	}
-	if setupReq.TenantName == "" {
-		http.Error(w, "Tenant name is required", http.StatusBadRequest)
-		return
-	}
-	if setupReq.TenantDomain == "" {
-		http.Error(w, "Tenant domain is required", http.StatusBadRequest)
-		return
-	}
-	if setupReq.TenantSubdomain == "" {
-		http.Error(w, "Tenant subdomain is required", http.StatusBadRequest)
-		return
-	}
-	if setupReq.AdminEmail == "" {
-		http.Error(w, "Admin email is required", http.StatusBadRequest)
-		return
-	}
-	if setupReq.AdminPassword == "" {
-		http.Error(w, "Admin password is required", http.StatusBadRequest)
-		return
-	}
-	if len(setupReq.AdminPassword) < 8 {
-		http.Error(w, "Admin password must be at least 8 characters", http.StatusBadRequest)
+	if !decodeRequest(w, r, &setupReq) {
 		return
 	}
 
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestClientSigningKeyEndpoint fetches a client's HS256 ID token key and verifies an ID token
+// issued to the client with it
+func TestClientSigningKeyEndpoint(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	clientID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, Email: "jane@example.com", Scopes: []string{"openid"}, Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, db, "clients",
+		&models.Client{ID: clientID, ClientID: "backend", ClientSecret: "s3cr:t", Name: "Backend",
+			RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.Client{ID: primitive.NewObjectID(), ClientID: "spa", ClientType: models.ClientTypePublic, Name: "SPA",
+			RedirectURIs: []string{"https://spa.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	userService := services.NewUserService(db)
+	oauthService := services.NewOAuthService(db, "test-secret", services.DefaultLifetimes())
+	tenantService := services.NewTenantService(db)
+	handler := NewAuthHandler(userService, oauthService, services.NewSocialAuthService(userService, db),
+		services.NewTwoFactorService(db, services.DefaultLifetimes()),
+		services.NewCIBAService(db, userService, oauthService, services.NewLogNotifier()),
+		services.NewTranslationService(db, tenantService), services.NewAuditService(db), services.NewConsentService(db), services.NewBotProtectionService(db, "test-secret"), services.NewFeatureFlagService(db), services.NewSSOSessionService(db, 0, 0), services.NewCookieService("test-secret", 0))
+
+	fetch := func(form url.Values, authorization string) *httptest.ResponseRecorder {
+		req := tokenRequest(form, authorization)
+		req.URL.Path = "/oauth/signing-key"
+		rec := httptest.NewRecorder()
+		handler.ClientSigningKey(rec, req)
+		return rec
+	}
+
+	// Only the confidential client itself gets its key
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"no credentials": fetch(url.Values{}, ""),
+		"wrong secret":   fetch(url.Values{}, basicAuthorization("backend", "wrong")),
+		"public client":  fetch(url.Values{"client_id": {"spa"}}, ""),
+	} {
+		var body OAuthErrorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusUnauthorized || body.Error != "invalid_client" {
+			t.Errorf("%s: expected 401 invalid_client, got %d %+v", name, rec.Code, body)
+		}
+	}
+
+	var jwk JWK
+	rec := fetch(url.Values{}, basicAuthorization("backend", url.QueryEscape("s3cr:t")))
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("Expected an uncached 200, got %d %q: %s", rec.Code, rec.Header().Get("Cache-Control"), rec.Body.String())
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&jwk); err != nil {
+		t.Fatal(err)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(jwk.K)
+	if err != nil || len(key) == 0 || jwk.Kty != "oct" || jwk.Alg != "HS256" {
+		t.Fatalf("Expected an HS256 oct key, got %+v (%v)", jwk, err)
+	}
+	hash := sha256.Sum256(key)
+	if jwk.Kid != "hs-"+base64.RawURLEncoding.EncodeToString(hash[:8]) {
+		t.Errorf("kid %q isn't derived from the key", jwk.Kid)
+	}
+
+	// The key is created once and then kept, with client_secret_post too
+	var again JWK
+	json.NewDecoder(fetch(url.Values{"client_id": {"backend"}, "client_secret": {"s3cr:t"}}, "").Body).Decode(&again)
+	if again != jwk {
+		t.Errorf("Expected the same key on the second fetch, got %+v and %+v", jwk, again)
+	}
+
+	// ID tokens of the client verify with its key, not the server secret
+	code, err := oauthService.CreateAuthorizationCode("backend", userID.Hex(), "", "https://app.example.com/cb", []string{"openid"}, "", "", services.CodeBinding{}, "")
+	if err != nil {
+		t.Fatalf("Failed to create authorization code: %v", err)
+	}
+	tokenRec := httptest.NewRecorder()
+	handler.Token(tokenRec, tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://app.example.com/cb"}},
+		basicAuthorization("backend", url.QueryEscape("s3cr:t"))))
+	var tokens services.TokenResponse
+	if err := json.NewDecoder(tokenRec.Body).Decode(&tokens); err != nil || tokens.IDToken == "" {
+		t.Fatalf("Expected an ID token, got %d %v", tokenRec.Code, err)
+	}
+
+	verify := func(secret []byte) error {
+		_, err := jwt.Parse(tokens.IDToken, func(token *jwt.Token) (interface{}, error) {
+			if kid, _ := token.Header["kid"].(string); kid != jwk.Kid {
+				t.Errorf("ID token kid = %q, want %q", kid, jwk.Kid)
+			}
+			return secret, nil
+		}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience("backend"))
+		return err
+	}
+	if err := verify(key); err != nil {
+		t.Errorf("ID token doesn't verify with the client's key: %v", err)
+	}
+	if err := verify([]byte("test-secret")); err == nil {
+		t.Error("ID token verifies with the server secret")
+	}
+
+	// The key stays out of the client API responses
+	client, err := services.NewClientService(db).GetClientByClientID("backend", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.IDTokenSigningKey == "" {
+		t.Fatal("Expected the key to be stored with the client")
+	}
+	body, _ := json.Marshal(&ClientResponse{Client: client})
+	if strings.Contains(string(body), client.IDTokenSigningKey) {
+		t.Errorf("Client response contains the signing key: %s", body)
+	}
+}
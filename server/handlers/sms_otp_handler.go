@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+)
+
+// SMSOTPHandler verifies phone numbers and manages SMS codes as second factor
+type SMSOTPHandler struct {
+	smsOTPService *services.SMSOTPService
+}
+
+type VerifyPhoneRequest struct {
+	Code string `json:"code" validate:"required,max=16"`
+}
+
+type SMSTwoFactorRequest struct {
+	UserID string `json:"user_id" validate:"required"`
+}
+
+func NewSMSOTPHandler(smsOTPService *services.SMSOTPService) *SMSOTPHandler {
+	return &SMSOTPHandler{smsOTPService: smsOTPService}
+}
+
+// SendMyPhoneVerification texts a verification code to the signed-in user's phone number
+func (h *SMSOTPHandler) SendMyPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !writeSMSOTPError(w, h.smsOTPService.SendPhoneVerification(claims.UserID, claims.TenantID)) {
+		return
+	}
+
+	writeJSONMessage(w, http.StatusAccepted, "Verification code sent")
+}
+
+// VerifyMyPhone marks the signed-in user's phone number as verified
+func (h *SMSOTPHandler) VerifyMyPhone(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req VerifyPhoneRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !writeSMSOTPError(w, h.smsOTPService.VerifyPhone(claims.UserID, claims.TenantID, req.Code)) {
+		return
+	}
+
+	writeJSONMessage(w, http.StatusOK, "Phone number verified")
+}
+
+// EnableSMSTwoFactor accepts SMS codes as the user's second factor
+func (h *SMSOTPHandler) EnableSMSTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SMSTwoFactorRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !writeSMSOTPError(w, h.smsOTPService.EnableSMSTwoFactor(req.UserID)) {
+		return
+	}
+
+	writeJSONMessage(w, http.StatusOK, "SMS two-factor authentication enabled")
+}
+
+// DisableSMSTwoFactor stops accepting SMS codes as the user's second factor
+func (h *SMSOTPHandler) DisableSMSTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SMSTwoFactorRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !writeSMSOTPError(w, h.smsOTPService.DisableSMSTwoFactor(req.UserID)) {
+		return
+	}
+
+	writeJSONMessage(w, http.StatusOK, "SMS two-factor authentication disabled")
+}
+
+// SendSMSCode texts a sign-in code during the second login step. The code is then submitted
+// like a TOTP code.
+func (h *SMSOTPHandler) SendSMSCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SMSTwoFactorRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !writeSMSOTPError(w, h.smsOTPService.SendTwoFactorCode(req.UserID)) {
+		return
+	}
+
+	writeJSONMessage(w, http.StatusAccepted, "Sign-in code sent")
+}
+
+// writeSMSOTPError writes the response for a failed SMS operation. It returns true if err is nil.
+func writeSMSOTPError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrSMSCodeThrottled):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, services.ErrSMSTwoFactorOff):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrPhoneRequired), errors.Is(err, services.ErrPhoneNotVerified), errors.Is(err, services.ErrInvalidSMSCode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err.Error() == "user not found" || err.Error() == "invalid user ID":
+		http.Error(w, "User not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Failed to process SMS request: "+err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+func writeJSONMessage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": message})
+}
@@ -4,13 +4,16 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"oauth2-openid-server/config"
 	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
 	"oauth2-openid-server/services"
 
 	"github.com/gorilla/mux"
@@ -19,7 +22,12 @@ import (
 type SocialAuthHandler struct {
 	socialAuthService     *services.SocialAuthService
 	socialProviderService *services.SocialProviderService
+	stateService          *services.SocialLoginStateService
 	oauthService          *services.OAuthService
+	twoFactorService      *services.TwoFactorService
+	translationService    *services.TranslationService
+	auditService          *services.AuditService
+	cookies               *services.CookieService
 	config                *config.Config
 }
 
@@ -27,11 +35,16 @@ type SocialProvidersResponse struct {
 	Providers []string `json:"providers"`
 }
 
-func NewSocialAuthHandler(socialAuthService *services.SocialAuthService, socialProviderService *services.SocialProviderService, oauthService *services.OAuthService, cfg *config.Config) *SocialAuthHandler {
+func NewSocialAuthHandler(socialAuthService *services.SocialAuthService, socialProviderService *services.SocialProviderService, stateService *services.SocialLoginStateService, oauthService *services.OAuthService, twoFactorService *services.TwoFactorService, translationService *services.TranslationService, auditService *services.AuditService, cookies *services.CookieService, cfg *config.Config) *SocialAuthHandler {
 	return &SocialAuthHandler{
 		socialAuthService:     socialAuthService,
 		socialProviderService: socialProviderService,
+		stateService:          stateService,
 		oauthService:          oauthService,
+		twoFactorService:      twoFactorService,
+		translationService:    translationService,
+		auditService:          auditService,
+		cookies:               cookies,
 		config:                cfg,
 	}
 }
@@ -63,6 +76,7 @@ func (h *SocialAuthHandler) InitiateSocialLogin(w http.ResponseWriter, r *http.R
 	vars := mux.Vars(r)
 	provider := vars["provider"]
 	tenantID := middleware.GetTenantIDFromRequest(r)
+	cookieMaxAge := int(h.oauthService.Lifetimes(tenantID).StateCookie.Seconds())
 
 	// Get OAuth parameters from frontend (if using PKCE)
 	clientID := r.URL.Query().Get("client_id")
@@ -73,6 +87,7 @@ func (h *SocialAuthHandler) InitiateSocialLogin(w http.ResponseWriter, r *http.R
 	codeChallengeMethod := r.URL.Query().Get("code_challenge_method")
 
 	var state string
+	var params map[string]string
 	
 	// If frontend provides state and PKCE parameters, use PKCE flow
 	if frontendState != "" && codeChallenge != "" && clientID != "" && redirectURI != "" {
@@ -80,7 +95,7 @@ func (h *SocialAuthHandler) InitiateSocialLogin(w http.ResponseWriter, r *http.R
 		state = frontendState
 		
 		// Store OAuth parameters for callback processing
-		params := map[string]string{
+		params = map[string]string{
 			"original_state":        frontendState,
 			"client_id":             clientID,
 			"redirect_uri":          redirectURI,
@@ -90,13 +105,10 @@ func (h *SocialAuthHandler) InitiateSocialLogin(w http.ResponseWriter, r *http.R
 		}
 
 		paramsJSON, _ := json.Marshal(params)
-		http.SetCookie(w, &http.Cookie{
-			Name:     "oauth_params_" + provider,
-			Value:    base64.URLEncoding.EncodeToString(paramsJSON),
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   false, // Set to true in production with HTTPS
-			MaxAge:   600,   // 10 minutes
+		h.cookies.Set(w, r, &http.Cookie{
+			Name:   "oauth_params_" + provider,
+			Value:  base64.URLEncoding.EncodeToString(paramsJSON),
+			MaxAge: cookieMaxAge,
 		})
 
 		println("Social login with PKCE - storing OAuth params for", provider)
@@ -106,22 +118,24 @@ func (h *SocialAuthHandler) InitiateSocialLogin(w http.ResponseWriter, r *http.R
 		println("Direct social login - generated state for", provider)
 	}
 
-	// Store state in session/cookie for validation
-	// Detect if we're running behind HTTPS (either direct TLS or proxy)
-	isSecure := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
-	
-	http.SetCookie(w, &http.Cookie{
+	// Shared providers from the global catalog call back without tenant context
+	if tenantID != "" && h.socialAuthService.UsesGlobalProvider(provider, tenantID) {
+		state = services.SharedProviderState(tenantID, state)
+	}
+
+	// Record the state server-side or seal it into the state, depending on the flow state mode
+	state = h.saveState(state, provider, tenantID, params)
+
+	// Store state in a cookie for validation; Lax lets the provider's redirect back carry it
+	h.cookies.Set(w, r, &http.Cookie{
 		Name:     "oauth_state_" + provider,
 		Value:    state,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   isSecure,
-		SameSite: http.SameSiteLaxMode, // Allow cross-site requests for OAuth callbacks
-		MaxAge:   600,                  // 10 minutes
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   cookieMaxAge,
 	})
 
 	// Get authorization URL from social provider
-	authURL, err := h.socialAuthService.GetAuthURL(provider, state, tenantID)
+	authURL, err := h.socialAuthService.GetAuthURL(provider, state, tenantID, loginHint(r))
 	if err != nil {
 		http.Error(w, "Provider not configured: "+err.Error(), http.StatusBadRequest)
 		return
@@ -163,53 +177,63 @@ func (h *SocialAuthHandler) HandleSocialCallback(w http.ResponseWriter, r *http.
 	}
 
 	// Validate state parameter - skip validation for direct social login
+	var stored *models.SocialLoginState
+	cookieMatched := false
 	if state == "direct-social-login" {
 		// Skip state validation for direct social login
 		println("Direct social login callback detected, state:", state)
 	} else {
 		// Validate state parameter against cookie for normal OAuth flow
 		cookieName := "oauth_state_" + provider
-		cookie, err := r.Cookie(cookieName)
+		cookieState, err := h.cookies.Value(r, cookieName)
 		
 		// Debug logging for OAuth state validation
 		if err != nil {
-			log.Printf("OAuth state validation failed - cookie '%s' not found: %v", cookieName, err)
-			log.Printf("Available cookies: %v", r.Cookies())
+			log.Printf("OAuth state validation failed - cookie '%s': %v", cookieName, err)
 		} else {
-			log.Printf("OAuth state validation - cookie value: %s, received state: %s", cookie.Value, state)
+			log.Printf("OAuth state validation - cookie value: %s, received state: %s", cookieState, state)
 		}
+
+		// The server-side record is consumed even when the cookie matches so the state can't be
+		// replayed, and validates the state on its own when the browser dropped the cookie
+		stored = h.consumeState(state, provider)
+		cookieMatched = err == nil && cookieState == state
 		
-		if err != nil || cookie.Value != state {
+		if !cookieMatched && stored == nil {
 			if state == "" {
 				log.Printf("OAuth callback error: Missing state parameter")
 				http.Error(w, "Missing authorization code or state parameter", http.StatusBadRequest)
 				return
 			}
 			log.Printf("OAuth callback error: Invalid state parameter. Expected: %s, Got: %s", 
-				func() string { if cookie != nil { return cookie.Value } else { return "<cookie not found>" } }(), state)
+				func() string { if err == nil { return cookieState } else { return "<cookie not found>" } }(), state)
 			http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 			return
 		}
 	}
 
+	// The callback of a shared provider belongs to the tenant that started the login
+	if sharedTenantID, ok := services.TenantFromSharedState(state); ok && h.socialAuthService.UsesGlobalProvider(provider, sharedTenantID) {
+		tenantID = sharedTenantID
+	}
+
 	// Clear the state cookie (only if not direct social login)
 	if state != "direct-social-login" {
-		// Use same security settings for consistency
-		isSecure := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
-		
-		http.SetCookie(w, &http.Cookie{
-			Name:     "oauth_state_" + provider,
-			Value:    "",
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   isSecure,
-			SameSite: http.SameSiteLaxMode,
-			MaxAge:   -1, // Delete the cookie
-		})
+		h.cookies.Clear(w, r, "oauth_state_"+provider)
+	}
+
+	// Links started from the profile page attach the provider account instead of signing in
+	if stored != nil && stored.Params[linkUserParam] != "" {
+		h.completeLink(w, r, provider, code, stored, cookieMatched)
+		return
 	}
 
 	// Handle the callback and get user information
 	user, err := h.socialAuthService.HandleCallback(provider, code, state, tenantID)
+	if errors.Is(err, services.ErrSocialDomainNotAllowed) || errors.Is(err, services.ErrSocialProvisioningDisabled) {
+		http.Error(w, "Sign-in with "+provider+" is not allowed: "+err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to authenticate with "+provider+": "+err.Error(), http.StatusInternalServerError)
 		return
@@ -218,9 +242,9 @@ func (h *SocialAuthHandler) HandleSocialCallback(w http.ResponseWriter, r *http.
 	// Get OAuth parameters from cookie (stored during OAuth initiation)
 	var originalState, clientID, redirectURI, scope, codeChallenge, codeChallengeMethod string
 
-	if paramsCookie, err := r.Cookie("oauth_params_" + provider); err == nil {
+	if paramsCookie, err := h.cookies.Value(r, "oauth_params_"+provider); err == nil {
 		// Decode the OAuth parameters from cookie
-		paramsJSON, err := base64.URLEncoding.DecodeString(paramsCookie.Value)
+		paramsJSON, err := base64.URLEncoding.DecodeString(paramsCookie)
 		if err == nil {
 			var params map[string]string
 			if err := json.Unmarshal(paramsJSON, &params); err == nil {
@@ -234,21 +258,51 @@ func (h *SocialAuthHandler) HandleSocialCallback(w http.ResponseWriter, r *http.
 		}
 
 		// Clear the OAuth params cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:   "oauth_params_" + provider,
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
+		h.cookies.Clear(w, r, "oauth_params_"+provider)
+	} else if stored != nil && stored.Params != nil {
+		// The params cookie was dropped along with the state cookie
+		originalState = stored.Params["original_state"]
+		clientID = stored.Params["client_id"]
+		redirectURI = stored.Params["redirect_uri"]
+		scope = stored.Params["scope"]
+		codeChallenge = stored.Params["code_challenge"]
+		codeChallengeMethod = stored.Params["code_challenge_method"]
+	}
+
+	// A social login has no second factor, so it can't satisfy a multi-factor policy set by an
+	// administrator (a user's own 2FA enrollment doesn't apply to upstream identity providers)
+	mfa, err := h.twoFactorService.EvaluateMFAPolicy(user.ID.Hex(), clientID)
+	if err != nil {
+		http.Error(w, "Failed to evaluate multi-factor policy", http.StatusInternalServerError)
+		return
+	}
+	if mfa.Mandated() {
+		if clientID != "" && redirectURI != "" {
+			redirectAuthorizeError(w, r, redirectURI, originalState, "interaction_required", "Multi-factor authentication is required for this sign-in")
+			return
+		}
+		http.Error(w, "Sign-in with "+provider+" is not allowed: multi-factor authentication is required", http.StatusForbidden)
+		return
 	}
 
 	if clientID != "" && redirectURI != "" {
-		// Continue OAuth flow - create authorization code
-		scopes := []string{"read", "openid", "profile", "email"}
+		if !h.oauthService.HasApplicationAccess(clientID, user) {
+			t := h.translationService.LocalizerForRequest(r, tenantID)
+			writeErrorPage(w, t, h.oauthService.TenantBranding(tenantID), http.StatusForbidden, t.T("error.application_access_denied"))
+			return
+		}
+		h.oauthService.RecordClientEvent(clientID, services.ClientMetricLogins)
+
+		// Continue OAuth flow - create authorization code with the requested scopes the user has
+		requestedScopes := h.oauthService.DefaultScopes(tenantID, services.DefaultScopesDirectLogin)
 		if scope != "" {
-			// Parse scopes from query parameter
-			scopes = parseScopes(scope)
+			requestedScopes, err = h.oauthService.RequestedScopes(tenantID, clientID, scope)
+			if err != nil {
+				redirectAuthorizeError(w, r, redirectURI, originalState, "invalid_scope", err.Error())
+				return
+			}
 		}
+		scopes := services.GrantUserScopes(requestedScopes, user)
 
 		authCode, err := h.oauthService.CreateAuthorizationCode(
 			clientID,
@@ -258,6 +312,8 @@ func (h *SocialAuthHandler) HandleSocialCallback(w http.ResponseWriter, r *http.
 			scopes,
 			codeChallenge,
 			codeChallengeMethod,
+			services.RequestCodeBinding(r),
+			services.ACRSingleFactor,
 		)
 		if err != nil {
 			http.Error(w, "Failed to create authorization code", http.StatusInternalServerError)
@@ -284,10 +340,10 @@ func (h *SocialAuthHandler) HandleSocialCallback(w http.ResponseWriter, r *http.
 
 	// Direct social login without OAuth flow - create temporary auth code for frontend
 	// Generate a temporary authorization code that the frontend can exchange for tokens
-	// Use the default frontend client ID so the token exchange will work
-	tempClientID := "frontend-client"
-	tempRedirectURI := h.config.WebBaseURL + "/callback" // Frontend callback page
-	tempScopes := []string{"read", "openid", "profile", "email"}
+	// The code belongs to the tenant's direct social login system client
+	tempClientID := models.SystemClientDirectSocialLogin
+	tempRedirectURI := strings.TrimSuffix(h.config.WebBaseURL, "/") + "/callback" // Frontend callback page
+	tempScopes := h.oauthService.DefaultScopes(tenantID, services.DefaultScopesDirectLogin)
 
 	authCode, err := h.oauthService.CreateAuthorizationCode(
 		tempClientID,
@@ -297,6 +353,8 @@ func (h *SocialAuthHandler) HandleSocialCallback(w http.ResponseWriter, r *http.
 		tempScopes,
 		"", // no code challenge for direct login
 		"",
+		services.RequestCodeBinding(r),
+		services.ACRSingleFactor,
 	)
 	if err != nil {
 		http.Error(w, "Failed to create authorization code", http.StatusInternalServerError)
@@ -328,13 +386,28 @@ func (h *SocialAuthHandler) SocialOAuthAuthorize(w http.ResponseWriter, r *http.
 	codeChallenge := r.URL.Query().Get("code_challenge")
 	codeChallengeMethod := r.URL.Query().Get("code_challenge_method")
 
-	if clientID == "" || redirectURI == "" {
-		http.Error(w, "Missing required OAuth parameters", http.StatusBadRequest)
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	t := h.translationService.LocalizerForRequest(r, tenantID)
+	if err := h.oauthService.ValidateAuthorizeClient(clientID, redirectURI, tenantID); err != nil {
+		writeErrorPage(w, t, h.oauthService.TenantBranding(tenantID), http.StatusBadRequest, t.T("error.invalid_redirect_uri"))
 		return
 	}
+	if err := h.oauthService.ValidateCodeChallenge(clientID, tenantID, codeChallenge, codeChallengeMethod); err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "invalid_request", err.Error())
+		return
+	}
+	if _, err := h.oauthService.RequestedScopes(tenantID, clientID, scope); err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "invalid_scope", err.Error())
+		return
+	}
+	h.oauthService.RecordClientEvent(clientID, services.ClientMetricAuthorizeRequests)
 
 	// Generate state for social provider
+	cookieMaxAge := int(h.oauthService.Lifetimes(tenantID).StateCookie.Seconds())
 	socialState := h.generateState()
+	if tenantID != "" && h.socialAuthService.UsesGlobalProvider(provider, tenantID) {
+		socialState = services.SharedProviderState(tenantID, socialState)
+	}
 
 	// Store OAuth parameters and state in cookie for callback
 	params := map[string]string{
@@ -346,30 +419,26 @@ func (h *SocialAuthHandler) SocialOAuthAuthorize(w http.ResponseWriter, r *http.
 		"code_challenge_method": codeChallengeMethod,
 	}
 
+	socialState = h.saveState(socialState, provider, tenantID, params)
+
 	paramsJSON, _ := json.Marshal(params)
-	http.SetCookie(w, &http.Cookie{
-		Name:     "oauth_params_" + provider,
-		Value:    base64.URLEncoding.EncodeToString(paramsJSON),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		MaxAge:   600,   // 10 minutes
+	h.cookies.Set(w, r, &http.Cookie{
+		Name:   "oauth_params_" + provider,
+		Value:  base64.URLEncoding.EncodeToString(paramsJSON),
+		MaxAge: cookieMaxAge,
 	})
 
-	http.SetCookie(w, &http.Cookie{
+	h.cookies.Set(w, r, &http.Cookie{
 		Name:     "oauth_state_" + provider,
 		Value:    socialState,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		MaxAge:   600,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   cookieMaxAge,
 	})
 
 	// Get authorization URL from social provider
-	tenantID := middleware.GetTenantIDFromRequest(r)
-	authURL, err := h.socialAuthService.GetAuthURL(provider, socialState, tenantID)
+	authURL, err := h.socialAuthService.GetAuthURL(provider, socialState, tenantID, loginHint(r))
 	if err != nil {
-		http.Error(w, "Provider not configured: "+err.Error(), http.StatusBadRequest)
+		redirectAuthorizeError(w, r, redirectURI, state, "invalid_request", "Provider not configured: "+err.Error())
 		return
 	}
 
@@ -384,50 +453,66 @@ func (h *SocialAuthHandler) generateState() string {
 	return base64.URLEncoding.EncodeToString(bytes)
 }
 
-// Helper function to parse scope string into slice
-func parseScopes(scopeStr string) []string {
-	if scopeStr == "" {
-		return []string{}
+// saveState records a social login state next to the state cookie and returns the state to send
+// to the provider, which carries the sealed record in stateless mode. Failures are logged and
+// return the state unchanged: the callback then falls back to validating the cookie alone.
+func (h *SocialAuthHandler) saveState(state, provider, tenantID string, params map[string]string) string {
+	if h.stateService == nil {
+		return state
 	}
-
-	scopes := []string{}
-	for _, scope := range []string{"read", "write", "admin", "openid", "profile", "email"} {
-		if contains(scopeStr, scope) {
-			scopes = append(scopes, scope)
-		}
+	ttl := h.oauthService.Lifetimes(tenantID).StateCookie
+	issued, err := h.stateService.Issue(state, provider, tenantID, params, ttl)
+	if err != nil {
+		log.Printf("Warning: Failed to store social login state for %s: %v", provider, err)
+		return state
 	}
-
-	return scopes
+	return issued
 }
 
-// Helper function to check if string contains substring
-func contains(str, substr string) bool {
-	return len(str) >= len(substr) && (str == substr ||
-		(len(str) > len(substr) && (str[:len(substr)+1] == substr+" " ||
-			str[len(str)-len(substr)-1:] == " "+substr ||
-			len(str) > len(substr)*2 && str[len(str)-len(substr):] == substr)))
+// consumeState returns the server-side record of a callback state, or nil when there is none or
+// the store is unavailable
+func (h *SocialAuthHandler) consumeState(state, provider string) *models.SocialLoginState {
+	if h.stateService == nil || state == "" {
+		return nil
+	}
+	stored, err := h.stateService.Consume(state, provider)
+	if err != nil {
+		if !errors.Is(err, services.ErrSocialStateNotFound) {
+			log.Printf("Warning: Failed to look up social login state for %s: %v", provider, err)
+		}
+		return nil
+	}
+	return stored
 }
 
 // Provider configuration management structures
 type ProviderConfig struct {
-	ID           string   `json:"id"`
-	Name         string   `json:"name"`
-	Enabled      bool     `json:"enabled"`
-	ClientID     string   `json:"clientId"`
-	ClientSecret string   `json:"clientSecret,omitempty"`
-	RedirectURL  string   `json:"redirectUrl"`
-	Scopes       []string `json:"scopes"`
-	AuthURL      string   `json:"authUrl"`
-	TokenURL     string   `json:"tokenUrl"`
-	UserInfoURL  string   `json:"userInfoUrl"`
-	Configured   bool     `json:"configured"`
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Enabled         bool     `json:"enabled"`
+	ClientID        string   `json:"clientId"`
+	ClientSecret    string   `json:"clientSecret,omitempty"`
+	RedirectURL     string   `json:"redirectUrl"`
+	Scopes          []string `json:"scopes"`
+	AuthURL         string   `json:"authUrl"`
+	TokenURL        string   `json:"tokenUrl"`
+	UserInfoURL     string   `json:"userInfoUrl"`
+	Configured      bool     `json:"configured"`
+	UseGlobal       bool     `json:"useGlobal"`       // signs in with the global catalog's shared credentials
+	GlobalAvailable bool     `json:"globalAvailable"` // the global catalog offers this provider
+	DirectoryTenant string   `json:"directoryTenant,omitempty"`
+	SyncGroups      bool     `json:"syncGroups"`
 }
 
 type UpdateProviderRequest struct {
 	Enabled      bool   `json:"enabled"`
-	ClientID     string `json:"clientId"`
-	ClientSecret string `json:"clientSecret"`
-	RedirectURL  string `json:"redirectUrl"`
+	ClientID     string `json:"clientId" validate:"max=500"`
+	ClientSecret string `json:"clientSecret" validate:"max=500"`
+	RedirectURL  string `json:"redirectUrl" validate:"url"`
+	UseGlobal    bool   `json:"useGlobal"`
+	// Microsoft only: directory restricting sign-in and group import from Microsoft Graph
+	DirectoryTenant string `json:"directoryTenant" validate:"max=100"`
+	SyncGroups      bool   `json:"syncGroups"`
 }
 
 // GetProviderConfigs returns the configuration of all social providers
@@ -446,6 +531,12 @@ func (h *SocialAuthHandler) GetProviderConfigs(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	globalProviders, err := h.socialProviderService.GetGlobalProviders()
+	if err != nil {
+		http.Error(w, "Failed to get providers", http.StatusInternalServerError)
+		return
+	}
+
 	configs := []ProviderConfig{}
 	for _, provider := range providers {
 		config := ProviderConfig{
@@ -459,6 +550,19 @@ func (h *SocialAuthHandler) GetProviderConfigs(w http.ResponseWriter, r *http.Re
 			TokenURL:    provider.TokenURL,
 			UserInfoURL: provider.UserInfoURL,
 			Configured:  provider.ClientID != "" && provider.ClientSecret != "",
+			UseGlobal:   provider.UseGlobal,
+			DirectoryTenant: provider.DirectoryTenant,
+			SyncGroups:      provider.SyncGroups,
+		}
+		for _, global := range globalProviders {
+			if global.Name == provider.Name && global.Enabled && global.ClientID != "" && global.ClientSecret != "" {
+				config.GlobalAvailable = true
+			}
+		}
+		if provider.UseGlobal {
+			// Shared credentials are never shown to tenants
+			config.ClientID = ""
+			config.Configured = config.GlobalAvailable
 		}
 		configs = append(configs, config)
 	}
@@ -479,8 +583,7 @@ func (h *SocialAuthHandler) UpdateProviderConfig(w http.ResponseWriter, r *http.
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
 	var req UpdateProviderRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -491,8 +594,20 @@ func (h *SocialAuthHandler) UpdateProviderConfig(w http.ResponseWriter, r *http.
 		return
 	}
 
+	if req.UseGlobal {
+		if _, err := h.socialProviderService.GetGlobalProvider(provider); err != nil {
+			http.Error(w, "Provider is not available in the global catalog", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Update the provider with new values
 	existingProvider.Enabled = req.Enabled
+	existingProvider.UseGlobal = req.UseGlobal
+	if existingProvider.Name == "microsoft" {
+		existingProvider.DirectoryTenant = req.DirectoryTenant
+		existingProvider.SyncGroups = req.SyncGroups
+	}
 	existingProvider.ClientID = req.ClientID
 	if req.ClientSecret != "" {
 		existingProvider.ClientSecret = req.ClientSecret
@@ -507,6 +622,7 @@ func (h *SocialAuthHandler) UpdateProviderConfig(w http.ResponseWriter, r *http.
 		http.Error(w, "Failed to update provider configuration: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordActivity(h.auditService, r, &models.AuditEvent{TenantID: tenantID, Type: models.AuditEventProviderUpdated, Target: provider})
 
 	response := map[string]interface{}{
 		"success":  true,
@@ -518,6 +634,20 @@ func (h *SocialAuthHandler) UpdateProviderConfig(w http.ResponseWriter, r *http.
 	json.NewEncoder(w).Encode(response)
 }
 
+// GetProviderHealth reports the circuit state and call statistics of the social providers
+// this instance has contacted since it started
+func (h *SocialAuthHandler) GetProviderHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"providers": services.SocialProviderHealth(),
+	})
+}
+
 // TestProviderConfig tests the configuration for a specific provider
 func (h *SocialAuthHandler) TestProviderConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -529,17 +659,23 @@ func (h *SocialAuthHandler) TestProviderConfig(w http.ResponseWriter, r *http.Re
 	provider := vars["provider"]
 	tenantID := middleware.GetTenantIDFromRequest(r)
 
-	// Basic validation
-	isConfigured := h.socialAuthService.IsProviderConfigured(provider, tenantID)
+	// ?credentials=true also sends the client credentials to the provider's token endpoint
+	checkCredentials := r.URL.Query().Get("credentials") == "true"
+	diagnostics, err := h.socialAuthService.TestProvider(provider, tenantID, checkCredentials)
+	if err != nil {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
 
 	response := map[string]interface{}{
-		"success":    isConfigured,
-		"configured": isConfigured,
+		"success":    diagnostics.Success,
+		"configured": h.socialAuthService.IsProviderConfigured(provider, tenantID),
 		"provider":   provider,
+		"checks":     diagnostics.Checks,
 	}
 
-	if !isConfigured {
-		response["message"] = "Provider is not properly configured"
+	if !diagnostics.Success {
+		response["message"] = "Provider configuration has problems"
 	} else {
 		response["message"] = "Provider configuration is valid"
 	}
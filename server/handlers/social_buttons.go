@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"oauth2-openid-server/i18n"
+	"oauth2-openid-server/models"
+
+	"github.com/gorilla/mux"
+)
+
+// socialButtonClasses styles the buttons of well-known providers. Other providers, such as
+// custom OIDC providers, are shown in the tenant's brand color.
+var socialButtonClasses = map[string]string{
+	"google":   "google-btn",
+	"github":   "github-btn",
+	"facebook": "facebook-btn",
+	"apple":    "apple-btn",
+}
+
+// socialAuthBasePath returns the social login routes matching the request, so that pages served
+// under /tenant/{tenantId} keep the user in that tenant
+func socialAuthBasePath(r *http.Request) string {
+	if tenantID := mux.Vars(r)["tenantId"]; tenantID != "" {
+		return "/tenant/" + url.PathEscape(tenantID) + "/auth"
+	}
+	return "/auth"
+}
+
+// maxLoginHintLength bounds the login_hint accepted on the authorize page; longer hints are ignored
+const maxLoginHintLength = 256
+
+// socialLoginURL returns the link that starts a social login with the given authorization request
+// parameters
+func socialLoginURL(basePath, provider string, params url.Values) string {
+	return basePath + "/" + url.PathEscape(provider) + "/oauth?" + params.Encode()
+}
+
+// loginHint returns the login_hint of an authorization request, typically the user's email
+// address, or "" when it is missing or implausibly long
+func loginHint(r *http.Request) string {
+	hint := strings.TrimSpace(r.URL.Query().Get("login_hint"))
+	if len(hint) > maxLoginHintLength || strings.ContainsAny(hint, "\r\n") {
+		return ""
+	}
+	return hint
+}
+
+// idpHintProvider returns the enabled provider named by the idp_hint (or Keycloak's kc_idp_hint)
+// of an authorization request, so the login form can be skipped. Unknown providers are ignored.
+func idpHintProvider(r *http.Request, providers []models.SocialProvider) (string, bool) {
+	hint := r.URL.Query().Get("idp_hint")
+	if hint == "" {
+		hint = r.URL.Query().Get("kc_idp_hint")
+	}
+	if hint == "" {
+		return "", false
+	}
+	for _, provider := range providers {
+		if strings.EqualFold(provider.Name, hint) {
+			return provider.Name, true
+		}
+	}
+	return "", false
+}
+
+// socialLoginButtons renders a "continue with" link per provider that starts the social login
+// with the given authorization request parameters
+func socialLoginButtons(t *i18n.Localizer, providers []models.SocialProvider, basePath string, params url.Values, branding models.TenantBranding) string {
+	buttons := ""
+	for _, provider := range providers {
+		href := socialLoginURL(basePath, provider.Name, params)
+
+		name := provider.DisplayName
+		if name == "" {
+			name = provider.Name
+		}
+
+		class, style := "social-btn", ""
+		if known, ok := socialButtonClasses[provider.Name]; ok {
+			class = known
+		} else if brandColorPattern.MatchString(branding.PrimaryColor) {
+			style = fmt.Sprintf(` style="background: %s; color: white; border-color: %s;"`, branding.PrimaryColor, branding.PrimaryColor)
+		}
+
+		buttons += fmt.Sprintf(`
+			<a href="%s" class="social-button %s"%s>%s</a>
+		`, html.EscapeString(href), class, style, html.EscapeString(t.T("page.authorize.continue_with", name)))
+	}
+	return buttons
+}
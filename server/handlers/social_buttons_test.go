@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/i18n"
+	"oauth2-openid-server/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSocialLoginButtons(t *testing.T) {
+	providers := []models.SocialProvider{
+		{Name: "google", DisplayName: "Google"},
+		{Name: "acme-sso", DisplayName: `Acme <SSO>`},
+	}
+	params := url.Values{"client_id": {"app"}, "state": {`"><script>`}}
+	branding := models.TenantBranding{PrimaryColor: "#123456"}
+
+	buttons := socialLoginButtons(i18n.NewLocalizer("en", nil), providers, "/tenant/t1/auth", params, branding)
+
+	if !strings.Contains(buttons, `href="/tenant/t1/auth/google/oauth?client_id=app&amp;state=%22%3E%3Cscript%3E"`) {
+		t.Errorf("Expected an escaped tenant-specific link, got %s", buttons)
+	}
+	if !strings.Contains(buttons, `class="social-button google-btn"`) {
+		t.Error("Expected the Google button style")
+	}
+	if !strings.Contains(buttons, `style="background: #123456;`) {
+		t.Error("Expected a custom provider in the tenant's brand color")
+	}
+	if strings.Contains(buttons, "<SSO>") || !strings.Contains(buttons, "Acme &lt;SSO&gt;") {
+		t.Error("Expected the display name to be escaped")
+	}
+}
+
+func TestSocialAuthBasePath(t *testing.T) {
+	r := httptest.NewRequest("GET", "/oauth/authorize", nil)
+	if got := socialAuthBasePath(r); got != "/auth" {
+		t.Errorf("socialAuthBasePath() = %q, want /auth", got)
+	}
+
+	r = mux.SetURLVars(httptest.NewRequest("GET", "/tenant/t1/oauth/authorize", nil), map[string]string{"tenantId": "t1"})
+	if got := socialAuthBasePath(r); got != "/tenant/t1/auth" {
+		t.Errorf("socialAuthBasePath() = %q, want /tenant/t1/auth", got)
+	}
+}
+
+func TestLoginAndIdPHints(t *testing.T) {
+	r := httptest.NewRequest("GET", "/oauth/authorize?login_hint=+jane%40acme.com+&kc_idp_hint=Acme-SSO", nil)
+	if got := loginHint(r); got != "jane@acme.com" {
+		t.Errorf("loginHint() = %q, want jane@acme.com", got)
+	}
+	r = httptest.NewRequest("GET", "/oauth/authorize?login_hint="+strings.Repeat("a", maxLoginHintLength+1), nil)
+	if got := loginHint(r); got != "" {
+		t.Errorf("loginHint() of an overlong hint = %q", got)
+	}
+
+	providers := []models.SocialProvider{{Name: "google"}, {Name: "acme-sso"}}
+	r = httptest.NewRequest("GET", "/oauth/authorize?kc_idp_hint=Acme-SSO", nil)
+	if provider, ok := idpHintProvider(r, providers); !ok || provider != "acme-sso" {
+		t.Errorf("idpHintProvider(kc_idp_hint) = %q, %v", provider, ok)
+	}
+	r = httptest.NewRequest("GET", "/oauth/authorize?idp_hint=google&kc_idp_hint=acme-sso", nil)
+	if provider, _ := idpHintProvider(r, providers); provider != "google" {
+		t.Errorf("idpHintProvider() = %q, want idp_hint to win", provider)
+	}
+	r = httptest.NewRequest("GET", "/oauth/authorize?idp_hint=github", nil)
+	if _, ok := idpHintProvider(r, providers); ok {
+		t.Error("Expected a provider the tenant hasn't enabled to be ignored")
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// SocialCatalogHandler manages the global social provider catalog. Tenants opt into catalog
+// providers to sign in with shared credentials instead of registering their own OAuth apps.
+type SocialCatalogHandler struct {
+	socialProviderService *services.SocialProviderService
+}
+
+func NewSocialCatalogHandler(socialProviderService *services.SocialProviderService) *SocialCatalogHandler {
+	return &SocialCatalogHandler{
+		socialProviderService: socialProviderService,
+	}
+}
+
+// GetCatalog lists the providers of the global catalog
+func (h *SocialCatalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.requirePlatform(w, r) {
+		return
+	}
+
+	providers, err := h.socialProviderService.GetGlobalProviders()
+	if err != nil {
+		http.Error(w, "Failed to get providers", http.StatusInternalServerError)
+		return
+	}
+
+	configs := []ProviderConfig{}
+	for _, provider := range providers {
+		configs = append(configs, ProviderConfig{
+			ID:              provider.Name,
+			Name:            provider.DisplayName,
+			Enabled:         provider.Enabled,
+			ClientID:        provider.ClientID,
+			RedirectURL:     provider.RedirectURL,
+			Scopes:          provider.Scopes,
+			AuthURL:         provider.AuthURL,
+			TokenURL:        provider.TokenURL,
+			UserInfoURL:     provider.UserInfoURL,
+			Configured:      provider.ClientID != "" && provider.ClientSecret != "",
+			GlobalAvailable: provider.Enabled,
+			DirectoryTenant: provider.DirectoryTenant,
+			SyncGroups:      provider.SyncGroups,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configs)
+}
+
+// UpdateCatalogProvider updates the shared credentials of a catalog provider. Its redirect URL
+// must point at the platform's callback (/auth/{provider}/callback), which serves all tenants.
+func (h *SocialCatalogHandler) UpdateCatalogProvider(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.requirePlatform(w, r) {
+		return
+	}
+
+	var req UpdateProviderRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	provider := mux.Vars(r)["provider"]
+	existingProvider, err := h.socialProviderService.GetGlobalProvider(provider)
+	if err != nil {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+
+	existingProvider.Enabled = req.Enabled
+	existingProvider.ClientID = req.ClientID
+	if existingProvider.Name == "microsoft" {
+		existingProvider.DirectoryTenant = req.DirectoryTenant
+		existingProvider.SyncGroups = req.SyncGroups
+	}
+	if req.ClientSecret != "" {
+		existingProvider.ClientSecret = req.ClientSecret
+	}
+	if req.RedirectURL != "" {
+		existingProvider.RedirectURL = req.RedirectURL
+	}
+
+	if err := h.socialProviderService.UpdateGlobalProvider(existingProvider); err != nil {
+		http.Error(w, "Failed to update provider configuration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"message":  "Provider configuration updated successfully",
+		"provider": provider,
+	})
+}
+
+// requirePlatform only lets the platform operator manage the catalog
+func (h *SocialCatalogHandler) requirePlatform(w http.ResponseWriter, r *http.Request) bool {
+	if !middleware.IsPlatformOperator(r) {
+		http.Error(w, "The global provider catalog is managed by the platform operator", http.StatusForbidden)
+		return false
+	}
+	return true
+}
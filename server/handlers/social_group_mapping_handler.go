@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+
+	"github.com/gorilla/mux"
+)
+
+// GroupMappingsRequest replaces the upstream group mapping table of a provider
+type GroupMappingsRequest struct {
+	GroupsClaim         string                `json:"groupsClaim" validate:"max=100"`
+	Mappings            []models.GroupMapping `json:"mappings" validate:"max=200"`
+	CreateMissingGroups bool                  `json:"createMissingGroups"`
+}
+
+// GroupMappingsResponse describes how a provider's upstream groups map to local groups
+type GroupMappingsResponse struct {
+	Provider            string                `json:"provider"`
+	GroupsClaim         string                `json:"groupsClaim"`
+	Mappings            []models.GroupMapping `json:"mappings"`
+	CreateMissingGroups bool                  `json:"createMissingGroups"`
+}
+
+// GetGroupMappings returns the upstream group mapping table of a provider
+func (h *SocialAuthHandler) GetGroupMappings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider := mux.Vars(r)["provider"]
+	tenantID := middleware.GetTenantIDFromRequest(r)
+
+	existing, err := h.socialProviderService.GetProviderByName(provider, tenantID)
+	if err != nil {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+
+	writeGroupMappings(w, existing.Name, existing.GroupsClaim, existing.GroupMappings, existing.CreateMissingGroups)
+}
+
+// UpdateGroupMappings replaces the upstream group mapping table of a provider. Mappings take
+// effect on the users' next login.
+func (h *SocialAuthHandler) UpdateGroupMappings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider := mux.Vars(r)["provider"]
+	tenantID := middleware.GetTenantIDFromRequest(r)
+
+	var req GroupMappingsRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	for _, mapping := range req.Mappings {
+		if mapping.External == "" || mapping.Group == "" {
+			http.Error(w, "Each mapping requires an external group and a local group", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.socialProviderService.UpdateGroupMappings(provider, tenantID, req.GroupsClaim, req.Mappings, req.CreateMissingGroups); err != nil {
+		if err.Error() == "provider not found" {
+			http.Error(w, "Provider not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update group mappings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeGroupMappings(w, provider, req.GroupsClaim, req.Mappings, req.CreateMissingGroups)
+}
+
+func writeGroupMappings(w http.ResponseWriter, provider, groupsClaim string, mappings []models.GroupMapping, createMissing bool) {
+	if mappings == nil {
+		mappings = []models.GroupMapping{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GroupMappingsResponse{
+		Provider:            provider,
+		GroupsClaim:         groupsClaim,
+		Mappings:            mappings,
+		CreateMissingGroups: createMissing,
+	})
+}
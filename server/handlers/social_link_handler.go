@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// linkUserParam marks a social login state that links the provider account to a signed-in user
+// instead of signing in
+const linkUserParam = "link_user_id"
+
+// LinkMySocialIdentity starts linking a social provider account to the signed-in user. It returns
+// the provider's authorization URL; the provider calls back to the regular social callback, which
+// links the account and redirects to the profile page.
+func (h *SocialAuthHandler) LinkMySocialIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The callback can only tell a link from a login through the server-side state record
+	if h.stateService == nil {
+		http.Error(w, "Social identity linking is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	provider := mux.Vars(r)["provider"]
+	if !h.socialAuthService.IsProviderEnabled(provider, claims.TenantID) {
+		http.Error(w, "Provider not enabled", http.StatusNotFound)
+		return
+	}
+
+	state := h.generateState()
+	if h.socialAuthService.UsesGlobalProvider(provider, claims.TenantID) {
+		state = services.SharedProviderState(claims.TenantID, state)
+	}
+	ttl := h.oauthService.Lifetimes(claims.TenantID).StateCookie
+	state, err := h.stateService.Issue(state, provider, claims.TenantID, map[string]string{linkUserParam: claims.UserID}, ttl)
+	if err != nil {
+		http.Error(w, "Failed to start linking", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := h.socialAuthService.GetAuthURL(provider, state, claims.TenantID, "")
+	if err != nil {
+		http.Error(w, "Provider not configured: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Binds the link to this browser, so a victim can't be made to complete someone else's link
+	h.cookies.Set(w, r, &http.Cookie{
+		Name:     "oauth_state_" + provider,
+		Value:    state,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ttl.Seconds()),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"authorization_url": authURL})
+}
+
+// completeLink finishes a link started by LinkMySocialIdentity and redirects to the profile page
+// with the outcome
+func (h *SocialAuthHandler) completeLink(w http.ResponseWriter, r *http.Request, provider, code string, stored *models.SocialLoginState, cookieMatched bool) {
+	userID := stored.Params[linkUserParam]
+	query := url.Values{}
+
+	if !cookieMatched {
+		query.Set("link_error", "invalid_state")
+	} else if identity, err := h.socialAuthService.LinkIdentity(provider, code, stored.TenantID, userID); err != nil {
+		log.Printf("Failed to link %s identity to user %s: %v", provider, userID, err)
+		if errors.Is(err, services.ErrSocialIdentityInUse) {
+			query.Set("link_error", "identity_in_use")
+		} else {
+			query.Set("link_error", "link_failed")
+		}
+	} else {
+		query.Set("linked", provider)
+		recordActivity(h.auditService, r, &models.AuditEvent{
+			TenantID: stored.TenantID,
+			Type:     models.AuditEventSocialIdentityLinked,
+			UserID:   userID,
+			Email:    identity.Email,
+			Target:   provider,
+		})
+	}
+
+	http.Redirect(w, r, strings.TrimSuffix(h.config.WebBaseURL, "/")+"/profile?"+query.Encode(), http.StatusFound)
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+)
+
+// startSSOSession signs the browser in at the authorization server after a successful login.
+// Failures are logged; the login itself succeeded.
+func startSSOSession(w http.ResponseWriter, r *http.Request, ssoSessions *services.SSOSessionService, cookies *services.CookieService, tenantID, userID, acr, directClientID string) {
+	if ssoSessions == nil {
+		return
+	}
+	session, token, err := ssoSessions.Create(tenantID, userID, acr, directClientID)
+	if err != nil {
+		log.Printf("Warning: Failed to start SSO session of user %s: %v", userID, err)
+		return
+	}
+
+	// Silent renewal runs in a hidden iframe of the client's site, which only sends SameSite=None
+	// cookies; over plain HTTP they fall back to Lax
+	cookies.Set(w, r, &http.Cookie{
+		Name:     services.SSOSessionCookie,
+		Value:    token,
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   int(time.Until(session.MaxExpiresAt).Seconds()),
+	})
+}
+
+func (h *AuthHandler) startSSOSession(w http.ResponseWriter, r *http.Request, tenantID, userID, acr, directClientID string) {
+	startSSOSession(w, r, h.ssoSessions, h.cookies, tenantID, userID, acr, directClientID)
+}
+
+// ssoSessionFromRequest returns the active SSO session of the request's cookie in the tenant
+func ssoSessionFromRequest(r *http.Request, ssoSessions *services.SSOSessionService, cookies *services.CookieService, tenantID string) (*models.SSOSession, error) {
+	if ssoSessions == nil {
+		return nil, services.ErrSSOSessionNotFound
+	}
+	token, err := cookies.Value(r, services.SSOSessionCookie)
+	if err != nil {
+		return nil, services.ErrSSOSessionNotFound
+	}
+	return ssoSessions.Find(token, tenantID)
+}
+
+// silentAuthorize answers an authorization request with prompt=none: the code is issued from the
+// browser's SSO session without showing any page, or the client gets login_required,
+// consent_required or interaction_required and has to send the user through the login page. The
+// client, redirect URI, code challenge and scopes were validated by the caller.
+func (h *AuthHandler) silentAuthorize(w http.ResponseWriter, r *http.Request, tenantID string) {
+	query := r.URL.Query()
+	clientID := query.Get("client_id")
+	redirectURI := query.Get("redirect_uri")
+	state := query.Get("state")
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	session, err := ssoSessionFromRequest(r, h.ssoSessions, h.cookies, tenantID)
+	if err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "login_required", "The user is not signed in")
+		return
+	}
+	// max_age asks for a sign-in no older than the given number of seconds
+	if maxAge, err := strconv.Atoi(query.Get("max_age")); err == nil && time.Since(session.CreatedAt) > time.Duration(maxAge)*time.Second {
+		redirectAuthorizeError(w, r, redirectURI, state, "login_required", "The sign-in is older than max_age")
+		return
+	}
+
+	user, err := h.userService.GetUserByIDAndTenant(session.UserID, tenantID)
+	if err != nil || !user.Active {
+		redirectAuthorizeError(w, r, redirectURI, state, "login_required", "The user is not signed in")
+		return
+	}
+	if !h.oauthService.HasApplicationAccess(clientID, user) {
+		redirectAuthorizeError(w, r, redirectURI, state, "access_denied", "The user has no access to this application")
+		return
+	}
+	mfa, err := h.twoFactorService.EvaluateMFAPolicy(session.UserID, clientID)
+	if err != nil || (mfa.Required && session.ACR != services.ACRMultiFactor) {
+		redirectAuthorizeError(w, r, redirectURI, state, "interaction_required", "Multi-factor authentication is required for this sign-in")
+		return
+	}
+
+	requestedScopes, err := h.oauthService.RequestedScopes(tenantID, clientID, query.Get("scope"))
+	if err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "invalid_scope", err.Error())
+		return
+	}
+	grantedScopes := userGrantedScopes(requestedScopes, user)
+	if session.DirectClientID == clientID {
+		// The same grant as the direct login that started the session
+		grantedScopes = h.oauthService.DefaultScopes(tenantID, services.DefaultScopesDirectLogin)
+		if len(user.Scopes) > 0 {
+			grantedScopes = user.Scopes
+		}
+	} else {
+		if !h.consentService.HasConsent(session.UserID, clientID, tenantID, grantedScopes) {
+			redirectAuthorizeError(w, r, redirectURI, state, "consent_required", "The user has not consented to the requested scopes")
+			return
+		}
+		grantedScopes = h.consentService.FilterGranted(session.UserID, clientID, tenantID, grantedScopes)
+	}
+	if len(grantedScopes) == 0 {
+		grantedScopes = []string{"read"}
+	}
+
+	code, err := h.oauthService.CreateAuthorizationCode(clientID, session.UserID, tenantID, redirectURI, grantedScopes,
+		query.Get("code_challenge"), query.Get("code_challenge_method"), services.RequestCodeBinding(r), session.ACR)
+	if err != nil {
+		redirectAuthorizeError(w, r, redirectURI, state, "server_error", "Failed to create authorization code")
+		return
+	}
+	if err := h.ssoSessions.Touch(session); err != nil {
+		log.Printf("Warning: Failed to extend SSO session of user %s: %v", session.UserID, err)
+	}
+
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "Invalid redirect URI", http.StatusBadRequest)
+		return
+	}
+	params := redirectURL.Query()
+	params.Set("code", code)
+	if state != "" {
+		params.Set("state", state)
+	}
+	redirectURL.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// userGrantedScopes keeps the requested scopes the user has
+func userGrantedScopes(requestedScopes []string, user *models.User) []string {
+	var granted []string
+	for _, scope := range requestedScopes {
+		if containsValue(user.Scopes, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
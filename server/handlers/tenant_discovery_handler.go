@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// TenantDiscoveryHandler serves tenant discovery for a single login box across tenants and lets
+// tenant admins verify the email domains it matches
+type TenantDiscoveryHandler struct {
+	discoveryService *services.TenantDiscoveryService
+	tenantService    *services.TenantService
+	auditService     *services.AuditService
+}
+
+type TenantDiscoveryRequest struct {
+	Email string `json:"email" validate:"required,max=254"`
+}
+
+// TenantDiscoveryResponse is what a login box needs to send the user to their tenant
+type TenantDiscoveryResponse struct {
+	TenantID string                `json:"tenant_id"`
+	Name     string                `json:"name"`
+	Issuer   string                `json:"issuer"`
+	Branding models.TenantBranding `json:"branding"`
+}
+
+type AddTenantDomainRequest struct {
+	Domain string `json:"domain" validate:"required,hostname"`
+}
+
+// TenantDomainResponse is a domain claim with the DNS TXT record that verifies it
+type TenantDomainResponse struct {
+	models.TenantDomain
+	RecordName  string `json:"record_name"`
+	RecordValue string `json:"record_value"`
+}
+
+func NewTenantDiscoveryHandler(discoveryService *services.TenantDiscoveryService, tenantService *services.TenantService, auditService *services.AuditService) *TenantDiscoveryHandler {
+	return &TenantDiscoveryHandler{
+		discoveryService: discoveryService,
+		tenantService:    tenantService,
+		auditService:     auditService,
+	}
+}
+
+// DiscoverTenant returns the tenant whose verified domains include the email's domain. Emails of
+// other domains get the default tenant in the same form, so the response doesn't tell which
+// domains belong to a tenant that isn't the default, and only the domain is looked at, so it
+// doesn't tell whether the user exists. Lookups are rate limited per client address.
+func (h *TenantDiscoveryHandler) DiscoverTenant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.discoveryService.ReserveDiscovery(services.ClientIP(r)); err != nil {
+		var limited *services.DiscoveryRateLimitError
+		if errors.As(err, &limited) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+			writeErrorResponse(w, http.StatusTooManyRequests, "rate_limited", limited.Error(), nil)
+			return
+		}
+	}
+
+	var req TenantDiscoveryRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenant, err := h.discoveryService.Discover(req.Email)
+	if errors.Is(err, services.ErrTenantNotDiscovered) {
+		tenant, err = h.tenantService.GetDefaultTenant()
+	}
+	switch {
+	case errors.Is(err, services.ErrInvalidDiscoveryEmail):
+		writeErrorResponse(w, http.StatusBadRequest, "validation_failed", err.Error(), nil)
+		return
+	case err != nil:
+		http.Error(w, services.ErrTenantNotDiscovered.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TenantDiscoveryResponse{
+		TenantID: tenant.ID.Hex(),
+		Name:     tenant.Name,
+		Issuer:   requestBaseURL(r) + "/tenant/" + tenant.ID.Hex(),
+		Branding: tenant.Settings.CustomBranding,
+	})
+}
+
+// GetTenantDomains lists the email domains the tenant has claimed for discovery
+func (h *TenantDiscoveryHandler) GetTenantDomains(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	domains, err := h.discoveryService.ListDomains(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to list domains: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responses := make([]TenantDomainResponse, 0, len(domains))
+	for _, domain := range domains {
+		responses = append(responses, tenantDomainResponse(domain))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+// AddTenantDomain claims an email domain for the tenant and returns the TXT record that verifies
+// it
+func (h *TenantDiscoveryHandler) AddTenantDomain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AddTenantDomainRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenantID := mux.Vars(r)["id"]
+	if _, err := h.tenantService.GetTenantByID(tenantID); err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	domain, err := h.discoveryService.AddDomain(tenantID, req.Domain)
+	if errors.Is(err, services.ErrTenantDomainClaimed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to add domain: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tenantDomainResponse(*domain))
+}
+
+// VerifyTenantDomain marks a claimed domain verified once its TXT record is published
+func (h *TenantDiscoveryHandler) VerifyTenantDomain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tenantID := vars["id"]
+	domain, err := h.discoveryService.VerifyDomain(tenantID, vars["domain"])
+	switch {
+	case errors.Is(err, services.ErrTenantDomainNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrTenantDomainClaimed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrTenantDomainUnverified):
+		writeErrorResponse(w, http.StatusUnprocessableEntity, "domain_unverified", err.Error(), nil)
+		return
+	case err != nil:
+		http.Error(w, "Failed to verify domain: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordActivity(h.auditService, r, &models.AuditEvent{
+		TenantID: tenantID,
+		Type:     models.AuditEventDomainVerified,
+		Target:   domain.Domain,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenantDomainResponse(*domain))
+}
+
+// DeleteTenantDomain drops the tenant's claim on a domain
+func (h *TenantDiscoveryHandler) DeleteTenantDomain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := h.discoveryService.RemoveDomain(vars["id"], vars["domain"])
+	if errors.Is(err, services.ErrTenantDomainNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to remove domain: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tenantDomainResponse(domain models.TenantDomain) TenantDomainResponse {
+	return TenantDomainResponse{
+		TenantDomain: domain,
+		RecordName:   services.TenantDomainRecordPrefix + domain.Domain,
+		RecordValue:  services.TenantDomainRecordValue(domain.VerificationToken),
+	}
+}
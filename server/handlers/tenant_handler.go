@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
+	"oauth2-openid-server/database"
 	"oauth2-openid-server/models"
 	"oauth2-openid-server/services"
 
@@ -19,24 +21,19 @@ type TenantResponse struct {
 
 // WellKnownURLs contains OpenID Connect discovery URLs for the tenant
 type WellKnownURLs struct {
-	OpenIDConfiguration string `json:"openid_configuration"`
+	OpenIDConfiguration      string `json:"openid_configuration"`
+	OAuthAuthorizationServer string `json:"oauth_authorization_server"` // RFC 8414 metadata
 }
 
 // buildTenantResponse creates a tenant response with well-known URLs
 func (h *TenantHandler) buildTenantResponse(tenant *models.Tenant, r *http.Request) *TenantResponse {
-	scheme := "https"
-	if r.Header.Get("X-Forwarded-Proto") != "" {
-		scheme = r.Header.Get("X-Forwarded-Proto")
-	} else if r.TLS == nil {
-		scheme = "http"
-	}
-	
-	baseURL := scheme + "://" + r.Host
+	baseURL := services.RequestBaseURL(r)
 	
 	return &TenantResponse{
 		Tenant: tenant,
 		WellKnownURLs: WellKnownURLs{
-			OpenIDConfiguration: baseURL + "/.well-known/" + tenant.ID.Hex() + "/openid_configuration",
+			OpenIDConfiguration:      baseURL + "/tenant/" + tenant.ID.Hex() + "/.well-known/openid-configuration",
+			OAuthAuthorizationServer: baseURL + "/.well-known/oauth-authorization-server/tenant/" + tenant.ID.Hex(),
 		},
 	}
 }
@@ -55,28 +52,38 @@ type TenantHandler struct {
 	socialProviderService *services.SocialProviderService
 	scopeService          *services.ScopeService
 	groupService          *services.GroupService
+	clientService         *services.ClientService
+	settingsChangeService *services.TenantSettingsChangeService
+	bootstrapService      *services.TenantBootstrapService
+	webBaseURL            string
 }
 
 type CreateTenantRequest struct {
-	Name      string                `json:"name"`
-	Domain    string                `json:"domain"`
-	Subdomain string                `json:"subdomain"`
+	Name      string                `json:"name" validate:"required,max=100"`
+	Domain    string                `json:"domain" validate:"required,hostname"`
+	Subdomain string                `json:"subdomain" validate:"required,slug,max=63"`
+	Region    string                `json:"region" validate:"slug,max=32"` // data residency region, see GET /tenants/regions
 	Settings  models.TenantSettings `json:"settings"`
 }
 
 type UpdateTenantRequest struct {
-	Name      string                `json:"name"`
-	Domain    string                `json:"domain"`
-	Subdomain string                `json:"subdomain"`
+	Name      string                `json:"name" validate:"required,max=100"`
+	Domain    string                `json:"domain" validate:"required,hostname"`
+	Subdomain string                `json:"subdomain" validate:"required,slug,max=63"`
 	Settings  models.TenantSettings `json:"settings"`
+	Version   *int64                `json:"version,omitempty"` // alternative to the If-Match header
 }
 
-func NewTenantHandler(tenantService *services.TenantService, socialProviderService *services.SocialProviderService, scopeService *services.ScopeService, groupService *services.GroupService) *TenantHandler {
+func NewTenantHandler(tenantService *services.TenantService, socialProviderService *services.SocialProviderService, scopeService *services.ScopeService, groupService *services.GroupService, clientService *services.ClientService, settingsChangeService *services.TenantSettingsChangeService, bootstrapService *services.TenantBootstrapService, webBaseURL string) *TenantHandler {
 	return &TenantHandler{
 		tenantService:         tenantService,
 		socialProviderService: socialProviderService,
 		scopeService:          scopeService,
 		groupService:          groupService,
+		clientService:         clientService,
+		settingsChangeService: settingsChangeService,
+		bootstrapService:      bootstrapService,
+		webBaseURL:            webBaseURL,
 	}
 }
 
@@ -87,22 +94,7 @@ func (h *TenantHandler) CreateTenant(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var createReq CreateTenantRequest
-	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate required fields
-	if createReq.Name == "" {
-		http.Error(w, "Name is required", http.StatusBadRequest)
-		return
-	}
-	if createReq.Domain == "" {
-		http.Error(w, "Domain is required", http.StatusBadRequest)
-		return
-	}
-	if createReq.Subdomain == "" {
-		http.Error(w, "Subdomain is required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &createReq) {
 		return
 	}
 
@@ -111,9 +103,14 @@ func (h *TenantHandler) CreateTenant(w http.ResponseWriter, r *http.Request) {
 		Domain:    createReq.Domain,
 		Subdomain: createReq.Subdomain,
 		Settings:  createReq.Settings,
+		Storage:   models.TenantStorage{Region: createReq.Region},
 	}
 
 	if err := h.tenantService.CreateTenant(tenant); err != nil {
+		if errors.Is(err, database.ErrResidencyUnsupported) {
+			writeResidencyUnsupported(w)
+			return
+		}
 		http.Error(w, "Failed to create tenant: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -142,11 +139,70 @@ func (h *TenantHandler) CreateTenant(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Seed the built-in system clients for this tenant (best-effort)
+	if h.clientService != nil {
+		if err := h.clientService.InitializeSystemClients(tenant.ID.Hex(), h.webBaseURL); err != nil {
+			log.Printf("Warning: Failed to initialize system clients for tenant %s: %v", tenant.ID.Hex(), err)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(h.buildTenantResponse(tenant, r))
 }
 
+// BootstrapTenantResponse returns everything a bootstrap created. AdminPassword (when generated)
+// and the client secret are shown only in this response.
+type BootstrapTenantResponse struct {
+	Tenant        *TenantResponse `json:"tenant"`
+	AdminUser     *models.User    `json:"admin_user"`
+	AdminPassword string          `json:"admin_password,omitempty"`
+	Client        *ClientResponse `json:"client"`
+}
+
+// BootstrapTenant creates a tenant with its defaults, an admin user and a first OAuth client in
+// one call, for automation that would otherwise chain several requests
+func (h *TenantHandler) BootstrapTenant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req services.TenantBootstrapRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	result, err := h.bootstrapService.Bootstrap(&req)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, database.ErrResidencyUnsupported) {
+			writeResidencyUnsupported(w)
+			return
+		}
+		if writeQuotaExceeded(w, err) {
+			return
+		}
+		http.Error(w, "Failed to bootstrap tenant: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(BootstrapTenantResponse{
+		Tenant:        h.buildTenantResponse(result.Tenant, r),
+		AdminUser:     result.AdminUser,
+		AdminPassword: result.AdminPassword,
+		Client: &ClientResponse{
+			Client:       result.Client,
+			ClientSecret: result.Client.ClientSecret,
+		},
+	})
+}
+
 func (h *TenantHandler) GetTenants(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -178,6 +234,7 @@ func (h *TenantHandler) GetTenant(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, tenant.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(h.buildTenantResponse(tenant, r))
 }
@@ -192,8 +249,23 @@ func (h *TenantHandler) UpdateTenant(w http.ResponseWriter, r *http.Request) {
 	tenantID := vars["id"]
 
 	var updateReq UpdateTenantRequest
-	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &updateReq) {
+		return
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		impact, err := h.settingsChangeService.Preview(tenantID, updateReq.Settings)
+		if err != nil {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": true, "impact": impact})
+		return
+	}
+
+	version, ok := expectedVersion(w, r, updateReq.Version)
+	if !ok {
 		return
 	}
 
@@ -204,7 +276,10 @@ func (h *TenantHandler) UpdateTenant(w http.ResponseWriter, r *http.Request) {
 		Settings:  updateReq.Settings,
 	}
 
-	if err := h.tenantService.UpdateTenant(tenantID, tenant); err != nil {
+	if err := h.tenantService.UpdateTenant(tenantID, tenant, version); err != nil {
+		if writeVersionConflict(w, err) {
+			return
+		}
 		http.Error(w, "Failed to update tenant: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -216,6 +291,7 @@ func (h *TenantHandler) UpdateTenant(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, updatedTenant.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(h.buildTenantResponse(updatedTenant, r))
 }
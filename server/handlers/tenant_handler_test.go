@@ -34,10 +34,15 @@ func TestBuildTenantResponse(t *testing.T) {
 		t.Error("Expected embedded tenant to match original")
 	}
 
-	expectedURL := "https://authy.imsc.eu/.well-known/" + tenantID.Hex() + "/openid_configuration"
+	expectedURL := "https://authy.imsc.eu/tenant/" + tenantID.Hex() + "/.well-known/openid-configuration"
 	if response.WellKnownURLs.OpenIDConfiguration != expectedURL {
 		t.Errorf("Expected OpenID configuration URL %s, got %s", expectedURL, response.WellKnownURLs.OpenIDConfiguration)
 	}
+
+	expectedMetadataURL := "https://authy.imsc.eu/.well-known/oauth-authorization-server/tenant/" + tenantID.Hex()
+	if response.WellKnownURLs.OAuthAuthorizationServer != expectedMetadataURL {
+		t.Errorf("Expected authorization server metadata URL %s, got %s", expectedMetadataURL, response.WellKnownURLs.OAuthAuthorizationServer)
+	}
 }
 
 func TestBuildTenantResponseHTTP(t *testing.T) {
@@ -61,7 +66,7 @@ func TestBuildTenantResponseHTTP(t *testing.T) {
 	response := handler.buildTenantResponse(tenant, req)
 
 	// Verify HTTP scheme is detected correctly
-	expectedURL := "http://localhost:8080/.well-known/" + tenantID.Hex() + "/openid_configuration"
+	expectedURL := "http://localhost:8080/tenant/" + tenantID.Hex() + "/.well-known/openid-configuration"
 	if response.WellKnownURLs.OpenIDConfiguration != expectedURL {
 		t.Errorf("Expected HTTP OpenID configuration URL %s, got %s", expectedURL, response.WellKnownURLs.OpenIDConfiguration)
 	}
@@ -103,13 +108,13 @@ func TestBuildTenantsResponse(t *testing.T) {
 	}
 
 	// Verify the first tenant response
-	expectedURL1 := "https://authy.imsc.eu/.well-known/" + tenant1ID.Hex() + "/openid_configuration"
+	expectedURL1 := "https://authy.imsc.eu/tenant/" + tenant1ID.Hex() + "/.well-known/openid-configuration"
 	if responses[0].WellKnownURLs.OpenIDConfiguration != expectedURL1 {
 		t.Errorf("Expected first tenant URL %s, got %s", expectedURL1, responses[0].WellKnownURLs.OpenIDConfiguration)
 	}
 
 	// Verify the second tenant response
-	expectedURL2 := "https://authy.imsc.eu/.well-known/" + tenant2ID.Hex() + "/openid_configuration"
+	expectedURL2 := "https://authy.imsc.eu/tenant/" + tenant2ID.Hex() + "/.well-known/openid-configuration"
 	if responses[1].WellKnownURLs.OpenIDConfiguration != expectedURL2 {
 		t.Errorf("Expected second tenant URL %s, got %s", expectedURL2, responses[1].WellKnownURLs.OpenIDConfiguration)
 	}
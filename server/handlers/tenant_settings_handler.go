@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type PreviewSettingsRequest struct {
+	Settings models.TenantSettings `json:"settings"`
+}
+
+type ScheduleSettingsChangeRequest struct {
+	Settings models.TenantSettings `json:"settings"`
+	ApplyAt  *time.Time            `json:"apply_at,omitempty"` // applied immediately when omitted
+}
+
+// PreviewSettings reports who would be affected by new tenant settings without saving them
+func (h *TenantHandler) PreviewSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PreviewSettingsRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	impact, err := h.settingsChangeService.Preview(mux.Vars(r)["id"], req.Settings)
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(impact)
+}
+
+func (h *TenantHandler) GetSettingsChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	changes, err := h.settingsChangeService.List(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to retrieve settings changes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// ScheduleSettingsChange applies new tenant settings at a later time, or right away when no time is given
+func (h *TenantHandler) ScheduleSettingsChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ScheduleSettingsChangeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	var applyAt time.Time
+	if req.ApplyAt != nil {
+		applyAt = *req.ApplyAt
+	}
+
+	change, err := h.settingsChangeService.Schedule(mux.Vars(r)["id"], req.Settings, applyAt, claims.UserID)
+	if err != nil {
+		if change == nil {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to apply settings change: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(change)
+}
+
+func (h *TenantHandler) CancelSettingsChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	change, err := h.settingsChangeService.Cancel(vars["id"], vars["changeId"])
+	if err != nil {
+		writeSettingsChangeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(change)
+}
+
+// RollbackSettingsChange restores the settings an applied change replaced
+func (h *TenantHandler) RollbackSettingsChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	change, err := h.settingsChangeService.Rollback(vars["id"], vars["changeId"])
+	if err != nil {
+		if writeVersionConflict(w, err) {
+			return
+		}
+		writeSettingsChangeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(change)
+}
+
+func writeSettingsChangeError(w http.ResponseWriter, err error) {
+	switch err {
+	case services.ErrSettingsChangeNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case services.ErrSettingsChangeState:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Failed to update settings change: "+err.Error(), http.StatusInternalServerError)
+	}
+}
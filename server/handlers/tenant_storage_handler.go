@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+	"oauth2-openid-server/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// UpdateTenantStorageRequest places a tenant's tenant-scoped collections; empty fields move them
+// back to the shared database of the main cluster
+type UpdateTenantStorageRequest struct {
+	Region           string `json:"region" validate:"slug,max=32"`
+	Database         string `json:"database" validate:"max=63"`
+	CollectionPrefix string `json:"collection_prefix" validate:"max=32"`
+}
+
+// GetTenantStorage returns where a tenant's tenant-scoped collections are stored
+func (h *TenantHandler) GetTenantStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.requirePlatformStorage(w, r) {
+		return
+	}
+
+	tenant, err := h.tenantService.GetTenantByID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenant.Storage)
+}
+
+// UpdateTenantStorage moves a tenant's tenant-scoped collections to its own database or
+// collection prefix, or back to the shared ones. The documents are copied before it returns.
+func (h *TenantHandler) UpdateTenantStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.requirePlatformStorage(w, r) {
+		return
+	}
+
+	tenantID := mux.Vars(r)["id"]
+	if _, err := h.tenantService.GetTenantByID(tenantID); err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	var req UpdateTenantStorageRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	storage := models.TenantStorage{Region: req.Region, Database: req.Database, CollectionPrefix: req.CollectionPrefix}
+	tenant, err := h.tenantService.MoveStorage(tenantID, storage)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidPlacement) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, database.ErrResidencyUnsupported) {
+			writeResidencyUnsupported(w)
+			return
+		}
+		if errors.Is(err, database.ErrRegionUnavailable) {
+			writeRegionUnavailable(w, h.tenantService, req.Region)
+			return
+		}
+		http.Error(w, "Failed to move tenant storage: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setETag(w, tenant.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenant.Storage)
+}
+
+// GetRegions lists the data residency regions this instance can place tenants in
+func (h *TenantHandler) GetRegions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"regions": h.tenantService.Regions()})
+}
+
+// writeRegionUnavailable rejects placing a tenant in a region this instance has no cluster for
+func writeRegionUnavailable(w http.ResponseWriter, tenantService *services.TenantService, region string) {
+	fields := validation.Errors{}
+	fields.Add("region", "oneof", fmt.Sprintf("region %q is not served; available regions: %s", region, strings.Join(tenantService.Regions(), ", ")))
+	writeValidationErrors(w, "region_unavailable", "The region is not served by this server", fields)
+}
+
+// writeResidencyUnsupported rejects placing a tenant in another region
+func writeResidencyUnsupported(w http.ResponseWriter) {
+	fields := validation.Errors{}
+	fields.Add("region", "oneof", database.ErrResidencyUnsupported.Error())
+	writeValidationErrors(w, "residency_unsupported", "Tenants can't be placed in another region", fields)
+}
+
+// requirePlatformStorage only lets the platform operator place tenant data: databases are
+// provisioned and backed up by the platform operator
+func (h *TenantHandler) requirePlatformStorage(w http.ResponseWriter, r *http.Request) bool {
+	if !middleware.IsPlatformOperator(r) {
+		http.Error(w, "Tenant storage is managed by the platform operator", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestTokenEndpointBasicAuth exchanges a code at the token endpoint with client_secret_basic
+// against a real database
+func TestTokenEndpointBasicAuth(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, Email: "jane@example.com", Scopes: []string{"openid"}, Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), ClientID: "backend", ClientSecret: "s3cr:t", Name: "Backend",
+		RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	userService := services.NewUserService(db)
+	oauthService := services.NewOAuthService(db, "test-secret", services.DefaultLifetimes())
+	tenantService := services.NewTenantService(db)
+	handler := NewAuthHandler(userService, oauthService, services.NewSocialAuthService(userService, db),
+		services.NewTwoFactorService(db, services.DefaultLifetimes()),
+		services.NewCIBAService(db, userService, oauthService, services.NewLogNotifier()),
+		services.NewTranslationService(db, tenantService), services.NewAuditService(db), services.NewConsentService(db), services.NewBotProtectionService(db, "test-secret"), services.NewFeatureFlagService(db), services.NewSSOSessionService(db, 0, 0), services.NewCookieService("test-secret", 0))
+
+	code, err := oauthService.CreateAuthorizationCode("backend", userID.Hex(), "", "https://app.example.com/cb", []string{"openid"}, "", "", services.CodeBinding{}, "")
+	if err != nil {
+		t.Fatalf("Failed to create authorization code: %v", err)
+	}
+
+	exchange := func(secret string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://app.example.com/cb"}}
+		rec := httptest.NewRecorder()
+		handler.Token(rec, tokenRequest(form, basicAuthorization("backend", url.QueryEscape(secret))))
+		return rec
+	}
+
+	if rec := exchange("wrong"); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected a 401 challenge for a wrong secret, got %d", rec.Code)
+	}
+
+	rec := exchange("s3cr:t")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var tokens services.TokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil || tokens.AccessToken == "" {
+		t.Errorf("Expected an access token, got %s (%v)", rec.Body.String(), err)
+	}
+}
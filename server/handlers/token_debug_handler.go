@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+)
+
+// TokenDebugHandler explains tokens users report as invalid
+type TokenDebugHandler struct {
+	oauthService *services.OAuthService
+}
+
+func NewTokenDebugHandler(oauthService *services.OAuthService) *TokenDebugHandler {
+	return &TokenDebugHandler{
+		oauthService: oauthService,
+	}
+}
+
+// DebugTokenRequest carries the access, refresh or ID token to explain
+type DebugTokenRequest struct {
+	Token string `json:"token" validate:"required,max=8192"`
+}
+
+// DebugToken returns a token's decoded claims, signature status, signing key ID, database record
+// and the user, client and tenant it belongs to, with the reasons the server would reject it.
+// Tokens of other tenants are reported as not found, except to the platform operator.
+func (h *TokenDebugHandler) DebugToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DebugTokenRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	info, err := h.oauthService.DebugToken(req.Token, tenantID, middleware.IsPlatformOperator(r))
+	if err != nil {
+		if errors.Is(err, services.ErrDebugTokenNotFound) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to inspect token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// VendTokenRequest asks for a narrowed child of the caller's token
+type VendTokenRequest struct {
+	Scopes    []string `json:"scopes" validate:"required,max=50"`
+	Audience  []string `json:"audience,omitempty" validate:"max=10,dive,max=255"`
+	ExpiresIn int      `json:"expires_in,omitempty" validate:"min=0,max=43200"` // seconds (default: 900, capped at the parent's expiry)
+	Purpose   string   `json:"purpose,omitempty" validate:"max=200"`
+}
+
+// VendToken mints a short-lived token with a subset of the caller's scopes, e.g. for handing to a
+// batch job. Revoking the caller's token revokes it too.
+func (h *AuthHandler) VendToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parentToken, ok := vendingParent(w, r)
+	if !ok {
+		return
+	}
+
+	var req VendTokenRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	token, err := h.oauthService.VendToken(parentToken, services.VendTokenRequest{
+		Scopes:   req.Scopes,
+		Audience: req.Audience,
+		Lifetime: time.Duration(req.ExpiresIn) * time.Second,
+		Purpose:  req.Purpose,
+	}, r)
+	if writeVendingError(w, err) || writeQuotaExceeded(w, err) || writeClientRateLimited(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to vend token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+// GetVendedTokens lists the tokens vended from the caller's token and from those tokens in turn
+func (h *AuthHandler) GetVendedTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parentToken, ok := vendingParent(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.oauthService.ListVendedTokens(parentToken)
+	if writeVendingError(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get vended tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokeVendedToken revokes a token vended from the caller's token, and everything vended from it
+func (h *AuthHandler) RevokeVendedToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parentToken, ok := vendingParent(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.oauthService.RevokeVendedToken(parentToken, mux.Vars(r)["id"])
+	if writeVendingError(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke vended token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// vendingParent returns the caller's bearer token, which vended tokens descend from
+func vendingParent(w http.ResponseWriter, r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if middleware.GetClaimsFromRequest(r) == nil || !found || !strings.EqualFold(scheme, "Bearer") {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// writeVendingError answers the errors of narrowing a token
+func writeVendingError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrParentTokenInvalid):
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	case errors.Is(err, services.ErrScopeNotHeld), errors.Is(err, services.ErrAudienceNotHeld):
+		writeErrorResponse(w, http.StatusForbidden, "insufficient_scope", err.Error(), nil)
+	case errors.Is(err, services.ErrVendingDepthExceeded):
+		writeErrorResponse(w, http.StatusForbidden, "vending_depth_exceeded", err.Error(), nil)
+	case errors.Is(err, services.ErrVendedTokenNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/i18n"
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type TranslationHandler struct {
+	translationService *services.TranslationService
+}
+
+func NewTranslationHandler(translationService *services.TranslationService) *TranslationHandler {
+	return &TranslationHandler{
+		translationService: translationService,
+	}
+}
+
+// GetLocales lists the supported locales
+func (h *TranslationHandler) GetLocales(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locales":        i18n.SupportedLocales(),
+		"default_locale": i18n.DefaultLocale,
+	})
+}
+
+// GetMessages returns the effective messages (built-in bundle plus tenant overrides) for a locale.
+// Without ?locale= the locale is negotiated from the request.
+func (h *TranslationHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = h.translationService.LocalizerForRequest(r, tenantID).Locale()
+	}
+	if !i18n.IsSupported(locale) {
+		http.Error(w, "Unsupported locale", http.StatusBadRequest)
+		return
+	}
+
+	messages := i18n.Bundle(locale)
+	overrides, err := h.translationService.GetOverrides(tenantID, locale)
+	if err != nil {
+		http.Error(w, "Failed to get translations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for key, value := range overrides {
+		messages[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", locale)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locale":   locale,
+		"messages": messages,
+	})
+}
+
+// GetTenantTranslations lists a tenant's translation overrides
+func (h *TranslationHandler) GetTenantTranslations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	translations, err := h.translationService.GetAllOverrides(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to get translations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(translations)
+}
+
+// UpdateTenantTranslations sets translation overrides for a tenant and locale.
+// The body is a JSON object mapping message keys to translated text.
+func (h *TranslationHandler) UpdateTenantTranslations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	var messages map[string]string
+	if !decodeJSON(w, r, &messages) {
+		return
+	}
+
+	if err := h.translationService.SetOverrides(vars["id"], vars["locale"], messages); err != nil {
+		http.Error(w, "Failed to update translations: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	overrides, err := h.translationService.GetOverrides(vars["id"], vars["locale"])
+	if err != nil {
+		http.Error(w, "Failed to get translations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overrides)
+}
+
+// DeleteTenantTranslation removes a single translation override
+func (h *TranslationHandler) DeleteTenantTranslation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := h.translationService.DeleteOverride(vars["id"], vars["locale"], vars["key"]); err != nil {
+		http.Error(w, "Failed to delete translation: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
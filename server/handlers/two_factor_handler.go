@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"oauth2-openid-server/models"
 	"oauth2-openid-server/services"
 )
 
@@ -12,37 +13,39 @@ type TwoFactorHandler struct {
 	twoFactorService *services.TwoFactorService
 	userService      *services.UserService
 	oauthService     *services.OAuthService
+	auditService     *services.AuditService
 }
 
 type SetupTwoFactorRequest struct {
-	UserID string `json:"user_id"`
+	UserID string `json:"user_id" validate:"required"`
 }
 
 type EnableTwoFactorRequest struct {
-	UserID string `json:"user_id"`
-	Code   string `json:"code"`
-	Secret string `json:"secret"`
+	UserID string `json:"user_id" validate:"required"`
+	Code   string `json:"code" validate:"required,max=16"`
+	Secret string `json:"secret" validate:"required"`
 }
 
 type VerifyTwoFactorRequest struct {
-	UserID string `json:"user_id"`
-	Code   string `json:"code"`
+	UserID string `json:"user_id" validate:"required"`
+	Code   string `json:"code" validate:"required,max=16"`
 }
 
 type DisableTwoFactorRequest struct {
-	UserID string `json:"user_id"`
+	UserID string `json:"user_id" validate:"required"`
 }
 
 type VerifySessionRequest struct {
-	SessionID string `json:"session_id"`
-	Code      string `json:"code"`
+	SessionID string `json:"session_id" validate:"required"`
+	Code      string `json:"code" validate:"required,max=16"`
 }
 
-func NewTwoFactorHandler(twoFactorService *services.TwoFactorService, userService *services.UserService, oauthService *services.OAuthService) *TwoFactorHandler {
+func NewTwoFactorHandler(twoFactorService *services.TwoFactorService, userService *services.UserService, oauthService *services.OAuthService, auditService *services.AuditService) *TwoFactorHandler {
 	return &TwoFactorHandler{
 		twoFactorService: twoFactorService,
 		userService:      userService,
 		oauthService:     oauthService,
+		auditService:     auditService,
 	}
 }
 
@@ -53,13 +56,7 @@ func (h *TwoFactorHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request
 	}
 
 	var req SetupTwoFactorRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if req.UserID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -80,13 +77,7 @@ func (h *TwoFactorHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Reques
 	}
 
 	var req EnableTwoFactorRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if req.UserID == "" || req.Code == "" || req.Secret == "" {
-		http.Error(w, "User ID, code, and secret are required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -95,6 +86,9 @@ func (h *TwoFactorHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Reques
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if user, err := h.userService.GetUserByID(req.UserID); err == nil {
+		recordActivity(h.auditService, r, &models.AuditEvent{TenantID: user.TenantID, Type: models.AuditEventTwoFactorEnabled, UserID: req.UserID, Email: user.Email})
+	}
 
 	response := map[string]interface{}{
 		"success": true,
@@ -112,13 +106,7 @@ func (h *TwoFactorHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Reque
 	}
 
 	var req DisableTwoFactorRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if req.UserID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -144,13 +132,7 @@ func (h *TwoFactorHandler) VerifyTwoFactor(w http.ResponseWriter, r *http.Reques
 	}
 
 	var req VerifyTwoFactorRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if req.UserID == "" || req.Code == "" {
-		http.Error(w, "User ID and code are required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -181,13 +163,7 @@ func (h *TwoFactorHandler) VerifySession(w http.ResponseWriter, r *http.Request)
 	}
 
 	var req VerifySessionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if req.SessionID == "" || req.Code == "" {
-		http.Error(w, "Session ID and code are required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -256,9 +232,16 @@ func (h *TwoFactorHandler) GetTwoFactorStatus(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	methods, err := h.twoFactorService.TwoFactorMethods(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	response := map[string]interface{}{
 		"enabled":          enabled,
 		"has_backup_codes": hasBackupCodes,
+		"methods":          methods,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+)
+
+type UserBulkHandler struct {
+	bulkService  *services.UserBulkService
+	auditService *services.AuditService
+}
+
+// BulkUserRequest applies one operation to many users. add_group and remove_group need group
+// (name or ID), add_scope and remove_scope need scope.
+type BulkUserRequest struct {
+	UserIDs   []string `json:"user_ids" validate:"required,max=500,dive,max=64"`
+	Operation string   `json:"operation" validate:"required,oneof=activate deactivate add_group remove_group add_scope remove_scope force_password_reset"`
+	Group     string   `json:"group" validate:"max=100"`
+	Scope     string   `json:"scope" validate:"max=100"`
+	Reason    string   `json:"reason" validate:"max=500"`
+}
+
+func NewUserBulkHandler(bulkService *services.UserBulkService, auditService *services.AuditService) *UserBulkHandler {
+	return &UserBulkHandler{
+		bulkService:  bulkService,
+		auditService: auditService,
+	}
+}
+
+// BulkUpdateUsers applies an operation to each of the given users and reports the outcome per user.
+// The response is 200 even if some users failed; the request fails as a whole only if the
+// operation itself is invalid.
+func (h *UserBulkHandler) BulkUpdateUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	var req BulkUserRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	actorID := ""
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+		actorID = claims.UserID
+	}
+
+	op := services.BulkUserOperation{Operation: req.Operation, Group: req.Group, Scope: req.Scope, Reason: req.Reason}
+	report, err := h.bulkService.Apply(tenantID, actorID, op, req.UserIDs, r)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid_operation", err.Error(), nil)
+		return
+	}
+
+	// Lifecycle transitions are audited by the lifecycle service
+	if eventType := bulkAuditEvent(req.Operation); eventType != "" {
+		for _, result := range report.Results {
+			if result.Status == services.BulkUserUpdated {
+				recordActivity(h.auditService, r, &models.AuditEvent{TenantID: tenantID, Type: eventType, UserID: result.UserID, Target: report.Target})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// bulkAuditEvent returns the audit event type recorded for each user a bulk operation changed
+func bulkAuditEvent(operation string) string {
+	switch operation {
+	case services.BulkUserAddGroup:
+		return models.AuditEventGroupMemberAdded
+	case services.BulkUserRemoveGroup:
+		return models.AuditEventGroupMemberRemoved
+	case services.BulkUserAddScope:
+		return models.AuditEventUserScopeAdded
+	case services.BulkUserRemoveScope:
+		return models.AuditEventUserScopeRemoved
+	case services.BulkUserForcePasswordReset:
+		return models.AuditEventPasswordResetForced
+	}
+	return ""
+}
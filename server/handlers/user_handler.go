@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"oauth2-openid-server/middleware"
@@ -15,44 +16,120 @@ import (
 )
 
 type UserHandler struct {
-	userService   *services.UserService
-	tenantService *services.TenantService
-	groupService  *services.GroupService
+	userService          *services.UserService
+	tenantService        *services.TenantService
+	groupService         *services.GroupService
+	membershipService    *services.MembershipService
+	consentService       *services.ConsentService
+	emailChange          *services.EmailChangeService
+	deactivation         *services.UserDeactivationService
+	lifecycle            *services.UserLifecycleService
+	registrationNotifier *services.RegistrationNotifier
+	botProtection        *services.BotProtectionService
+	passwordBreach       *services.PasswordBreachService
 }
 
 type CreateUserRequest struct {
-	Email     string   `json:"email"`
-	Username  string   `json:"username"`
-	Password  string   `json:"password"`
-	FirstName string   `json:"first_name"`
-	LastName  string   `json:"last_name"`
+	Email     string   `json:"email" validate:"required,email,max=254"`
+	Username  string   `json:"username" validate:"max=64"`
+	Phone     string   `json:"phone" validate:"max=32"`
+	Password  string   `json:"password" validate:"required,min=6,max=72"`
+	FirstName string   `json:"first_name" validate:"max=100"`
+	LastName  string   `json:"last_name" validate:"max=100"`
 	Groups    []string `json:"groups"`
-	Scopes    []string `json:"scopes"`
+	Scopes    []string `json:"scopes" validate:"dive,max=100"`
+	Status    string   `json:"status,omitempty" validate:"oneof=active pending"` // pending users can't sign in until activated (default: active)
 }
 
 type UpdateUserRequest struct {
-	Email     string   `json:"email"`
-	Username  string   `json:"username"`
-	FirstName string   `json:"first_name"`
-	LastName  string   `json:"last_name"`
+	Email     string   `json:"email" validate:"required,email,max=254"`
+	Username  string   `json:"username" validate:"max=64"`
+	Phone     *string  `json:"phone,omitempty" validate:"max=32"` // omitted keeps the phone number and its verification
+	FirstName string   `json:"first_name" validate:"max=100"`
+	LastName  string   `json:"last_name" validate:"max=100"`
 	Groups    []string `json:"groups"`
-	Scopes    []string `json:"scopes"`
+	Scopes    []string `json:"scopes" validate:"dive,max=100"`
 	Active    bool     `json:"active"`
+	Version   *int64   `json:"version,omitempty"` // alternative to the If-Match header
 }
 
 type RegisterUserRequest struct {
-	Email     string `json:"email"`
-	Username  string `json:"username"`
-	Password  string `json:"password"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
+	Email     string `json:"email" validate:"required,email,max=254"`
+	Username  string `json:"username" validate:"max=64"`
+	Phone     string `json:"phone" validate:"max=32"`
+	Password  string `json:"password" validate:"required,min=8,max=72"`
+	FirstName string `json:"first_name" validate:"max=100"`
+	LastName  string `json:"last_name" validate:"max=100"`
+	// CaptchaToken is the bot protection challenge response
+	CaptchaToken string `json:"captcha_token,omitempty" validate:"max=4096"`
 }
 
-func NewUserHandler(userService *services.UserService, tenantService *services.TenantService, groupService *services.GroupService) *UserHandler {
+func NewUserHandler(userService *services.UserService, tenantService *services.TenantService, groupService *services.GroupService, membershipService *services.MembershipService, consentService *services.ConsentService, emailChange *services.EmailChangeService, deactivation *services.UserDeactivationService, lifecycle *services.UserLifecycleService, registrationNotifier *services.RegistrationNotifier, botProtection *services.BotProtectionService, passwordBreach *services.PasswordBreachService) *UserHandler {
 	return &UserHandler{
-		userService:   userService,
-		tenantService: tenantService,
-		groupService:  groupService,
+		userService:          userService,
+		tenantService:        tenantService,
+		groupService:         groupService,
+		membershipService:    membershipService,
+		consentService:       consentService,
+		emailChange:          emailChange,
+		deactivation:         deactivation,
+		lifecycle:            lifecycle,
+		registrationNotifier: registrationNotifier,
+		botProtection:        botProtection,
+		passwordBreach:       passwordBreach,
+	}
+}
+
+// checkLoginIdentifiers rejects a username or phone number that another user of the tenant
+// already signs in with. It writes the error response and returns false on conflict.
+func (h *UserHandler) checkLoginIdentifiers(w http.ResponseWriter, tenantID, userID, username, phone string) bool {
+	if phone != "" && services.NormalizePhone(phone) == "" {
+		http.Error(w, "Invalid phone number", http.StatusBadRequest)
+		return false
+	}
+	if h.userService.LoginIdentifierInUse(services.LoginIdentifierUsername, username, tenantID, userID) {
+		http.Error(w, "User with this username already exists", http.StatusConflict)
+		return false
+	}
+	if h.userService.LoginIdentifierInUse(services.LoginIdentifierPhone, phone, tenantID, userID) {
+		http.Error(w, "User with this phone number already exists", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// takenLoginIdentifier names the login identifier another user of the tenant already signs in
+// with ("username" or "phone number"), or returns ""
+func (h *UserHandler) takenLoginIdentifier(tenantID, username, phone string) string {
+	if h.userService.LoginIdentifierInUse(services.LoginIdentifierUsername, username, tenantID, "") {
+		return "username"
+	}
+	if h.userService.LoginIdentifierInUse(services.LoginIdentifierPhone, phone, tenantID, "") {
+		return "phone number"
+	}
+	return ""
+}
+
+// withGroupNames replaces the stored group IDs of users with group names for API responses
+func (h *UserHandler) withGroupNames(tenantID string, users ...*models.User) {
+	groups, err := h.groupService.GetAllGroups(tenantID)
+	if err != nil {
+		return
+	}
+
+	names := make(map[string]string, len(groups))
+	for _, group := range groups {
+		names[group.ID.Hex()] = group.Name
+	}
+
+	for _, user := range users {
+		groupNames := []string{}
+		for _, id := range user.Groups {
+			if name, ok := names[id]; ok {
+				groupNames = append(groupNames, name)
+			}
+		}
+		user.Groups = groupNames
 	}
 }
 
@@ -70,22 +147,10 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var createReq CreateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate required fields
-	if createReq.Email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
-		return
-	}
-	if createReq.Password == "" {
-		http.Error(w, "Password is required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &createReq) {
 		return
 	}
-	if len(createReq.Password) < 6 {
-		http.Error(w, "Password must be at least 6 characters", http.StatusBadRequest)
+	if !checkPasswordBreach(w, h.passwordBreach, tenantID, createReq.Password) {
 		return
 	}
 
@@ -94,30 +159,51 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User with this email already exists", http.StatusConflict)
 		return
 	}
+	if !h.checkLoginIdentifiers(w, tenantID, "", createReq.Username, createReq.Phone) {
+		return
+	}
 
 	// Set default scopes if none provided
 	if len(createReq.Scopes) == 0 {
-		createReq.Scopes = []string{"read", "openid", "profile", "email"}
+		createReq.Scopes = h.tenantService.DefaultScopes(tenantID, services.DefaultScopesRegistration)
+	}
+
+	// Groups may be given by name or ID; they are stored as IDs
+	groupIDs, err := h.membershipService.ResolveGroupIDs(tenantID, createReq.Groups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	user := &models.User{
 		TenantID:     tenantID,
 		Email:        createReq.Email,
 		Username:     createReq.Username,
+		Phone:        createReq.Phone,
 		PasswordHash: createReq.Password,
 		FirstName:    createReq.FirstName,
 		LastName:     createReq.LastName,
-		Groups:       createReq.Groups,
+		Groups:       groupIDs,
 		Scopes:       createReq.Scopes,
+		Status:       createReq.Status,
 	}
 
 	if err := h.userService.CreateUser(user); err != nil {
+		if writeQuotaExceeded(w, err) {
+			return
+		}
 		http.Error(w, "Failed to create user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if err := h.membershipService.SyncUser(user); err != nil {
+		http.Error(w, "Failed to update group memberships: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Clear password before returning
 	user.PasswordHash = ""
+	h.withGroupNames(tenantID, user)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -146,11 +232,51 @@ func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
 	for _, user := range users {
 		user.PasswordHash = ""
 	}
+	h.withGroupNames(tenantID, users...)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
 }
 
+// SearchUsers returns lightweight users matching ?q= by email, username or name, for member pickers
+func (h *UserHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := services.DefaultUserSearchLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := h.userService.SearchUsers(tenantID, query, limit)
+	if err != nil {
+		http.Error(w, "Failed to search users: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -174,7 +300,9 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user.PasswordHash = ""
+	h.withGroupNames(tenantID, user)
 
+	setETag(w, user.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
@@ -196,31 +324,106 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	userID := vars["id"]
 
 	var updateReq UpdateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &updateReq) {
+		return
+	}
+
+	version, ok := expectedVersion(w, r, updateReq.Version)
+	if !ok {
+		return
+	}
+
+	existingUser, err := h.userService.GetUserByIDAndTenant(userID, tenantID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	phone := existingUser.Phone
+	if updateReq.Phone != nil {
+		phone = *updateReq.Phone
+	}
+
+	if !h.checkLoginIdentifiers(w, tenantID, userID, updateReq.Username, phone) {
+		return
+	}
+
+	groupIDs, err := h.membershipService.ResolveGroupIDs(tenantID, updateReq.Groups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// Changing active suspends or reactivates the user
+	targetStatus := ""
+	if updateReq.Active != existingUser.Active {
+		targetStatus = models.UserStatusSuspended
+		if updateReq.Active {
+			targetStatus = models.UserStatusActive
+		}
+		if !models.CanTransitionUserStatus(existingUser.LifecycleStatus(), targetStatus) {
+			http.Error(w, services.ErrInvalidUserTransition.Error(), http.StatusConflict)
+			return
+		}
+	}
+
 	user := &models.User{
 		TenantID:  tenantID,
 		Email:     updateReq.Email,
 		Username:  updateReq.Username,
+		Phone:     phone,
 		FirstName: updateReq.FirstName,
 		LastName:  updateReq.LastName,
-		Groups:    updateReq.Groups,
-		Active:    updateReq.Active,
+		Groups:    groupIDs,
 		Scopes:    updateReq.Scopes,
 	}
 
-	if err := h.userService.UpdateUserInTenant(userID, tenantID, user); err != nil {
+	if err := h.userService.UpdateUserInTenant(userID, tenantID, user, version); err != nil {
+		if writeVersionConflict(w, err) {
+			return
+		}
 		http.Error(w, "Failed to update user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	user.PasswordHash = ""
+	// Suspended users lose access right away instead of when their tokens expire
+	if targetStatus != "" {
+		actorID := ""
+		if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+			actorID = claims.UserID
+		}
+		if _, err := h.lifecycle.Transition(tenantID, userID, targetStatus, "", actorID, r); err != nil {
+			http.Error(w, "Failed to change the user's state: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Deactivated users are dropped from group member lists; reactivation restores them
+	if err := h.membershipService.SyncUserByID(userID, tenantID); err != nil {
+		http.Error(w, "Failed to update group memberships: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	updatedUser, err := h.userService.GetUserByIDAndTenant(userID, tenantID)
+	if err != nil {
+		http.Error(w, "Failed to get updated user", http.StatusInternalServerError)
+		return
+	}
+
+	// A new email address only takes effect once it has been verified
+	if updateReq.Email != updatedUser.Email && updateReq.Email != updatedUser.PendingEmail {
+		if _, err := h.emailChange.RequestEmailChange(userID, tenantID, updateReq.Email); !writeEmailChangeError(w, err) {
+			return
+		}
+		updatedUser.PendingEmail = updateReq.Email
+	}
+
+	updatedUser.PasswordHash = ""
+	h.withGroupNames(tenantID, updatedUser)
+
+	setETag(w, updatedUser.Version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(updatedUser)
 }
 
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
@@ -239,11 +442,27 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
+	user, err := h.userService.GetUserByIDAndTenant(userID, tenantID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
 	if err := h.userService.DeleteUserInTenant(userID, tenantID); err != nil {
 		http.Error(w, "Failed to delete user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if err := h.deactivation.Deleted(user, r); err != nil {
+		http.Error(w, "Failed to revoke tokens of deleted user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.membershipService.RemoveUser(userID, tenantID); err != nil {
+		http.Error(w, "Failed to update group memberships: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -262,7 +481,7 @@ func (h *UserHandler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Extract user ID from JWT token in Authorization header
-	userID, err := h.extractUserIDFromToken(r)
+	userID, clientID, err := h.extractUserIDFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 		return
@@ -283,26 +502,148 @@ func (h *UserHandler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 		"username":   user.Username,
 		"first_name": user.FirstName,
 		"last_name":  user.LastName,
-		"groups":     user.Groups,
+		"groups":     h.membershipService.GetGroupNames(tenantID, user.Groups),
 		"scopes":     user.Scopes,
 		"active":     user.Active,
 		"two_factor_enabled": user.TwoFactorEnabled,
 	}
 
+	// The profile scope releases the optional profile attributes
+	if tokenClaims := middleware.GetClaimsFromRequest(r); tokenClaims == nil || containsValue(tokenClaims.Scopes, "profile") {
+		for name, value := range services.ProfileClaims(user) {
+			response[name] = value
+		}
+	}
+
+	// Tokens issued to a client only release the claims the user agreed to share with it
+	if clientID != "" {
+		for _, claim := range h.consentService.WithheldClaims(userID, clientID, tenantID) {
+			switch claim {
+			case services.ClaimEmail:
+				delete(response, "email")
+			case services.ClaimName:
+				delete(response, "first_name")
+				delete(response, "last_name")
+			case services.ClaimGroups:
+				delete(response, "groups")
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// Helper function to extract user ID from JWT token
-func (h *UserHandler) extractUserIDFromToken(r *http.Request) (string, error) {
+// maxGroupsPageSize caps a page of the signed-in user's groups
+const maxGroupsPageSize = 500
+
+// GetMyGroups returns the signed-in user's groups that the token's client may see, a page at a
+// time (?limit=, default and maximum 500; ?cursor= is the next_cursor of the previous page). ID
+// tokens of users in too many groups refer to this endpoint instead of carrying the group names.
+func (h *UserHandler) GetMyGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := maxGroupsPageSize
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if parsed < limit {
+			limit = parsed
+		}
+	}
+	offset := 0
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		parsed, err := strconv.Atoi(cursor)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	user, err := h.userService.GetUserByIDAndTenant(claims.UserID, claims.TenantID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	groups := []string{}
+	if claims.ClientID == "" || !containsValue(h.consentService.WithheldClaims(claims.UserID, claims.ClientID, claims.TenantID), services.ClaimGroups) {
+		groups = h.membershipService.GroupNamesForClient(user, claims.ClientID)
+	}
+
+	response := map[string]interface{}{}
+	if offset < len(groups) {
+		end := offset + limit
+		if end < len(groups) {
+			response["next_cursor"] = strconv.Itoa(end)
+		} else {
+			end = len(groups)
+		}
+		response["groups"] = groups[offset:end]
+	} else {
+		response["groups"] = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateMyProfile changes the signed-in user's picture, locale and time zone. Omitted attributes
+// are kept; empty strings clear them.
+func (h *UserHandler) UpdateMyProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := middleware.GetClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req services.ProfileAttributes
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	user, err := h.userService.UpdateProfileAttributes(claims.UserID, claims.TenantID, req)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services.ProfileClaims(user))
+}
+
+// Helper function to extract the user ID and the client the JWT token was issued to
+func (h *UserHandler) extractUserIDFromToken(r *http.Request) (string, string, error) {
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+		return claims.UserID, claims.ClientID, nil
+	}
+
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return "", fmt.Errorf("authorization header missing")
+		return "", "", fmt.Errorf("authorization header missing")
 	}
 
 	parts := strings.Split(authHeader, " ")
 	if len(parts) != 2 || parts[0] != "Bearer" {
-		return "", fmt.Errorf("invalid authorization header format")
+		return "", "", fmt.Errorf("invalid authorization header format")
 	}
 
 	token := parts[1]
@@ -310,7 +651,7 @@ func (h *UserHandler) extractUserIDFromToken(r *http.Request) (string, error) {
 	// Parse JWT token (simplified - just decode the payload)
 	parts = strings.Split(token, ".")
 	if len(parts) != 3 {
-		return "", fmt.Errorf("invalid JWT token format")
+		return "", "", fmt.Errorf("invalid JWT token format")
 	}
 
 	// Decode the payload (second part)
@@ -322,21 +663,23 @@ func (h *UserHandler) extractUserIDFromToken(r *http.Request) (string, error) {
 	
 	decoded, err := base64.URLEncoding.DecodeString(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode JWT payload")
+		return "", "", fmt.Errorf("failed to decode JWT payload")
 	}
 
 	var claims map[string]interface{}
 	if err := json.Unmarshal(decoded, &claims); err != nil {
-		return "", fmt.Errorf("failed to parse JWT claims")
+		return "", "", fmt.Errorf("failed to parse JWT claims")
 	}
 
 	// Extract user ID from claims
 	userID, ok := claims["user_id"].(string)
 	if !ok {
-		return "", fmt.Errorf("user_id not found in token")
+		return "", "", fmt.Errorf("user_id not found in token")
 	}
 
-	return userID, nil
+	clientID, _ := claims["client_id"].(string)
+
+	return userID, clientID, nil
 }
 
 // RegisterUser handles public user registration for tenants that allow it
@@ -366,30 +709,44 @@ func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var registerReq RegisterUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&registerReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate required fields
-	if registerReq.Email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
+	if !decodeRequest(w, r, &registerReq) {
 		return
 	}
-	if registerReq.Password == "" {
-		http.Error(w, "Password is required", http.StatusBadRequest)
+	if !checkBotProtection(w, r, h.botProtection, tenantID, models.BotEndpointRegister, registerReq.CaptchaToken) {
 		return
 	}
-	if len(registerReq.Password) < 8 {
-		http.Error(w, "Password must be at least 8 characters", http.StatusBadRequest)
+	// Checked before the account lookups, so a refusal doesn't tell whether an account exists
+	if !checkPasswordBreach(w, h.passwordBreach, tenantID, registerReq.Password) {
 		return
 	}
 
-	// Check if user already exists in this tenant
+	// Check if user already exists in this tenant. In anti-enumeration mode taken emails, usernames
+	// and phone numbers are answered like successful registrations and explained by email instead.
+	antiEnumeration := tenant.Settings.AntiEnumeration
 	if existingUser, _ := h.userService.GetUserByEmailAndTenant(registerReq.Email, tenantID); existingUser != nil {
+		if antiEnumeration {
+			h.userService.CheckCredentials(nil, registerReq.Password) // takes as long as hashing a new password
+			h.registrationNotifier.ExistingAccount(tenantID, existingUser.Email)
+			writeRegistrationReceived(w)
+			return
+		}
 		http.Error(w, "User with this email already exists", http.StatusConflict)
 		return
 	}
+	if antiEnumeration {
+		if registerReq.Phone != "" && services.NormalizePhone(registerReq.Phone) == "" {
+			http.Error(w, "Invalid phone number", http.StatusBadRequest)
+			return
+		}
+		if taken := h.takenLoginIdentifier(tenantID, registerReq.Username, registerReq.Phone); taken != "" {
+			h.userService.CheckCredentials(nil, registerReq.Password)
+			h.registrationNotifier.IdentifierTaken(tenantID, registerReq.Email, taken)
+			writeRegistrationReceived(w)
+			return
+		}
+	} else if !h.checkLoginIdentifiers(w, tenantID, "", registerReq.Username, registerReq.Phone) {
+		return
+	}
 
 	// Find the "Standard Users" group to assign to new registrations
 	var userGroups []string
@@ -398,12 +755,13 @@ func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Set default scopes for registered users
-	defaultScopes := []string{"read", "openid", "profile", "email", "read:profile", "write:profile"}
+	defaultScopes := h.tenantService.DefaultScopes(tenantID, services.DefaultScopesRegistration)
 
 	user := &models.User{
 		TenantID:     tenantID,
 		Email:        registerReq.Email,
 		Username:     registerReq.Username,
+		Phone:        registerReq.Phone,
 		PasswordHash: registerReq.Password,
 		FirstName:    registerReq.FirstName,
 		LastName:     registerReq.LastName,
@@ -413,12 +771,26 @@ func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.userService.CreateUser(user); err != nil {
+		if writeQuotaExceeded(w, err) {
+			return
+		}
 		http.Error(w, "Failed to register user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if err := h.membershipService.SyncUser(user); err != nil {
+		http.Error(w, "Failed to update group memberships: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if antiEnumeration {
+		writeRegistrationReceived(w)
+		return
+	}
+
 	// Clear password before returning
 	user.PasswordHash = ""
+	h.withGroupNames(tenantID, user)
 
 	response := map[string]interface{}{
 		"message":    "User registered successfully",
@@ -429,4 +801,15 @@ func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// writeRegistrationReceived answers registrations in anti-enumeration mode, the same whether an
+// account was created or not
+func writeRegistrationReceived(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":   "Registration received. If you can't sign in, check your email.",
+		"login_url": "/auth/login",
+	})
+}
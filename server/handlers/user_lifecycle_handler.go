@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type UserLifecycleHandler struct {
+	lifecycleService *services.UserLifecycleService
+}
+
+type UserTransitionRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+func NewUserLifecycleHandler(lifecycleService *services.UserLifecycleService) *UserLifecycleHandler {
+	return &UserLifecycleHandler{
+		lifecycleService: lifecycleService,
+	}
+}
+
+// SuspendUser locks an active or pending user out until it is reactivated
+func (h *UserLifecycleHandler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	h.transition(w, r, models.UserStatusSuspended)
+}
+
+// ReactivateUser lets a pending or suspended user sign in
+func (h *UserLifecycleHandler) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	h.transition(w, r, models.UserStatusActive)
+}
+
+// DeprovisionUser locks a user out for good and removes its credentials. The user stays stored for
+// audit; deleting it is a separate step.
+func (h *UserLifecycleHandler) DeprovisionUser(w http.ResponseWriter, r *http.Request) {
+	h.transition(w, r, models.UserStatusDeprovisioned)
+}
+
+func (h *UserLifecycleHandler) transition(w http.ResponseWriter, r *http.Request, to string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	var req UserTransitionRequest
+	if r.ContentLength != 0 && !decodeRequest(w, r, &req) {
+		return
+	}
+
+	actorID := ""
+	if claims := middleware.GetClaimsFromRequest(r); claims != nil {
+		actorID = claims.UserID
+	}
+
+	user, err := h.lifecycleService.Transition(tenantID, mux.Vars(r)["id"], to, req.Reason, actorID, r)
+	switch {
+	case err == services.ErrLifecycleUserNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == services.ErrInvalidUserTransition:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case writeVersionConflict(w, err):
+		return
+	case err != nil:
+		http.Error(w, "Failed to change the user's state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user.PasswordHash = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+type UserMergeHandler struct {
+	mergeService *services.UserMergeService
+}
+
+type MergeUserRequest struct {
+	DuplicateID string `json:"duplicate_id" validate:"required"`
+	DryRun      bool   `json:"dry_run"`
+	Confirm     string `json:"confirm" validate:"max=254"` // the duplicate's email, required unless dry_run is set
+}
+
+func NewUserMergeHandler(mergeService *services.UserMergeService) *UserMergeHandler {
+	return &UserMergeHandler{
+		mergeService: mergeService,
+	}
+}
+
+// MergeUser merges the duplicate user into the user of the path and deletes the duplicate. With
+// dry_run set the changes are only reported.
+func (h *UserMergeHandler) MergeUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := middleware.GetTenantIDFromRequest(r)
+	if tenantID == "" {
+		http.Error(w, "Tenant context required", http.StatusBadRequest)
+		return
+	}
+
+	var req MergeUserRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	survivorID := mux.Vars(r)["id"]
+	var plan *services.UserMergePlan
+	var err error
+	if req.DryRun {
+		plan, err = h.mergeService.PlanMerge(tenantID, survivorID, req.DuplicateID)
+	} else {
+		plan, err = h.mergeService.MergeUsers(tenantID, survivorID, req.DuplicateID, req.Confirm)
+	}
+
+	switch {
+	case err == services.ErrMergeSameUser || err == services.ErrMergeNotConfirmed:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err == services.ErrMergeUserNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to merge users: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
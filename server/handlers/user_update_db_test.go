@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestUpdateUserKeepsOmittedPhone sends the body of the user form, which has no phone field, and
+// checks that the verified phone and SMS sign-in survive
+func TestUpdateUserKeepsOmittedPhone(t *testing.T) {
+	db := dbtest.New(t)
+	userService := services.NewUserService(db)
+	user := &models.User{TenantID: "acme", Email: "jane@acme.com", Phone: "+15550100", PasswordHash: "password1", Active: true}
+	if err := userService.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if _, err := db.GetCollection("users").UpdateOne(context.Background(), bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"phone_verified": true, "sms_two_factor": true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	users := NewUserHandler(userService, services.NewTenantService(db), services.NewGroupService(db), services.NewMembershipService(db),
+		services.NewConsentService(db), nil, nil, nil, nil, nil, nil)
+	update := func(body string) *models.User {
+		t.Helper()
+		current, err := userService.GetUserByIDAndTenant(user.ID.Hex(), "acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/users/"+user.ID.Hex(), strings.NewReader(body))
+		r.Header.Set("If-Match", fmt.Sprintf(`"%d"`, current.Version))
+		r = mux.SetURLVars(r, map[string]string{"id": user.ID.Hex()})
+		r = r.WithContext(context.WithValue(r.Context(), middleware.TenantIDKey, "acme"))
+		w := httptest.NewRecorder()
+		users.UpdateUser(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("UpdateUser() = %d %s", w.Code, w.Body)
+		}
+		updated, err := userService.GetUserByIDAndTenant(user.ID.Hex(), "acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return updated
+	}
+
+	updated := update(`{"email": "jane@acme.com", "first_name": "Jane", "active": true}`)
+	if updated.FirstName != "Jane" || updated.Phone != "+15550100" || !updated.PhoneVerified || !updated.SMSTwoFactor {
+		t.Errorf("update without a phone: got phone %q verified %v sms %v", updated.Phone, updated.PhoneVerified, updated.SMSTwoFactor)
+	}
+
+	// A new number has to be verified again
+	updated = update(`{"email": "jane@acme.com", "phone": "+15550199", "active": true}`)
+	if updated.Phone != "+15550199" || updated.PhoneVerified || updated.SMSTwoFactor {
+		t.Errorf("update with a new phone: got phone %q verified %v sms %v", updated.Phone, updated.PhoneVerified, updated.SMSTwoFactor)
+	}
+}
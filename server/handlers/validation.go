@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/validation"
+)
+
+// ValidationErrorResponse is the body of 400 responses to malformed or invalid request bodies
+// and of 413 responses to oversized ones. Fields lists the violations per JSON field so clients
+// can show them next to form inputs.
+type ValidationErrorResponse struct {
+	Error   string            `json:"error"`
+	Message string            `json:"message"`
+	Fields  validation.Errors `json:"fields"`
+}
+
+// decodeRequest decodes the JSON request body into dst and validates it against its
+// `validate` tags. On failure it writes a 400 or 413 ValidationErrorResponse and returns false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if !decodeJSON(w, r, dst) {
+		return false
+	}
+
+	if errs := validation.Struct(dst); errs != nil {
+		writeValidationErrors(w, "validation_failed", "Request validation failed", errs)
+		return false
+	}
+
+	return true
+}
+
+// decodeJSON strictly decodes the JSON request body into dst: bodies over the route's limit
+// (see middleware.LimitBody) are refused with 413, unknown fields, trailing data and type
+// mismatches with 400.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	limit := middleware.GetBodyLimit(r)
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil {
+		if _, trailing := decoder.Token(); trailing != io.EOF {
+			err = errors.New("unexpected data after the JSON body")
+		}
+	}
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeErrorResponse(w, http.StatusRequestEntityTooLarge, "request_too_large",
+			fmt.Sprintf("Request body exceeds %d bytes", limit), validation.Errors{})
+		return false
+	}
+
+	fields := validation.Errors{}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		fields.Add(typeErr.Field, "type", typeErr.Field+" must be of type "+typeErr.Type.String())
+	}
+	if field, ok := unknownField(err); ok {
+		fields.Add(field, "unknown", field+" is not a known field")
+	}
+	writeValidationErrors(w, "invalid_request_body", "Invalid request body", fields)
+	return false
+}
+
+// unknownField returns the field named by the error DisallowUnknownFields reports
+func unknownField(err error) (string, bool) {
+	quoted, found := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !found {
+		return "", false
+	}
+	field, err := strconv.Unquote(quoted)
+	return field, err == nil
+}
+
+// writeValidationErrors writes a 400 response listing field violations
+func writeValidationErrors(w http.ResponseWriter, code, message string, fields validation.Errors) {
+	writeErrorResponse(w, http.StatusBadRequest, code, message, fields)
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, code, message string, fields validation.Errors) {
+	if fields == nil {
+		fields = validation.Errors{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ValidationErrorResponse{
+		Error:   code,
+		Message: message,
+		Fields:  fields,
+	})
+}
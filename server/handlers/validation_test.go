@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/middleware"
+)
+
+func TestDecodeRequestWritesFieldErrors(t *testing.T) {
+	var req CreateGroupRequest
+	w := httptest.NewRecorder()
+	if decodeRequest(w, httptest.NewRequest("POST", "/api/v1/groups", strings.NewReader(`{"name": ""}`)), &req) {
+		t.Fatal("Expected an empty group name to be rejected")
+	}
+
+	var body ValidationErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Expected a JSON body: %v", err)
+	}
+	if w.Code != 400 || body.Error != "validation_failed" {
+		t.Errorf("Expected 400 validation_failed, got %d %q", w.Code, body.Error)
+	}
+	if len(body.Fields) != 1 || body.Fields[0].Field != "name" || body.Fields[0].Rule != "required" {
+		t.Errorf("Expected a required error on name, got %+v", body.Fields)
+	}
+}
+
+func TestDecodeRequestReportsTypeErrors(t *testing.T) {
+	var req UpdateUserRequest
+	w := httptest.NewRecorder()
+	if decodeRequest(w, httptest.NewRequest("PUT", "/api/v1/users/1", strings.NewReader(`{"active": "yes"}`)), &req) {
+		t.Fatal("Expected a malformed body to be rejected")
+	}
+
+	var body ValidationErrorResponse
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.Error != "invalid_request_body" || len(body.Fields) != 1 || body.Fields[0].Field != "active" {
+		t.Errorf("Expected a type error on active, got %+v", body)
+	}
+}
+
+func TestDecodeRequestRejectsUnknownFields(t *testing.T) {
+	var req CreateGroupRequest
+	w := httptest.NewRecorder()
+	if decodeRequest(w, httptest.NewRequest("POST", "/api/v1/groups", strings.NewReader(`{"name": "admins", "nmae": "x"}`)), &req) {
+		t.Fatal("Expected an unknown field to be rejected")
+	}
+
+	var body ValidationErrorResponse
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != 400 || len(body.Fields) != 1 || body.Fields[0].Field != "nmae" || body.Fields[0].Rule != "unknown" {
+		t.Errorf("Expected an unknown field error on nmae, got %d %+v", w.Code, body)
+	}
+}
+
+func TestDecodeRequestRejectsTrailingData(t *testing.T) {
+	var req CreateGroupRequest
+	w := httptest.NewRecorder()
+	if decodeRequest(w, httptest.NewRequest("POST", "/api/v1/groups", strings.NewReader(`{"name": "admins"} {"name": "users"}`)), &req) {
+		t.Fatal("Expected a second JSON value to be rejected")
+	}
+	if w.Code != 400 {
+		t.Errorf("Expected 400, got %d", w.Code)
+	}
+}
+
+func TestDecodeRequestRejectsOversizedBodies(t *testing.T) {
+	body := `{"name": "admins", "members": ["` + strings.Repeat("a", 2048) + `"]}`
+	handler := middleware.LimitBody(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req CreateGroupRequest
+		decodeRequest(w, r, &req)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/groups", strings.NewReader(body)))
+
+	var resp ValidationErrorResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusRequestEntityTooLarge || resp.Error != "request_too_large" {
+		t.Errorf("Expected 413 request_too_large, got %d %q", w.Code, resp.Error)
+	}
+
+	// Without a route limit the default applies
+	w = httptest.NewRecorder()
+	var req CreateGroupRequest
+	if !decodeRequest(w, httptest.NewRequest("POST", "/api/v1/groups", strings.NewReader(body)), &req) {
+		t.Errorf("Expected a body under the default limit to be accepted, got %d", w.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"oauth2-openid-server/services"
+)
+
+// WebFingerIssuerRel is the link relation of an OpenID Connect issuer (OpenID Connect Discovery 1.0
+// section 2)
+const WebFingerIssuerRel = "http://openid.net/specs/connect/1.0/issuer"
+
+// WebFingerHandler lets clients discover the issuer of a user's tenant from the user's email
+// address (RFC 7033)
+type WebFingerHandler struct {
+	tenantService *services.TenantService
+}
+
+func NewWebFingerHandler(tenantService *services.TenantService) *WebFingerHandler {
+	return &WebFingerHandler{
+		tenantService: tenantService,
+	}
+}
+
+// WebFingerResponse is a JSON Resource Descriptor
+type WebFingerResponse struct {
+	Subject string          `json:"subject"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+// WebFingerLink is a link of a JSON Resource Descriptor
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
+}
+
+// WebFinger resolves ?resource=acct:user@domain to the issuer of the tenant whose domain is the
+// email domain. Only the domain is looked at, so the response doesn't reveal whether the user
+// exists. ?rel= limits the links to the given relations.
+func (h *WebFingerHandler) WebFinger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// WebFinger responses are public and must be readable from any origin (RFC 7033 section 5)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := r.URL.Query()
+	subject, domain, ok := webFingerAccount(query.Get("resource"))
+	if !ok {
+		http.Error(w, "resource must be an acct: URI or an email address", http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := h.tenantService.GetTenantByDomain(domain)
+	if err != nil {
+		http.Error(w, "No issuer for this resource", http.StatusNotFound)
+		return
+	}
+
+	response := WebFingerResponse{Subject: subject, Links: []WebFingerLink{}}
+	if rels := query["rel"]; len(rels) == 0 || containsValue(rels, WebFingerIssuerRel) {
+		response.Links = append(response.Links, WebFingerLink{
+			Rel:  WebFingerIssuerRel,
+			Href: requestBaseURL(r) + "/tenant/" + tenant.ID.Hex(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/jrd+json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// webFingerAccount parses an acct: resource, or an email address as OpenID Connect Discovery
+// normalizes it, into the acct: subject and the lowercased email domain
+func webFingerAccount(resource string) (string, string, bool) {
+	account := strings.TrimPrefix(resource, "acct:")
+	local, domain, found := strings.Cut(account, "@")
+	if !found || local == "" || domain == "" || strings.ContainsAny(account, "/?#: ") || strings.Contains(domain, "@") {
+		return "", "", false
+	}
+	return "acct:" + account, strings.ToLower(domain), true
+}
+
+// requestBaseURL returns the scheme and host the request was made to
+func requestBaseURL(r *http.Request) string {
+	return services.RequestBaseURL(r)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWebFingerAccount(t *testing.T) {
+	tests := []struct {
+		resource, subject, domain string
+		ok                        bool
+	}{
+		{"acct:jane@Acme.com", "acct:jane@Acme.com", "acme.com", true},
+		{"jane@acme.com", "acct:jane@acme.com", "acme.com", true},
+		{"https://acme.com/jane", "", "", false},
+		{"acct:jane", "", "", false},
+		{"acct:@acme.com", "", "", false},
+		{"acct:a@b@acme.com", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, tt := range tests {
+		subject, domain, ok := webFingerAccount(tt.resource)
+		if subject != tt.subject || domain != tt.domain || ok != tt.ok {
+			t.Errorf("webFingerAccount(%q) = %q, %q, %v; want %q, %q, %v", tt.resource, subject, domain, ok, tt.subject, tt.domain, tt.ok)
+		}
+	}
+}
+
+// TestWebFinger resolves a user's email domain to the issuer of the tenant with that domain
+func TestWebFinger(t *testing.T) {
+	db := dbtest.New(t)
+
+	tenantID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "tenants", &models.Tenant{ID: tenantID, Name: "Acme", Domain: "acme.com", Active: true, CreatedAt: now, UpdatedAt: now})
+	handler := NewWebFingerHandler(services.NewTenantService(db))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.WebFinger(rec, httptest.NewRequest("GET", "http://auth.example.com/.well-known/webfinger?"+query, nil))
+		return rec
+	}
+
+	rec := get("resource=acct%3Ajane%40acme.com&rel=" + WebFingerIssuerRel)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/jrd+json" || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("WebFinger() = %d %v: %s", rec.Code, rec.Header(), rec.Body.String())
+	}
+	var response WebFingerResponse
+	json.NewDecoder(rec.Body).Decode(&response)
+	want := "http://auth.example.com/tenant/" + tenantID.Hex()
+	if response.Subject != "acct:jane@acme.com" || len(response.Links) != 1 || response.Links[0].Href != want {
+		t.Errorf("WebFinger() = %+v, want the issuer %s", response, want)
+	}
+
+	rec = get("resource=acct%3Ajane%40acme.com&rel=http%3A%2F%2Fwebfinger.net%2Frel%2Favatar")
+	json.NewDecoder(rec.Body).Decode(&response)
+	if rec.Code != http.StatusOK || len(response.Links) != 0 {
+		t.Errorf("WebFinger() with another rel = %d, %d links; want no links", rec.Code, len(response.Links))
+	}
+
+	if rec := get("resource=acct%3Ajane%40other.com"); rec.Code != http.StatusNotFound {
+		t.Errorf("WebFinger() of an unknown domain = %d, want 404", rec.Code)
+	}
+	if rec := get(""); rec.Code != http.StatusBadRequest {
+		t.Errorf("WebFinger() without a resource = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,162 @@
+package i18n
+
+// bundles holds the built-in translations. The English bundle defines the set of known keys;
+// other locales fall back to English for any key they do not translate.
+var bundles = map[string]map[string]string{
+	"en": {
+		"page.authorize.title":                 "OAuth2 Authorization",
+		"page.authorize.heading":               "Authorization Required",
+		"page.authorize.intro":                 "Application is requesting access to your account.",
+		"page.authorize.requested_permissions": "Requested permissions:",
+		"page.authorize.email":                 "Email:",
+		"page.authorize.password":              "Password:",
+		"page.authorize.authorize":             "Authorize",
+		"page.authorize.deny":                  "Deny",
+		"page.authorize.divider":               "or sign in with email",
+		"page.authorize.continue_with":         "Continue with %s",
+		"page.authorize.missing_credentials":   "Please enter email and password",
+		"page.authorize.login_failed":          "Login failed",
+		"page.authorize.share_information":     "Information shared with the application:",
+		"page.authorize.claim.email":           "Email address",
+		"page.authorize.claim.name":            "Name",
+		"page.authorize.claim.groups":          "Group memberships",
+		"page.consent.heading":                 "%s would like to access your account",
+		"page.consent.allow":                   "Allow",
+		"page.consent.privacy_policy":          "Privacy policy",
+		"page.consent.terms_of_service":        "Terms of service",
+		"page.error.title":                     "Something went wrong",
+		"page.error.back":                      "Return to the application",
+		"error.method_not_allowed":             "Method not allowed",
+		"error.invalid_request_body":           "Invalid request body",
+		"error.tenant_required":                "Tenant context required",
+		"error.invalid_credentials":            "Invalid credentials",
+		"error.account_disabled":               "Account disabled",
+		"error.invalid_two_factor":             "Invalid two-factor authentication code",
+		"error.mfa_enrollment_required":        "Two-factor authentication is required for this sign-in; set up an authenticator first",
+		"error.application_access_denied":      "You don't have access to this application. Ask your administrator to assign it to you.",
+		"error.unsupported_response_type":      "Unsupported response type",
+		"error.unsupported_grant_type":         "Unsupported grant type",
+		"error.user_not_found":                 "User not found",
+		"error.invalid_redirect_uri":           "Invalid redirect URI",
+		"error.authorization_required":         "Authorization header required",
+		"error.invalid_token":                  "Invalid or expired token",
+		"error.internal":                       "Internal server error",
+	},
+	"de": {
+		"page.authorize.title":                 "OAuth2-Autorisierung",
+		"page.authorize.heading":               "Autorisierung erforderlich",
+		"page.authorize.intro":                 "Eine Anwendung fordert Zugriff auf Ihr Konto an.",
+		"page.authorize.requested_permissions": "Angeforderte Berechtigungen:",
+		"page.authorize.email":                 "E-Mail:",
+		"page.authorize.password":              "Passwort:",
+		"page.authorize.authorize":             "Autorisieren",
+		"page.authorize.deny":                  "Ablehnen",
+		"page.authorize.divider":               "oder mit E-Mail anmelden",
+		"page.authorize.continue_with":         "Weiter mit %s",
+		"page.authorize.missing_credentials":   "Bitte E-Mail und Passwort eingeben",
+		"page.authorize.login_failed":          "Anmeldung fehlgeschlagen",
+		"page.authorize.share_information":     "Mit der Anwendung geteilte Informationen:",
+		"page.authorize.claim.email":           "E-Mail-Adresse",
+		"page.authorize.claim.name":            "Name",
+		"page.authorize.claim.groups":          "Gruppenmitgliedschaften",
+		"page.consent.heading":                 "%s möchte auf Ihr Konto zugreifen",
+		"page.consent.allow":                   "Erlauben",
+		"page.consent.privacy_policy":          "Datenschutzerklärung",
+		"page.consent.terms_of_service":        "Nutzungsbedingungen",
+		"page.error.title":                     "Etwas ist schiefgelaufen",
+		"page.error.back":                      "Zurück zur Anwendung",
+		"error.method_not_allowed":             "Methode nicht erlaubt",
+		"error.invalid_request_body":           "Ungültiger Anfrageinhalt",
+		"error.tenant_required":                "Mandantenkontext erforderlich",
+		"error.invalid_credentials":            "Ungültige Anmeldedaten",
+		"error.account_disabled":               "Konto deaktiviert",
+		"error.invalid_two_factor":             "Ungültiger Zwei-Faktor-Code",
+		"error.mfa_enrollment_required":        "Für diese Anmeldung ist eine Zwei-Faktor-Authentifizierung erforderlich; richten Sie zuerst einen Authentifikator ein",
+		"error.application_access_denied":      "Sie haben keinen Zugriff auf diese Anwendung. Bitten Sie Ihren Administrator, sie Ihnen zuzuweisen.",
+		"error.unsupported_response_type":      "Nicht unterstützter Antworttyp",
+		"error.unsupported_grant_type":         "Nicht unterstützter Grant-Typ",
+		"error.user_not_found":                 "Benutzer nicht gefunden",
+		"error.invalid_redirect_uri":           "Ungültige Weiterleitungs-URI",
+		"error.authorization_required":         "Authorization-Header erforderlich",
+		"error.invalid_token":                  "Ungültiges oder abgelaufenes Token",
+		"error.internal":                       "Interner Serverfehler",
+	},
+	"fr": {
+		"page.authorize.title":                 "Autorisation OAuth2",
+		"page.authorize.heading":               "Autorisation requise",
+		"page.authorize.intro":                 "Une application demande l'accès à votre compte.",
+		"page.authorize.requested_permissions": "Autorisations demandées :",
+		"page.authorize.email":                 "E-mail :",
+		"page.authorize.password":              "Mot de passe :",
+		"page.authorize.authorize":             "Autoriser",
+		"page.authorize.deny":                  "Refuser",
+		"page.authorize.divider":               "ou se connecter avec un e-mail",
+		"page.authorize.continue_with":         "Continuer avec %s",
+		"page.authorize.missing_credentials":   "Veuillez saisir votre e-mail et votre mot de passe",
+		"page.authorize.login_failed":          "Échec de la connexion",
+		"page.authorize.share_information":     "Informations partagées avec l'application :",
+		"page.authorize.claim.email":           "Adresse e-mail",
+		"page.authorize.claim.name":            "Nom",
+		"page.authorize.claim.groups":          "Appartenance aux groupes",
+		"page.consent.heading":                 "%s souhaite accéder à votre compte",
+		"page.consent.allow":                   "Autoriser",
+		"page.consent.privacy_policy":          "Politique de confidentialité",
+		"page.consent.terms_of_service":        "Conditions d'utilisation",
+		"page.error.title":                     "Une erreur est survenue",
+		"page.error.back":                      "Retourner à l'application",
+		"error.method_not_allowed":             "Méthode non autorisée",
+		"error.invalid_request_body":           "Corps de requête invalide",
+		"error.tenant_required":                "Contexte de locataire requis",
+		"error.invalid_credentials":            "Identifiants invalides",
+		"error.account_disabled":               "Compte désactivé",
+		"error.invalid_two_factor":             "Code d'authentification à deux facteurs invalide",
+		"error.mfa_enrollment_required":        "L'authentification à deux facteurs est requise pour cette connexion ; configurez d'abord un authentificateur",
+		"error.application_access_denied":      "Vous n'avez pas accès à cette application. Demandez à votre administrateur de vous l'attribuer.",
+		"error.unsupported_response_type":      "Type de réponse non pris en charge",
+		"error.unsupported_grant_type":         "Type d'autorisation non pris en charge",
+		"error.user_not_found":                 "Utilisateur introuvable",
+		"error.invalid_redirect_uri":           "URI de redirection invalide",
+		"error.authorization_required":         "En-tête Authorization requis",
+		"error.invalid_token":                  "Jeton invalide ou expiré",
+		"error.internal":                       "Erreur interne du serveur",
+	},
+	"bg": {
+		"page.authorize.title":                 "OAuth2 оторизация",
+		"page.authorize.heading":               "Необходима е оторизация",
+		"page.authorize.intro":                 "Приложение иска достъп до вашия акаунт.",
+		"page.authorize.requested_permissions": "Поискани разрешения:",
+		"page.authorize.email":                 "Имейл:",
+		"page.authorize.password":              "Парола:",
+		"page.authorize.authorize":             "Разреши",
+		"page.authorize.deny":                  "Откажи",
+		"page.authorize.divider":               "или влезте с имейл",
+		"page.authorize.continue_with":         "Продължи с %s",
+		"page.authorize.missing_credentials":   "Моля, въведете имейл и парола",
+		"page.authorize.login_failed":          "Неуспешно влизане",
+		"page.authorize.share_information":     "Информация, споделяна с приложението:",
+		"page.authorize.claim.email":           "Имейл адрес",
+		"page.authorize.claim.name":            "Име",
+		"page.authorize.claim.groups":          "Членство в групи",
+		"page.consent.heading":                 "%s иска достъп до вашия акаунт",
+		"page.consent.allow":                   "Разреши",
+		"page.consent.privacy_policy":          "Политика за поверителност",
+		"page.consent.terms_of_service":        "Условия за ползване",
+		"page.error.title":                     "Нещо се обърка",
+		"page.error.back":                      "Обратно към приложението",
+		"error.method_not_allowed":             "Методът не е разрешен",
+		"error.invalid_request_body":           "Невалидно съдържание на заявката",
+		"error.tenant_required":                "Изисква се контекст на организация",
+		"error.invalid_credentials":            "Невалидни данни за вход",
+		"error.account_disabled":               "Акаунтът е деактивиран",
+		"error.invalid_two_factor":             "Невалиден код за двуфакторна автентикация",
+		"error.mfa_enrollment_required":        "За този вход е нужна двуфакторна автентикация; първо настройте автентикатор",
+		"error.application_access_denied":      "Нямате достъп до това приложение. Помолете администратора си да ви го присвои.",
+		"error.unsupported_response_type":      "Неподдържан тип отговор",
+		"error.unsupported_grant_type":         "Неподдържан тип на разрешение",
+		"error.user_not_found":                 "Потребителят не е намерен",
+		"error.invalid_redirect_uri":           "Невалиден адрес за пренасочване",
+		"error.authorization_required":         "Изисква се заглавка Authorization",
+		"error.invalid_token":                  "Невалиден или изтекъл токен",
+		"error.internal":                       "Вътрешна грешка на сървъра",
+	},
+}
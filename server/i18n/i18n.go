@@ -0,0 +1,159 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used when neither the request nor the tenant selects a supported locale
+const DefaultLocale = "en"
+
+// SupportedLocales returns the locales that have a built-in language bundle
+func SupportedLocales() []string {
+	locales := make([]string, 0, len(bundles))
+	for locale := range bundles {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// IsSupported reports whether a built-in bundle exists for the locale
+func IsSupported(locale string) bool {
+	_, ok := bundles[locale]
+	return ok
+}
+
+// HasKey reports whether the key is a known message key
+func HasKey(key string) bool {
+	_, ok := bundles[DefaultLocale][key]
+	return ok
+}
+
+// Bundle returns a copy of the built-in messages for a locale, falling back to the default locale
+func Bundle(locale string) map[string]string {
+	messages := make(map[string]string, len(bundles[DefaultLocale]))
+	for key, value := range bundles[DefaultLocale] {
+		messages[key] = value
+	}
+	for key, value := range bundles[locale] {
+		messages[key] = value
+	}
+	return messages
+}
+
+// Negotiate picks the locale to use. An explicit ui_locales value (space separated, in order of
+// preference) wins, then the Accept-Language header (by quality), then the tenant default.
+func Negotiate(uiLocales, acceptLanguage, tenantDefault string) string {
+	for _, tag := range strings.Fields(uiLocales) {
+		if locale := match(tag); locale != "" {
+			return locale
+		}
+	}
+
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		if locale := match(tag); locale != "" {
+			return locale
+		}
+	}
+
+	if IsSupported(tenantDefault) {
+		return tenantDefault
+	}
+
+	return DefaultLocale
+}
+
+// match maps a language tag such as "de-AT" to a supported locale
+func match(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if IsSupported(tag) {
+		return tag
+	}
+	if i := strings.IndexAny(tag, "-_"); i > 0 && IsSupported(tag[:i]) {
+		return tag[:i]
+	}
+	return ""
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language header ordered by quality
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag: tag, q: q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// Localizer translates message keys for a single locale, honouring tenant overrides
+type Localizer struct {
+	locale    string
+	overrides map[string]string
+}
+
+// NewLocalizer creates a localizer for the locale with optional tenant overrides
+func NewLocalizer(locale string, overrides map[string]string) *Localizer {
+	if !IsSupported(locale) {
+		locale = DefaultLocale
+	}
+	return &Localizer{
+		locale:    locale,
+		overrides: overrides,
+	}
+}
+
+// Locale returns the locale used by the localizer
+func (l *Localizer) Locale() string {
+	return l.locale
+}
+
+// T translates a message key. Arguments are applied with fmt.Sprintf semantics.
+// Unknown keys are returned unchanged.
+func (l *Localizer) T(key string, args ...interface{}) string {
+	message, ok := l.overrides[key]
+	if !ok {
+		message, ok = bundles[l.locale][key]
+	}
+	if !ok {
+		message, ok = bundles[DefaultLocale][key]
+	}
+	if !ok {
+		return key
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
@@ -0,0 +1,63 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		uiLocales      string
+		acceptLanguage string
+		tenantDefault  string
+		expected       string
+	}{
+		{"ui_locales wins", "fr", "de-DE,de;q=0.9", "bg", "fr"},
+		{"accept-language region fallback", "", "de-AT", "", "de"},
+		{"accept-language quality order", "", "es;q=0.9,bg;q=0.8,fr;q=0.5", "", "bg"},
+		{"tenant default when nothing matches", "", "es-ES,it;q=0.8", "fr", "fr"},
+		{"unsupported tenant default", "", "", "xx", DefaultLocale},
+		{"zero quality ignored", "", "de;q=0", "", DefaultLocale},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Negotiate(tt.uiLocales, tt.acceptLanguage, tt.tenantDefault)
+			if got != tt.expected {
+				t.Errorf("Expected locale %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLocalizerFallbackAndOverrides(t *testing.T) {
+	localizer := NewLocalizer("de", map[string]string{"page.authorize.deny": "Nein danke"})
+
+	if got := localizer.T("page.authorize.authorize"); got != "Autorisieren" {
+		t.Errorf("Expected German translation, got %s", got)
+	}
+
+	if got := localizer.T("page.authorize.deny"); got != "Nein danke" {
+		t.Errorf("Expected tenant override, got %s", got)
+	}
+
+	if got := localizer.T("page.authorize.continue_with", "GitHub"); got != "Weiter mit GitHub" {
+		t.Errorf("Expected formatted message, got %s", got)
+	}
+
+	if got := localizer.T("unknown.key"); got != "unknown.key" {
+		t.Errorf("Expected unknown key to be returned unchanged, got %s", got)
+	}
+
+	if got := NewLocalizer("xx", nil).Locale(); got != DefaultLocale {
+		t.Errorf("Expected unsupported locale to fall back to %s, got %s", DefaultLocale, got)
+	}
+}
+
+func TestBundlesOnlyContainKnownKeys(t *testing.T) {
+	for locale, messages := range bundles {
+		for key := range messages {
+			if !HasKey(key) {
+				t.Errorf("Locale %s defines unknown key %s", locale, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package logging filters the server's diagnostic output by level. The level is set from the
+// LOG_LEVEL setting and can be changed at runtime by reloading the configuration.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Level is the minimum severity of messages that are written
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+var current atomic.Int32
+
+func init() {
+	current.Store(int32(LevelInfo))
+}
+
+// ParseLevel returns the level with the given name (debug, info, warn or error)
+func ParseLevel(name string) (Level, error) {
+	level, ok := levelNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// SetLevel changes the minimum level of written messages
+func SetLevel(name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	current.Store(int32(level))
+	return nil
+}
+
+// Enabled reports whether messages of the level are written
+func Enabled(level Level) bool {
+	return level >= Level(current.Load())
+}
+
+// Debugf writes a debug message, which is dropped unless the level is debug
+func Debugf(format string, args ...interface{}) {
+	if Enabled(LevelDebug) {
+		log.Printf("Debug: "+format, args...)
+	}
+}
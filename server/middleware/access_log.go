@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AccessLogSampling is the fraction (0 to 1) of successful requests written to the access log.
+// Failed requests (status 400 and above) are always written.
+type AccessLogSampling struct {
+	Rate      float64 // requests to the API and pages
+	OAuthRate float64 // requests to the OAuth and OpenID Connect endpoints
+}
+
+// accessLogQueryFields are query parameters whose values are redacted besides secretFields,
+// compared after lowercasing and removing underscores
+var accessLogQueryFields = map[string]bool{
+	"code":         true,
+	"state":        true,
+	"nonce":        true,
+	"codeverifier": true,
+	"idtokenhint":  true,
+	"loginhint":    true,
+	"authreqid":    true,
+	"usercode":     true,
+	"devicecode":   true,
+	"assertion":    true,
+	"key":          true,
+	"signature":    true,
+	"resource":     true, // WebFinger's acct: URI carries the user's email address
+}
+
+// logSafeClientID is what a client_id must look like to be logged; anything else is logged as
+// invalid so attacker-controlled values can't forge log lines
+var logSafeClientID = regexp.MustCompile(`^[A-Za-z0-9._:@-]{1,128}$`)
+
+const accessLogKey contextKey = "access_log"
+
+// accessLogEntry is one line of the access log. Middleware and handlers further down fill in the
+// tenant and client once they resolved them.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route"`
+	Query     string    `json:"query,omitempty"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	TenantID  string    `json:"tenant_id,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+
+	mu sync.Mutex
+}
+
+func (e *accessLogEntry) set(update func(*accessLogEntry)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	update(e)
+}
+
+// AccessLog writes a JSON line per sampled request with the method, route template, status,
+// latency, tenant and client. Bodies are never logged and secrets in the query are redacted, so
+// the log can be shipped to centralized logging. route names the route template of a request.
+func AccessLog(out io.Writer, route func(*http.Request) string, sampling func() AccessLogSampling) func(http.Handler) http.Handler {
+	var writeMu sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			entry := &accessLogEntry{
+				Time:     start.UTC(),
+				Method:   r.Method,
+				Route:    route(r),
+				Query:    redactQuery(r.URL.Query()),
+				ClientID: logSafe(r.URL.Query().Get("client_id")),
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessLogKey, entry)))
+
+			if !sampled(entry.Route, recorder.status, sampling()) {
+				return
+			}
+			var line []byte
+			var err error
+			entry.set(func(e *accessLogEntry) {
+				e.Status = recorder.status
+				e.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+				line, err = json.Marshal(e)
+			})
+			if err != nil {
+				return
+			}
+			writeMu.Lock()
+			out.Write(append(line, '\n'))
+			writeMu.Unlock()
+		})
+	}
+}
+
+// RouteTemplate returns the route template matching a request, e.g. /api/v1/users/{id}, so the log
+// doesn't carry IDs and tokens from the path. Requests no route matches are logged as "unmatched".
+func RouteTemplate(router *mux.Router) func(*http.Request) string {
+	return func(r *http.Request) string {
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				return template
+			}
+		}
+		return "unmatched"
+	}
+}
+
+// SetAccessLogClient records the client of a request in its access log line, for client IDs that
+// only a handler reads (form parameters and HTTP Basic credentials)
+func SetAccessLogClient(r *http.Request, clientID string) {
+	if entry, ok := r.Context().Value(accessLogKey).(*accessLogEntry); ok && clientID != "" {
+		entry.set(func(e *accessLogEntry) { e.ClientID = logSafe(clientID) })
+	}
+}
+
+// setAccessLogTenant records the tenant the TenantMiddleware resolved
+func setAccessLogTenant(r *http.Request, tenantID string) {
+	if entry, ok := r.Context().Value(accessLogKey).(*accessLogEntry); ok {
+		entry.set(func(e *accessLogEntry) { e.TenantID = tenantID })
+	}
+}
+
+// sampled decides whether a request is logged: failures always, successes at the rate of their
+// kind of endpoint
+func sampled(route string, status int, sampling AccessLogSampling) bool {
+	if status >= http.StatusBadRequest {
+		return true
+	}
+	rate := sampling.Rate
+	if isOAuthRoute(route) {
+		rate = sampling.OAuthRate
+	}
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+func isOAuthRoute(route string) bool {
+	return strings.Contains(route, "/oauth/") || strings.Contains(route, "/.well-known/")
+}
+
+// redactQuery returns the query with the values of secret parameters replaced
+func redactQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		normalized := strings.ReplaceAll(strings.ToLower(key), "_", "")
+		for _, value := range query[key] {
+			value = url.QueryEscape(value)
+			if secretFields[normalized] || accessLogQueryFields[normalized] {
+				value = redactedValue
+			}
+			parts = append(parts, url.QueryEscape(key)+"="+value)
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func logSafe(clientID string) string {
+	if clientID == "" || logSafeClientID.MatchString(clientID) {
+		return clientID
+	}
+	return "invalid"
+}
+
+// statusRecorder remembers the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streamed responses
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAccessLogRedactsAndAnnotates(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/tenant/{tenantId}/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		setAccessLogTenant(r, "t1")
+		SetAccessLogClient(r, "billing-app")
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	var out bytes.Buffer
+	sampling := AccessLogSampling{Rate: 0, OAuthRate: 0}
+	handler := AccessLog(&out, RouteTemplate(router), func() AccessLogSampling { return sampling })(router)
+
+	req := httptest.NewRequest("POST", "/tenant/abc123/oauth/token?code=secret-code&state=xyz&access_token=opaque-value&scope=openid", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("access log line %q: %v", out.String(), err)
+	}
+	if entry["route"] != "/tenant/{tenantId}/oauth/token" || entry["status"] != float64(400) {
+		t.Errorf("route/status = %v/%v", entry["route"], entry["status"])
+	}
+	if entry["tenant_id"] != "t1" || entry["client_id"] != "billing-app" {
+		t.Errorf("tenant/client = %v/%v", entry["tenant_id"], entry["client_id"])
+	}
+	query, _ := entry["query"].(string)
+	for _, secret := range []string{"secret-code", "xyz", "opaque-value"} {
+		if strings.Contains(query, secret) {
+			t.Errorf("query %q leaks %q", query, secret)
+		}
+	}
+	if !strings.Contains(query, "scope=openid") {
+		t.Errorf("query %q lost a harmless parameter", query)
+	}
+	if strings.Contains(out.String(), "abc123") {
+		t.Errorf("access log carries the raw path: %s", out.String())
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	if sampled("/api/v1/users", http.StatusOK, AccessLogSampling{Rate: 0, OAuthRate: 1}) {
+		t.Error("successful API request logged at rate 0")
+	}
+	if !sampled("/oauth/token", http.StatusOK, AccessLogSampling{Rate: 0, OAuthRate: 1}) {
+		t.Error("successful OAuth request not logged at OAuth rate 1")
+	}
+	if !sampled("/api/v1/users", http.StatusUnauthorized, AccessLogSampling{}) {
+		t.Error("failed request not logged")
+	}
+
+	if got := logSafe("evil\nclient"); got != "invalid" {
+		t.Errorf("logSafe() of a forged client_id = %q", got)
+	}
+}
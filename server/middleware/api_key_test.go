@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeAPIKeys map[string]*services.Claims
+
+func (k fakeAPIKeys) Authenticate(key string, r *http.Request) (*services.Claims, error) {
+	if claims, ok := k[key]; ok {
+		return claims, nil
+	}
+	return nil, errors.New("invalid API key")
+}
+
+func TestAPIKeyAuthorization(t *testing.T) {
+	acme := primitive.NewObjectID().Hex()
+	principal := models.APIKeyPrincipalPrefix + primitive.NewObjectID().Hex()
+	validator := fakeValidator{"admin": {UserID: "u1", TenantID: acme, Scopes: []string{services.AdminScope}}}
+	keys := fakeAPIKeys{"oak_valid": {UserID: principal, TenantID: acme, Scopes: []string{"read"}}}
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		key        string
+		wantStatus int
+		wantUser   string
+	}{
+		{"no credentials", http.MethodGet, "", "", http.StatusOK, ""},
+		{"valid key", http.MethodGet, "", "oak_valid", http.StatusOK, principal},
+		{"unknown key", http.MethodGet, "", "oak_unknown", http.StatusUnauthorized, ""},
+		{"bearer token wins", http.MethodGet, "admin", "oak_unknown", http.StatusOK, "u1"},
+		{"destructive request", http.MethodDelete, "", "oak_valid", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userID, tenantID string
+			var apiKey bool
+			var route http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if claims := GetClaimsFromRequest(r); claims != nil {
+					userID = claims.UserID
+				}
+				tenantID, apiKey = GetTenantIDFromRequest(r), IsAPIKeyRequest(r)
+			})
+			if tt.method == http.MethodDelete {
+				// API keys can't carry the elevated scope, so they can't use destructive routes
+				route = RequireElevation(route)
+			}
+			handler := Authorization(validator, nil)(APIKeyAuthorization(keys, nil)(route))
+
+			req := httptest.NewRequest(tt.method, "/api/v1/users/1", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.key != "" {
+				req.Header.Set(APIKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus || userID != tt.wantUser {
+				t.Errorf("got %d as %q, want %d as %q", w.Code, userID, tt.wantStatus, tt.wantUser)
+			}
+			if userID != "" && tenantID != acme {
+				t.Errorf("tenant = %q, want the credential's tenant %q", tenantID, acme)
+			}
+			if apiKey != (userID == principal) {
+				t.Errorf("IsAPIKeyRequest() = %v for %q", apiKey, userID)
+			}
+		})
+	}
+}
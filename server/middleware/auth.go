@@ -0,0 +1,377 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+const ClaimsKey contextKey = "claims"
+
+// platformOperatorKey marks requests made with a platform operator token
+const platformOperatorKey contextKey = "platform_operator"
+
+// authRequiredKey marks requests to routes that anonymous callers may not use
+const authRequiredKey contextKey = "auth_required"
+
+// redactedValue replaces secrets in responses to support staff
+const redactedValue = "[redacted]"
+
+// secretFields are JSON keys whose values are withheld from support staff, compared after
+// lowercasing and removing underscores so both snake_case and camelCase keys match
+var secretFields = map[string]bool{
+	"secret":          true,
+	"clientsecret":    true,
+	"password":        true,
+	"passwordhash":    true,
+	"adminpassword":   true,
+	"twofactorsecret": true,
+	"backupcodes":     true,
+	"token":           true,
+	"accesstoken":     true,
+	"refreshtoken":    true,
+	"idtoken":         true,
+	"setuptoken":      true,
+	"csrftoken":       true,
+	"privatekey":      true,
+	"qrcodeurl":       true,
+	"qrcodeimage":     true,
+}
+
+// TokenValidator validates bearer access tokens
+type TokenValidator interface {
+	ValidateAccessToken(tokenString string) (*services.Claims, error)
+}
+
+// DefaultTenantProvider returns the default tenant, whose administrators operate the platform
+type DefaultTenantProvider interface {
+	GetDefaultTenant() (*models.Tenant, error)
+}
+
+// Authorization validates a bearer token if one is sent and stores its claims in the request
+// context. An invalid token is rejected with 401 on routes behind RequireAuthentication and
+// ignored on public ones. The token's tenant becomes the request's tenant: a tenant named by the request that
+// differs from it is rejected, except for platform operators (see bindTenant). Tokens carrying the
+// support scope are read-only: only GET, HEAD and OPTIONS requests are allowed and secrets are
+// redacted from JSON responses. Destructive routes also need an elevated token (see RequireElevation).
+func Authorization(validator TokenValidator, tenants DefaultTenantProvider) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !found || !strings.EqualFold(scheme, "Bearer") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := validator.ValidateAccessToken(strings.TrimSpace(token))
+			if err != nil {
+				if authenticationRequired(r) {
+					writeUnauthorized(w, "invalid_token", "The access token is invalid or expired")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			serveWithClaims(w, r, claims, tenants, next)
+		})
+	}
+}
+
+// RequireAuthentication rejects anonymous requests with 401 unless public reports the request's
+// route as public. It runs before TenantMiddleware, so anonymous callers can't probe tenants, and
+// marks the request for Authorization, which then rejects invalid tokens instead of serving the
+// request anonymously. Only requests carrying a bearer token or an API key get past it.
+func RequireAuthentication(public func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions || public(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scheme, _, found := strings.Cut(r.Header.Get("Authorization"), " ")
+			bearer := found && strings.EqualFold(scheme, "Bearer")
+			if !bearer && strings.TrimSpace(r.Header.Get(APIKeyHeader)) == "" {
+				writeUnauthorized(w, "unauthorized", "This request requires a bearer token or an API key")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authRequiredKey, true)))
+		})
+	}
+}
+
+func authenticationRequired(r *http.Request) bool {
+	required, _ := r.Context().Value(authRequiredKey).(bool)
+	return required
+}
+
+// writeUnauthorized answers with 401 and a bearer challenge (RFC 6750 section 3)
+func writeUnauthorized(w http.ResponseWriter, code, message string) {
+	challenge := "Bearer"
+	if code == "invalid_token" {
+		challenge += ` error="invalid_token"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "message": message})
+}
+
+// serveWithClaims serves a request authenticated as claims: it binds the request to the claims'
+// tenant and enforces support's read-only access
+func serveWithClaims(w http.ResponseWriter, r *http.Request, claims *services.Claims, tenants DefaultTenantProvider, next http.Handler) {
+	r = r.WithContext(context.WithValue(r.Context(), ClaimsKey, claims))
+	if r = bindTenant(w, r, claims, tenants); r == nil {
+		return
+	}
+
+	if !isSupport(claims) {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   "read_only",
+			"message": "Support access is read-only",
+		})
+		return
+	}
+
+	sanitizer := &sanitizingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(sanitizer, r)
+	sanitizer.flush()
+}
+
+// GetClaimsFromRequest returns the claims of the request's bearer token, or nil if it had none
+func GetClaimsFromRequest(r *http.Request) *services.Claims {
+	if claims, ok := r.Context().Value(ClaimsKey).(*services.Claims); ok {
+		return claims
+	}
+	return nil
+}
+
+// RequireScope rejects requests whose bearer token doesn't carry scope: 401 without a valid token,
+// 403 without the scope
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetClaimsFromRequest(r)
+			if claims == nil {
+				http.Error(w, "Authorization required", http.StatusUnauthorized)
+				return
+			}
+			if !hasScope(claims, scope) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error":   "insufficient_scope",
+					"message": "This request requires the " + scope + " scope",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePlatformOperator rejects requests not made by the platform operator: 401 without a
+// valid token, 403 otherwise
+func RequirePlatformOperator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetClaimsFromRequest(r) == nil {
+			http.Error(w, "Authorization required", http.StatusUnauthorized)
+			return
+		}
+		if !IsPlatformOperator(r) {
+			writeForbidden(w, "platform_operator_required", "This request is reserved to the platform operator")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireTenantAdmin guards the /tenants/{id} endpoints: only admins (and read-only support staff)
+// of tenant {id} and the platform operator may use them
+func RequireTenantAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := GetClaimsFromRequest(r)
+		if claims == nil {
+			http.Error(w, "Authorization required", http.StatusUnauthorized)
+			return
+		}
+		if !IsPlatformOperator(r) {
+			if !hasScope(claims, services.AdminScope) && !isSupport(claims) {
+				writeForbidden(w, "insufficient_scope", "This request requires the "+services.AdminScope+" scope")
+				return
+			}
+			if mux.Vars(r)["id"] != GetTenantIDFromRequest(r) {
+				writeForbidden(w, "tenant_forbidden", "Tenant admins can only manage their own tenant")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsPlatformOperator reports whether the request was made with a token of the default tenant
+// carrying the platform operator scope
+func IsPlatformOperator(r *http.Request) bool {
+	operator, _ := r.Context().Value(platformOperatorKey).(bool)
+	return operator
+}
+
+// bindTenant makes the token's tenant the request's tenant, so callers can't act in another tenant
+// by naming it (path, query parameter, X-Tenant-ID header or host). Platform operators may address
+// any tenant. It writes a 403 and returns nil when a tenant admin names another tenant.
+func bindTenant(w http.ResponseWriter, r *http.Request, claims *services.Claims, tenants DefaultTenantProvider) *http.Request {
+	ctx := r.Context()
+	operator := false
+	if hasScope(claims, services.PlatformOperatorScope) && tenants != nil {
+		defaultTenant, err := tenants.GetDefaultTenant()
+		operator = err == nil && defaultTenant.ID.Hex() == claims.TenantID
+	}
+	if operator {
+		ctx = context.WithValue(ctx, platformOperatorKey, true)
+	}
+
+	requested := GetExplicitTenantID(r)
+	if claims.TenantID == "" || (operator && requested != "") {
+		return r.WithContext(ctx)
+	}
+	if requested != "" && requested != claims.TenantID {
+		writeForbidden(w, "tenant_mismatch", "The token was issued for another tenant")
+		return nil
+	}
+
+	ctx = context.WithValue(ctx, TenantIDKey, claims.TenantID)
+	ctx = context.WithValue(ctx, tenantExplicitKey, true)
+	r = r.WithContext(ctx)
+	setAccessLogTenant(r, claims.TenantID)
+	return r
+}
+
+func writeForbidden(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "message": message})
+}
+
+// IsSupportRequest reports whether the request was made with a support token
+func IsSupportRequest(r *http.Request) bool {
+	return isSupport(GetClaimsFromRequest(r))
+}
+
+func isSupport(claims *services.Claims) bool {
+	return hasScope(claims, services.SupportScope)
+}
+
+func hasScope(claims *services.Claims, want string) bool {
+	if claims == nil {
+		return false
+	}
+	for _, scope := range claims.Scopes {
+		if scope == want {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireElevation guards a destructive endpoint: deleting a resource, rotating secrets or keys,
+// merging, deprovisioning or mass-changing users, or revoking tokens in bulk. Only elevated tokens
+// (see POST /api/v1/auth/elevate) may use it; routes mark themselves with it when registered.
+func RequireElevation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := GetClaimsFromRequest(r)
+		if claims == nil {
+			writeUnauthorized(w, "unauthorized", "This request requires an elevated token")
+			return
+		}
+		if !hasScope(claims, services.ElevatedScope) {
+			writeForbidden(w, "elevation_required", "This request requires an elevated token from POST /api/v1/auth/elevate")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sanitizingResponseWriter buffers a response so secrets can be redacted before it is sent
+type sanitizingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *sanitizingResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *sanitizingResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *sanitizingResponseWriter) flush() {
+	body := w.body.Bytes()
+	if strings.Contains(w.Header().Get("Content-Type"), "json") {
+		body = RedactSecrets(body)
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+// RedactSecrets replaces the values of secret fields in a JSON document. Documents that cannot
+// be parsed are withheld entirely rather than risk leaking a secret.
+func RedactSecrets(body []byte) []byte {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return body
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return []byte(`{"error":"response_withheld","message":"Response could not be sanitized"}`)
+	}
+
+	redacted, err := json.Marshal(redactValue(document))
+	if err != nil {
+		return []byte(`{"error":"response_withheld","message":"Response could not be sanitized"}`)
+	}
+	return append(redacted, '\n')
+}
+
+func redactValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, field := range typed {
+			if secretFields[strings.ReplaceAll(strings.ToLower(key), "_", "")] {
+				if field != nil && field != "" {
+					typed[key] = redactedValue
+				}
+				continue
+			}
+			typed[key] = redactValue(field)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = redactValue(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,332 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeValidator map[string]*services.Claims
+
+func (v fakeValidator) ValidateAccessToken(token string) (*services.Claims, error) {
+	if claims, ok := v[token]; ok {
+		return claims, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func newAuthorizationTestHandler(called *bool) http.Handler {
+	validator := fakeValidator{
+		"support":  {UserID: "u1", Scopes: []string{"read", services.SupportScope}},
+		"admin":    {UserID: "u2", Scopes: []string{"admin"}},
+		"elevated": {UserID: "u2", Scopes: []string{"admin", services.ElevatedScope}},
+	}
+	return Authorization(validator, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*called = true
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":          "Portal",
+			"client_secret": "s3cr3t",
+			"tokens":        map[string]interface{}{"access_token": "abc", "expires_in": 3600},
+			"providers":     []interface{}{map[string]interface{}{"clientSecret": "xyz"}},
+		})
+	}))
+}
+
+func TestAuthorizationRejectsSupportMutations(t *testing.T) {
+	called := false
+	handler := newAuthorizationTestHandler(&called)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/1", nil)
+	req.Header.Set("Authorization", "Bearer support")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a support mutation, got %d", w.Code)
+	}
+	if called {
+		t.Error("Expected the handler not to run")
+	}
+}
+
+func TestAuthorizationRedactsSecretsForSupport(t *testing.T) {
+	called := false
+	handler := newAuthorizationTestHandler(&called)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/clients/1", nil)
+	req.Header.Set("Authorization", "Bearer support")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected the handler's status to be kept, got %d", w.Code)
+	}
+
+	var body struct {
+		Name         string `json:"name"`
+		ClientSecret string `json:"client_secret"`
+		Tokens       struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"`
+		} `json:"tokens"`
+		Providers []struct {
+			ClientSecret string `json:"clientSecret"`
+		} `json:"providers"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected JSON, got %q", w.Body.String())
+	}
+	if body.Name != "Portal" || body.Tokens.ExpiresIn != 3600 {
+		t.Errorf("Expected non-secret fields to be kept, got %+v", body)
+	}
+	if body.ClientSecret != redactedValue || body.Tokens.AccessToken != redactedValue || body.Providers[0].ClientSecret != redactedValue {
+		t.Errorf("Expected secrets to be redacted, got %s", w.Body.String())
+	}
+}
+
+func TestAuthorizationLeavesOtherTokensUntouched(t *testing.T) {
+	called := false
+	handler := newAuthorizationTestHandler(&called)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/clients", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if !called || w.Code != http.StatusCreated {
+		t.Fatalf("Expected admin requests to pass, got %d", w.Code)
+	}
+
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body["client_secret"] != "s3cr3t" {
+		t.Errorf("Expected admin responses not to be redacted, got %v", body["client_secret"])
+	}
+}
+
+func TestRequireElevation(t *testing.T) {
+	validator := fakeValidator{
+		"admin":    {UserID: "u2", Scopes: []string{"admin"}},
+		"elevated": {UserID: "u2", Scopes: []string{"admin", services.ElevatedScope}},
+	}
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"admin", http.StatusForbidden},
+		{"elevated", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		handler := Authorization(validator, nil)(RequireElevation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		})))
+
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/1", nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("token %q: expected %d, got %d", tt.token, tt.want, w.Code)
+		}
+		if tt.want == http.StatusForbidden && !strings.Contains(w.Body.String(), "elevation_required") {
+			t.Errorf("token %q: expected an elevation_required error, got %s", tt.token, w.Body.String())
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	validator := fakeValidator{
+		"support": {UserID: "u1", Scopes: []string{"read", services.SupportScope}},
+		"admin":   {UserID: "u2", Scopes: []string{services.AdminScope}},
+	}
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"forged", http.StatusUnauthorized},
+		{"support", http.StatusForbidden},
+		{"admin", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		handler := Authorization(validator, nil)(RequireScope(services.AdminScope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		})))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/debug/token", nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("token %q: expected %d, got %d", tt.token, tt.want, w.Code)
+		}
+	}
+}
+
+type fakeTenants struct {
+	defaultID primitive.ObjectID
+}
+
+func (f fakeTenants) GetDefaultTenant() (*models.Tenant, error) {
+	return &models.Tenant{ID: f.defaultID, IsDefault: true}, nil
+}
+
+func TestAuthorizationBindsTokenTenant(t *testing.T) {
+	platform := primitive.NewObjectID()
+	acme := primitive.NewObjectID().Hex()
+	other := primitive.NewObjectID().Hex()
+	validator := fakeValidator{
+		"acme-admin":    {UserID: "u1", TenantID: acme, Scopes: []string{services.AdminScope}},
+		"acme-operator": {UserID: "u2", TenantID: acme, Scopes: []string{services.AdminScope, services.PlatformOperatorScope}},
+		"operator":      {UserID: "u3", TenantID: platform.Hex(), Scopes: []string{services.PlatformOperatorScope}},
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		requested  string // X-Tenant-ID
+		wantStatus int
+		wantTenant string
+		operator   bool
+	}{
+		{"tenant from token", "acme-admin", "", http.StatusOK, acme, false},
+		{"same tenant named", "acme-admin", acme, http.StatusOK, acme, false},
+		{"other tenant named", "acme-admin", other, http.StatusForbidden, "", false},
+		{"operator scope outside the default tenant", "acme-operator", other, http.StatusForbidden, "", false},
+		{"operator in own tenant", "operator", "", http.StatusOK, platform.Hex(), true},
+		{"operator addressing a tenant", "operator", other, http.StatusOK, other, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tenantID string
+			var operator bool
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			ctx := req.Context()
+			if tt.requested != "" {
+				ctx = context.WithValue(ctx, TenantIDKey, tt.requested)
+				ctx = context.WithValue(ctx, tenantExplicitKey, true)
+			}
+			w := httptest.NewRecorder()
+			Authorization(validator, fakeTenants{platform})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenantID, operator = GetTenantIDFromRequest(r), IsPlatformOperator(r)
+			})).ServeHTTP(w, req.WithContext(ctx))
+
+			if w.Code != tt.wantStatus || tenantID != tt.wantTenant || operator != tt.operator {
+				t.Errorf("got %d, tenant %q, operator %v; want %d, tenant %q, operator %v",
+					w.Code, tenantID, operator, tt.wantStatus, tt.wantTenant, tt.operator)
+			}
+		})
+	}
+}
+
+func TestRequireTenantAdmin(t *testing.T) {
+	platform := primitive.NewObjectID()
+	acme := primitive.NewObjectID().Hex()
+	validator := fakeValidator{
+		"acme-admin":   {UserID: "u1", TenantID: acme, Scopes: []string{services.AdminScope}},
+		"acme-user":    {UserID: "u2", TenantID: acme, Scopes: []string{"read"}},
+		"acme-support": {UserID: "u3", TenantID: acme, Scopes: []string{services.SupportScope}},
+		"operator":     {UserID: "u4", TenantID: platform.Hex(), Scopes: []string{services.PlatformOperatorScope}},
+	}
+
+	router := mux.NewRouter()
+	router.Use(Authorization(validator, fakeTenants{platform}))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Handle("/api/v1/tenants", RequirePlatformOperator(ok))
+	router.Handle("/api/v1/tenants/{id}", RequireTenantAdmin(ok))
+
+	tests := []struct {
+		token string
+		path  string
+		want  int
+	}{
+		{"", "/api/v1/tenants", http.StatusUnauthorized},
+		{"acme-admin", "/api/v1/tenants", http.StatusForbidden},
+		{"operator", "/api/v1/tenants", http.StatusOK},
+		{"", "/api/v1/tenants/" + acme, http.StatusUnauthorized},
+		{"acme-admin", "/api/v1/tenants/" + acme, http.StatusOK},
+		{"acme-support", "/api/v1/tenants/" + acme, http.StatusOK},
+		{"acme-user", "/api/v1/tenants/" + acme, http.StatusForbidden},
+		{"acme-admin", "/api/v1/tenants/" + platform.Hex(), http.StatusForbidden},
+		{"operator", "/api/v1/tenants/" + acme, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("token %q on %s: expected %d, got %d", tt.token, tt.path, tt.want, w.Code)
+		}
+	}
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	validator := fakeValidator{"admin": {UserID: "u1", Scopes: []string{services.AdminScope}}}
+	public := func(r *http.Request) bool { return r.URL.Path == "/api/v1/register" }
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"anonymous", "/api/v1/users", "", http.StatusUnauthorized},
+		{"basic credentials", "/api/v1/users", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"invalid token", "/api/v1/users", "Bearer forged", http.StatusUnauthorized},
+		{"valid token", "/api/v1/users", "Bearer admin", http.StatusOK},
+		{"anonymous on a public route", "/api/v1/register", "", http.StatusOK},
+		{"invalid token on a public route", "/api/v1/register", "Bearer forged", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireAuthentication(public)(Authorization(validator, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("expected a bearer challenge, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultMaxBodyBytes caps the JSON request bodies of routes without a limit of their own
+const DefaultMaxBodyBytes int64 = 64 << 10
+
+// bodyLimitKey holds the body limit set by LimitBody
+const bodyLimitKey contextKey = "body_limit"
+
+// LimitBody caps the request bodies of the wrapped routes at maxBytes, raising or lowering
+// DefaultMaxBodyBytes. Reading past the limit fails with *http.MaxBytesError.
+func LimitBody(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey, maxBytes)))
+		})
+	}
+}
+
+// GetBodyLimit returns the body limit of the request's route
+func GetBodyLimit(r *http.Request) int64 {
+	if limit, ok := r.Context().Value(bodyLimitKey).(int64); ok {
+		return limit
+	}
+	return DefaultMaxBodyBytes
+}
@@ -4,60 +4,52 @@ import (
 	"net/http"
 	"slices"
 	"strings"
+
+	"oauth2-openid-server/logging"
 )
 
-func CorsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		
-		// Debug CORS requests
-		if r.Method == "OPTIONS" || origin != "" {
-			println("CORS request:", r.Method, "from origin:", origin, "to:", r.URL.Path)
-		}
-		
-		// When credentials are allowed, we cannot use wildcard
-		// Always use the specific origin when available, or allow common development origins
-		if origin != "" {
-			// Validate against allowed origins for security
-			allowedOrigins := []string{
-				"https://authy.imsc.eu",
-				"https://oauth2.imsc.eu", 
-				"http://localhost:5173",
-				"http://localhost:3000",
-				"http://localhost:8080",
+// CORS allows browsers to call the API from the allowed origins. The origins are read on every
+// request so a configuration reload takes effect immediately.
+func CORS(allowedOrigins func() []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			origins := allowedOrigins()
+
+			if r.Method == "OPTIONS" || origin != "" {
+				logging.Debugf("CORS request: %s from origin %q to %s", r.Method, origin, r.URL.Path)
 			}
-			
-			if slices.Contains(allowedOrigins, origin) {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-				println("CORS: Setting allowed origin:", origin)
-			} else {
-				// For development, allow any localhost origin
-				if strings.Contains(origin, "localhost") || strings.Contains(origin, "127.0.0.1") {
+
+			// When credentials are allowed, we cannot use wildcard
+			// Always use the specific origin when available, or allow common development origins
+			if origin != "" {
+				if slices.Contains(origins, origin) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				} else if strings.Contains(origin, "localhost") || strings.Contains(origin, "127.0.0.1") {
+					// For development, allow any localhost origin
 					w.Header().Set("Access-Control-Allow-Origin", origin)
-					println("CORS: Setting localhost origin:", origin)
-				} else {
+				} else if len(origins) > 0 {
 					// Default to the main frontend URL for unknown origins
-					w.Header().Set("Access-Control-Allow-Origin", "https://authy.imsc.eu")
-					println("CORS: Setting default origin for unrecognized origin:", origin)
+					w.Header().Set("Access-Control-Allow-Origin", origins[0])
+					logging.Debugf("CORS: origin %q is not allowed", origin)
 				}
+			} else if len(origins) > 0 {
+				// No origin header - default to main frontend URL (never use wildcard with credentials)
+				w.Header().Set("Access-Control-Allow-Origin", origins[0])
 			}
-		} else {
-			// No origin header - default to main frontend URL (never use wildcard with credentials)
-			w.Header().Set("Access-Control-Allow-Origin", "https://authy.imsc.eu")
-			println("CORS: No origin header, setting default:", "https://authy.imsc.eu")
-		}
-		
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Tenant-ID, X-Requested-With, Accept, Origin, Cache-Control")
-		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type, Authorization, X-Tenant-ID")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Max-Age", "86400")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
\ No newline at end of file
+
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Tenant-ID, X-Requested-With, Accept, Origin, Cache-Control, X-CSRF-Token, Idempotency-Key, X-Captcha-Token, X-Bot-Bypass-Key")
+			w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type, Authorization, X-Tenant-ID, API-Version, Deprecation, Sunset, Link, Idempotent-Replayed")
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
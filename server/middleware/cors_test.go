@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSFollowsAllowedOrigins(t *testing.T) {
+	origins := []string{"https://app.example.com"}
+	handler := CORS(func() []string { return origins })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	allowedOrigin := func(origin string) string {
+		req := httptest.NewRequest("GET", "/api/v1/users", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	if got := allowedOrigin("https://admin.example.com"); got != "https://app.example.com" {
+		t.Errorf("unknown origin got %q, want the first allowed origin", got)
+	}
+
+	// A reload changes the origins for the next request
+	origins = []string{"https://app.example.com", "https://admin.example.com"}
+	if got := allowedOrigin("https://admin.example.com"); got != "https://admin.example.com" {
+		t.Errorf("reloaded origin got %q", got)
+	}
+	if got := allowedOrigin("http://localhost:5173"); got != "http://localhost:5173" {
+		t.Errorf("localhost origin got %q", got)
+	}
+}
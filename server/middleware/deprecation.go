@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// Deprecated marks the wrapped routes as deprecated: responses carry Deprecation (RFC 9745),
+// Sunset (RFC 8594) and a Link to the successor route, and each request is counted per route
+// template. usage may be nil to skip counting.
+func Deprecated(deprecation services.RouteDeprecation, usage *services.LegacyUsageService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := GetTenantIDFromRequest(r)
+
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecation.Since.Unix()))
+			if !deprecation.Sunset.IsZero() {
+				w.Header().Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, deprecation.SuccessorFor(r.URL.Path, tenantID)))
+
+			if usage != nil {
+				route := r.URL.Path
+				if current := mux.CurrentRoute(r); current != nil {
+					if template, err := current.GetPathTemplate(); err == nil {
+						route = template
+					}
+				}
+				go usage.Record(route, tenantID)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIVersion adds an API-Version header naming the API version that served the request
+func APIVersion(version string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("API-Version", version)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// GroupManagers tells which groups and users a group manager administers
+type GroupManagers interface {
+	// ManagesGroup reports whether the user is a manager of the group
+	ManagesGroup(tenantID, groupID, userID string) bool
+	// ManagesUser reports whether the manager manages a group the user is a member of
+	ManagesUser(tenantID, managerID, userID string) bool
+}
+
+// RequireUserAdministration guards the endpoints changing groups: only tokens with the admin or
+// user_management scope may use them
+func RequireUserAdministration(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := GetClaimsFromRequest(r)
+		if claims == nil {
+			http.Error(w, "Authorization required", http.StatusUnauthorized)
+			return
+		}
+		if !isUserAdministrator(claims) {
+			writeForbidden(w, "insufficient_scope", "This request requires the "+services.AdminScope+" or "+services.UserManagementScope+" scope")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireGroupManager guards the member endpoints of group {id}: user administrators and the
+// managers of the group may use them, so department leads can manage their members without
+// tenant-wide rights
+func RequireGroupManager(managers GroupManagers) func(http.Handler) http.Handler {
+	return requireManager(func(r *http.Request, claims *services.Claims) bool {
+		return managers.ManagesGroup(GetTenantIDFromRequest(r), mux.Vars(r)["id"], claims.UserID)
+	}, "Only user administrators and the managers of the group may change its members")
+}
+
+// RequireUserManager guards endpoints acting on user {id}: user administrators and the managers of
+// a group the user is a member of may use them
+func RequireUserManager(managers GroupManagers) func(http.Handler) http.Handler {
+	return requireManager(func(r *http.Request, claims *services.Claims) bool {
+		return managers.ManagesUser(GetTenantIDFromRequest(r), claims.UserID, mux.Vars(r)["id"])
+	}, "Only user administrators and the managers of the user's groups may do this")
+}
+
+// RequireAdministratorElevation guards a destructive member endpoint: user administrators need an
+// elevated token, while group managers, who can't elevate, act on the members of their groups
+// without one. Use it behind RequireGroupManager or RequireUserManager.
+func RequireAdministratorElevation(next http.Handler) http.Handler {
+	elevated := RequireElevation(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsUserAdministrator(r) {
+			elevated.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsUserAdministrator reports whether the request was made with a token carrying the admin or
+// user_management scope
+func IsUserAdministrator(r *http.Request) bool {
+	claims := GetClaimsFromRequest(r)
+	return claims != nil && isUserAdministrator(claims)
+}
+
+func requireManager(manages func(*http.Request, *services.Claims) bool, message string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetClaimsFromRequest(r)
+			if claims == nil {
+				http.Error(w, "Authorization required", http.StatusUnauthorized)
+				return
+			}
+			if !isUserAdministrator(claims) && (claims.UserID == "" || !manages(r, claims)) {
+				writeForbidden(w, "group_manager_required", message)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isUserAdministrator(claims *services.Claims) bool {
+	return hasScope(claims, services.AdminScope) || hasScope(claims, services.UserManagementScope)
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeManagers maps a group or user ID to the users managing it
+type fakeManagers map[string][]string
+
+func (f fakeManagers) ManagesGroup(tenantID, groupID, userID string) bool {
+	return tenantID == "acme" && contains(f[groupID], userID)
+}
+
+func (f fakeManagers) ManagesUser(tenantID, managerID, userID string) bool {
+	return tenantID == "acme" && contains(f[userID], managerID)
+}
+
+func contains(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRequireGroupManager(t *testing.T) {
+	validator := fakeValidator{
+		"admin":      {UserID: "u1", TenantID: "acme", Scopes: []string{services.AdminScope}},
+		"user-admin": {UserID: "u2", TenantID: "acme", Scopes: []string{services.UserManagementScope}},
+		"lead":       {UserID: "u3", TenantID: "acme", Scopes: []string{"read"}},
+		"member":     {UserID: "u4", TenantID: "acme", Scopes: []string{"read"}},
+		"other-lead": {UserID: "u3", TenantID: "other", Scopes: []string{"read"}},
+	}
+	managers := fakeManagers{"sales": {"u3"}, "u5": {"u3"}}
+
+	tests := []struct {
+		name  string
+		guard func(http.Handler) http.Handler
+		id    string
+		token string
+		want  int
+	}{
+		{"no token", RequireGroupManager(managers), "sales", "", http.StatusUnauthorized},
+		{"admin", RequireGroupManager(managers), "finance", "admin", http.StatusCreated},
+		{"user administrator", RequireGroupManager(managers), "finance", "user-admin", http.StatusCreated},
+		{"manager of the group", RequireGroupManager(managers), "sales", "lead", http.StatusCreated},
+		{"manager of another group", RequireGroupManager(managers), "finance", "lead", http.StatusForbidden},
+		{"member", RequireGroupManager(managers), "sales", "member", http.StatusForbidden},
+		{"manager in another tenant", RequireGroupManager(managers), "sales", "other-lead", http.StatusForbidden},
+		{"admin resets a password", RequireUserManager(managers), "u6", "admin", http.StatusCreated},
+		{"manager resets a member's password", RequireUserManager(managers), "u5", "lead", http.StatusCreated},
+		{"manager resets another user's password", RequireUserManager(managers), "u6", "lead", http.StatusForbidden},
+		{"member resets a password", RequireUserManager(managers), "u5", "member", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Authorization(validator, nil)(tt.guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			})))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/groups/"+tt.id+"/members", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+			req = req.WithContext(context.WithValue(req.Context(), TenantIDKey, "acme"))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireAdministratorElevation(t *testing.T) {
+	validator := fakeValidator{
+		"admin":          {UserID: "u1", Scopes: []string{services.AdminScope}},
+		"elevated-admin": {UserID: "u1", Scopes: []string{services.AdminScope, services.ElevatedScope}},
+		"lead":           {UserID: "u3", Scopes: []string{"read"}},
+	}
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"admin", http.StatusForbidden},
+		{"elevated-admin", http.StatusCreated},
+		{"lead", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		handler := Authorization(validator, nil)(RequireAdministratorElevation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		})))
+
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/groups/sales/members/u5", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("token %q: expected %d, got %d", tt.token, tt.want, w.Code)
+		}
+	}
+}
+
+func TestRequireUserAdministration(t *testing.T) {
+	validator := fakeValidator{
+		"admin":      {UserID: "u1", Scopes: []string{services.AdminScope}},
+		"user-admin": {UserID: "u2", Scopes: []string{services.UserManagementScope}},
+		"lead":       {UserID: "u3", Scopes: []string{"read"}},
+	}
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"admin", http.StatusCreated},
+		{"user-admin", http.StatusCreated},
+		{"lead", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		handler := Authorization(validator, nil)(RequireUserAdministration(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		})))
+
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/groups/sales", nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("token %q: expected %d, got %d", tt.token, tt.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"oauth2-openid-server/services"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// idempotentHeaders are the response headers replayed with a stored response
+var idempotentHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// Idempotent lets clients retry the wrapped create endpoint safely: a request with an
+// Idempotency-Key header that was already answered gets the original response again, marked with
+// Idempotent-Replayed: true, instead of being processed twice. Keys are scoped to the tenant and
+// caller. Reusing a key for a different request fails with 422, retrying while the first request
+// is still running with 409. Server errors (5xx) aren't stored, so such requests can be retried.
+func Idempotent(idempotency *services.IdempotencyService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" || idempotency == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				writeIdempotencyError(w, http.StatusBadRequest, "invalid_request", "The Idempotency-Key header must be at most 255 characters")
+				return
+			}
+
+			// Bodies over the route's limit are left to the handler to refuse
+			body, err := io.ReadAll(io.LimitReader(r.Body, GetBodyLimit(r)+1))
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			scope := GetTenantIDFromRequest(r)
+			if claims := GetClaimsFromRequest(r); claims != nil {
+				scope += "/" + claims.UserID + "/" + claims.ClientID
+			}
+			fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+
+			stored, err := idempotency.Begin(scope, key, hex.EncodeToString(fingerprint[:]))
+			switch {
+			case errors.Is(err, services.ErrIdempotencyKeyReused):
+				writeIdempotencyError(w, http.StatusUnprocessableEntity, "idempotency_key_reused", err.Error())
+				return
+			case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+				w.Header().Set("Retry-After", "1")
+				writeIdempotencyError(w, http.StatusConflict, "idempotency_key_in_progress", err.Error())
+				return
+			case err != nil:
+				http.Error(w, "Failed to check the idempotency key", http.StatusInternalServerError)
+				return
+			case stored != nil:
+				for name, value := range stored.Header {
+					w.Header().Set(name, value)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+				return
+			}
+
+			capture := &responseCapture{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(capture, r)
+
+			if capture.status >= http.StatusInternalServerError {
+				if err := idempotency.Abandon(scope, key); err != nil {
+					log.Printf("Warning: Failed to release idempotency key: %v", err)
+				}
+				return
+			}
+			response := &services.IdempotentResponse{Status: capture.status, Header: map[string]string{}, Body: capture.body.Bytes()}
+			for _, name := range idempotentHeaders {
+				if value := w.Header().Get(name); value != "" {
+					response.Header[name] = value
+				}
+			}
+			if err := idempotency.Complete(scope, key, response); err != nil {
+				log.Printf("Warning: Failed to store idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+func writeIdempotencyError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   code,
+		"message": message,
+	})
+}
+
+// responseCapture passes a response through and keeps a copy of its status and body
+type responseCapture struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *responseCapture) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseCapture) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
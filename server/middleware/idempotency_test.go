@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/services"
+)
+
+func TestIdempotentReplaysRetries(t *testing.T) {
+	idempotency := services.NewIdempotencyService(dbtest.New(t))
+
+	created := 0
+	handler := Idempotent(idempotency)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		created++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/v1/users/42")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"42"}`))
+	}))
+
+	send := func(tenantID, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), TenantIDKey, tenantID))
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := send("t1", "create-jane", `{"email":"jane@acme.com"}`)
+	retry := send("t1", "create-jane", `{"email":"jane@acme.com"}`)
+	if created != 1 {
+		t.Fatalf("handler ran %d times, want once", created)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get("Location") != "/api/v1/users/42" || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry = %d %v %s, want the original response replayed", retry.Code, retry.Header(), retry.Body)
+	}
+
+	if w := send("t1", "create-jane", `{"email":"john@acme.com"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another request = %d, want 422", w.Code)
+	}
+
+	// Keys are scoped to the tenant, and requests without a key are never deduplicated
+	send("t2", "create-jane", `{"email":"jane@acme.com"}`)
+	send("t1", "", `{"email":"jane@acme.com"}`)
+	if created != 3 {
+		t.Errorf("handler ran %d times, want 3", created)
+	}
+}
+
+func TestIdempotentRejectsLongKeys(t *testing.T) {
+	handler := Idempotent(&services.IdempotencyService{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called with an overlong key")
+	}))
+
+	r := httptest.NewRequest("POST", "/api/v1/users", strings.NewReader("{}"))
+	r.Header.Set("Idempotency-Key", strings.Repeat("k", maxIdempotencyKeyLength+1))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("overlong key = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// LoadShedding limits how many requests are handled at once. Requests beyond MaxConcurrent wait
+// up to QueueTimeout for a slot; beyond MaxQueue waiting requests they are rejected right away.
+type LoadShedding struct {
+	MaxConcurrent int
+	MaxQueue      int
+	QueueTimeout  time.Duration
+}
+
+// LoadShedderStats are the requests in flight and waiting, and those rejected since startup
+type LoadShedderStats struct {
+	MaxConcurrent int   `json:"max_concurrent"`
+	InFlight      int64 `json:"in_flight"`
+	Queued        int64 `json:"queued"`
+	Shed          int64 `json:"shed"`
+	PriorityShed  int64 `json:"priority_shed"`
+}
+
+// LoadShedder keeps a slow database from piling up goroutines: it caps the requests being handled
+// and answers the rest with 503 Service Unavailable and Retry-After instead of letting them wait
+// without bound. A share of the MaxConcurrent slots is reserved for priority requests (the token
+// endpoint and health checks), so they keep being served while other requests are shed.
+type LoadShedder struct {
+	limits   LoadShedding
+	priority func(*http.Request) bool
+	slots    chan struct{} // shared by all requests
+	reserved chan struct{} // only taken by priority requests, nil without reservation
+
+	inFlight     int64
+	queued       int64
+	shed         int64
+	priorityShed int64
+}
+
+// NewLoadShedder creates a load shedder; priority reports the requests that may use the
+// reserved slots. A MaxConcurrent of 0 or less disables shedding.
+func NewLoadShedder(limits LoadShedding, priority func(*http.Request) bool) *LoadShedder {
+	s := &LoadShedder{limits: limits, priority: priority}
+	if limits.MaxConcurrent <= 0 {
+		return s
+	}
+
+	// A tenth of the slots, at least one, is kept for priority requests; other requests share the
+	// rest, so no more than MaxConcurrent requests are handled at once. A single slot is shared.
+	reserved := limits.MaxConcurrent / 10
+	if reserved < 1 && limits.MaxConcurrent > 1 {
+		reserved = 1
+	}
+	if reserved > 0 {
+		s.reserved = make(chan struct{}, reserved)
+	}
+	s.slots = make(chan struct{}, limits.MaxConcurrent-reserved)
+	return s
+}
+
+// Middleware sheds the requests that find no slot within the queue timeout
+func (s *LoadShedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.slots == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		priority := s.priority != nil && s.priority(r)
+		release, ok := s.acquire(r, priority)
+		if !ok {
+			if priority {
+				atomic.AddInt64(&s.priorityShed, 1)
+			}
+			atomic.AddInt64(&s.shed, 1)
+			writeOverloaded(w, s.limits.QueueTimeout)
+			return
+		}
+		defer release()
+
+		atomic.AddInt64(&s.inFlight, 1)
+		defer atomic.AddInt64(&s.inFlight, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a free slot, waiting in the queue for one if all are taken
+func (s *LoadShedder) acquire(r *http.Request, priority bool) (release func(), ok bool) {
+	releaseShared := func() { <-s.slots }
+	releaseReserved := func() { <-s.reserved }
+
+	select {
+	case s.slots <- struct{}{}:
+		return releaseShared, true
+	default:
+	}
+	if priority {
+		select {
+		case s.reserved <- struct{}{}:
+			return releaseReserved, true
+		default:
+		}
+	}
+
+	if atomic.AddInt64(&s.queued, 1) > int64(s.limits.MaxQueue) {
+		atomic.AddInt64(&s.queued, -1)
+		return nil, false
+	}
+	defer atomic.AddInt64(&s.queued, -1)
+
+	timer := time.NewTimer(s.limits.QueueTimeout)
+	defer timer.Stop()
+
+	// Only priority requests wait for reserved slots; a nil channel never becomes ready
+	var reserved chan struct{}
+	if priority {
+		reserved = s.reserved
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return releaseShared, true
+	case reserved <- struct{}{}:
+		return releaseReserved, true
+	case <-timer.C:
+		return nil, false
+	case <-r.Context().Done():
+		return nil, false
+	}
+}
+
+// Stats returns the current load and the requests shed since startup
+func (s *LoadShedder) Stats() LoadShedderStats {
+	return LoadShedderStats{
+		MaxConcurrent: s.limits.MaxConcurrent,
+		InFlight:      atomic.LoadInt64(&s.inFlight),
+		Queued:        atomic.LoadInt64(&s.queued),
+		Shed:          atomic.LoadInt64(&s.shed),
+		PriorityShed:  atomic.LoadInt64(&s.priorityShed),
+	}
+}
+
+// writeOverloaded answers a shed request the way OAuth clients expect a temporary outage
+func writeOverloaded(w http.ResponseWriter, queueTimeout time.Duration) {
+	retryAfter := int(queueTimeout / time.Second)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             "temporarily_unavailable",
+		"error_description": "The server is overloaded, retry later",
+	})
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadShedderShedsAndKeepsPrioritySlots(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedding{MaxConcurrent: 3, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond},
+		func(r *http.Request) bool { return strings.HasSuffix(r.URL.Path, "/oauth/token") })
+
+	unblock := make(chan struct{})
+	started := make(chan struct{}, 10)
+	handler := shedder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") != "" {
+			started <- struct{}{}
+			<-unblock
+		}
+	}))
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	// Two slow requests take the shared slots, leaving the reserved one
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve("/api/v1/users?block=1")
+		}()
+		<-started
+	}
+
+	w := serve("/api/v1/users")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), "temporarily_unavailable") {
+		t.Errorf("request beyond the limit = %d %q", w.Code, w.Body.String())
+	}
+	if w := serve("/oauth/token"); w.Code != http.StatusOK {
+		t.Errorf("token request while overloaded = %d, want a reserved slot", w.Code)
+	}
+
+	stats := shedder.Stats()
+	if stats.InFlight != 2 || stats.Shed != 1 || stats.PriorityShed != 0 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	// The reserved slot is one of the MaxConcurrent slots, not one on top of them
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve("/oauth/token?block=1")
+	}()
+	<-started
+	if w := serve("/oauth/token"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("token request beyond MaxConcurrent = %d, want 503", w.Code)
+	}
+	if stats := shedder.Stats(); stats.InFlight != 3 || stats.PriorityShed != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	close(unblock)
+	wg.Wait()
+	if w := serve("/api/v1/users"); w.Code != http.StatusOK {
+		t.Errorf("request after the load dropped = %d", w.Code)
+	}
+}
+
+func TestLoadShedderQueueLimit(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedding{MaxConcurrent: 1, MaxQueue: 0, QueueTimeout: time.Second}, nil)
+
+	unblock := make(chan struct{})
+	started := make(chan struct{})
+	handler := shedder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-unblock
+		}
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-started
+
+	// Without queue room the request is shed right away instead of waiting out the timeout
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	if w.Code != http.StatusServiceUnavailable || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("request with a full queue = %d after %v", w.Code, time.Since(start))
+	}
+	close(unblock)
+	<-done
+}
+
+func TestLoadShedderDisabled(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedding{}, nil)
+	w := httptest.NewRecorder()
+	shedder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("disabled shedder answered %d", w.Code)
+	}
+}
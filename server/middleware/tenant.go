@@ -2,10 +2,12 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"strings"
 
+	"oauth2-openid-server/models"
 	"oauth2-openid-server/services"
 	"github.com/gorilla/mux"
 )
@@ -14,7 +16,13 @@ type contextKey string
 
 const TenantIDKey contextKey = "tenant_id"
 
-// TenantMiddleware extracts tenant information from the request and adds it to context
+// tenantExplicitKey marks requests whose tenant was named by the request rather than defaulted
+const tenantExplicitKey contextKey = "tenant_explicit"
+
+// TenantMiddleware extracts tenant information from the request and adds it to context. A tenant
+// named by the path, a query parameter or the X-Tenant-ID header must exist and be active, otherwise
+// the request is rejected with 404 unknown_tenant. Tenants stored in a data residency region this
+// instance has no cluster for are rejected with 421 wrong_region.
 func TenantMiddleware(tenantService *services.TenantService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -25,16 +33,20 @@ func TenantMiddleware(tenantService *services.TenantService) func(http.Handler)
 			// 4. Host/subdomain resolution
 			// 5. Default tenant fallback
 			var tenantID string
+			var resolved *models.Tenant
+			explicit := true
 
 			// 1. Check for tenant ID in URL path (e.g., /tenant/{tenantId}/...)
 			if vars := mux.Vars(r); vars != nil {
 				if urlTenantID := vars["tenantId"]; urlTenantID != "" {
 					// Validate that the tenant exists
 					tenant, err := tenantService.GetTenantByID(urlTenantID)
-					if err == nil && tenant != nil {
-						tenantID = tenant.ID.Hex()
-						log.Printf("Tenant resolved from URL path: %s ID: %s", tenant.Name, tenantID)
+					if err != nil {
+						writeUnknownTenant(w)
+						return
 					}
+					tenantID, resolved = tenant.ID.Hex(), tenant
+					log.Printf("Tenant resolved from URL path: %s ID: %s", tenant.Name, tenantID)
 				}
 			}
 
@@ -45,29 +57,28 @@ func TenantMiddleware(tenantService *services.TenantService) func(http.Handler)
 					if queryTenantID := r.URL.Query().Get(param); queryTenantID != "" {
 						// Validate that the tenant exists
 						tenant, err := tenantService.GetTenantByID(queryTenantID)
-						if err == nil && tenant != nil {
-							tenantID = tenant.ID.Hex()
-							println("Tenant resolved from URL query parameter", param+":", tenant.Name, "ID:", tenantID)
-							break
+						if err != nil {
+							writeUnknownTenant(w)
+							return
 						}
+						tenantID, resolved = tenant.ID.Hex(), tenant
+						println("Tenant resolved from URL query parameter", param+":", tenant.Name, "ID:", tenantID)
+						break
 					}
 				}
 			}
 
-			// 3. Check for X-Tenant-ID header (for API clients)
+			// 3. Check for X-Tenant-ID header (for API clients). Unknown or deactivated tenants are
+			// rejected rather than passed on, so queries can't be pointed at arbitrary tenant IDs.
 			if tenantID == "" {
 				if header := r.Header.Get("X-Tenant-ID"); header != "" {
-					// This could be either an ObjectID or a tenant identifier
-					// First try as ObjectID
 					tenant, err := tenantService.GetTenantByID(header)
-					if err == nil && tenant != nil {
-						tenantID = tenant.ID.Hex()
-						println("Tenant resolved from X-Tenant-ID header:", tenant.Name, "ID:", tenantID)
-					} else {
-						// If not found as ObjectID, treat as direct tenant ID
-						tenantID = header
-						println("Using X-Tenant-ID header directly as tenant ID:", tenantID)
+					if err != nil {
+						writeUnknownTenant(w)
+						return
 					}
+					tenantID, resolved = tenant.ID.Hex(), tenant
+					println("Tenant resolved from X-Tenant-ID header:", tenant.Name, "ID:", tenantID)
 				}
 			}
 
@@ -81,30 +92,41 @@ func TenantMiddleware(tenantService *services.TenantService) func(http.Handler)
 
 				tenant, err := tenantService.ResolveTenantFromHost(host)
 				if err == nil && tenant != nil {
-					tenantID = tenant.ID.Hex()
+					tenantID, resolved = tenant.ID.Hex(), tenant
 					println("Tenant resolved from host", host+":", tenant.Name, "ID:", tenantID)
 				}
 			}
 
 			// If no tenant found, try to get default tenant using isDefault flag
 			if tenantID == "" {
+				explicit = false
 				defaultTenant, err := tenantService.GetDefaultTenant()
 				if err != nil {
 					// Log the error but continue - this helps with debugging
 					println("Warning: Failed to get default tenant:", err.Error())
 				}
 				if err == nil && defaultTenant != nil {
-					tenantID = defaultTenant.ID.Hex()
+					tenantID, resolved = defaultTenant.ID.Hex(), defaultTenant
 					println("Using default tenant:", defaultTenant.Name, "ID:", tenantID)
 				} else {
 					println("No default tenant found, request will fail")
 				}
 			}
 
+			// The tenant's data may only be read and written in its region
+			if resolved != nil {
+				if err := tenantService.RouteTenant(resolved); err != nil {
+					writeWrongRegion(w, resolved.Storage.Region)
+					return
+				}
+			}
+
 			// Add tenant ID to request context
 			if tenantID != "" {
 				ctx := context.WithValue(r.Context(), TenantIDKey, tenantID)
+				ctx = context.WithValue(ctx, tenantExplicitKey, explicit)
 				r = r.WithContext(ctx)
+				setAccessLogTenant(r, tenantID)
 			}
 
 			next.ServeHTTP(w, r)
@@ -112,6 +134,27 @@ func TenantMiddleware(tenantService *services.TenantService) func(http.Handler)
 	}
 }
 
+// writeUnknownTenant rejects requests naming a tenant that doesn't exist or was deactivated
+func writeUnknownTenant(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "unknown_tenant",
+		"message": "The requested tenant does not exist",
+	})
+}
+
+// writeWrongRegion rejects requests for a tenant whose data lives in a region this instance can't
+// reach, so they are retried on an instance of that region
+func writeWrongRegion(w http.ResponseWriter, region string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMisdirectedRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "wrong_region",
+		"message": "The tenant's data is stored in region " + region + ", which this server does not serve",
+	})
+}
+
 // GetTenantIDFromContext extracts tenant ID from request context
 func GetTenantIDFromContext(ctx context.Context) string {
 	if tenantID, ok := ctx.Value(TenantIDKey).(string); ok {
@@ -123,4 +166,13 @@ func GetTenantIDFromContext(ctx context.Context) string {
 // GetTenantIDFromRequest extracts tenant ID from request context
 func GetTenantIDFromRequest(r *http.Request) string {
 	return GetTenantIDFromContext(r.Context())
-}
\ No newline at end of file
+}
+
+// GetExplicitTenantID returns the tenant the request was addressed to (path, query parameter,
+// X-Tenant-ID header or host), or "" when it fell back to the default tenant
+func GetExplicitTenantID(r *http.Request) string {
+	if explicit, _ := r.Context().Value(tenantExplicitKey).(bool); explicit {
+		return GetTenantIDFromRequest(r)
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTenantMiddlewareRejectsUnknownTenants(t *testing.T) {
+	db := dbtest.New(t)
+	now := time.Now()
+	defaultID, acmeID, inactiveID := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	dbtest.Insert(t, db, "tenants",
+		&models.Tenant{ID: defaultID, Name: "Default", Active: true, IsDefault: true, CreatedAt: now, UpdatedAt: now},
+		&models.Tenant{ID: acmeID, Name: "Acme", Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.Tenant{ID: inactiveID, Name: "Gone", Active: false, CreatedAt: now, UpdatedAt: now},
+	)
+
+	var resolved string
+	router := mux.NewRouter()
+	router.Use(TenantMiddleware(services.NewTenantService(db)))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolved = GetTenantIDFromRequest(r)
+	})
+	router.Handle("/tenant/{tenantId}/login", handler)
+	router.Handle("/api/v1/users", handler)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   string // resolved tenant, "" when rejected
+	}{
+		{"header", "/api/v1/users", acmeID.Hex(), acmeID.Hex()},
+		{"unknown header", "/api/v1/users", primitive.NewObjectID().Hex(), ""},
+		{"malformed header", "/api/v1/users", "acme", ""},
+		{"deactivated tenant", "/api/v1/users", inactiveID.Hex(), ""},
+		{"unknown query parameter", "/api/v1/users?tenant_id=" + primitive.NewObjectID().Hex(), "", ""},
+		{"path", "/tenant/" + acmeID.Hex() + "/login", "", acmeID.Hex()},
+		{"unknown path", "/tenant/" + primitive.NewObjectID().Hex() + "/login", "", ""},
+		{"default", "/api/v1/users", "", defaultID.Hex()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved = ""
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if tt.want == "" && w.Code != http.StatusNotFound {
+				t.Errorf("Expected 404 for an unknown tenant, got %d", w.Code)
+			}
+			if resolved != tt.want {
+				t.Errorf("resolved tenant = %q, want %q", resolved, tt.want)
+			}
+		})
+	}
+}
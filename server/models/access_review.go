@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Access review campaign states
+const (
+	AccessReviewOpen      = "open"
+	AccessReviewCompleted = "completed"
+)
+
+// Access review decisions
+const (
+	AccessReviewApprove = "approve"
+	AccessReviewRevoke  = "revoke"
+)
+
+// AccessReviewCampaign asks the owners of groups to confirm that each member still needs the
+// membership. Applying the campaign removes the members whose membership was revoked.
+type AccessReviewCampaign struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID        string              `bson:"tenant_id" json:"tenant_id"`
+	Name            string              `bson:"name" json:"name"`
+	GroupIDs        []string            `bson:"group_ids" json:"group_ids"` // groups under review, empty for all of the tenant's groups
+	Status          string              `bson:"status" json:"status"`
+	RevokeUndecided bool                `bson:"revoke_undecided" json:"revoke_undecided"`   // members nobody decided on lose the membership
+	RepeatEveryDays int                 `bson:"repeat_every_days" json:"repeat_every_days"` // the next campaign starts this many days after this one (0: once)
+	DueAt           time.Time           `bson:"due_at" json:"due_at"`                       // applied automatically at this time
+	CreatedBy       string              `bson:"created_by" json:"created_by"`
+	CreatedAt       time.Time           `bson:"created_at" json:"created_at"`
+	AppliedAt       *time.Time          `bson:"applied_at,omitempty" json:"applied_at,omitempty"`
+	Repeated        bool                `bson:"repeated" json:"-"` // the next campaign of the series was started
+	Summary         AccessReviewSummary `bson:"summary" json:"summary"`
+}
+
+// AccessReviewSummary counts a campaign's items by decision, and the memberships it removed
+type AccessReviewSummary struct {
+	Items     int `bson:"items" json:"items"`
+	Approved  int `bson:"approved" json:"approved"`
+	Revoked   int `bson:"revoked" json:"revoked"`
+	Undecided int `bson:"undecided" json:"undecided"`
+	Removed   int `bson:"removed" json:"removed"`
+}
+
+// AccessReviewItem is the review of one user's membership in one group
+type AccessReviewItem struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID   string             `bson:"tenant_id" json:"tenant_id"`
+	CampaignID string             `bson:"campaign_id" json:"campaign_id"`
+	GroupID    string             `bson:"group_id" json:"group_id"`
+	GroupName  string             `bson:"group_name" json:"group_name"`
+	UserID     string             `bson:"user_id" json:"user_id"`
+	UserEmail  string             `bson:"user_email" json:"user_email"`
+	Reviewers  []string           `bson:"reviewers" json:"reviewers"` // the group's owners other than the user; empty: tenant admins
+	Decision   string             `bson:"decision" json:"decision"`   // approve, revoke or empty while undecided
+	Comment    string             `bson:"comment,omitempty" json:"comment,omitempty"`
+	DecidedBy  string             `bson:"decided_by,omitempty" json:"decided_by,omitempty"`
+	DecidedAt  *time.Time         `bson:"decided_at,omitempty" json:"decided_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
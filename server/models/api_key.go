@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey is a static credential for integrations that don't speak OAuth. Requests sending it in
+// the X-API-Key header act as the key's service principal with the key's scopes. Only a hash of
+// the key is stored; Prefix identifies it in lists and logs.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID   string             `bson:"tenant_id" json:"tenant_id"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"` // first characters of the key
+	KeyHash    string             `bson:"key_hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedBy  string             `bson:"created_by" json:"created_by"` // user who created the key
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	LastUsedIP string             `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// PrincipalID is the user ID requests made with the key act as, e.g. in audit events
+func (k *APIKey) PrincipalID() string {
+	return APIKeyPrincipalPrefix + k.ID.Hex()
+}
+
+// APIKeyPrincipalPrefix starts the user ID of API key service principals
+const APIKeyPrincipalPrefix = "api_key:"
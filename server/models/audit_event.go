@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEvent records a security relevant action within a tenant
+type AuditEvent struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID  string             `bson:"tenant_id" json:"tenant_id"`
+	Type      string             `bson:"type" json:"type"`
+	UserID    string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email     string             `bson:"email,omitempty" json:"email,omitempty"`
+	ClientID  string             `bson:"client_id,omitempty" json:"client_id,omitempty"`
+	ActorID   string             `bson:"actor_id,omitempty" json:"actor_id,omitempty"` // admin who made the change, if not the user
+	Target    string             `bson:"target,omitempty" json:"target,omitempty"`     // group ID, scope or provider name the event concerns
+	IPAddress string             `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	UserAgent string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"` // e.g. why a login failed
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Audit event types
+const (
+	AuditEventLoginSuccess = "login_success"
+	AuditEventLoginFailure = "login_failure"
+	AuditEventEmailChanged = "email_changed"
+
+	AuditEventElevationGranted = "elevation_granted"
+	AuditEventElevationFailure = "elevation_failure"
+
+	AuditEventUserDeactivated   = "user_deactivated"
+	AuditEventUserDeleted       = "user_deleted"
+	AuditEventUserDeprovisioned = "user_deprovisioned"
+	AuditEventUserReactivated   = "user_reactivated"
+
+	AuditEventTwoFactorEnabled    = "two_factor_enabled"
+	AuditEventClientSecretRotated = "client_secret_rotated"
+	AuditEventGroupMemberAdded    = "group_member_added"
+	AuditEventGroupMemberRemoved  = "group_member_removed"
+	AuditEventGroupMemberExpired  = "group_member_expired"
+	AuditEventUserScopeAdded      = "user_scope_added"
+	AuditEventUserScopeRemoved    = "user_scope_removed"
+	AuditEventProviderUpdated     = "provider_updated"
+
+	AuditEventPasswordSet          = "password_set"
+	AuditEventPasswordResetForced  = "password_reset_forced"
+	AuditEventSocialIdentityLinked = "social_identity_linked"
+
+	AuditEventDomainVerified = "domain_verified"
+
+	AuditEventAPIKeyCreated  = "api_key_created"
+	AuditEventAPIKeyRevoked  = "api_key_revoked"
+	AuditEventAPIKeyUsed     = "api_key_used"     // reason: the request's method and path
+	AuditEventAPIKeyRejected = "api_key_rejected" // a revoked or expired key was presented
+
+	AuditEventAccessReviewStarted  = "access_review_started"  // target: the campaign
+	AuditEventAccessReviewDecision = "access_review_decision" // target: the group; reason: approve or revoke
+	AuditEventAccessReviewApplied  = "access_review_applied"  // target: the campaign
+)
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuthorizeFlow holds the server-side state of a headless (JSON driven) authorization flow
+type AuthorizeFlow struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID            string             `bson:"tenant_id" json:"tenant_id"`
+	FlowID              string             `bson:"flow_id" json:"flow_id"`
+	CSRFToken           string             `bson:"csrf_token" json:"-"`
+	Step                string             `bson:"step" json:"step"`
+	ClientID            string             `bson:"client_id" json:"client_id"`
+	ClientName          string             `bson:"client_name" json:"client_name"`
+	ClientMetadata      ClientMetadata     `bson:"client_metadata" json:"client_metadata"` // logo and links shown on the consent step
+	RedirectURI         string             `bson:"redirect_uri" json:"redirect_uri"`
+	RequestedScopes     []string           `bson:"requested_scopes" json:"requested_scopes"`
+	GrantedScopes       []string           `bson:"granted_scopes" json:"granted_scopes"`
+	State               string             `bson:"state" json:"state"`
+	CodeChallenge       string             `bson:"code_challenge" json:"-"`
+	CodeChallengeMethod string             `bson:"code_challenge_method" json:"-"`
+	UserID              string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	UserAgent           string             `bson:"user_agent" json:"-"` // device that started the flow, for code binding
+	IPAddress           string             `bson:"ip_address" json:"-"`
+	FailedAttempts      int                `bson:"failed_attempts" json:"-"`
+	ACR                 string             `bson:"acr,omitempty" json:"-"` // authentication context class reached by the login
+	RedirectTo          string             `bson:"redirect_to,omitempty" json:"redirect_to,omitempty"`
+	ExpiresAt           time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt           time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt           time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Steps of a headless authorization flow
+const (
+	AuthorizeFlowStepCredentials = "credentials"
+	AuthorizeFlowStepTwoFactor   = "two_factor"
+	AuthorizeFlowStepConsent     = "consent"
+	AuthorizeFlowStepComplete    = "complete"
+)
+
+// ConsentGrant records the scopes a user has agreed to share with a client, and the optional
+// scopes and claims the user declined
+type ConsentGrant struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID       string             `bson:"tenant_id" json:"tenant_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	ClientID       string             `bson:"client_id" json:"client_id"`
+	Scopes         []string           `bson:"scopes" json:"scopes"`
+	DeclinedScopes []string           `bson:"declined_scopes,omitempty" json:"declined_scopes,omitempty"`
+	DeclinedClaims []string           `bson:"declined_claims,omitempty" json:"declined_claims,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
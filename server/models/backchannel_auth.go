@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BackchannelAuthRequest represents a pending CIBA (Client-Initiated Backchannel Authentication) request
+type BackchannelAuthRequest struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID       string             `bson:"tenant_id" json:"tenant_id"`
+	AuthReqID      string             `bson:"auth_req_id" json:"auth_req_id"`
+	ClientID       string             `bson:"client_id" json:"client_id"`
+	ClientName     string             `bson:"client_name" json:"client_name"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	LoginHint      string             `bson:"login_hint" json:"login_hint"`
+	BindingMessage string             `bson:"binding_message" json:"binding_message"`
+	Scopes         []string           `bson:"scopes" json:"scopes"`
+	Status         string             `bson:"status" json:"status"`     // pending, approved, denied, consumed
+	Interval       int                `bson:"interval" json:"interval"` // minimum polling interval in seconds
+	LastPolledAt   *time.Time         `bson:"last_polled_at,omitempty" json:"last_polled_at,omitempty"`
+	ExpiresAt      time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// BackchannelAuthStatus defines the lifecycle states of a CIBA request
+const (
+	BackchannelAuthStatusPending  = "pending"
+	BackchannelAuthStatusApproved = "approved"
+	BackchannelAuthStatusDenied   = "denied"
+	BackchannelAuthStatusConsumed = "consumed"
+)
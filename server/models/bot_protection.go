@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Bot protection providers
+const (
+	BotProviderRecaptcha = "recaptcha"
+	BotProviderHCaptcha  = "hcaptcha"
+	BotProviderTurnstile = "turnstile"
+	BotProviderPoW       = "pow" // built-in proof-of-work challenge, needs no third party
+)
+
+// Public endpoints bot protection can guard
+const (
+	BotEndpointLogin    = "login"    // POST /login and the credentials step of headless authorization flows
+	BotEndpointRegister = "register" // POST /register
+)
+
+// BotProtectionPolicy is a tenant's challenge for public endpoints. Requests to guarded endpoints
+// must carry a solved challenge of the provider or a bypass key of the tenant.
+type BotProtectionPolicy struct {
+	TenantID      string    `bson:"_id" json:"tenant_id"`
+	Provider      string    `bson:"provider" json:"provider" validate:"oneof=recaptcha hcaptcha turnstile pow"` // "" turns bot protection off
+	SiteKey       string    `bson:"site_key" json:"site_key" validate:"max=200"`                                // public key of the CAPTCHA widget
+	SecretKey     string    `bson:"secret_key" json:"-"`                                                        // CAPTCHA verification secret, never returned
+	HasSecretKey  bool      `bson:"-" json:"has_secret_key"`
+	Endpoints     []string  `bson:"endpoints" json:"endpoints" validate:"dive,oneof=login register"` // empty guards every endpoint
+	MinScore      float64   `bson:"min_score" json:"min_score"`                                      // reCAPTCHA v3 score needed to pass, 0 to 1 (0 = any)
+	PoWDifficulty int       `bson:"pow_difficulty" json:"pow_difficulty" validate:"min=0,max=28"`    // leading zero bits of a proof-of-work solution (0 = 20)
+	UpdatedAt     time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Guards reports whether the policy requires a challenge on the endpoint
+func (p *BotProtectionPolicy) Guards(endpoint string) bool {
+	if p == nil || p.Provider == "" {
+		return false
+	}
+	if len(p.Endpoints) == 0 {
+		return true
+	}
+	for _, guarded := range p.Endpoints {
+		if guarded == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// BotBypassKey lets trusted automation (monitoring, load tests, provisioning scripts) call guarded
+// endpoints without solving challenges. Only a hash of the key is stored.
+type BotBypassKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID   string             `bson:"tenant_id" json:"tenant_id"`
+	Name       string             `bson:"name" json:"name"`
+	KeyHash    string             `bson:"key_hash" json:"-"`
+	Prefix     string             `bson:"prefix" json:"prefix"` // first characters of the key, to tell keys apart
+	CreatedBy  string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
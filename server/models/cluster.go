@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ClusterLock is a lease on work that only one server instance may do at a time
+type ClusterLock struct {
+	Name       string    `bson:"_id" json:"name"`      // e.g. "crypto_keys:platform"
+	Holder     string    `bson:"holder" json:"holder"` // instance ID
+	Region     string    `bson:"region,omitempty" json:"region,omitempty"`
+	AcquiredAt time.Time `bson:"acquired_at" json:"acquired_at"`
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// ClusterEvent is a change one instance announces to the others, e.g. a session revocation that
+// instances in other regions apply and forward
+type ClusterEvent struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Type      string                 `bson:"type" json:"type"`
+	Origin    string                 `bson:"origin" json:"origin"` // instance ID of the publisher
+	Region    string                 `bson:"region,omitempty" json:"region,omitempty"`
+	TenantID  string                 `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	Data      map[string]interface{} `bson:"data,omitempty" json:"data,omitempty"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
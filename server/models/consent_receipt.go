@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Consent receipt actions
+const (
+	ConsentReceiptGranted   = "granted"
+	ConsentReceiptWithdrawn = "withdrawn"
+)
+
+// ConsentReceipt is an immutable record of a consent decision or withdrawal. Receipts are kept
+// when the consent grant changes or is removed, so auditors can see who consented to what and when.
+type ConsentReceipt struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID       string             `bson:"tenant_id" json:"tenant_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	ClientID       string             `bson:"client_id" json:"client_id"`
+	Action         string             `bson:"action" json:"action"`
+	Scopes         []string           `bson:"scopes" json:"scopes"` // approved scopes; the withdrawn ones for withdrawals
+	DeclinedScopes []string           `bson:"declined_scopes,omitempty" json:"declined_scopes,omitempty"`
+	DeclinedClaims []string           `bson:"declined_claims,omitempty" json:"declined_claims,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -8,14 +8,45 @@ import (
 // CryptoKey represents a cryptographic key stored in the database
 type CryptoKey struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID   string            `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"` // empty for the platform keys
 	KeyID      string            `bson:"key_id" json:"key_id"`           // JWK kid
 	KeyType    string            `bson:"key_type" json:"key_type"`       // "rsa", "ecdsa"
 	Algorithm  string            `bson:"algorithm" json:"algorithm"`     // "RS256", "ES256"
 	PrivateKey []byte            `bson:"private_key" json:"-"`           // PEM encoded, don't expose in JSON
 	PublicKey  []byte            `bson:"public_key" json:"public_key"`   // PEM encoded
 	Active     bool              `bson:"active" json:"active"`
+	Region     string            `bson:"region,omitempty" json:"region,omitempty"` // data residency region of the instance that created it
 	CreatedAt  time.Time         `bson:"created_at" json:"created_at"`
+	ActivatesAt *time.Time       `bson:"activates_at,omitempty" json:"activates_at,omitempty"` // published ahead of use until then
 	ExpiresAt  *time.Time        `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	RevokedAt  *time.Time        `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+
+	// Canary rotation: the key signs this percentage of new tokens until it is promoted or rolled back
+	CanaryPercent      int        `bson:"canary_percent,omitempty" json:"canary_percent,omitempty"`
+	CanaryMaxFailures  int        `bson:"canary_max_failures,omitempty" json:"canary_max_failures,omitempty"` // distinct clients reporting failures that roll the canary back (0 = manual only)
+	CanaryTokens       int        `bson:"canary_tokens,omitempty" json:"canary_tokens,omitempty"`             // tokens signed while it was a canary
+	CanaryFailures     int        `bson:"canary_failures,omitempty" json:"canary_failures,omitempty"`         // failures reported by clients of the key's tenant
+	RolledBackAt       *time.Time `bson:"rolled_back_at,omitempty" json:"rolled_back_at,omitempty"`
+	ValidationFailures int        `bson:"validation_failures,omitempty" json:"validation_failures,omitempty"` // reported by relying parties
+	FailingClients     []string   `bson:"failing_clients,omitempty" json:"failing_clients,omitempty"`         // client IDs of the key tenant's relying parties that reported failures
+	LastFailure        string     `bson:"last_failure,omitempty" json:"last_failure,omitempty"`
+}
+
+// Status reports whether the key is still published and usable at the given time
+func (k *CryptoKey) Status(now time.Time) KeyStatus {
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(now) {
+		return KeyStatusExpired
+	}
+	if !k.Active {
+		return KeyStatusInactive
+	}
+	if k.ActivatesAt != nil && k.ActivatesAt.After(now) {
+		return KeyStatusUpcoming
+	}
+	if k.CanaryPercent > 0 {
+		return KeyStatusCanary
+	}
+	return KeyStatusActive
 }
 
 // KeyPurpose defines the purpose of the key
@@ -33,4 +64,6 @@ const (
 	KeyStatusActive   KeyStatus = "active"
 	KeyStatusInactive KeyStatus = "inactive"
 	KeyStatusExpired  KeyStatus = "expired"
+	KeyStatusUpcoming KeyStatus = "upcoming"
+	KeyStatusCanary   KeyStatus = "canary"
 )
\ No newline at end of file
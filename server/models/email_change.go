@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailChangeRequest is a pending change of a user's email address. The change takes effect once
+// the new address is verified and, if the tenant requires it, confirmed from the old address.
+// Only hashes of the link tokens are stored.
+type EmailChangeRequest struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID            string             `bson:"tenant_id" json:"tenant_id"`
+	UserID              string             `bson:"user_id" json:"user_id"`
+	OldEmail            string             `bson:"old_email" json:"old_email"`
+	NewEmail            string             `bson:"new_email" json:"new_email"`
+	TokenHash           string             `bson:"token_hash" json:"-"`
+	OldEmailTokenHash   string             `bson:"old_email_token_hash,omitempty" json:"-"` // empty when the old address does not need to confirm
+	NewEmailVerifiedAt  *time.Time         `bson:"new_email_verified_at,omitempty" json:"new_email_verified_at,omitempty"`
+	OldEmailConfirmedAt *time.Time         `bson:"old_email_confirmed_at,omitempty" json:"old_email_confirmed_at,omitempty"`
+	CompletedAt         *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ExpiresAt           time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt           time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// RequiresOldEmailConfirmation reports whether the old address has to confirm the change
+func (r *EmailChangeRequest) RequiresOldEmailConfirmation() bool {
+	return r.OldEmailTokenHash != ""
+}
+
+// Ready reports whether every required confirmation has been given
+func (r *EmailChangeRequest) Ready() bool {
+	if r.NewEmailVerifiedAt == nil {
+		return false
+	}
+	return !r.RequiresOldEmailConfirmation() || r.OldEmailConfirmedAt != nil
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// FeatureFlag switches an auth capability on or off, either for the whole deployment or, as an
+// override, for one tenant
+type FeatureFlag struct {
+	Name              string    `bson:"name" json:"name"`
+	Scope             string    `bson:"scope" json:"scope"` // FeatureFlagScopeDeployment or a tenant ID
+	Enabled           bool      `bson:"enabled" json:"enabled"`
+	RolloutPercentage int       `bson:"rollout_percentage" json:"rollout_percentage"` // deployment flags: share of tenants it is enabled for
+	UpdatedBy         string    `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt         time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// FeatureFlagScopeDeployment is the scope of the flags that apply to every tenant without an override
+const FeatureFlagScopeDeployment = "deployment"
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Statuses of an idempotency key
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord remembers the response to a request sent with an Idempotency-Key header, so a
+// retry returns it instead of repeating the request. Neither the key nor the response is stored
+// in the clear: the ID is a hash of the key and the response is sealed with a key derived from it.
+type IdempotencyRecord struct {
+	ID          string    `bson:"_id"`
+	Fingerprint string    `bson:"fingerprint"` // hash of the method, path and body of the request
+	Status      string    `bson:"status"`
+	Response    string    `bson:"response,omitempty"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of background jobs
+const (
+	JobUserImport      = "user_import"
+	JobTokenRevocation = "token_revocation"
+	JobTenantPurge     = "tenant_purge"
+)
+
+// Statuses of a background job
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is a long-running admin operation executed in the background. Jobs are persisted, so a job
+// interrupted by a restart is picked up again and resumes from its recorded progress.
+type Job struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID   string             `bson:"tenant_id" json:"tenant_id"` // tenant the job was submitted in
+	Type       string             `bson:"type" json:"type"`
+	Status     string             `bson:"status" json:"status"`
+	Params     bson.Raw           `bson:"params,omitempty" json:"-"` // removed once the job finished
+	Progress   JobProgress        `bson:"progress" json:"progress"`
+	Result     map[string]int64   `bson:"result,omitempty" json:"result,omitempty"`
+	Errors     []string           `bson:"errors,omitempty" json:"errors,omitempty"` // item failures, the first 100
+	Error      string             `bson:"error,omitempty" json:"error,omitempty"`   // why the job failed
+	Attempts   int                `bson:"attempts" json:"attempts"`
+	LeaseID    string             `bson:"lease_id,omitempty" json:"-"`
+	LeaseUntil *time.Time         `bson:"lease_until,omitempty" json:"-"`
+	CreatedBy  string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	StartedAt  *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// JobProgress counts the items a job handled. Processed includes the failed items; Total is 0
+// until the job knows it.
+type JobProgress struct {
+	Total     int64 `bson:"total" json:"total"`
+	Processed int64 `bson:"processed" json:"processed"`
+	Failed    int64 `bson:"failed" json:"failed"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LegacyRouteUsage is a daily counter of requests to a deprecated route
+type LegacyRouteUsage struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Route    string             `bson:"route" json:"route"` // mux path template, e.g. "/auth/{provider}/login"
+	TenantID string             `bson:"tenant_id" json:"tenant_id"`
+	Day      time.Time          `bson:"day" json:"day"` // UTC midnight of the bucket
+	Requests int64              `bson:"requests" json:"requests"`
+	LastSeen time.Time          `bson:"last_seen" json:"last_seen"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MeteringEvent is a billable usage event of a tenant. Events are kept in an outbox until they
+// have been delivered to the configured metering exporters.
+type MeteringEvent struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID   string             `bson:"tenant_id" json:"tenant_id"`
+	Type       string             `bson:"type" json:"type"`
+	UserID     string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	ClientID   string             `bson:"client_id,omitempty" json:"client_id,omitempty"`
+	Method     string             `bson:"method,omitempty" json:"method,omitempty"` // MFA method, e.g. "totp" or "backup_code"
+	Period     string             `bson:"period" json:"period"`                     // calendar month in UTC, e.g. "2026-10"
+	OccurredAt time.Time          `bson:"occurred_at" json:"occurred_at"`
+	ExportedAt *time.Time         `bson:"exported_at,omitempty" json:"-"`
+}
+
+// Metering event types
+const (
+	MeteringEventActiveUser      = "user.active" // emitted once per user and month
+	MeteringEventTokenIssued     = "token.issued"
+	MeteringEventMFAVerification = "mfa.verified"
+)
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordSetupRequest lets a user who only signs in through social providers add a password. The
+// emailed link proves control of the account's address; only a hash of its token is stored.
+type PasswordSetupRequest struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID  string             `bson:"tenant_id" json:"tenant_id"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	Email     string             `bson:"email" json:"email"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ScopeUsage is a daily counter of how often a client requested and was granted a scope
+type ScopeUsage struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID        string             `bson:"tenant_id" json:"tenant_id"`
+	ClientID        string             `bson:"client_id" json:"client_id"`
+	Scope           string             `bson:"scope" json:"scope"`
+	Day             time.Time          `bson:"day" json:"day"` // UTC midnight of the bucket
+	Requested       int64              `bson:"requested" json:"requested"`
+	Granted         int64              `bson:"granted" json:"granted"`
+	LastRequestedAt *time.Time         `bson:"last_requested_at,omitempty" json:"last_requested_at,omitempty"`
+	LastGrantedAt   *time.Time         `bson:"last_granted_at,omitempty" json:"last_granted_at,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purposes of SMS one-time codes
+const (
+	SMSCodePurposeVerifyPhone = "verify_phone"
+	SMSCodePurposeTwoFactor   = "two_factor"
+)
+
+// SMSCode is a one-time code sent to a user's phone. Only a hash of the code is stored.
+type SMSCode struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID  string             `bson:"tenant_id" json:"tenant_id"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	Purpose   string             `bson:"purpose" json:"purpose"`
+	Phone     string             `bson:"phone" json:"phone"`
+	CodeHash  string             `bson:"code_hash" json:"-"`
+	Attempts  int                `bson:"attempts" json:"attempts"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
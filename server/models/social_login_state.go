@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// SocialLoginState is the server-side record of a social login in progress, keyed by a hash of
+// the state sent to the provider so the callback can be validated without relying on cookies
+type SocialLoginState struct {
+	StateHash string            `bson:"_id" json:"-"`
+	Provider  string            `bson:"provider" json:"provider"`
+	TenantID  string            `bson:"tenant_id" json:"tenant_id"`
+	Params    map[string]string `bson:"params,omitempty" json:"params,omitempty"` // OAuth parameters of the client that started the login
+	ExpiresAt time.Time         `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SSOSession is a browser's sign-in at the authorization server, carried in the sso_session
+// cookie. It lets clients renew tokens with prompt=none without showing the login page again.
+// Sessions started by a direct login (POST /login) renew that first-party client with the user's
+// scopes, as the login did, without a consent.
+type SSOSession struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID       string             `bson:"tenant_id" json:"tenant_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	TokenHash      string             `bson:"token_hash" json:"-"`                                          // SHA-256 of the cookie value
+	ACR            string             `bson:"acr" json:"acr"`                                               // authentication level of the sign-in
+	DirectClientID string             `bson:"direct_client_id,omitempty" json:"direct_client_id,omitempty"` // client of a POST /login sign-in
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	LastActiveAt   time.Time          `bson:"last_active_at" json:"last_active_at"`
+	ExpiresAt      time.Time          `bson:"expires_at" json:"expires_at"`         // end of the idle timeout, never after MaxExpiresAt
+	MaxExpiresAt   time.Time          `bson:"max_expires_at" json:"max_expires_at"` // end of the absolute lifetime
+}
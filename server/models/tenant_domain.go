@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TenantDomain is an email domain a tenant claims for tenant discovery. The claim counts once the
+// tenant has proven it controls the domain by publishing VerificationToken in a DNS TXT record;
+// a domain can be verified for only one tenant.
+type TenantDomain struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID          string             `bson:"tenant_id" json:"tenant_id"`
+	Domain            string             `bson:"domain" json:"domain"` // lowercased, e.g. "acme.com"
+	VerificationToken string             `bson:"verification_token" json:"verification_token"`
+	VerifiedAt        *time.Time         `bson:"verified_at,omitempty" json:"verified_at,omitempty"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+}
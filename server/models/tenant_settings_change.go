@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TenantSettingsChange is a tenant settings update applied at a scheduled time. Applied changes
+// keep the settings they replaced so they can be rolled back.
+type TenantSettingsChange struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID         string             `bson:"tenant_id" json:"tenant_id"`
+	Status           string             `bson:"status" json:"status"`
+	Settings         TenantSettings     `bson:"settings" json:"settings"`
+	PreviousSettings *TenantSettings    `bson:"previous_settings,omitempty" json:"previous_settings,omitempty"`
+	ApplyAt          time.Time          `bson:"apply_at" json:"apply_at"`
+	AppliedAt        *time.Time         `bson:"applied_at,omitempty" json:"applied_at,omitempty"`
+	AppliedVersion   int64              `bson:"applied_version,omitempty" json:"applied_version,omitempty"` // tenant version the change produced
+	RolledBackAt     *time.Time         `bson:"rolled_back_at,omitempty" json:"rolled_back_at,omitempty"`
+	Error            string             `bson:"error,omitempty" json:"error,omitempty"` // why applying failed
+	CreatedBy        string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Statuses of a tenant settings change
+const (
+	SettingsChangeScheduled  = "scheduled"
+	SettingsChangeApplied    = "applied"
+	SettingsChangeCancelled  = "cancelled"
+	SettingsChangeRolledBack = "rolled_back"
+	SettingsChangeFailed     = "failed"
+)
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TenantTokenUsage is a monthly counter of the access tokens issued within a tenant
+type TenantTokenUsage struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID     string             `bson:"tenant_id" json:"tenant_id"`
+	Period       string             `bson:"period" json:"period"` // calendar month in UTC, e.g. "2026-10"
+	TokensIssued int64              `bson:"tokens_issued" json:"tokens_issued"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TenantTranslations holds a tenant's overrides of the built-in messages for one locale
+type TenantTranslations struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID  string             `bson:"tenant_id" json:"tenant_id"`
+	Locale    string             `bson:"locale" json:"locale"`
+	Entries   []TranslationEntry `bson:"entries" json:"entries"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// TranslationEntry is a single overridden message. Stored as a list because message keys contain dots.
+type TranslationEntry struct {
+	Key   string `bson:"key" json:"key"`
+	Value string `bson:"value" json:"value"`
+}
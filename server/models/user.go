@@ -7,28 +7,125 @@ import (
 )
 
 type Tenant struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Name        string             `bson:"name" json:"name"`
-	Domain      string             `bson:"domain" json:"domain"` // e.g., "acme.com" or "tenant1"
-	Subdomain   string             `bson:"subdomain" json:"subdomain"` // e.g., "acme" for "acme.auth-server.com"
-	Active      bool               `bson:"active" json:"active"`
-	IsDefault   bool               `bson:"is_default" json:"is_default"` // Flag to mark the default tenant
-	Settings    TenantSettings     `bson:"settings" json:"settings"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	Domain    string             `bson:"domain" json:"domain"`       // e.g., "acme.com" or "tenant1"
+	Subdomain string             `bson:"subdomain" json:"subdomain"` // e.g., "acme" for "acme.auth-server.com"
+	Active    bool               `bson:"active" json:"active"`
+	IsDefault bool               `bson:"is_default" json:"is_default"` // Flag to mark the default tenant
+	Settings  TenantSettings     `bson:"settings" json:"settings"`
+	Storage   TenantStorage      `bson:"storage" json:"storage"` // set by the platform operator, see TenantService.MoveStorage
+	Version   int64              `bson:"version" json:"version"` // incremented on every update, checked by If-Match
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// TenantStorage places a tenant's tenant-scoped collections (see database.TenantScopedCollections)
+// outside the shared collections, to isolate a large tenant for performance and backups. The zero
+// value is the shared database.
+type TenantStorage struct {
+	Region           string `bson:"region,omitempty" json:"region,omitempty"`                       // region of tenants placed in one before, e.g. "eu"
+	Database         string `bson:"database,omitempty" json:"database,omitempty"`                   // own database on the region's cluster
+	CollectionPrefix string `bson:"collection_prefix,omitempty" json:"collection_prefix,omitempty"` // e.g. "acme_"
 }
 
 type TenantSettings struct {
-	AllowUserRegistration bool               `bson:"allow_user_registration" json:"allow_user_registration"`
-	RequireTwoFactor     bool               `bson:"require_two_factor" json:"require_two_factor"`
-	SessionTimeout       int                `bson:"session_timeout" json:"session_timeout"` // in minutes
-	CustomBranding       TenantBranding     `bson:"custom_branding" json:"custom_branding"`
+	AllowUserRegistration bool                 `bson:"allow_user_registration" json:"allow_user_registration"`
+	RequireTwoFactor      bool                 `bson:"require_two_factor" json:"require_two_factor"`
+	SessionTimeout        int                  `bson:"session_timeout" json:"session_timeout" validate:"min=0,max=43200"` // in minutes
+	DefaultLocale         string               `bson:"default_locale" json:"default_locale" validate:"max=10"`            // e.g. "en", "de", "fr", "bg"
+	CustomBranding        TenantBranding       `bson:"custom_branding" json:"custom_branding"`
+	ClientSecretPolicy    ClientSecretPolicy   `bson:"client_secret_policy" json:"client_secret_policy"`
+	Reports               ReportSettings       `bson:"reports" json:"reports"`
+	Quotas                TenantQuotas         `bson:"quotas" json:"quotas"`
+	KeyRotation           KeyRotationPolicy    `bson:"key_rotation" json:"key_rotation"`
+	ConfirmEmailChange    bool                 `bson:"confirm_email_change" json:"confirm_email_change"` // email changes must also be confirmed from the old address
+	Provisioning          ProvisioningPolicy   `bson:"provisioning" json:"provisioning"`
+	Lifetimes             FlowLifetimes        `bson:"lifetimes" json:"lifetimes"`
+	CodeBinding           string               `bson:"code_binding" json:"code_binding" validate:"oneof=off user_agent user_agent_ip"`        // bind authorization codes to the browser's user agent (and IP)
+	AllowSMSTwoFactor     bool                 `bson:"allow_sms_two_factor" json:"allow_sms_two_factor"`                                      // users may use SMS one-time codes as second factor
+	LoginIdentifiers      []string             `bson:"login_identifiers" json:"login_identifiers" validate:"dive,oneof=email username phone"` // identifiers accepted at login, tried in order (empty = email)
+	DefaultScopes         DefaultScopeSets     `bson:"default_scopes" json:"default_scopes"`
+	RequireS256PKCE       bool                 `bson:"require_s256_pkce" json:"require_s256_pkce"`   // authorization requests that use PKCE must use S256
+	AntiEnumeration       bool                 `bson:"anti_enumeration" json:"anti_enumeration"`     // login and registration answer alike whether or not an account exists
+	PlaygroundEnabled     bool                 `bson:"playground_enabled" json:"playground_enabled"` // serves /tenant/{id}/playground for trying sign-in without writing a client
+	PasswordBreach        PasswordBreachPolicy `bson:"password_breach" json:"password_breach"`
+}
+
+// PasswordBreachPolicy controls whether passwords found in known data breaches may be set when
+// users are created, register, are imported or set up a password. The zero value doesn't check.
+type PasswordBreachPolicy struct {
+	Mode           string `bson:"mode" json:"mode" validate:"oneof=off reject"`                        // "reject" refuses breached passwords
+	MinOccurrences int    `bson:"min_occurrences" json:"min_occurrences" validate:"min=0,max=1000000"` // times a password must have been seen in breaches to be refused (0 = once)
+	FailClosed     bool   `bson:"fail_closed" json:"fail_closed"`                                      // refuse passwords while no breach source answers
+}
+
+// DefaultScopeSets are the scopes given when none are set explicitly. Empty sets keep the platform
+// defaults.
+type DefaultScopeSets struct {
+	Registration []string `bson:"registration" json:"registration" validate:"max=50,dive,max=100"` // self-registered users and users created without scopes
+	Social       []string `bson:"social" json:"social" validate:"max=50,dive,max=100"`             // users provisioned by a social login
+	DirectLogin  []string `bson:"direct_login" json:"direct_login" validate:"max=50,dive,max=100"` // logins that don't request scopes (POST /login, social logins)
+}
+
+// ProvisioningPolicy controls just-in-time creation of users signing in through a social provider
+type ProvisioningPolicy struct {
+	Mode           string             `bson:"mode" json:"mode" validate:"oneof=auto disabled"`                     // "auto" (default) creates unknown users, "disabled" only lets existing users sign in
+	AllowedDomains []string           `bson:"allowed_domains" json:"allowed_domains" validate:"dive,hostname"`     // email domains allowed to sign in (empty = any)
+	DefaultGroups  []string           `bson:"default_groups" json:"default_groups" validate:"max=50"`              // group names or IDs of every provisioned user
+	DefaultScopes  []string           `bson:"default_scopes" json:"default_scopes" validate:"max=50,dive,max=100"` // deprecated: used when settings.default_scopes.social is empty
+	Rules          []ProvisioningRule `bson:"rules" json:"rules" validate:"max=50"`
+}
+
+// ProvisioningRule grants additional groups and scopes to provisioned users it matches. Empty
+// conditions match every user.
+type ProvisioningRule struct {
+	Provider      string   `bson:"provider,omitempty" json:"provider,omitempty"`             // e.g. "google"
+	EmailDomain   string   `bson:"email_domain,omitempty" json:"email_domain,omitempty"`     // e.g. "acme.com"
+	ExternalGroup string   `bson:"external_group,omitempty" json:"external_group,omitempty"` // group reported by the provider
+	Groups        []string `bson:"groups" json:"groups"`
+	Scopes        []string `bson:"scopes" json:"scopes"`
+}
+
+// FlowLifetimes overrides the platform lifetimes of login artifacts for a tenant. Zero keeps the
+// platform default.
+type FlowLifetimes struct {
+	AuthCodeSeconds         int `bson:"auth_code_seconds" json:"auth_code_seconds" validate:"min=30,max=1800"`
+	StateCookieSeconds      int `bson:"state_cookie_seconds" json:"state_cookie_seconds" validate:"min=60,max=3600"`             // social login state cookies
+	TwoFactorSessionSeconds int `bson:"two_factor_session_seconds" json:"two_factor_session_seconds" validate:"min=60,max=3600"` // pending two-factor verifications
+}
+
+// KeyRotationPolicy controls the scheduled rotation of a tenant's signing keys
+type KeyRotationPolicy struct {
+	IntervalDays     int `bson:"interval_days" json:"interval_days" validate:"min=0"`           // 0 = keys are only rotated on demand
+	GracePeriodHours int `bson:"grace_period_hours" json:"grace_period_hours" validate:"min=0"` // how long replaced keys stay published (0 = 24h)
+	PrepublishHours  int `bson:"prepublish_hours" json:"prepublish_hours" validate:"min=0"`     // how long next keys are published before activation (0 = 24h)
+}
+
+// TenantQuotas are the plan limits of a tenant. A limit of 0 means unlimited.
+type TenantQuotas struct {
+	MaxUsers          int `bson:"max_users" json:"max_users" validate:"min=0"`
+	MaxClients        int `bson:"max_clients" json:"max_clients" validate:"min=0"`
+	MaxTokensPerMonth int `bson:"max_tokens_per_month" json:"max_tokens_per_month" validate:"min=0"` // access tokens issued per calendar month (UTC)
+}
+
+// ReportSettings controls the scheduled summary reports emailed to tenant administrators
+type ReportSettings struct {
+	WeeklyEnabled bool     `bson:"weekly_enabled" json:"weekly_enabled"`
+	Recipients    []string `bson:"recipients" json:"recipients" validate:"dive,email"` // additional addresses besides the tenant's administrators
+}
+
+// ClientSecretPolicy controls how long client secrets stay valid within a tenant
+type ClientSecretPolicy struct {
+	MaxAgeDays         int `bson:"max_age_days" json:"max_age_days" validate:"min=0"`                 // 0 = secrets never expire
+	RotationGraceHours int `bson:"rotation_grace_hours" json:"rotation_grace_hours" validate:"min=0"` // how long the previous secret stays valid after rotation (0 = revoked immediately)
+	ExpiryWarningDays  int `bson:"expiry_warning_days" json:"expiry_warning_days" validate:"min=0"`   // notify this many days before expiry (0 = 14 days)
 }
 
 type TenantBranding struct {
-	LogoURL     string `bson:"logo_url" json:"logo_url"`
-	CompanyName string `bson:"company_name" json:"company_name"`
-	PrimaryColor string `bson:"primary_color" json:"primary_color"`
+	LogoURL        string `bson:"logo_url" json:"logo_url" validate:"url"`
+	CompanyName    string `bson:"company_name" json:"company_name"`
+	PrimaryColor   string `bson:"primary_color" json:"primary_color"`
 	SecondaryColor string `bson:"secondary_color" json:"secondary_color"`
 }
 
@@ -36,20 +133,52 @@ type User struct {
 	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	TenantID         string             `bson:"tenant_id" json:"tenant_id"`
 	Email            string             `bson:"email" json:"email"`
+	PendingEmail     string             `bson:"pending_email,omitempty" json:"pending_email,omitempty"`         // new address awaiting verification
+	LinkedEmails     []string           `bson:"linked_emails,omitempty" json:"linked_emails,omitempty"`         // addresses of merged accounts, matched on social login
+	ExternalGroups   []string           `bson:"external_groups,omitempty" json:"external_groups,omitempty"`     // groups reported by a social provider at the last login
+	SocialIdentities []SocialIdentity   `bson:"social_identities,omitempty" json:"social_identities,omitempty"` // provider accounts that sign in as the user
 	Username         string             `bson:"username" json:"username"`
+	Phone            string             `bson:"phone,omitempty" json:"phone,omitempty"` // E.164-style, e.g. +15551234567
+	PhoneVerified    bool               `bson:"phone_verified" json:"phone_verified"`   // the user proved ownership of the phone with an SMS code
 	PasswordHash     string             `bson:"password_hash" json:"-"`
 	FirstName        string             `bson:"first_name" json:"first_name"`
 	LastName         string             `bson:"last_name" json:"last_name"`
+	Picture          string             `bson:"picture,omitempty" json:"picture,omitempty"`   // profile picture URL
+	Locale           string             `bson:"locale,omitempty" json:"locale,omitempty"`     // BCP 47 language tag, e.g. de-AT
+	Zoneinfo         string             `bson:"zoneinfo,omitempty" json:"zoneinfo,omitempty"` // IANA time zone, e.g. Europe/Vienna
 	Groups           []string           `bson:"groups" json:"groups"`
+	GroupExpirations []GroupExpiration  `bson:"group_expirations,omitempty" json:"group_expirations,omitempty"` // time-bound memberships among Groups
 	Scopes           []string           `bson:"scopes" json:"scopes"`
-	Active           bool               `bson:"active" json:"active"`
+	Active           bool               `bson:"active" json:"active"`                                   // true exactly while Status is active
+	Status           string             `bson:"status,omitempty" json:"status,omitempty"`               // lifecycle state, see UserStatusActive
+	StatusReason     string             `bson:"status_reason,omitempty" json:"status_reason,omitempty"` // why the user entered the state
+	StatusChangedAt  *time.Time         `bson:"status_changed_at,omitempty" json:"status_changed_at,omitempty"`
+	StatusHistory    []UserStatusChange `bson:"status_history,omitempty" json:"status_history,omitempty"` // latest transitions, oldest first
 	TwoFactorEnabled bool               `bson:"two_factor_enabled" json:"two_factor_enabled"`
 	TwoFactorSecret  string             `bson:"two_factor_secret" json:"-"`
+	SMSTwoFactor     bool               `bson:"sms_two_factor" json:"sms_two_factor"` // SMS one-time codes are accepted as second factor
 	BackupCodes      []string           `bson:"backup_codes" json:"-"`
+	SearchValues     []string           `bson:"search_values,omitempty" json:"-"` // lowercased email, username and names, for indexed prefix search
+	SearchWords      []string           `bson:"search_words,omitempty" json:"-"`  // lowercased words of the same fields
+	Version          int64              `bson:"version" json:"version"`
 	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
+// SocialIdentity is an account at a social provider that signs in as the user, whatever its email
+type SocialIdentity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"subject"` // the provider's user ID
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// GroupExpiration ends a user's membership in a group; the scheduler removes the user then
+type GroupExpiration struct {
+	GroupID   string    `bson:"group_id" json:"group_id"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
 type Group struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	TenantID    string             `bson:"tenant_id" json:"tenant_id"`
@@ -57,23 +186,131 @@ type Group struct {
 	Description string             `bson:"description" json:"description"`
 	Scopes      []string           `bson:"scopes" json:"scopes"`
 	Members     []string           `bson:"members" json:"members"`
+	Owners      []string           `bson:"owners,omitempty" json:"owners,omitempty"`     // users reviewing the members in access reviews
+	Managers    []string           `bson:"managers,omitempty" json:"managers,omitempty"` // users adding and removing members and resetting their passwords
+	RequireMFA  bool               `bson:"require_mfa" json:"require_mfa"`               // members must use a second factor
+	Version     int64              `bson:"version" json:"version"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
+// GroupMapping maps a group or role reported by an upstream identity provider to a local group
+type GroupMapping struct {
+	External string `bson:"external" json:"external" validate:"required,max=256"` // upstream group name or ID
+	Group    string `bson:"group" json:"group" validate:"required,max=100"`       // local group name or ID
+}
+
 type Client struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	TenantID     string             `bson:"tenant_id" json:"tenant_id"`
-	ClientID     string             `bson:"client_id" json:"client_id"`
-	ClientSecret string             `bson:"client_secret" json:"-"`
-	Name         string             `bson:"name" json:"name"`
-	Description  string             `bson:"description" json:"description"`
-	RedirectURIs []string           `bson:"redirect_uris" json:"redirect_uris"`
-	Scopes       []string           `bson:"scopes" json:"scopes"`
-	GrantTypes   []string           `bson:"grant_types" json:"grant_types"`
-	Active       bool               `bson:"active" json:"active"`
-	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID        string             `bson:"tenant_id" json:"tenant_id"`
+	ClientID        string             `bson:"client_id" json:"client_id"`
+	ClientSecret    string             `bson:"client_secret" json:"-"`
+	ClientType      string             `bson:"client_type,omitempty" json:"client_type"` // confidential (default) or public
+	Name            string             `bson:"name" json:"name"`
+	Description     string             `bson:"description" json:"description"`
+	RedirectURIs    []string           `bson:"redirect_uris" json:"redirect_uris"`
+	Scopes          []string           `bson:"scopes" json:"scopes"`
+	GrantTypes      []string           `bson:"grant_types" json:"grant_types"`
+	Contacts        []string           `bson:"contacts" json:"contacts"` // emails notified about secret expiry
+	Active          bool               `bson:"active" json:"active"`
+	RequireMFA      bool               `bson:"require_mfa" json:"require_mfa"`             // every sign-in to the client needs a second factor
+	RequireS256PKCE bool               `bson:"require_s256_pkce" json:"require_s256_pkce"` // PKCE challenges must use S256, plain is refused
+	System          bool               `bson:"system" json:"system"`                       // built-in client of the server's own login flows, seeded per tenant
+
+	ClientMetadata `bson:",inline"`
+
+	// IDTokenRoles adds the user's scopes and group names to the client's ID tokens. Other
+	// clients only get the claims their granted scopes release.
+	IDTokenRoles bool `bson:"id_token_roles" json:"id_token_roles"`
+
+	// GroupsClaim keeps the groups claim of the client's ID tokens small for users in many groups
+	GroupsClaim GroupsClaimPolicy `bson:"groups_claim" json:"groups_claim"`
+
+	// Application assignment: when required, only the assigned users and members of the assigned
+	// groups (IDs) may sign in to the client
+	AssignmentRequired bool     `bson:"assignment_required" json:"assignment_required"`
+	AssignedGroups     []string `bson:"assigned_groups,omitempty" json:"assigned_groups,omitempty"`
+	AssignedUsers      []string `bson:"assigned_users,omitempty" json:"assigned_users,omitempty"`
+
+	RefreshTokenPolicy RefreshTokenPolicy `bson:"refresh_token_policy" json:"refresh_token_policy"`
+	RateLimits         ClientRateLimits   `bson:"rate_limits" json:"rate_limits"`
+
+	// Secret lifecycle: during a rotation window both the current and previous secret are accepted
+	ClientSecretExpiresAt   *time.Time `bson:"client_secret_expires_at,omitempty" json:"client_secret_expires_at,omitempty"`
+	PreviousClientSecret    string     `bson:"previous_client_secret,omitempty" json:"-"`
+	PreviousSecretExpiresAt *time.Time `bson:"previous_secret_expires_at,omitempty" json:"previous_secret_expires_at,omitempty"`
+	SecretRotatedAt         *time.Time `bson:"secret_rotated_at,omitempty" json:"secret_rotated_at,omitempty"`
+	SecretExpiryNotifiedAt  *time.Time `bson:"secret_expiry_notified_at,omitempty" json:"-"`
+
+	// Symmetric key (base64url) the client's HS256 ID tokens are signed with, created on first use
+	IDTokenSigningKey string `bson:"id_token_signing_key,omitempty" json:"-"`
+	// IDTokenSignedResponseAlg is the algorithm of the client's ID tokens (OIDC Registration
+	// section 2): HS256 (default) or RS256 and ES256, signed with the tenant's published keys
+	IDTokenSignedResponseAlg string `bson:"id_token_signed_response_alg,omitempty" json:"id_token_signed_response_alg,omitempty"`
+
+	Version   int64     `bson:"version" json:"version"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// GroupsClaimPolicy controls which groups a client's ID tokens carry. ID tokens larger than the
+// size budget carry a reference to the groups endpoint (an OpenID Connect distributed claim)
+// instead of the group names.
+type GroupsClaimPolicy struct {
+	Mode           string   `bson:"mode,omitempty" json:"mode,omitempty" validate:"oneof=all relevant omit reference"`     // all (default), relevant, omit or reference
+	RelevantGroups []string `bson:"relevant_groups,omitempty" json:"relevant_groups,omitempty"`                            // group IDs the relevant mode releases (empty = the assigned groups)
+	MaxTokenBytes  int      `bson:"max_token_bytes,omitempty" json:"max_token_bytes,omitempty" validate:"min=0,max=65536"` // ID token size budget (0 = 4096)
+}
+
+// ClientMetadata is what users are shown about a client on the consent screen (RFC 7591 section 2)
+type ClientMetadata struct {
+	LogoURI   string `bson:"logo_uri,omitempty" json:"logo_uri,omitempty" validate:"max=2048,weburl"`
+	ClientURI string `bson:"client_uri,omitempty" json:"client_uri,omitempty" validate:"max=2048,weburl"` // the application's home page
+	PolicyURI string `bson:"policy_uri,omitempty" json:"policy_uri,omitempty" validate:"max=2048,weburl"` // privacy policy
+	TOSURI    string `bson:"tos_uri,omitempty" json:"tos_uri,omitempty" validate:"max=2048,weburl"`       // terms of service
+}
+
+// Client types (RFC 6749 section 2.1). Public clients, such as single-page and native apps,
+// can't keep a secret: they get none and must use PKCE with S256 instead.
+const (
+	ClientTypeConfidential = "confidential"
+	ClientTypePublic       = "public"
+)
+
+// ID token signing algorithms. HS256 tokens are signed with the client's own key, RS256 and ES256
+// tokens with the tenant's RSA and ECDSA keys, which relying parties fetch from the JWKS.
+const (
+	IDTokenAlgHS256 = "HS256"
+	IDTokenAlgRS256 = "RS256"
+	IDTokenAlgES256 = "ES256"
+)
+
+// IsPublic reports whether the client is a public client. Clients stored before client types
+// existed are confidential.
+func (c *Client) IsPublic() bool {
+	return c.ClientType == ClientTypePublic
+}
+
+// Built-in system clients the server issues tokens to from its own login pages
+const (
+	SystemClientDirectLogin       = "direct-login-client"
+	SystemClientDirectSocialLogin = "direct-social-login"
+	SystemClientPlayground        = "playground-client"
+)
+
+// RefreshTokenPolicy controls refresh token behaviour for a client. Zero values keep the server defaults.
+type RefreshTokenPolicy struct {
+	RotateOnUse          bool `bson:"rotate_on_use" json:"rotate_on_use"`                                    // issue a new refresh token on every use
+	IdleTimeoutDays      int  `bson:"idle_timeout_days" json:"idle_timeout_days" validate:"min=0"`           // expire if unused for N days (0 = no idle timeout)
+	AbsoluteLifetimeDays int  `bson:"absolute_lifetime_days" json:"absolute_lifetime_days" validate:"min=0"` // maximum lifetime, not extended by rotation (0 = 30 days)
+	MaxSessionsPerUser   int  `bson:"max_sessions_per_user" json:"max_sessions_per_user" validate:"min=0"`   // oldest sessions are revoked beyond this (0 = unlimited)
+}
+
+// ClientRateLimits throttle a client at the token endpoint so a misbehaving integration can't
+// overload the server (0 = unlimited)
+type ClientRateLimits struct {
+	TokenRequestsPerMinute int `bson:"token_requests_per_minute" json:"token_requests_per_minute" validate:"min=0"` // answered with 429 beyond this
+	MaxActiveTokens        int `bson:"max_active_tokens" json:"max_active_tokens" validate:"min=0"`                 // unexpired, unrevoked access tokens
 }
 
 type AuthorizationCode struct {
@@ -86,21 +323,28 @@ type AuthorizationCode struct {
 	Scopes              []string           `bson:"scopes" json:"scopes"`
 	CodeChallenge       string             `bson:"code_challenge" json:"code_challenge"`
 	CodeChallengeMethod string             `bson:"code_challenge_method" json:"code_challenge_method"`
+	BindingMode         string             `bson:"binding_mode,omitempty" json:"-"`
+	BindingHash         string             `bson:"binding_hash,omitempty" json:"-"` // hash of the user agent/IP the code was issued to
+	ACR                 string             `bson:"acr,omitempty" json:"-"`          // authentication context class of the login
 	ExpiresAt           time.Time          `bson:"expires_at" json:"expires_at"`
 	Used                bool               `bson:"used" json:"used"`
 	CreatedAt           time.Time          `bson:"created_at" json:"created_at"`
 }
 
 type AccessToken struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	TenantID  string             `bson:"tenant_id" json:"tenant_id"`
-	Token     string             `bson:"token" json:"token"`
-	ClientID  string             `bson:"client_id" json:"client_id"`
-	UserID    string             `bson:"user_id" json:"user_id"`
-	Scopes    []string           `bson:"scopes" json:"scopes"`
-	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
-	Revoked   bool               `bson:"revoked" json:"revoked"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID  string               `bson:"tenant_id" json:"tenant_id"`
+	Token     string               `bson:"token" json:"token,omitempty"`
+	ClientID  string               `bson:"client_id" json:"client_id"`
+	UserID    string               `bson:"user_id" json:"user_id"`
+	Scopes    []string             `bson:"scopes" json:"scopes"`
+	Audience  []string             `bson:"audience,omitempty" json:"audience,omitempty"`
+	ParentID  *primitive.ObjectID  `bson:"parent_id,omitempty" json:"parent_id,omitempty"` // the token a vended token was narrowed from
+	Ancestors []primitive.ObjectID `bson:"ancestors,omitempty" json:"ancestors,omitempty"` // root first; revoking any of them revokes this token
+	Purpose   string               `bson:"purpose,omitempty" json:"purpose,omitempty"`     // what a vended token was minted for
+	ExpiresAt time.Time            `bson:"expires_at" json:"expires_at"`
+	Revoked   bool                 `bson:"revoked" json:"revoked"`
+	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
 }
 
 type RefreshToken struct {
@@ -111,8 +355,14 @@ type RefreshToken struct {
 	ClientID    string             `bson:"client_id" json:"client_id"`
 	UserID      string             `bson:"user_id" json:"user_id"`
 	Scopes      []string           `bson:"scopes" json:"scopes"`
+	FamilyID    string             `bson:"family_id" json:"family_id"`         // shared by all tokens produced by rotation of the same grant
+	ACR         string             `bson:"acr,omitempty" json:"acr,omitempty"` // authentication context class of the original login
 	ExpiresAt   time.Time          `bson:"expires_at" json:"expires_at"`
+	LastUsedAt  *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
 	Revoked     bool               `bson:"revoked" json:"revoked"`
+	RotatedAt   *time.Time         `bson:"rotated_at,omitempty" json:"rotated_at,omitempty"`
+	IPAddress   string             `bson:"ip_address,omitempty" json:"ip_address,omitempty"` // address of the last issue or use
+	UserAgent   string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 }
 
@@ -148,10 +398,20 @@ type SocialProvider struct {
 	ClientSecret string             `bson:"client_secret" json:"-"` // Hidden in JSON responses
 	RedirectURL  string             `bson:"redirect_url" json:"redirect_url"`
 	Enabled      bool               `bson:"enabled" json:"enabled"`
-	Scopes       []string           `bson:"scopes" json:"scopes"`
-	AuthURL      string             `bson:"auth_url" json:"auth_url"`
-	TokenURL     string             `bson:"token_url" json:"token_url"`
-	UserInfoURL  string             `bson:"user_info_url" json:"user_info_url"`
-	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
-}
\ No newline at end of file
+	UseGlobal    bool               `bson:"use_global" json:"use_global"` // sign in with the global catalog's shared credentials
+	// Microsoft: common, organizations, consumers or an Entra tenant ID/domain restricting sign-in
+	DirectoryTenant string `bson:"directory_tenant,omitempty" json:"directory_tenant,omitempty"`
+	// Microsoft: import the user's group memberships from Microsoft Graph on every login
+	SyncGroups bool `bson:"sync_groups" json:"sync_groups"`
+	// Userinfo claim holding the user's upstream groups or roles, e.g. "groups" or "roles"
+	GroupsClaim string `bson:"groups_claim,omitempty" json:"groups_claim,omitempty"`
+	// Upstream groups mapped to local groups on every login
+	GroupMappings       []GroupMapping `bson:"group_mappings" json:"group_mappings"`
+	CreateMissingGroups bool           `bson:"create_missing_groups" json:"create_missing_groups"` // create mapped local groups that don't exist
+	Scopes              []string       `bson:"scopes" json:"scopes"`
+	AuthURL             string         `bson:"auth_url" json:"auth_url"`
+	TokenURL            string         `bson:"token_url" json:"token_url"`
+	UserInfoURL         string         `bson:"user_info_url" json:"user_info_url"`
+	CreatedAt           time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt           time.Time      `bson:"updated_at" json:"updated_at"`
+}
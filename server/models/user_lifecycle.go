@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// User lifecycle states. Only active users can sign in or get tokens; User.Active mirrors
+// whether the state is UserStatusActive so existing queries keep working.
+const (
+	UserStatusPending       = "pending"       // created, e.g. invited, but not yet allowed to sign in
+	UserStatusActive        = "active"        // may sign in
+	UserStatusSuspended     = "suspended"     // temporarily locked out; can be reactivated
+	UserStatusDeprovisioned = "deprovisioned" // permanently locked out, credentials removed; kept for audit
+)
+
+// userStatusTransitions lists the states each state can move to
+var userStatusTransitions = map[string][]string{
+	UserStatusPending:       {UserStatusActive, UserStatusDeprovisioned},
+	UserStatusActive:        {UserStatusSuspended, UserStatusDeprovisioned},
+	UserStatusSuspended:     {UserStatusActive, UserStatusDeprovisioned},
+	UserStatusDeprovisioned: {},
+}
+
+// UserStatusChange records a lifecycle transition of a user
+type UserStatusChange struct {
+	From      string    `bson:"from" json:"from"`
+	To        string    `bson:"to" json:"to"`
+	Reason    string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	ActorID   string    `bson:"actor_id,omitempty" json:"actor_id,omitempty"` // who made the change, empty for the system
+	ChangedAt time.Time `bson:"changed_at" json:"changed_at"`
+}
+
+// LifecycleStatus returns the user's lifecycle state. Users stored before lifecycle states
+// existed are active or suspended according to their active flag.
+func (u *User) LifecycleStatus() string {
+	if u.Status != "" {
+		return u.Status
+	}
+	if u.Active {
+		return UserStatusActive
+	}
+	return UserStatusSuspended
+}
+
+// CanTransitionUserStatus reports whether a user in state from may move to state to
+func CanTransitionUserStatus(from, to string) bool {
+	for _, next := range userStatusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
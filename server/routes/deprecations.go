@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"time"
+
+	"oauth2-openid-server/services"
+)
+
+var (
+	// legacyDeprecatedSince is when the non-tenant routes were superseded by /tenant/{tenantId}/...
+	legacyDeprecatedSince = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	// legacySunset is when the non-tenant routes are scheduled to be removed
+	legacySunset = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// LegacyDeprecations lists the deprecated legacy routes and their tenant-scoped successors
+func LegacyDeprecations() []services.RouteDeprecation {
+	deprecation := func(path, successor string) services.RouteDeprecation {
+		return services.RouteDeprecation{
+			Path:      path,
+			Successor: successor,
+			Since:     legacyDeprecatedSince,
+			Sunset:    legacySunset,
+		}
+	}
+
+	return []services.RouteDeprecation{
+		deprecation("/oauth", "/tenant/{tenantId}/oauth"),
+		deprecation("/auth", "/tenant/{tenantId}/auth"),
+		deprecation("/login", "/tenant/{tenantId}/login"),
+		deprecation("/.well-known/openid-configuration", "/tenant/{tenantId}/.well-known/openid-configuration"),
+		deprecation("/.well-known/openid_configuration", "/tenant/{tenantId}/.well-known/openid-configuration"),
+		deprecation("/.well-known/oauth-authorization-server", "/.well-known/oauth-authorization-server/tenant/{tenantId}"),
+		deprecation("/.well-known/jwks.json", "/tenant/{tenantId}/.well-known/jwks.json"),
+	}
+}
+
+// legacyDeprecation returns the deprecation registered for a legacy path
+func legacyDeprecation(path string) services.RouteDeprecation {
+	for _, deprecation := range LegacyDeprecations() {
+		if deprecation.Path == path {
+			return deprecation
+		}
+	}
+	panic("routes: no deprecation registered for " + path)
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"oauth2-openid-server/autodiscovery"
 	"oauth2-openid-server/handlers"
@@ -24,6 +25,13 @@ type Dependencies struct {
 	SocialAuthService *services.SocialAuthService
 	TwoFactorService  *services.TwoFactorService
 	SetupService      *services.SetupService
+	ConsentService    *services.ConsentService
+	MembershipService *services.MembershipService
+
+	LegacyUsageService *services.LegacyUsageService
+	MaintenanceService *services.MaintenanceService
+	IdempotencyService *services.IdempotencyService
+	APIKeyService      *services.APIKeyService
 
 	// Handlers
 	AuthHandler         *handlers.AuthHandler
@@ -38,6 +46,36 @@ type Dependencies struct {
 	SetupHandler        *handlers.SetupHandler
 	AutodiscoveryHandler *autodiscovery.Handler
 	JWKSHandler         *handlers.JWKSHandler
+	CIBAHandler         *handlers.CIBAHandler
+	AuthorizeFlowHandler *handlers.AuthorizeFlowHandler
+	TranslationHandler  *handlers.TranslationHandler
+	ReportHandler       *handlers.ReportHandler
+	APIVersionHandler   *handlers.APIVersionHandler
+	QuotaHandler        *handlers.QuotaHandler
+	MeteringHandler     *handlers.MeteringHandler
+	KeyHandler          *handlers.KeyHandler
+	SessionHandler      *handlers.SessionHandler
+	EmailChangeHandler  *handlers.EmailChangeHandler
+	AccountLinkHandler  *handlers.AccountLinkHandler
+	PlaygroundHandler   *handlers.PlaygroundHandler
+	UserMergeHandler    *handlers.UserMergeHandler
+	UserLifecycleHandler *handlers.UserLifecycleHandler
+	UserBulkHandler      *handlers.UserBulkHandler
+	SocialCatalogHandler *handlers.SocialCatalogHandler
+	SMSOTPHandler       *handlers.SMSOTPHandler
+	AppPortalHandler    *handlers.AppPortalHandler
+	MaintenanceHandler  *handlers.MaintenanceHandler
+	FeatureFlagHandler  *handlers.FeatureFlagHandler
+	ConfigHandler       *handlers.ConfigHandler
+	TokenDebugHandler   *handlers.TokenDebugHandler
+	ConsentHandler      *handlers.ConsentHandler
+	WebFingerHandler    *handlers.WebFingerHandler
+	TenantDiscoveryHandler *handlers.TenantDiscoveryHandler
+	ClusterHandler      *handlers.ClusterHandler
+	JobHandler          *handlers.JobHandler
+	BotProtectionHandler *handlers.BotProtectionHandler
+	APIKeyHandler       *handlers.APIKeyHandler
+	AccessReviewHandler *handlers.AccessReviewHandler
 }
 
 // SetupRoutes configures all the routes for the application
@@ -57,6 +95,7 @@ func SetupRoutes(deps *Dependencies) *mux.Router {
 
 	// API routes with tenant middleware
 	setupAPIRoutes(router, deps)
+	setupAPIV2Routes(router, deps)
 
 	// Legacy routes (backwards compatibility) - before tenant routes
 	setupLegacyRoutes(router, deps)
@@ -69,30 +108,41 @@ func SetupRoutes(deps *Dependencies) *mux.Router {
 
 // setupWellKnownRoutes configures well-known endpoints (no middleware, public access)
 func setupWellKnownRoutes(router *mux.Router, deps *Dependencies) {
-	// OpenID Connect Discovery endpoints - must be accessible without authentication
-	router.HandleFunc("/.well-known/openid_configuration", deps.AutodiscoveryHandler.LegacyDiscoveryHandler).Methods("GET")
+	// OpenID Connect Discovery endpoints - must be accessible without authentication. The
+	// underscore paths predate the spec path and are kept as aliases.
+	router.Handle("/.well-known/openid-configuration", legacyHandler(deps, "/.well-known/openid-configuration", deps.AutodiscoveryHandler.LegacyDiscoveryHandler)).Methods("GET")
+	router.Handle("/.well-known/openid_configuration", legacyHandler(deps, "/.well-known/openid_configuration", deps.AutodiscoveryHandler.LegacyDiscoveryHandler)).Methods("GET")
 	
 	// Legacy JWKS endpoint
-	router.HandleFunc("/.well-known/jwks.json", deps.JWKSHandler.GetJWKS).Methods("GET")
+	router.Handle("/.well-known/jwks.json", legacyHandler(deps, "/.well-known/jwks.json", deps.JWKSHandler.GetJWKS)).Methods("GET")
 	
-	// Tenant-specific autodiscovery endpoints - New format: /.well-known/{tenant-id}/openid_configuration
-	router.HandleFunc("/.well-known/{tenantId}/openid_configuration", func(w http.ResponseWriter, r *http.Request) {
+	// Tenant-specific autodiscovery endpoints: {issuer}/.well-known/openid-configuration as OpenID
+	// Connect Discovery defines it, and the older /.well-known/{tenant-id}/openid_configuration
+	tenantDiscovery := deps.AutodiscoveryHandler.TenantDiscoveryHandler(func(r *http.Request) string {
 		// Extract tenant ID from URL path directly (no middleware needed)
-		vars := mux.Vars(r)
-		tenantID := vars["tenantId"]
-		
-		// Create a simple tenant ID getter
-		getTenantID := func(*http.Request) string {
-			return tenantID
-		}
-		
-		// Call the handler
-		handler := deps.AutodiscoveryHandler.TenantDiscoveryHandler(getTenantID)
-		handler(w, r)
-	}).Methods("GET")
+		return mux.Vars(r)["tenantId"]
+	})
+	router.HandleFunc("/tenant/{tenantId}/.well-known/openid-configuration", tenantDiscovery).Methods("GET")
+	router.HandleFunc("/.well-known/{tenantId}/openid_configuration", tenantDiscovery).Methods("GET")
 	
+	// OAuth 2.0 Authorization Server Metadata (RFC 8414). For tenant issuers the well-known suffix
+	// goes between the host and the issuer path; the tenant-prefixed path is kept for clients
+	// that expect it next to the issuer.
+	router.Handle("/.well-known/oauth-authorization-server", legacyHandler(deps, "/.well-known/oauth-authorization-server", deps.AutodiscoveryHandler.LegacyServerMetadataHandler)).Methods("GET")
+	tenantServerMetadata := deps.AutodiscoveryHandler.TenantServerMetadataHandler(func(r *http.Request) string {
+		return mux.Vars(r)["tenantId"]
+	})
+	router.HandleFunc("/.well-known/oauth-authorization-server/tenant/{tenantId}", tenantServerMetadata).Methods("GET")
+	router.HandleFunc("/tenant/{tenantId}/.well-known/oauth-authorization-server", tenantServerMetadata).Methods("GET")
+
 	// Tenant-specific JWKS endpoints
 	router.HandleFunc("/tenant/{tenantId}/.well-known/jwks.json", deps.JWKSHandler.GetJWKS).Methods("GET")
+
+	// WebFinger issuer discovery from a user's email address (OpenID Connect Discovery 1.0 section 2)
+	router.HandleFunc("/.well-known/webfinger", deps.WebFingerHandler.WebFinger).Methods("GET")
+
+	// Tenant discovery from a work email address for a login box shared by all tenants
+	router.HandleFunc("/tenant-discovery", deps.TenantDiscoveryHandler.DiscoverTenant).Methods("POST")
 }
 
 // setupSetupRoutes configures initial setup endpoints
@@ -102,10 +152,23 @@ func setupSetupRoutes(router *mux.Router, deps *Dependencies) {
 	router.HandleFunc("/api/setup/complete", deps.SetupHandler.PerformSetup).Methods("POST")
 }
 
+// setupAPIVersionRoutes configures API version discovery and legacy route usage endpoints
+func setupAPIVersionRoutes(api *mux.Router, deps *Dependencies) {
+	api.HandleFunc("/versions", deps.APIVersionHandler.GetVersions).Methods("GET")
+	api.HandleFunc("/legacy-usage", deps.APIVersionHandler.GetLegacyUsage).Methods("GET")
+}
+
 // setupAPIRoutes configures API v1 routes with tenant middleware
 func setupAPIRoutes(router *mux.Router, deps *Dependencies) {
 	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(middleware.RequireAuthentication(isPublicAPIRoute))
 	api.Use(middleware.TenantMiddleware(deps.TenantService))
+	api.Use(middleware.Authorization(deps.OAuthService, deps.TenantService))
+	api.Use(middleware.APIKeyAuthorization(deps.APIKeyService, deps.TenantService))
+	api.Use(middleware.APIVersion("v1"))
+
+	// API version discovery and deprecated route usage
+	setupAPIVersionRoutes(api, deps)
 
 	// Tenant management endpoints
 	setupTenantManagementRoutes(api, deps)
@@ -122,68 +185,244 @@ func setupAPIRoutes(router *mux.Router, deps *Dependencies) {
 	// Scope management endpoints
 	setupScopeManagementRoutes(api, deps)
 
+	// Short-lived elevated tokens for destructive requests
+	api.HandleFunc("/auth/elevate", deps.AuthHandler.Elevate).Methods("POST")
+
+	// Narrowed, short-lived child tokens of the caller's token, e.g. for batch jobs
+	api.HandleFunc("/tokens/vend", deps.AuthHandler.VendToken).Methods("POST")
+	api.HandleFunc("/tokens/vended", deps.AuthHandler.GetVendedTokens).Methods("GET")
+	api.HandleFunc("/tokens/vended/{id}/revoke", deps.AuthHandler.RevokeVendedToken).Methods("POST")
+
 	// Dashboard endpoints
 	api.HandleFunc("/dashboard/stats", deps.DashboardHandler.GetDashboardStats).Methods("GET")
+	api.HandleFunc("/dashboard/activity", deps.DashboardHandler.GetActivity).Methods("GET")
+	api.HandleFunc("/dashboard/export", deps.ReportHandler.ExportDashboard).Methods("GET")
+	api.HandleFunc("/audit/summary", deps.ReportHandler.GetAuditSummary).Methods("GET")
+	api.HandleFunc("/consents/export", deps.ConsentHandler.ExportConsents).Methods("GET")
+
+	// Quota usage of the current tenant
+	api.HandleFunc("/usage", deps.QuotaHandler.GetUsage).Methods("GET")
+
+	// Platform-wide maintenance mode (default tenant only)
+	api.HandleFunc("/maintenance", deps.MaintenanceHandler.GetGlobalMaintenance).Methods("GET")
+	api.HandleFunc("/maintenance", deps.MaintenanceHandler.UpdateGlobalMaintenance).Methods("PUT")
+
+	// Deployment-wide feature flags of new auth capabilities (platform operator only)
+	api.Handle("/feature-flags", platformOperator(http.HandlerFunc(deps.FeatureFlagHandler.GetDeploymentFlags))).Methods("GET")
+	api.Handle("/feature-flags/{name}", platformOperator(http.HandlerFunc(deps.FeatureFlagHandler.UpdateDeploymentFlag))).Methods("PUT")
+	api.Handle("/feature-flags/{name}", elevated(platformOperator(http.HandlerFunc(deps.FeatureFlagHandler.DeleteDeploymentFlag)))).Methods("DELETE")
+
+	// Cluster locks and event bus of multi-region deployments (platform operator only)
+	api.Handle("/cluster", platformOperator(http.HandlerFunc(deps.ClusterHandler.GetClusterStatus))).Methods("GET")
+	api.Handle("/cluster/load", platformOperator(http.HandlerFunc(deps.ClusterHandler.GetLoadStatus))).Methods("GET")
+
+	// Reload of the runtime-changeable server settings (default tenant only)
+	api.HandleFunc("/config/reload", deps.ConfigHandler.ReloadConfig).Methods("POST")
+
+	// Token inspection for support investigations (admin scope)
+	api.Handle("/debug/token", middleware.RequireScope(services.AdminScope)(http.HandlerFunc(deps.TokenDebugHandler.DebugToken))).Methods("POST")
+
+	// Background jobs of long-running operations
+	api.HandleFunc("/jobs", deps.JobHandler.GetJobs).Methods("GET")
+	api.HandleFunc("/jobs/{id}", deps.JobHandler.GetJob).Methods("GET")
+	api.Handle("/tokens/revoke", elevated(idempotent(deps, deps.JobHandler.RevokeTokens))).Methods("POST")
+
+	// Bot protection of login and registration: the public challenge and its admin configuration
+	setupBotProtectionRoutes(api, deps)
+
+	// API keys of integrations that don't use OAuth (admin scope)
+	apiKeyAdmin := middleware.RequireScope(services.AdminScope)
+	api.Handle("/api-keys", apiKeyAdmin(http.HandlerFunc(deps.APIKeyHandler.GetAPIKeys))).Methods("GET")
+	api.Handle("/api-keys", apiKeyAdmin(idempotent(deps, deps.APIKeyHandler.CreateAPIKey))).Methods("POST")
+	api.Handle("/api-keys/{id}", elevated(apiKeyAdmin(http.HandlerFunc(deps.APIKeyHandler.RevokeAPIKey)))).Methods("DELETE")
+
+	// Monthly metering rollups for billing
+	api.HandleFunc("/metering/rollups", deps.MeteringHandler.GetRollups).Methods("GET")
+
+	// Signing key management: changing the keys needs the admin scope
+	keyAdmin := middleware.RequireScope(services.AdminScope)
+	api.HandleFunc("/keys", deps.KeyHandler.ListKeys).Methods("GET")
+	api.Handle("/keys/rotate", elevated(keyAdmin(http.HandlerFunc(deps.KeyHandler.RotateKeys)))).Methods("POST")
+	api.Handle("/keys/canary/promote", elevated(keyAdmin(http.HandlerFunc(deps.KeyHandler.PromoteKeyCanary)))).Methods("POST")
+	api.Handle("/keys/canary/rollback", elevated(keyAdmin(http.HandlerFunc(deps.KeyHandler.RollbackKeyCanary)))).Methods("POST")
+	api.Handle("/keys/{kid}", elevated(keyAdmin(http.HandlerFunc(deps.KeyHandler.RevokeKey)))).Methods("DELETE")
 
 	// Two-factor authentication endpoints
 	setupTwoFactorRoutes(api, deps)
 
 	// Social provider management endpoints
 	setupSocialProviderRoutes(api, deps)
+
+	// Backchannel (CIBA) authentication approval endpoints
+	setupCIBARoutes(api, deps)
+
+	// Headless authorization flow endpoints (custom login/consent UIs)
+	setupAuthorizeFlowRoutes(api, deps)
+
+	// Localization endpoints
+	api.HandleFunc("/i18n/locales", deps.TranslationHandler.GetLocales).Methods("GET")
+	api.HandleFunc("/i18n/messages", deps.TranslationHandler.GetMessages).Methods("GET")
+}
+
+// setupAPIV2Routes configures the API v2 router. Endpoints are added here when they need
+// breaking changes; everything else stays on /api/v1.
+func setupAPIV2Routes(router *mux.Router, deps *Dependencies) {
+	api := router.PathPrefix("/api/v2").Subrouter()
+	api.Use(middleware.RequireAuthentication(isPublicAPIRoute))
+	api.Use(middleware.TenantMiddleware(deps.TenantService))
+	api.Use(middleware.Authorization(deps.OAuthService, deps.TenantService))
+	api.Use(middleware.APIKeyAuthorization(deps.APIKeyService, deps.TenantService))
+	api.Use(middleware.APIVersion("v2"))
+
+	setupAPIVersionRoutes(api, deps)
 }
 
 // setupTenantManagementRoutes configures tenant management endpoints
 func setupTenantManagementRoutes(api *mux.Router, deps *Dependencies) {
-	api.HandleFunc("/tenants", deps.TenantHandler.CreateTenant).Methods("POST")
-	api.HandleFunc("/tenants", deps.TenantHandler.GetTenants).Methods("GET")
-	api.HandleFunc("/tenants/{id}", deps.TenantHandler.GetTenant).Methods("GET")
-	api.HandleFunc("/tenants/{id}", deps.TenantHandler.UpdateTenant).Methods("PUT")
-	api.HandleFunc("/tenants/{id}", deps.TenantHandler.DeleteTenant).Methods("DELETE")
+	// Tenant lifecycle and cross-tenant views (platform operator only)
+	api.Handle("/tenants", platformOperator(idempotent(deps, deps.TenantHandler.CreateTenant))).Methods("POST")
+	api.Handle("/tenants", platformOperator(http.HandlerFunc(deps.TenantHandler.GetTenants))).Methods("GET")
+	api.Handle("/tenants/bootstrap", platformOperator(idempotent(deps, deps.TenantHandler.BootstrapTenant))).Methods("POST")
+	api.Handle("/tenants/usage", platformOperator(http.HandlerFunc(deps.QuotaHandler.GetAllTenantUsage))).Methods("GET")
+	api.Handle("/tenants/regions", platformOperator(http.HandlerFunc(deps.TenantHandler.GetRegions))).Methods("GET")
+	api.Handle("/tenants/{id}", elevated(platformOperator(http.HandlerFunc(deps.TenantHandler.DeleteTenant)))).Methods("DELETE")
+	api.Handle("/tenants/{id}/purge", elevated(platformOperator(idempotent(deps, deps.JobHandler.PurgeTenant)))).Methods("POST")
+	api.Handle("/tenants/{id}/feature-flags/{name}", platformOperator(http.HandlerFunc(deps.FeatureFlagHandler.UpdateTenantFlag))).Methods("PUT")
+	api.Handle("/tenants/{id}/feature-flags/{name}", elevated(platformOperator(http.HandlerFunc(deps.FeatureFlagHandler.DeleteTenantFlag)))).Methods("DELETE")
+
+	// Settings of a tenant (its admins or the platform operator)
+	api.Handle("/tenants/{id}", tenantAdmin(deps.TenantHandler.GetTenant)).Methods("GET")
+	api.Handle("/tenants/{id}", tenantAdmin(deps.TenantHandler.UpdateTenant)).Methods("PUT")
+	api.Handle("/tenants/{id}/usage", tenantAdmin(deps.QuotaHandler.GetTenantUsage)).Methods("GET")
+	api.Handle("/tenants/{id}/maintenance", tenantAdmin(deps.MaintenanceHandler.GetTenantMaintenance)).Methods("GET")
+	api.Handle("/tenants/{id}/maintenance", tenantAdmin(deps.MaintenanceHandler.UpdateTenantMaintenance)).Methods("PUT")
+	api.Handle("/tenants/{id}/feature-flags", tenantAdmin(deps.FeatureFlagHandler.GetTenantFlags)).Methods("GET")
+	api.Handle("/tenants/{id}/default-scopes", tenantAdmin(deps.TenantHandler.GetDefaultScopes)).Methods("GET")
+	api.Handle("/tenants/{id}/default-scopes", tenantAdmin(deps.TenantHandler.UpdateDefaultScopes)).Methods("PUT")
+	api.Handle("/tenants/{id}/storage", tenantAdmin(deps.TenantHandler.GetTenantStorage)).Methods("GET")
+	api.Handle("/tenants/{id}/storage", tenantAdmin(deps.TenantHandler.UpdateTenantStorage)).Methods("PUT")
+	api.Handle("/tenants/{id}/domains", tenantAdmin(deps.TenantDiscoveryHandler.GetTenantDomains)).Methods("GET")
+	api.Handle("/tenants/{id}/domains", tenantAdmin(deps.TenantDiscoveryHandler.AddTenantDomain)).Methods("POST")
+	api.Handle("/tenants/{id}/domains/{domain}/verify", tenantAdmin(deps.TenantDiscoveryHandler.VerifyTenantDomain)).Methods("POST")
+	api.Handle("/tenants/{id}/domains/{domain}", elevated(tenantAdmin(deps.TenantDiscoveryHandler.DeleteTenantDomain))).Methods("DELETE")
+	api.Handle("/tenants/{id}/settings/preview", tenantAdmin(deps.TenantHandler.PreviewSettings)).Methods("POST")
+	api.Handle("/tenants/{id}/settings/changes", tenantAdmin(deps.TenantHandler.GetSettingsChanges)).Methods("GET")
+	api.Handle("/tenants/{id}/settings/changes", tenantAdmin(deps.TenantHandler.ScheduleSettingsChange)).Methods("POST")
+	api.Handle("/tenants/{id}/settings/changes/{changeId}/cancel", tenantAdmin(deps.TenantHandler.CancelSettingsChange)).Methods("POST")
+	api.Handle("/tenants/{id}/settings/changes/{changeId}/rollback", tenantAdmin(deps.TenantHandler.RollbackSettingsChange)).Methods("POST")
+
+	// Tenant translation overrides
+	api.Handle("/tenants/{id}/translations", tenantAdmin(deps.TranslationHandler.GetTenantTranslations)).Methods("GET")
+	api.Handle("/tenants/{id}/translations/{locale}", middleware.RequireTenantAdmin(middleware.LimitBody(translationBodyLimit)(http.HandlerFunc(deps.TranslationHandler.UpdateTenantTranslations)))).Methods("PUT")
+	api.Handle("/tenants/{id}/translations/{locale}/{key}", elevated(tenantAdmin(deps.TranslationHandler.DeleteTenantTranslation))).Methods("DELETE")
 }
 
 // setupUserManagementRoutes configures user management endpoints
 func setupUserManagementRoutes(api *mux.Router, deps *Dependencies) {
-	api.HandleFunc("/users", deps.UserHandler.CreateUser).Methods("POST")
+	// Changing users needs the admin or user_management scope; group managers only reset the
+	// passwords of their members (below)
+	userAdmin := middleware.RequireUserAdministration
+	api.Handle("/users", userAdmin(idempotent(deps, deps.UserHandler.CreateUser))).Methods("POST")
 	api.HandleFunc("/users", deps.UserHandler.GetUsers).Methods("GET")
+	api.Handle("/users/import", userAdmin(middleware.LimitBody(importBodyLimit)(idempotent(deps, deps.JobHandler.ImportUsers)))).Methods("POST")
+	api.Handle("/users/bulk", elevated(userAdmin(idempotent(deps, deps.UserBulkHandler.BulkUpdateUsers)))).Methods("POST")
 	api.HandleFunc("/users/me", deps.UserHandler.GetCurrentUser).Methods("GET")
+	setupSelfServiceRoutes(api, deps)
+	api.HandleFunc("/users/search", deps.UserHandler.SearchUsers).Methods("GET")
 	api.HandleFunc("/users/{id}", deps.UserHandler.GetUser).Methods("GET")
-	api.HandleFunc("/users/{id}", deps.UserHandler.UpdateUser).Methods("PUT")
-	api.HandleFunc("/users/{id}", deps.UserHandler.DeleteUser).Methods("DELETE")
+	api.Handle("/users/{id}", userAdmin(http.HandlerFunc(deps.UserHandler.UpdateUser))).Methods("PUT")
+	api.Handle("/users/{id}", elevated(userAdmin(http.HandlerFunc(deps.UserHandler.DeleteUser)))).Methods("DELETE")
+	api.Handle("/users/{id}/merge", elevated(userAdmin(http.HandlerFunc(deps.UserMergeHandler.MergeUser)))).Methods("POST")
+	api.Handle("/users/{id}/suspend", userAdmin(http.HandlerFunc(deps.UserLifecycleHandler.SuspendUser))).Methods("POST")
+	api.Handle("/users/{id}/reactivate", userAdmin(http.HandlerFunc(deps.UserLifecycleHandler.ReactivateUser))).Methods("POST")
+	api.Handle("/users/{id}/deprovision", elevated(userAdmin(http.HandlerFunc(deps.UserLifecycleHandler.DeprovisionUser)))).Methods("POST")
+
+	// Forced password reset: user administrators with an elevated token, and group managers for the
+	// members of their groups
+	userManager := middleware.RequireUserManager(deps.MembershipService)
+	api.Handle("/users/{id}/password-reset", userManager(middleware.RequireAdministratorElevation(http.HandlerFunc(deps.AccountLinkHandler.ResetUserPassword)))).Methods("POST")
 
 	// Public user registration endpoint (tenant-scoped but no auth required)
 	api.HandleFunc("/register", deps.UserHandler.RegisterUser).Methods("POST")
 }
 
+// setupSelfServiceRoutes configures the signed-in user's session, login history, consent, email
+// change and login method endpoints
+func setupSelfServiceRoutes(api *mux.Router, deps *Dependencies) {
+	api.HandleFunc("/session/heartbeat", deps.SessionHandler.Heartbeat).Methods("GET")
+	api.HandleFunc("/session/logout", deps.SessionHandler.Logout).Methods("POST")
+	api.HandleFunc("/users/me/sessions", deps.SessionHandler.GetMySessions).Methods("GET")
+	api.HandleFunc("/users/me/sessions/{sessionId}", deps.SessionHandler.RevokeMySession).Methods("DELETE")
+	api.HandleFunc("/users/me/logins", deps.SessionHandler.GetMyLogins).Methods("GET")
+	api.HandleFunc("/users/me/applications", deps.AppPortalHandler.GetMyApplications).Methods("GET")
+	api.HandleFunc("/users/me/applications/{clientId}/launch", deps.AppPortalHandler.CreateLaunchLink).Methods("POST")
+	api.HandleFunc("/users/me/consents/{clientId}", deps.ConsentHandler.WithdrawMyConsent).Methods("DELETE")
+	api.HandleFunc("/users/me/email", deps.EmailChangeHandler.RequestMyEmailChange).Methods("POST")
+	api.HandleFunc("/users/me/profile", deps.UserHandler.UpdateMyProfile).Methods("PATCH")
+	api.HandleFunc("/users/me/groups", deps.UserHandler.GetMyGroups).Methods("GET")
+	api.HandleFunc("/users/email-change/verify", deps.EmailChangeHandler.VerifyEmailChange).Methods("POST")
+	api.HandleFunc("/users/me/phone/verification", deps.SMSOTPHandler.SendMyPhoneVerification).Methods("POST")
+	api.HandleFunc("/users/me/phone/verify", deps.SMSOTPHandler.VerifyMyPhone).Methods("POST")
+	api.HandleFunc("/users/me/login-methods", deps.AccountLinkHandler.GetMyLoginMethods).Methods("GET")
+	api.HandleFunc("/users/me/password/setup", deps.AccountLinkHandler.RequestMyPasswordSetup).Methods("POST")
+	api.HandleFunc("/users/password-setup/complete", deps.AccountLinkHandler.CompletePasswordSetup).Methods("POST")
+	api.HandleFunc("/users/me/social/{provider}/link", deps.SocialAuthHandler.LinkMySocialIdentity).Methods("POST")
+}
+
 // setupGroupManagementRoutes configures group management endpoints
 func setupGroupManagementRoutes(api *mux.Router, deps *Dependencies) {
-	api.HandleFunc("/groups", deps.GroupHandler.CreateGroup).Methods("POST")
+	// User administrators change groups and their managers; group managers only change the members
+	// of their groups
+	userAdmin := middleware.RequireUserAdministration
+	groupManager := middleware.RequireGroupManager(deps.MembershipService)
+	api.Handle("/groups", userAdmin(idempotent(deps, deps.GroupHandler.CreateGroup))).Methods("POST")
 	api.HandleFunc("/groups", deps.GroupHandler.GetGroups).Methods("GET")
+	api.Handle("/groups/reconcile", userAdmin(http.HandlerFunc(deps.GroupHandler.ReconcileMemberships))).Methods("POST")
 	api.HandleFunc("/groups/{id}", deps.GroupHandler.GetGroup).Methods("GET")
-	api.HandleFunc("/groups/{id}", deps.GroupHandler.UpdateGroup).Methods("PUT")
-	api.HandleFunc("/groups/{id}", deps.GroupHandler.DeleteGroup).Methods("DELETE")
-	api.HandleFunc("/groups/{id}/members", deps.GroupHandler.AddMember).Methods("POST")
-	api.HandleFunc("/groups/{id}/members/{userId}", deps.GroupHandler.RemoveMember).Methods("DELETE")
+	api.Handle("/groups/{id}", userAdmin(http.HandlerFunc(deps.GroupHandler.UpdateGroup))).Methods("PUT")
+	api.Handle("/groups/{id}", elevated(userAdmin(http.HandlerFunc(deps.GroupHandler.DeleteGroup)))).Methods("DELETE")
+	api.Handle("/groups/{id}/members", groupManager(idempotent(deps, deps.GroupHandler.AddMember))).Methods("POST")
+	api.Handle("/groups/{id}/members/{userId}", groupManager(middleware.RequireAdministratorElevation(http.HandlerFunc(deps.GroupHandler.RemoveMember)))).Methods("DELETE")
 	api.HandleFunc("/users/{userId}/groups", deps.GroupHandler.GetUserGroups).Methods("GET")
+
+	// Access reviews: admins run campaigns, group owners decide on the memberships
+	admin := middleware.RequireScope(services.AdminScope)
+	api.Handle("/access-reviews", admin(idempotent(deps, deps.AccessReviewHandler.StartAccessReview))).Methods("POST")
+	api.Handle("/access-reviews", admin(http.HandlerFunc(deps.AccessReviewHandler.GetAccessReviews))).Methods("GET")
+	api.HandleFunc("/access-reviews/pending", deps.AccessReviewHandler.GetPendingAccessReviews).Methods("GET")
+	api.Handle("/access-reviews/{id}", admin(http.HandlerFunc(deps.AccessReviewHandler.GetAccessReview))).Methods("GET")
+	api.Handle("/access-reviews/{id}/apply", admin(http.HandlerFunc(deps.AccessReviewHandler.ApplyAccessReview))).Methods("POST")
+	api.HandleFunc("/access-reviews/{id}/items/{itemId}/decision", deps.AccessReviewHandler.DecideAccessReviewItem).Methods("POST")
 }
 
 // setupClientManagementRoutes configures OAuth client management endpoints
 func setupClientManagementRoutes(api *mux.Router, deps *Dependencies) {
-	api.HandleFunc("/clients", deps.ClientHandler.CreateClient).Methods("POST")
+	// Only admins change clients
+	admin := middleware.RequireScope(services.AdminScope)
+	api.Handle("/clients", admin(idempotent(deps, deps.ClientHandler.CreateClient))).Methods("POST")
 	api.HandleFunc("/clients", deps.ClientHandler.GetClients).Methods("GET")
 	api.HandleFunc("/clients/{id}", deps.ClientHandler.GetClient).Methods("GET")
-	api.HandleFunc("/clients/{id}", deps.ClientHandler.UpdateClient).Methods("PUT")
-	api.HandleFunc("/clients/{id}", deps.ClientHandler.DeleteClient).Methods("DELETE")
-	api.HandleFunc("/clients/{id}/activate", deps.ClientHandler.ActivateClient).Methods("PATCH")
-	api.HandleFunc("/clients/{id}/deactivate", deps.ClientHandler.DeactivateClient).Methods("PATCH")
-	api.HandleFunc("/clients/{id}/regenerate-secret", deps.ClientHandler.RegenerateSecret).Methods("POST")
+	api.Handle("/clients/{id}", admin(http.HandlerFunc(deps.ClientHandler.UpdateClient))).Methods("PUT")
+	api.Handle("/clients/{id}", elevated(admin(http.HandlerFunc(deps.ClientHandler.DeleteClient)))).Methods("DELETE")
+	api.Handle("/clients/{id}/activate", admin(http.HandlerFunc(deps.ClientHandler.ActivateClient))).Methods("PATCH")
+	api.Handle("/clients/{id}/deactivate", admin(http.HandlerFunc(deps.ClientHandler.DeactivateClient))).Methods("PATCH")
+	api.Handle("/clients/{id}/regenerate-secret", elevated(admin(http.HandlerFunc(deps.ClientHandler.RegenerateSecret)))).Methods("POST")
+	api.HandleFunc("/clients/{id}/metrics", deps.ClientHandler.GetClientMetrics).Methods("GET")
+
+	// Assignments decide who may sign in to a client, so only admins see them too
+	api.Handle("/clients/{id}/assignments", admin(http.HandlerFunc(deps.ClientHandler.GetAssignments))).Methods("GET")
+	api.Handle("/clients/{id}/assignments", admin(idempotent(deps, deps.ClientHandler.AddAssignment))).Methods("POST")
+	api.Handle("/clients/{id}/assignments/{type}/{principalId}", elevated(admin(http.HandlerFunc(deps.ClientHandler.RemoveAssignment)))).Methods("DELETE")
 }
 
 // setupScopeManagementRoutes configures scope management endpoints
 func setupScopeManagementRoutes(api *mux.Router, deps *Dependencies) {
+	admin := middleware.RequireScope(services.AdminScope)
 	api.HandleFunc("/scopes", deps.ScopeHandler.GetAllScopes).Methods("GET")
-	api.HandleFunc("/scopes", deps.ScopeHandler.CreateScope).Methods("POST")
-	api.HandleFunc("/scopes/{id}", deps.ScopeHandler.UpdateScope).Methods("PUT")
-	api.HandleFunc("/scopes/{id}", deps.ScopeHandler.DeleteScope).Methods("DELETE")
+	api.Handle("/scopes", admin(idempotent(deps, deps.ScopeHandler.CreateScope))).Methods("POST")
+	api.HandleFunc("/scopes/usage", deps.ScopeHandler.GetScopeUsage).Methods("GET")
+	api.Handle("/scopes/{id}", admin(http.HandlerFunc(deps.ScopeHandler.UpdateScope))).Methods("PUT")
+	api.Handle("/scopes/{id}", elevated(admin(http.HandlerFunc(deps.ScopeHandler.DeleteScope)))).Methods("DELETE")
 	api.HandleFunc("/scopes/{id}", deps.ScopeHandler.HandleOptions).Methods("OPTIONS")
 }
 
@@ -195,13 +434,52 @@ func setupTwoFactorRoutes(api *mux.Router, deps *Dependencies) {
 	api.HandleFunc("/2fa/verify", deps.TwoFactorHandler.VerifyTwoFactor).Methods("POST")
 	api.HandleFunc("/2fa/verify-session", deps.TwoFactorHandler.VerifySession).Methods("POST")
 	api.HandleFunc("/2fa/status", deps.TwoFactorHandler.GetTwoFactorStatus).Methods("GET")
+	api.HandleFunc("/2fa/sms/enable", deps.SMSOTPHandler.EnableSMSTwoFactor).Methods("POST")
+	api.HandleFunc("/2fa/sms/disable", deps.SMSOTPHandler.DisableSMSTwoFactor).Methods("POST")
+	api.HandleFunc("/2fa/sms/send", deps.SMSOTPHandler.SendSMSCode).Methods("POST")
 }
 
 // setupSocialProviderRoutes configures social provider management endpoints
 func setupSocialProviderRoutes(api *mux.Router, deps *Dependencies) {
+	// Only admins configure providers
+	admin := middleware.RequireScope(services.AdminScope)
 	api.HandleFunc("/social/providers", deps.SocialAuthHandler.GetProviderConfigs).Methods("GET")
-	api.HandleFunc("/social/providers/{provider}", deps.SocialAuthHandler.UpdateProviderConfig).Methods("PUT")
-	api.HandleFunc("/social/providers/{provider}/test", deps.SocialAuthHandler.TestProviderConfig).Methods("POST")
+	api.HandleFunc("/social/providers/health", deps.SocialAuthHandler.GetProviderHealth).Methods("GET")
+	api.Handle("/social/providers/{provider}", admin(http.HandlerFunc(deps.SocialAuthHandler.UpdateProviderConfig))).Methods("PUT")
+	api.Handle("/social/providers/{provider}/test", admin(http.HandlerFunc(deps.SocialAuthHandler.TestProviderConfig))).Methods("POST")
+	api.HandleFunc("/social/providers/{provider}/group-mappings", deps.SocialAuthHandler.GetGroupMappings).Methods("GET")
+	api.Handle("/social/providers/{provider}/group-mappings", admin(http.HandlerFunc(deps.SocialAuthHandler.UpdateGroupMappings))).Methods("PUT")
+	api.HandleFunc("/social/catalog", deps.SocialCatalogHandler.GetCatalog).Methods("GET")
+	api.Handle("/social/catalog/{provider}", admin(http.HandlerFunc(deps.SocialCatalogHandler.UpdateCatalogProvider))).Methods("PUT")
+}
+
+// setupCIBARoutes configures endpoints used by users to answer backchannel authentication requests
+func setupCIBARoutes(api *mux.Router, deps *Dependencies) {
+	api.HandleFunc("/ciba/requests", deps.CIBAHandler.GetPendingRequests).Methods("GET")
+	api.HandleFunc("/ciba/requests/{authReqId}/approve", deps.CIBAHandler.ApproveRequest).Methods("POST")
+	api.HandleFunc("/ciba/requests/{authReqId}/deny", deps.CIBAHandler.DenyRequest).Methods("POST")
+}
+
+// setupAuthorizeFlowRoutes configures the JSON step-driven authorization flow endpoints
+func setupAuthorizeFlowRoutes(api *mux.Router, deps *Dependencies) {
+	api.Handle("/authorize/flows", loginHandler(deps, deps.AuthorizeFlowHandler.StartFlow)).Methods("POST")
+	api.HandleFunc("/authorize/flows/{flowId}", deps.AuthorizeFlowHandler.GetFlow).Methods("GET")
+	api.Handle("/authorize/flows/{flowId}/credentials", loginHandler(deps, deps.AuthorizeFlowHandler.SubmitCredentials)).Methods("POST")
+	api.Handle("/authorize/flows/{flowId}/two-factor", loginHandler(deps, deps.AuthorizeFlowHandler.SubmitTwoFactor)).Methods("POST")
+	api.Handle("/authorize/flows/{flowId}/two-factor/sms", loginHandler(deps, deps.AuthorizeFlowHandler.SendTwoFactorSMS)).Methods("POST")
+	api.Handle("/authorize/flows/{flowId}/consent", loginHandler(deps, deps.AuthorizeFlowHandler.SubmitConsent)).Methods("POST")
+}
+
+// setupBotProtectionRoutes configures the bot protection challenge and the admin endpoints of the
+// tenant's policy and bypass keys
+func setupBotProtectionRoutes(api *mux.Router, deps *Dependencies) {
+	admin := middleware.RequireScope(services.AdminScope)
+	api.HandleFunc("/bot-protection/challenge", deps.BotProtectionHandler.GetChallenge).Methods("GET")
+	api.Handle("/bot-protection", admin(http.HandlerFunc(deps.BotProtectionHandler.GetPolicy))).Methods("GET")
+	api.Handle("/bot-protection", admin(http.HandlerFunc(deps.BotProtectionHandler.UpdatePolicy))).Methods("PUT")
+	api.Handle("/bot-protection/bypass-keys", admin(http.HandlerFunc(deps.BotProtectionHandler.GetBypassKeys))).Methods("GET")
+	api.Handle("/bot-protection/bypass-keys", admin(http.HandlerFunc(deps.BotProtectionHandler.CreateBypassKey))).Methods("POST")
+	api.Handle("/bot-protection/bypass-keys/{id}", elevated(admin(http.HandlerFunc(deps.BotProtectionHandler.DeleteBypassKey)))).Methods("DELETE")
 }
 
 // setupTenantRoutes configures tenant-specific routes
@@ -216,11 +494,17 @@ func setupTenantRoutes(router *mux.Router, deps *Dependencies) {
 	setupTenantSocialAuthRoutes(tenantRouter, deps)
 
 	// Direct login route for specific tenant
-	tenantRouter.HandleFunc("/login", deps.AuthHandler.Login).Methods("POST")
+	tenantRouter.Handle("/login", loginHandler(deps, deps.AuthHandler.Login)).Methods("POST")
 
 	// Registration route for specific tenant
 	tenantRouter.HandleFunc("/register", deps.UserHandler.RegisterUser).Methods("POST")
 
+	// Sign-in playground, served when the tenant enables it
+	tenantRouter.HandleFunc("/playground", deps.PlaygroundHandler.ShowPlayground).Methods("GET")
+
+	// IdP-initiated sign-in into an application through a signed launch link
+	tenantRouter.HandleFunc("/launch", deps.AppPortalHandler.LaunchApplication).Methods("GET")
+
 	// API routes for specific tenant (needed for UserInfo endpoint)
 	setupTenantAPIRoutes(tenantRouter, deps)
 }
@@ -228,9 +512,18 @@ func setupTenantRoutes(router *mux.Router, deps *Dependencies) {
 // setupTenantAPIRoutes configures tenant-specific API routes
 func setupTenantAPIRoutes(tenantRouter *mux.Router, deps *Dependencies) {
 	tenantAPI := tenantRouter.PathPrefix("/api/v1").Subrouter()
+	tenantAPI.Use(middleware.RequireAuthentication(isPublicAPIRoute))
+	tenantAPI.Use(middleware.Authorization(deps.OAuthService, deps.TenantService))
+	tenantAPI.Use(middleware.APIKeyAuthorization(deps.APIKeyService, deps.TenantService))
 	
 	// UserInfo endpoint for OpenID Connect (required by Gitea)
 	tenantAPI.HandleFunc("/users/me", deps.UserHandler.GetCurrentUser).Methods("GET")
+	setupSelfServiceRoutes(tenantAPI, deps)
+
+	// Headless authorization flow for tenant-branded login UIs
+	setupAuthorizeFlowRoutes(tenantAPI, deps)
+	tenantAPI.HandleFunc("/bot-protection/challenge", deps.BotProtectionHandler.GetChallenge).Methods("GET")
+	tenantAPI.HandleFunc("/i18n/messages", deps.TranslationHandler.GetMessages).Methods("GET")
 }
 
 // setupTenantOAuthRoutes configures tenant-specific OAuth routes
@@ -250,8 +543,11 @@ func setupTenantOAuthRoutes(tenantRouter *mux.Router, deps *Dependencies) {
 		json.NewEncoder(w).Encode(response)
 	}).Methods("GET")
 	
-	tenantOAuth.HandleFunc("/authorize", deps.AuthHandler.Authorize).Methods("GET", "POST")
-	tenantOAuth.HandleFunc("/token", deps.AuthHandler.Token).Methods("POST")
+	tenantOAuth.Handle("/authorize", loginHandler(deps, deps.AuthHandler.Authorize)).Methods("GET", "POST")
+	tenantOAuth.Handle("/token", loginHandler(deps, deps.AuthHandler.Token)).Methods("POST")
+	tenantOAuth.HandleFunc("/signing-key", deps.AuthHandler.ClientSigningKey).Methods("POST")
+	tenantOAuth.HandleFunc("/key-feedback", deps.KeyHandler.ReportValidationFailure).Methods("POST")
+	tenantOAuth.Handle("/bc-authorize", loginHandler(deps, deps.CIBAHandler.BackchannelAuthorize)).Methods("POST")
 }
 
 // setupTenantSocialAuthRoutes configures tenant-specific social authentication routes
@@ -274,9 +570,9 @@ func setupTenantSocialAuthRoutes(tenantRouter *mux.Router, deps *Dependencies) {
 	tenantAuth.HandleFunc("/providers/config", deps.SocialAuthHandler.GetProviderConfigs).Methods("GET")
 	tenantAuth.HandleFunc("/providers/{provider}/config", deps.SocialAuthHandler.UpdateProviderConfig).Methods("PUT")
 	tenantAuth.HandleFunc("/providers/{provider}/test", deps.SocialAuthHandler.TestProviderConfig).Methods("POST")
-	tenantAuth.HandleFunc("/{provider}/login", deps.SocialAuthHandler.InitiateSocialLogin).Methods("GET")
-	tenantAuth.HandleFunc("/{provider}/callback", deps.SocialAuthHandler.HandleSocialCallback).Methods("GET")
-	tenantAuth.HandleFunc("/{provider}/oauth", deps.SocialAuthHandler.SocialOAuthAuthorize).Methods("GET")
+	tenantAuth.Handle("/{provider}/login", loginHandler(deps, deps.SocialAuthHandler.InitiateSocialLogin)).Methods("GET")
+	tenantAuth.Handle("/{provider}/callback", loginHandler(deps, deps.SocialAuthHandler.HandleSocialCallback)).Methods("GET")
+	tenantAuth.Handle("/{provider}/oauth", loginHandler(deps, deps.SocialAuthHandler.SocialOAuthAuthorize)).Methods("GET")
 }
 
 // setupLegacyRoutes configures legacy routes for backwards compatibility
@@ -295,6 +591,7 @@ func setupLegacyRoutes(router *mux.Router, deps *Dependencies) {
 func setupLegacyOAuthRoutes(router *mux.Router, deps *Dependencies) {
 	oauth := router.PathPrefix("/oauth").Subrouter()
 	oauth.Use(middleware.TenantMiddleware(deps.TenantService))
+	oauth.Use(middleware.Deprecated(legacyDeprecation("/oauth"), deps.LegacyUsageService))
 	
 	oauth.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -309,14 +606,18 @@ func setupLegacyOAuthRoutes(router *mux.Router, deps *Dependencies) {
 		json.NewEncoder(w).Encode(response)
 	}).Methods("GET")
 	
-	oauth.HandleFunc("/authorize", deps.AuthHandler.Authorize).Methods("GET", "POST")
-	oauth.HandleFunc("/token", deps.AuthHandler.Token).Methods("POST")
+	oauth.Handle("/authorize", loginHandler(deps, deps.AuthHandler.Authorize)).Methods("GET", "POST")
+	oauth.Handle("/token", loginHandler(deps, deps.AuthHandler.Token)).Methods("POST")
+	oauth.HandleFunc("/signing-key", deps.AuthHandler.ClientSigningKey).Methods("POST")
+	oauth.HandleFunc("/key-feedback", deps.KeyHandler.ReportValidationFailure).Methods("POST")
+	oauth.Handle("/bc-authorize", loginHandler(deps, deps.CIBAHandler.BackchannelAuthorize)).Methods("POST")
 }
 
 // setupLegacySocialAuthRoutes configures legacy social authentication routes
 func setupLegacySocialAuthRoutes(router *mux.Router, deps *Dependencies) {
 	auth := router.PathPrefix("/auth").Subrouter()
 	auth.Use(middleware.TenantMiddleware(deps.TenantService))
+	auth.Use(middleware.Deprecated(legacyDeprecation("/auth"), deps.LegacyUsageService))
 	
 	auth.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -334,16 +635,101 @@ func setupLegacySocialAuthRoutes(router *mux.Router, deps *Dependencies) {
 	auth.HandleFunc("/providers/config", deps.SocialAuthHandler.GetProviderConfigs).Methods("GET")
 	auth.HandleFunc("/providers/{provider}/config", deps.SocialAuthHandler.UpdateProviderConfig).Methods("PUT")
 	auth.HandleFunc("/providers/{provider}/test", deps.SocialAuthHandler.TestProviderConfig).Methods("POST")
-	auth.HandleFunc("/{provider}/login", deps.SocialAuthHandler.InitiateSocialLogin).Methods("GET")
-	auth.HandleFunc("/{provider}/callback", deps.SocialAuthHandler.HandleSocialCallback).Methods("GET")
-	auth.HandleFunc("/{provider}/oauth", deps.SocialAuthHandler.SocialOAuthAuthorize).Methods("GET")
+	auth.Handle("/{provider}/login", loginHandler(deps, deps.SocialAuthHandler.InitiateSocialLogin)).Methods("GET")
+	auth.Handle("/{provider}/callback", loginHandler(deps, deps.SocialAuthHandler.HandleSocialCallback)).Methods("GET")
+	auth.Handle("/{provider}/oauth", loginHandler(deps, deps.SocialAuthHandler.SocialOAuthAuthorize)).Methods("GET")
 }
 
 // setupLegacyLoginRoutes configures legacy login routes
 func setupLegacyLoginRoutes(router *mux.Router, deps *Dependencies) {
 	loginRouter := router.PathPrefix("/login").Subrouter()
 	loginRouter.Use(middleware.TenantMiddleware(deps.TenantService))
-	loginRouter.HandleFunc("", deps.AuthHandler.Login).Methods("POST")
+	loginRouter.Use(middleware.Deprecated(legacyDeprecation("/login"), deps.LegacyUsageService))
+	loginRouter.Handle("", loginHandler(deps, deps.AuthHandler.Login)).Methods("POST")
+}
+
+const (
+	// loginBodyLimit caps the bodies of unauthenticated sign-in and token requests
+	loginBodyLimit int64 = 16 << 10
+	// translationBodyLimit leaves room for a full locale of message overrides
+	translationBodyLimit int64 = 256 << 10
+	// importBodyLimit leaves room for the largest bulk user import
+	importBodyLimit int64 = 8 << 20
+)
+
+// publicAPIRoutes are the API endpoints anonymous callers may use, by method and path below the
+// API version: sign-up, the sign-in flow of login UIs and the links users open from emails. All
+// other API endpoints answer 401 without a valid bearer token or API key.
+var publicAPIRoutes = map[string]bool{
+	"GET /versions":                                 true,
+	"POST /register":                                true,
+	"GET /bot-protection/challenge":                 true,
+	"GET /i18n/locales":                             true,
+	"GET /i18n/messages":                            true,
+	"POST /users/email-change/verify":               true,
+	"POST /users/password-setup/complete":           true,
+	"POST /authorize/flows":                         true,
+	"GET /authorize/flows/{flowId}":                 true,
+	"POST /authorize/flows/{flowId}/credentials":    true,
+	"POST /authorize/flows/{flowId}/two-factor":     true,
+	"POST /authorize/flows/{flowId}/two-factor/sms": true,
+	"POST /authorize/flows/{flowId}/consent":        true,
+}
+
+// isPublicAPIRoute reports whether the request's route is one of publicAPIRoutes, below /api/v1,
+// /api/v2 or /tenant/{tenantId}/api/v1
+func isPublicAPIRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+	i := strings.Index(template, "/api/v")
+	if i < 0 {
+		return false
+	}
+	template = template[i+len("/api/v"):]
+	if j := strings.Index(template, "/"); j >= 0 {
+		return publicAPIRoutes[r.Method+" "+template[j:]]
+	}
+	return false
+}
+
+// loginHandler wraps a handler that signs users in or issues tokens, which the maintenance
+// mode can freeze
+func loginHandler(deps *Dependencies, handler http.HandlerFunc) http.Handler {
+	return middleware.LoginFreeze(deps.MaintenanceService)(middleware.LimitBody(loginBodyLimit)(handler))
+}
+
+// idempotent wraps a create endpoint so retries carrying the same Idempotency-Key header get the
+// original response instead of creating duplicates
+func idempotent(deps *Dependencies, handler http.HandlerFunc) http.Handler {
+	return middleware.Idempotent(deps.IdempotencyService)(handler)
+}
+
+// elevated marks a destructive endpoint: only elevated tokens from POST /api/v1/auth/elevate may
+// use it. Deletions (other than of the caller's own sessions and consents) and every other
+// irreversible or mass change are registered with it.
+func elevated(handler http.Handler) http.Handler {
+	return middleware.RequireElevation(handler)
+}
+
+// platformOperator restricts a handler to the platform operator
+func platformOperator(handler http.Handler) http.Handler {
+	return middleware.RequirePlatformOperator(handler)
+}
+
+// tenantAdmin restricts a /tenants/{id} handler to the admins of the tenant and the platform operator
+func tenantAdmin(handler http.HandlerFunc) http.Handler {
+	return middleware.RequireTenantAdmin(handler)
+}
+
+// legacyHandler wraps a handler of a deprecated route registered outside the legacy subrouters
+func legacyHandler(deps *Dependencies, path string, handler http.HandlerFunc) http.Handler {
+	return middleware.Deprecated(legacyDeprecation(path), deps.LegacyUsageService)(handler)
 }
 
 // setupHealthRoute configures the health check endpoint
@@ -1,11 +1,18 @@
 package routes
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"oauth2-openid-server/autodiscovery"
+	"oauth2-openid-server/middleware"
+	"oauth2-openid-server/services"
+
+	"github.com/gorilla/mux"
 )
 
 // MockDependencies creates a minimal mock dependencies struct for testing
@@ -80,4 +87,270 @@ func TestSetupHealthRoute(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
-}
\ No newline at end of file
+}
+func TestLegacyRoutesCarryDeprecationHeaders(t *testing.T) {
+	router := SetupRoutes(createMockDependencies())
+
+	req := httptest.NewRequest("GET", "/.well-known/openid_configuration", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Deprecation") == "" {
+		t.Error("Expected a Deprecation header on the legacy discovery route")
+	}
+	if w.Header().Get("Sunset") == "" {
+		t.Error("Expected a Sunset header on the legacy discovery route")
+	}
+	expectedLink := `</tenant/{tenantId}/.well-known/openid-configuration>; rel="successor-version"`
+	if link := w.Header().Get("Link"); link != expectedLink {
+		t.Errorf("Expected Link %q, got %q", expectedLink, link)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Header().Get("Deprecation") != "" {
+		t.Error("Expected no Deprecation header on current routes")
+	}
+}
+
+func TestLegacyDeprecationsAreRegistered(t *testing.T) {
+	for _, path := range []string{"/oauth", "/auth", "/login", "/.well-known/openid-configuration", "/.well-known/openid_configuration", "/.well-known/jwks.json"} {
+		deprecation := legacyDeprecation(path)
+		if !deprecation.Sunset.After(deprecation.Since) {
+			t.Errorf("Expected %s to be sunset after it was deprecated", path)
+		}
+	}
+}
+
+func TestAuthorizationServerMetadataRoutes(t *testing.T) {
+	router := SetupRoutes(createMockDependencies())
+
+	for path, issuer := range map[string]string{
+		"/.well-known/oauth-authorization-server":                 "http://example.com",
+		"/.well-known/oauth-authorization-server/tenant/tenant-1": "http://example.com/tenant/tenant-1",
+		"/tenant/tenant-1/.well-known/oauth-authorization-server": "http://example.com/tenant/tenant-1",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+path, nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200 for %s, got %d", path, w.Code)
+			continue
+		}
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &metadata); err != nil || metadata["issuer"] != issuer {
+			t.Errorf("Expected issuer %s for %s, got %v (err %v)", issuer, path, metadata["issuer"], err)
+		}
+	}
+}
+
+func TestOpenIDConfigurationRoutes(t *testing.T) {
+	router := SetupRoutes(createMockDependencies())
+
+	for path, issuer := range map[string]string{
+		"/.well-known/openid-configuration":                 "http://example.com",
+		"/.well-known/openid_configuration":                 "http://example.com",
+		"/tenant/tenant-1/.well-known/openid-configuration": "http://example.com/tenant/tenant-1",
+		"/.well-known/tenant-1/openid_configuration":        "http://example.com/tenant/tenant-1",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+path, nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200 for %s, got %d", path, w.Code)
+			continue
+		}
+		var config map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil || config["issuer"] != issuer {
+			t.Errorf("Expected issuer %s for %s, got %v (err %v)", issuer, path, config["issuer"], err)
+		}
+	}
+}
+
+func TestJWKSRoutesAreRegistered(t *testing.T) {
+	router := SetupRoutes(createMockDependencies())
+
+	for _, path := range []string{"/.well-known/jwks.json", "/tenant/tenant-1/.well-known/jwks.json"} {
+		var match mux.RouteMatch
+		if !router.Match(httptest.NewRequest("GET", path, nil), &match) || match.Handler == nil {
+			t.Errorf("Expected a JWKS route for %s", path)
+		}
+	}
+}
+
+func TestAPIRoutesRequireAuthentication(t *testing.T) {
+	router := SetupRoutes(createMockDependencies())
+
+	for _, tt := range []struct{ method, path string }{
+		{"GET", "/api/v1/users"},
+		{"PUT", "/api/v1/users/64b7f0c2a1b2c3d4e5f60718"},
+		{"GET", "/api/v1/clients"},
+		{"POST", "/api/v1/keys/rotate"},
+		{"POST", "/api/v1/users/bulk"},
+		{"GET", "/api/v2/legacy-usage"},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("X-Tenant-ID", "64b7f0c2a1b2c3d4e5f60718")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for anonymous %s %s, got %d", tt.method, tt.path, w.Code)
+		}
+		if w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected a WWW-Authenticate challenge for %s %s", tt.method, tt.path)
+		}
+	}
+}
+
+func TestPublicAPIRoutes(t *testing.T) {
+	router := SetupRoutes(createMockDependencies())
+
+	tests := []struct {
+		method, path string
+		public       bool
+	}{
+		{"POST", "/api/v1/register", true},
+		{"GET", "/api/v1/bot-protection/challenge", true},
+		{"POST", "/api/v1/users/email-change/verify", true},
+		{"POST", "/api/v1/users/password-setup/complete", true},
+		{"POST", "/api/v1/authorize/flows/flow-1/credentials", true},
+		{"POST", "/tenant/tenant-1/api/v1/authorize/flows", true},
+		{"GET", "/api/v2/versions", true},
+		{"GET", "/api/v1/users", false},
+		{"POST", "/api/v1/users", false},
+		{"POST", "/api/v1/2fa/verify", false},
+		{"GET", "/tenant/tenant-1/api/v1/users/me", false},
+	}
+	for _, tt := range tests {
+		var public bool
+		probe := mux.NewRouter()
+		probe.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				public = isPublicAPIRoute(r)
+			})
+		})
+		var match mux.RouteMatch
+		if !router.Match(httptest.NewRequest(tt.method, tt.path, nil), &match) || match.Route == nil {
+			t.Errorf("Expected a route for %s %s", tt.method, tt.path)
+			continue
+		}
+		template, _ := match.Route.GetPathTemplate()
+		probe.Handle(template, http.NotFoundHandler()).Methods(tt.method)
+		probe.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+		if public != tt.public {
+			t.Errorf("isPublicAPIRoute(%s %s) = %v, want %v", tt.method, tt.path, public, tt.public)
+		}
+	}
+}
+
+// serveRoutes serves a request with the claims to the handler chain of every route named in
+// routes ("METHOD template"), bypassing authentication, and returns the responses by route
+func serveRoutes(t *testing.T, routes []string, claims *services.Claims) map[string]*httptest.ResponseRecorder {
+	t.Helper()
+	wanted := map[string]bool{}
+	for _, name := range routes {
+		wanted[name] = true
+	}
+
+	responses := map[string]*httptest.ResponseRecorder{}
+	SetupRoutes(createMockDependencies()).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			name := method + " " + template
+			if !wanted[name] {
+				continue
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ClaimsKey, claims))
+			w := httptest.NewRecorder()
+			route.GetHandler().ServeHTTP(w, req)
+			responses[name] = w
+		}
+		return nil
+	})
+
+	for _, name := range routes {
+		if responses[name] == nil {
+			t.Errorf("Expected a route for %s", name)
+		}
+	}
+	return responses
+}
+
+func TestDestructiveRoutesRequireElevation(t *testing.T) {
+	destructive := []string{
+		"DELETE /api/v1/users/{id}",
+		"POST /api/v1/users/bulk",
+		"POST /api/v1/users/{id}/merge",
+		"POST /api/v1/users/{id}/deprovision",
+		"POST /api/v1/users/{id}/password-reset",
+		"POST /api/v1/clients/{id}/regenerate-secret",
+		"POST /api/v1/keys/rotate",
+		"POST /api/v1/keys/canary/promote",
+		"POST /api/v1/keys/canary/rollback",
+		"POST /api/v1/tokens/revoke",
+		"DELETE /api/v1/tenants/{id}",
+		"POST /api/v1/tenants/{id}/purge",
+		"DELETE /api/v1/groups/{id}/members/{userId}",
+	}
+	// A valid admin token without elevation; the route's own handler chain must reject it
+	admin := &services.Claims{UserID: "u1", TenantID: "t1", Scopes: []string{services.AdminScope}}
+
+	for name, w := range serveRoutes(t, destructive, admin) {
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "elevation_required") {
+			t.Errorf("Expected %s to require elevation, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUserAdministrationRoutes(t *testing.T) {
+	userAdministration := []string{
+		"POST /api/v1/users",
+		"POST /api/v1/users/import",
+		"POST /api/v1/users/bulk",
+		"PUT /api/v1/users/{id}",
+		"DELETE /api/v1/users/{id}",
+		"POST /api/v1/users/{id}/suspend",
+		"POST /api/v1/users/{id}/reactivate",
+	}
+	// A group manager's elevated token: elevation doesn't replace the user administration scopes
+	manager := &services.Claims{UserID: "u1", TenantID: "t1", Scopes: []string{"read", services.ElevatedScope}}
+
+	for name, w := range serveRoutes(t, userAdministration, manager) {
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "insufficient_scope") {
+			t.Errorf("Expected %s to require user administration, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAdministrationRoutesRequireAdmin(t *testing.T) {
+	administration := []string{
+		"POST /api/v1/clients",
+		"PUT /api/v1/clients/{id}",
+		"DELETE /api/v1/clients/{id}",
+		"PATCH /api/v1/clients/{id}/activate",
+		"PATCH /api/v1/clients/{id}/deactivate",
+		"POST /api/v1/clients/{id}/regenerate-secret",
+		"GET /api/v1/clients/{id}/assignments",
+		"POST /api/v1/clients/{id}/assignments",
+		"DELETE /api/v1/clients/{id}/assignments/{type}/{principalId}",
+		"POST /api/v1/scopes",
+		"PUT /api/v1/scopes/{id}",
+		"DELETE /api/v1/scopes/{id}",
+		"PUT /api/v1/social/providers/{provider}",
+		"POST /api/v1/social/providers/{provider}/test",
+		"PUT /api/v1/social/providers/{provider}/group-mappings",
+		"PUT /api/v1/social/catalog/{provider}",
+	}
+	// An elevated token without the admin scope
+	user := &services.Claims{UserID: "u1", TenantID: "t1", Scopes: []string{"read", services.ElevatedScope}}
+
+	for name, w := range serveRoutes(t, administration, user) {
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "insufficient_scope") {
+			t.Errorf("Expected %s to require the admin scope, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+}
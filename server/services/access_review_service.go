@@ -0,0 +1,480 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultAccessReviewDuration is how long reviewers have when a campaign doesn't set a due date
+const DefaultAccessReviewDuration = 14 * 24 * time.Hour
+
+var (
+	ErrAccessReviewNotFound      = errors.New("access review not found")
+	ErrAccessReviewItemNotFound  = errors.New("access review item not found")
+	ErrAccessReviewClosed        = errors.New("the access review is no longer open")
+	ErrAccessReviewNotReviewer   = errors.New("only the group's owners or a tenant admin can review this membership")
+	ErrAccessReviewSelf          = errors.New("users can't review their own memberships")
+	ErrAccessReviewDueInPast     = errors.New("the due date must be in the future")
+	ErrInvalidAccessReviewChoice = errors.New("the decision must be approve or revoke")
+)
+
+// AccessReviewService runs access review campaigns: starting one lists every membership of the
+// groups under review for their owners, who approve or revoke each; applying it removes the
+// revoked memberships. Open campaigns are applied when due and repeating campaigns restart on
+// schedule (see RunDue).
+type AccessReviewService struct {
+	db           *database.MongoDB
+	campaigns    *mongo.Collection
+	items        *mongo.Collection
+	memberships  *MembershipService
+	auditService *AuditService
+}
+
+func NewAccessReviewService(db *database.MongoDB, memberships *MembershipService, auditService *AuditService) *AccessReviewService {
+	return &AccessReviewService{
+		db:           db,
+		campaigns:    db.GetCollection("access_review_campaigns"),
+		items:        db.GetCollection("access_review_items"),
+		memberships:  memberships,
+		auditService: auditService,
+	}
+}
+
+// EnsureIndexes creates the indexes used to list campaigns, their items and reviewers' pending items
+func (s *AccessReviewService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := s.campaigns.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "due_at", Value: 1}}},
+	}); err != nil {
+		return err
+	}
+	_, err := s.items.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "campaign_id", Value: 1}, {Key: "group_name", Value: 1}, {Key: "user_email", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "reviewers", Value: 1}, {Key: "decision", Value: 1}}},
+	})
+	return err
+}
+
+// Start opens a campaign over its groups (IDs or names; all of the tenant's groups when empty)
+// with one item per member. A zero DueAt gives reviewers DefaultAccessReviewDuration.
+func (s *AccessReviewService) Start(tenantID string, campaign *models.AccessReviewCampaign) error {
+	now := time.Now()
+	if campaign.DueAt.IsZero() {
+		campaign.DueAt = now.Add(DefaultAccessReviewDuration)
+	} else if !campaign.DueAt.After(now) {
+		return ErrAccessReviewDueInPast
+	}
+
+	groupService := NewGroupService(s.db)
+	var groups []*models.Group
+	if len(campaign.GroupIDs) == 0 {
+		all, err := groupService.GetAllGroups(tenantID)
+		if err != nil {
+			return err
+		}
+		groups = all
+	} else {
+		ids, err := s.memberships.ResolveGroupIDs(tenantID, campaign.GroupIDs)
+		if err != nil {
+			return err
+		}
+		campaign.GroupIDs = ids
+		for _, id := range ids {
+			group, err := groupService.GetGroupByID(id, tenantID)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+		}
+	}
+	if campaign.GroupIDs == nil {
+		campaign.GroupIDs = []string{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	campaign.ID = primitive.NewObjectID()
+	campaign.TenantID = tenantID
+	campaign.Status = models.AccessReviewOpen
+	campaign.CreatedAt = now
+	campaign.AppliedAt = nil
+	campaign.Repeated = false
+	campaign.Summary = models.AccessReviewSummary{}
+
+	items := []interface{}{}
+	users := s.db.TenantCollection(tenantID, "users")
+	for _, group := range groups {
+		groupID := group.ID.Hex()
+		cursor, err := users.Find(ctx, bson.M{"tenant_id": tenantID, "groups": groupID})
+		if err != nil {
+			return err
+		}
+		var members []*models.User
+		if err := cursor.All(ctx, &members); err != nil {
+			return err
+		}
+		for _, member := range members {
+			userID := member.ID.Hex()
+			reviewers := []string{}
+			for _, owner := range group.Owners {
+				if owner != userID {
+					reviewers = append(reviewers, owner)
+				}
+			}
+			items = append(items, &models.AccessReviewItem{
+				ID:         primitive.NewObjectID(),
+				TenantID:   tenantID,
+				CampaignID: campaign.ID.Hex(),
+				GroupID:    groupID,
+				GroupName:  group.Name,
+				UserID:     userID,
+				UserEmail:  member.Email,
+				Reviewers:  reviewers,
+				CreatedAt:  now,
+			})
+		}
+	}
+	campaign.Summary.Items = len(items)
+	campaign.Summary.Undecided = len(items)
+
+	if len(items) > 0 {
+		if _, err := s.items.InsertMany(ctx, items); err != nil {
+			return err
+		}
+	}
+	if _, err := s.campaigns.InsertOne(ctx, campaign); err != nil {
+		return err
+	}
+
+	s.record(&models.AuditEvent{TenantID: tenantID, Type: models.AuditEventAccessReviewStarted, ActorID: campaign.CreatedBy, Target: campaign.ID.Hex()})
+	return nil
+}
+
+// List returns the tenant's campaigns, newest first, with their current counts
+func (s *AccessReviewService) List(tenantID string) ([]*models.AccessReviewCampaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.campaigns.Find(ctx, bson.M{"tenant_id": tenantID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	campaigns := []*models.AccessReviewCampaign{}
+	if err := cursor.All(ctx, &campaigns); err != nil {
+		return nil, err
+	}
+
+	for _, campaign := range campaigns {
+		if campaign.Status != models.AccessReviewOpen {
+			continue
+		}
+		items, err := s.campaignItems(ctx, campaign.ID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		campaign.Summary = summarizeAccessReview(items)
+	}
+	return campaigns, nil
+}
+
+// Get returns a campaign of the tenant and its items
+func (s *AccessReviewService) Get(tenantID, id string) (*models.AccessReviewCampaign, []*models.AccessReviewItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	campaign, err := s.getCampaign(ctx, tenantID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	items, err := s.campaignItems(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if campaign.Status == models.AccessReviewOpen {
+		campaign.Summary = summarizeAccessReview(items)
+	}
+	return campaign, items, nil
+}
+
+// Pending returns the undecided items of open campaigns the user reviews. Tenant admins also get
+// the items of groups without owners.
+func (s *AccessReviewService) Pending(tenantID, userID string, admin bool) ([]*models.AccessReviewItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	open, err := s.openCampaignIDs(ctx, bson.M{"tenant_id": tenantID})
+	if err != nil {
+		return nil, err
+	}
+	items := []*models.AccessReviewItem{}
+	if len(open) == 0 {
+		return items, nil
+	}
+
+	reviewers := []bson.M{{"reviewers": userID}}
+	if admin {
+		reviewers = append(reviewers, bson.M{"reviewers": bson.M{"$size": 0}})
+	}
+	cursor, err := s.items.Find(ctx, bson.M{
+		"tenant_id":   tenantID,
+		"campaign_id": bson.M{"$in": open},
+		"decision":    "",
+		"user_id":     bson.M{"$ne": userID},
+		"$or":         reviewers,
+	}, options.Find().SetSort(bson.D{{Key: "group_name", Value: 1}, {Key: "user_email", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Decide records a reviewer's decision on an item of an open campaign. The group's owners decide;
+// tenant admins may decide any item. Nobody decides on their own membership. A decision can be
+// changed until the campaign is applied.
+func (s *AccessReviewService) Decide(tenantID, campaignID, itemID, reviewerID string, admin bool, decision, comment string) (*models.AccessReviewItem, error) {
+	if decision != models.AccessReviewApprove && decision != models.AccessReviewRevoke {
+		return nil, ErrInvalidAccessReviewChoice
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	campaign, err := s.getCampaign(ctx, tenantID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.Status != models.AccessReviewOpen {
+		return nil, ErrAccessReviewClosed
+	}
+
+	objID, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return nil, ErrAccessReviewItemNotFound
+	}
+	var item models.AccessReviewItem
+	if err := s.items.FindOne(ctx, bson.M{"_id": objID, "campaign_id": campaignID, "tenant_id": tenantID}).Decode(&item); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAccessReviewItemNotFound
+		}
+		return nil, err
+	}
+	if item.UserID == reviewerID {
+		return nil, ErrAccessReviewSelf
+	}
+	if !admin && !containsString(item.Reviewers, reviewerID) {
+		return nil, ErrAccessReviewNotReviewer
+	}
+
+	now := time.Now()
+	item.Decision = decision
+	item.Comment = comment
+	item.DecidedBy = reviewerID
+	item.DecidedAt = &now
+	if _, err := s.items.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"decision":   decision,
+		"comment":    comment,
+		"decided_by": reviewerID,
+		"decided_at": now,
+	}}); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Apply completes an open campaign: members whose membership was revoked (and, with
+// RevokeUndecided, those nobody decided on) are removed from the group. Each removal is audited as
+// group_member_removed with the reason access_review.
+func (s *AccessReviewService) Apply(tenantID, id, actorID string) (*models.AccessReviewCampaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrAccessReviewNotFound
+	}
+
+	// Moving the campaign out of open first makes sure it is applied once, even when the scheduler
+	// and an admin apply it at the same time
+	now := time.Now()
+	var campaign models.AccessReviewCampaign
+	err = s.campaigns.FindOneAndUpdate(ctx,
+		bson.M{"_id": objID, "tenant_id": tenantID, "status": models.AccessReviewOpen},
+		bson.M{"$set": bson.M{"status": models.AccessReviewCompleted, "applied_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&campaign)
+	if err == mongo.ErrNoDocuments {
+		if _, err := s.getCampaign(ctx, tenantID, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrAccessReviewClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.campaignItems(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	summary := summarizeAccessReview(items)
+	for _, item := range items {
+		revoke := item.Decision == models.AccessReviewRevoke || (item.Decision == "" && campaign.RevokeUndecided)
+		if !revoke {
+			continue
+		}
+		if err := s.memberships.RemoveMember(item.GroupID, item.UserID, tenantID); err != nil {
+			// The user or group may have been deleted since the campaign started
+			log.Printf("Warning: Access review %s failed to remove user %s from group %s: %v", id, item.UserID, item.GroupID, err)
+			continue
+		}
+		summary.Removed++
+		s.record(&models.AuditEvent{TenantID: tenantID, Type: models.AuditEventGroupMemberRemoved, UserID: item.UserID, ActorID: actorID, Target: item.GroupID, Reason: "access_review"})
+	}
+
+	if _, err := s.campaigns.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"summary": summary}}); err != nil {
+		return nil, err
+	}
+	campaign.Summary = summary
+
+	s.record(&models.AuditEvent{TenantID: tenantID, Type: models.AuditEventAccessReviewApplied, ActorID: actorID, Target: id})
+	return &campaign, nil
+}
+
+// RunDue applies the open campaigns past their due date and starts the next campaign of repeating
+// series whose interval has elapsed
+func (s *AccessReviewService) RunDue() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := s.campaigns.Find(ctx, bson.M{"status": models.AccessReviewOpen, "due_at": bson.M{"$lte": now}})
+	if err != nil {
+		return err
+	}
+	var due []*models.AccessReviewCampaign
+	if err := cursor.All(ctx, &due); err != nil {
+		return err
+	}
+	for _, campaign := range due {
+		if _, err := s.Apply(campaign.TenantID, campaign.ID.Hex(), ""); err != nil && !errors.Is(err, ErrAccessReviewClosed) {
+			log.Printf("Warning: Failed to apply access review %s: %v", campaign.ID.Hex(), err)
+		}
+	}
+
+	cursor, err = s.campaigns.Find(ctx, bson.M{"repeat_every_days": bson.M{"$gt": 0}, "repeated": false})
+	if err != nil {
+		return err
+	}
+	var series []*models.AccessReviewCampaign
+	if err := cursor.All(ctx, &series); err != nil {
+		return err
+	}
+	for _, campaign := range series {
+		if now.Before(campaign.CreatedAt.AddDate(0, 0, campaign.RepeatEveryDays)) {
+			continue
+		}
+		// Claiming the repetition first keeps concurrent runs from starting it twice
+		result, err := s.campaigns.UpdateOne(ctx, bson.M{"_id": campaign.ID, "repeated": false}, bson.M{"$set": bson.M{"repeated": true}})
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+		next := &models.AccessReviewCampaign{
+			Name:            campaign.Name,
+			GroupIDs:        campaign.GroupIDs,
+			RevokeUndecided: campaign.RevokeUndecided,
+			RepeatEveryDays: campaign.RepeatEveryDays,
+			DueAt:           now.Add(campaign.DueAt.Sub(campaign.CreatedAt)),
+			CreatedBy:       campaign.CreatedBy,
+		}
+		if err := s.Start(campaign.TenantID, next); err != nil {
+			log.Printf("Warning: Failed to repeat access review %s: %v", campaign.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+func (s *AccessReviewService) getCampaign(ctx context.Context, tenantID, id string) (*models.AccessReviewCampaign, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrAccessReviewNotFound
+	}
+	var campaign models.AccessReviewCampaign
+	if err := s.campaigns.FindOne(ctx, bson.M{"_id": objID, "tenant_id": tenantID}).Decode(&campaign); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAccessReviewNotFound
+		}
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+func (s *AccessReviewService) campaignItems(ctx context.Context, campaignID string) ([]*models.AccessReviewItem, error) {
+	cursor, err := s.items.Find(ctx, bson.M{"campaign_id": campaignID}, options.Find().SetSort(bson.D{{Key: "group_name", Value: 1}, {Key: "user_email", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	items := []*models.AccessReviewItem{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *AccessReviewService) openCampaignIDs(ctx context.Context, filter bson.M) ([]string, error) {
+	filter["status"] = models.AccessReviewOpen
+	cursor, err := s.campaigns.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var campaigns []*models.AccessReviewCampaign
+	if err := cursor.All(ctx, &campaigns); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		ids = append(ids, campaign.ID.Hex())
+	}
+	return ids, nil
+}
+
+func (s *AccessReviewService) record(event *models.AuditEvent) {
+	if s.auditService != nil {
+		s.auditService.Record(event)
+	}
+}
+
+// summarizeAccessReview counts the items by decision
+func summarizeAccessReview(items []*models.AccessReviewItem) models.AccessReviewSummary {
+	summary := models.AccessReviewSummary{Items: len(items)}
+	for _, item := range items {
+		switch item.Decision {
+		case models.AccessReviewApprove:
+			summary.Approved++
+		case models.AccessReviewRevoke:
+			summary.Revoked++
+		default:
+			summary.Undecided++
+		}
+	}
+	return summary
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSummarizeAccessReview(t *testing.T) {
+	items := []*models.AccessReviewItem{
+		{Decision: models.AccessReviewApprove},
+		{Decision: models.AccessReviewRevoke},
+		{Decision: models.AccessReviewRevoke},
+		{},
+	}
+	want := models.AccessReviewSummary{Items: 4, Approved: 1, Revoked: 2, Undecided: 1}
+	if got := summarizeAccessReview(items); got != want {
+		t.Errorf("summarizeAccessReview() = %+v, want %+v", got, want)
+	}
+}
+
+// TestExpireMemberships checks that expired memberships are removed and others kept
+func TestExpireMemberships(t *testing.T) {
+	db := dbtest.New(t)
+	memberships := NewMembershipService(db)
+
+	group := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Contractors", Members: []string{}}
+	user := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "c@example.com", Active: true, Groups: []string{}}
+	dbtest.Insert(t, db, "groups", group)
+	dbtest.Insert(t, db, "users", user)
+
+	if err := memberships.AddMemberUntil(group.ID.Hex(), user.ID.Hex(), "t1", &time.Time{}); !errors.Is(err, ErrMembershipExpiryInPast) {
+		t.Errorf("AddMemberUntil() in the past error = %v, want ErrMembershipExpiryInPast", err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	if err := memberships.AddMemberUntil(group.ID.Hex(), user.ID.Hex(), "t1", &expiresAt); err != nil {
+		t.Fatalf("AddMemberUntil() error = %v", err)
+	}
+
+	if removed, err := memberships.ExpireMemberships(nil); err != nil || removed != 0 {
+		t.Errorf("ExpireMemberships() before the expiry = %d, %v; want 0", removed, err)
+	}
+
+	// Expire the membership by moving its expiry into the past
+	if _, err := db.GetCollection("users").UpdateOne(context.Background(), bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"group_expirations.0.expires_at": time.Now().Add(-time.Minute)}}); err != nil {
+		t.Fatalf("UpdateOne() error = %v", err)
+	}
+	if removed, err := memberships.ExpireMemberships(nil); err != nil || removed != 1 {
+		t.Fatalf("ExpireMemberships() = %d, %v; want 1", removed, err)
+	}
+
+	updated, err := NewGroupService(db).GetGroupByID(group.ID.Hex(), "t1")
+	if err != nil {
+		t.Fatalf("GetGroupByID() error = %v", err)
+	}
+	if len(updated.Members) != 0 {
+		t.Errorf("group members = %v, want none after the expiry", updated.Members)
+	}
+}
+
+// TestAccessReviewLifecycle starts a campaign, decides on its items and applies it
+func TestAccessReviewLifecycle(t *testing.T) {
+	db := dbtest.New(t)
+	memberships := NewMembershipService(db)
+	service := NewAccessReviewService(db, memberships, nil)
+	if err := service.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+
+	owner := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "owner@example.com", Active: true, Groups: []string{}}
+	kept := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "kept@example.com", Active: true, Groups: []string{}}
+	revoked := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "revoked@example.com", Active: true, Groups: []string{}}
+	group := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Finance", Members: []string{}, Owners: []string{owner.ID.Hex()}}
+	groupID := group.ID.Hex()
+	owner.Groups = []string{groupID}
+	kept.Groups = []string{groupID}
+	revoked.Groups = []string{groupID}
+	dbtest.Insert(t, db, "groups", group)
+	dbtest.Insert(t, db, "users", owner, kept, revoked)
+
+	campaign := &models.AccessReviewCampaign{Name: "Q3 finance review", GroupIDs: []string{"Finance"}, CreatedBy: "admin"}
+	if err := service.Start("t1", campaign); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if campaign.Summary.Items != 3 || campaign.GroupIDs[0] != groupID {
+		t.Fatalf("Start() = %+v, want three items of the group", campaign)
+	}
+
+	pending, err := service.Pending("t1", owner.ID.Hex(), false)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("Pending(owner) = %v, %v; want the two other members", pending, err)
+	}
+
+	// The owner's own membership has no reviewer, so a tenant admin decides on it
+	_, items, err := service.Get("t1", campaign.ID.Hex())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	for _, item := range items {
+		var err error
+		switch item.UserID {
+		case owner.ID.Hex():
+			if _, err := service.Decide("t1", campaign.ID.Hex(), item.ID.Hex(), owner.ID.Hex(), true, models.AccessReviewApprove, ""); !errors.Is(err, ErrAccessReviewSelf) {
+				t.Errorf("Decide() on the own membership error = %v, want ErrAccessReviewSelf", err)
+			}
+			_, err = service.Decide("t1", campaign.ID.Hex(), item.ID.Hex(), "admin", true, models.AccessReviewApprove, "")
+		case kept.ID.Hex():
+			if _, err := service.Decide("t1", campaign.ID.Hex(), item.ID.Hex(), revoked.ID.Hex(), false, models.AccessReviewRevoke, ""); !errors.Is(err, ErrAccessReviewNotReviewer) {
+				t.Errorf("Decide() by a member error = %v, want ErrAccessReviewNotReviewer", err)
+			}
+			_, err = service.Decide("t1", campaign.ID.Hex(), item.ID.Hex(), owner.ID.Hex(), false, models.AccessReviewApprove, "")
+		case revoked.ID.Hex():
+			_, err = service.Decide("t1", campaign.ID.Hex(), item.ID.Hex(), owner.ID.Hex(), false, models.AccessReviewRevoke, "left the team")
+		}
+		if err != nil {
+			t.Fatalf("Decide() error = %v", err)
+		}
+	}
+
+	applied, err := service.Apply("t1", campaign.ID.Hex(), "admin")
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	want := models.AccessReviewSummary{Items: 3, Approved: 2, Revoked: 1, Removed: 1}
+	if applied.Status != models.AccessReviewCompleted || applied.Summary != want {
+		t.Errorf("Apply() = %+v, want completed with %+v", applied, want)
+	}
+	if _, err := service.Apply("t1", campaign.ID.Hex(), "admin"); !errors.Is(err, ErrAccessReviewClosed) {
+		t.Errorf("Apply() twice error = %v, want ErrAccessReviewClosed", err)
+	}
+
+	updated, err := NewGroupService(db).GetGroupByID(groupID, "t1")
+	if err != nil {
+		t.Fatalf("GetGroupByID() error = %v", err)
+	}
+	if len(updated.Members) != 2 || containsString(updated.Members, revoked.ID.Hex()) {
+		t.Errorf("group members = %v, want the owner and the kept member", updated.Members)
+	}
+}
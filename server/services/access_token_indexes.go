@@ -0,0 +1,30 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Indexes of access_tokens that the heavy read paths hint
+const (
+	AccessTokenLookupIndex  = "token_lookup"      // token validation
+	AccessTokenCreatedIndex = "created_at_lookup" // dashboard charts of recently issued tokens
+	AccessTokenLineageIndex = "ancestors_lookup"  // descendants of a token, see VendToken
+)
+
+// EnsureIndexes creates the access token indexes of token validation, the dashboard charts and the
+// lineage of vended tokens. Queries hint them once they are built.
+func (s *OAuthService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.db.CreateHintedIndexes(ctx, "access_tokens", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetName(AccessTokenLookupIndex)},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetName(AccessTokenCreatedIndex)},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}}, Options: options.Index().SetName(AccessTokenLineageIndex).SetSparse(true)},
+	})
+}
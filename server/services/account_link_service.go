@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordSetupLifetime is how long the link of a password setup stays valid
+const passwordSetupLifetime = 24 * time.Hour
+
+var (
+	ErrPasswordAlreadySet     = errors.New("the account already has a password")
+	ErrPasswordSetupNotFound  = errors.New("password setup request not found")
+	ErrPasswordSetupExpired   = errors.New("password setup request has expired")
+	ErrPasswordSetupNoAddress = errors.New("the account has no email address to verify")
+)
+
+// LoginMethods are the ways a user can sign in
+type LoginMethods struct {
+	Password         bool                    `json:"password"`
+	TwoFactor        bool                    `json:"two_factor"`
+	SocialIdentities []models.SocialIdentity `json:"social_identities"`
+}
+
+// AccountLinkService reports and extends the login methods of a user: users who only sign in
+// through social providers can add a password after verifying their email address. Linking
+// social identities goes through SocialAuthService.LinkIdentity.
+type AccountLinkService struct {
+	db             *database.MongoDB
+	collection     *mongo.Collection
+	userCollection *mongo.Collection
+	notifier       Notifier
+	webBaseURL     string
+	passwordBreach *PasswordBreachService
+	sessionService *SessionService
+}
+
+func NewAccountLinkService(db *database.MongoDB, notifier Notifier, webBaseURL string, passwordBreach *PasswordBreachService) *AccountLinkService {
+	return &AccountLinkService{
+		db:             db,
+		collection:     db.GetCollection("password_setup_requests"),
+		userCollection: db.GetCollection("users"),
+		notifier:       notifier,
+		webBaseURL:     webBaseURL,
+		passwordBreach: passwordBreach,
+		sessionService: NewSessionService(db),
+	}
+}
+
+// EnsureIndexes creates the indexes used to look up password setups by link token
+func (s *AccountLinkService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}}},
+	})
+	return err
+}
+
+// LoginMethods returns the login methods active for a user
+func (s *AccountLinkService) LoginMethods(userID, tenantID string) (*LoginMethods, error) {
+	user, err := s.user(userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := &LoginMethods{
+		Password:         user.PasswordHash != "",
+		TwoFactor:        user.TwoFactorEnabled || user.SMSTwoFactor,
+		SocialIdentities: user.SocialIdentities,
+	}
+	if methods.SocialIdentities == nil {
+		methods.SocialIdentities = []models.SocialIdentity{}
+	}
+	return methods, nil
+}
+
+// RequestPasswordSetup emails a link for adding a password to an account that has none. Earlier
+// unused links of the user stop working.
+func (s *AccountLinkService) RequestPasswordSetup(userID, tenantID string) (*models.PasswordSetupRequest, error) {
+	user, err := s.user(userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if user.PasswordHash != "" {
+		return nil, ErrPasswordAlreadySet
+	}
+	if user.Email == "" {
+		return nil, ErrPasswordSetupNoAddress
+	}
+
+	request, token, err := s.createPasswordSetup(user)
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify(request, "password_setup", "Set a password for your account",
+		"Use this link to set a password, so you can also sign in with your email address: "+s.setupURL(token)+
+			"\nThe link expires on "+request.ExpiresAt.Format(time.RFC1123)+". If you did not ask for it, ignore this message.")
+	return request, nil
+}
+
+// RequirePasswordReset removes the password of an account, e.g. after a suspected leak, signs the
+// user out everywhere and emails a link for choosing a new one. The user can't sign in with a
+// password until it has been set.
+func (s *AccountLinkService) RequirePasswordReset(userID, tenantID string) (*models.PasswordSetupRequest, error) {
+	user, err := s.user(userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Email == "" {
+		return nil, ErrPasswordSetupNoAddress
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.userCollection.UpdateOne(ctx, bson.M{"_id": user.ID, "tenant_id": tenantID}, bson.M{
+		"$set": bson.M{"password_hash": "", "updated_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}); err != nil {
+		return nil, err
+	}
+	if err := s.sessionService.RevokeAllUserSessions(userID, tenantID); err != nil {
+		return nil, err
+	}
+
+	request, token, err := s.createPasswordSetup(user)
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify(request, "password_reset", "Choose a new password",
+		"An administrator reset the password of your account. Use this link to choose a new one: "+s.setupURL(token)+
+			"\nThe link expires on "+request.ExpiresAt.Format(time.RFC1123)+".")
+	return request, nil
+}
+
+// createPasswordSetup stores a password setup for the user and returns it with its link token.
+// Earlier unused links of the user stop working.
+func (s *AccountLinkService) createPasswordSetup(user *models.User) (*models.PasswordSetupRequest, string, error) {
+	token, err := generateEmailChangeToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tenantID, userID := user.TenantID, user.ID.Hex()
+	now := time.Now()
+	request := &models.PasswordSetupRequest{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantID,
+		UserID:    userID,
+		Email:     user.Email,
+		TokenHash: hashEmailChangeToken(token),
+		ExpiresAt: now.Add(passwordSetupLifetime),
+		CreatedAt: now,
+	}
+
+	if _, err := s.collection.DeleteMany(ctx, bson.M{
+		"tenant_id": tenantID,
+		"user_id":   userID,
+		"used_at":   bson.M{"$exists": false},
+	}); err != nil {
+		return nil, "", err
+	}
+	if _, err := s.collection.InsertOne(ctx, request); err != nil {
+		return nil, "", err
+	}
+	return request, token, nil
+}
+
+// CompletePasswordSetup sets the password of the account a setup link was sent for. Accounts
+// that got a password in the meantime are left unchanged. Passwords the tenant's breach policy
+// refuses leave the link unused, so another password can be chosen.
+func (s *AccountLinkService) CompletePasswordSetup(token, password string) (*models.PasswordSetupRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var request models.PasswordSetupRequest
+	err := s.collection.FindOne(ctx, bson.M{
+		"token_hash": hashEmailChangeToken(token),
+		"used_at":    bson.M{"$exists": false},
+	}).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPasswordSetupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(request.ExpiresAt) {
+		return nil, ErrPasswordSetupExpired
+	}
+	if err := s.passwordBreach.Check(request.TenantID, password); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	// The link is used up even if the password can't be set any more
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": request.ID, "used_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"used_at": now}})
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrPasswordSetupNotFound
+	}
+	request.UsedAt = &now
+
+	objID, err := primitive.ObjectIDFromHex(request.UserID)
+	if err != nil {
+		return nil, ErrPasswordSetupNotFound
+	}
+	result, err = s.userCollection.UpdateOne(ctx, bson.M{
+		"_id":           objID,
+		"tenant_id":     request.TenantID,
+		"email":         request.Email,
+		"password_hash": "",
+	}, bson.M{
+		"$set": bson.M{"password_hash": string(hash), "updated_at": now},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrPasswordAlreadySet
+	}
+
+	s.notify(&request, "password_set", "A password was added to your account",
+		"A password was set for your account. If you did not do this, contact your administrator.")
+	return &request, nil
+}
+
+func (s *AccountLinkService) user(userID, tenantID string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	var user models.User
+	if err := s.userCollection.FindOne(ctx, bson.M{"_id": objID, "tenant_id": tenantID}).Decode(&user); err != nil {
+		return nil, errors.New("user not found")
+	}
+	return &user, nil
+}
+
+func (s *AccountLinkService) setupURL(token string) string {
+	return s.webBaseURL + "/password/setup?token=" + url.QueryEscape(token)
+}
+
+func (s *AccountLinkService) notify(request *models.PasswordSetupRequest, notificationType, subject, message string) {
+	if s.notifier == nil {
+		return
+	}
+	notification := &Notification{
+		Type:      notificationType,
+		TenantID:  request.TenantID,
+		Recipient: request.Email,
+		Subject:   subject,
+		Message:   message,
+		Data:      map[string]interface{}{"user_id": request.UserID},
+		CreatedAt: time.Now(),
+	}
+	if err := s.notifier.Notify(notification); err != nil {
+		log.Printf("Warning: Failed to send %s notification for user %s: %v", notificationType, request.UserID, err)
+	}
+}
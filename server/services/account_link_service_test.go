@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type setupLinkNotifier struct {
+	links []string
+}
+
+func (n *setupLinkNotifier) Notify(notification *Notification) error {
+	if _, link, found := strings.Cut(notification.Message, "?token="); found {
+		token, _, _ := strings.Cut(link, "\n")
+		n.links = append(n.links, token)
+	}
+	return nil
+}
+
+// TestPasswordSetup adds a password to a social-only account through the emailed link
+func TestPasswordSetup(t *testing.T) {
+	db := dbtest.New(t)
+
+	socialID := primitive.NewObjectID()
+	passwordID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users",
+		&models.User{ID: socialID, TenantID: "t1", Email: "social@example.com", Active: true, CreatedAt: now, UpdatedAt: now,
+			SocialIdentities: []models.SocialIdentity{{Provider: "github", Subject: "42", LinkedAt: now}}},
+		&models.User{ID: passwordID, TenantID: "t1", Email: "password@example.com", PasswordHash: "hash", Active: true, CreatedAt: now, UpdatedAt: now},
+	)
+
+	notifier := &setupLinkNotifier{}
+	service := NewAccountLinkService(db, notifier, "https://auth.example.com", nil)
+
+	if _, err := service.RequestPasswordSetup(passwordID.Hex(), "t1"); !errors.Is(err, ErrPasswordAlreadySet) {
+		t.Errorf("RequestPasswordSetup() for a password user error = %v, want ErrPasswordAlreadySet", err)
+	}
+
+	methods, err := service.LoginMethods(socialID.Hex(), "t1")
+	if err != nil || methods.Password || len(methods.SocialIdentities) != 1 {
+		t.Fatalf("LoginMethods() = %+v, %v, want no password and one social identity", methods, err)
+	}
+
+	// A second request replaces the first link
+	for i := 0; i < 2; i++ {
+		if _, err := service.RequestPasswordSetup(socialID.Hex(), "t1"); err != nil {
+			t.Fatalf("RequestPasswordSetup() error = %v", err)
+		}
+	}
+	if len(notifier.links) != 2 {
+		t.Fatalf("RequestPasswordSetup() sent %d links, want 2", len(notifier.links))
+	}
+	if _, err := service.CompletePasswordSetup(notifier.links[0], "new-password"); !errors.Is(err, ErrPasswordSetupNotFound) {
+		t.Errorf("CompletePasswordSetup() with a replaced link error = %v, want ErrPasswordSetupNotFound", err)
+	}
+
+	request, err := service.CompletePasswordSetup(notifier.links[1], "new-password")
+	if err != nil {
+		t.Fatalf("CompletePasswordSetup() error = %v", err)
+	}
+	if request.UsedAt == nil {
+		t.Error("CompletePasswordSetup() should mark the request used")
+	}
+	user, err := service.user(socialID.Hex(), "t1")
+	if err != nil {
+		t.Fatalf("Failed to read the user: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("new-password")) != nil {
+		t.Error("CompletePasswordSetup() did not set the password")
+	}
+	if _, err := service.CompletePasswordSetup(notifier.links[1], "other-password"); !errors.Is(err, ErrPasswordSetupNotFound) {
+		t.Errorf("CompletePasswordSetup() with a used link error = %v, want ErrPasswordSetupNotFound", err)
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Notification types of registrations that tenants in anti-enumeration mode answer like any other
+const (
+	NotificationRegistrationExistingAccount = "registration.existing_account"
+	NotificationRegistrationIdentifierTaken = "registration.identifier_taken"
+)
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash is a bcrypt hash of the cost of stored passwords that no password matches
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		password, _ := generateBootstrapPassword()
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// CheckCredentials reports whether password is the user's password. Without a user it compares
+// against a dummy hash, so failed logins of unknown users take as long as wrong passwords.
+func (s *UserService) CheckCredentials(user *models.User, password string) bool {
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+	return s.ValidatePassword(user, password)
+}
+
+// AntiEnumeration reports whether the tenant hides which accounts exist: logins fail alike for
+// unknown users, wrong passwords and disabled accounts, and registrations are answered alike
+// whether or not the email, username or phone number is taken
+func (s *UserService) AntiEnumeration(tenantID string) bool {
+	tenant, err := NewTenantService(s.db).GetTenantByID(tenantID)
+	return err == nil && tenant.Settings.AntiEnumeration
+}
+
+// RegistrationNotifier tells people by email about registrations the anti-enumeration mode
+// answered as if they had succeeded, instead of rejecting them with 409 Conflict
+type RegistrationNotifier struct {
+	notifier Notifier
+}
+
+func NewRegistrationNotifier(notifier Notifier) *RegistrationNotifier {
+	return &RegistrationNotifier{notifier: notifier}
+}
+
+// ExistingAccount tells the owner of an account that someone tried to register with its email
+func (n *RegistrationNotifier) ExistingAccount(tenantID, email string) {
+	n.notify(NotificationRegistrationExistingAccount, tenantID, email,
+		"Someone tried to register with your email address",
+		"Someone tried to create an account with your email address, which already has one. If this was you, "+
+			"sign in or reset your password instead. Otherwise you can ignore this message.")
+}
+
+// IdentifierTaken tells the person registering that the username or phone number they chose is
+// taken and no account was created
+func (n *RegistrationNotifier) IdentifierTaken(tenantID, email, identifier string) {
+	n.notify(NotificationRegistrationIdentifierTaken, tenantID, email,
+		"Your account was not created",
+		"Your account was not created because the "+identifier+" you chose is already in use. "+
+			"Register again with a different "+identifier+".")
+}
+
+func (n *RegistrationNotifier) notify(notificationType, tenantID, recipient, subject, message string) {
+	if n == nil || n.notifier == nil {
+		return
+	}
+	notification := &Notification{
+		Type:      notificationType,
+		TenantID:  tenantID,
+		Recipient: recipient,
+		Subject:   subject,
+		Message:   message,
+		CreatedAt: time.Now(),
+	}
+	// Sent in the background, so the response doesn't take longer than a successful registration
+	go func() {
+		if err := n.notifier.Notify(notification); err != nil {
+			log.Printf("Warning: Failed to send %s notification: %v", notificationType, err)
+		}
+	}()
+}
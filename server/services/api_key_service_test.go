@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestAPIKeyLifecycle creates a key, authenticates with it and checks that revoked and expired
+// keys are rejected
+func TestAPIKeyLifecycle(t *testing.T) {
+	db := dbtest.New(t)
+	service := NewAPIKeyService(db, NewAuditService(db))
+	if err := service.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+
+	if _, _, err := service.Create("t1", "ci", []string{"read", ElevatedScope}, nil, "admin"); !errors.Is(err, ErrAPIKeyReservedScope) {
+		t.Errorf("Create() with %s error = %v, want ErrAPIKeyReservedScope", ElevatedScope, err)
+	}
+
+	apiKey, key, err := service.Create("t1", "ci", []string{"read"}, nil, "admin")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if !strings.HasPrefix(key, apiKey.Prefix) || apiKey.KeyHash == key {
+		t.Errorf("Create() = %+v, key %q; want the key's prefix and a hash", apiKey, key)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/users", nil)
+	claims, err := service.Authenticate(key, req)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if claims.UserID != models.APIKeyPrincipalPrefix+apiKey.ID.Hex() || claims.TenantID != "t1" || len(claims.Scopes) != 1 {
+		t.Errorf("Authenticate() = %+v", claims)
+	}
+	keys, err := service.List("t1")
+	if err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Errorf("List() = %v, %v, want the key with its last use", keys, err)
+	}
+
+	if _, err := service.Authenticate(key+"x", req); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate(unknown) error = %v, want ErrInvalidAPIKey", err)
+	}
+	if _, err := service.Revoke("t2", apiKey.ID.Hex()); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Revoke() in another tenant error = %v, want ErrAPIKeyNotFound", err)
+	}
+	if _, err := service.Revoke("t1", apiKey.ID.Hex()); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, err := service.Authenticate(key, req); !errors.Is(err, ErrAPIKeyRevoked) {
+		t.Errorf("Authenticate(revoked) error = %v, want ErrAPIKeyRevoked", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	expiring, key, err := service.Create("t1", "nightly", nil, &expiresAt, "admin")
+	if err != nil {
+		t.Fatalf("Create() with an expiry error = %v", err)
+	}
+	db.GetCollection("api_keys").UpdateByID(req.Context(), expiring.ID, bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Minute)}})
+	if _, err := service.Authenticate(key, req); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("Authenticate(expired) error = %v, want ErrAPIKeyExpired", err)
+	}
+}
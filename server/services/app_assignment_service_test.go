@@ -0,0 +1,33 @@
+package services
+
+import (
+	"testing"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestClientAssignedTo(t *testing.T) {
+	userID := primitive.NewObjectID()
+	user := &models.User{ID: userID, Groups: []string{"g-staff", "g-finance"}}
+
+	tests := []struct {
+		name   string
+		client models.Client
+		want   bool
+	}{
+		{"assignment not required", models.Client{}, true},
+		{"nobody assigned", models.Client{AssignmentRequired: true}, false},
+		{"user assigned", models.Client{AssignmentRequired: true, AssignedUsers: []string{userID.Hex()}}, true},
+		{"group assigned", models.Client{AssignmentRequired: true, AssignedGroups: []string{"g-finance"}}, true},
+		{"other group assigned", models.Client{AssignmentRequired: true, AssignedGroups: []string{"g-admins"}}, false},
+		{"assignments ignored when not required", models.Client{AssignedGroups: []string{"g-admins"}}, true},
+	}
+
+	for _, tt := range tests {
+		if got := clientAssignedTo(&tt.client, user); got != tt.want {
+			t.Errorf("%s: clientAssignedTo = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+)
+
+const (
+	// DefaultAppLaunchLifetime is how long a launch link stays valid unless asked otherwise
+	DefaultAppLaunchLifetime = 15 * time.Minute
+	// MaxAppLaunchLifetime bounds the lifetime of launch links, e.g. for portal tiles kept open all day
+	MaxAppLaunchLifetime = 24 * time.Hour
+)
+
+var (
+	ErrAppLaunchNotAllowed  = errors.New("the application can't be launched by this user")
+	ErrAppLaunchScope       = errors.New("the application may not request one of the scopes")
+	ErrAppLaunchRedirectURI = errors.New("redirect_uri is not registered for the application")
+	ErrAppLaunchPKCE        = errors.New("the application requires PKCE and has to start sign-in itself")
+	ErrInvalidAppLaunch     = errors.New("invalid launch link")
+	ErrAppLaunchExpired     = errors.New("the launch link has expired")
+)
+
+// AppLaunch is the request sealed into a launch link: an authorization request for the user into
+// the client, with the relay state passed to the client as state
+type AppLaunch struct {
+	TenantID    string    `json:"tid"`
+	UserID      string    `json:"uid"`
+	ClientID    string    `json:"cid"`
+	Scopes      []string  `json:"scp"`
+	RedirectURI string    `json:"ruri"`
+	RelayState  string    `json:"rs,omitempty"`
+	ExpiresAt   time.Time `json:"exp"`
+
+	LoginHint string `json:"-"` // the user's email, filled in by Resolve
+}
+
+// AppLaunchService issues IdP-initiated launch links: signed links that start an authorization
+// code flow into a client with preselected scopes, so users can go straight from the portal into
+// an application. Links are sealed, not stored, so every replica accepts them until they expire.
+type AppLaunchService struct {
+	db            *database.MongoDB
+	sealer        *FlowSealer
+	clientService *ClientService
+	userService   *UserService
+}
+
+// NewAppLaunchService seals launch links with a key derived from secret
+func NewAppLaunchService(db *database.MongoDB, secret string) *AppLaunchService {
+	return &AppLaunchService{
+		db:            db,
+		sealer:        NewFlowSealer("app-launch\x00" + secret),
+		clientService: NewClientService(db),
+		userService:   NewUserService(db),
+	}
+}
+
+// CreateLaunch seals a launch of the client for the user. Empty scopes take the client's scopes
+// and an empty redirect URI the client's first one; ttl is capped at MaxAppLaunchLifetime.
+func (s *AppLaunchService) CreateLaunch(user *models.User, clientID string, scopes []string, redirectURI, relayState string, ttl time.Duration) (string, *AppLaunch, error) {
+	client, err := s.launchableClient(user, clientID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !containsString(client.Scopes, scope) {
+			return "", nil, ErrAppLaunchScope
+		}
+	}
+
+	if redirectURI == "" && len(client.RedirectURIs) > 0 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !containsString(client.RedirectURIs, redirectURI) {
+		return "", nil, ErrAppLaunchRedirectURI
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultAppLaunchLifetime
+	}
+	if ttl > MaxAppLaunchLifetime {
+		ttl = MaxAppLaunchLifetime
+	}
+
+	launch := &AppLaunch{
+		TenantID:    user.TenantID,
+		UserID:      user.ID.Hex(),
+		ClientID:    client.ClientID,
+		Scopes:      scopes,
+		RedirectURI: redirectURI,
+		RelayState:  relayState,
+		ExpiresAt:   time.Now().Add(ttl).Truncate(time.Second),
+	}
+	payload, err := json.Marshal(launch)
+	if err != nil {
+		return "", nil, err
+	}
+	token, err := s.sealer.Seal(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, launch, nil
+}
+
+// Resolve opens a launch link of the tenant. The user must still be active and the client still
+// launchable by them, so unassigning a user also stops the links issued before.
+func (s *AppLaunchService) Resolve(tenantID, token string) (*AppLaunch, error) {
+	payload, err := s.sealer.Open(token)
+	if err != nil {
+		return nil, ErrInvalidAppLaunch
+	}
+	var launch AppLaunch
+	if err := json.Unmarshal(payload, &launch); err != nil || launch.TenantID != tenantID {
+		return nil, ErrInvalidAppLaunch
+	}
+	if time.Now().After(launch.ExpiresAt) {
+		return nil, ErrAppLaunchExpired
+	}
+
+	user, err := s.userService.GetUserByIDAndTenant(launch.UserID, tenantID)
+	if err != nil || !user.Active {
+		return nil, ErrAppLaunchNotAllowed
+	}
+	if _, err := s.launchableClient(user, launch.ClientID); err != nil {
+		return nil, err
+	}
+	launch.LoginHint = user.Email
+	return &launch, nil
+}
+
+// launchableClient returns the client if the user may sign in to it through an authorization
+// code flow the server starts, which rules out clients that need PKCE
+func (s *AppLaunchService) launchableClient(user *models.User, clientID string) (*models.Client, error) {
+	client, err := s.clientService.GetClientByClientID(clientID, user.TenantID)
+	if err != nil || !client.Active || client.System || !containsString(client.GrantTypes, "authorization_code") || !clientAssignedTo(client, user) {
+		return nil, ErrAppLaunchNotAllowed
+	}
+	if pkcePolicy(client, models.TenantSettings{}).Required {
+		return nil, ErrAppLaunchPKCE
+	}
+	return client, nil
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestAppLaunch issues a launch link and resolves it until the user loses the assignment
+func TestAppLaunch(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	user := &models.User{ID: userID, TenantID: "t1", Email: "jane@example.com", Active: true, CreatedAt: now, UpdatedAt: now}
+	dbtest.Insert(t, db, "users", user)
+	wiki := &models.Client{ID: primitive.NewObjectID(), TenantID: "t1", ClientID: "wiki", Name: "Wiki", Active: true,
+		GrantTypes: []string{"authorization_code"}, Scopes: []string{"openid", "profile", "wiki:read"},
+		RedirectURIs: []string{"https://wiki.example.com/cb"}, AssignmentRequired: true, AssignedUsers: []string{userID.Hex()}}
+	dbtest.Insert(t, db, "clients", wiki,
+		&models.Client{ID: primitive.NewObjectID(), TenantID: "t1", ClientID: "spa", Name: "SPA", ClientType: "public", Active: true,
+			GrantTypes: []string{"authorization_code"}, Scopes: []string{"openid"}, RedirectURIs: []string{"https://spa.example.com/cb"}},
+	)
+
+	service := NewAppLaunchService(db, "test-secret")
+
+	if _, _, err := service.CreateLaunch(user, "spa", nil, "", "", 0); !errors.Is(err, ErrAppLaunchPKCE) {
+		t.Errorf("CreateLaunch() of a public client error = %v, want ErrAppLaunchPKCE", err)
+	}
+	if _, _, err := service.CreateLaunch(user, "wiki", []string{"admin"}, "", "", 0); !errors.Is(err, ErrAppLaunchScope) {
+		t.Errorf("CreateLaunch() with a foreign scope error = %v, want ErrAppLaunchScope", err)
+	}
+	if _, _, err := service.CreateLaunch(user, "wiki", nil, "https://evil.example.com/cb", "", 0); !errors.Is(err, ErrAppLaunchRedirectURI) {
+		t.Errorf("CreateLaunch() with an unregistered redirect URI error = %v, want ErrAppLaunchRedirectURI", err)
+	}
+
+	token, _, err := service.CreateLaunch(user, "wiki", []string{"openid", "wiki:read"}, "", "/pages/42", 0)
+	if err != nil {
+		t.Fatalf("CreateLaunch() error = %v", err)
+	}
+	launch, err := service.Resolve("t1", token)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if launch.RedirectURI != "https://wiki.example.com/cb" || launch.RelayState != "/pages/42" || launch.LoginHint != "jane@example.com" || len(launch.Scopes) != 2 {
+		t.Errorf("Resolve() = %+v", launch)
+	}
+	if _, err := service.Resolve("t2", token); !errors.Is(err, ErrInvalidAppLaunch) {
+		t.Errorf("Resolve() in another tenant error = %v, want ErrInvalidAppLaunch", err)
+	}
+	if _, err := service.Resolve("t1", token[:len(token)-2]+"AA"); !errors.Is(err, ErrInvalidAppLaunch) {
+		t.Errorf("Resolve() of a tampered link error = %v, want ErrInvalidAppLaunch", err)
+	}
+
+	// Links stop working once the user is no longer assigned
+	if err := NewAppAssignmentService(db).Revoke(wiki, "user", userID.Hex()); err != nil {
+		t.Fatalf("Failed to unassign the user: %v", err)
+	}
+	if _, err := service.Resolve("t1", token); !errors.Is(err, ErrAppLaunchNotAllowed) {
+		t.Errorf("Resolve() after unassigning error = %v, want ErrAppLaunchNotAllowed", err)
+	}
+}
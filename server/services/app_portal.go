@@ -0,0 +1,99 @@
+package services
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+// UserApplication is a tile of the user's application portal
+type UserApplication struct {
+	ClientID    string     `json:"client_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	LaunchURL   string     `json:"launch_url,omitempty"` // origin of the client's first redirect URI
+	Initials    string     `json:"initials"`             // fallback for a logo
+	Assigned    bool       `json:"assigned"`             // explicitly assigned to the user or one of their groups
+	SSOLaunch   bool       `json:"sso_launch"`           // launch links can sign in to it (see AppLaunchService)
+	ConsentedAt *time.Time `json:"consented_at,omitempty"`
+}
+
+// UserApplications lists the clients the user can sign in to and has either been assigned to or
+// already consented to, ordered by name. Clients that can't be signed in to interactively
+// (without the authorization_code grant) are left out.
+func (s *AppAssignmentService) UserApplications(user *models.User) ([]*UserApplication, error) {
+	clients, err := NewClientService(s.db).GetActiveClients(user.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	consents, err := NewConsentService(s.db).GetUserConsents(user.ID.Hex(), user.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildUserApplications(clients, consents, user), nil
+}
+
+func buildUserApplications(clients []*models.Client, consents []*models.ConsentGrant, user *models.User) []*UserApplication {
+	consentedAt := map[string]time.Time{}
+	for _, consent := range consents {
+		consentedAt[consent.ClientID] = consent.UpdatedAt
+	}
+
+	applications := []*UserApplication{}
+	for _, client := range clients {
+		if client.System || !containsString(client.GrantTypes, "authorization_code") || !clientAssignedTo(client, user) {
+			continue
+		}
+
+		application := &UserApplication{
+			ClientID:    client.ClientID,
+			Name:        client.Name,
+			Description: client.Description,
+			LaunchURL:   launchURL(client.RedirectURIs),
+			Initials:    initials(client.Name),
+			Assigned:    client.AssignmentRequired,
+			SSOLaunch:   !client.IsPublic(),
+		}
+		if at, ok := consentedAt[client.ClientID]; ok {
+			application.ConsentedAt = &at
+		}
+		if !application.Assigned && application.ConsentedAt == nil {
+			continue
+		}
+		applications = append(applications, application)
+	}
+
+	sort.Slice(applications, func(i, j int) bool {
+		return strings.ToLower(applications[i].Name) < strings.ToLower(applications[j].Name)
+	})
+	return applications
+}
+
+// launchURL returns the origin of the first absolute http(s) redirect URI
+func launchURL(redirectURIs []string) string {
+	for _, redirectURI := range redirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+			continue
+		}
+		return parsed.Scheme + "://" + parsed.Host
+	}
+	return ""
+}
+
+// initials returns up to two uppercase initials of a name
+func initials(name string) string {
+	result := ""
+	for _, word := range strings.Fields(name) {
+		result += strings.ToUpper(string([]rune(word)[:1]))
+		if len([]rune(result)) == 2 {
+			break
+		}
+	}
+	return result
+}
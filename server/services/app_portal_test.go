@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuildUserApplications(t *testing.T) {
+	user := &models.User{ID: primitive.NewObjectID(), Groups: []string{"g-finance"}}
+	codeGrant := []string{"authorization_code", "refresh_token"}
+
+	clients := []*models.Client{
+		{ClientID: "wiki", Name: "wiki", GrantTypes: codeGrant, RedirectURIs: []string{"https://wiki.example.com/oauth/callback"}},
+		{ClientID: "billing", Name: "Billing Portal", GrantTypes: codeGrant, AssignmentRequired: true, AssignedGroups: []string{"g-finance"}},
+		{ClientID: "hr", Name: "HR", GrantTypes: codeGrant, AssignmentRequired: true, AssignedGroups: []string{"g-hr"}},
+		{ClientID: "unused", Name: "Unused", GrantTypes: codeGrant},
+		{ClientID: "batch", Name: "Batch", GrantTypes: []string{"client_credentials"}},
+	}
+	consents := []*models.ConsentGrant{
+		{ClientID: "wiki", UpdatedAt: time.Now()},
+		{ClientID: "hr", UpdatedAt: time.Now()},
+		{ClientID: "batch", UpdatedAt: time.Now()},
+	}
+
+	applications := buildUserApplications(clients, consents, user)
+
+	if len(applications) != 2 {
+		t.Fatalf("Expected the assigned and the consented application, got %d", len(applications))
+	}
+	billing, wiki := applications[0], applications[1]
+	if billing.ClientID != "billing" || !billing.Assigned || billing.ConsentedAt != nil || billing.Initials != "BP" {
+		t.Errorf("Unexpected billing tile: %+v", billing)
+	}
+	if wiki.ClientID != "wiki" || wiki.Assigned || wiki.ConsentedAt == nil || wiki.LaunchURL != "https://wiki.example.com" {
+		t.Errorf("Unexpected wiki tile: %+v", wiki)
+	}
+}
+
+func TestLaunchURL(t *testing.T) {
+	tests := []struct {
+		redirectURIs []string
+		want         string
+	}{
+		{nil, ""},
+		{[]string{"com.example.app:/callback", "http://localhost:3000/cb"}, "http://localhost:3000"},
+		{[]string{"https://app.example.com:8443/a/b?c=d"}, "https://app.example.com:8443"},
+	}
+
+	for _, tt := range tests {
+		if got := launchURL(tt.redirectURIs); got != tt.want {
+			t.Errorf("launchURL(%v) = %q, want %q", tt.redirectURIs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxLoginHistory caps the number of login attempts returned to a user
+const maxLoginHistory = 50
+
+// maxActivityPage caps the number of events returned per page of the activity feed
+const maxActivityPage = 100
+
+// ErrInvalidActivityCursor is returned for a cursor that is not the next_cursor of a page
+var ErrInvalidActivityCursor = errors.New("invalid activity cursor")
+
+type AuditService struct {
+	db      *database.MongoDB
+	breaker *database.CircuitBreaker // suspends recording while MongoDB times out
+}
+
+// ActivityPage is one page of a tenant's activity feed. NextCursor is empty on the last page.
+type ActivityPage struct {
+	Events     []*models.AuditEvent `json:"events"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// ActivityFilter narrows a tenant's activity feed; empty fields don't filter
+type ActivityFilter struct {
+	Types   []string // event types
+	ActorID string   // who made the change: the admin, or the user for their own actions
+	UserID  string   // the user the event concerns
+	Target  string   // the group, scope, provider, ... the event concerns
+}
+
+// AuditEventCount is the number of events of one type in a period
+type AuditEventCount struct {
+	Type  string `bson:"_id" json:"type"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+func NewAuditService(db *database.MongoDB) *AuditService {
+	return &AuditService{
+		db:      db,
+		breaker: db.Breaker("audit_events"),
+	}
+}
+
+// events returns the collection holding the tenant's audit events
+func (s *AuditService) events(tenantID string) *mongo.Collection {
+	return s.db.TenantCollection(tenantID, "audit_events")
+}
+
+// reads returns the tenant's audit events for queries, served by the analytics connection when
+// one is configured
+func (s *AuditService) reads(tenantID string) *mongo.Collection {
+	return s.db.ReadTenantCollection(database.ReadPathAudit, tenantID, "audit_events")
+}
+
+// EnsureIndexes creates the indexes used to query a tenant's events by time, type and user, and
+// to page through its activity feed filtered by type, actor, user or target
+func (s *AuditService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.db.EnsureTenantIndexes(ctx, "audit_events", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "type", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "type", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "actor_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "target", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+}
+
+// Record stores an audit event. Failures are logged and never fail the calling flow.
+func (s *AuditService) Record(event *models.AuditEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	event.ID = primitive.NewObjectID()
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	err := s.breaker.Do(func() error {
+		_, err := s.events(event.TenantID).InsertOne(ctx, event)
+		return err
+	})
+	if err != nil && err != database.ErrCircuitOpen {
+		log.Printf("Warning: Failed to record audit event %s: %v", event.Type, err)
+	}
+}
+
+// RecordRequest stores an audit event, taking the client address and user agent from the request
+func (s *AuditService) RecordRequest(r *http.Request, event *models.AuditEvent) {
+	if r != nil {
+		event.IPAddress = ClientIP(r)
+		event.UserAgent = r.UserAgent()
+	}
+	s.Record(event)
+}
+
+// ListUserLogins returns the user's most recent login attempts, newest first
+func (s *AuditService) ListUserLogins(userID, tenantID string, limit int) ([]*models.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if limit <= 0 || limit > maxLoginHistory {
+		limit = maxLoginHistory
+	}
+
+	cursor, err := s.reads(tenantID).Find(ctx, bson.M{
+		"user_id":   userID,
+		"tenant_id": tenantID,
+		"type":      bson.M{"$in": []string{models.AuditEventLoginSuccess, models.AuditEventLoginFailure}},
+	}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	logins := []*models.AuditEvent{}
+	if err := cursor.All(ctx, &logins); err != nil {
+		return nil, err
+	}
+	return logins, nil
+}
+
+// ListActivity returns a tenant's events matching the filter, newest first. cursor is the
+// NextCursor of the previous page, or "" for the first page.
+func (s *AuditService) ListActivity(tenantID string, activity ActivityFilter, cursor string, limit int) (*ActivityPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if limit <= 0 || limit > maxActivityPage {
+		limit = maxActivityPage
+	}
+
+	filter := bson.M{"tenant_id": tenantID}
+	if len(activity.Types) > 0 {
+		filter["type"] = bson.M{"$in": activity.Types}
+	}
+	if activity.ActorID != "" {
+		// Events of users acting on their own account carry no separate actor
+		filter["$or"] = []bson.M{
+			{"actor_id": activity.ActorID},
+			{"actor_id": bson.M{"$exists": false}, "user_id": activity.ActorID},
+		}
+	}
+	if activity.UserID != "" {
+		filter["user_id"] = activity.UserID
+	}
+	if activity.Target != "" {
+		filter["target"] = activity.Target
+	}
+	if cursor != "" {
+		before, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return nil, ErrInvalidActivityCursor
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	// Object IDs grow with their creation time, so they double as a stable page cursor
+	found, err := s.reads(tenantID).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit+1)))
+	if err != nil {
+		return nil, err
+	}
+	defer found.Close(ctx)
+
+	page := &ActivityPage{Events: []*models.AuditEvent{}}
+	if err := found.All(ctx, &page.Events); err != nil {
+		return nil, err
+	}
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+		page.NextCursor = page.Events[limit-1].ID.Hex()
+	}
+	return page, nil
+}
+
+// CountByType counts a tenant's events per type between from (inclusive) and to (exclusive)
+func (s *AuditService) CountByType(tenantID string, from, to time.Time) ([]AuditEventCount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"tenant_id":  tenantID,
+			"created_at": bson.M{"$gte": from, "$lt": to},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$type", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	cursor, err := s.reads(tenantID).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := []AuditEventCount{}
+	err = cursor.All(ctx, &counts)
+	return counts, err
+}
+
+// CountByDay counts a tenant's events of one type per UTC day between from and to
+func (s *AuditService) CountByDay(tenantID, eventType string, from, to time.Time) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"tenant_id":  tenantID,
+			"type":       eventType,
+			"created_at": bson.M{"$gte": from, "$lt": to},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+
+	return aggregateDailyCounts(ctx, s.reads(tenantID), pipeline)
+}
+
+// aggregateDailyCounts runs a pipeline producing {_id: "YYYY-MM-DD", count: n} documents
+func aggregateDailyCounts(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) (map[string]int64, error) {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Date  string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Date] = row.Count
+	}
+	return counts, nil
+}
+
+// ClientIP returns the originating client address, preferring the first X-Forwarded-For entry
+func ClientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		return strings.TrimSpace(realIP)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,527 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Errors returned by the headless authorization flow
+var (
+	ErrFlowNotFound       = errors.New("authorization flow not found")
+	ErrFlowExpired        = errors.New("authorization flow expired")
+	ErrInvalidCSRFToken   = errors.New("invalid CSRF token")
+	ErrInvalidFlowStep    = errors.New("authorization flow is not at this step")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidTwoFactor   = errors.New("invalid two-factor authentication code")
+	ErrTooManyAttempts    = errors.New("too many failed attempts")
+)
+
+// sealedFlowPrefix marks flow IDs that carry the sealed flow instead of referencing a stored one
+const sealedFlowPrefix = "sealed~"
+
+// AuthorizeFlowService drives the step based authorize API (start -> credentials -> 2FA -> consent -> code)
+type AuthorizeFlowService struct {
+	db               *database.MongoDB
+	collection       *mongo.Collection
+	clientService    *ClientService
+	userService      *UserService
+	twoFactorService *TwoFactorService
+	smsOTPService    *SMSOTPService
+	consentService   *ConsentService
+	oauthService     *OAuthService
+	auditService     *AuditService
+	sealer           *FlowSealer // seals flows into their flow IDs instead of storing them
+	flowExpiry       time.Duration
+	maxAttempts      int
+}
+
+// NewAuthorizeFlowService creates the service. A nil sealer stores flows in the database.
+func NewAuthorizeFlowService(db *database.MongoDB, clientService *ClientService, userService *UserService, twoFactorService *TwoFactorService, smsOTPService *SMSOTPService, consentService *ConsentService, oauthService *OAuthService, sealer *FlowSealer) *AuthorizeFlowService {
+	return &AuthorizeFlowService{
+		db:               db,
+		collection:       db.GetCollection("authorize_flows"),
+		clientService:    clientService,
+		userService:      userService,
+		twoFactorService: twoFactorService,
+		smsOTPService:    smsOTPService,
+		consentService:   consentService,
+		oauthService:     oauthService,
+		auditService:     NewAuditService(db),
+		sealer:           sealer,
+		flowExpiry:       time.Minute * 15,
+		maxAttempts:      5,
+	}
+}
+
+// StartFlow validates the authorization request and creates the server-side flow state. The
+// code issued at the end of the flow is bound to the device that started it if the tenant binds codes.
+func (s *AuthorizeFlowService) StartFlow(tenantID, clientID, redirectURI, responseType, scope, state, codeChallenge, codeChallengeMethod string, binding CodeBinding) (*models.AuthorizeFlow, error) {
+	if responseType != "code" {
+		return nil, errors.New("unsupported response type")
+	}
+
+	client, err := s.clientService.GetClientByClientID(clientID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.clientService.ValidateRedirectURI(clientID, redirectURI, tenantID); err != nil {
+		return nil, err
+	}
+	if err := s.oauthService.checkPKCE(client, codeChallenge, codeChallengeMethod); err != nil {
+		return nil, err
+	}
+
+	requestedScopes := ParseScopes(scope)
+	if err := s.oauthService.ValidateScopes(tenantID, client, requestedScopes); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	flow := &models.AuthorizeFlow{
+		ID:                  primitive.NewObjectID(),
+		TenantID:            tenantID,
+		FlowID:              uuid.New().String(),
+		CSRFToken:           s.generateCSRFToken(),
+		Step:                models.AuthorizeFlowStepCredentials,
+		ClientID:            client.ClientID,
+		ClientName:          client.Name,
+		ClientMetadata:      client.ClientMetadata,
+		RedirectURI:         redirectURI,
+		RequestedScopes:     requestedScopes,
+		State:               state,
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: codeChallengeMethod,
+		UserAgent:           binding.UserAgent,
+		IPAddress:           binding.IP,
+		ExpiresAt:           now.Add(s.flowExpiry),
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+
+	if s.sealer != nil {
+		err = s.seal(flow)
+	} else {
+		_, err = s.collection.InsertOne(ctx, flow)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.oauthService.RecordRequestedScopes(tenantID, flow.ClientID, flow.RequestedScopes)
+	s.oauthService.RecordClientEvent(flow.ClientID, ClientMetricAuthorizeRequests)
+
+	return flow, nil
+}
+
+// EnsureIndexes expires flows, and the step guards of sealed flows, once their lifetime is over
+func (s *AuthorizeFlowService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// GetFlow returns the current state of a flow
+func (s *AuthorizeFlowService) GetFlow(flowID, tenantID string) (*models.AuthorizeFlow, error) {
+	if s.sealer != nil && strings.HasPrefix(flowID, sealedFlowPrefix) {
+		return s.openSealed(flowID, tenantID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"flow_id": flowID}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	var flow models.AuthorizeFlow
+	err := s.collection.FindOne(ctx, filter).Decode(&flow)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrFlowNotFound
+		}
+		return nil, err
+	}
+
+	if time.Now().After(flow.ExpiresAt) {
+		return nil, ErrFlowExpired
+	}
+
+	return &flow, nil
+}
+
+// SubmitCredentials verifies the user's login identifier (email, or username or phone if the
+// tenant allows) and password for the flow
+func (s *AuthorizeFlowService) SubmitCredentials(flowID, tenantID, csrfToken, email, password string) (*models.AuthorizeFlow, error) {
+	flow, err := s.loadStep(flowID, tenantID, csrfToken, models.AuthorizeFlowStepCredentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.release(flow.ID, flow.CSRFToken, csrfToken)
+
+	user, _ := s.userService.GetUserByLoginIdentifier(email, flow.TenantID)
+	if !s.userService.CheckCredentials(user, password) || !user.Active {
+		event := &models.AuditEvent{TenantID: flow.TenantID, Type: models.AuditEventLoginFailure, Email: email, ClientID: flow.ClientID, Reason: "invalid_credentials"}
+		if user != nil {
+			event.UserID = user.ID.Hex()
+		}
+		s.auditService.Record(event)
+		return nil, s.recordFailure(flow, csrfToken, ErrInvalidCredentials)
+	}
+
+	flow.UserID = user.ID.Hex()
+	flow.GrantedScopes = GrantUserScopes(flow.RequestedScopes, user)
+
+	if !s.oauthService.HasApplicationAccess(flow.ClientID, user) {
+		s.auditService.Record(&models.AuditEvent{TenantID: flow.TenantID, Type: models.AuditEventLoginFailure, UserID: flow.UserID, ClientID: flow.ClientID, Reason: "application_access_denied"})
+		return nil, ErrApplicationAccessDenied
+	}
+
+	mfa, err := s.twoFactorService.EvaluateMFAPolicy(flow.UserID, flow.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa.Required {
+		if !mfa.Enrolled {
+			s.auditService.Record(&models.AuditEvent{TenantID: flow.TenantID, Type: models.AuditEventLoginFailure, UserID: flow.UserID, ClientID: flow.ClientID, Reason: "mfa_enrollment_required"})
+			return nil, ErrMFAEnrollmentRequired
+		}
+		flow.Step = models.AuthorizeFlowStepTwoFactor
+		return flow, s.save(flow)
+	}
+
+	flow.ACR = ACRSingleFactor
+	return s.afterAuthentication(flow)
+}
+
+// SendTwoFactorSMS texts a sign-in code to the flow's user, who submits it as two-factor code
+func (s *AuthorizeFlowService) SendTwoFactorSMS(flowID, tenantID, csrfToken string) error {
+	flow, err := s.loadStep(flowID, tenantID, csrfToken, models.AuthorizeFlowStepTwoFactor)
+	if err != nil {
+		return err
+	}
+	defer s.release(flow.ID, flow.CSRFToken, csrfToken)
+
+	return s.smsOTPService.SendTwoFactorCode(flow.UserID)
+}
+
+// SubmitTwoFactor verifies the TOTP, SMS or backup code for the flow's user
+func (s *AuthorizeFlowService) SubmitTwoFactor(flowID, tenantID, csrfToken, code string) (*models.AuthorizeFlow, error) {
+	flow, err := s.loadStep(flowID, tenantID, csrfToken, models.AuthorizeFlowStepTwoFactor)
+	if err != nil {
+		return nil, err
+	}
+	defer s.release(flow.ID, flow.CSRFToken, csrfToken)
+
+	valid, err := s.twoFactorService.VerifyTwoFactor(flow.UserID, code)
+	if err != nil || !valid {
+		s.auditService.Record(&models.AuditEvent{TenantID: flow.TenantID, Type: models.AuditEventLoginFailure, UserID: flow.UserID, ClientID: flow.ClientID, Reason: "invalid_two_factor"})
+		return nil, s.recordFailure(flow, csrfToken, ErrInvalidTwoFactor)
+	}
+
+	flow.ACR = ACRMultiFactor
+	return s.afterAuthentication(flow)
+}
+
+// SubmitConsent records the user's consent decision and completes the flow. approvedScopes
+// narrows the grant to the optional scopes the user kept (nil approves all of them) and
+// declinedClaims lists the optional claims the user does not want to share.
+func (s *AuthorizeFlowService) SubmitConsent(flowID, tenantID, csrfToken string, approve bool, approvedScopes, declinedClaims []string) (*models.AuthorizeFlow, error) {
+	flow, err := s.loadStep(flowID, tenantID, csrfToken, models.AuthorizeFlowStepConsent)
+	if err != nil {
+		return nil, err
+	}
+	defer s.release(flow.ID, flow.CSRFToken, csrfToken)
+
+	if !approve {
+		redirectTo, err := s.buildRedirect(flow.RedirectURI, map[string]string{
+			"error": "access_denied",
+			"state": flow.State,
+		})
+		if err != nil {
+			return nil, err
+		}
+		flow.Step = models.AuthorizeFlowStepComplete
+		flow.RedirectTo = redirectTo
+		return flow, s.save(flow)
+	}
+
+	decision := ResolveConsent(flow.GrantedScopes, approvedScopes, declinedClaims)
+	if err := s.consentService.GrantConsent(flow.UserID, flow.ClientID, flow.TenantID, decision); err != nil {
+		return nil, err
+	}
+	s.oauthService.RecordClientEvent(flow.ClientID, ClientMetricConsents)
+	flow.GrantedScopes = decision.ApprovedScopes
+
+	return s.complete(flow)
+}
+
+// afterAuthentication moves an authenticated flow to consent, or completes it if consent was already given
+func (s *AuthorizeFlowService) afterAuthentication(flow *models.AuthorizeFlow) (*models.AuthorizeFlow, error) {
+	s.auditService.Record(&models.AuditEvent{TenantID: flow.TenantID, Type: models.AuditEventLoginSuccess, UserID: flow.UserID, ClientID: flow.ClientID})
+	s.oauthService.RecordClientEvent(flow.ClientID, ClientMetricLogins)
+
+	if s.consentService.HasConsent(flow.UserID, flow.ClientID, flow.TenantID, flow.GrantedScopes) {
+		flow.GrantedScopes = s.consentService.FilterGranted(flow.UserID, flow.ClientID, flow.TenantID, flow.GrantedScopes)
+		return s.complete(flow)
+	}
+
+	flow.Step = models.AuthorizeFlowStepConsent
+	return flow, s.save(flow)
+}
+
+// complete issues the authorization code and computes the client redirect
+func (s *AuthorizeFlowService) complete(flow *models.AuthorizeFlow) (*models.AuthorizeFlow, error) {
+	code, err := s.oauthService.CreateAuthorizationCode(flow.ClientID, flow.UserID, flow.TenantID, flow.RedirectURI, flow.GrantedScopes, flow.CodeChallenge, flow.CodeChallengeMethod, CodeBinding{UserAgent: flow.UserAgent, IP: flow.IPAddress}, flow.ACR)
+	if err != nil {
+		return nil, err
+	}
+
+	redirectTo, err := s.buildRedirect(flow.RedirectURI, map[string]string{
+		"code":  code,
+		"state": flow.State,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	flow.Step = models.AuthorizeFlowStepComplete
+	flow.RedirectTo = redirectTo
+	return flow, s.save(flow)
+}
+
+// loadStep loads a flow, checks the CSRF token and expected step and claims the step: the
+// stored flow's CSRF token is swapped for a claim token, so concurrent submissions of the
+// same step fail until the step moves on, fails or is released. A sealed flow is claimed
+// through a guard record under the flow's ID, which keeps its current step, CSRF token and
+// failed attempts, so flow IDs of earlier steps can't be replayed.
+func (s *AuthorizeFlowService) loadStep(flowID, tenantID, csrfToken, step string) (*models.AuthorizeFlow, error) {
+	flow, err := s.GetFlow(flowID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if csrfToken == "" || subtle.ConstantTimeCompare([]byte(csrfToken), []byte(flow.CSRFToken)) != 1 {
+		return nil, ErrInvalidCSRFToken
+	}
+
+	if flow.FailedAttempts >= s.maxAttempts {
+		return nil, ErrTooManyAttempts
+	}
+
+	if flow.Step != step {
+		return nil, ErrInvalidFlowStep
+	}
+
+	return s.claimStep(flow, csrfToken)
+}
+
+// claimStep atomically swaps the flow's CSRF token for a claim token while the flow is still
+// at the step, with the submitted token and below the attempt limit. The first claim of a
+// sealed flow creates its guard; later claims that don't match the guard collide with it.
+func (s *AuthorizeFlowService) claimStep(flow *models.AuthorizeFlow, csrfToken string) (*models.AuthorizeFlow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":             flow.ID,
+		"step":            flow.Step,
+		"csrf_token":      csrfToken,
+		"failed_attempts": bson.M{"$lt": s.maxAttempts},
+	}
+	update := bson.M{"$set": bson.M{"csrf_token": s.generateCSRFToken(), "updated_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if s.sealer != nil {
+		update["$setOnInsert"] = bson.M{"tenant_id": flow.TenantID, "failed_attempts": 0, "expires_at": flow.ExpiresAt, "created_at": time.Now()}
+		opts.SetUpsert(true)
+	}
+
+	var claimed models.AuthorizeFlow
+	if err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&claimed); err != nil {
+		if err == mongo.ErrNoDocuments || mongo.IsDuplicateKeyError(err) {
+			// The step was claimed, moved on or failed too often since the flow was loaded
+			return nil, s.claimError(flow.ID)
+		}
+		return nil, err
+	}
+
+	if s.sealer != nil {
+		flow.CSRFToken = claimed.CSRFToken
+		flow.FailedAttempts = claimed.FailedAttempts
+		return flow, nil
+	}
+	claimed.FlowID = flow.FlowID
+	return &claimed, nil
+}
+
+// claimError tells why a step couldn't be claimed
+func (s *AuthorizeFlowService) claimError(flowID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var current models.AuthorizeFlow
+	if err := s.collection.FindOne(ctx, bson.M{"_id": flowID}).Decode(&current); err == nil && current.FailedAttempts >= s.maxAttempts {
+		return ErrTooManyAttempts
+	}
+	return ErrInvalidCSRFToken
+}
+
+// release hands a claimed step back to the submitted CSRF token, so the user can retry a step
+// that ended without moving on. It does nothing once the step was saved or failed.
+func (s *AuthorizeFlowService) release(flowID primitive.ObjectID, claimToken, csrfToken string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.collection.UpdateOne(ctx, bson.M{"_id": flowID, "csrf_token": claimToken}, bson.M{
+		"$set": bson.M{"csrf_token": csrfToken, "updated_at": time.Now()},
+	})
+}
+
+// recordFailure increments the flow's failed attempt counter and returns the given error.
+// The submitted CSRF token is restored so the user can retry the same step.
+func (s *AuthorizeFlowService) recordFailure(flow *models.AuthorizeFlow, csrfToken string, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$inc": bson.M{"failed_attempts": 1},
+		"$set": bson.M{"csrf_token": csrfToken, "updated_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var failed models.AuthorizeFlow
+	err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": flow.ID, "csrf_token": flow.CSRFToken}, update, opts).Decode(&failed)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrInvalidCSRFToken
+		}
+		return err
+	}
+	if failed.FailedAttempts >= s.maxAttempts {
+		return ErrTooManyAttempts
+	}
+	return cause
+}
+
+// save persists a step transition of a claimed flow and rotates the CSRF token for the next step.
+// The guard of a sealed flow only moves to the new step and token; the flow itself is resealed.
+func (s *AuthorizeFlowService) save(flow *models.AuthorizeFlow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	claimToken := flow.CSRFToken
+	flow.CSRFToken = s.generateCSRFToken()
+	flow.UpdatedAt = time.Now()
+	fields := bson.M{
+		"csrf_token": flow.CSRFToken,
+		"step":       flow.Step,
+		"updated_at": flow.UpdatedAt,
+	}
+	if s.sealer == nil {
+		fields["user_id"] = flow.UserID
+		fields["granted_scopes"] = flow.GrantedScopes
+		fields["acr"] = flow.ACR
+		fields["redirect_to"] = flow.RedirectTo
+	}
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": flow.ID, "csrf_token": claimToken}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidCSRFToken
+	}
+
+	if s.sealer != nil {
+		return s.seal(flow)
+	}
+	return nil
+}
+
+// seal replaces the flow ID with the sealed flow, so the next step can be served by any replica
+func (s *AuthorizeFlowService) seal(flow *models.AuthorizeFlow) error {
+	sealedFlow := *flow
+	sealedFlow.FlowID = ""
+	payload, err := bson.Marshal(&sealedFlow)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := s.sealer.Seal(payload)
+	if err != nil {
+		return err
+	}
+	flow.FlowID = sealedFlowPrefix + sealed
+	return nil
+}
+
+// openSealed returns the flow sealed into a flow ID
+func (s *AuthorizeFlowService) openSealed(flowID, tenantID string) (*models.AuthorizeFlow, error) {
+	payload, err := s.sealer.Open(strings.TrimPrefix(flowID, sealedFlowPrefix))
+	if err != nil {
+		return nil, ErrFlowNotFound
+	}
+
+	var flow models.AuthorizeFlow
+	if err := bson.Unmarshal(payload, &flow); err != nil {
+		return nil, ErrFlowNotFound
+	}
+	if tenantID != "" && flow.TenantID != tenantID {
+		return nil, ErrFlowNotFound
+	}
+	if time.Now().After(flow.ExpiresAt) {
+		return nil, ErrFlowExpired
+	}
+
+	flow.FlowID = flowID
+	return &flow, nil
+}
+
+func (s *AuthorizeFlowService) buildRedirect(redirectURI string, params map[string]string) (string, error) {
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
+
+	query := redirectURL.Query()
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	redirectURL.RawQuery = query.Encode()
+
+	return redirectURL.String(), nil
+}
+
+func (s *AuthorizeFlowService) generateCSRFToken() string {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestAuthorizeFlowIssuesCode drives the headless flow through credentials and consent and
+// exchanges the resulting code, then checks that the consent is remembered by the next flow
+func TestAuthorizeFlowIssuesCode(t *testing.T) {
+	db := dbtest.New(t)
+
+	now := time.Now()
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), ClientID: "spa", Name: "SPA",
+		RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	userService := NewUserService(db)
+	user := &models.User{Email: "jane@example.com", PasswordHash: "password1", Scopes: []string{"openid", "profile"}, Active: true}
+	if err := userService.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+	flows := NewAuthorizeFlowService(db, NewClientService(db), userService, NewTwoFactorService(db, DefaultLifetimes()), nil,
+		NewConsentService(db), oauthService, nil)
+
+	verifier := "a-sufficiently-long-code-verifier-for-the-test-0123456789"
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+	start := func() *models.AuthorizeFlow {
+		t.Helper()
+		flow, err := flows.StartFlow("", "spa", "https://app.example.com/cb", "code", "openid profile", "xyz", challenge, PKCEMethodS256, CodeBinding{})
+		if err != nil {
+			t.Fatalf("StartFlow() error = %v", err)
+		}
+		if flow.Step != models.AuthorizeFlowStepCredentials {
+			t.Fatalf("Expected the flow to start at the credentials step, got %s", flow.Step)
+		}
+		return flow
+	}
+
+	flow := start()
+	if _, err := flows.SubmitCredentials(flow.FlowID, "", "forged", "jane@example.com", "password1"); err != ErrInvalidCSRFToken {
+		t.Errorf("SubmitCredentials() with a wrong CSRF token error = %v", err)
+	}
+	if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("SubmitCredentials() with a wrong password error = %v", err)
+	}
+	consent, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "password1")
+	if err != nil {
+		t.Fatalf("SubmitCredentials() error = %v", err)
+	}
+	if consent.Step != models.AuthorizeFlowStepConsent || consent.CSRFToken == flow.CSRFToken {
+		t.Fatalf("Expected the consent step with a new CSRF token, got %s", consent.Step)
+	}
+	if _, err := flows.SubmitConsent(flow.FlowID, "", flow.CSRFToken, true, nil, nil); err != ErrInvalidCSRFToken {
+		t.Errorf("SubmitConsent() with the previous step's CSRF token error = %v", err)
+	}
+
+	done, err := flows.SubmitConsent(flow.FlowID, "", consent.CSRFToken, true, nil, nil)
+	if err != nil {
+		t.Fatalf("SubmitConsent() error = %v", err)
+	}
+	redirect, err := url.Parse(done.RedirectTo)
+	if err != nil || done.Step != models.AuthorizeFlowStepComplete || redirect.Query().Get("state") != "xyz" {
+		t.Fatalf("Expected a completed flow redirecting with the state, got %s %q", done.Step, done.RedirectTo)
+	}
+
+	tokens, err := oauthService.ExchangeCodeForTokensPKCE(redirect.Query().Get("code"), "spa", verifier, "https://app.example.com/cb", "", httptest.NewRequest("POST", "/oauth/token", nil))
+	if err != nil {
+		t.Fatalf("Failed to exchange the flow's code: %v", err)
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokens.IDToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	// The acr of the login survives the consent step
+	if claims["sub"] != user.ID.Hex() || claims["acr"] != ACRSingleFactor {
+		t.Errorf("Expected an ID token for the user with acr %s, got sub %v acr %v", ACRSingleFactor, claims["sub"], claims["acr"])
+	}
+	if tokens.Scope != "openid profile" {
+		t.Errorf("Expected the consented scopes, got %q", tokens.Scope)
+	}
+
+	// Consent was given, so the next login completes right away
+	flow = start()
+	done, err = flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "password1")
+	if err != nil {
+		t.Fatalf("SubmitCredentials() error = %v", err)
+	}
+	if done.Step != models.AuthorizeFlowStepComplete {
+		t.Errorf("Expected the flow to complete without asking for consent again, got %s", done.Step)
+	}
+}
+
+// TestAuthorizeFlowClaimsSteps checks that a step is claimed by one submission at a time and
+// that failed attempts are counted against the stored flow
+func TestAuthorizeFlowClaimsSteps(t *testing.T) {
+	db := dbtest.New(t)
+
+	now := time.Now()
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), ClientID: "spa", Name: "SPA",
+		RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	userService := NewUserService(db)
+	user := &models.User{Email: "jane@example.com", PasswordHash: "password1", Scopes: []string{"openid"}, Active: true}
+	if err := userService.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	flows := NewAuthorizeFlowService(db, NewClientService(db), userService, NewTwoFactorService(db, DefaultLifetimes()), nil,
+		NewConsentService(db), NewOAuthService(db, "test-secret", DefaultLifetimes()), nil)
+
+	verifier := "a-sufficiently-long-code-verifier-for-the-test-0123456789"
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+	flow, err := flows.StartFlow("", "spa", "https://app.example.com/cb", "code", "openid", "xyz", challenge, PKCEMethodS256, CodeBinding{})
+	if err != nil {
+		t.Fatalf("StartFlow() error = %v", err)
+	}
+
+	// While a submission holds the step, a concurrent one with the same token is turned away
+	claimed, err := flows.loadStep(flow.FlowID, "", flow.CSRFToken, models.AuthorizeFlowStepCredentials)
+	if err != nil {
+		t.Fatalf("loadStep() error = %v", err)
+	}
+	if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "password1"); err != ErrInvalidCSRFToken {
+		t.Errorf("SubmitCredentials() on a claimed step error = %v, want %v", err, ErrInvalidCSRFToken)
+	}
+	flows.release(claimed.ID, claimed.CSRFToken, flow.CSRFToken)
+
+	for i := 1; i < flows.maxAttempts; i++ {
+		if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "wrong"); err != ErrInvalidCredentials {
+			t.Fatalf("Attempt %d: SubmitCredentials() error = %v, want %v", i, err, ErrInvalidCredentials)
+		}
+	}
+	if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "wrong"); err != ErrTooManyAttempts {
+		t.Errorf("Last attempt: SubmitCredentials() error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "password1"); err != ErrTooManyAttempts {
+		t.Errorf("SubmitCredentials() after the attempt limit error = %v, want %v", err, ErrTooManyAttempts)
+	}
+}
+
+// TestSealedAuthorizeFlowRejectsReplays checks that the flow ID of a completed step of a sealed
+// flow can't be used again and that failed attempts of sealed flows are counted
+func TestSealedAuthorizeFlowRejectsReplays(t *testing.T) {
+	db := dbtest.New(t)
+
+	now := time.Now()
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), ClientID: "spa", Name: "SPA",
+		RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	userService := NewUserService(db)
+	user := &models.User{Email: "jane@example.com", PasswordHash: "password1", Scopes: []string{"openid"}, Active: true}
+	if err := userService.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	flows := NewAuthorizeFlowService(db, NewClientService(db), userService, NewTwoFactorService(db, DefaultLifetimes()), nil,
+		NewConsentService(db), NewOAuthService(db, "test-secret", DefaultLifetimes()), NewFlowSealer("replica-secret"))
+
+	verifier := "a-sufficiently-long-code-verifier-for-the-test-0123456789"
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+	start := func() *models.AuthorizeFlow {
+		t.Helper()
+		flow, err := flows.StartFlow("", "spa", "https://app.example.com/cb", "code", "openid", "xyz", challenge, PKCEMethodS256, CodeBinding{})
+		if err != nil {
+			t.Fatalf("StartFlow() error = %v", err)
+		}
+		return flow
+	}
+
+	flow := start()
+	consent, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "password1")
+	if err != nil {
+		t.Fatalf("SubmitCredentials() error = %v", err)
+	}
+	if consent.Step != models.AuthorizeFlowStepConsent {
+		t.Fatalf("Expected the consent step, got %s", consent.Step)
+	}
+	if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "password1"); err != ErrInvalidCSRFToken {
+		t.Errorf("Replayed credentials step error = %v, want %v", err, ErrInvalidCSRFToken)
+	}
+
+	if _, err := flows.SubmitConsent(consent.FlowID, "", consent.CSRFToken, true, nil, nil); err != nil {
+		t.Fatalf("SubmitConsent() error = %v", err)
+	}
+	if _, err := flows.SubmitConsent(consent.FlowID, "", consent.CSRFToken, true, nil, nil); err != ErrInvalidCSRFToken {
+		t.Errorf("Replayed consent step error = %v, want %v", err, ErrInvalidCSRFToken)
+	}
+
+	flow = start()
+	for i := 1; i < flows.maxAttempts; i++ {
+		if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "wrong"); err != ErrInvalidCredentials {
+			t.Fatalf("Attempt %d: SubmitCredentials() error = %v, want %v", i, err, ErrInvalidCredentials)
+		}
+	}
+	if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "wrong"); err != ErrTooManyAttempts {
+		t.Errorf("Last attempt: SubmitCredentials() error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if _, err := flows.SubmitCredentials(flow.FlowID, "", flow.CSRFToken, "jane@example.com", "password1"); err != ErrTooManyAttempts {
+		t.Errorf("SubmitCredentials() after the attempt limit error = %v, want %v", err, ErrTooManyAttempts)
+	}
+}
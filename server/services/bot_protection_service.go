@@ -0,0 +1,385 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// powChallengeLifetime is how long a proof-of-work challenge can be solved and used
+	powChallengeLifetime = 5 * time.Minute
+	// defaultPoWDifficulty takes a browser about a second to solve
+	defaultPoWDifficulty = 20
+	// bypassKeyPrefix starts every bypass key, so leaked keys are easy to spot
+	bypassKeyPrefix = "bpk_"
+)
+
+var (
+	ErrBotCheckFailed      = errors.New("bot protection check failed")
+	ErrBypassKeyNotFound   = errors.New("bypass key not found")
+	ErrBotSecretKeyMissing = errors.New("the provider needs a secret key")
+)
+
+// botVerifyURLs are the server-side verification endpoints of the CAPTCHA providers. They all take
+// secret, response and remoteip form fields and answer with a JSON success flag.
+var botVerifyURLs = map[string]string{
+	models.BotProviderRecaptcha: "https://www.google.com/recaptcha/api/siteverify",
+	models.BotProviderHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	models.BotProviderTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// BotChallenge tells a login or registration page how to prove it isn't a bot: the CAPTCHA widget
+// to render, or a proof-of-work challenge to solve
+type BotChallenge struct {
+	Provider   string     `json:"provider"`
+	Endpoints  []string   `json:"endpoints,omitempty"`
+	SiteKey    string     `json:"site_key,omitempty"`
+	Challenge  string     `json:"challenge,omitempty"`
+	Difficulty int        `json:"difficulty,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// BotCheck is what a request to a guarded endpoint offers to pass bot protection
+type BotCheck struct {
+	Token     string // CAPTCHA response, or "<challenge>.<solution>" for proof of work
+	BypassKey string
+	RemoteIP  string
+}
+
+// powChallenge is sealed into proof-of-work challenges, so they need no storage until used
+type powChallenge struct {
+	TenantID   string    `bson:"tenant_id"`
+	Nonce      string    `bson:"nonce"`
+	Difficulty int       `bson:"difficulty"`
+	ExpiresAt  time.Time `bson:"expires_at"`
+}
+
+// BotProtectionService keeps bots off public endpoints according to each tenant's policy, with
+// reCAPTCHA, hCaptcha, Turnstile or a built-in proof-of-work challenge
+type BotProtectionService struct {
+	policies       *mongo.Collection
+	bypassKeys     *mongo.Collection
+	usedChallenges *mongo.Collection
+	sealer         *FlowSealer
+	httpClient     *http.Client
+	verifyURLs     map[string]string
+}
+
+// NewBotProtectionService seals proof-of-work challenges with a key derived from secret
+func NewBotProtectionService(db *database.MongoDB, secret string) *BotProtectionService {
+	return &BotProtectionService{
+		policies:       db.GetCollection("bot_protection_policies"),
+		bypassKeys:     db.GetCollection("bot_bypass_keys"),
+		usedChallenges: db.GetCollection("bot_used_challenges"),
+		sealer:         NewFlowSealer("bot-protection\x00" + secret),
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+		verifyURLs:     botVerifyURLs,
+	}
+}
+
+// EnsureIndexes creates the bypass key lookup index and expires used proof-of-work challenges
+func (s *BotProtectionService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := s.bypassKeys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	_, err := s.usedChallenges.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// GetPolicy returns the tenant's policy; tenants without one get an empty (disabled) policy
+func (s *BotProtectionService) GetPolicy(tenantID string) (*models.BotProtectionPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var policy models.BotProtectionPolicy
+	err := s.policies.FindOne(ctx, bson.M{"_id": tenantID}).Decode(&policy)
+	if err == mongo.ErrNoDocuments {
+		return &models.BotProtectionPolicy{TenantID: tenantID, Endpoints: []string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.HasSecretKey = policy.SecretKey != ""
+	return &policy, nil
+}
+
+// SetPolicy replaces the tenant's policy. An empty secret key keeps the stored one. CAPTCHA
+// providers can't be turned on without a secret key.
+func (s *BotProtectionService) SetPolicy(policy *models.BotProtectionPolicy) error {
+	current, err := s.GetPolicy(policy.TenantID)
+	if err != nil {
+		return err
+	}
+	if policy.SecretKey == "" {
+		policy.SecretKey = current.SecretKey
+	}
+	if _, captcha := botVerifyURLs[policy.Provider]; captcha && policy.SecretKey == "" {
+		return ErrBotSecretKeyMissing
+	}
+	if policy.Endpoints == nil {
+		policy.Endpoints = []string{}
+	}
+	policy.UpdatedAt = time.Now()
+	policy.HasSecretKey = policy.SecretKey != ""
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = s.policies.ReplaceOne(ctx, bson.M{"_id": policy.TenantID}, policy, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Challenge returns what a page of the tenant needs to pass bot protection, with a fresh
+// proof-of-work challenge for the pow provider
+func (s *BotProtectionService) Challenge(tenantID string) (*BotChallenge, error) {
+	policy, err := s.GetPolicy(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	challenge := &BotChallenge{Provider: policy.Provider, Endpoints: policy.Endpoints}
+	switch policy.Provider {
+	case "":
+	case models.BotProviderPoW:
+		expiresAt := time.Now().Add(powChallengeLifetime)
+		sealed, difficulty, err := s.newPoWChallenge(tenantID, policy.PoWDifficulty, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		challenge.Challenge, challenge.Difficulty, challenge.ExpiresAt = sealed, difficulty, &expiresAt
+	default:
+		challenge.SiteKey = policy.SiteKey
+	}
+	return challenge, nil
+}
+
+func (s *BotProtectionService) newPoWChallenge(tenantID string, difficulty int, expiresAt time.Time) (string, int, error) {
+	if difficulty <= 0 {
+		difficulty = defaultPoWDifficulty
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", 0, err
+	}
+	payload, err := bson.Marshal(powChallenge{TenantID: tenantID, Nonce: hex.EncodeToString(nonce), Difficulty: difficulty, ExpiresAt: expiresAt})
+	if err != nil {
+		return "", 0, err
+	}
+	sealed, err := s.sealer.Seal(payload)
+	return sealed, difficulty, err
+}
+
+// Verify lets a request to an endpoint of the tenant through if the tenant doesn't guard the
+// endpoint, the request carries a bypass key of the tenant or its challenge response checks out.
+// It returns ErrBotCheckFailed otherwise.
+func (s *BotProtectionService) Verify(tenantID, endpoint string, check BotCheck) error {
+	policy, err := s.GetPolicy(tenantID)
+	if err != nil {
+		return err
+	}
+	if !policy.Guards(endpoint) {
+		return nil
+	}
+	if check.BypassKey != "" {
+		if s.useBypassKey(tenantID, check.BypassKey) {
+			return nil
+		}
+		return ErrBotCheckFailed
+	}
+	if check.Token == "" {
+		return ErrBotCheckFailed
+	}
+
+	if policy.Provider == models.BotProviderPoW {
+		return s.verifyPoW(tenantID, check.Token)
+	}
+	return s.verifyCaptcha(policy, check)
+}
+
+// verifyPoW checks a "<challenge>.<solution>" token: the challenge must be one of the tenant's,
+// unexpired and unused, and the SHA-256 of "<challenge>:<solution>" must start with the
+// challenge's number of zero bits
+func (s *BotProtectionService) verifyPoW(tenantID, token string) error {
+	sealed, solution, found := strings.Cut(token, ".")
+	if !found || solution == "" || len(solution) > 64 {
+		return ErrBotCheckFailed
+	}
+	payload, err := s.sealer.Open(sealed)
+	if err != nil {
+		return ErrBotCheckFailed
+	}
+	var challenge powChallenge
+	if err := bson.Unmarshal(payload, &challenge); err != nil {
+		return ErrBotCheckFailed
+	}
+	if challenge.TenantID != tenantID || time.Now().After(challenge.ExpiresAt) {
+		return ErrBotCheckFailed
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(sealed+":"+solution))) < challenge.Difficulty {
+		return ErrBotCheckFailed
+	}
+
+	// Each challenge passes once
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = s.usedChallenges.InsertOne(ctx, bson.M{"_id": challenge.Nonce, "expires_at": challenge.ExpiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrBotCheckFailed
+	}
+	return err
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// verifyCaptcha asks the CAPTCHA provider whether the response token is valid
+func (s *BotProtectionService) verifyCaptcha(policy *models.BotProtectionPolicy, check BotCheck) error {
+	verifyURL, ok := s.verifyURLs[policy.Provider]
+	if !ok {
+		return fmt.Errorf("unknown bot protection provider %q", policy.Provider)
+	}
+
+	form := url.Values{"secret": {policy.SecretKey}, "response": {check.Token}}
+	if check.RemoteIP != "" {
+		form.Set("remoteip", check.RemoteIP)
+	}
+	resp, err := s.httpClient.PostForm(verifyURL, form)
+	if err != nil {
+		return fmt.Errorf("bot protection provider unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bot protection provider returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success bool     `json:"success"`
+		Score   *float64 `json:"score"` // reCAPTCHA v3 only
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid bot protection provider response: %w", err)
+	}
+	if !result.Success {
+		return ErrBotCheckFailed
+	}
+	if policy.MinScore > 0 && result.Score != nil && *result.Score < policy.MinScore {
+		return ErrBotCheckFailed
+	}
+	return nil
+}
+
+// CreateBypassKey issues a bypass key for the tenant. The key is only returned here.
+func (s *BotProtectionService) CreateBypassKey(tenantID, name, createdBy string) (*models.BotBypassKey, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	key := bypassKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	record := &models.BotBypassKey{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantID,
+		Name:      name,
+		KeyHash:   hashBypassKey(key),
+		Prefix:    key[:len(bypassKeyPrefix)+6],
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.bypassKeys.InsertOne(ctx, record); err != nil {
+		return nil, "", err
+	}
+	return record, key, nil
+}
+
+// ListBypassKeys returns the tenant's bypass keys, newest first
+func (s *BotProtectionService) ListBypassKeys(tenantID string) ([]*models.BotBypassKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.bypassKeys.Find(ctx, bson.M{"tenant_id": tenantID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []*models.BotBypassKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeleteBypassKey revokes a bypass key of the tenant
+func (s *BotProtectionService) DeleteBypassKey(tenantID, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrBypassKeyNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.bypassKeys.DeleteOne(ctx, bson.M{"_id": objectID, "tenant_id": tenantID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrBypassKeyNotFound
+	}
+	return nil
+}
+
+// useBypassKey reports whether key is a bypass key of the tenant and records its use
+func (s *BotProtectionService) useBypassKey(tenantID, key string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.bypassKeys.UpdateOne(ctx, bson.M{"key_hash": hashBypassKey(key), "tenant_id": tenantID},
+		bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+	return err == nil && result.MatchedCount > 0
+}
+
+func hashBypassKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+)
+
+// solvePoW finds a solution to a proof-of-work challenge the way a login page would
+func solvePoW(t *testing.T, challenge *BotChallenge) string {
+	t.Helper()
+	for i := 0; i < 1<<24; i++ {
+		solution := strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(challenge.Challenge+":"+solution))) >= challenge.Difficulty {
+			return challenge.Challenge + "." + solution
+		}
+	}
+	t.Fatal("no proof-of-work solution found")
+	return ""
+}
+
+// TestBotProtectionProofOfWork lets a solved challenge through once, and only for its tenant and
+// the endpoints the policy guards
+func TestBotProtectionProofOfWork(t *testing.T) {
+	db := dbtest.New(t)
+	service := NewBotProtectionService(db, "test-secret")
+	if err := service.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+
+	if err := service.Verify("t1", models.BotEndpointLogin, BotCheck{}); err != nil {
+		t.Errorf("Verify() without a policy error = %v, want nil", err)
+	}
+
+	policy := &models.BotProtectionPolicy{TenantID: "t1", Provider: models.BotProviderPoW, Endpoints: []string{models.BotEndpointLogin}, PoWDifficulty: 8}
+	if err := service.SetPolicy(policy); err != nil {
+		t.Fatalf("SetPolicy() error = %v", err)
+	}
+
+	challenge, err := service.Challenge("t1")
+	if err != nil {
+		t.Fatalf("Challenge() error = %v", err)
+	}
+	if challenge.Provider != models.BotProviderPoW || challenge.Difficulty != 8 || challenge.Challenge == "" {
+		t.Fatalf("Challenge() = %+v, want an 8 bit proof-of-work challenge", challenge)
+	}
+	token := solvePoW(t, challenge)
+
+	if err := service.Verify("t2", models.BotEndpointLogin, BotCheck{Token: token}); err != nil {
+		t.Errorf("Verify() for a tenant without a policy error = %v, want nil", err)
+	}
+	if err := service.Verify("t1", models.BotEndpointRegister, BotCheck{}); err != nil {
+		t.Errorf("Verify() of an unguarded endpoint error = %v, want nil", err)
+	}
+	for name, check := range map[string]BotCheck{
+		"missing token":    {},
+		"unsolved":         {Token: challenge.Challenge + ".x"},
+		"tampered":         {Token: "x" + token},
+		"unknown key":      {BypassKey: "bpk_unknown"},
+		"without solution": {Token: challenge.Challenge},
+	} {
+		if err := service.Verify("t1", models.BotEndpointLogin, check); !errors.Is(err, ErrBotCheckFailed) {
+			t.Errorf("Verify() %s error = %v, want ErrBotCheckFailed", name, err)
+		}
+	}
+
+	if err := service.Verify("t1", models.BotEndpointLogin, BotCheck{Token: token}); err != nil {
+		t.Errorf("Verify() of a solved challenge error = %v, want nil", err)
+	}
+	if err := service.Verify("t1", models.BotEndpointLogin, BotCheck{Token: token}); !errors.Is(err, ErrBotCheckFailed) {
+		t.Errorf("Verify() of a reused challenge error = %v, want ErrBotCheckFailed", err)
+	}
+
+	record, key, err := service.CreateBypassKey("t1", "monitoring", "admin")
+	if err != nil {
+		t.Fatalf("CreateBypassKey() error = %v", err)
+	}
+	if err := service.Verify("t1", models.BotEndpointLogin, BotCheck{BypassKey: key}); err != nil {
+		t.Errorf("Verify() with a bypass key error = %v, want nil", err)
+	}
+	if err := service.DeleteBypassKey("t1", record.ID.Hex()); err != nil {
+		t.Fatalf("DeleteBypassKey() error = %v", err)
+	}
+	if err := service.Verify("t1", models.BotEndpointLogin, BotCheck{BypassKey: key}); !errors.Is(err, ErrBotCheckFailed) {
+		t.Errorf("Verify() with a revoked bypass key error = %v, want ErrBotCheckFailed", err)
+	}
+}
+
+// TestBotProtectionCaptcha asks the provider about the response token and applies the minimum
+// reCAPTCHA score
+func TestBotProtectionCaptcha(t *testing.T) {
+	db := dbtest.New(t)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") != "captcha-secret" || r.PostFormValue("remoteip") != "203.0.113.7" {
+			w.Write([]byte(`{"success":false}`))
+			return
+		}
+		switch r.PostFormValue("response") {
+		case "human":
+			w.Write([]byte(`{"success":true,"score":0.9}`))
+		case "suspicious":
+			w.Write([]byte(`{"success":true,"score":0.2}`))
+		default:
+			w.Write([]byte(`{"success":false}`))
+		}
+	}))
+	defer provider.Close()
+
+	service := NewBotProtectionService(db, "test-secret")
+	service.verifyURLs = map[string]string{models.BotProviderRecaptcha: provider.URL}
+
+	policy := &models.BotProtectionPolicy{TenantID: "t1", Provider: models.BotProviderRecaptcha, SiteKey: "site", MinScore: 0.5}
+	if err := service.SetPolicy(policy); !errors.Is(err, ErrBotSecretKeyMissing) {
+		t.Fatalf("SetPolicy() without a secret key error = %v, want ErrBotSecretKeyMissing", err)
+	}
+	policy.SecretKey = "captcha-secret"
+	if err := service.SetPolicy(policy); err != nil {
+		t.Fatalf("SetPolicy() error = %v", err)
+	}
+	// An empty secret key keeps the stored one
+	if err := service.SetPolicy(&models.BotProtectionPolicy{TenantID: "t1", Provider: models.BotProviderRecaptcha, SiteKey: "site", MinScore: 0.5}); err != nil {
+		t.Fatalf("SetPolicy() keeping the secret key error = %v", err)
+	}
+
+	for token, want := range map[string]error{"human": nil, "suspicious": ErrBotCheckFailed, "bot": ErrBotCheckFailed} {
+		err := service.Verify("t1", models.BotEndpointRegister, BotCheck{Token: token, RemoteIP: "203.0.113.7"})
+		if !errors.Is(err, want) {
+			t.Errorf("Verify(%s) error = %v, want %v", token, err, want)
+		}
+	}
+}
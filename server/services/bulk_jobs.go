@@ -0,0 +1,330 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserImportParams are the parameters of a user import job
+type UserImportParams struct {
+	Users []UserImportItem `bson:"users"`
+}
+
+// UserImportItem is one user to import. Groups may be given by name or ID; users without scopes
+// get the tenant's registration default scopes.
+type UserImportItem struct {
+	Email     string   `bson:"email" json:"email" validate:"required,email,max=254"`
+	Username  string   `bson:"username,omitempty" json:"username" validate:"max=64"`
+	Phone     string   `bson:"phone,omitempty" json:"phone" validate:"max=32"`
+	Password  string   `bson:"password" json:"password" validate:"required,min=6,max=72"`
+	FirstName string   `bson:"first_name,omitempty" json:"first_name" validate:"max=100"`
+	LastName  string   `bson:"last_name,omitempty" json:"last_name" validate:"max=100"`
+	Groups    []string `bson:"groups,omitempty" json:"groups"`
+	Scopes    []string `bson:"scopes,omitempty" json:"scopes" validate:"dive,max=100"`
+}
+
+// TokenRevocationParams select the tokens a token revocation job revokes in the job's tenant;
+// empty fields don't filter
+type TokenRevocationParams struct {
+	ClientID string `bson:"client_id,omitempty"`
+	UserID   string `bson:"user_id,omitempty"`
+}
+
+// TenantPurgeParams name the deactivated tenant a tenant purge job deletes
+type TenantPurgeParams struct {
+	TenantID string `bson:"tenant_id"`
+}
+
+// UserImportJob creates the users of an import one by one. Users whose email, username or phone
+// number is taken, or whose password the tenant's breach policy refuses, fail individually
+// without stopping the import.
+func UserImportJob(userService *UserService, membershipService *MembershipService, tenantService *TenantService, passwordBreach *PasswordBreachService) JobRunner {
+	return func(run *JobRun) error {
+		var params UserImportParams
+		if err := run.Params(&params); err != nil {
+			return err
+		}
+		if err := run.SetTotal(int64(len(params.Users))); err != nil {
+			return err
+		}
+
+		tenantID := run.Job.TenantID
+		for i := int(run.Job.Progress.Processed); i < len(params.Users); i++ {
+			item := params.Users[i]
+			step := JobStep{Processed: 1, Counts: map[string]int64{"created": 1}}
+			if err := importUser(userService, membershipService, tenantService, passwordBreach, tenantID, item); err != nil {
+				step = JobStep{Processed: 1, Failed: 1, Errors: []string{fmt.Sprintf("users[%d] (%s): %v", i, item.Email, err)}}
+			}
+			if err := run.Advance(step); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func importUser(userService *UserService, membershipService *MembershipService, tenantService *TenantService, passwordBreach *PasswordBreachService, tenantID string, item UserImportItem) error {
+	if existing, _ := userService.GetUserByEmailAndTenant(item.Email, tenantID); existing != nil {
+		return errors.New("a user with this email already exists")
+	}
+	if item.Phone != "" && NormalizePhone(item.Phone) == "" {
+		return errors.New("invalid phone number")
+	}
+	if userService.LoginIdentifierInUse(LoginIdentifierUsername, item.Username, tenantID, "") {
+		return errors.New("a user with this username already exists")
+	}
+	if userService.LoginIdentifierInUse(LoginIdentifierPhone, item.Phone, tenantID, "") {
+		return errors.New("a user with this phone number already exists")
+	}
+	if err := passwordBreach.Check(tenantID, item.Password); err != nil {
+		return err
+	}
+
+	scopes := item.Scopes
+	if len(scopes) == 0 {
+		scopes = tenantService.DefaultScopes(tenantID, DefaultScopesRegistration)
+	}
+	groupIDs, err := membershipService.ResolveGroupIDs(tenantID, item.Groups)
+	if err != nil {
+		return err
+	}
+
+	user := &models.User{
+		TenantID:     tenantID,
+		Email:        item.Email,
+		Username:     item.Username,
+		Phone:        item.Phone,
+		PasswordHash: item.Password,
+		FirstName:    item.FirstName,
+		LastName:     item.LastName,
+		Groups:       groupIDs,
+		Scopes:       scopes,
+	}
+	if err := userService.CreateUser(user); err != nil {
+		return err
+	}
+	return membershipService.SyncUser(user)
+}
+
+// TokenRevocationJob revokes the matching unrevoked refresh and access tokens of the job's tenant
+// in batches. Revoked tokens no longer match, so an interrupted job continues where it stopped.
+func TokenRevocationJob(db *database.MongoDB) JobRunner {
+	return func(run *JobRun) error {
+		var params TokenRevocationParams
+		if err := run.Params(&params); err != nil {
+			return err
+		}
+
+		filter := bson.M{"tenant_id": run.Job.TenantID, "revoked": false}
+		if params.ClientID != "" {
+			filter["client_id"] = params.ClientID
+		}
+		if params.UserID != "" {
+			filter["user_id"] = params.UserID
+		}
+
+		// Refresh tokens first, so no new access tokens are minted while access tokens are revoked
+		collections := []string{"refresh_tokens", "access_tokens"}
+		if err := setRemainingTotal(run, db, collections, filter); err != nil {
+			return err
+		}
+
+		revoke := bson.M{"$set": bson.M{"revoked": true}}
+		for _, name := range collections {
+			collection := db.GetCollection(name)
+			err := forEachBatch(collection, filter, func(ctx context.Context, ids []interface{}) error {
+				result, err := collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, revoke)
+				if err != nil {
+					return err
+				}
+				return run.Advance(JobStep{Processed: result.ModifiedCount, Counts: map[string]int64{name + "_revoked": result.ModifiedCount}})
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// tenantPurgeCollections hold documents of a tenant keyed by tenant_id, besides the
+// tenant-scoped collections. Metering events are kept for billing.
+var tenantPurgeCollections = []string{
+	"refresh_tokens", "access_tokens", "authorization_codes", "authorize_flows", "backchannel_auth_requests",
+	"social_login_states", "two_factor_sessions", "sms_codes", "email_change_requests", "password_setup_requests",
+	"users", "groups", "clients", "scopes", "social_providers", "crypto_keys",
+	"tenant_translations", "tenant_settings_changes", "tenant_token_usage", "scope_usage", "bot_bypass_keys",
+	"tenant_domains", "api_keys", "access_review_campaigns", "access_review_items", "sso_sessions",
+}
+
+// clientKeyedCollections hold client statistics keyed by client_id only
+var clientKeyedCollections = []string{"client_metrics", "client_rate_windows"}
+
+// TenantPurgeJob permanently deletes a deactivated tenant and all of its data in batches. The
+// tenant document goes last, so an interrupted purge can be submitted and resumed until it is gone.
+func TenantPurgeJob(db *database.MongoDB) JobRunner {
+	return func(run *JobRun) error {
+		var params TenantPurgeParams
+		if err := run.Params(&params); err != nil {
+			return err
+		}
+		tenantObjectID, err := primitive.ObjectIDFromHex(params.TenantID)
+		if err != nil {
+			return errors.New("invalid tenant ID")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var tenant models.Tenant
+		err = db.GetCollection("tenants").FindOne(ctx, bson.M{"_id": tenantObjectID}).Decode(&tenant)
+		cancel()
+		if err == mongo.ErrNoDocuments {
+			return errors.New("tenant not found")
+		}
+		if err != nil {
+			return err
+		}
+		if tenant.Active || tenant.IsDefault {
+			return errors.New("only deactivated tenants other than the default tenant can be purged")
+		}
+
+		// Client statistics are only keyed by client, so they go before the tenant's clients
+		clientIDs, err := tenantClientIDs(db, params.TenantID)
+		if err != nil {
+			return err
+		}
+
+		type target struct {
+			name       string
+			collection *mongo.Collection
+			filter     bson.M
+		}
+		targets := []target{}
+		for _, name := range clientKeyedCollections {
+			targets = append(targets, target{name, db.GetCollection(name), bson.M{"client_id": bson.M{"$in": clientIDs}}})
+		}
+		for _, name := range database.TenantScopedCollections {
+			targets = append(targets, target{name, db.TenantCollection(params.TenantID, name), bson.M{"tenant_id": params.TenantID}})
+		}
+		for _, name := range tenantPurgeCollections {
+			targets = append(targets, target{name, db.GetCollection(name), bson.M{"tenant_id": params.TenantID}})
+		}
+		// Bot protection policies are keyed by the tenant ID itself, feature flag overrides by their scope
+		targets = append(targets, target{"bot_protection_policies", db.GetCollection("bot_protection_policies"), bson.M{"_id": params.TenantID}})
+		targets = append(targets, target{"feature_flags", db.GetCollection("feature_flags"), bson.M{"scope": params.TenantID}})
+
+		remaining := int64(0)
+		for _, t := range targets {
+			count, err := countDocuments(t.collection, t.filter)
+			if err != nil {
+				return err
+			}
+			remaining += count
+		}
+		if err := run.SetTotal(run.Job.Progress.Processed + remaining + 1); err != nil {
+			return err
+		}
+
+		for _, t := range targets {
+			t := t
+			err := forEachBatch(t.collection, t.filter, func(ctx context.Context, ids []interface{}) error {
+				result, err := t.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+				if err != nil {
+					return err
+				}
+				return run.Advance(JobStep{Processed: result.DeletedCount, Counts: map[string]int64{t.name: result.DeletedCount}})
+			})
+			if err != nil {
+				return fmt.Errorf("failed to purge %s: %w", t.name, err)
+			}
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := db.GetCollection("tenants").DeleteOne(ctx, bson.M{"_id": tenantObjectID, "active": false}); err != nil {
+			return err
+		}
+		db.SetTenantPlacement(params.TenantID, database.Placement{})
+		return run.Advance(JobStep{Processed: 1, Counts: map[string]int64{"tenants": 1}})
+	}
+}
+
+func tenantClientIDs(db *database.MongoDB, tenantID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	values, err := db.GetCollection("clients").Distinct(ctx, "client_id", bson.M{"tenant_id": tenantID})
+	if err != nil {
+		return nil, err
+	}
+	clientIDs := make([]string, 0, len(values))
+	for _, value := range values {
+		if clientID, ok := value.(string); ok {
+			clientIDs = append(clientIDs, clientID)
+		}
+	}
+	return clientIDs, nil
+}
+
+// setRemainingTotal sets the job's total to the items already processed plus the documents still
+// matching in the collections
+func setRemainingTotal(run *JobRun, db *database.MongoDB, collections []string, filter bson.M) error {
+	total := run.Job.Progress.Processed
+	for _, name := range collections {
+		count, err := countDocuments(db.GetCollection(name), filter)
+		if err != nil {
+			return err
+		}
+		total += count
+	}
+	return run.SetTotal(total)
+}
+
+func countDocuments(collection *mongo.Collection, filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return collection.CountDocuments(ctx, filter)
+}
+
+// forEachBatch passes the IDs of the matching documents to step in batches of jobBatchSize until
+// no document matches. step must make the documents stop matching (update or delete them).
+func forEachBatch(collection *mongo.Collection, filter bson.M, step func(ctx context.Context, ids []interface{}) error) error {
+	for {
+		done, err := func() (bool, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(jobBatchSize))
+			if err != nil {
+				return false, err
+			}
+			var docs []struct {
+				ID interface{} `bson:"_id"`
+			}
+			if err := cursor.All(ctx, &docs); err != nil {
+				return false, err
+			}
+			if len(docs) == 0 {
+				return true, nil
+			}
+
+			ids := make([]interface{}, len(docs))
+			for i, doc := range docs {
+				ids[i] = doc.ID
+			}
+			return false, step(ctx, ids)
+		}()
+		if err != nil || done {
+			return err
+		}
+	}
+}
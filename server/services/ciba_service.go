@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CIBAGrantType is the token endpoint grant type used by clients polling for a CIBA result
+const CIBAGrantType = "urn:openid:params:grant-type:ciba"
+
+// Polling errors returned by PollToken. Their messages are the OAuth error codes defined by the CIBA spec.
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+)
+
+// Errors of starting, answering and polling backchannel requests
+var (
+	ErrLoginHintRequired   = errors.New("login_hint is required")
+	ErrOpenIDScopeRequired = errors.New("openid scope is required")
+	ErrUnknownUser         = errors.New("unknown user")
+	ErrAuthRequestNotFound = errors.New("authentication request not found")
+	ErrInvalidAuthReqID    = errors.New("invalid auth_req_id")
+)
+
+// CIBAService implements OpenID Connect Client-Initiated Backchannel Authentication (poll mode)
+type CIBAService struct {
+	db            *database.MongoDB
+	collection    *mongo.Collection
+	userService   *UserService
+	oauthService  *OAuthService
+	notifier      Notifier
+	requestExpiry time.Duration
+	pollInterval  time.Duration
+}
+
+func NewCIBAService(db *database.MongoDB, userService *UserService, oauthService *OAuthService, notifier Notifier) *CIBAService {
+	return &CIBAService{
+		db:            db,
+		collection:    db.GetCollection("backchannel_auth_requests"),
+		userService:   userService,
+		oauthService:  oauthService,
+		notifier:      notifier,
+		requestExpiry: time.Minute * 5,
+		pollInterval:  time.Second * 5,
+	}
+}
+
+// StartAuthentication registers a backchannel request for the user identified by loginHint and notifies them
+func (s *CIBAService) StartAuthentication(client *models.Client, loginHint, scope, bindingMessage, tenantID string) (*models.BackchannelAuthRequest, error) {
+	if loginHint == "" {
+		return nil, ErrLoginHintRequired
+	}
+
+	requestedScopes := ParseScopes(scope)
+	if !containsString(requestedScopes, "openid") {
+		return nil, ErrOpenIDScopeRequired
+	}
+	if err := s.oauthService.ValidateScopes(tenantID, client, requestedScopes); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userService.GetUserByLoginIdentifier(loginHint, tenantID)
+	if err != nil {
+		return nil, ErrUnknownUser
+	}
+	if !user.Active {
+		return nil, ErrUnknownUser
+	}
+
+	// Only grant scopes that the user actually has permission for
+	grantedScopes := []string{"openid"}
+	for _, requestedScope := range requestedScopes {
+		if requestedScope != "openid" && containsString(user.Scopes, requestedScope) {
+			grantedScopes = append(grantedScopes, requestedScope)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	authReq := &models.BackchannelAuthRequest{
+		ID:             primitive.NewObjectID(),
+		TenantID:       tenantID,
+		AuthReqID:      uuid.New().String(),
+		ClientID:       client.ClientID,
+		ClientName:     client.Name,
+		UserID:         user.ID.Hex(),
+		LoginHint:      loginHint,
+		BindingMessage: bindingMessage,
+		Scopes:         grantedScopes,
+		Status:         models.BackchannelAuthStatusPending,
+		Interval:       int(s.pollInterval.Seconds()),
+		ExpiresAt:      now.Add(s.requestExpiry),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	if _, err := s.collection.InsertOne(ctx, authReq); err != nil {
+		return nil, err
+	}
+
+	s.oauthService.RecordRequestedScopes(tenantID, client.ClientID, requestedScopes)
+
+	notification := &Notification{
+		Type:      "ciba_authentication_request",
+		TenantID:  tenantID,
+		Recipient: user.Email,
+		Subject:   "Sign-in request",
+		Message:   fmt.Sprintf("%s is requesting to sign you in", client.Name),
+		Data: map[string]interface{}{
+			"auth_req_id":     authReq.AuthReqID,
+			"user_id":         authReq.UserID,
+			"client_id":       client.ClientID,
+			"client_name":     client.Name,
+			"binding_message": bindingMessage,
+			"scopes":          grantedScopes,
+			"expires_at":      authReq.ExpiresAt,
+		},
+		CreatedAt: now,
+	}
+	if err := s.notifier.Notify(notification); err != nil {
+		return nil, fmt.Errorf("failed to notify user: %w", err)
+	}
+
+	return authReq, nil
+}
+
+// GetPendingRequestsForUser returns the unexpired requests awaiting the user's decision
+func (s *CIBAService) GetPendingRequestsForUser(userID, tenantID string) ([]*models.BackchannelAuthRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"status":     models.BackchannelAuthStatusPending,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var requests []*models.BackchannelAuthRequest
+	if err = cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// ApproveRequest records the user's approval of a pending request
+func (s *CIBAService) ApproveRequest(authReqID, userID string) error {
+	return s.decide(authReqID, userID, models.BackchannelAuthStatusApproved)
+}
+
+// DenyRequest records the user's denial of a pending request
+func (s *CIBAService) DenyRequest(authReqID, userID string) error {
+	return s.decide(authReqID, userID, models.BackchannelAuthStatusDenied)
+}
+
+func (s *CIBAService) decide(authReqID, userID, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{
+		"auth_req_id": authReqID,
+		"user_id":     userID,
+		"status":      models.BackchannelAuthStatusPending,
+		"expires_at":  bson.M{"$gt": time.Now()},
+	}, bson.M{
+		"$set": bson.M{
+			"status":     status,
+			"updated_at": time.Now(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrAuthRequestNotFound
+	}
+
+	return nil
+}
+
+// PollToken is called from the token endpoint with the CIBA grant. It returns tokens once the
+// user has approved, or one of the polling errors while the request is not yet complete. Requests
+// started in another tenant than the token endpoint's (tenantID, "" for the unscoped one) are refused.
+func (s *CIBAService) PollToken(authReqID, clientID, tenantID string, r *http.Request) (*TokenResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var authReq models.BackchannelAuthRequest
+	err := s.collection.FindOne(ctx, bson.M{
+		"auth_req_id": authReqID,
+		"client_id":   clientID,
+	}).Decode(&authReq)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidAuthReqID
+		}
+		return nil, err
+	}
+
+	if !s.oauthService.grantTenantMatches(authReq.TenantID, tenantID) {
+		return nil, ErrTenantMismatch
+	}
+
+	now := time.Now()
+	if err := pollResult(&authReq, now); err != nil {
+		// Polls of pending requests are remembered, so the next one can be told to slow down
+		if err == ErrAuthorizationPending || err == ErrSlowDown {
+			_, updateErr := s.collection.UpdateOne(ctx, bson.M{"_id": authReq.ID}, bson.M{
+				"$set": bson.M{"last_polled_at": now},
+			})
+			if updateErr != nil {
+				return nil, updateErr
+			}
+		}
+		return nil, err
+	}
+
+	// Approved: consume the request atomically so the tokens are only issued once
+	result, err := s.collection.UpdateOne(ctx, bson.M{
+		"_id":    authReq.ID,
+		"status": models.BackchannelAuthStatusApproved,
+	}, bson.M{
+		"$set": bson.M{
+			"status":     models.BackchannelAuthStatusConsumed,
+			"updated_at": now,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrInvalidAuthReqID
+	}
+
+	return s.oauthService.IssueTokens(authReq.UserID, authReq.TenantID, clientID, authReq.Scopes, "", r)
+}
+
+// pollResult returns the polling error a poll at now gets for the request, or nil once the user
+// has approved it and tokens can be issued
+func pollResult(authReq *models.BackchannelAuthRequest, now time.Time) error {
+	if now.After(authReq.ExpiresAt) {
+		return ErrExpiredToken
+	}
+
+	switch authReq.Status {
+	case models.BackchannelAuthStatusDenied:
+		return ErrAccessDenied
+	case models.BackchannelAuthStatusConsumed:
+		return ErrInvalidAuthReqID
+	case models.BackchannelAuthStatusPending:
+		if authReq.LastPolledAt != nil && now.Sub(*authReq.LastPolledAt) < time.Duration(authReq.Interval)*time.Second {
+			return ErrSlowDown
+		}
+		return ErrAuthorizationPending
+	}
+	return nil
+}
+
+// containsString reports whether value is present in values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
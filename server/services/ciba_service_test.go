@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestCIBAPollResult walks a backchannel request through the answers its polls get
+func TestCIBAPollResult(t *testing.T) {
+	now := time.Now()
+	justPolled := now.Add(-2 * time.Second)
+	polledBefore := now.Add(-6 * time.Second)
+
+	tests := []struct {
+		name       string
+		status     string
+		lastPolled *time.Time
+		expiresAt  time.Time
+		want       error
+	}{
+		{"first poll", models.BackchannelAuthStatusPending, nil, now.Add(time.Minute), ErrAuthorizationPending},
+		{"poll within the interval", models.BackchannelAuthStatusPending, &justPolled, now.Add(time.Minute), ErrSlowDown},
+		{"poll after the interval", models.BackchannelAuthStatusPending, &polledBefore, now.Add(time.Minute), ErrAuthorizationPending},
+		{"denied", models.BackchannelAuthStatusDenied, nil, now.Add(time.Minute), ErrAccessDenied},
+		{"approved", models.BackchannelAuthStatusApproved, &justPolled, now.Add(time.Minute), nil},
+		{"consumed", models.BackchannelAuthStatusConsumed, nil, now.Add(time.Minute), ErrInvalidAuthReqID},
+		{"expired", models.BackchannelAuthStatusPending, nil, now.Add(-time.Second), ErrExpiredToken},
+		{"approved but expired", models.BackchannelAuthStatusApproved, nil, now.Add(-time.Second), ErrExpiredToken},
+	}
+
+	for _, tt := range tests {
+		authReq := &models.BackchannelAuthRequest{Status: tt.status, Interval: 5, LastPolledAt: tt.lastPolled, ExpiresAt: tt.expiresAt}
+		if err := pollResult(authReq, now); err != tt.want {
+			t.Errorf("%s: pollResult() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestCIBALifecycle walks backchannel requests through polling, approval, denial and expiry
+func TestCIBALifecycle(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, Email: "jane@example.com", Scopes: []string{"openid", "read"}, Active: true, CreatedAt: now, UpdatedAt: now})
+	client := &models.Client{ID: primitive.NewObjectID(), ClientID: "kiosk", ClientSecret: "secret", Name: "Kiosk",
+		GrantTypes: []string{CIBAGrantType}, Active: true, CreatedAt: now, UpdatedAt: now}
+	dbtest.Insert(t, db, "clients", client)
+
+	userService := NewUserService(db)
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+	notifier := &recordingNotifier{}
+	ciba := NewCIBAService(db, userService, oauthService, notifier)
+	req := httptest.NewRequest("POST", "/oauth/token", nil)
+
+	authReq, err := ciba.StartAuthentication(client, "jane@example.com", "openid read write", "A1B2", "")
+	if err != nil {
+		t.Fatalf("StartAuthentication() error = %v", err)
+	}
+	if len(notifier.types) != 1 || notifier.types[0] != "ciba_authentication_request" {
+		t.Errorf("Expected the user to be notified, got %v", notifier.types)
+	}
+	if len(authReq.Scopes) != 2 || authReq.Scopes[1] != "read" {
+		t.Errorf("Expected only the user's scopes to be granted, got %v", authReq.Scopes)
+	}
+
+	// Polling before the user answers, then faster than the interval
+	if _, err := ciba.PollToken(authReq.AuthReqID, "kiosk", "", req); err != ErrAuthorizationPending {
+		t.Errorf("First poll error = %v, want authorization_pending", err)
+	}
+	if _, err := ciba.PollToken(authReq.AuthReqID, "kiosk", "", req); err != ErrSlowDown {
+		t.Errorf("Immediate second poll error = %v, want slow_down", err)
+	}
+	if _, err := ciba.PollToken(authReq.AuthReqID, "other-client", "", req); err != ErrInvalidAuthReqID {
+		t.Errorf("Poll by another client error = %v, want invalid auth_req_id", err)
+	}
+
+	// Only the user the request was sent to can answer it
+	if err := ciba.ApproveRequest(authReq.AuthReqID, primitive.NewObjectID().Hex()); err != ErrAuthRequestNotFound {
+		t.Errorf("Approval by another user error = %v, want authentication request not found", err)
+	}
+	if err := ciba.ApproveRequest(authReq.AuthReqID, userID.Hex()); err != nil {
+		t.Fatalf("ApproveRequest() error = %v", err)
+	}
+	tokens, err := ciba.PollToken(authReq.AuthReqID, "kiosk", "", req)
+	if err != nil {
+		t.Fatalf("Poll after approval error = %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.IDToken == "" {
+		t.Errorf("Expected access and ID tokens, got %+v", tokens)
+	}
+	if _, err := ciba.PollToken(authReq.AuthReqID, "kiosk", "", req); err != ErrInvalidAuthReqID {
+		t.Errorf("Poll of a consumed request error = %v, want invalid auth_req_id", err)
+	}
+
+	denied, err := ciba.StartAuthentication(client, "jane@example.com", "openid", "", "")
+	if err != nil {
+		t.Fatalf("StartAuthentication() error = %v", err)
+	}
+	if err := ciba.DenyRequest(denied.AuthReqID, userID.Hex()); err != nil {
+		t.Fatalf("DenyRequest() error = %v", err)
+	}
+	if _, err := ciba.PollToken(denied.AuthReqID, "kiosk", "", req); err != ErrAccessDenied {
+		t.Errorf("Poll after denial error = %v, want access_denied", err)
+	}
+
+	expired, err := ciba.StartAuthentication(client, "jane@example.com", "openid", "", "")
+	if err != nil {
+		t.Fatalf("StartAuthentication() error = %v", err)
+	}
+	if _, err := db.GetCollection("backchannel_auth_requests").UpdateOne(context.Background(), bson.M{"auth_req_id": expired.AuthReqID},
+		bson.M{"$set": bson.M{"expires_at": now.Add(-time.Second)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ciba.PollToken(expired.AuthReqID, "kiosk", "", req); err != ErrExpiredToken {
+		t.Errorf("Poll after expiry error = %v, want expired_token", err)
+	}
+	if err := ciba.ApproveRequest(expired.AuthReqID, userID.Hex()); err != ErrAuthRequestNotFound {
+		t.Errorf("Approval of an expired request error = %v, want authentication request not found", err)
+	}
+	if pending, err := ciba.GetPendingRequestsForUser(userID.Hex(), ""); err != nil || len(pending) != 0 {
+		t.Errorf("Expected no pending requests left, got %d (%v)", len(pending), err)
+	}
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Client funnel events, in the order a successful authorization passes through them
+const (
+	ClientMetricAuthorizeRequests = "authorize_requests"
+	ClientMetricLogins            = "logins"
+	ClientMetricConsents          = "consents"
+	ClientMetricCodesIssued       = "codes_issued"
+	ClientMetricCodesExchanged    = "codes_exchanged"
+)
+
+// Reasons a code exchange failed, as reported in the exchange_failures breakdown
+const (
+	ExchangeFailureInvalidClient    = "invalid_client"
+	ExchangeFailureInvalidCode      = "invalid_code"
+	ExchangeFailureExpiredCode      = "expired_code"
+	ExchangeFailureRedirectMismatch = "redirect_uri_mismatch"
+	ExchangeFailureBindingMismatch  = "binding_mismatch"
+	ExchangeFailurePKCE             = "pkce_failed"
+	ExchangeFailureQuotaExceeded    = "quota_exceeded"
+	ExchangeFailureRateLimited      = "rate_limited"
+	ExchangeFailureOther            = "other"
+)
+
+const (
+	// DefaultClientMetricsDays is the reporting window used when none is given
+	DefaultClientMetricsDays = 30
+	// MaxClientMetricsDays is the longest reporting window; older buckets expire
+	MaxClientMetricsDays   = 90
+	clientMetricsRetention = (MaxClientMetricsDays + 7) * 24 * time.Hour
+)
+
+// ClientMetricsService keeps daily per-client counters of the authorization funnel so client
+// owners can see where users drop off without reading server logs
+type ClientMetricsService struct {
+	db         *database.MongoDB
+	collection *mongo.Collection
+	rateLimits *ClientRateLimitService
+	breaker    *database.CircuitBreaker // suspends counting while MongoDB times out
+}
+
+// ClientMetricsReport summarizes a client's funnel over a reporting window
+type ClientMetricsReport struct {
+	ClientID         string              `json:"client_id"`
+	Days             int                 `json:"days"`
+	Since            time.Time           `json:"since"`
+	Totals           ClientFunnelCounts  `json:"totals"`
+	ExchangeFailures map[string]int64    `json:"exchange_failures"`
+	Daily            []*ClientDailyCount `json:"daily"`
+
+	RateLimits *ClientRateLimitUsage `json:"rate_limits,omitempty"` // current usage, see GetRateLimitUsage
+}
+
+// ClientFunnelCounts holds the funnel counters of a client
+type ClientFunnelCounts struct {
+	AuthorizeRequests int64 `bson:"authorize_requests" json:"authorize_requests"`
+	Logins            int64 `bson:"logins" json:"logins"`
+	Consents          int64 `bson:"consents" json:"consents"`
+	CodesIssued       int64 `bson:"codes_issued" json:"codes_issued"`
+	CodesExchanged    int64 `bson:"codes_exchanged" json:"codes_exchanged"`
+	ExchangeFailures  int64 `bson:"-" json:"exchange_failures"`
+}
+
+// ClientDailyCount holds one day of a client's funnel counters
+type ClientDailyCount struct {
+	Day                time.Time `bson:"day" json:"day"`
+	ClientFunnelCounts `bson:",inline"`
+	Failures           map[string]int64 `bson:"exchange_failures,omitempty" json:"exchange_failure_reasons,omitempty"`
+}
+
+func NewClientMetricsService(db *database.MongoDB) *ClientMetricsService {
+	return &ClientMetricsService{
+		db:         db,
+		collection: db.GetCollection("client_metrics"),
+		rateLimits: NewClientRateLimitService(db),
+		breaker:    db.Breaker("client_metrics"),
+	}
+}
+
+// EnsureIndexes creates the counter lookup index and expires old daily buckets
+func (s *ClientMetricsService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "day", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "day", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(clientMetricsRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// Record counts a funnel event for a client
+func (s *ClientMetricsService) Record(clientID, event string) {
+	s.increment(clientID, event)
+}
+
+// RecordExchange counts the outcome of an authorization code exchange
+func (s *ClientMetricsService) RecordExchange(clientID string, err error) {
+	if err == nil {
+		s.increment(clientID, ClientMetricCodesExchanged)
+		return
+	}
+	s.increment(clientID, "exchange_failures."+ExchangeFailureReason(err))
+}
+
+// increment bumps a counter in the client's daily bucket. Failures are logged and never fail
+// the calling flow.
+func (s *ClientMetricsService) increment(clientID, counter string) {
+	if clientID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	err := s.breaker.Do(func() error {
+		_, err := s.collection.UpdateOne(ctx,
+			bson.M{"client_id": clientID, "day": day},
+			bson.M{"$inc": bson.M{counter: 1}},
+			options.Update().SetUpsert(true))
+		return err
+	})
+	if err != nil && err != database.ErrCircuitOpen {
+		log.Printf("Warning: Failed to record %s for client %s: %v", counter, clientID, err)
+	}
+}
+
+// GetReport returns the client's funnel counters for the last days, with daily buckets in
+// chronological order
+func (s *ClientMetricsService) GetReport(clientID string, days int) (*ClientMetricsReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if days <= 0 {
+		days = DefaultClientMetricsDays
+	}
+	if days > MaxClientMetricsDays {
+		days = MaxClientMetricsDays
+	}
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+
+	cursor, err := s.collection.Find(ctx,
+		bson.M{"client_id": clientID, "day": bson.M{"$gte": since}},
+		options.Find().SetSort(bson.D{{Key: "day", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var daily []*ClientDailyCount
+	if err := cursor.All(ctx, &daily); err != nil {
+		return nil, err
+	}
+
+	return buildClientMetricsReport(clientID, days, since, daily), nil
+}
+
+// GetRateLimitUsage returns the client's current usage against its rate limits
+func (s *ClientMetricsService) GetRateLimitUsage(client *models.Client) (*ClientRateLimitUsage, error) {
+	return s.rateLimits.GetUsage(client)
+}
+
+// buildClientMetricsReport sums the daily buckets into the report totals
+func buildClientMetricsReport(clientID string, days int, since time.Time, daily []*ClientDailyCount) *ClientMetricsReport {
+	report := &ClientMetricsReport{
+		ClientID:         clientID,
+		Days:             days,
+		Since:            since,
+		ExchangeFailures: map[string]int64{},
+		Daily:            []*ClientDailyCount{},
+	}
+
+	for _, bucket := range daily {
+		for reason, count := range bucket.Failures {
+			bucket.ExchangeFailures += count
+			report.ExchangeFailures[reason] += count
+		}
+
+		report.Totals.AuthorizeRequests += bucket.AuthorizeRequests
+		report.Totals.Logins += bucket.Logins
+		report.Totals.Consents += bucket.Consents
+		report.Totals.CodesIssued += bucket.CodesIssued
+		report.Totals.CodesExchanged += bucket.CodesExchanged
+		report.Totals.ExchangeFailures += bucket.ExchangeFailures
+		report.Daily = append(report.Daily, bucket)
+	}
+
+	sort.Slice(report.Daily, func(i, j int) bool { return report.Daily[i].Day.Before(report.Daily[j].Day) })
+	return report
+}
+
+// ExchangeFailureReason classifies a code exchange error for the failure breakdown
+func ExchangeFailureReason(err error) string {
+	if _, ok := IsQuotaExceeded(err); ok {
+		return ExchangeFailureQuotaExceeded
+	}
+	if _, ok := IsClientRateLimited(err); ok {
+		return ExchangeFailureRateLimited
+	}
+
+	message := err.Error()
+	switch {
+	case message == "invalid client credentials" || message == "invalid client" || message == "client secret expired" || errors.Is(err, ErrPublicClientSecret):
+		return ExchangeFailureInvalidClient
+	case message == "invalid authorization code":
+		return ExchangeFailureInvalidCode
+	case message == "authorization code expired":
+		return ExchangeFailureExpiredCode
+	case message == "redirect URI mismatch":
+		return ExchangeFailureRedirectMismatch
+	case message == "authorization code binding mismatch":
+		return ExchangeFailureBindingMismatch
+	case strings.Contains(message, "code_verifier") || strings.Contains(message, "code_challenge") || errors.Is(err, ErrPKCERequired) || errors.Is(err, ErrPKCEMethodNotAllowed):
+		return ExchangeFailurePKCE
+	}
+	return ExchangeFailureOther
+}
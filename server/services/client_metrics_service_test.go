@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExchangeFailureReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("invalid client credentials"), ExchangeFailureInvalidClient},
+		{errors.New("invalid authorization code"), ExchangeFailureInvalidCode},
+		{errors.New("authorization code expired"), ExchangeFailureExpiredCode},
+		{errors.New("redirect URI mismatch"), ExchangeFailureRedirectMismatch},
+		{errors.New("authorization code binding mismatch"), ExchangeFailureBindingMismatch},
+		{errors.New("invalid code_verifier"), ExchangeFailurePKCE},
+		{errors.New("PKCE required but no code_challenge found"), ExchangeFailurePKCE},
+		{ErrPKCERequired, ExchangeFailurePKCE},
+		{ErrPublicClientSecret, ExchangeFailureInvalidClient},
+		{&QuotaExceededError{Resource: QuotaTokens, Limit: 10, Used: 10}, ExchangeFailureQuotaExceeded},
+		{&ClientRateLimitError{Limit: RateLimitActiveTokens, Max: 5}, ExchangeFailureRateLimited},
+		{errors.New("connection reset"), ExchangeFailureOther},
+	}
+
+	for _, tt := range tests {
+		if got := ExchangeFailureReason(tt.err); got != tt.want {
+			t.Errorf("ExchangeFailureReason(%q) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBuildClientMetricsReport(t *testing.T) {
+	day1 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	daily := []*ClientDailyCount{
+		{Day: day2, ClientFunnelCounts: ClientFunnelCounts{AuthorizeRequests: 4, Logins: 3, CodesIssued: 3, CodesExchanged: 2},
+			Failures: map[string]int64{ExchangeFailurePKCE: 1}},
+		{Day: day1, ClientFunnelCounts: ClientFunnelCounts{AuthorizeRequests: 10, Logins: 6, Consents: 2, CodesIssued: 6, CodesExchanged: 3},
+			Failures: map[string]int64{ExchangeFailurePKCE: 2, ExchangeFailureExpiredCode: 1}},
+	}
+
+	report := buildClientMetricsReport("app", 7, day1, daily)
+
+	if report.Totals.AuthorizeRequests != 14 || report.Totals.Logins != 9 || report.Totals.Consents != 2 {
+		t.Errorf("Unexpected funnel totals: %+v", report.Totals)
+	}
+	if report.Totals.CodesIssued != 9 || report.Totals.CodesExchanged != 5 || report.Totals.ExchangeFailures != 4 {
+		t.Errorf("Unexpected code totals: %+v", report.Totals)
+	}
+	if report.ExchangeFailures[ExchangeFailurePKCE] != 3 || report.ExchangeFailures[ExchangeFailureExpiredCode] != 1 {
+		t.Errorf("Unexpected failure breakdown: %v", report.ExchangeFailures)
+	}
+	if len(report.Daily) != 2 || !report.Daily[0].Day.Equal(day1) || report.Daily[1].ExchangeFailures != 1 {
+		t.Errorf("Expected chronological daily buckets with failure totals, got %+v", report.Daily)
+	}
+}
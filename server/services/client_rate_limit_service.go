@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Client rate limits reported in ClientRateLimitError
+const (
+	RateLimitTokenRequests = "token_requests"
+	RateLimitActiveTokens  = "active_tokens"
+)
+
+// rateLimitWindowRetention keeps the per-minute counters around until the usage report no longer
+// reads them
+const rateLimitWindowRetention = 2 * time.Minute
+
+// ClientRateLimitError is returned when a client exceeds one of its rate limits. RetryAfter is
+// when the client may try again.
+type ClientRateLimitError struct {
+	Limit      string
+	Max        int64
+	RetryAfter time.Duration
+}
+
+func (e *ClientRateLimitError) Error() string {
+	switch e.Limit {
+	case RateLimitTokenRequests:
+		return fmt.Sprintf("rate limit exceeded: the client may make %d token requests per minute", e.Max)
+	case RateLimitActiveTokens:
+		return fmt.Sprintf("active token limit exceeded: the client may hold at most %d unexpired access tokens", e.Max)
+	}
+	return fmt.Sprintf("%s limit exceeded: limit is %d", e.Limit, e.Max)
+}
+
+// IsClientRateLimited reports whether err is a ClientRateLimitError and returns it
+func IsClientRateLimited(err error) (*ClientRateLimitError, bool) {
+	var limited *ClientRateLimitError
+	if errors.As(err, &limited) {
+		return limited, true
+	}
+	return nil, false
+}
+
+// ClientRateLimitService enforces the per-client rate limits tenant admins set, protecting the
+// server from a misbehaving integration
+type ClientRateLimitService struct {
+	db               *database.MongoDB
+	clientCollection *mongo.Collection
+	windowCollection *mongo.Collection
+	tokenCollection  *mongo.Collection
+}
+
+// ClientRateLimitUsage is a client's current usage against its rate limits. Limit and Remaining
+// are omitted when unlimited.
+type ClientRateLimitUsage struct {
+	TokenRequestsPerMinute QuotaUsage `json:"token_requests_per_minute"` // requests in the current minute
+	ActiveTokens           QuotaUsage `json:"active_tokens"`             // unexpired, unrevoked access tokens
+}
+
+func NewClientRateLimitService(db *database.MongoDB) *ClientRateLimitService {
+	return &ClientRateLimitService{
+		db:               db,
+		clientCollection: db.GetCollection("clients"),
+		windowCollection: db.GetCollection("client_rate_windows"),
+		tokenCollection:  db.GetCollection("access_tokens"),
+	}
+}
+
+// EnsureIndexes creates the unique index the per-minute counters rely on for atomic reservations,
+// expires old counters and indexes the active token count
+func (s *ClientRateLimitService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.windowCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "window", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "window", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(rateLimitWindowRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.tokenCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "client_id", Value: 1}, {Key: "expires_at", Value: 1}},
+	})
+	return err
+}
+
+// ReserveTokenRequest counts a token endpoint request against the client's per-minute limit. The
+// counter is only incremented while below the limit, so concurrent requests cannot overshoot it.
+// Unknown clients and storage failures are not throttled; the grant itself rejects them.
+func (s *ClientRateLimitService) ReserveTokenRequest(clientID string) error {
+	limits, ok := s.limits(clientID)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	window := now.Truncate(time.Minute)
+	limit := limits.TokenRequestsPerMinute
+
+	filter := bson.M{"client_id": clientID, "window": window}
+	if limit > 0 {
+		filter["requests"] = bson.M{"$lt": limit}
+	}
+
+	_, err := s.windowCollection.UpdateOne(ctx, filter, bson.M{
+		"$inc": bson.M{"requests": 1},
+	}, options.Update().SetUpsert(true))
+	if err == nil {
+		return nil
+	}
+
+	// The counter exists but is at the limit, so the upsert collided with the unique index
+	if limit > 0 && mongo.IsDuplicateKeyError(err) {
+		return &ClientRateLimitError{Limit: RateLimitTokenRequests, Max: int64(limit), RetryAfter: window.Add(time.Minute).Sub(now)}
+	}
+
+	log.Printf("Warning: Failed to count token request of client %s: %v", clientID, err)
+	return nil
+}
+
+// CheckActiveTokens returns a ClientRateLimitError if the client holds as many active access
+// tokens as its cap allows. Concurrent requests may overshoot the cap by a few tokens.
+func (s *ClientRateLimitService) CheckActiveTokens(clientID string) error {
+	limits, ok := s.limits(clientID)
+	if !ok || limits.MaxActiveTokens <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	active, err := s.countActiveTokens(ctx, clientID)
+	if err != nil {
+		log.Printf("Warning: Failed to count active tokens of client %s: %v", clientID, err)
+		return nil
+	}
+	if active < int64(limits.MaxActiveTokens) {
+		return nil
+	}
+
+	// The client can get a token again once its oldest active token expires
+	limited := &ClientRateLimitError{Limit: RateLimitActiveTokens, Max: int64(limits.MaxActiveTokens)}
+	var oldest models.AccessToken
+	err = s.tokenCollection.FindOne(ctx, activeTokensFilter(clientID),
+		options.FindOne().SetSort(bson.D{{Key: "expires_at", Value: 1}})).Decode(&oldest)
+	if err == nil {
+		limited.RetryAfter = time.Until(oldest.ExpiresAt)
+	}
+	return limited
+}
+
+// GetUsage returns the client's current usage against its rate limits
+func (s *ClientRateLimitService) GetUsage(client *models.Client) (*ClientRateLimitUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var window struct {
+		Requests int64 `bson:"requests"`
+	}
+	err := s.windowCollection.FindOne(ctx, bson.M{
+		"client_id": client.ClientID,
+		"window":    time.Now().UTC().Truncate(time.Minute),
+	}).Decode(&window)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	active, err := s.countActiveTokens(ctx, client.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientRateLimitUsage{
+		TokenRequestsPerMinute: quotaUsage(window.Requests, client.RateLimits.TokenRequestsPerMinute),
+		ActiveTokens:           quotaUsage(active, client.RateLimits.MaxActiveTokens),
+	}, nil
+}
+
+func (s *ClientRateLimitService) countActiveTokens(ctx context.Context, clientID string) (int64, error) {
+	return s.tokenCollection.CountDocuments(ctx, activeTokensFilter(clientID))
+}
+
+func activeTokensFilter(clientID string) bson.M {
+	return bson.M{"client_id": clientID, "revoked": false, "expires_at": bson.M{"$gt": time.Now()}}
+}
+
+// limits returns the rate limits of a client; false for unknown clients
+func (s *ClientRateLimitService) limits(clientID string) (models.ClientRateLimits, bool) {
+	if clientID == "" {
+		return models.ClientRateLimits{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var client models.Client
+	err := s.clientCollection.FindOne(ctx, bson.M{"client_id": clientID},
+		options.FindOne().SetProjection(bson.M{"rate_limits": 1})).Decode(&client)
+	if err != nil {
+		return models.ClientRateLimits{}, false
+	}
+	return client.RateLimits, true
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestClientRateLimits throttles a client's token requests per minute and caps its active tokens
+func TestClientRateLimits(t *testing.T) {
+	db := dbtest.New(t)
+	now := time.Now()
+
+	client := &models.Client{ID: primitive.NewObjectID(), ClientID: "noisy", Name: "Noisy", Active: true, CreatedAt: now, UpdatedAt: now,
+		RateLimits: models.ClientRateLimits{TokenRequestsPerMinute: 2, MaxActiveTokens: 1}}
+	dbtest.Insert(t, db, "clients", client,
+		&models.Client{ID: primitive.NewObjectID(), ClientID: "quiet", Name: "Quiet", Active: true, CreatedAt: now, UpdatedAt: now})
+
+	service := NewClientRateLimitService(db)
+	if err := service.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := service.ReserveTokenRequest("noisy"); err != nil {
+			t.Fatalf("ReserveTokenRequest() #%d error = %v", i+1, err)
+		}
+	}
+	err := service.ReserveTokenRequest("noisy")
+	limited, ok := IsClientRateLimited(err)
+	if !ok || limited.Limit != RateLimitTokenRequests || limited.RetryAfter <= 0 || limited.RetryAfter > time.Minute {
+		t.Errorf("ReserveTokenRequest() beyond the limit = %v, want a token request limit with a retry within a minute", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := service.ReserveTokenRequest("quiet"); err != nil {
+			t.Errorf("ReserveTokenRequest() of an unlimited client error = %v", err)
+		}
+	}
+
+	if err := service.CheckActiveTokens("noisy"); err != nil {
+		t.Errorf("CheckActiveTokens() without tokens error = %v", err)
+	}
+	dbtest.Insert(t, db, "access_tokens",
+		&models.AccessToken{ID: primitive.NewObjectID(), Token: "t1", ClientID: "noisy", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		&models.AccessToken{ID: primitive.NewObjectID(), Token: "t0", ClientID: "noisy", ExpiresAt: now.Add(-time.Hour), CreatedAt: now},
+	)
+	if _, ok := IsClientRateLimited(service.CheckActiveTokens("noisy")); !ok {
+		t.Error("CheckActiveTokens() at the cap = nil, want an active token limit")
+	}
+
+	usage, err := service.GetUsage(client)
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+	if usage.TokenRequestsPerMinute.Used != 2 || *usage.TokenRequestsPerMinute.Remaining != 0 || usage.ActiveTokens.Used != 1 {
+		t.Errorf("GetUsage() = %+v, want 2 requests and 1 active token", usage)
+	}
+}
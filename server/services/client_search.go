@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ClientFilter narrows a tenant's client list; empty fields don't filter
+type ClientFilter struct {
+	Name        string     // case-insensitive substring of the client name
+	Scope       string     // the client may request this scope
+	GrantType   string     // the client may use this grant type
+	Active      *bool      // only active or only inactive clients
+	CreatedFrom *time.Time // created at or after
+	CreatedTo   *time.Time // created before
+}
+
+// EnsureSearchIndexes creates the tenant-scoped indexes used to filter clients
+func (s *ClientService) EnsureSearchIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "name", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "scopes", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "grant_types", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "active", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// SearchClients returns the tenant's clients matching the filter, ordered by name
+func (s *ClientService) SearchClients(tenantID string, filter ClientFilter) ([]*models.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if tenantID != "" {
+		query["tenant_id"] = tenantID
+	}
+	if name := strings.TrimSpace(filter.Name); name != "" {
+		query["name"] = primitive.Regex{Pattern: regexp.QuoteMeta(name), Options: "i"}
+	}
+	if filter.Scope != "" {
+		query["scopes"] = filter.Scope
+	}
+	if filter.GrantType != "" {
+		query["grant_types"] = filter.GrantType
+	}
+	if filter.Active != nil {
+		query["active"] = *filter.Active
+	}
+	created := bson.M{}
+	if filter.CreatedFrom != nil {
+		created["$gte"] = *filter.CreatedFrom
+	}
+	if filter.CreatedTo != nil {
+		created["$lt"] = *filter.CreatedTo
+	}
+	if len(created) > 0 {
+		query["created_at"] = created
+	}
+
+	cursor, err := s.collection.Find(ctx, query, options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	clients := []*models.Client{}
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
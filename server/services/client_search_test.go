@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestSearchClients filters a tenant's clients by name, scope, grant type, state and creation date
+func TestSearchClients(t *testing.T) {
+	db := dbtest.New(t)
+
+	created := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	dbtest.Insert(t, db, "clients",
+		&models.Client{ID: primitive.NewObjectID(), TenantID: "t1", ClientID: "billing", Name: "Billing Portal", Scopes: []string{"openid", "invoices"}, GrantTypes: []string{"authorization_code"}, Active: true, CreatedAt: created},
+		&models.Client{ID: primitive.NewObjectID(), TenantID: "t1", ClientID: "sync", Name: "Billing sync (a+b)", Scopes: []string{"invoices"}, GrantTypes: []string{"client_credentials"}, Active: false, CreatedAt: created.AddDate(0, 1, 0)},
+		&models.Client{ID: primitive.NewObjectID(), TenantID: "t2", ClientID: "other", Name: "Billing", Scopes: []string{"invoices"}, Active: true, CreatedAt: created},
+	)
+
+	service := NewClientService(db)
+	if err := service.EnsureSearchIndexes(); err != nil {
+		t.Fatalf("EnsureSearchIndexes() error = %v", err)
+	}
+
+	inactive := false
+	april := time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		filter ClientFilter
+		want   []string
+	}{
+		{"all", ClientFilter{}, []string{"billing", "sync"}},
+		{"name substring", ClientFilter{Name: "billing"}, []string{"billing", "sync"}},
+		{"name with regex characters", ClientFilter{Name: "(a+b)"}, []string{"sync"}},
+		{"scope", ClientFilter{Scope: "openid"}, []string{"billing"}},
+		{"grant type", ClientFilter{GrantType: "client_credentials"}, []string{"sync"}},
+		{"inactive", ClientFilter{Active: &inactive}, []string{"sync"}},
+		{"created before", ClientFilter{CreatedTo: &april}, []string{"billing"}},
+		{"created after", ClientFilter{CreatedFrom: &april}, []string{"sync"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients, err := service.SearchClients("t1", tt.filter)
+			if err != nil {
+				t.Fatalf("SearchClients() error = %v", err)
+			}
+			if len(clients) != len(tt.want) {
+				t.Fatalf("SearchClients() returned %d clients, want %v", len(clients), tt.want)
+			}
+			for i, client := range clients {
+				if client.ClientID != tt.want[i] {
+					t.Errorf("client %d = %s, want %s", i, client.ClientID, tt.want[i])
+				}
+			}
+		})
+	}
+}
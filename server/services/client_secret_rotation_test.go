@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestClientSecretRotation rotates a client secret under a tenant policy and checks which secrets
+// the token endpoint accepts during and after the rotation window
+func TestClientSecretRotation(t *testing.T) {
+	db := dbtest.New(t)
+
+	now := time.Now()
+	tenantID := primitive.NewObjectID()
+	clientID := primitive.NewObjectID()
+	userID := primitive.NewObjectID()
+	dbtest.Insert(t, db, "tenants", &models.Tenant{ID: tenantID, Name: "Acme", Active: true, CreatedAt: now, UpdatedAt: now,
+		Settings: models.TenantSettings{ClientSecretPolicy: models.ClientSecretPolicy{MaxAgeDays: 90, RotationGraceHours: 2}}})
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, TenantID: tenantID.Hex(), Email: "jane@acme.com", Scopes: []string{"openid"}, Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, db, "clients", &models.Client{ID: clientID, TenantID: tenantID.Hex(), ClientID: "backend", ClientSecret: "old-secret", Name: "Backend",
+		Contacts: []string{"ops@acme.com"}, RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	clients := NewClientService(db)
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+	exchange := func(secret string) error {
+		code, err := oauthService.CreateAuthorizationCode("backend", userID.Hex(), tenantID.Hex(), "https://app.example.com/cb", []string{"openid"}, "", "", CodeBinding{}, "")
+		if err != nil {
+			t.Fatalf("Failed to create authorization code: %v", err)
+		}
+		_, err = oauthService.ExchangeCodeForTokens(code, "backend", secret, "https://app.example.com/cb", tenantID.Hex(), httptest.NewRequest("POST", "/oauth/token", nil))
+		return err
+	}
+	setSecretFields := func(fields bson.M) {
+		if _, err := db.GetCollection("clients").UpdateOne(context.Background(), bson.M{"_id": clientID}, bson.M{"$set": fields}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, newSecret, err := clients.RegenerateClientSecret(clientID.Hex(), tenantID.Hex())
+	if err != nil {
+		t.Fatalf("RegenerateClientSecret() error = %v", err)
+	}
+	if client.ClientSecretExpiresAt == nil || client.ClientSecretExpiresAt.Sub(now) < 89*24*time.Hour {
+		t.Errorf("Expected the new secret to expire after the tenant's 90 days, got %v", client.ClientSecretExpiresAt)
+	}
+	if client.PreviousSecretExpiresAt == nil || client.PreviousSecretExpiresAt.Sub(now) > 2*time.Hour+time.Minute {
+		t.Errorf("Expected the previous secret to stay valid for the 2h grace period, got %v", client.PreviousSecretExpiresAt)
+	}
+
+	// Both secrets are accepted during the rotation window
+	if err := exchange("old-secret"); err != nil {
+		t.Errorf("Previous secret rejected during the rotation window: %v", err)
+	}
+	if err := exchange(newSecret); err != nil {
+		t.Errorf("New secret rejected: %v", err)
+	}
+
+	setSecretFields(bson.M{"previous_secret_expires_at": now.Add(-time.Minute)})
+	if err := exchange("old-secret"); err == nil {
+		t.Error("Previous secret accepted after the rotation window")
+	}
+
+	// Expired secrets are announced to the contacts once, then rejected
+	setSecretFields(bson.M{"client_secret_expires_at": now.Add(24 * time.Hour)})
+	notifier := &recordingNotifier{}
+	for i := 0; i < 2; i++ {
+		if err := clients.NotifyExpiringSecrets(notifier); err != nil {
+			t.Fatalf("NotifyExpiringSecrets() error = %v", err)
+		}
+	}
+	if len(notifier.types) != 1 || notifier.types[0] != "client_secret_expiring" {
+		t.Errorf("Expected one expiry notification, got %v", notifier.types)
+	}
+	setSecretFields(bson.M{"client_secret_expires_at": now.Add(-time.Minute)})
+	if err := exchange(newSecret); err == nil || err.Error() != "client secret expired" {
+		t.Errorf("Expected the expired secret to be rejected, got %v", err)
+	}
+
+	// Without a grace period in the policy the previous secret is revoked right away
+	if _, err := db.GetCollection("tenants").UpdateOne(context.Background(), bson.M{"_id": tenantID}, bson.M{
+		"$set": bson.M{"settings.client_secret_policy.rotation_grace_hours": 0},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	client, latestSecret, err := clients.RegenerateClientSecret(clientID.Hex(), tenantID.Hex())
+	if err != nil {
+		t.Fatalf("RegenerateClientSecret() error = %v", err)
+	}
+	if client.PreviousSecretExpiresAt != nil {
+		t.Errorf("Expected the previous secret to be revoked, got expiry %v", client.PreviousSecretExpiresAt)
+	}
+	if err := exchange(newSecret); err == nil {
+		t.Error("Previous secret accepted after a rotation without grace period")
+	}
+	if err := exchange(latestSecret); err != nil {
+		t.Errorf("Latest secret rejected: %v", err)
+	}
+}
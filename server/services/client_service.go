@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"log"
 	"time"
 
 	"oauth2-openid-server/database"
@@ -19,12 +20,14 @@ import (
 type ClientService struct {
 	db         *database.MongoDB
 	collection *mongo.Collection
+	quotas     *QuotaService
 }
 
 func NewClientService(db *database.MongoDB) *ClientService {
 	return &ClientService{
 		db:         db,
 		collection: db.GetCollection("clients"),
+		quotas:     NewQuotaService(db),
 	}
 }
 
@@ -32,13 +35,37 @@ func (s *ClientService) CreateClient(client *models.Client) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	return s.createClient(ctx, client)
+}
+
+func (s *ClientService) createClient(ctx context.Context, client *models.Client) error {
+	if err := validateClientType(client); err != nil {
+		return err
+	}
+	if err := s.quotas.CheckClientQuota(client.TenantID); err != nil {
+		return err
+	}
+
 	client.ID = primitive.NewObjectID()
 	client.ClientID = uuid.New().String()
-	client.ClientSecret = s.generateClientSecret()
+	client.Version = 1
 	client.CreatedAt = time.Now()
 	client.UpdatedAt = time.Now()
 	client.Active = true
 
+	if client.IsPublic() {
+		client.ClientSecret = ""
+		client.ClientSecretExpiresAt = nil
+	} else {
+		client.ClientType = models.ClientTypeConfidential
+		client.ClientSecret = s.generateClientSecret()
+
+		// Apply the tenant's max secret age unless an explicit expiry was requested
+		if client.ClientSecretExpiresAt == nil {
+			client.ClientSecretExpiresAt = s.secretExpiry(client.TenantID, client.CreatedAt)
+		}
+	}
+
 	if len(client.GrantTypes) == 0 {
 		client.GrantTypes = []string{"authorization_code", "refresh_token"}
 	}
@@ -134,7 +161,8 @@ func (s *ClientService) GetActiveClients(tenantID string) ([]*models.Client, err
 	return clients, err
 }
 
-func (s *ClientService) UpdateClient(id, tenantID string, client *models.Client) error {
+// UpdateClient updates a client if its stored version equals expectedVersion (AnyVersion skips the check)
+func (s *ClientService) UpdateClient(id, tenantID string, client *models.Client, expectedVersion int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -148,6 +176,22 @@ func (s *ClientService) UpdateClient(id, tenantID string, client *models.Client)
 		filter["tenant_id"] = tenantID
 	}
 
+	// The grant types and redirect URIs of system clients are controlled by the server
+	var existing models.Client
+	if err := s.collection.FindOne(ctx, filter).Decode(&existing); err == nil && existing.System {
+		client.GrantTypes = existing.GrantTypes
+		client.RedirectURIs = existing.RedirectURIs
+	}
+
+	// The client type can't change after creation; public clients keep having no secret
+	client.ClientType = existing.ClientType
+	if err := validateClientType(client); err != nil {
+		return err
+	}
+	if client.IsPublic() {
+		client.ClientSecretExpiresAt = nil
+	}
+
 	client.UpdatedAt = time.Now()
 	update := bson.M{"$set": bson.M{
 		"name":          client.Name,
@@ -155,22 +199,52 @@ func (s *ClientService) UpdateClient(id, tenantID string, client *models.Client)
 		"redirect_uris": client.RedirectURIs,
 		"scopes":        client.Scopes,
 		"grant_types":   client.GrantTypes,
+		"contacts":      client.Contacts,
+		"logo_uri":      client.LogoURI,
+		"client_uri":    client.ClientURI,
+		"policy_uri":    client.PolicyURI,
+		"tos_uri":       client.TOSURI,
+		"refresh_token_policy": client.RefreshTokenPolicy,
+		"rate_limits":   client.RateLimits,
+		"require_mfa":   client.RequireMFA,
+		"require_s256_pkce": client.RequireS256PKCE,
+		"id_token_roles": client.IDTokenRoles,
+		"id_token_signed_response_alg": client.IDTokenSignedResponseAlg,
+		"groups_claim":   client.GroupsClaim,
+		"assignment_required":  client.AssignmentRequired,
 		"active":        client.Active,
 		"updated_at":    client.UpdatedAt,
-	}}
+	}, "$inc": bson.M{"version": 1}}
+	if client.ClientSecretExpiresAt != nil {
+		update["$set"].(bson.M)["client_secret_expires_at"] = client.ClientSecretExpiresAt
+	}
 
-	result, err := s.collection.UpdateOne(ctx, filter, update)
+	result, err := s.collection.UpdateOne(ctx, withExpectedVersion(filter, expectedVersion), update)
 	if err != nil {
 		return err
 	}
 
 	if result.MatchedCount == 0 {
-		return errors.New("client not found")
+		return versionedUpdateError(ctx, s.collection, filter, errors.New("client not found"))
 	}
 
 	return nil
 }
 
+// MigrateFrontendClientRoles enables role claims in the ID tokens of the seeded frontend-client
+// of installations set up before clients opted into them. Clients where an administrator chose a
+// setting keep it.
+func (s *ClientService) MigrateFrontendClientRoles() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.UpdateMany(ctx, bson.M{
+		"client_id":      "frontend-client",
+		"id_token_roles": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"id_token_roles": true}})
+	return err
+}
+
 func (s *ClientService) DeleteClient(id, tenantID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -185,12 +259,15 @@ func (s *ClientService) DeleteClient(id, tenantID string) error {
 		filter["tenant_id"] = tenantID
 	}
 
-	result, err := s.collection.DeleteOne(ctx, filter)
+	result, err := s.collection.DeleteOne(ctx, bson.M{"$and": []bson.M{filter, {"system": bson.M{"$ne": true}}}})
 	if err != nil {
 		return err
 	}
 
 	if result.DeletedCount == 0 {
+		if count, _ := s.collection.CountDocuments(ctx, filter); count > 0 {
+			return ErrSystemClient
+		}
 		return errors.New("client not found")
 	}
 
@@ -222,7 +299,7 @@ func (s *ClientService) updateClientStatus(id, tenantID string, active bool) err
 	update := bson.M{"$set": bson.M{
 		"active":     active,
 		"updated_at": time.Now(),
-	}}
+	}, "$inc": bson.M{"version": 1}}
 
 	result, err := s.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
@@ -236,36 +313,165 @@ func (s *ClientService) updateClientStatus(id, tenantID string, active bool) err
 	return nil
 }
 
-func (s *ClientService) RegenerateClientSecret(id, tenantID string) (string, error) {
+// RegenerateClientSecret rotates the client secret using the tenant's rotation grace period. Without
+// one the previous secret is revoked immediately.
+func (s *ClientService) RegenerateClientSecret(id, tenantID string) (*models.Client, string, error) {
+	policy := s.secretPolicy(tenantID)
+	return s.RotateClientSecret(id, tenantID, time.Duration(policy.RotationGraceHours)*time.Hour)
+}
+
+// RotateClientSecret issues a new client secret. The previous secret stays valid for the grace
+// period so partners can roll out the new secret without downtime; a zero grace period revokes it immediately.
+func (s *ClientService) RotateClientSecret(id, tenantID string, grace time.Duration) (*models.Client, string, error) {
+	client, err := s.GetClientByID(id, tenantID)
+	if err != nil {
+		return nil, "", err
+	}
+	if client.IsPublic() {
+		return nil, "", ErrPublicClientSecret
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	objID, err := primitive.ObjectIDFromHex(id)
+	now := time.Now()
+	newSecret := s.generateClientSecret()
+	set := bson.M{
+		"client_secret":     newSecret,
+		"secret_rotated_at": now,
+		"updated_at":        now,
+	}
+	unset := bson.M{"secret_expiry_notified_at": ""}
+
+	if expiresAt := s.secretExpiry(client.TenantID, now); expiresAt != nil {
+		set["client_secret_expires_at"] = expiresAt
+	} else {
+		unset["client_secret_expires_at"] = ""
+	}
+
+	if grace > 0 {
+		previousExpiresAt := now.Add(grace)
+		if client.ClientSecretExpiresAt != nil && client.ClientSecretExpiresAt.Before(previousExpiresAt) {
+			previousExpiresAt = *client.ClientSecretExpiresAt
+		}
+		set["previous_client_secret"] = client.ClientSecret
+		set["previous_secret_expires_at"] = previousExpiresAt
+	} else {
+		unset["previous_client_secret"] = ""
+		unset["previous_secret_expires_at"] = ""
+	}
+
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": client.ID}, bson.M{
+		"$set":   set,
+		"$unset": unset,
+		"$inc":   bson.M{"version": 1},
+	})
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	filter := bson.M{"_id": objID}
-	if tenantID != "" {
-		filter["tenant_id"] = tenantID
+	updatedClient, err := s.GetClientByID(id, tenantID)
+	if err != nil {
+		return nil, "", err
 	}
 
-	newSecret := s.generateClientSecret()
-	update := bson.M{"$set": bson.M{
-		"client_secret": newSecret,
-		"updated_at":    time.Now(),
-	}}
+	return updatedClient, newSecret, nil
+}
 
-	result, err := s.collection.UpdateOne(ctx, filter, update)
+// NotifyExpiringSecrets notifies client contacts about secrets expiring within their tenant's warning window.
+// Each secret is only announced once; rotating the secret resets the notification.
+func (s *ClientService) NotifyExpiringSecrets(notifier Notifier) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"active":                    true,
+		"client_secret_expires_at":  bson.M{"$exists": true, "$ne": nil},
+		"secret_expiry_notified_at": bson.M{"$exists": false},
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer cursor.Close(ctx)
 
-	if result.MatchedCount == 0 {
-		return "", errors.New("client not found")
+	var clients []*models.Client
+	if err = cursor.All(ctx, &clients); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, client := range clients {
+		policy := s.secretPolicy(client.TenantID)
+		if client.ClientSecretExpiresAt.After(now.AddDate(0, 0, policy.ExpiryWarningDays)) {
+			continue
+		}
+
+		recipients := client.Contacts
+		if len(recipients) == 0 {
+			recipients = []string{""}
+		}
+
+		for _, recipient := range recipients {
+			notification := &Notification{
+				Type:      "client_secret_expiring",
+				TenantID:  client.TenantID,
+				Recipient: recipient,
+				Subject:   "Client secret for " + client.Name + " expires soon",
+				Message:   "The client secret of " + client.Name + " (" + client.ClientID + ") expires on " + client.ClientSecretExpiresAt.Format(time.RFC1123) + ". Rotate it before then to avoid downtime.",
+				Data: map[string]interface{}{
+					"client_id":                client.ClientID,
+					"client_name":              client.Name,
+					"client_secret_expires_at": client.ClientSecretExpiresAt,
+				},
+				CreatedAt: now,
+			}
+			if err := notifier.Notify(notification); err != nil {
+				log.Printf("Warning: Failed to send secret expiry notification for client %s: %v", client.ClientID, err)
+			}
+		}
+
+		_, err := s.collection.UpdateOne(ctx, bson.M{"_id": client.ID}, bson.M{
+			"$set": bson.M{"secret_expiry_notified_at": now},
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	return newSecret, nil
+	return nil
+}
+
+// secretPolicy returns the tenant's client secret policy with defaults applied
+func (s *ClientService) secretPolicy(tenantID string) models.ClientSecretPolicy {
+	var policy models.ClientSecretPolicy
+
+	tenantService := NewTenantService(s.db)
+	var tenant *models.Tenant
+	var err error
+	if tenantID != "" {
+		tenant, err = tenantService.GetTenantByID(tenantID)
+	} else {
+		tenant, err = tenantService.GetDefaultTenant()
+	}
+	if err == nil {
+		policy = tenant.Settings.ClientSecretPolicy
+	}
+
+	if policy.ExpiryWarningDays <= 0 {
+		policy.ExpiryWarningDays = 14
+	}
+
+	return policy
+}
+
+// secretExpiry computes when a secret issued at the given time expires under the tenant policy
+func (s *ClientService) secretExpiry(tenantID string, issuedAt time.Time) *time.Time {
+	policy := s.secretPolicy(tenantID)
+	if policy.MaxAgeDays <= 0 {
+		return nil
+	}
+	expiresAt := issuedAt.AddDate(0, 0, policy.MaxAgeDays)
+	return &expiresAt
 }
 
 func (s *ClientService) ValidateRedirectURI(clientID, redirectURI, tenantID string) error {
@@ -277,6 +483,9 @@ func (s *ClientService) ValidateRedirectURI(clientID, redirectURI, tenantID stri
 	if !client.Active {
 		return errors.New("client is inactive")
 	}
+	if client.ClientID == models.SystemClientPlayground && !s.playgroundEnabled(client.TenantID) {
+		return ErrPlaygroundDisabled
+	}
 
 	for _, uri := range client.RedirectURIs {
 		if uri == redirectURI {
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Cluster event types
+const (
+	ClusterEventSessionRevoked = "session.revoked" // data: user_id, and session_id unless all sessions were revoked
+)
+
+const (
+	// clusterEventRetention is how long published events are kept
+	clusterEventRetention = 24 * time.Hour
+	// clusterEventLookback is how late an event may become visible, e.g. through replication lag
+	// between regions, and still be delivered
+	clusterEventLookback = 5 * time.Minute
+)
+
+// ClusterEventHandler applies an event published by another instance
+type ClusterEventHandler func(event *models.ClusterEvent) error
+
+// ClusterEventBus carries events between server instances, in every region, through the
+// cluster_events collection. Each instance polls for the events others published since it
+// started and hands them to the subscribed handlers; an instance never receives its own events.
+// Delivery is at least once per instance, so handlers must be idempotent.
+type ClusterEventBus struct {
+	events    *mongo.Collection
+	region    string
+	startedAt time.Time
+
+	mu        sync.Mutex
+	handlers  map[string][]ClusterEventHandler
+	seen      map[primitive.ObjectID]time.Time
+	delivered int64
+	lastEvent *time.Time
+}
+
+// ClusterEventStats is what an instance received from the bus
+type ClusterEventStats struct {
+	Delivered   int64      `json:"delivered"`
+	LastEventAt *time.Time `json:"last_event_at,omitempty"`
+}
+
+func NewClusterEventBus(db *database.MongoDB) *ClusterEventBus {
+	return &ClusterEventBus{
+		events:    clusterEventCollection(db),
+		region:    db.HomeRegion(),
+		startedAt: time.Now(),
+		handlers:  map[string][]ClusterEventHandler{},
+		seen:      map[primitive.ObjectID]time.Time{},
+	}
+}
+
+func clusterEventCollection(db *database.MongoDB) *mongo.Collection {
+	events, _ := db.GetCollection("cluster_events").Clone(options.Collection().SetWriteConcern(writeconcern.Majority()))
+	return events
+}
+
+// EnsureIndexes indexes events by publication time and expires them after a day
+func (b *ClusterEventBus) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := b.events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(clusterEventRetention.Seconds())),
+	})
+	return err
+}
+
+// Subscribe registers a handler for events of the given type
+func (b *ClusterEventBus) Subscribe(eventType string, handler ClusterEventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish announces an event to the other instances
+func (b *ClusterEventBus) Publish(eventType, tenantID string, data map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return publishClusterEvent(ctx, b.events, b.region, eventType, tenantID, data)
+}
+
+// Poll delivers the events other instances published since the last poll
+func (b *ClusterEventBus) Poll() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	since := now.Add(-clusterEventLookback)
+	if since.Before(b.startedAt) {
+		since = b.startedAt
+	}
+
+	cursor, err := b.events.Find(ctx, bson.M{"created_at": bson.M{"$gte": since}, "origin": bson.M{"$ne": InstanceID()}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var events []models.ClusterEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range events {
+		event := &events[i]
+		if _, ok := b.seen[event.ID]; ok {
+			continue
+		}
+		b.seen[event.ID] = event.CreatedAt
+		b.delivered++
+		b.lastEvent = &event.CreatedAt
+
+		for _, handler := range b.handlers[event.Type] {
+			if err := handler(event); err != nil {
+				log.Printf("Warning: Failed to apply cluster event %s (%s) from %s: %v", event.ID.Hex(), event.Type, event.Origin, err)
+			}
+		}
+	}
+
+	// Events older than the lookback are never returned again
+	for id, createdAt := range b.seen {
+		if createdAt.Before(since) {
+			delete(b.seen, id)
+		}
+	}
+	return nil
+}
+
+// Stats returns how many events this instance has received
+func (b *ClusterEventBus) Stats() ClusterEventStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return ClusterEventStats{Delivered: b.delivered, LastEventAt: b.lastEvent}
+}
+
+// publishClusterEvent inserts an event for the other instances. Services that announce changes
+// use it directly, so they don't need the bus itself.
+func publishClusterEvent(ctx context.Context, events *mongo.Collection, region, eventType, tenantID string, data map[string]interface{}) error {
+	_, err := events.InsertOne(ctx, &models.ClusterEvent{
+		Type:      eventType,
+		Origin:    InstanceID(),
+		Region:    region,
+		TenantID:  tenantID,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
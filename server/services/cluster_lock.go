@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// ErrClusterLockHeld is returned when another instance holds a cluster lock
+var ErrClusterLockHeld = errors.New("the lock is held by another instance")
+
+var (
+	instanceID     string
+	instanceIDOnce sync.Once
+)
+
+// InstanceID identifies this server process across the cluster, e.g. "auth-7f9c-2b1e4a90"
+func InstanceID() string {
+	instanceIDOnce.Do(func() {
+		host, _ := os.Hostname()
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		instanceID = fmt.Sprintf("%s-%s", host, hex.EncodeToString(suffix))
+	})
+	return instanceID
+}
+
+// ClusterLockService hands out leases on named locks shared by every instance in every region,
+// so work that must happen once (creating or rotating signing keys) isn't done twice. Locks are
+// written with majority write concern; in an active-active deployment the cluster_locks
+// collection must live in the globally replicated database.
+type ClusterLockService struct {
+	locks  *mongo.Collection
+	region string
+}
+
+func NewClusterLockService(db *database.MongoDB) *ClusterLockService {
+	locks, _ := db.GetCollection("cluster_locks").Clone(options.Collection().SetWriteConcern(writeconcern.Majority()))
+	return &ClusterLockService{
+		locks:  locks,
+		region: db.HomeRegion(),
+	}
+}
+
+// WithLock runs fn while holding the named lock. The lease runs out after ttl, so a crashed
+// holder doesn't block the others forever; fn must finish well within it. It returns
+// ErrClusterLockHeld without running fn if another instance holds the lock.
+func (s *ClusterLockService) WithLock(name string, ttl time.Duration, fn func() error) error {
+	if err := s.acquire(name, ttl); err != nil {
+		return err
+	}
+	defer s.release(name)
+	return fn()
+}
+
+// HeldLocks returns the locks whose leases haven't run out
+func (s *ClusterLockService) HeldLocks() ([]models.ClusterLock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.locks.Find(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	locks := []models.ClusterLock{}
+	if err := cursor.All(ctx, &locks); err != nil {
+		return nil, err
+	}
+	return locks, nil
+}
+
+func (s *ClusterLockService) acquire(name string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.locks.UpdateOne(ctx, bson.M{"_id": name, "expires_at": bson.M{"$lte": now}}, bson.M{
+		"$set": bson.M{"holder": InstanceID(), "region": s.region, "acquired_at": now, "expires_at": now.Add(ttl)},
+	}, options.Update().SetUpsert(true))
+	// The lock exists and its lease is still running, so the upsert collided with the _id index
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrClusterLockHeld
+	}
+	return err
+}
+
+func (s *ClusterLockService) release(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// An expired lease may already belong to someone else
+	s.locks.DeleteOne(ctx, bson.M{"_id": name, "holder": InstanceID()})
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestClusterLock verifies a held lock keeps others out until it's released or its lease runs out
+func TestClusterLock(t *testing.T) {
+	db := dbtest.New(t)
+	locks := NewClusterLockService(db)
+
+	err := locks.WithLock("keys", time.Minute, func() error {
+		if err := locks.WithLock("keys", time.Minute, func() error { return nil }); !errors.Is(err, ErrClusterLockHeld) {
+			t.Errorf("Expected ErrClusterLockHeld while the lock is held, got %v", err)
+		}
+		held, err := locks.HeldLocks()
+		if err != nil || len(held) != 1 || held[0].Holder != InstanceID() {
+			t.Errorf("Expected this instance to hold the lock, got %+v (%v)", held, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithLock() error = %v", err)
+	}
+
+	ran := false
+	if err := locks.WithLock("keys", time.Minute, func() error { ran = true; return nil }); err != nil || !ran {
+		t.Errorf("Expected the released lock to be acquired again, got %v", err)
+	}
+
+	// A lease that ran out is taken over
+	past := time.Now().Add(-time.Minute)
+	dbtest.Insert(t, db, "cluster_locks", &models.ClusterLock{Name: "stale", Holder: "crashed", AcquiredAt: past.Add(-time.Minute), ExpiresAt: past})
+	if err := locks.WithLock("stale", time.Minute, func() error { return nil }); err != nil {
+		t.Errorf("Expected an expired lease to be taken over, got %v", err)
+	}
+}
+
+// TestClusterEventBus verifies events from other instances are delivered once and an instance's
+// own events are not delivered back to it
+func TestClusterEventBus(t *testing.T) {
+	db := dbtest.New(t)
+	bus := NewClusterEventBus(db)
+
+	var received []string
+	bus.Subscribe(ClusterEventSessionRevoked, func(event *models.ClusterEvent) error {
+		received = append(received, event.Data["user_id"].(string))
+		return nil
+	})
+
+	if err := bus.Publish(ClusterEventSessionRevoked, "tenant", map[string]interface{}{"user_id": "own"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	dbtest.Insert(t, db, "cluster_events", &models.ClusterEvent{
+		ID: primitive.NewObjectID(), Type: ClusterEventSessionRevoked, Origin: "other-instance", Region: "eu",
+		TenantID: "tenant", Data: map[string]interface{}{"user_id": "remote"}, CreatedAt: time.Now(),
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := bus.Poll(); err != nil {
+			t.Fatalf("Poll() error = %v", err)
+		}
+	}
+	if len(received) != 1 || received[0] != "remote" {
+		t.Errorf("Expected only the remote event, once, got %v", received)
+	}
+	if stats := bus.Stats(); stats.Delivered != 1 {
+		t.Errorf("Expected 1 delivered event, got %d", stats.Delivered)
+	}
+}
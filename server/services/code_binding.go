@@ -0,0 +1,57 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+
+	"oauth2-openid-server/models"
+)
+
+// Code binding modes of a tenant (TenantSettings.CodeBinding)
+const (
+	CodeBindingOff         = "off"
+	CodeBindingUserAgent   = "user_agent"
+	CodeBindingUserAgentIP = "user_agent_ip"
+)
+
+// CodeBinding identifies the client device an authorization code was issued to
+type CodeBinding struct {
+	UserAgent string
+	IP        string
+}
+
+// RequestCodeBinding returns the binding of the device that sent the request
+func RequestCodeBinding(r *http.Request) CodeBinding {
+	if r == nil {
+		return CodeBinding{}
+	}
+	return CodeBinding{UserAgent: r.UserAgent(), IP: ClientIP(r)}
+}
+
+// hashCodeBinding hashes the parts of the binding the mode covers. It returns "" when codes
+// are not bound.
+func hashCodeBinding(mode string, binding CodeBinding) string {
+	var material string
+	switch mode {
+	case CodeBindingUserAgent:
+		material = "ua:" + binding.UserAgent
+	case CodeBindingUserAgentIP:
+		material = "ua:" + binding.UserAgent + "\nip:" + binding.IP
+	default:
+		return ""
+	}
+	sum := sha256.Sum256([]byte(material))
+	return hex.EncodeToString(sum[:])
+}
+
+// codeBindingMatches reports whether the token request comes from the device the code was
+// issued to. Codes issued without a binding always match.
+func codeBindingMatches(authCode *models.AuthorizationCode, binding CodeBinding) bool {
+	if authCode.BindingHash == "" {
+		return true
+	}
+	expected := hashCodeBinding(authCode.BindingMode, binding)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(authCode.BindingHash)) == 1
+}
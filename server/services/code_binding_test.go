@@ -0,0 +1,50 @@
+package services
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestCodeBindingMatches(t *testing.T) {
+	issued := CodeBinding{UserAgent: "Mozilla/5.0 (iPhone)", IP: "203.0.113.7"}
+
+	unbound := &models.AuthorizationCode{}
+	if !codeBindingMatches(unbound, CodeBinding{UserAgent: "curl/8.0", IP: "198.51.100.1"}) {
+		t.Error("Expected codes without a binding to match any device")
+	}
+
+	uaBound := &models.AuthorizationCode{BindingMode: CodeBindingUserAgent, BindingHash: hashCodeBinding(CodeBindingUserAgent, issued)}
+	if !codeBindingMatches(uaBound, CodeBinding{UserAgent: issued.UserAgent, IP: "198.51.100.1"}) {
+		t.Error("Expected user agent binding to ignore the IP address")
+	}
+	if codeBindingMatches(uaBound, CodeBinding{UserAgent: "curl/8.0", IP: issued.IP}) {
+		t.Error("Expected a different user agent to be rejected")
+	}
+
+	strict := &models.AuthorizationCode{BindingMode: CodeBindingUserAgentIP, BindingHash: hashCodeBinding(CodeBindingUserAgentIP, issued)}
+	if !codeBindingMatches(strict, issued) {
+		t.Error("Expected the issuing device to match")
+	}
+	if codeBindingMatches(strict, CodeBinding{UserAgent: issued.UserAgent, IP: "198.51.100.1"}) {
+		t.Error("Expected a different IP address to be rejected")
+	}
+}
+
+func TestHashCodeBindingOff(t *testing.T) {
+	if hash := hashCodeBinding(CodeBindingOff, CodeBinding{UserAgent: "ua", IP: "ip"}); hash != "" {
+		t.Errorf("Expected no hash when binding is off, got %s", hash)
+	}
+}
+
+func TestRequestCodeBinding(t *testing.T) {
+	r := httptest.NewRequest("POST", "/oauth/token", nil)
+	r.RemoteAddr = "203.0.113.7:51234"
+	r.Header.Set("User-Agent", "TestAgent/1.0")
+
+	binding := RequestCodeBinding(r)
+	if binding.UserAgent != "TestAgent/1.0" || binding.IP != "203.0.113.7" {
+		t.Errorf("Unexpected binding: %+v", binding)
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AnyVersion skips the optimistic concurrency check of an update (e.g. for If-Match: *)
+const AnyVersion int64 = -1
+
+// VersionConflictError is returned when an update was based on an outdated version of a resource
+type VersionConflictError struct {
+	CurrentVersion int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("resource was modified concurrently (current version %d)", e.CurrentVersion)
+}
+
+// IsVersionConflict reports whether err is a VersionConflictError and returns it
+func IsVersionConflict(err error) (*VersionConflictError, bool) {
+	var conflict *VersionConflictError
+	if errors.As(err, &conflict) {
+		return conflict, true
+	}
+	return nil, false
+}
+
+// withExpectedVersion returns a copy of filter that only matches the expected version.
+// Documents written before versioning have no version field and match version 0.
+func withExpectedVersion(filter bson.M, expected int64) bson.M {
+	versioned := bson.M{}
+	for key, value := range filter {
+		versioned[key] = value
+	}
+
+	switch {
+	case expected == AnyVersion:
+	case expected == 0:
+		versioned["$or"] = bson.A{bson.M{"version": 0}, bson.M{"version": bson.M{"$exists": false}}}
+	default:
+		versioned["version"] = expected
+	}
+
+	return versioned
+}
+
+// versionedUpdateError explains why a versioned update matched no document: either the
+// document does not exist (notFound) or its version changed.
+func versionedUpdateError(ctx context.Context, collection *mongo.Collection, filter bson.M, notFound error) error {
+	var current struct {
+		Version int64 `bson:"version"`
+	}
+
+	err := collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"version": 1})).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return &VersionConflictError{CurrentVersion: current.Version}
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"log"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ConsentReceiptFilter narrows a consent receipt export. Zero fields don't filter.
+type ConsentReceiptFilter struct {
+	ClientID string
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+}
+
+// ConsentReceiptEntry is a consent receipt with the names of its user and client, as exported for
+// auditors. Users and clients deleted since keep their IDs only.
+type ConsentReceiptEntry struct {
+	*models.ConsentReceipt
+	UserEmail  string `json:"user_email,omitempty"`
+	ClientName string `json:"client_name,omitempty"`
+}
+
+// receipts returns the collection holding the tenant's consent receipts
+func (s *ConsentService) receipts(tenantID string) *mongo.Collection {
+	return s.db.TenantCollection(tenantID, "consent_receipts")
+}
+
+// EnsureIndexes creates the consent receipt indexes of the per-client export
+func (s *ConsentService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.db.EnsureTenantIndexes(ctx, "consent_receipts", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "client_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+}
+
+// recordReceipt stores a consent receipt. Failures are logged and never fail the consent flow.
+func (s *ConsentService) recordReceipt(receipt *models.ConsentReceipt) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	receipt.ID = primitive.NewObjectID()
+	receipt.CreatedAt = time.Now()
+	if receipt.Scopes == nil {
+		receipt.Scopes = []string{}
+	}
+
+	if _, err := s.receipts(receipt.TenantID).InsertOne(ctx, receipt); err != nil {
+		log.Printf("Warning: Failed to record consent receipt of user %s for client %s: %v", receipt.UserID, receipt.ClientID, err)
+	}
+}
+
+// ExportReceipts returns the tenant's consent receipts, grants and withdrawals, ordered by client
+// and time
+func (s *ConsentService) ExportReceipts(tenantID string, filter ConsentReceiptFilter) ([]*ConsentReceiptEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	query := bson.M{"tenant_id": tenantID}
+	if filter.ClientID != "" {
+		query["client_id"] = filter.ClientID
+	}
+	created := bson.M{}
+	if !filter.From.IsZero() {
+		created["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		created["$lt"] = filter.To
+	}
+	if len(created) > 0 {
+		query["created_at"] = created
+	}
+
+	cursor, err := s.receipts(tenantID).Find(ctx, query,
+		options.Find().SetSort(bson.D{{Key: "client_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var receipts []*models.ConsentReceipt
+	if err := cursor.All(ctx, &receipts); err != nil {
+		return nil, err
+	}
+
+	userIDs := map[string]bool{}
+	clientIDs := map[string]bool{}
+	for _, receipt := range receipts {
+		userIDs[receipt.UserID] = true
+		clientIDs[receipt.ClientID] = true
+	}
+	emails, err := s.userEmails(ctx, tenantID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	clientNames, err := s.clientNames(ctx, tenantID, clientIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*ConsentReceiptEntry, len(receipts))
+	for i, receipt := range receipts {
+		entries[i] = &ConsentReceiptEntry{
+			ConsentReceipt: receipt,
+			UserEmail:      emails[receipt.UserID],
+			ClientName:     clientNames[receipt.ClientID],
+		}
+	}
+	return entries, nil
+}
+
+func (s *ConsentService) userEmails(ctx context.Context, tenantID string, userIDs map[string]bool) (map[string]string, error) {
+	ids := make([]primitive.ObjectID, 0, len(userIDs))
+	for userID := range userIDs {
+		if id, err := primitive.ObjectIDFromHex(userID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	cursor, err := s.db.GetCollection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "tenant_id": tenantID},
+		options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []*models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	emails := make(map[string]string, len(users))
+	for _, user := range users {
+		emails[user.ID.Hex()] = user.Email
+	}
+	return emails, nil
+}
+
+func (s *ConsentService) clientNames(ctx context.Context, tenantID string, clientIDs map[string]bool) (map[string]string, error) {
+	ids := make([]string, 0, len(clientIDs))
+	for clientID := range clientIDs {
+		ids = append(ids, clientID)
+	}
+
+	cursor, err := s.db.GetCollection("clients").Find(ctx, bson.M{"client_id": bson.M{"$in": ids}, "tenant_id": tenantID},
+		options.Find().SetProjection(bson.M{"client_id": 1, "name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var clients []*models.Client
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(clients))
+	for _, client := range clients {
+		names[client.ClientID] = client.Name
+	}
+	return names, nil
+}
+
+// ConsentReceiptsCSV renders consent receipts as CSV, one row per receipt with scopes and claims
+// separated by spaces
+func ConsentReceiptsCSV(entries []*ConsentReceiptEntry) ([]byte, error) {
+	rows := [][]string{{"Receipt ID", "Time", "Action", "User ID", "User email", "Client ID", "Client name", "Scopes", "Declined scopes", "Declined claims"}}
+	for _, entry := range entries {
+		rows = append(rows, []string{
+			entry.ID.Hex(),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			entry.Action,
+			entry.UserID,
+			entry.UserEmail,
+			entry.ClientID,
+			entry.ClientName,
+			strings.Join(entry.Scopes, " "),
+			strings.Join(entry.DeclinedScopes, " "),
+			strings.Join(entry.DeclinedClaims, " "),
+		})
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
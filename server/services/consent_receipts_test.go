@@ -0,0 +1,91 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConsentReceiptsCSV(t *testing.T) {
+	entry := &ConsentReceiptEntry{
+		ConsentReceipt: &models.ConsentReceipt{
+			ID: primitive.NewObjectID(), UserID: "u1", ClientID: "app", Action: models.ConsentReceiptGranted,
+			Scopes: []string{"openid", "email"}, DeclinedClaims: []string{"name"},
+			CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+		UserEmail:  "jane@example.com",
+		ClientName: "App",
+	}
+
+	data, err := ConsentReceiptsCSV([]*ConsentReceiptEntry{entry})
+	if err != nil {
+		t.Fatalf("ConsentReceiptsCSV() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "Receipt ID,Time,Action") {
+		t.Fatalf("ConsentReceiptsCSV() = %q, want a header and one row", data)
+	}
+	if !strings.Contains(lines[1], "2024-05-01T12:00:00Z,granted,u1,jane@example.com,app,App,openid email,,name") {
+		t.Errorf("ConsentReceiptsCSV() row = %q", lines[1])
+	}
+}
+
+// TestConsentReceipts records a receipt for every consent decision and withdrawal and exports
+// them per client
+func TestConsentReceipts(t *testing.T) {
+	db := dbtest.New(t)
+	now := time.Now()
+
+	tenantID := primitive.NewObjectID().Hex()
+	userID := primitive.NewObjectID()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, TenantID: tenantID, Email: "jane@example.com", Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), TenantID: tenantID, ClientID: "app", Name: "App", Active: true, CreatedAt: now, UpdatedAt: now})
+
+	service := NewConsentService(db)
+	user := userID.Hex()
+	if err := service.GrantConsent(user, "app", tenantID, ConsentDecision{ApprovedScopes: []string{"openid", "email"}}); err != nil {
+		t.Fatalf("GrantConsent() error = %v", err)
+	}
+	if err := service.GrantConsent(user, "app", tenantID, ConsentDecision{ApprovedScopes: []string{"openid"}, DeclinedScopes: []string{"email"}}); err != nil {
+		t.Fatalf("GrantConsent() error = %v", err)
+	}
+	if err := service.GrantConsent(user, "other", tenantID, ConsentDecision{ApprovedScopes: []string{"openid"}}); err != nil {
+		t.Fatalf("GrantConsent() error = %v", err)
+	}
+	if err := service.RevokeConsent(user, "app", tenantID); err != nil {
+		t.Fatalf("RevokeConsent() error = %v", err)
+	}
+	if err := service.RevokeConsent(user, "app", tenantID); err != ErrConsentNotFound {
+		t.Errorf("RevokeConsent() twice error = %v, want ErrConsentNotFound", err)
+	}
+
+	entries, err := service.ExportReceipts(tenantID, ConsentReceiptFilter{ClientID: "app"})
+	if err != nil {
+		t.Fatalf("ExportReceipts() error = %v", err)
+	}
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	if strings.Join(actions, ",") != "granted,granted,withdrawn" {
+		t.Fatalf("ExportReceipts() actions = %v, want granted, granted, withdrawn", actions)
+	}
+	if entries[0].UserEmail != "jane@example.com" || entries[0].ClientName != "App" {
+		t.Errorf("ExportReceipts() names = %q/%q", entries[0].UserEmail, entries[0].ClientName)
+	}
+	if strings.Join(entries[1].DeclinedScopes, ",") != "email" || strings.Join(entries[2].Scopes, ",") != "openid" {
+		t.Errorf("ExportReceipts() = %+v / %+v, want the declined email scope and the withdrawn openid scope", entries[1].ConsentReceipt, entries[2].ConsentReceipt)
+	}
+
+	if all, err := service.ExportReceipts(tenantID, ConsentReceiptFilter{}); err != nil || len(all) != 4 {
+		t.Errorf("ExportReceipts() of the tenant = %d receipts, %v; want 4", len(all), err)
+	}
+	if other, err := service.ExportReceipts(primitive.NewObjectID().Hex(), ConsentReceiptFilter{}); err != nil || len(other) != 0 {
+		t.Errorf("ExportReceipts() of another tenant = %d receipts, %v; want none", len(other), err)
+	}
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RequiredConsentScopes cannot be declined on the consent screen
+var RequiredConsentScopes = []string{"openid"}
+
+// Optional claims a user can withhold from a client while approving the rest of the request
+const (
+	ClaimEmail  = "email"
+	ClaimName   = "name"
+	ClaimGroups = "groups"
+)
+
+// OptionalClaims lists the claims that can be withheld, in display order
+var OptionalClaims = []string{ClaimEmail, ClaimName, ClaimGroups}
+
+// scopeClaims maps scopes to the optional claims they release, so declining a scope withholds its claims
+var scopeClaims = map[string][]string{
+	"email":   {ClaimEmail},
+	"profile": {ClaimName},
+	"groups":  {ClaimGroups},
+}
+
+// ConsentDecision is the outcome of a consent screen: the scopes the user approved and the
+// optional scopes and claims the user declined
+type ConsentDecision struct {
+	ApprovedScopes []string
+	DeclinedScopes []string
+	DeclinedClaims []string
+}
+
+// OptionalConsentScopes returns the scopes of a request the user may decline
+func OptionalConsentScopes(scopes []string) []string {
+	return removeStrings(scopes, RequiredConsentScopes)
+}
+
+// ResolveConsent builds a consent decision for the requested scopes. approved is the subset the
+// user kept selected; nil means everything was approved. Required scopes are always approved and
+// unknown claims are ignored.
+func ResolveConsent(requested, approved, declinedClaims []string) ConsentDecision {
+	decision := ConsentDecision{ApprovedScopes: []string{}, DeclinedScopes: []string{}, DeclinedClaims: []string{}}
+
+	for _, scope := range requested {
+		if approved == nil || containsString(approved, scope) || containsString(RequiredConsentScopes, scope) {
+			decision.ApprovedScopes = append(decision.ApprovedScopes, scope)
+		} else {
+			decision.DeclinedScopes = append(decision.DeclinedScopes, scope)
+		}
+	}
+
+	for _, claim := range OptionalClaims {
+		if containsString(declinedClaims, claim) {
+			decision.DeclinedClaims = append(decision.DeclinedClaims, claim)
+		}
+	}
+
+	return decision
+}
+
+type ConsentService struct {
+	db *database.MongoDB
+}
+
+func NewConsentService(db *database.MongoDB) *ConsentService {
+	return &ConsentService{
+		db: db,
+	}
+}
+
+// consents returns the collection holding the tenant's consent grants
+func (s *ConsentService) consents(tenantID string) *mongo.Collection {
+	return s.db.TenantCollection(tenantID, "consents")
+}
+
+// GetConsent returns the consent a user has given to a client
+func (s *ConsentService) GetConsent(userID, clientID, tenantID string) (*models.ConsentGrant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "client_id": clientID}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	var consent models.ConsentGrant
+	err := s.consents(tenantID).FindOne(ctx, filter).Decode(&consent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("consent not found")
+		}
+		return nil, err
+	}
+
+	return &consent, nil
+}
+
+// HasConsent reports whether the user already decided on all the given scopes for the client,
+// either by approving or by declining them
+func (s *ConsentService) HasConsent(userID, clientID, tenantID string, scopes []string) bool {
+	consent, err := s.GetConsent(userID, clientID, tenantID)
+	if err != nil {
+		return false
+	}
+
+	for _, scope := range scopes {
+		if !containsString(consent.Scopes, scope) && !containsString(consent.DeclinedScopes, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GrantConsent records a consent decision, merging it with any previous consent. Scopes approved
+// now are no longer declined and vice versa; the declined claims replace the previous choice.
+func (s *ConsentService) GrantConsent(userID, clientID, tenantID string, decision ConsentDecision) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	scopes := decision.ApprovedScopes
+	declinedScopes := decision.DeclinedScopes
+	if previous, err := s.GetConsent(userID, clientID, tenantID); err == nil {
+		scopes = mergeScopes(removeStrings(previous.Scopes, decision.DeclinedScopes), decision.ApprovedScopes)
+		declinedScopes = mergeScopes(removeStrings(previous.DeclinedScopes, decision.ApprovedScopes), decision.DeclinedScopes)
+	}
+
+	now := time.Now()
+	_, err := s.consents(tenantID).UpdateOne(ctx, bson.M{
+		"user_id":   userID,
+		"client_id": clientID,
+		"tenant_id": tenantID,
+	}, bson.M{
+		"$set": bson.M{
+			"scopes":          scopes,
+			"declined_scopes": declinedScopes,
+			"declined_claims": decision.DeclinedClaims,
+			"updated_at":      now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	s.recordReceipt(&models.ConsentReceipt{
+		TenantID:       tenantID,
+		UserID:         userID,
+		ClientID:       clientID,
+		Action:         models.ConsentReceiptGranted,
+		Scopes:         decision.ApprovedScopes,
+		DeclinedScopes: decision.DeclinedScopes,
+		DeclinedClaims: decision.DeclinedClaims,
+	})
+	return nil
+}
+
+// FilterGranted removes the scopes the user declined for the client from a scope list
+func (s *ConsentService) FilterGranted(userID, clientID, tenantID string, scopes []string) []string {
+	consent, err := s.GetConsent(userID, clientID, tenantID)
+	if err != nil {
+		return scopes
+	}
+	return removeStrings(scopes, consent.DeclinedScopes)
+}
+
+// WithheldClaims returns the optional claims that must not be released to the client: the claims
+// the user declined and the claims of declined scopes
+func (s *ConsentService) WithheldClaims(userID, clientID, tenantID string) []string {
+	consent, err := s.GetConsent(userID, clientID, tenantID)
+	if err != nil {
+		return nil
+	}
+	return withheldClaims(consent)
+}
+
+func withheldClaims(consent *models.ConsentGrant) []string {
+	withheld := []string{}
+	for _, claim := range OptionalClaims {
+		declined := containsString(consent.DeclinedClaims, claim)
+		for _, scope := range consent.DeclinedScopes {
+			if containsString(scopeClaims[scope], claim) {
+				declined = true
+			}
+		}
+		if declined {
+			withheld = append(withheld, claim)
+		}
+	}
+	return withheld
+}
+
+func mergeScopes(scopes, add []string) []string {
+	for _, scope := range add {
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func removeStrings(values, remove []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if !containsString(remove, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// GetUserConsents lists all clients the user has granted consent to
+func (s *ConsentService) GetUserConsents(userID, tenantID string) ([]*models.ConsentGrant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	cursor, err := s.consents(tenantID).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var consents []*models.ConsentGrant
+	if err = cursor.All(ctx, &consents); err != nil {
+		return nil, err
+	}
+
+	return consents, nil
+}
+
+// ErrConsentNotFound is returned when withdrawing a consent the user never gave
+var ErrConsentNotFound = errors.New("consent not found")
+
+// RevokeConsent removes the user's consent for a client and records the withdrawal
+func (s *ConsentService) RevokeConsent(userID, clientID, tenantID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "client_id": clientID}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	var withdrawn models.ConsentGrant
+	err := s.consents(tenantID).FindOneAndDelete(ctx, filter).Decode(&withdrawn)
+	if err == mongo.ErrNoDocuments {
+		return ErrConsentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.recordReceipt(&models.ConsentReceipt{
+		TenantID: withdrawn.TenantID,
+		UserID:   userID,
+		ClientID: clientID,
+		Action:   models.ConsentReceiptWithdrawn,
+		Scopes:   withdrawn.Scopes,
+	})
+	return nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestResolveConsentApprovesEverythingByDefault(t *testing.T) {
+	decision := ResolveConsent([]string{"openid", "email", "groups"}, nil, nil)
+
+	if !reflect.DeepEqual(decision.ApprovedScopes, []string{"openid", "email", "groups"}) {
+		t.Errorf("Expected all scopes to be approved, got %v", decision.ApprovedScopes)
+	}
+	if len(decision.DeclinedScopes) != 0 || len(decision.DeclinedClaims) != 0 {
+		t.Errorf("Expected nothing to be declined, got %+v", decision)
+	}
+}
+
+func TestResolveConsentDeclinesUnselectedScopes(t *testing.T) {
+	decision := ResolveConsent([]string{"openid", "email", "groups"}, []string{"email"}, []string{"name", "unknown"})
+
+	if !reflect.DeepEqual(decision.ApprovedScopes, []string{"openid", "email"}) {
+		t.Errorf("Expected openid to stay approved with email, got %v", decision.ApprovedScopes)
+	}
+	if !reflect.DeepEqual(decision.DeclinedScopes, []string{"groups"}) {
+		t.Errorf("Expected groups to be declined, got %v", decision.DeclinedScopes)
+	}
+	if !reflect.DeepEqual(decision.DeclinedClaims, []string{"name"}) {
+		t.Errorf("Expected only known claims to be declined, got %v", decision.DeclinedClaims)
+	}
+}
+
+func TestWithheldClaims(t *testing.T) {
+	withheld := withheldClaims(&models.ConsentGrant{
+		Scopes:         []string{"openid", "email"},
+		DeclinedScopes: []string{"groups"},
+		DeclinedClaims: []string{"email"},
+	})
+
+	if !reflect.DeepEqual(withheld, []string{"email", "groups"}) {
+		t.Errorf("Expected declined claims and claims of declined scopes, got %v", withheld)
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// hostCookiePrefix makes browsers accept a cookie only if it is Secure, host-only and set for
+	// the whole site, so subdomains and plain-HTTP pages can't plant or overwrite it
+	hostCookiePrefix = "__Host-"
+	// DefaultCookieKeyRotation is how long a cookie signing key is used for new cookies
+	DefaultCookieKeyRotation = 24 * time.Hour
+	// minCookieKeyRotation keeps the number of keys a cookie is checked against small
+	minCookieKeyRotation = time.Hour
+	// maxSignedCookieAge is how long signed cookies are accepted: as long as the longest SSO session
+	maxSignedCookieAge = MaxSSOSessionLifetime * time.Second
+	// cookieMACSize is the length of the truncated HMAC-SHA256 signature
+	cookieMACSize = 16
+)
+
+var ErrCookieNotFound = errors.New("cookie not found or not signed by this server")
+
+// RequestScheme returns the scheme the client used: the first X-Forwarded-Proto entry set by a
+// proxy in front of the server, otherwise whether the connection is TLS
+func RequestScheme(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+		proto := strings.ToLower(strings.TrimSpace(strings.Split(forwarded, ",")[0]))
+		if proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// IsSecureRequest reports whether the client reached the server over HTTPS
+func IsSecureRequest(r *http.Request) bool {
+	return RequestScheme(r) == "https"
+}
+
+// RequestBaseURL returns the scheme and host the request was made to
+func RequestBaseURL(r *http.Request) string {
+	return RequestScheme(r) + "://" + r.Host
+}
+
+// CookieService sets and reads the cookies of the browser flows (social login states, SSO
+// sessions). Values are signed with HMAC-SHA256 under a key derived from the secret for the
+// current rotation period, and bound to the cookie name. Over HTTPS cookies are Secure and get
+// the __Host- prefix; SameSite=None falls back to Lax over HTTP, where browsers refuse it.
+// Every replica configured with the same secret reads the cookies set by the others.
+type CookieService struct {
+	secret   []byte
+	rotation time.Duration
+	now      func() time.Time
+}
+
+// NewCookieService signs cookies with keys derived from secret, a new one every rotation (at
+// least an hour; 0 selects a day). Cookies stay valid across rotations until they expire.
+func NewCookieService(secret string, rotation time.Duration) *CookieService {
+	if rotation == 0 {
+		rotation = DefaultCookieKeyRotation
+	}
+	if rotation < minCookieKeyRotation {
+		rotation = minCookieKeyRotation
+	}
+	return &CookieService{
+		secret:   deriveFlowKey(secret, "cookie-signing"),
+		rotation: rotation,
+		now:      time.Now,
+	}
+}
+
+// Set sets the cookie with a signed value. Name, value, max age and SameSite are taken from
+// cookie; it is always HttpOnly and set for the whole site.
+func (s *CookieService) Set(w http.ResponseWriter, r *http.Request, cookie *http.Cookie) {
+	secure := IsSecureRequest(r)
+	sameSite := cookie.SameSite
+	if sameSite == http.SameSiteDefaultMode || (sameSite == http.SameSiteNoneMode && !secure) {
+		sameSite = http.SameSiteLaxMode
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName(cookie.Name, secure),
+		Value:    s.sign(cookie.Name, cookie.Value),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+		MaxAge:   cookie.MaxAge,
+	})
+}
+
+// Clear deletes the named cookie
+func (s *CookieService) Clear(w http.ResponseWriter, r *http.Request, name string) {
+	secure := IsSecureRequest(r)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName(name, secure),
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// Value returns the value of the named cookie. Cookies that are missing, unsigned, tampered
+// with, copied from another cookie or signed with an expired key return ErrCookieNotFound.
+func (s *CookieService) Value(r *http.Request, name string) (string, error) {
+	// The prefixed cookie comes first; browsers only send it when it was set over HTTPS
+	for _, candidate := range []string{hostCookiePrefix + name, name} {
+		cookie, err := r.Cookie(candidate)
+		if err != nil {
+			continue
+		}
+		if value, ok := s.verify(name, cookie.Value); ok {
+			return value, nil
+		}
+	}
+	return "", ErrCookieNotFound
+}
+
+func cookieName(name string, secure bool) string {
+	if secure {
+		return hostCookiePrefix + name
+	}
+	return name
+}
+
+// sign returns "<value>.<period>.<signature>"
+func (s *CookieService) sign(name, value string) string {
+	period := s.now().Unix() / int64(s.rotation/time.Second)
+	return value + "." + strconv.FormatInt(period, 36) + "." + base64.RawURLEncoding.EncodeToString(s.mac(period, name, value))
+}
+
+// verify checks a signed value and returns the value without the signature
+func (s *CookieService) verify(name, signed string) (string, bool) {
+	rest, signature, ok := cutLast(signed, ".")
+	if !ok {
+		return "", false
+	}
+	value, periodText, ok := cutLast(rest, ".")
+	if !ok {
+		return "", false
+	}
+	period, err := strconv.ParseInt(periodText, 36, 64)
+	if err != nil {
+		return "", false
+	}
+
+	current := s.now().Unix() / int64(s.rotation/time.Second)
+	oldest := current - int64((maxSignedCookieAge+s.rotation-1)/s.rotation)
+	if period > current || period < oldest {
+		return "", false
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(period, name, value)) {
+		return "", false
+	}
+	return value, true
+}
+
+// mac signs the cookie name and value with the key of a rotation period
+func (s *CookieService) mac(period int64, name, value string) []byte {
+	var periodBytes [8]byte
+	binary.BigEndian.PutUint64(periodBytes[:], uint64(period))
+	keyMAC := hmac.New(sha256.New, s.secret)
+	keyMAC.Write(periodBytes[:])
+
+	mac := hmac.New(sha256.New, keyMAC.Sum(nil))
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return mac.Sum(nil)[:cookieMACSize]
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestScheme(t *testing.T) {
+	tests := []struct {
+		forwarded string
+		want      string
+	}{
+		{"", "http"},
+		{"https", "https"},
+		{"HTTPS, http", "https"},
+		{"http", "http"},
+		{"wss", "http"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.forwarded)
+		}
+		if got := RequestScheme(r); got != tt.want {
+			t.Errorf("RequestScheme(X-Forwarded-Proto: %q) = %q, want %q", tt.forwarded, got, tt.want)
+		}
+	}
+
+	if got := RequestScheme(httptest.NewRequest("GET", "https://auth.example.com/", nil)); got != "https" {
+		t.Errorf("RequestScheme(TLS) = %q, want https", got)
+	}
+}
+
+// setAndRead sets a cookie in a response and returns a request of the same scheme carrying it
+func setAndRead(t *testing.T, cookies *CookieService, secure bool, cookie *http.Cookie) (*http.Cookie, *http.Request) {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if secure {
+		r.Header.Set("X-Forwarded-Proto", "https")
+	}
+	w := httptest.NewRecorder()
+	cookies.Set(w, r, cookie)
+
+	set := w.Result().Cookies()
+	if len(set) != 1 {
+		t.Fatalf("Set() set %d cookies", len(set))
+	}
+	next := httptest.NewRequest("GET", "/", nil)
+	next.Header.Set("X-Forwarded-Proto", r.Header.Get("X-Forwarded-Proto"))
+	next.AddCookie(&http.Cookie{Name: set[0].Name, Value: set[0].Value})
+	return set[0], next
+}
+
+func TestCookieServiceSignsAndPrefixes(t *testing.T) {
+	cookies := NewCookieService("test-secret", 0)
+
+	set, next := setAndRead(t, cookies, true, &http.Cookie{Name: "sso_session", Value: "token", SameSite: http.SameSiteNoneMode, MaxAge: 60})
+	if set.Name != "__Host-sso_session" || !set.Secure || !set.HttpOnly || set.Path != "/" || set.SameSite != http.SameSiteNoneMode {
+		t.Errorf("HTTPS cookie = %+v", set)
+	}
+	if value, err := cookies.Value(next, "sso_session"); err != nil || value != "token" {
+		t.Errorf("Value() = %q, %v", value, err)
+	}
+
+	set, next = setAndRead(t, cookies, false, &http.Cookie{Name: "sso_session", Value: "token", SameSite: http.SameSiteNoneMode})
+	if set.Name != "sso_session" || set.Secure || set.SameSite != http.SameSiteLaxMode {
+		t.Errorf("HTTP cookie = %+v, want unprefixed Lax", set)
+	}
+	if value, err := cookies.Value(next, "sso_session"); err != nil || value != "token" {
+		t.Errorf("Value() over HTTP = %q, %v", value, err)
+	}
+
+	tampered := httptest.NewRequest("GET", "/", nil)
+	tampered.AddCookie(&http.Cookie{Name: "sso_session", Value: strings.Replace(set.Value, "token", "other", 1)})
+	if _, err := cookies.Value(tampered, "sso_session"); err != ErrCookieNotFound {
+		t.Errorf("Value(tampered) error = %v", err)
+	}
+	unsigned := httptest.NewRequest("GET", "/", nil)
+	unsigned.AddCookie(&http.Cookie{Name: "sso_session", Value: "token"})
+	if _, err := cookies.Value(unsigned, "sso_session"); err != ErrCookieNotFound {
+		t.Errorf("Value(unsigned) error = %v", err)
+	}
+	// A value signed for one cookie can't be replayed in another
+	replayed := httptest.NewRequest("GET", "/", nil)
+	replayed.AddCookie(&http.Cookie{Name: "oauth_state_google", Value: set.Value})
+	if _, err := cookies.Value(replayed, "oauth_state_google"); err != ErrCookieNotFound {
+		t.Errorf("Value(replayed) error = %v", err)
+	}
+	if _, err := NewCookieService("other-secret", 0).Value(next, "sso_session"); err != ErrCookieNotFound {
+		t.Errorf("Value() with another secret error = %v", err)
+	}
+}
+
+func TestCookieServiceKeyRotation(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cookies := NewCookieService("test-secret", time.Hour)
+	cookies.now = func() time.Time { return now }
+
+	set, next := setAndRead(t, cookies, true, &http.Cookie{Name: "sso_session", Value: "token"})
+
+	now = now.Add(2 * time.Hour)
+	if again, _ := setAndRead(t, cookies, true, &http.Cookie{Name: "sso_session", Value: "token"}); again.Value == set.Value {
+		t.Error("cookie signed with the same key after a rotation")
+	}
+	if _, err := cookies.Value(next, "sso_session"); err != nil {
+		t.Errorf("Value() after a rotation error = %v", err)
+	}
+
+	now = now.Add(maxSignedCookieAge)
+	if _, err := cookies.Value(next, "sso_session"); err != ErrCookieNotFound {
+		t.Errorf("Value() with an expired key error = %v", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"oauth2-openid-server/database"
@@ -20,28 +21,63 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	// ErrKeyNotFound is returned when a key ID does not exist for the tenant
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyChangeInProgress is returned while another instance creates or rotates the same keys
+	ErrKeyChangeInProgress = errors.New("another server instance is changing these keys, try again shortly")
+)
+
+const (
+	// defaultKeyGracePeriod is how long replaced keys stay published when the policy does not set one
+	defaultKeyGracePeriod = 24 * time.Hour
+	// defaultKeyPrepublishWindow is how long next keys are published before they become active
+	defaultKeyPrepublishWindow = 24 * time.Hour
+	// keyLockLease bounds how long one instance may take to create or rotate an owner's keys
+	keyLockLease = 2 * time.Minute
+)
+
+// CryptoKeyService manages the signing keys. Keys of an owner are only created or rotated under
+// its cluster lock, and every change re-reads the keys inside the lock, so instances in different
+// regions never create competing keys.
 type CryptoKeyService struct {
 	db             *database.MongoDB
 	keyCollection  *mongo.Collection
+	tenantService  *TenantService
+	locks          *ClusterLockService
 }
 
 func NewCryptoKeyService(db *database.MongoDB) *CryptoKeyService {
 	return &CryptoKeyService{
 		db:            db,
 		keyCollection: db.GetCollection("crypto_keys"),
+		tenantService: NewTenantService(db),
+		locks:         NewClusterLockService(db),
 	}
 }
 
-// GetActiveKeys retrieves all active cryptographic keys
+// GetActiveKeys retrieves all active platform keys
 func (s *CryptoKeyService) GetActiveKeys(ctx context.Context) ([]models.CryptoKey, error) {
-	filter := bson.M{
-		"active": true,
-		"$or": []bson.M{
-			{"expires_at": nil},
-			{"expires_at": bson.M{"$gt": time.Now()}},
-		},
+	return s.findActiveKeys(ctx, keyOwnerFilter(""))
+}
+
+// GetActiveKeysForTenant retrieves the active platform keys and the tenant's own keys
+func (s *CryptoKeyService) GetActiveKeysForTenant(ctx context.Context, tenantID string) ([]models.CryptoKey, error) {
+	owner := s.keyOwner(tenantID)
+	if owner == "" {
+		return s.GetActiveKeys(ctx)
+	}
+	return s.findActiveKeys(ctx, bson.M{"tenant_id": bson.M{"$in": bson.A{nil, "", owner}}})
+}
+
+func (s *CryptoKeyService) findActiveKeys(ctx context.Context, filter bson.M) ([]models.CryptoKey, error) {
+	filter["active"] = true
+	filter["$or"] = []bson.M{
+		{"expires_at": nil},
+		{"expires_at": bson.M{"$gt": time.Now()}},
 	}
 
 	cursor, err := s.keyCollection.Find(ctx, filter)
@@ -71,8 +107,30 @@ func (s *CryptoKeyService) GetKeyByID(ctx context.Context, keyID string) (*model
 	return &key, nil
 }
 
-// CreateRSAKey generates and stores a new RSA key pair
+// ListKeys returns the keys of a tenant (the platform keys for the default tenant), newest first,
+// including replaced keys in their grace period and revoked keys
+func (s *CryptoKeyService) ListKeys(ctx context.Context, tenantID string) ([]models.CryptoKey, error) {
+	cursor, err := s.keyCollection.Find(ctx, keyOwnerFilter(s.keyOwner(tenantID)),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.CryptoKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// CreateRSAKey generates and stores a new RSA platform key pair
 func (s *CryptoKeyService) CreateRSAKey(ctx context.Context, keySize int) (*models.CryptoKey, error) {
+	return s.createRSAKey(ctx, "", keySize, nil)
+}
+
+func (s *CryptoKeyService) createRSAKey(ctx context.Context, owner string, keySize int, activatesAt *time.Time) (*models.CryptoKey, error) {
 	if keySize < 2048 {
 		keySize = 2048 // Minimum secure key size
 	}
@@ -109,13 +167,16 @@ func (s *CryptoKeyService) CreateRSAKey(ctx context.Context, keySize int) (*mode
 	// Create key model
 	key := &models.CryptoKey{
 		ID:         primitive.NewObjectID(),
+		TenantID:   owner,
 		KeyID:      keyID,
 		KeyType:    "rsa",
 		Algorithm:  "RS256",
 		PrivateKey: privateKeyPEM,
 		PublicKey:  publicKeyPEM,
 		Active:     true,
+		Region:     s.db.HomeRegion(),
 		CreatedAt:  time.Now(),
+		ActivatesAt: activatesAt,
 	}
 
 	// Store in database
@@ -127,8 +188,12 @@ func (s *CryptoKeyService) CreateRSAKey(ctx context.Context, keySize int) (*mode
 	return key, nil
 }
 
-// CreateECDSAKey generates and stores a new ECDSA key pair
+// CreateECDSAKey generates and stores a new ECDSA platform key pair
 func (s *CryptoKeyService) CreateECDSAKey(ctx context.Context) (*models.CryptoKey, error) {
+	return s.createECDSAKey(ctx, "", nil)
+}
+
+func (s *CryptoKeyService) createECDSAKey(ctx context.Context, owner string, activatesAt *time.Time) (*models.CryptoKey, error) {
 	// Generate ECDSA key pair using P-256 curve
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -161,13 +226,16 @@ func (s *CryptoKeyService) CreateECDSAKey(ctx context.Context) (*models.CryptoKe
 	// Create key model
 	key := &models.CryptoKey{
 		ID:         primitive.NewObjectID(),
+		TenantID:   owner,
 		KeyID:      keyID,
 		KeyType:    "ecdsa",
 		Algorithm:  "ES256",
 		PrivateKey: privateKeyPEM,
 		PublicKey:  publicKeyPEM,
 		Active:     true,
+		Region:     s.db.HomeRegion(),
 		CreatedAt:  time.Now(),
+		ActivatesAt: activatesAt,
 	}
 
 	// Store in database
@@ -196,8 +264,20 @@ func (s *CryptoKeyService) DeactivateKey(ctx context.Context, keyID string) erro
 	return nil
 }
 
-// InitializeDefaultKeys creates default RSA and ECDSA keys if none exist
+// InitializeDefaultKeys creates default RSA and ECDSA keys if none exist. When instances start
+// together, only the one holding the platform key lock creates them.
 func (s *CryptoKeyService) InitializeDefaultKeys(ctx context.Context) error {
+	err := s.withKeyLock("", func() error {
+		return s.initializeDefaultKeys(ctx)
+	})
+	if err == ErrKeyChangeInProgress {
+		log.Printf("Another instance is creating the default signing keys")
+		return nil
+	}
+	return err
+}
+
+func (s *CryptoKeyService) initializeDefaultKeys(ctx context.Context) error {
 	// Check if any active keys exist
 	activeKeys, err := s.GetActiveKeys(ctx)
 	if err != nil {
@@ -280,49 +360,345 @@ func (s *CryptoKeyService) generateECDSAKeyID(pubKey *ecdsa.PublicKey) string {
 	return base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(keyHash[:8])
 }
 
-// RotateKeys creates new keys and deactivates old ones
+// RotateKeys rotates the platform keys using the default tenant's grace period
 func (s *CryptoKeyService) RotateKeys(ctx context.Context) error {
-	// Get current active keys
-	activeKeys, err := s.GetActiveKeys(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get active keys: %v", err)
+	_, err := s.RotateTenantKeys(ctx, "", 0)
+	return err
+}
+
+// RotateTenantKeys creates a new RSA and ECDSA key for the tenant and lets its current keys expire
+// after the grace period, so tokens signed with them can still be validated until then. A zero
+// grace period uses the tenant's rotation policy.
+func (s *CryptoKeyService) RotateTenantKeys(ctx context.Context, tenantID string, gracePeriod time.Duration) ([]models.CryptoKey, error) {
+	var keys []models.CryptoKey
+	err := s.withKeyLock(s.keyOwner(tenantID), func() error {
+		var err error
+		keys, err = s.rotateTenantKeys(ctx, tenantID, gracePeriod)
+		return err
+	})
+	return keys, err
+}
+
+func (s *CryptoKeyService) rotateTenantKeys(ctx context.Context, tenantID string, gracePeriod time.Duration) ([]models.CryptoKey, error) {
+	owner := s.keyOwner(tenantID)
+	if gracePeriod <= 0 {
+		gracePeriod = s.policyGracePeriod(tenantID)
 	}
 
-	// Create new keys
-	_, err = s.CreateRSAKey(ctx, 2048)
+	activeKeys, err := s.findActiveKeys(ctx, keyOwnerFilter(owner))
 	if err != nil {
-		return fmt.Errorf("failed to create new RSA key: %v", err)
+		return nil, fmt.Errorf("failed to get active keys: %v", err)
+	}
+	now := time.Now()
+	for _, key := range activeKeys {
+		if key.Status(now) == models.KeyStatusCanary {
+			return nil, ErrKeyCanaryRunning
+		}
 	}
 
-	_, err = s.CreateECDSAKey(ctx)
+	keys, err := s.createKeyPair(ctx, owner, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create new ECDSA key: %v", err)
+		return nil, err
 	}
 
-	// Deactivate old keys (with grace period - don't deactivate immediately)
-	// In production, you might want to set an expiry date instead
+	expiresAt := now.Add(gracePeriod)
 	for _, key := range activeKeys {
-		if key.KeyType == "rsa" || key.KeyType == "ecdsa" {
-			// Set expiry to 24 hours from now to allow existing tokens to validate
-			expiresAt := time.Now().Add(24 * time.Hour)
-			filter := bson.M{"key_id": key.KeyID}
-			update := bson.M{"$set": bson.M{"expires_at": expiresAt}}
-			
-			_, err := s.keyCollection.UpdateOne(ctx, filter, update)
+		// Published next keys that never became active are superseded by the new keys
+		if key.Status(now) == models.KeyStatusUpcoming {
+			if _, err := s.keyCollection.DeleteOne(ctx, bson.M{"_id": key.ID}); err != nil {
+				return nil, fmt.Errorf("failed to remove upcoming key %s: %v", key.KeyID, err)
+			}
+			continue
+		}
+		// Keys that already expire sooner, e.g. from an earlier rotation, keep their expiry
+		if key.ExpiresAt != nil && key.ExpiresAt.Before(expiresAt) {
+			continue
+		}
+		_, err := s.keyCollection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{
+			"$set": bson.M{"expires_at": expiresAt},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to set expiry for key %s: %v", key.KeyID, err)
+		}
+	}
+
+	return keys, nil
+}
+
+// createKeyPair creates an RSA and an ECDSA key for the owner, optionally published ahead of activation
+func (s *CryptoKeyService) createKeyPair(ctx context.Context, owner string, activatesAt *time.Time) ([]models.CryptoKey, error) {
+	rsaKey, err := s.createRSAKey(ctx, owner, 2048, activatesAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new RSA key: %v", err)
+	}
+
+	ecdsaKey, err := s.createECDSAKey(ctx, owner, activatesAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new ECDSA key: %v", err)
+	}
+
+	return []models.CryptoKey{*rsaKey, *ecdsaKey}, nil
+}
+
+// RevokeKey withdraws a compromised key immediately, without a grace period. If it was the
+// tenant's last active key of its type, a replacement is created so signing can continue.
+func (s *CryptoKeyService) RevokeKey(ctx context.Context, tenantID, keyID string) error {
+	owner := s.keyOwner(tenantID)
+	return s.withKeyLock(owner, func() error {
+		return s.revokeKey(ctx, owner, keyID)
+	})
+}
+
+func (s *CryptoKeyService) revokeKey(ctx context.Context, owner, keyID string) error {
+	filter := keyOwnerFilter(owner)
+	filter["key_id"] = keyID
+
+	var key models.CryptoKey
+	if err := s.keyCollection.FindOne(ctx, filter).Decode(&key); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+
+	now := time.Now()
+	_, err := s.keyCollection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{
+		"$set": bson.M{"active": false, "revoked_at": now},
+	})
+	if err != nil {
+		return err
+	}
+
+	activeKeys, err := s.findActiveKeys(ctx, keyOwnerFilter(owner))
+	if err != nil {
+		return err
+	}
+	for _, active := range activeKeys {
+		if active.KeyType == key.KeyType {
+			return nil
+		}
+	}
+
+	switch key.KeyType {
+	case "rsa":
+		_, err = s.createRSAKey(ctx, owner, 2048, nil)
+	case "ecdsa":
+		_, err = s.createECDSAKey(ctx, owner, nil)
+	}
+	return err
+}
+
+// RotateDueKeys advances the scheduled rotation of every tenant with a rotation policy: the next
+// keys are published ahead of activation so relying parties can refresh their caches, and once
+// they are active the previous keys expire after the grace period. The default tenant's policy
+// applies to the platform keys.
+func (s *CryptoKeyService) RotateDueKeys() error {
+	tenants, err := s.tenantService.GetAllTenants()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, tenant := range tenants {
+		policy := tenant.Settings.KeyRotation
+		if policy.IntervalDays <= 0 {
+			continue
+		}
+
+		tenantID := tenant.ID.Hex()
+		owner := tenantID
+		if tenant.IsDefault {
+			owner = ""
+		}
+
+		gracePeriod := time.Duration(policy.GracePeriodHours) * time.Hour
+		if gracePeriod <= 0 {
+			gracePeriod = defaultKeyGracePeriod
+		}
+
+		// The keys are planned inside the lock, so a step another instance just took isn't repeated
+		err := s.withKeyLock(owner, func() error {
+			keys, err := s.findActiveKeys(ctx, keyOwnerFilter(owner))
 			if err != nil {
-				return fmt.Errorf("failed to set expiry for key %s: %v", key.KeyID, err)
+				return err
 			}
+
+			now := time.Now()
+			action, activatesAt := planKeyRotation(keys, policy, now)
+			switch action {
+			case keyRotationRotate:
+				_, err = s.rotateTenantKeys(ctx, tenantID, gracePeriod)
+			case keyRotationPrepublish:
+				_, err = s.createKeyPair(ctx, owner, &activatesAt)
+			case keyRotationActivate:
+				err = s.expireReplacedKeys(ctx, keys, now, now.Add(gracePeriod))
+			}
+			return err
+		})
+		if err != nil && err != ErrKeyChangeInProgress {
+			log.Printf("Warning: Failed to rotate keys for tenant %s: %v", tenantID, err)
 		}
 	}
 
 	return nil
 }
 
-// CleanupExpiredKeys removes expired keys from the database
+// keyRotationAction is the next step of a scheduled key rotation
+type keyRotationAction int
+
+const (
+	keyRotationNone       keyRotationAction = iota
+	keyRotationPrepublish                   // publish the next keys ahead of activation
+	keyRotationActivate                     // the next keys became active; the keys they replace start expiring
+	keyRotationRotate                       // no keys or rotation overdue; rotate immediately
+)
+
+// planKeyRotation decides the next rotation step for an owner's active keys. For prepublication it
+// also returns when the next keys become active.
+func planKeyRotation(keys []models.CryptoKey, policy models.KeyRotationPolicy, now time.Time) (keyRotationAction, time.Time) {
+	if len(keys) == 0 {
+		return keyRotationRotate, time.Time{}
+	}
+
+	var current time.Time
+	activated := false
+	for _, key := range keys {
+		// Scheduled rotation waits for published next keys and for a canary to be promoted or rolled back
+		if status := key.Status(now); status == models.KeyStatusUpcoming || status == models.KeyStatusCanary {
+			return keyRotationNone, time.Time{}
+		}
+		// Rolled back canary keys stay published for a while but never became the current keys
+		if key.RolledBackAt != nil {
+			continue
+		}
+		if effective := keyEffectiveAt(key); effective.After(current) {
+			current = effective
+			activated = key.ActivatesAt != nil
+		}
+	}
+
+	if activated {
+		for _, key := range keys {
+			if key.ExpiresAt == nil && keyEffectiveAt(key).Before(current) {
+				return keyRotationActivate, time.Time{}
+			}
+		}
+	}
+
+	due := current.Add(time.Duration(policy.IntervalDays) * 24 * time.Hour)
+	if !now.Before(due) {
+		return keyRotationRotate, time.Time{}
+	}
+
+	prepublish := time.Duration(policy.PrepublishHours) * time.Hour
+	if prepublish <= 0 {
+		prepublish = defaultKeyPrepublishWindow
+	}
+	if !now.Before(due.Add(-prepublish)) {
+		return keyRotationPrepublish, due
+	}
+
+	return keyRotationNone, time.Time{}
+}
+
+// keyEffectiveAt returns when a key became (or becomes) active
+func keyEffectiveAt(key models.CryptoKey) time.Time {
+	if key.ActivatesAt != nil {
+		return *key.ActivatesAt
+	}
+	return key.CreatedAt
+}
+
+// expireReplacedKeys starts the grace period of keys replaced by the newest active keys
+func (s *CryptoKeyService) expireReplacedKeys(ctx context.Context, keys []models.CryptoKey, now, expiresAt time.Time) error {
+	var current time.Time
+	for _, key := range keys {
+		if effective := keyEffectiveAt(key); !effective.After(now) && effective.After(current) {
+			current = effective
+		}
+	}
+
+	for _, key := range keys {
+		if key.ExpiresAt != nil || !keyEffectiveAt(key).Before(current) {
+			continue
+		}
+		if _, err := s.keyCollection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{
+			"$set": bson.M{"expires_at": expiresAt},
+		}); err != nil {
+			return fmt.Errorf("failed to set expiry for key %s: %v", key.KeyID, err)
+		}
+	}
+	return nil
+}
+
+// NextKeyChange returns the earliest upcoming activation or expiry among published keys, or nil
+func NextKeyChange(keys []models.CryptoKey, now time.Time) *time.Time {
+	var next *time.Time
+	for i := range keys {
+		for _, change := range []*time.Time{keys[i].ActivatesAt, keys[i].ExpiresAt} {
+			if change != nil && change.After(now) && (next == nil || change.Before(*next)) {
+				next = change
+			}
+		}
+	}
+	return next
+}
+
+// policyGracePeriod returns the grace period of the tenant's rotation policy, 24 hours if unset
+func (s *CryptoKeyService) policyGracePeriod(tenantID string) time.Duration {
+	var tenant *models.Tenant
+	var err error
+	if tenantID != "" {
+		tenant, err = s.tenantService.GetTenantByID(tenantID)
+	} else {
+		tenant, err = s.tenantService.GetDefaultTenant()
+	}
+	if err == nil && tenant.Settings.KeyRotation.GracePeriodHours > 0 {
+		return time.Duration(tenant.Settings.KeyRotation.GracePeriodHours) * time.Hour
+	}
+	return defaultKeyGracePeriod
+}
+
+// withKeyLock runs fn holding the cluster lock of an owner's keys. It returns
+// ErrKeyChangeInProgress if another instance holds it.
+func (s *CryptoKeyService) withKeyLock(owner string, fn func() error) error {
+	name := "crypto_keys:platform"
+	if owner != "" {
+		name = "crypto_keys:" + owner
+	}
+	err := s.locks.WithLock(name, keyLockLease, fn)
+	if err == ErrClusterLockHeld {
+		return ErrKeyChangeInProgress
+	}
+	return err
+}
+
+// keyOwner maps a tenant to the owner of its keys: the default tenant uses the platform keys
+func (s *CryptoKeyService) keyOwner(tenantID string) string {
+	if tenantID == "" {
+		return ""
+	}
+	tenant, err := s.tenantService.GetTenantByID(tenantID)
+	if err == nil && tenant.IsDefault {
+		return ""
+	}
+	return tenantID
+}
+
+// keyOwnerFilter matches the keys of one owner; platform keys have no tenant_id
+func keyOwnerFilter(owner string) bson.M {
+	if owner == "" {
+		return bson.M{"tenant_id": bson.M{"$in": bson.A{nil, ""}}}
+	}
+	return bson.M{"tenant_id": owner}
+}
+
+// CleanupExpiredKeys removes keys whose grace period has ended. Revoked keys are kept as a record.
 func (s *CryptoKeyService) CleanupExpiredKeys(ctx context.Context) error {
 	filter := bson.M{
 		"expires_at": bson.M{"$lt": time.Now()},
-		"active":     false,
 	}
 
 	result, err := s.keyCollection.DeleteMany(ctx, filter)
@@ -335,4 +711,4 @@ func (s *CryptoKeyService) CleanupExpiredKeys(ctx context.Context) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestKeyOwnerFilter(t *testing.T) {
+	platform := keyOwnerFilter("")
+	if _, ok := platform["tenant_id"].(bson.M)["$in"]; !ok {
+		t.Errorf("Expected platform keys to match a missing or empty tenant_id, got %v", platform)
+	}
+
+	tenant := keyOwnerFilter("tenant-1")
+	if tenant["tenant_id"] != "tenant-1" {
+		t.Errorf("Expected tenant keys to match the tenant ID, got %v", tenant)
+	}
+}
+
+func TestCryptoKeyStatus(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		key  models.CryptoKey
+		want models.KeyStatus
+	}{
+		{"active", models.CryptoKey{Active: true}, models.KeyStatusActive},
+		{"in grace period", models.CryptoKey{Active: true, ExpiresAt: &future}, models.KeyStatusActive},
+		{"expired", models.CryptoKey{Active: true, ExpiresAt: &past}, models.KeyStatusExpired},
+		{"revoked", models.CryptoKey{Active: false, RevokedAt: &past}, models.KeyStatusInactive},
+		{"upcoming", models.CryptoKey{Active: true, ActivatesAt: &future}, models.KeyStatusUpcoming},
+		{"canary", models.CryptoKey{Active: true, CanaryPercent: 10}, models.KeyStatusCanary},
+	}
+
+	for _, tt := range tests {
+		if got := tt.key.Status(now); got != tt.want {
+			t.Errorf("%s: expected status %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestPlanKeyRotation(t *testing.T) {
+	now := time.Now()
+	policy := models.KeyRotationPolicy{IntervalDays: 30, PrepublishHours: 48}
+	daysAgo := func(days int) time.Time { return now.Add(-time.Duration(days) * 24 * time.Hour) }
+	at := func(t time.Time) *time.Time { return &t }
+
+	if action, _ := planKeyRotation(nil, policy, now); action != keyRotationRotate {
+		t.Errorf("Expected keys to be created when there are none, got %v", action)
+	}
+
+	fresh := []models.CryptoKey{{Active: true, CreatedAt: daysAgo(10)}}
+	if action, _ := planKeyRotation(fresh, policy, now); action != keyRotationNone {
+		t.Errorf("Expected no action for fresh keys, got %v", action)
+	}
+
+	nearlyDue := []models.CryptoKey{{Active: true, CreatedAt: daysAgo(29)}}
+	action, activatesAt := planKeyRotation(nearlyDue, policy, now)
+	if action != keyRotationPrepublish {
+		t.Fatalf("Expected the next keys to be published ahead of rotation, got %v", action)
+	}
+	if !activatesAt.Equal(daysAgo(29).Add(30 * 24 * time.Hour)) {
+		t.Errorf("Expected the next keys to activate when rotation is due, got %v", activatesAt)
+	}
+
+	published := append(nearlyDue, models.CryptoKey{Active: true, CreatedAt: now, ActivatesAt: at(now.Add(time.Hour))})
+	if action, _ := planKeyRotation(published, policy, now); action != keyRotationNone {
+		t.Errorf("Expected to wait for the upcoming keys, got %v", action)
+	}
+
+	activated := append(nearlyDue, models.CryptoKey{Active: true, CreatedAt: daysAgo(1), ActivatesAt: at(now.Add(-time.Hour))})
+	if action, _ := planKeyRotation(activated, policy, now); action != keyRotationActivate {
+		t.Errorf("Expected the replaced keys to start expiring, got %v", action)
+	}
+
+	overdue := []models.CryptoKey{{Active: true, CreatedAt: daysAgo(40)}}
+	if action, _ := planKeyRotation(overdue, policy, now); action != keyRotationRotate {
+		t.Errorf("Expected an overdue rotation to happen immediately, got %v", action)
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of default scope sets a tenant configures in settings.default_scopes
+const (
+	DefaultScopesRegistration = "registration"
+	DefaultScopesSocial       = "social"
+	DefaultScopesDirectLogin  = "direct_login"
+)
+
+// Platform default scope sets, used while a tenant configures none
+var (
+	defaultLoginScopes        = []string{"read", "openid", "profile", "email"}
+	defaultRegistrationScopes = []string{"read", "openid", "profile", "email", "read:profile", "write:profile"}
+	defaultSocialScopes       = defaultLoginScopes
+)
+
+// resolveDefaultScopes returns a copy of the tenant's default scopes of a kind, falling back to the
+// platform defaults. Social logins still honour the older provisioning.default_scopes setting.
+func resolveDefaultScopes(settings models.TenantSettings, kind string) []string {
+	var configured, platform []string
+	switch kind {
+	case DefaultScopesRegistration:
+		configured, platform = settings.DefaultScopes.Registration, defaultRegistrationScopes
+	case DefaultScopesSocial:
+		configured, platform = settings.DefaultScopes.Social, defaultSocialScopes
+		if len(configured) == 0 {
+			configured = settings.Provisioning.DefaultScopes
+		}
+	default:
+		configured, platform = settings.DefaultScopes.DirectLogin, defaultLoginScopes
+	}
+	if len(configured) == 0 {
+		configured = platform
+	}
+	return append([]string(nil), configured...)
+}
+
+// EffectiveDefaultScopes returns every default scope set of a tenant with the platform defaults
+// filled in
+func EffectiveDefaultScopes(settings models.TenantSettings) models.DefaultScopeSets {
+	return models.DefaultScopeSets{
+		Registration: resolveDefaultScopes(settings, DefaultScopesRegistration),
+		Social:       resolveDefaultScopes(settings, DefaultScopesSocial),
+		DirectLogin:  resolveDefaultScopes(settings, DefaultScopesDirectLogin),
+	}
+}
+
+// DefaultScopes returns the tenant's default scopes of a kind (DefaultScopesRegistration etc.).
+// Requests without a tenant use the default tenant; the platform defaults apply if it can't be loaded.
+func (s *TenantService) DefaultScopes(tenantID, kind string) []string {
+	var tenant *models.Tenant
+	var err error
+	if tenantID != "" {
+		tenant, err = s.GetTenantByID(tenantID)
+	} else {
+		tenant, err = s.GetDefaultTenant()
+	}
+	if err != nil {
+		return resolveDefaultScopes(models.TenantSettings{}, kind)
+	}
+	return resolveDefaultScopes(tenant.Settings, kind)
+}
+
+// UpdateDefaultScopes replaces the default scope sets of a tenant
+func (s *TenantService) UpdateDefaultScopes(tenantID string, sets models.DefaultScopeSets) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(tenantID)
+	if err != nil {
+		return errors.New("invalid tenant ID")
+	}
+
+	result, err := s.tenantCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{
+		"$set": bson.M{"settings.default_scopes": sets, "updated_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("tenant not found")
+	}
+	return nil
+}
+
+// DefaultScopes returns the tenant's default scopes of a kind (see TenantService.DefaultScopes)
+func (s *OAuthService) DefaultScopes(tenantID, kind string) []string {
+	return NewTenantService(s.db).DefaultScopes(tenantID, kind)
+}
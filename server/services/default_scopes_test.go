@@ -0,0 +1,40 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestResolveDefaultScopes(t *testing.T) {
+	if got := resolveDefaultScopes(models.TenantSettings{}, DefaultScopesRegistration); !reflect.DeepEqual(got, defaultRegistrationScopes) {
+		t.Errorf("registration defaults = %v, want %v", got, defaultRegistrationScopes)
+	}
+	if got := resolveDefaultScopes(models.TenantSettings{}, DefaultScopesDirectLogin); !reflect.DeepEqual(got, defaultLoginScopes) {
+		t.Errorf("direct login defaults = %v, want %v", got, defaultLoginScopes)
+	}
+
+	settings := models.TenantSettings{
+		Provisioning:  models.ProvisioningPolicy{DefaultScopes: []string{"openid", "legacy"}},
+		DefaultScopes: models.DefaultScopeSets{DirectLogin: []string{"openid"}},
+	}
+	if got := resolveDefaultScopes(settings, DefaultScopesSocial); !reflect.DeepEqual(got, []string{"openid", "legacy"}) {
+		t.Errorf("social scopes = %v, want the provisioning defaults", got)
+	}
+	if got := resolveDefaultScopes(settings, DefaultScopesDirectLogin); !reflect.DeepEqual(got, []string{"openid"}) {
+		t.Errorf("direct login scopes = %v, want [openid]", got)
+	}
+
+	settings.DefaultScopes.Social = []string{"openid", "email"}
+	got := resolveDefaultScopes(settings, DefaultScopesSocial)
+	if !reflect.DeepEqual(got, []string{"openid", "email"}) {
+		t.Errorf("social scopes = %v, want the default_scopes.social set", got)
+	}
+
+	// Callers may extend the result without changing the settings or the platform defaults
+	_ = append(got[:1], "changed")
+	if settings.DefaultScopes.Social[1] != "email" {
+		t.Error("resolveDefaultScopes() returned the configured slice instead of a copy")
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+// ElevatedTokenLifetime is how long an elevated token stays valid
+const ElevatedTokenLifetime = 15 * time.Minute
+
+// ErrElevationNotAllowed is returned when a user without the elevated scope asks for an elevated token
+var ErrElevationNotAllowed = errors.New("user is not allowed to elevate")
+
+// ElevatedToken is a short-lived access token carrying ElevatedScope. It has no refresh token:
+// once it expires the user has to elevate again.
+type ElevatedToken struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int       `json:"expires_in"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Scope       string    `json:"scope"`
+}
+
+// CanElevate reports whether the user holds the elevated scope, directly or through a group
+func (s *OAuthService) CanElevate(user *models.User) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	elevated, err := holdsScope(ctx, s.db.GetCollection("groups"), user, ElevatedScope)
+	return err == nil && elevated
+}
+
+// IssueElevatedToken issues an elevated token with the scopes of the user's current token plus
+// ElevatedScope. The caller must have re-authenticated the user.
+func (s *OAuthService) IssueElevatedToken(user *models.User, tenantID, clientID string, scopes []string, r *http.Request) (*ElevatedToken, error) {
+	if !s.CanElevate(user) {
+		return nil, ErrElevationNotAllowed
+	}
+
+	if !containsString(scopes, ElevatedScope) {
+		scopes = append(append([]string{}, scopes...), ElevatedScope)
+	}
+
+	accessToken, err := s.signAccessToken(user.ID.Hex(), tenantID, clientID, s.getBaseURL(r), scopes, ElevatedTokenLifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ElevatedToken{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(ElevatedTokenLifetime.Seconds()),
+		ExpiresAt:   time.Now().Add(ElevatedTokenLifetime),
+		Scope:       s.joinScopes(scopes),
+	}, nil
+}
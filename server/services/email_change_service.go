@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// emailChangeLifetime is how long the verification links of an email change stay valid
+const emailChangeLifetime = 24 * time.Hour
+
+var (
+	ErrEmailInUse          = errors.New("email address is already in use")
+	ErrEmailUnchanged      = errors.New("new email address matches the current one")
+	ErrEmailChangeNotFound = errors.New("email change request not found")
+	ErrEmailChangeExpired  = errors.New("email change request has expired")
+)
+
+// EmailChangeService changes user email addresses in two steps: the new address is verified
+// through a signed link (and optionally confirmed from the old address) before it is applied,
+// after which all of the user's sessions are revoked.
+type EmailChangeService struct {
+	db             *database.MongoDB
+	collection     *mongo.Collection
+	userCollection *mongo.Collection
+	tenantService  *TenantService
+	sessionService *SessionService
+	notifier       Notifier
+	webBaseURL     string
+}
+
+func NewEmailChangeService(db *database.MongoDB, notifier Notifier, webBaseURL string) *EmailChangeService {
+	return &EmailChangeService{
+		db:             db,
+		collection:     db.GetCollection("email_change_requests"),
+		userCollection: db.GetCollection("users"),
+		tenantService:  NewTenantService(db),
+		sessionService: NewSessionService(db),
+		notifier:       notifier,
+		webBaseURL:     webBaseURL,
+	}
+}
+
+// EnsureIndexes creates the indexes used to look up change requests by link token
+func (s *EmailChangeService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "old_email_token_hash", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}}},
+	})
+	return err
+}
+
+// RequestEmailChange starts changing the user's email address to newEmail. Earlier pending
+// changes of the user are cancelled. The current address stays in effect until the change is
+// verified.
+func (s *EmailChangeService) RequestEmailChange(userID, tenantID, newEmail string) (*models.EmailChangeRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	var user models.User
+	if err := s.userCollection.FindOne(ctx, bson.M{"_id": objID, "tenant_id": tenantID}).Decode(&user); err != nil {
+		return nil, errors.New("user not found")
+	}
+	if newEmail == user.Email {
+		return nil, ErrEmailUnchanged
+	}
+	if err := s.checkEmailAvailable(ctx, objID, tenantID, newEmail); err != nil {
+		return nil, err
+	}
+
+	token, err := generateEmailChangeToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	request := &models.EmailChangeRequest{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantID,
+		UserID:    userID,
+		OldEmail:  user.Email,
+		NewEmail:  newEmail,
+		TokenHash: hashEmailChangeToken(token),
+		ExpiresAt: now.Add(emailChangeLifetime),
+		CreatedAt: now,
+	}
+
+	var oldEmailToken string
+	if s.requiresOldEmailConfirmation(tenantID) {
+		if oldEmailToken, err = generateEmailChangeToken(); err != nil {
+			return nil, err
+		}
+		request.OldEmailTokenHash = hashEmailChangeToken(oldEmailToken)
+	}
+
+	if _, err := s.collection.DeleteMany(ctx, bson.M{
+		"tenant_id":    tenantID,
+		"user_id":      userID,
+		"completed_at": bson.M{"$exists": false},
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := s.collection.InsertOne(ctx, request); err != nil {
+		return nil, err
+	}
+	if _, err := s.userCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"pending_email": newEmail, "updated_at": now},
+	}); err != nil {
+		return nil, err
+	}
+
+	s.notify(request, "email_change_verify", request.NewEmail, "Verify your new email address",
+		"Confirm that "+request.NewEmail+" should become the email address of your account: "+s.verificationURL(token)+
+			"\nThe link expires on "+request.ExpiresAt.Format(time.RFC1123)+".")
+
+	message := "A change of your account's email address to " + request.NewEmail + " was requested."
+	if oldEmailToken != "" {
+		message += " Confirm the change here: " + s.verificationURL(oldEmailToken) + "\nIf you did not request it, ignore this message and the change will not happen."
+	} else {
+		message += " If you did not request it, contact your administrator."
+	}
+	s.notify(request, "email_change_requested", request.OldEmail, "Your email address is being changed", message)
+
+	return request, nil
+}
+
+// VerifyEmailChange records the confirmation carried by a verification link token. Once every
+// required confirmation is in, the new address is applied and all of the user's tokens are
+// revoked. The returned request has CompletedAt set if the change took effect.
+func (s *EmailChangeService) VerifyEmailChange(token string) (*models.EmailChangeRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hash := hashEmailChangeToken(token)
+	var request models.EmailChangeRequest
+	err := s.collection.FindOne(ctx, bson.M{
+		"$or":          []bson.M{{"token_hash": hash}, {"old_email_token_hash": hash}},
+		"completed_at": bson.M{"$exists": false},
+	}).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrEmailChangeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(request.ExpiresAt) {
+		return nil, ErrEmailChangeExpired
+	}
+
+	field := "new_email_verified_at"
+	if hash == request.OldEmailTokenHash {
+		field = "old_email_confirmed_at"
+	}
+	err = s.collection.FindOneAndUpdate(ctx, bson.M{"_id": request.ID}, bson.M{
+		"$set": bson.M{field: now},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	if !request.Ready() {
+		return &request, nil
+	}
+	if err := s.complete(ctx, &request, now); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// complete applies a fully confirmed email change and signs the user out everywhere
+func (s *EmailChangeService) complete(ctx context.Context, request *models.EmailChangeRequest, now time.Time) error {
+	objID, err := primitive.ObjectIDFromHex(request.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkEmailAvailable(ctx, objID, request.TenantID, request.NewEmail); err != nil {
+		return err
+	}
+
+	// Only one confirmation completes the request, even if both arrive at the same time
+	result, err := s.collection.UpdateOne(ctx, bson.M{
+		"_id":          request.ID,
+		"completed_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"completed_at": now}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrEmailChangeNotFound
+	}
+	request.CompletedAt = &now
+
+	if _, err := s.userCollection.UpdateOne(ctx, bson.M{"_id": objID, "tenant_id": request.TenantID}, bson.M{
+		"$set":   bson.M{"email": request.NewEmail, "updated_at": now},
+		"$unset": bson.M{"pending_email": ""},
+		"$inc":   bson.M{"version": 1},
+	}); err != nil {
+		return err
+	}
+	if err := refreshSearchKeys(ctx, s.userCollection, bson.M{"_id": objID}); err != nil {
+		log.Printf("Warning: Failed to update the search keys of user %s: %v", request.UserID, err)
+	}
+
+	if err := s.sessionService.RevokeAllUserSessions(request.UserID, request.TenantID); err != nil {
+		return err
+	}
+
+	s.notify(request, "email_changed", request.OldEmail, "Your email address was changed",
+		"The email address of your account was changed to "+request.NewEmail+". All sessions have been signed out.")
+	return nil
+}
+
+// checkEmailAvailable fails with ErrEmailInUse if another user of the tenant has the address
+func (s *EmailChangeService) checkEmailAvailable(ctx context.Context, userID primitive.ObjectID, tenantID, email string) error {
+	count, err := s.userCollection.CountDocuments(ctx, bson.M{
+		"tenant_id": tenantID,
+		"email":     email,
+		"_id":       bson.M{"$ne": userID},
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailInUse
+	}
+	return nil
+}
+
+func (s *EmailChangeService) requiresOldEmailConfirmation(tenantID string) bool {
+	tenant, err := s.tenantService.GetTenantByID(tenantID)
+	if err != nil {
+		return false
+	}
+	return tenant.Settings.ConfirmEmailChange
+}
+
+func (s *EmailChangeService) verificationURL(token string) string {
+	return s.webBaseURL + "/email-change/verify?token=" + url.QueryEscape(token)
+}
+
+func (s *EmailChangeService) notify(request *models.EmailChangeRequest, notificationType, recipient, subject, message string) {
+	if s.notifier == nil || recipient == "" {
+		return
+	}
+	notification := &Notification{
+		Type:      notificationType,
+		TenantID:  request.TenantID,
+		Recipient: recipient,
+		Subject:   subject,
+		Message:   message,
+		Data: map[string]interface{}{
+			"user_id":   request.UserID,
+			"new_email": request.NewEmail,
+		},
+		CreatedAt: time.Now(),
+	}
+	if err := s.notifier.Notify(notification); err != nil {
+		log.Printf("Warning: Failed to send %s notification for user %s: %v", notificationType, request.UserID, err)
+	}
+}
+
+func generateEmailChangeToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// hashEmailChangeToken derives the stored form of a link token
+func hashEmailChangeToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+func TestEmailChangeReadyRequiresNewAddressVerification(t *testing.T) {
+	now := time.Now()
+	request := &models.EmailChangeRequest{TokenHash: hashEmailChangeToken("new")}
+
+	if request.Ready() {
+		t.Error("Expected an unverified change not to be ready")
+	}
+
+	request.NewEmailVerifiedAt = &now
+	if !request.Ready() {
+		t.Error("Expected a verified change to be ready when the old address need not confirm")
+	}
+}
+
+func TestEmailChangeReadyRequiresOldAddressConfirmation(t *testing.T) {
+	now := time.Now()
+	request := &models.EmailChangeRequest{
+		TokenHash:          hashEmailChangeToken("new"),
+		OldEmailTokenHash:  hashEmailChangeToken("old"),
+		NewEmailVerifiedAt: &now,
+	}
+
+	if request.Ready() {
+		t.Error("Expected the change to wait for the old address")
+	}
+
+	request.OldEmailConfirmedAt = &now
+	if !request.Ready() {
+		t.Error("Expected the change to be ready once both addresses confirmed")
+	}
+}
+
+func TestHashEmailChangeToken(t *testing.T) {
+	if hashEmailChangeToken("a") == hashEmailChangeToken("b") {
+		t.Error("Expected different tokens to have different hashes")
+	}
+	if hashEmailChangeToken("a") == "a" {
+		t.Error("Expected the token not to be stored in plain text")
+	}
+}
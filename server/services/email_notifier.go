@@ -0,0 +1,54 @@
+package services
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// EmailNotifier delivers notifications by email over SMTP
+type EmailNotifier struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// NewEmailNotifier creates a notifier that sends mail through the given SMTP server
+func NewEmailNotifier(host, port, username, password, from string) *EmailNotifier {
+	return &EmailNotifier{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Notify emails the notification to its recipient. Notifications without an email recipient are skipped.
+func (n *EmailNotifier) Notify(notification *Notification) error {
+	if !strings.Contains(notification.Recipient, "@") {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	subject := notification.Subject
+	if subject == "" {
+		subject = notification.Type
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		n.from, sanitizeHeader(notification.Recipient), sanitizeHeader(subject), notification.Message)
+
+	return smtp.SendMail(n.host+":"+n.port, auth, n.from, []string{notification.Recipient}, []byte(message))
+}
+
+// sanitizeHeader strips line breaks so values cannot inject extra mail headers
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
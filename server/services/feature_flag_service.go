@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Feature flags of the auth capabilities that are rolled out gradually
+const (
+	FeatureCIBA       = "ciba"        // client-initiated backchannel authentication
+	FeatureDeviceCode = "device_code" // RFC 8628 device authorization grant
+	FeatureDPoP       = "dpop"        // RFC 9449 proof-of-possession tokens
+)
+
+// featureDefaults are the known flags and their state when nothing was configured. Capabilities
+// that already shipped default to on.
+var featureDefaults = map[string]bool{
+	FeatureCIBA:       true,
+	FeatureDeviceCode: false,
+	FeatureDPoP:       false,
+}
+
+// featureFlagCacheTTL is how long flag changes made on another instance take to apply
+const featureFlagCacheTTL = 30 * time.Second
+
+var (
+	ErrUnknownFeatureFlag  = errors.New("unknown feature flag")
+	ErrFeatureFlagNotFound = errors.New("feature flag override not found")
+)
+
+// Sources of a flag's state
+const (
+	FeatureSourceTenant     = "tenant"
+	FeatureSourceDeployment = "deployment"
+	FeatureSourceDefault    = "default"
+)
+
+// FeatureFlagState is the state of a flag for a tenant and the setting it comes from
+type FeatureFlagState struct {
+	Name              string `json:"name"`
+	Enabled           bool   `json:"enabled"`
+	Source            string `json:"source"`
+	RolloutPercentage int    `json:"rollout_percentage,omitempty"`
+}
+
+// FeatureFlagService stores the deployment-wide and per-tenant feature flags. Flags are read on
+// every token and discovery request, so they are cached and reloaded every featureFlagCacheTTL.
+type FeatureFlagService struct {
+	db         *database.MongoDB
+	collection *mongo.Collection
+
+	mu       sync.Mutex
+	flags    map[string]*models.FeatureFlag // by scope and name, see flagKey
+	loadedAt time.Time
+}
+
+func NewFeatureFlagService(db *database.MongoDB) *FeatureFlagService {
+	return &FeatureFlagService{
+		db:         db,
+		collection: db.GetCollection("feature_flags"),
+	}
+}
+
+// EnsureIndexes allows one flag per name and scope
+func (s *FeatureFlagService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "scope", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// FeatureDefault reports whether a flag is on when it isn't configured
+func FeatureDefault(name string) bool {
+	return featureDefaults[name]
+}
+
+// FeatureNames lists the known flags
+func FeatureNames() []string {
+	names := make([]string, 0, len(featureDefaults))
+	for name := range featureDefaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enabled reports whether a flag is on for a tenant
+func (s *FeatureFlagService) Enabled(name, tenantID string) bool {
+	return s.State(name, tenantID).Enabled
+}
+
+// State resolves a flag for a tenant: the tenant's override wins, then the deployment flag with its
+// rollout percentage, then the default. Lookup failures fall back to the last loaded flags.
+func (s *FeatureFlagService) State(name, tenantID string) FeatureFlagState {
+	flags := s.cached()
+	var tenantFlag *models.FeatureFlag
+	if tenantID != "" {
+		tenantFlag = flags[flagKey(tenantID, name)]
+	}
+	return resolveFeatureFlag(name, tenantID, flags[flagKey(models.FeatureFlagScopeDeployment, name)], tenantFlag)
+}
+
+// States resolves every known flag for a tenant
+func (s *FeatureFlagService) States(tenantID string) []FeatureFlagState {
+	names := FeatureNames()
+	states := make([]FeatureFlagState, 0, len(names))
+	for _, name := range names {
+		states = append(states, s.State(name, tenantID))
+	}
+	return states
+}
+
+// List returns the stored flags of a scope (FeatureFlagScopeDeployment or a tenant ID)
+func (s *FeatureFlagService) List(scope string) ([]*models.FeatureFlag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{"scope": scope}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	flags := []*models.FeatureFlag{}
+	if err := cursor.All(ctx, &flags); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// Set stores a flag. Deployment flags roll out to RolloutPercentage of the tenants; tenant
+// overrides apply in full.
+func (s *FeatureFlagService) Set(flag *models.FeatureFlag) error {
+	if _, ok := featureDefaults[flag.Name]; !ok {
+		return ErrUnknownFeatureFlag
+	}
+	if flag.Scope != models.FeatureFlagScopeDeployment || flag.RolloutPercentage > 100 {
+		flag.RolloutPercentage = 100
+	}
+	if flag.RolloutPercentage < 0 {
+		flag.RolloutPercentage = 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	flag.UpdatedAt = time.Now()
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"scope": flag.Scope, "name": flag.Name}, flag, options.Replace().SetUpsert(true))
+	if err == nil {
+		s.invalidate()
+	}
+	return err
+}
+
+// Delete removes a flag, so the scope falls back to the deployment flag or the default
+func (s *FeatureFlagService) Delete(scope, name string) error {
+	if _, ok := featureDefaults[name]; !ok {
+		return ErrUnknownFeatureFlag
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.collection.DeleteOne(ctx, bson.M{"scope": scope, "name": name})
+	if err != nil {
+		return err
+	}
+	s.invalidate()
+	if result.DeletedCount == 0 {
+		return ErrFeatureFlagNotFound
+	}
+	return nil
+}
+
+// cached returns the flags, reloading them once they are older than featureFlagCacheTTL
+func (s *FeatureFlagService) cached() map[string]*models.FeatureFlag {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.flags != nil && time.Since(s.loadedAt) < featureFlagCacheTTL {
+		return s.flags
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var flags []*models.FeatureFlag
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err == nil {
+		err = cursor.All(ctx, &flags)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to load feature flags: %v", err)
+		// Retry on the next cache period rather than on every request
+		if s.flags == nil {
+			s.flags = map[string]*models.FeatureFlag{}
+		}
+		s.loadedAt = time.Now()
+		return s.flags
+	}
+
+	s.flags = make(map[string]*models.FeatureFlag, len(flags))
+	for _, flag := range flags {
+		s.flags[flagKey(flag.Scope, flag.Name)] = flag
+	}
+	s.loadedAt = time.Now()
+	return s.flags
+}
+
+// invalidate makes the next lookup reload the flags
+func (s *FeatureFlagService) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags = nil
+}
+
+func flagKey(scope, name string) string {
+	return scope + "/" + name
+}
+
+// resolveFeatureFlag applies the tenant override, then the deployment flag, then the default
+func resolveFeatureFlag(name, tenantID string, deployment, tenant *models.FeatureFlag) FeatureFlagState {
+	if tenant != nil {
+		return FeatureFlagState{Name: name, Enabled: tenant.Enabled, Source: FeatureSourceTenant}
+	}
+	if deployment != nil {
+		enabled := deployment.Enabled && rolloutBucket(name, tenantID) < deployment.RolloutPercentage
+		return FeatureFlagState{Name: name, Enabled: enabled, Source: FeatureSourceDeployment, RolloutPercentage: deployment.RolloutPercentage}
+	}
+	return FeatureFlagState{Name: name, Enabled: featureDefaults[name], Source: FeatureSourceDefault}
+}
+
+// rolloutBucket places a tenant in one of 100 buckets per flag, so raising a rollout percentage
+// only adds tenants and different flags reach different tenants first
+func rolloutBucket(name, tenantID string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(name + "/" + tenantID))
+	return int(hash.Sum32() % 100)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+)
+
+func TestResolveFeatureFlag(t *testing.T) {
+	on := &models.FeatureFlag{Enabled: true, RolloutPercentage: 100}
+	off := &models.FeatureFlag{Enabled: false, RolloutPercentage: 100}
+	none := &models.FeatureFlag{Enabled: true, RolloutPercentage: 0}
+
+	tests := []struct {
+		name       string
+		flag       string
+		deployment *models.FeatureFlag
+		tenant     *models.FeatureFlag
+		want       bool
+		source     string
+	}{
+		{"default on", FeatureCIBA, nil, nil, true, FeatureSourceDefault},
+		{"default off", FeatureDPoP, nil, nil, false, FeatureSourceDefault},
+		{"deployment on", FeatureDPoP, on, nil, true, FeatureSourceDeployment},
+		{"deployment off", FeatureCIBA, off, nil, false, FeatureSourceDeployment},
+		{"no rollout", FeatureDPoP, none, nil, false, FeatureSourceDeployment},
+		{"tenant override", FeatureDPoP, off, on, true, FeatureSourceTenant},
+		{"tenant opt-out", FeatureCIBA, on, off, false, FeatureSourceTenant},
+	}
+	for _, tt := range tests {
+		state := resolveFeatureFlag(tt.flag, "tenant-1", tt.deployment, tt.tenant)
+		if state.Enabled != tt.want || state.Source != tt.source {
+			t.Errorf("%s: resolveFeatureFlag() = %+v, want enabled %v from %s", tt.name, state, tt.want, tt.source)
+		}
+	}
+}
+
+// TestRolloutBucket only adds tenants as the rollout percentage grows
+func TestRolloutBucket(t *testing.T) {
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		tenantID := fmt.Sprintf("tenant-%d", i)
+		bucket := rolloutBucket(FeatureDPoP, tenantID)
+		if bucket != rolloutBucket(FeatureDPoP, tenantID) {
+			t.Fatalf("rolloutBucket(%s) is not stable", tenantID)
+		}
+		quarter := resolveFeatureFlag(FeatureDPoP, tenantID, &models.FeatureFlag{Enabled: true, RolloutPercentage: 25}, nil)
+		half := resolveFeatureFlag(FeatureDPoP, tenantID, &models.FeatureFlag{Enabled: true, RolloutPercentage: 50}, nil)
+		if quarter.Enabled && !half.Enabled {
+			t.Errorf("tenant %s lost the flag when the rollout grew", tenantID)
+		}
+		if quarter.Enabled {
+			enabled++
+		}
+	}
+	if enabled < 150 || enabled > 350 {
+		t.Errorf("25%% rollout enabled the flag for %d of 1000 tenants", enabled)
+	}
+}
+
+func TestFeatureFlagService(t *testing.T) {
+	db := dbtest.New(t)
+	service := NewFeatureFlagService(db)
+	if err := service.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+
+	if err := service.Set(&models.FeatureFlag{Name: "unknown", Scope: models.FeatureFlagScopeDeployment}); !errors.Is(err, ErrUnknownFeatureFlag) {
+		t.Errorf("Set() of an unknown flag error = %v, want ErrUnknownFeatureFlag", err)
+	}
+	if service.Enabled(FeatureDPoP, "t1") {
+		t.Error("Enabled() of an unconfigured flag = true, want its default")
+	}
+
+	// Writes take effect right away on this instance
+	if err := service.Set(&models.FeatureFlag{Name: FeatureDPoP, Scope: models.FeatureFlagScopeDeployment, Enabled: true, RolloutPercentage: 100}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := service.Set(&models.FeatureFlag{Name: FeatureDPoP, Scope: "t2", Enabled: false}); err != nil {
+		t.Fatalf("Set() override error = %v", err)
+	}
+	if !service.Enabled(FeatureDPoP, "t1") || service.Enabled(FeatureDPoP, "t2") {
+		t.Error("Enabled() should follow the deployment flag unless the tenant overrides it")
+	}
+
+	if err := service.Delete("t2", FeatureDPoP); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if !service.Enabled(FeatureDPoP, "t2") {
+		t.Error("Enabled() after removing the override should follow the deployment flag")
+	}
+	if err := service.Delete("t2", FeatureDPoP); !errors.Is(err, ErrFeatureFlagNotFound) {
+		t.Errorf("Delete() of a missing override error = %v, want ErrFeatureFlagNotFound", err)
+	}
+}
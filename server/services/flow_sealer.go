@@ -0,0 +1,86 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// flowBlobVersion is the first byte of every sealed blob, so the format can change later
+const flowBlobVersion = 1
+
+var ErrInvalidFlowBlob = errors.New("invalid or tampered flow state")
+
+// FlowSealer encrypts (AES-256-CTR) and signs (HMAC-SHA256) flow state so it can travel with
+// the browser instead of being stored server-side. Every replica configured with the same secret
+// opens the blobs sealed by the others, so no shared store or sticky sessions are needed.
+type FlowSealer struct {
+	encryptionKey []byte
+	signingKey    []byte
+}
+
+// NewFlowSealer derives separate encryption and signing keys from the secret
+func NewFlowSealer(secret string) *FlowSealer {
+	return &FlowSealer{
+		encryptionKey: deriveFlowKey(secret, "flow-state-encryption"),
+		signingKey:    deriveFlowKey(secret, "flow-state-signing"),
+	}
+}
+
+func deriveFlowKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Seal returns the payload encrypted and signed as URL-safe base64 (no padding), which fits in
+// query parameters, path segments and cookies
+func (s *FlowSealer) Seal(payload []byte) (string, error) {
+	block, err := aes.NewCipher(s.encryptionKey)
+	if err != nil {
+		return "", err
+	}
+
+	// version | iv | ciphertext | mac
+	blob := make([]byte, 1+aes.BlockSize+len(payload), 1+aes.BlockSize+len(payload)+sha256.Size)
+	blob[0] = flowBlobVersion
+	iv := blob[1 : 1+aes.BlockSize]
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	cipher.NewCTR(block, iv).XORKeyStream(blob[1+aes.BlockSize:], payload)
+
+	blob = append(blob, s.sign(blob)...)
+	return base64.RawURLEncoding.EncodeToString(blob), nil
+}
+
+// Open verifies the signature of a sealed blob and returns its payload
+func (s *FlowSealer) Open(sealed string) ([]byte, error) {
+	blob, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(blob) < 1+aes.BlockSize+sha256.Size || blob[0] != flowBlobVersion {
+		return nil, ErrInvalidFlowBlob
+	}
+
+	signed, signature := blob[:len(blob)-sha256.Size], blob[len(blob)-sha256.Size:]
+	if !hmac.Equal(signature, s.sign(signed)) {
+		return nil, ErrInvalidFlowBlob
+	}
+
+	block, err := aes.NewCipher(s.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, len(signed)-1-aes.BlockSize)
+	cipher.NewCTR(block, signed[1:1+aes.BlockSize]).XORKeyStream(payload, signed[1+aes.BlockSize:])
+	return payload, nil
+}
+
+func (s *FlowSealer) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+func TestFlowSealerRoundTrip(t *testing.T) {
+	sealer := NewFlowSealer("replica-secret")
+
+	sealed, err := sealer.Seal([]byte("flow state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "flow state") || strings.ContainsAny(sealed, "+/=") {
+		t.Errorf("Expected an encrypted URL-safe blob, got %q", sealed)
+	}
+
+	// Another replica with the same secret opens it
+	payload, err := NewFlowSealer("replica-secret").Open(sealed)
+	if err != nil || !bytes.Equal(payload, []byte("flow state")) {
+		t.Errorf("Open() = %q, %v", payload, err)
+	}
+
+	if _, err := NewFlowSealer("other-secret").Open(sealed); !errors.Is(err, ErrInvalidFlowBlob) {
+		t.Errorf("Expected a blob of another secret to be rejected, got %v", err)
+	}
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)/2] ^= 'A' ^ 'B'
+	if _, err := sealer.Open(string(tampered)); !errors.Is(err, ErrInvalidFlowBlob) {
+		t.Errorf("Expected a tampered blob to be rejected, got %v", err)
+	}
+
+	if _, err := sealer.Open("sealed"); !errors.Is(err, ErrInvalidFlowBlob) {
+		t.Errorf("Expected a truncated blob to be rejected, got %v", err)
+	}
+}
+
+func TestSealedSocialLoginState(t *testing.T) {
+	states := &SocialLoginStateService{sealer: NewFlowSealer("replica-secret")}
+
+	params := map[string]string{"client_id": "spa"}
+	state, err := states.Issue(SharedProviderState("tenant-1", "random"), "google", "tenant-1", params, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenantID, ok := TenantFromSharedState(state); !ok || tenantID != "tenant-1" {
+		t.Errorf("Expected the shared provider prefix to be kept, got %q", state)
+	}
+
+	if _, err := states.Consume(state, "github"); !errors.Is(err, ErrSocialStateNotFound) {
+		t.Errorf("Consume() for another provider error = %v, want ErrSocialStateNotFound", err)
+	}
+	stored, err := states.Consume(state, "google")
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if stored.TenantID != "tenant-1" || stored.Params["client_id"] != "spa" {
+		t.Errorf("Consume() = %+v, want the issued tenant and params", stored)
+	}
+
+	expired, err := states.Issue("random", "google", "tenant-1", nil, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := states.Consume(expired, "google"); !errors.Is(err, ErrSocialStateNotFound) {
+		t.Errorf("expired Consume() error = %v, want ErrSocialStateNotFound", err)
+	}
+}
+
+func TestSealedAuthorizeFlow(t *testing.T) {
+	flows := &AuthorizeFlowService{sealer: NewFlowSealer("replica-secret")}
+
+	flow := &models.AuthorizeFlow{
+		TenantID:  "tenant-1",
+		FlowID:    "unsealed",
+		CSRFToken: "csrf",
+		Step:      models.AuthorizeFlowStepConsent,
+		UserID:    "user-1",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	if err := flows.seal(flow); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(flow.FlowID, sealedFlowPrefix) {
+		t.Fatalf("Expected a sealed flow ID, got %q", flow.FlowID)
+	}
+
+	opened, err := flows.GetFlow(flow.FlowID, "tenant-1")
+	if err != nil {
+		t.Fatalf("GetFlow() error = %v", err)
+	}
+	if opened.FlowID != flow.FlowID || opened.CSRFToken != "csrf" || opened.UserID != "user-1" || opened.Step != models.AuthorizeFlowStepConsent {
+		t.Errorf("GetFlow() = %+v, want the sealed flow", opened)
+	}
+
+	if _, err := flows.GetFlow(flow.FlowID, "tenant-2"); !errors.Is(err, ErrFlowNotFound) {
+		t.Errorf("Expected another tenant's flow to be rejected, got %v", err)
+	}
+
+	flow.ExpiresAt = time.Now().Add(-time.Second)
+	if err := flows.seal(flow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := flows.GetFlow(flow.FlowID, "tenant-1"); !errors.Is(err, ErrFlowExpired) {
+		t.Errorf("Expected an expired flow, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// privilegedScopes are the scopes of tenant-wide user administrators, elevated administrators,
+// support staff and the platform operator. Group managers can't manage groups granting them, nor
+// users holding them directly or through a group.
+var privilegedScopes = []string{AdminScope, UserManagementScope, ElevatedScope, SupportScope, PlatformOperatorScope}
+
+// holdsScope reports whether the user holds one of the scopes, directly or through a group they
+// are a member of
+func holdsScope(ctx context.Context, groups *mongo.Collection, user *models.User, scopes ...string) (bool, error) {
+	for _, scope := range scopes {
+		if containsString(user.Scopes, scope) {
+			return true, nil
+		}
+	}
+
+	groupIDs := objectIDs(user.Groups)
+	if len(groupIDs) == 0 {
+		return false, nil
+	}
+	count, err := groups.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": groupIDs}, "scopes": bson.M{"$in": scopes}})
+	return count > 0, err
+}
+
+// managedGroupsFilter matches the tenant's groups the user manages
+func managedGroupsFilter(tenantID, managerID string) bson.M {
+	return bson.M{
+		"tenant_id": tenantID,
+		"managers":  managerID,
+		"scopes":    bson.M{"$nin": privilegedScopes},
+	}
+}
+
+// ManagesGroup reports whether the user is a manager of the group, and may add and remove its
+// members
+func (s *MembershipService) ManagesGroup(tenantID, groupID, userID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil || tenantID == "" || userID == "" {
+		return false
+	}
+
+	filter := managedGroupsFilter(tenantID, userID)
+	filter["_id"] = objID
+	count, err := s.groups.CountDocuments(ctx, filter)
+	return err == nil && count > 0
+}
+
+// ManagesUser reports whether the manager manages a group the user is a member of, and may reset
+// the user's password or add the user to another group they manage. Privileged users, including
+// those holding the rights through a group, are never managed.
+func (s *MembershipService) ManagesUser(tenantID, managerID, userID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil || tenantID == "" || managerID == "" {
+		return false
+	}
+
+	var user models.User
+	if err := s.users.FindOne(ctx, bson.M{"_id": objID, "tenant_id": tenantID},
+		options.FindOne().SetProjection(bson.M{"groups": 1, "scopes": 1})).Decode(&user); err != nil {
+		return false
+	}
+	if privileged, err := holdsScope(ctx, s.groups, &user, privilegedScopes...); err != nil || privileged {
+		return false
+	}
+
+	groupIDs := objectIDs(user.Groups)
+	if len(groupIDs) == 0 {
+		return false
+	}
+
+	filter := managedGroupsFilter(tenantID, managerID)
+	filter["_id"] = bson.M{"$in": groupIDs}
+	count, err := s.groups.CountDocuments(ctx, filter)
+	return err == nil && count > 0
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestManagesUser checks that group managers manage the members of their groups, but not user
+// administrators, whether they hold the rights directly or through another group
+func TestManagesUser(t *testing.T) {
+	db := dbtest.New(t)
+
+	managerID := primitive.NewObjectID().Hex()
+	sales := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Sales", Scopes: []string{"read"}, Managers: []string{managerID}}
+	admins := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Administrators", Scopes: []string{AdminScope}}
+	helpdesk := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Helpdesk", Scopes: []string{UserManagementScope}}
+	support := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Support", Scopes: []string{SupportScope}, Managers: []string{managerID}}
+	dbtest.Insert(t, db, "groups", sales, admins, helpdesk, support)
+
+	member := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "member@example.com", Groups: []string{sales.ID.Hex()}}
+	directAdmin := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "admin@example.com", Scopes: []string{AdminScope}, Groups: []string{sales.ID.Hex()}}
+	groupAdmin := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "group-admin@example.com", Groups: []string{sales.ID.Hex(), admins.ID.Hex()}}
+	userManager := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "helpdesk@example.com", Groups: []string{sales.ID.Hex(), helpdesk.ID.Hex()}}
+	operator := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "operator@example.com", Scopes: []string{PlatformOperatorScope}, Groups: []string{sales.ID.Hex()}}
+	supportAgent := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "support@example.com", Groups: []string{support.ID.Hex()}}
+	outsider := &models.User{ID: primitive.NewObjectID(), TenantID: "t1", Email: "outsider@example.com"}
+	dbtest.Insert(t, db, "users", member, directAdmin, groupAdmin, userManager, operator, supportAgent, outsider)
+
+	service := NewMembershipService(db)
+	tests := []struct {
+		name string
+		user *models.User
+		want bool
+	}{
+		{"member", member, true},
+		{"admin by scope", directAdmin, false},
+		{"admin through a group", groupAdmin, false},
+		{"user manager through a group", userManager, false},
+		{"platform operator", operator, false},
+		{"member of a managed support group", supportAgent, false},
+		{"not a member", outsider, false},
+	}
+	for _, tt := range tests {
+		if got := service.ManagesUser("t1", managerID, tt.user.ID.Hex()); got != tt.want {
+			t.Errorf("%s: ManagesUser() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if service.ManagesUser("t2", managerID, member.ID.Hex()) {
+		t.Error("ManagesUser() managed a user of another tenant")
+	}
+	if service.ManagesGroup("t1", support.ID.Hex(), managerID) {
+		t.Error("ManagesGroup() managed a group granting the support scope")
+	}
+}
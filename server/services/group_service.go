@@ -29,7 +29,12 @@ func (s *GroupService) CreateGroup(group *models.Group) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	return s.createGroup(ctx, group)
+}
+
+func (s *GroupService) createGroup(ctx context.Context, group *models.Group) error {
 	group.ID = primitive.NewObjectID()
+	group.Version = 1
 	group.CreatedAt = time.Now()
 	group.UpdatedAt = time.Now()
 
@@ -67,6 +72,10 @@ func (s *GroupService) GetGroupByName(name, tenantID string) (*models.Group, err
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	return s.getGroupByName(ctx, name, tenantID)
+}
+
+func (s *GroupService) getGroupByName(ctx context.Context, name, tenantID string) (*models.Group, error) {
 	filter := bson.M{"name": name}
 	if tenantID != "" {
 		filter["tenant_id"] = tenantID
@@ -104,7 +113,8 @@ func (s *GroupService) GetAllGroups(tenantID string) ([]*models.Group, error) {
 	return groups, err
 }
 
-func (s *GroupService) UpdateGroup(id, tenantID string, group *models.Group) error {
+// UpdateGroup updates a group if its stored version equals expectedVersion (AnyVersion skips the check)
+func (s *GroupService) UpdateGroup(id, tenantID string, group *models.Group, expectedVersion int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -124,16 +134,19 @@ func (s *GroupService) UpdateGroup(id, tenantID string, group *models.Group) err
 		"description": group.Description,
 		"scopes":      group.Scopes,
 		"members":     group.Members,
+		"owners":      group.Owners,
+		"managers":    group.Managers,
+		"require_mfa": group.RequireMFA,
 		"updated_at":  group.UpdatedAt,
-	}}
+	}, "$inc": bson.M{"version": 1}}
 
-	result, err := s.collection.UpdateOne(ctx, filter, update)
+	result, err := s.collection.UpdateOne(ctx, withExpectedVersion(filter, expectedVersion), update)
 	if err != nil {
 		return err
 	}
 
 	if result.MatchedCount == 0 {
-		return errors.New("group not found")
+		return versionedUpdateError(ctx, s.collection, filter, errors.New("group not found"))
 	}
 
 	return nil
@@ -182,6 +195,7 @@ func (s *GroupService) AddMemberToGroup(groupID, userID, tenantID string) error
 	update := bson.M{
 		"$addToSet": bson.M{"members": userID},
 		"$set":      bson.M{"updated_at": time.Now()},
+		"$inc":      bson.M{"version": 1},
 	}
 
 	result, err := s.collection.UpdateOne(ctx, filter, update)
@@ -213,6 +227,7 @@ func (s *GroupService) RemoveMemberFromGroup(groupID, userID, tenantID string) e
 	update := bson.M{
 		"$pull": bson.M{"members": userID},
 		"$set":  bson.M{"updated_at": time.Now()},
+		"$inc":  bson.M{"version": 1},
 	}
 
 	result, err := s.collection.UpdateOne(ctx, filter, update)
@@ -251,6 +266,10 @@ func (s *GroupService) InitializeDefaultGroups(tenantID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	return s.initializeDefaultGroups(ctx, tenantID)
+}
+
+func (s *GroupService) initializeDefaultGroups(ctx context.Context, tenantID string) error {
 	// Check if any groups already exist for this tenant
 	filter := bson.M{}
 	if tenantID != "" {
@@ -304,6 +323,16 @@ func (s *GroupService) InitializeDefaultGroups(tenantID string) error {
 			},
 			Members: []string{},
 		},
+		{
+			Name:        "Support",
+			Description: "Support engineers with read-only access to all administration endpoints",
+			TenantID:    tenantID,
+			Scopes: []string{
+				SupportScope, "read", "openid", "profile", "email",
+				"read:profile", "read:users", "read:groups", "read:clients",
+			},
+			Members: []string{},
+		},
 		{
 			Name:        "Standard Users",
 			Description: "Regular users with basic access",
@@ -332,10 +361,35 @@ func (s *GroupService) InitializeDefaultGroups(tenantID string) error {
 		group.CreatedAt = now
 		group.UpdatedAt = now
 		
-		if err := s.CreateGroup(group); err != nil {
+		if err := s.createGroup(ctx, group); err != nil {
 			return err
 		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+// GrantPlatformOperator adds PlatformOperatorScope to the Administrators group of the default
+// tenant and to its members, so installations set up before the platform operator role existed
+// keep managing tenants. It does nothing once a user of the tenant carries the scope.
+func (s *GroupService) GrantPlatformOperator(defaultTenantID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := s.db.GetCollection("users")
+	count, err := users.CountDocuments(ctx, bson.M{"tenant_id": defaultTenantID, "scopes": PlatformOperatorScope})
+	if err != nil || count > 0 {
+		return err
+	}
+
+	group, err := s.GetGroupByName("Administrators", defaultTenantID)
+	if err != nil {
+		return nil // no administrators to promote
+	}
+
+	grant := bson.M{"$addToSet": bson.M{"scopes": PlatformOperatorScope}}
+	if _, err := s.collection.UpdateOne(ctx, bson.M{"_id": group.ID}, grant); err != nil {
+		return err
+	}
+	_, err = users.UpdateMany(ctx, bson.M{"tenant_id": defaultTenantID, "groups": group.ID.Hex()}, grant)
+	return err
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+// Groups claim modes of a client's ID tokens
+const (
+	GroupsClaimAll       = "all"       // every group of the user (default)
+	GroupsClaimRelevant  = "relevant"  // only the groups relevant to the client
+	GroupsClaimOmit      = "omit"      // no groups claim
+	GroupsClaimReference = "reference" // a distributed claim pointing at the groups endpoint
+)
+
+const (
+	// DefaultIDTokenSizeBudget keeps ID tokens well below common proxy header limits (8 KB)
+	DefaultIDTokenSizeBudget = 4096
+	// groupsClaimSource names the groups endpoint in _claim_sources
+	groupsClaimSource = "groups_endpoint"
+)
+
+// ClaimSource is a distributed claims source (OIDC Core 5.6.2): the client fetches the claims
+// from the endpoint with its access token
+type ClaimSource struct {
+	Endpoint string `json:"endpoint"`
+}
+
+// referenceGroups replaces the groups claim with a reference to the groups endpoint
+func (c *IDTokenClaims) referenceGroups() {
+	c.Groups = nil
+	c.ClaimNames = map[string]string{"groups": groupsClaimSource}
+	c.ClaimSources = map[string]ClaimSource{groupsClaimSource: {Endpoint: c.Issuer + "/api/v1/users/me/groups"}}
+}
+
+// idTokenSizeBudget returns the largest ID token with group names the client accepts
+func idTokenSizeBudget(client *models.Client) int {
+	if client != nil && client.GroupsClaim.MaxTokenBytes > 0 {
+		return client.GroupsClaim.MaxTokenBytes
+	}
+	return DefaultIDTokenSizeBudget
+}
+
+// groupsClaimMode returns the client's groups claim mode; clients without a record get all groups
+func groupsClaimMode(client *models.Client) string {
+	if client == nil || client.GroupsClaim.Mode == "" {
+		return GroupsClaimAll
+	}
+	return client.GroupsClaim.Mode
+}
+
+// clientGroupIDs filters the user's group IDs down to those the client's policy releases. The
+// relevant mode keeps the groups listed in the policy, or else the groups assigned to the client.
+func clientGroupIDs(client *models.Client, userGroups []string) []string {
+	switch groupsClaimMode(client) {
+	case GroupsClaimAll, GroupsClaimReference:
+		return userGroups
+	case GroupsClaimRelevant:
+		relevant := client.GroupsClaim.RelevantGroups
+		if len(relevant) == 0 {
+			relevant = client.AssignedGroups
+		}
+		ids := []string{}
+		for _, id := range userGroups {
+			if containsString(relevant, id) {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	return []string{}
+}
+
+// GroupNamesForClient returns the names of the user's groups the client's groups claim policy
+// releases, for the groups endpoint the claim may refer to. Clients without a record in the
+// user's tenant get all groups, like in their ID tokens.
+func (s *MembershipService) GroupNamesForClient(user *models.User, clientID string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := getTenantClient(ctx, s.db.GetCollection("clients"), clientID, user.TenantID)
+	if err != nil {
+		client = nil
+	}
+	return s.GetGroupNames(user.TenantID, clientGroupIDs(client, user.Groups))
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"oauth2-openid-server/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestClientGroupIDs(t *testing.T) {
+	userGroups := []string{"admins", "developers", "finance"}
+
+	tests := []struct {
+		name   string
+		client *models.Client
+		want   []string
+	}{
+		{"no client record", nil, userGroups},
+		{"default mode", &models.Client{}, userGroups},
+		{"relevant groups", &models.Client{GroupsClaim: models.GroupsClaimPolicy{Mode: GroupsClaimRelevant, RelevantGroups: []string{"finance", "sales"}}}, []string{"finance"}},
+		{"assigned groups", &models.Client{AssignedGroups: []string{"developers"}, GroupsClaim: models.GroupsClaimPolicy{Mode: GroupsClaimRelevant}}, []string{"developers"}},
+		{"nothing relevant", &models.Client{GroupsClaim: models.GroupsClaimPolicy{Mode: GroupsClaimRelevant}}, []string{}},
+		{"omitted", &models.Client{GroupsClaim: models.GroupsClaimPolicy{Mode: GroupsClaimOmit}}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := clientGroupIDs(tt.client, userGroups); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: clientGroupIDs() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIDTokenClaimsReferenceGroups(t *testing.T) {
+	claims := &IDTokenClaims{
+		Groups:           []string{"admins"},
+		RegisteredClaims: jwt.RegisteredClaims{Issuer: "https://auth.example.com/tenant/t1"},
+	}
+	claims.referenceGroups()
+
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(encoded)
+	if strings.Contains(body, `"groups":[`) ||
+		!strings.Contains(body, `"_claim_names":{"groups":"groups_endpoint"}`) ||
+		!strings.Contains(body, `"_claim_sources":{"groups_endpoint":{"endpoint":"https://auth.example.com/tenant/t1/api/v1/users/me/groups"}}`) {
+		t.Errorf("claims = %s", body)
+	}
+
+	if budget := idTokenSizeBudget(&models.Client{GroupsClaim: models.GroupsClaimPolicy{MaxTokenBytes: 2048}}); budget != 2048 {
+		t.Errorf("idTokenSizeBudget() = %d", budget)
+	}
+	if budget := idTokenSizeBudget(nil); budget != DefaultIDTokenSizeBudget {
+		t.Errorf("idTokenSizeBudget(nil) = %d", budget)
+	}
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The expected values are the examples of OIDC Core appendix A.3 (RS256, same hash as HS256)
+func TestOIDCTokenHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		value  string
+		want   string
+	}{
+		{"at_hash", jwt.SigningMethodHS256, "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "77QmUPtjPfzWtF2AnpK9RQ"},
+		{"c_hash", jwt.SigningMethodRS256, "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk", "LDktKdoQak3Pk0cnXxCltA"},
+		{"SHA-512", jwt.SigningMethodHS512, "abc", "3a81oZNherrMQXNJriBBMRLm-k6JqX6iCp7u5ktV05o"},
+		{"empty", jwt.SigningMethodHS256, "", ""},
+		{"none", jwt.SigningMethodNone, "abc", ""},
+	}
+	for _, tt := range tests {
+		if got := oidcTokenHash(tt.method, tt.value); got != tt.want {
+			t.Errorf("%s: oidcTokenHash() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// rollingKeys picks signing keys like CryptoKeyService.SigningKey, with the canary rolls going
+// round 0-99 instead of being random
+type rollingKeys struct {
+	keys []models.CryptoKey
+	roll int
+}
+
+func (k *rollingKeys) SigningKey(ctx context.Context, tenantID, keyType string) (*models.CryptoKey, error) {
+	key := selectSigningKey(k.keys, keyType, time.Now(), k.roll%100)
+	k.roll++
+	if key == nil {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func newTestCryptoKey(t *testing.T, keyID, keyType string, canaryPercent int) (models.CryptoKey, interface{}) {
+	t.Helper()
+	var private, public interface{}
+	if keyType == "rsa" {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = key, &key.PublicKey
+	} else {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = key, &key.PublicKey
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.CryptoKey{
+		KeyID:         keyID,
+		KeyType:       keyType,
+		Active:        true,
+		CreatedAt:     time.Now(),
+		CanaryPercent: canaryPercent,
+		PrivateKey:    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	}, public
+}
+
+func TestClientIDTokenSignerSplitsCanaryKeys(t *testing.T) {
+	current, currentPublic := newTestCryptoKey(t, "current", "rsa", 0)
+	canary, canaryPublic := newTestCryptoKey(t, "canary", "rsa", 30)
+	publicKeys := map[string]interface{}{"current": currentPublic, "canary": canaryPublic}
+	s := &OAuthService{signingKeys: &rollingKeys{keys: []models.CryptoKey{current, canary}}}
+	client := &models.Client{ClientID: "portal", IDTokenSignedResponseAlg: models.IDTokenAlgRS256}
+
+	signed := map[string]int{}
+	for i := 0; i < 100; i++ {
+		signer, err := s.clientIDTokenSigner(client, "acme")
+		if err != nil {
+			t.Fatalf("clientIDTokenSigner() error = %v", err)
+		}
+		token := jwt.NewWithClaims(signer.method, jwt.RegisteredClaims{Audience: jwt.ClaimStrings{client.ClientID}})
+		token.Header["kid"] = signer.keyID
+		tokenString, err := token.SignedString(signer.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Relying parties verify with the JWKS key named by the kid
+		_, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			signed[kid]++
+			return publicKeys[kid], nil
+		}, jwt.WithValidMethods([]string{models.IDTokenAlgRS256}))
+		if err != nil {
+			t.Fatalf("ID token doesn't verify with its kid's key: %v", err)
+		}
+	}
+
+	if signed["canary"] != 30 || signed["current"] != 70 {
+		t.Errorf("Expected 30 canary and 70 current signatures, got %v", signed)
+	}
+}
+
+func TestClientIDTokenSignerAlgorithms(t *testing.T) {
+	rsaKey, _ := newTestCryptoKey(t, "rsa-1", "rsa", 0)
+	ecKey, _ := newTestCryptoKey(t, "ec-1", "ecdsa", 0)
+	s := &OAuthService{signingKeys: &rollingKeys{keys: []models.CryptoKey{rsaKey, ecKey}}}
+
+	tests := []struct {
+		alg     string
+		method  string
+		wantKid string
+	}{
+		{models.IDTokenAlgRS256, "RS256", "rsa-1"},
+		{models.IDTokenAlgES256, "ES256", "ec-1"},
+		{"", "HS256", ""},
+	}
+	for _, tt := range tests {
+		client := &models.Client{ClientID: "portal", IDTokenSignedResponseAlg: tt.alg, IDTokenSigningKey: "client-key"}
+		signer, err := s.clientIDTokenSigner(client, "acme")
+		if err != nil {
+			t.Fatalf("%q: clientIDTokenSigner() error = %v", tt.alg, err)
+		}
+		if signer.method.Alg() != tt.method {
+			t.Errorf("%q: method = %s, want %s", tt.alg, signer.method.Alg(), tt.method)
+		}
+		if tt.wantKid != "" && signer.keyID != tt.wantKid {
+			t.Errorf("%q: kid = %q, want %q", tt.alg, signer.keyID, tt.wantKid)
+		}
+	}
+}
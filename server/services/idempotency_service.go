@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// idempotencyKeyTTL is how long a retry returns the original response
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long a request holds its key; the key of a request that
+	// crashed before finishing can be used again afterwards
+	idempotencyLockTimeout = time.Minute
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("the idempotency key was already used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+// IdempotentResponse is a response replayed to retries of a request
+type IdempotentResponse struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   []byte            `json:"body"`
+}
+
+// IdempotencyService stores the responses of requests sent with an Idempotency-Key header, so
+// automation retrying a create request gets the original result instead of a duplicate
+type IdempotencyService struct {
+	collection *mongo.Collection
+}
+
+func NewIdempotencyService(db *database.MongoDB) *IdempotencyService {
+	return &IdempotencyService{
+		collection: db.GetCollection("idempotency_keys"),
+	}
+}
+
+// EnsureIndexes expires idempotency keys after idempotencyKeyTTL
+func (s *IdempotencyService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(idempotencyKeyTTL.Seconds())),
+	})
+	return err
+}
+
+// Begin claims an idempotency key of a scope (the tenant and caller) for a request with the given
+// fingerprint. It returns the stored response when the request was already answered; otherwise
+// the caller processes the request and then calls Complete or Abandon. A key used for a different
+// request fails with ErrIdempotencyKeyReused, one whose request is still running with
+// ErrIdempotencyKeyInProgress.
+func (s *IdempotencyService) Begin(scope, key, fingerprint string) (*IdempotentResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	id := idempotencyRecordID(scope, key)
+	_, err := s.collection.InsertOne(ctx, &models.IdempotencyRecord{
+		ID:          id,
+		Fingerprint: fingerprint,
+		Status:      models.IdempotencyInProgress,
+		CreatedAt:   now,
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var record models.IdempotencyRecord
+	if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&record); err == mongo.ErrNoDocuments {
+		// The request holding the key failed and released it just now
+		return nil, ErrIdempotencyKeyInProgress
+	} else if err != nil {
+		return nil, err
+	}
+	if record.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if record.Status == models.IdempotencyCompleted {
+		return openIdempotentResponse(scope, key, record.Response)
+	}
+
+	// Take over the key of a request that never finished
+	result, err := s.collection.UpdateOne(ctx, bson.M{
+		"_id":        id,
+		"status":     models.IdempotencyInProgress,
+		"created_at": bson.M{"$lt": now.Add(-idempotencyLockTimeout)},
+	}, bson.M{"$set": bson.M{"created_at": now}})
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return nil, nil
+}
+
+// Complete stores the response of a request claimed with Begin
+func (s *IdempotencyService) Complete(scope, key string, response *IdempotentResponse) error {
+	sealed, err := sealIdempotentResponse(scope, key, response)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": idempotencyRecordID(scope, key), "status": models.IdempotencyInProgress}, bson.M{
+		"$set": bson.M{"status": models.IdempotencyCompleted, "response": sealed},
+	})
+	return err
+}
+
+// Abandon releases the key of a request that failed on the server, so a retry processes it again
+func (s *IdempotencyService) Abandon(scope, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": idempotencyRecordID(scope, key), "status": models.IdempotencyInProgress})
+	return err
+}
+
+func idempotencyRecordID(scope, key string) string {
+	sum := sha256.Sum256([]byte("idempotency-key\x00" + scope + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencySealer encrypts a stored response with a key only the client holds: responses such
+// as a new client's secret can't be read from the database without the idempotency key
+func idempotencySealer(scope, key string) *FlowSealer {
+	return NewFlowSealer("idempotency-response\x00" + scope + "\x00" + key)
+}
+
+func sealIdempotentResponse(scope, key string, response *IdempotentResponse) (string, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+	return idempotencySealer(scope, key).Seal(data)
+}
+
+func openIdempotentResponse(scope, key, sealed string) (*IdempotentResponse, error) {
+	data, err := idempotencySealer(scope, key).Open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	var response IdempotentResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdempotentResponseSealedWithKey(t *testing.T) {
+	response := &IdempotentResponse{Status: 201, Header: map[string]string{"Location": "/api/v1/clients/c1"}, Body: []byte(`{"client_secret":"s3cret"}`)}
+
+	sealed, err := sealIdempotentResponse("t1/u1/app", "create-client", response)
+	if err != nil {
+		t.Fatalf("sealIdempotentResponse() error = %v", err)
+	}
+	opened, err := openIdempotentResponse("t1/u1/app", "create-client", sealed)
+	if err != nil || !reflect.DeepEqual(opened, response) {
+		t.Errorf("openIdempotentResponse() = %+v, %v, want the original response", opened, err)
+	}
+
+	if _, err := openIdempotentResponse("t1/u1/app", "other-key", sealed); err == nil {
+		t.Error("Expected a response to open only with its own key")
+	}
+	if idempotencyRecordID("t1/u1/app", "create-client") == idempotencyRecordID("t2/u1/app", "create-client") {
+		t.Error("Expected keys of different scopes to be stored apart")
+	}
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// jobLease is how long a worker owns a job without reporting progress. Jobs of a worker that
+	// stopped (a restart or crash) are picked up by another worker once the lease ran out.
+	jobLease = 2 * time.Minute
+	// maxJobAttempts stops jobs that keep crashing their worker from being retried forever
+	maxJobAttempts = 3
+	// maxJobErrors caps the item failures kept on a job
+	maxJobErrors = 100
+	// jobRetention is how long finished jobs can be looked up
+	jobRetention = 30 * 24 * time.Hour
+	// jobBatchSize is how many documents bulk jobs update or delete per step
+	jobBatchSize = 1000
+)
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobLeaseLost = errors.New("job was taken over by another worker")
+)
+
+// JobRunner executes one kind of job. Jobs can be interrupted at any point and run again, so a
+// runner must resume from the job's recorded progress and tolerate repeating its last step.
+type JobRunner func(run *JobRun) error
+
+// JobStep is the progress a runner made since its last report
+type JobStep struct {
+	Processed int64            // items handled, including failed ones
+	Failed    int64            // items that failed
+	Counts    map[string]int64 // added to the job's result
+	Errors    []string         // descriptions of the failed items
+}
+
+// JobService queues long-running admin operations and runs them in the background, so requests
+// don't time out behind proxies. Jobs are claimed with a lease, so several server instances can
+// share the queue.
+type JobService struct {
+	jobs    *mongo.Collection
+	runners map[string]JobRunner
+}
+
+func NewJobService(db *database.MongoDB) *JobService {
+	return &JobService{
+		jobs:    db.GetCollection("jobs"),
+		runners: map[string]JobRunner{},
+	}
+}
+
+// Register sets the runner of a kind of job. Runners are registered before the scheduler starts.
+func (s *JobService) Register(jobType string, runner JobRunner) {
+	s.runners[jobType] = runner
+}
+
+// EnsureIndexes creates the indexes of the job queue and the tenant's job list, and expires
+// finished jobs
+func (s *JobService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.jobs.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "finished_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(jobRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// Submit queues a job of the tenant. params are stored with the job and passed to its runner.
+func (s *JobService) Submit(tenantID, jobType, createdBy string, params interface{}) (*models.Job, error) {
+	if _, ok := s.runners[jobType]; !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+	raw, err := bson.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	job := &models.Job{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantID,
+		Type:      jobType,
+		Status:    models.JobPending,
+		Params:    raw,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := s.jobs.InsertOne(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Get returns a job of the tenant
+func (s *JobService) Get(tenantID, jobID string) (*models.Job, error) {
+	id, err := primitive.ObjectIDFromHex(jobID)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var job models.Job
+	err = s.jobs.FindOne(ctx, bson.M{"_id": id, "tenant_id": tenantID}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List returns the tenant's most recent jobs, newest first
+func (s *JobService) List(tenantID string) ([]*models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.jobs.Find(ctx, bson.M{"tenant_id": tenantID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100).SetProjection(bson.M{"params": 0}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []*models.Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// RunPending runs queued jobs, and jobs whose worker stopped, one after the other until the queue
+// is empty
+func (s *JobService) RunPending() error {
+	for {
+		job, err := s.claim()
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.run(job)
+	}
+}
+
+// claim takes the oldest runnable job with a new lease
+func (s *JobService) claim() (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	leaseUntil := now.Add(jobLease)
+	var job models.Job
+	err := s.jobs.FindOneAndUpdate(ctx, bson.M{"$or": bson.A{
+		bson.M{"status": models.JobPending},
+		bson.M{"status": models.JobRunning, "lease_until": bson.M{"$lt": now}},
+	}}, bson.M{
+		"$set": bson.M{"status": models.JobRunning, "lease_id": primitive.NewObjectID().Hex(), "lease_until": leaseUntil, "updated_at": now},
+		"$min": bson.M{"started_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}, options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *JobService) run(job *models.Job) {
+	run := &JobRun{Job: job, service: s}
+
+	runner, ok := s.runners[job.Type]
+	if !ok {
+		run.finish(fmt.Errorf("unknown job type %q", job.Type))
+		return
+	}
+	if job.Attempts > maxJobAttempts {
+		run.finish(fmt.Errorf("gave up after %d attempts", maxJobAttempts))
+		return
+	}
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job panicked: %v", r)
+			}
+		}()
+		return runner(run)
+	}()
+	if errors.Is(err, ErrJobLeaseLost) {
+		log.Printf("Warning: Job %s was taken over by another worker", job.ID.Hex())
+		return
+	}
+	run.finish(err)
+}
+
+// JobRun is a job being executed by its runner
+type JobRun struct {
+	Job     *models.Job
+	service *JobService
+}
+
+// Params decodes the job's parameters into dst
+func (r *JobRun) Params(dst interface{}) error {
+	return bson.Unmarshal(r.Job.Params, dst)
+}
+
+// SetTotal records how many items the job handles in total, including the processed ones
+func (r *JobRun) SetTotal(total int64) error {
+	r.Job.Progress.Total = total
+	return r.update(bson.M{"$set": bson.M{"progress.total": total}})
+}
+
+// Advance records the progress made since the last report and renews the lease. It returns
+// ErrJobLeaseLost when another worker took the job over; the runner must stop then.
+func (r *JobRun) Advance(step JobStep) error {
+	inc := bson.M{"progress.processed": step.Processed, "progress.failed": step.Failed}
+	for key, count := range step.Counts {
+		inc["result."+key] = count
+	}
+	update := bson.M{"$inc": inc}
+	if len(step.Errors) > 0 {
+		update["$push"] = bson.M{"errors": bson.M{"$each": step.Errors, "$slice": maxJobErrors}}
+	}
+	if err := r.update(update); err != nil {
+		return err
+	}
+
+	r.Job.Progress.Processed += step.Processed
+	r.Job.Progress.Failed += step.Failed
+	return nil
+}
+
+// update applies an update to the job while the worker still holds its lease, and renews it
+func (r *JobRun) update(update bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set, _ := update["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set["lease_until"] = now.Add(jobLease)
+	set["updated_at"] = now
+
+	result, err := r.service.jobs.UpdateOne(ctx, bson.M{"_id": r.Job.ID, "lease_id": r.Job.LeaseID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// finish records the outcome of the job and drops its parameters, which may hold secrets such as
+// the passwords of imported users
+func (r *JobRun) finish(err error) {
+	now := time.Now()
+	set := bson.M{"status": models.JobSucceeded, "finished_at": now, "updated_at": now}
+	if err != nil {
+		set["status"] = models.JobFailed
+		set["error"] = err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = r.service.jobs.UpdateOne(ctx, bson.M{"_id": r.Job.ID, "lease_id": r.Job.LeaseID}, bson.M{
+		"$set":   set,
+		"$unset": bson.M{"params": "", "lease_id": "", "lease_until": ""},
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to record the outcome of job %s: %v", r.Job.ID.Hex(), err)
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestTokenRevocationJob revokes a client's tokens in the background and reports the result
+func TestTokenRevocationJob(t *testing.T) {
+	db := dbtest.New(t)
+	now := time.Now()
+
+	dbtest.Insert(t, db, "access_tokens",
+		&models.AccessToken{ID: primitive.NewObjectID(), Token: "a1", ClientID: "app", TenantID: "t1", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		&models.AccessToken{ID: primitive.NewObjectID(), Token: "a2", ClientID: "other", TenantID: "t1", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		&models.AccessToken{ID: primitive.NewObjectID(), Token: "a3", ClientID: "app", TenantID: "t2", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+	)
+	dbtest.Insert(t, db, "refresh_tokens",
+		&models.RefreshToken{ID: primitive.NewObjectID(), Token: "r1", ClientID: "app", TenantID: "t1", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+	)
+
+	service := NewJobService(db)
+	service.Register(models.JobTokenRevocation, TokenRevocationJob(db))
+	job, err := service.Submit("t1", models.JobTokenRevocation, "admin", TokenRevocationParams{ClientID: "app"})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if job.Status != models.JobPending {
+		t.Errorf("Submit() status = %q, want pending", job.Status)
+	}
+
+	if err := service.RunPending(); err != nil {
+		t.Fatalf("RunPending() error = %v", err)
+	}
+
+	job, err = service.Get("t1", job.ID.Hex())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if job.Status != models.JobSucceeded || job.Progress.Total != 2 || job.Progress.Processed != 2 || job.FinishedAt == nil {
+		t.Errorf("finished job = %+v, want 2 of 2 tokens processed", job)
+	}
+	if job.Result["access_tokens_revoked"] != 1 || job.Result["refresh_tokens_revoked"] != 1 {
+		t.Errorf("job result = %v", job.Result)
+	}
+	if len(job.Params) != 0 {
+		t.Error("Expected the parameters to be dropped once the job finished")
+	}
+
+	ctx := context.Background()
+	for token, want := range map[string]bool{"a1": true, "a2": false, "a3": false} {
+		var stored models.AccessToken
+		if err := db.GetCollection("access_tokens").FindOne(ctx, bson.M{"token": token}).Decode(&stored); err != nil {
+			t.Fatalf("FindOne(%s) error = %v", token, err)
+		}
+		if stored.Revoked != want {
+			t.Errorf("access token %s revoked = %v, want %v", token, stored.Revoked, want)
+		}
+	}
+
+	if _, err := service.Get("t2", job.ID.Hex()); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get() from another tenant error = %v, want ErrJobNotFound", err)
+	}
+}
+
+// TestJobResumesAfterWorkerStopped picks a running job up again once its lease ran out and
+// continues from the recorded progress
+func TestJobResumesAfterWorkerStopped(t *testing.T) {
+	db := dbtest.New(t)
+
+	var started []int64
+	service := NewJobService(db)
+	service.Register("count", func(run *JobRun) error {
+		started = append(started, run.Job.Progress.Processed)
+		if err := run.SetTotal(3); err != nil {
+			return err
+		}
+		for i := run.Job.Progress.Processed; i < 3; i++ {
+			if err := run.Advance(JobStep{Processed: 1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	expired := time.Now().Add(-time.Minute)
+	job := &models.Job{ID: primitive.NewObjectID(), TenantID: "t1", Type: "count", Status: models.JobRunning,
+		Progress: models.JobProgress{Total: 3, Processed: 2}, Attempts: 1, LeaseID: "stopped-worker", LeaseUntil: &expired,
+		CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	dbtest.Insert(t, db, "jobs", job)
+
+	if err := service.RunPending(); err != nil {
+		t.Fatalf("RunPending() error = %v", err)
+	}
+
+	resumed, err := service.Get("t1", job.ID.Hex())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(started) != 1 || started[0] != 2 {
+		t.Errorf("runner started at %v, want once at item 2", started)
+	}
+	if resumed.Status != models.JobSucceeded || resumed.Progress.Processed != 3 || resumed.Attempts != 2 {
+		t.Errorf("resumed job = %+v, want succeeded with 3 items after 2 attempts", resumed)
+	}
+}
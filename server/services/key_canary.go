@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrKeyCanaryRunning is returned when keys are rotated while a canary rotation is running
+	ErrKeyCanaryRunning = errors.New("a canary key rotation is running, promote or roll it back first")
+	// ErrNoKeyCanary is returned when there is no canary rotation to promote or roll back
+	ErrNoKeyCanary = errors.New("no canary key rotation is running")
+)
+
+const (
+	// maxFailureReasonLength caps the failure reason a relying party reports
+	maxFailureReasonLength = 200
+	// MinCanaryRollbackClients is the least number of distinct clients whose failure reports roll
+	// a canary back, so a single misconfigured or malicious relying party can't
+	MinCanaryRollbackClients = 2
+	// CanaryRollbackFailureRate is the least share of the tokens a canary key signed that must be
+	// reported as failing before it is rolled back
+	CanaryRollbackFailureRate = 0.05
+)
+
+// StartKeyCanary rotates the tenant's keys as a canary: the new RSA and ECDSA keys are published
+// and sign percent of new tokens, while the current keys keep signing the rest and don't start
+// expiring. Relying parties report tokens they fail to validate; once maxFailures distinct
+// clients (0 = no limit) reported failures for a canary key, and the reports amount to
+// CanaryRollbackFailureRate of the tokens it signed, the canary is rolled back automatically.
+func (s *CryptoKeyService) StartKeyCanary(ctx context.Context, tenantID string, percent, maxFailures int) ([]models.CryptoKey, error) {
+	if percent < 1 || percent > 99 {
+		return nil, fmt.Errorf("canary percentage must be between 1 and 99, got %d", percent)
+	}
+	if maxFailures != 0 && maxFailures < MinCanaryRollbackClients {
+		return nil, fmt.Errorf("canary failure limit must be 0 or at least %d clients, got %d", MinCanaryRollbackClients, maxFailures)
+	}
+
+	owner := s.keyOwner(tenantID)
+	var keys []models.CryptoKey
+	err := s.withKeyLock(owner, func() error {
+		activeKeys, err := s.findActiveKeys(ctx, keyOwnerFilter(owner))
+		if err != nil {
+			return fmt.Errorf("failed to get active keys: %v", err)
+		}
+
+		now := time.Now()
+		for _, key := range activeKeys {
+			switch key.Status(now) {
+			case models.KeyStatusCanary:
+				return ErrKeyCanaryRunning
+			case models.KeyStatusUpcoming:
+				// Published next keys that never became active are superseded by the canary
+				if _, err := s.keyCollection.DeleteOne(ctx, bson.M{"_id": key.ID}); err != nil {
+					return fmt.Errorf("failed to remove upcoming key %s: %v", key.KeyID, err)
+				}
+			}
+		}
+
+		keys, err = s.createKeyPair(ctx, owner, nil)
+		if err != nil {
+			return err
+		}
+		ids := bson.A{}
+		for i := range keys {
+			keys[i].CanaryPercent = percent
+			keys[i].CanaryMaxFailures = maxFailures
+			ids = append(ids, keys[i].ID)
+		}
+		_, err = s.keyCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{
+			"$set": bson.M{"canary_percent": percent, "canary_max_failures": maxFailures},
+		})
+		return err
+	})
+	return keys, err
+}
+
+// PromoteKeyCanary makes the canary keys the tenant's current keys; the keys they replace expire
+// after the grace period (0 = the tenant policy)
+func (s *CryptoKeyService) PromoteKeyCanary(ctx context.Context, tenantID string, gracePeriod time.Duration) ([]models.CryptoKey, error) {
+	if gracePeriod <= 0 {
+		gracePeriod = s.policyGracePeriod(tenantID)
+	}
+
+	owner := s.keyOwner(tenantID)
+	var promoted []models.CryptoKey
+	err := s.withKeyLock(owner, func() error {
+		activeKeys, err := s.findActiveKeys(ctx, keyOwnerFilter(owner))
+		if err != nil {
+			return fmt.Errorf("failed to get active keys: %v", err)
+		}
+
+		now := time.Now()
+		var replaced []models.CryptoKey
+		for _, key := range activeKeys {
+			switch key.Status(now) {
+			case models.KeyStatusCanary:
+				promoted = append(promoted, key)
+			case models.KeyStatusActive:
+				replaced = append(replaced, key)
+			}
+		}
+		if len(promoted) == 0 {
+			return ErrNoKeyCanary
+		}
+
+		for i := range promoted {
+			if _, err := s.keyCollection.UpdateOne(ctx, bson.M{"_id": promoted[i].ID}, bson.M{
+				"$unset": bson.M{"canary_percent": "", "canary_max_failures": ""},
+			}); err != nil {
+				return fmt.Errorf("failed to promote key %s: %v", promoted[i].KeyID, err)
+			}
+			promoted[i].CanaryPercent = 0
+			promoted[i].CanaryMaxFailures = 0
+		}
+
+		expiresAt := now.Add(gracePeriod)
+		for _, key := range replaced {
+			// Keys that already expire sooner, e.g. from an earlier rotation, keep their expiry
+			if key.ExpiresAt != nil && key.ExpiresAt.Before(expiresAt) {
+				continue
+			}
+			if _, err := s.keyCollection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{
+				"$set": bson.M{"expires_at": expiresAt},
+			}); err != nil {
+				return fmt.Errorf("failed to set expiry for key %s: %v", key.KeyID, err)
+			}
+		}
+		return nil
+	})
+	return promoted, err
+}
+
+// RollbackKeyCanary stops signing with the tenant's canary keys. They stay published for the
+// grace period (0 = the tenant policy), so the tokens they already signed can still be validated
+// by the relying parties that managed to.
+func (s *CryptoKeyService) RollbackKeyCanary(ctx context.Context, tenantID string, gracePeriod time.Duration) ([]models.CryptoKey, error) {
+	if gracePeriod <= 0 {
+		gracePeriod = s.policyGracePeriod(tenantID)
+	}
+
+	owner := s.keyOwner(tenantID)
+	var rolledBack []models.CryptoKey
+	err := s.withKeyLock(owner, func() error {
+		var err error
+		rolledBack, err = s.rollbackKeyCanary(ctx, owner, gracePeriod)
+		return err
+	})
+	return rolledBack, err
+}
+
+func (s *CryptoKeyService) rollbackKeyCanary(ctx context.Context, owner string, gracePeriod time.Duration) ([]models.CryptoKey, error) {
+	activeKeys, err := s.findActiveKeys(ctx, keyOwnerFilter(owner))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active keys: %v", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(gracePeriod)
+	var rolledBack []models.CryptoKey
+	for _, key := range activeKeys {
+		if key.Status(now) != models.KeyStatusCanary {
+			continue
+		}
+		if _, err := s.keyCollection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{
+			"$set":   bson.M{"rolled_back_at": now, "expires_at": expiresAt},
+			"$unset": bson.M{"canary_percent": ""},
+		}); err != nil {
+			return nil, fmt.Errorf("failed to roll back key %s: %v", key.KeyID, err)
+		}
+		key.CanaryPercent = 0
+		key.RolledBackAt = &now
+		key.ExpiresAt = &expiresAt
+		rolledBack = append(rolledBack, key)
+	}
+	if len(rolledBack) == 0 {
+		return nil, ErrNoKeyCanary
+	}
+	return rolledBack, nil
+}
+
+// RecordValidationFailure counts a token signed with the key that the client, a relying party of
+// the tenant, failed to validate. Only reports of the key tenant's own clients count toward rolling
+// back a canary key: once as many distinct clients as its failure limit reported failures, and the
+// failures reach CanaryRollbackFailureRate of the tokens it signed, it is rolled back.
+func (s *CryptoKeyService) RecordValidationFailure(ctx context.Context, tenantID, clientID, keyID, reason string) error {
+	if len(reason) > maxFailureReasonLength {
+		reason = reason[:maxFailureReasonLength]
+	}
+
+	update := bson.M{
+		"$inc":      bson.M{"validation_failures": 1, "canary_failures": 1},
+		"$addToSet": bson.M{"failing_clients": clientID},
+	}
+	if reason != "" {
+		update["$set"] = bson.M{"last_failure": reason}
+	}
+
+	var key models.CryptoKey
+	owner := s.keyOwner(tenantID)
+	filter := keyOwnerFilter(owner)
+	filter["key_id"] = keyID
+	err := s.keyCollection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&key)
+	if err == mongo.ErrNoDocuments && owner != "" {
+		// Tenant JWKS endpoints publish the platform keys too. Other tenants' clients can't vouch
+		// for the platform keys' tokens, so their reports are shown but never roll a canary back.
+		foreign := bson.M{"$inc": bson.M{"validation_failures": 1}}
+		if reason != "" {
+			foreign["$set"] = update["$set"]
+		}
+		filter = keyOwnerFilter("")
+		filter["key_id"] = keyID
+		err = s.keyCollection.FindOneAndUpdate(ctx, filter, foreign,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&key)
+		if err == nil {
+			return nil
+		}
+	}
+	if err == mongo.ErrNoDocuments {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if !canaryRollbackDue(&key, time.Now()) {
+		return nil
+	}
+
+	keyTenant := key.TenantID
+	err = s.withKeyLock(keyTenant, func() error {
+		_, err := s.rollbackKeyCanary(ctx, keyTenant, s.policyGracePeriod(keyTenant))
+		return err
+	})
+	switch err {
+	case nil:
+		log.Printf("Warning: Rolled back the canary keys of %q after %d clients reported %d validation failures of the %d tokens signed with key %s", keyTenant, len(key.FailingClients), key.CanaryFailures, key.CanaryTokens, key.KeyID)
+	case ErrNoKeyCanary, ErrKeyChangeInProgress:
+		// Already rolled back or promoted, or another change is running; a later report retries
+	default:
+		return err
+	}
+	return nil
+}
+
+// canaryRollbackDue reports whether enough distinct clients reported failures for a canary key,
+// at a high enough rate of the tokens it signed, to roll it back
+func canaryRollbackDue(key *models.CryptoKey, now time.Time) bool {
+	if key.Status(now) != models.KeyStatusCanary || key.CanaryMaxFailures <= 0 {
+		return false
+	}
+	if len(key.FailingClients) < key.CanaryMaxFailures || len(key.FailingClients) < MinCanaryRollbackClients {
+		return false
+	}
+	if key.CanaryTokens == 0 {
+		return false
+	}
+	return float64(key.CanaryFailures)/float64(key.CanaryTokens) >= CanaryRollbackFailureRate
+}
+
+// SigningKey returns the key of the given type ("rsa" or "ecdsa") a new token of the tenant is
+// signed with: the tenant's current key, or the canary key for its share of tokens while a
+// canary rotation is running. Tenants without keys of their own use the platform keys.
+func (s *CryptoKeyService) SigningKey(ctx context.Context, tenantID, keyType string) (*models.CryptoKey, error) {
+	owner := s.keyOwner(tenantID)
+	keys, err := s.findActiveKeys(ctx, keyOwnerFilter(owner))
+	if err != nil {
+		return nil, err
+	}
+
+	roll := rand.Intn(100)
+	key := selectSigningKey(keys, keyType, time.Now(), roll)
+	if key == nil && owner != "" {
+		if keys, err = s.findActiveKeys(ctx, keyOwnerFilter("")); err != nil {
+			return nil, err
+		}
+		key = selectSigningKey(keys, keyType, time.Now(), roll)
+	}
+	if key == nil {
+		return nil, ErrKeyNotFound
+	}
+	if key.Status(time.Now()) == models.KeyStatusCanary {
+		// The failure rate that rolls a canary back is relative to the tokens it signed
+		if _, err := s.keyCollection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$inc": bson.M{"canary_tokens": 1}}); err != nil {
+			log.Printf("Warning: Failed to count token signed with canary key %s: %v", key.KeyID, err)
+		}
+	}
+	return key, nil
+}
+
+// selectSigningKey picks the signing key among an owner's active keys. roll (0-99) decides
+// whether a running canary key signs. Keys without an expiry are preferred over keys in their
+// grace period, and newer keys over older ones.
+func selectSigningKey(keys []models.CryptoKey, keyType string, now time.Time, roll int) *models.CryptoKey {
+	var current, canary *models.CryptoKey
+	for i := range keys {
+		key := &keys[i]
+		if key.KeyType != keyType {
+			continue
+		}
+		switch key.Status(now) {
+		case models.KeyStatusCanary:
+			canary = key
+		case models.KeyStatusActive:
+			if current == nil || preferSigningKey(key, current) {
+				current = key
+			}
+		}
+	}
+
+	if canary != nil && (current == nil || roll < canary.CanaryPercent) {
+		return canary
+	}
+	return current
+}
+
+// preferSigningKey reports whether key should sign rather than other
+func preferSigningKey(key, other *models.CryptoKey) bool {
+	if (key.ExpiresAt == nil) != (other.ExpiresAt == nil) {
+		return key.ExpiresAt == nil
+	}
+	return keyEffectiveAt(*key).After(keyEffectiveAt(*other))
+}
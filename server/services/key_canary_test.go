@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+func TestSelectSigningKey(t *testing.T) {
+	now := time.Now()
+	hoursAgo := func(hours int) time.Time { return now.Add(-time.Duration(hours) * time.Hour) }
+	inGrace := now.Add(time.Hour)
+
+	replaced := models.CryptoKey{KeyID: "replaced", KeyType: "rsa", Active: true, CreatedAt: hoursAgo(48), ExpiresAt: &inGrace}
+	current := models.CryptoKey{KeyID: "current", KeyType: "rsa", Active: true, CreatedAt: hoursAgo(24)}
+	ecdsa := models.CryptoKey{KeyID: "ecdsa", KeyType: "ecdsa", Active: true, CreatedAt: hoursAgo(1)}
+	canary := models.CryptoKey{KeyID: "canary", KeyType: "rsa", Active: true, CreatedAt: hoursAgo(1), CanaryPercent: 10}
+	rolledBack := models.CryptoKey{KeyID: "rolled-back", KeyType: "rsa", Active: true, CreatedAt: hoursAgo(1), RolledBackAt: &now, ExpiresAt: &inGrace}
+
+	tests := []struct {
+		name string
+		keys []models.CryptoKey
+		roll int
+		want string
+	}{
+		{"current over replaced", []models.CryptoKey{replaced, current, ecdsa}, 0, "current"},
+		{"only keys in grace period", []models.CryptoKey{replaced}, 0, "replaced"},
+		{"canary share", []models.CryptoKey{current, canary}, 9, "canary"},
+		{"outside the canary share", []models.CryptoKey{current, canary}, 10, "current"},
+		{"rolled back canary", []models.CryptoKey{current, rolledBack}, 0, "current"},
+		{"no key of the type", []models.CryptoKey{ecdsa}, 0, ""},
+	}
+	for _, tt := range tests {
+		got := selectSigningKey(tt.keys, "rsa", now, tt.roll)
+		if (got == nil && tt.want != "") || (got != nil && got.KeyID != tt.want) {
+			t.Errorf("%s: selectSigningKey() = %v, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPlanKeyRotationWaitsForCanary(t *testing.T) {
+	now := time.Now()
+	policy := models.KeyRotationPolicy{IntervalDays: 30}
+	overdue := now.Add(-60 * 24 * time.Hour)
+
+	running := []models.CryptoKey{
+		{Active: true, CreatedAt: overdue},
+		{Active: true, CreatedAt: now, CanaryPercent: 5},
+	}
+	if action, _ := planKeyRotation(running, policy, now); action != keyRotationNone {
+		t.Errorf("Expected no rotation while a canary runs, got %v", action)
+	}
+
+	// A rolled back canary doesn't count as a rotation of the current keys
+	inGrace := now.Add(time.Hour)
+	rolledBack := []models.CryptoKey{
+		{Active: true, CreatedAt: overdue},
+		{Active: true, CreatedAt: now, RolledBackAt: &now, ExpiresAt: &inGrace},
+	}
+	if action, _ := planKeyRotation(rolledBack, policy, now); action != keyRotationRotate {
+		t.Errorf("Expected overdue keys to rotate after a rollback, got %v", action)
+	}
+}
+
+func TestCanaryRollbackDue(t *testing.T) {
+	now := time.Now()
+	canary := func(maxFailures, tokens, reports int, clients ...string) *models.CryptoKey {
+		return &models.CryptoKey{Active: true, CanaryPercent: 10, CanaryMaxFailures: maxFailures, CanaryTokens: tokens, CanaryFailures: reports, FailingClients: clients}
+	}
+
+	tests := []struct {
+		name string
+		key  *models.CryptoKey
+		want bool
+	}{
+		{"manual rollback only", canary(0, 100, 50, "a", "b", "c"), false},
+		{"many reports of one client", canary(2, 100, 50, "a"), false},
+		{"below the client limit", canary(3, 100, 10, "a", "b"), false},
+		{"client limit reached", canary(3, 100, 10, "a", "b", "c"), true},
+		{"below the failure rate", canary(3, 1000, 10, "a", "b", "c"), false},
+		{"failure rate reached", canary(3, 200, 10, "a", "b", "c"), true},
+		{"no tokens signed", canary(2, 0, 5, "a", "b"), false},
+		{"limit below the minimum", canary(1, 100, 10, "a"), false},
+		{"promoted key", &models.CryptoKey{Active: true, CanaryMaxFailures: 2, FailingClients: []string{"a", "b"}}, false},
+	}
+	for _, tt := range tests {
+		if got := canaryRollbackDue(tt.key, now); got != tt.want {
+			t.Errorf("%s: canaryRollbackDue() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
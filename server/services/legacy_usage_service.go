@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultLegacyUsageDays is the reporting window used when none is given
+	DefaultLegacyUsageDays = 30
+	// MaxLegacyUsageDays is the longest reporting window; older counters expire
+	MaxLegacyUsageDays   = 180
+	legacyUsageRetention = (MaxLegacyUsageDays + 7) * 24 * time.Hour
+)
+
+// RouteDeprecation describes a deprecated route, or every route below a path prefix, and
+// the route that replaces it
+type RouteDeprecation struct {
+	Path      string    `json:"path"`      // deprecated route or route prefix, e.g. "/oauth"
+	Successor string    `json:"successor"` // replacement for Path; {tenantId} is filled in when known
+	Since     time.Time `json:"deprecated_since"`
+	Sunset    time.Time `json:"sunset"`
+}
+
+// Matches reports whether a route path falls under the deprecation
+func (d RouteDeprecation) Matches(path string) bool {
+	return path == d.Path || strings.HasPrefix(path, strings.TrimSuffix(d.Path, "/")+"/")
+}
+
+// SuccessorFor maps a request path below Path to its successor path
+func (d RouteDeprecation) SuccessorFor(path, tenantID string) string {
+	successor := d.Successor
+	if d.Matches(path) {
+		successor += strings.TrimPrefix(path, d.Path)
+	}
+	if tenantID != "" {
+		successor = strings.ReplaceAll(successor, "{tenantId}", tenantID)
+	}
+	return successor
+}
+
+// LegacyUsageService counts requests to deprecated routes so their removal can be planned
+type LegacyUsageService struct {
+	db         *database.MongoDB
+	collection *mongo.Collection
+	breaker    *database.CircuitBreaker // suspends counting while MongoDB times out
+}
+
+// LegacyUsageReport lists the usage of each deprecated route group over a reporting window
+type LegacyUsageReport struct {
+	Days         int                     `json:"days"`
+	Since        time.Time               `json:"since"`
+	Deprecations []*DeprecatedRouteUsage `json:"deprecations"`
+}
+
+// DeprecatedRouteUsage is the usage of one deprecation, broken down by route
+type DeprecatedRouteUsage struct {
+	RouteDeprecation
+	Requests int64                `json:"requests"`
+	Tenants  int                  `json:"tenants"`
+	LastSeen *time.Time           `json:"last_seen,omitempty"`
+	Routes   []*RouteUsageSummary `json:"routes"`
+}
+
+// RouteUsageSummary holds the aggregated counters of one route
+type RouteUsageSummary struct {
+	Route    string    `bson:"_id" json:"route"`
+	Requests int64     `bson:"requests" json:"requests"`
+	Tenants  []string  `bson:"tenants" json:"-"`
+	LastSeen time.Time `bson:"last_seen" json:"last_seen"`
+}
+
+func NewLegacyUsageService(db *database.MongoDB) *LegacyUsageService {
+	return &LegacyUsageService{
+		db:         db,
+		collection: db.GetCollection("legacy_route_usage"),
+		breaker:    db.Breaker("legacy_route_usage"),
+	}
+}
+
+// EnsureIndexes creates the counter lookup index and expires old daily buckets
+func (s *LegacyUsageService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "route", Value: 1}, {Key: "tenant_id", Value: 1}, {Key: "day", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "day", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(legacyUsageRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// Record counts a request to a deprecated route. Failures are logged and never fail the request.
+func (s *LegacyUsageService) Record(route, tenantID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	err := s.breaker.Do(func() error {
+		_, err := s.collection.UpdateOne(ctx,
+			bson.M{"route": route, "tenant_id": tenantID, "day": now.Truncate(24 * time.Hour)},
+			bson.M{"$inc": bson.M{"requests": 1}, "$max": bson.M{"last_seen": now}},
+			options.Update().SetUpsert(true))
+		return err
+	})
+	if err != nil && err != database.ErrCircuitOpen {
+		log.Printf("Warning: Failed to record legacy route usage for %s: %v", route, err)
+	}
+}
+
+// GetUsageReport aggregates the usage of the deprecated routes over the last days. Every
+// deprecation is listed, including those that were not used in the window.
+func (s *LegacyUsageService) GetUsageReport(deprecations []RouteDeprecation, days int) (*LegacyUsageReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if days <= 0 {
+		days = DefaultLegacyUsageDays
+	}
+	if days > MaxLegacyUsageDays {
+		days = MaxLegacyUsageDays
+	}
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"day": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$route",
+			"requests":  bson.M{"$sum": "$requests"},
+			"tenants":   bson.M{"$addToSet": "$tenant_id"},
+			"last_seen": bson.M{"$max": "$last_seen"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var routes []*RouteUsageSummary
+	if err := cursor.All(ctx, &routes); err != nil {
+		return nil, err
+	}
+
+	return &LegacyUsageReport{
+		Days:         days,
+		Since:        since,
+		Deprecations: buildDeprecatedRouteUsage(deprecations, routes),
+	}, nil
+}
+
+// buildDeprecatedRouteUsage groups route counters under the deprecation they belong to
+func buildDeprecatedRouteUsage(deprecations []RouteDeprecation, routes []*RouteUsageSummary) []*DeprecatedRouteUsage {
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Requests != routes[j].Requests {
+			return routes[i].Requests > routes[j].Requests
+		}
+		return routes[i].Route < routes[j].Route
+	})
+
+	result := make([]*DeprecatedRouteUsage, 0, len(deprecations))
+	for _, deprecation := range deprecations {
+		usage := &DeprecatedRouteUsage{RouteDeprecation: deprecation, Routes: []*RouteUsageSummary{}}
+		tenants := map[string]bool{}
+
+		for _, route := range routes {
+			if !deprecation.Matches(route.Route) {
+				continue
+			}
+			usage.Routes = append(usage.Routes, route)
+			usage.Requests += route.Requests
+			for _, tenantID := range route.Tenants {
+				if tenantID != "" {
+					tenants[tenantID] = true
+				}
+			}
+			if usage.LastSeen == nil || route.LastSeen.After(*usage.LastSeen) {
+				lastSeen := route.LastSeen
+				usage.LastSeen = &lastSeen
+			}
+		}
+
+		usage.Tenants = len(tenants)
+		result = append(result, usage)
+	}
+
+	return result
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouteDeprecationSuccessorFor(t *testing.T) {
+	deprecation := RouteDeprecation{Path: "/oauth", Successor: "/tenant/{tenantId}/oauth"}
+
+	if got := deprecation.SuccessorFor("/oauth/token", "t1"); got != "/tenant/t1/oauth/token" {
+		t.Errorf("Expected /tenant/t1/oauth/token, got %s", got)
+	}
+	if got := deprecation.SuccessorFor("/oauth", ""); got != "/tenant/{tenantId}/oauth" {
+		t.Errorf("Expected the template without a tenant, got %s", got)
+	}
+	if deprecation.Matches("/oauthx/token") {
+		t.Error("Expected /oauthx/token not to match the /oauth prefix")
+	}
+}
+
+func TestBuildDeprecatedRouteUsage(t *testing.T) {
+	now := time.Now().UTC()
+	deprecations := []RouteDeprecation{
+		{Path: "/oauth", Successor: "/tenant/{tenantId}/oauth"},
+		{Path: "/login", Successor: "/tenant/{tenantId}/login"},
+	}
+	routes := []*RouteUsageSummary{
+		{Route: "/oauth/authorize", Requests: 3, Tenants: []string{"t1"}, LastSeen: now.Add(-time.Hour)},
+		{Route: "/oauth/token", Requests: 7, Tenants: []string{"t1", "t2"}, LastSeen: now},
+		{Route: "/auth/{provider}/login", Requests: 1, Tenants: []string{"t3"}, LastSeen: now},
+	}
+
+	usage := buildDeprecatedRouteUsage(deprecations, routes)
+	if len(usage) != 2 {
+		t.Fatalf("Expected every deprecation to be listed, got %d", len(usage))
+	}
+
+	oauth := usage[0]
+	if oauth.Requests != 10 || oauth.Tenants != 2 || len(oauth.Routes) != 2 {
+		t.Errorf("Expected 10 requests from 2 tenants on 2 routes, got %d/%d/%d", oauth.Requests, oauth.Tenants, len(oauth.Routes))
+	}
+	if oauth.Routes[0].Route != "/oauth/token" {
+		t.Errorf("Expected the busiest route first, got %s", oauth.Routes[0].Route)
+	}
+	if oauth.LastSeen == nil || !oauth.LastSeen.Equal(now) {
+		t.Errorf("Expected last seen %v, got %v", now, oauth.LastSeen)
+	}
+
+	login := usage[1]
+	if login.Requests != 0 || login.LastSeen != nil || len(login.Routes) != 0 {
+		t.Errorf("Expected an unused deprecation to report no usage, got %+v", login)
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+// Bounds of the configurable lifetimes, in seconds
+const (
+	MinAuthCodeLifetime         = 30
+	MaxAuthCodeLifetime         = 1800
+	MinStateCookieLifetime      = 60
+	MaxStateCookieLifetime      = 3600
+	MinTwoFactorSessionLifetime = 60
+	MaxTwoFactorSessionLifetime = 3600
+
+	defaultFlowLifetime = 600
+)
+
+// Lifetimes are the lifetimes of the short-lived artifacts of a login: authorization codes, the
+// state cookies of social logins and two-factor sessions
+type Lifetimes struct {
+	AuthCode         time.Duration
+	StateCookie      time.Duration
+	TwoFactorSession time.Duration
+}
+
+// NewLifetimes creates platform lifetimes from seconds. Zero selects the default of 10 minutes;
+// other values are clamped into the allowed ranges.
+func NewLifetimes(authCodeSeconds, stateCookieSeconds, twoFactorSessionSeconds int) Lifetimes {
+	return Lifetimes{
+		AuthCode:         lifetimeSeconds(authCodeSeconds, defaultFlowLifetime, MinAuthCodeLifetime, MaxAuthCodeLifetime),
+		StateCookie:      lifetimeSeconds(stateCookieSeconds, defaultFlowLifetime, MinStateCookieLifetime, MaxStateCookieLifetime),
+		TwoFactorSession: lifetimeSeconds(twoFactorSessionSeconds, defaultFlowLifetime, MinTwoFactorSessionLifetime, MaxTwoFactorSessionLifetime),
+	}
+}
+
+// DefaultLifetimes returns the default lifetimes of 10 minutes each
+func DefaultLifetimes() Lifetimes {
+	return NewLifetimes(0, 0, 0)
+}
+
+// ForTenant applies a tenant's overrides to the platform lifetimes
+func (l Lifetimes) ForTenant(policy models.FlowLifetimes) Lifetimes {
+	return Lifetimes{
+		AuthCode:         lifetimeSeconds(policy.AuthCodeSeconds, int(l.AuthCode.Seconds()), MinAuthCodeLifetime, MaxAuthCodeLifetime),
+		StateCookie:      lifetimeSeconds(policy.StateCookieSeconds, int(l.StateCookie.Seconds()), MinStateCookieLifetime, MaxStateCookieLifetime),
+		TwoFactorSession: lifetimeSeconds(policy.TwoFactorSessionSeconds, int(l.TwoFactorSession.Seconds()), MinTwoFactorSessionLifetime, MaxTwoFactorSessionLifetime),
+	}
+}
+
+func lifetimeSeconds(seconds, fallback, min, max int) time.Duration {
+	if seconds <= 0 {
+		seconds = fallback
+	}
+	if seconds < min {
+		seconds = min
+	}
+	if seconds > max {
+		seconds = max
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+func TestNewLifetimes(t *testing.T) {
+	defaults := DefaultLifetimes()
+	if defaults.AuthCode != 10*time.Minute || defaults.StateCookie != 10*time.Minute || defaults.TwoFactorSession != 10*time.Minute {
+		t.Errorf("Expected 10 minute defaults, got %+v", defaults)
+	}
+
+	lifetimes := NewLifetimes(5, 7200, 300)
+	if lifetimes.AuthCode != MinAuthCodeLifetime*time.Second {
+		t.Errorf("Expected the auth code lifetime to be raised to the minimum, got %v", lifetimes.AuthCode)
+	}
+	if lifetimes.StateCookie != MaxStateCookieLifetime*time.Second {
+		t.Errorf("Expected the state cookie lifetime to be capped, got %v", lifetimes.StateCookie)
+	}
+	if lifetimes.TwoFactorSession != 5*time.Minute {
+		t.Errorf("Expected the configured two-factor lifetime, got %v", lifetimes.TwoFactorSession)
+	}
+}
+
+func TestLifetimesForTenant(t *testing.T) {
+	platform := NewLifetimes(300, 600, 600)
+
+	tenant := platform.ForTenant(models.FlowLifetimes{AuthCodeSeconds: 60, TwoFactorSessionSeconds: 1800})
+	if tenant.AuthCode != time.Minute {
+		t.Errorf("Expected the tenant's auth code lifetime, got %v", tenant.AuthCode)
+	}
+	if tenant.StateCookie != 10*time.Minute {
+		t.Errorf("Expected the platform state cookie lifetime without an override, got %v", tenant.StateCookie)
+	}
+	if tenant.TwoFactorSession != 30*time.Minute {
+		t.Errorf("Expected the extended two-factor lifetime, got %v", tenant.TwoFactorSession)
+	}
+
+	if capped := platform.ForTenant(models.FlowLifetimes{AuthCodeSeconds: 86400}); capped.AuthCode != MaxAuthCodeLifetime*time.Second {
+		t.Errorf("Expected tenant overrides to be capped, got %v", capped.AuthCode)
+	}
+}
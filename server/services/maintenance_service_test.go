@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+func TestMaintenanceModeInEffect(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+
+	tests := []struct {
+		name string
+		mode *models.MaintenanceMode
+		want bool
+	}{
+		{"nil", nil, false},
+		{"disabled", &models.MaintenanceMode{}, false},
+		{"enabled", &models.MaintenanceMode{Enabled: true}, true},
+		{"until later", &models.MaintenanceMode{Enabled: true, EndsAt: &future}, true},
+		{"ended", &models.MaintenanceMode{Enabled: true, EndsAt: &past}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.mode.InEffect(now); got != tt.want {
+			t.Errorf("%s: InEffect() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestActiveMaintenance(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	global := &models.MaintenanceMode{Scope: models.MaintenanceScopeGlobal, Enabled: true, Message: "global"}
+	tenant := &models.MaintenanceMode{Scope: "tenant-1", Enabled: true, Message: "tenant"}
+	ended := &models.MaintenanceMode{Scope: models.MaintenanceScopeGlobal, Enabled: true, EndsAt: &past}
+
+	if got := activeMaintenance(nil, now); got != nil {
+		t.Errorf("activeMaintenance() without modes = %v, want nil", got)
+	}
+	if got := activeMaintenance([]*models.MaintenanceMode{tenant, global}, now); got != global {
+		t.Errorf("activeMaintenance() = %v, want the global mode", got)
+	}
+	if got := activeMaintenance([]*models.MaintenanceMode{ended, tenant}, now); got != tenant {
+		t.Errorf("activeMaintenance() with an ended global mode = %v, want the tenant mode", got)
+	}
+}
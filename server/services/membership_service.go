@@ -0,0 +1,649 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MembershipService keeps User.Groups and Group.Members consistent.
+//
+// User.Groups holds group IDs and is the source of truth. Group.Members is derived from it
+// and lists the IDs of the active users in the group, so deactivated users drop out of
+// member lists but regain their groups when reactivated.
+type MembershipService struct {
+	db     *database.MongoDB
+	users  *mongo.Collection
+	groups *mongo.Collection
+}
+
+// MembershipReport summarizes the changes made by a reconciliation run
+type MembershipReport struct {
+	UsersUpdated  int `json:"users_updated"`
+	GroupsUpdated int `json:"groups_updated"`
+}
+
+var ErrMembershipExpiryInPast = errors.New("the membership expiry must be in the future")
+
+func NewMembershipService(db *database.MongoDB) *MembershipService {
+	return &MembershipService{
+		db:     db,
+		users:  db.GetCollection("users"),
+		groups: db.GetCollection("groups"),
+	}
+}
+
+// ResolveGroupIDs converts group references (IDs or names) into group IDs within the tenant
+func (s *MembershipService) ResolveGroupIDs(tenantID string, refs []string) ([]string, error) {
+	groupService := NewGroupService(s.db)
+
+	ids := []string{}
+	for _, ref := range refs {
+		group, err := groupService.GetGroupByID(ref, tenantID)
+		if err != nil {
+			group, err = groupService.GetGroupByName(ref, tenantID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unknown group: %s", ref)
+		}
+		if !containsString(ids, group.ID.Hex()) {
+			ids = append(ids, group.ID.Hex())
+		}
+	}
+
+	return ids, nil
+}
+
+// GetGroupNames maps group IDs to group names, skipping groups that no longer exist
+func (s *MembershipService) GetGroupNames(tenantID string, groupIDs []string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	names := []string{}
+
+	objIDs := make([]primitive.ObjectID, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	if len(objIDs) == 0 {
+		return names
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": objIDs}}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	cursor, err := s.groups.Find(ctx, filter, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return names
+	}
+	defer cursor.Close(ctx)
+
+	var groups []*models.Group
+	if err := cursor.All(ctx, &groups); err != nil {
+		return names
+	}
+
+	byID := make(map[string]string, len(groups))
+	for _, group := range groups {
+		byID[group.ID.Hex()] = group.Name
+	}
+	for _, id := range groupIDs {
+		if name, ok := byID[id]; ok {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// SyncUser updates group member lists to match the user's groups and active state
+func (s *MembershipService) SyncUser(user *models.User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.syncUser(ctx, user)
+}
+
+func (s *MembershipService) syncUser(ctx context.Context, user *models.User) error {
+	userID := user.ID.Hex()
+	now := time.Now()
+
+	objIDs := make([]primitive.ObjectID, 0, len(user.Groups))
+	for _, id := range user.Groups {
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+
+	pullFilter := bson.M{"tenant_id": user.TenantID, "members": userID}
+	if user.Active {
+		pullFilter["_id"] = bson.M{"$nin": objIDs}
+	}
+	if _, err := s.groups.UpdateMany(ctx, pullFilter, bson.M{
+		"$pull": bson.M{"members": userID},
+		"$set":  bson.M{"updated_at": now},
+		"$inc":  bson.M{"version": 1},
+	}); err != nil {
+		return err
+	}
+
+	if !user.Active || len(objIDs) == 0 {
+		return nil
+	}
+
+	_, err := s.groups.UpdateMany(ctx, bson.M{
+		"tenant_id": user.TenantID,
+		"_id":       bson.M{"$in": objIDs},
+		"members":   bson.M{"$ne": userID},
+	}, bson.M{
+		"$addToSet": bson.M{"members": userID},
+		"$set":      bson.M{"updated_at": now},
+		"$inc":      bson.M{"version": 1},
+	})
+	return err
+}
+
+// SyncUserByID reloads a user and syncs its group memberships
+func (s *MembershipService) SyncUserByID(userID, tenantID string) error {
+	user, err := NewUserService(s.db).GetUserByIDAndTenant(userID, tenantID)
+	if err != nil {
+		return err
+	}
+	return s.SyncUser(user)
+}
+
+// RemoveUser removes a deleted user from every group member list in the tenant
+func (s *MembershipService) RemoveUser(userID, tenantID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.removeUser(ctx, userID, tenantID)
+}
+
+func (s *MembershipService) removeUser(ctx context.Context, userID, tenantID string) error {
+	_, err := s.groups.UpdateMany(ctx, bson.M{"tenant_id": tenantID, "members": userID}, bson.M{
+		"$pull": bson.M{"members": userID},
+		"$set":  bson.M{"updated_at": time.Now()},
+		"$inc":  bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Application assignments of the user go with it
+	_, err = s.db.GetCollection("clients").UpdateMany(ctx, bson.M{"tenant_id": tenantID, "assigned_users": userID}, bson.M{
+		"$pull": bson.M{"assigned_users": userID},
+	})
+	return err
+}
+
+// RemoveGroup removes a deleted group from every user in the tenant
+func (s *MembershipService) RemoveGroup(groupID, tenantID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"groups": groupID}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	_, err := s.users.UpdateMany(ctx, filter, bson.M{
+		"$pull": bson.M{"groups": groupID, "group_expirations": bson.M{"group_id": groupID}},
+		"$set":  bson.M{"updated_at": time.Now()},
+		"$inc":  bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	// So do application assignments of the group
+	delete(filter, "groups")
+	filter["assigned_groups"] = groupID
+	_, err = s.db.GetCollection("clients").UpdateMany(ctx, filter, bson.M{
+		"$pull": bson.M{"assigned_groups": groupID},
+	})
+	return err
+}
+
+// AddMember adds a user to a group
+func (s *MembershipService) AddMember(groupID, userID, tenantID string) error {
+	return s.AddMemberUntil(groupID, userID, tenantID, nil)
+}
+
+// AddMemberUntil adds a user to a group until expiresAt, or for good when it is nil. Adding a
+// member again replaces the expiry.
+func (s *MembershipService) AddMemberUntil(groupID, userID, tenantID string, expiresAt *time.Time) error {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return ErrMembershipExpiryInPast
+	}
+
+	user, err := s.getGroupMember(groupID, userID, tenantID)
+	if err != nil {
+		return err
+	}
+
+	if !containsString(user.Groups, groupID) {
+		user.Groups = append(user.Groups, groupID)
+	}
+	user.GroupExpirations = withoutGroupExpiration(user.GroupExpirations, groupID)
+	if expiresAt != nil {
+		user.GroupExpirations = append(user.GroupExpirations, models.GroupExpiration{GroupID: groupID, ExpiresAt: *expiresAt})
+	}
+	return s.setUserGroups(user)
+}
+
+// RemoveMember removes a user from a group
+func (s *MembershipService) RemoveMember(groupID, userID, tenantID string) error {
+	user, err := s.getGroupMember(groupID, userID, tenantID)
+	if err != nil {
+		return err
+	}
+
+	groups := []string{}
+	for _, id := range user.Groups {
+		if id != groupID {
+			groups = append(groups, id)
+		}
+	}
+	user.Groups = groups
+	user.GroupExpirations = withoutGroupExpiration(user.GroupExpirations, groupID)
+	return s.setUserGroups(user)
+}
+
+// SetGroupMembers makes the given users the complete membership of a group
+func (s *MembershipService) SetGroupMembers(groupID, tenantID string, userIDs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := NewGroupService(s.db).GetGroupByID(groupID, tenantID); err != nil {
+		return err
+	}
+
+	objIDs := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, id := range userIDs {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return fmt.Errorf("invalid user ID: %s", id)
+		}
+		objIDs = append(objIDs, objID)
+	}
+
+	count, err := s.users.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": objIDs}, "tenant_id": tenantID})
+	if err != nil {
+		return err
+	}
+	if int(count) != len(uniqueObjectIDs(objIDs)) {
+		return errors.New("one or more users not found")
+	}
+
+	now := time.Now()
+	if _, err := s.users.UpdateMany(ctx, bson.M{
+		"tenant_id": tenantID,
+		"groups":    groupID,
+		"_id":       bson.M{"$nin": objIDs},
+	}, bson.M{
+		"$pull": bson.M{"groups": groupID, "group_expirations": bson.M{"group_id": groupID}},
+		"$set":  bson.M{"updated_at": now},
+		"$inc":  bson.M{"version": 1},
+	}); err != nil {
+		return err
+	}
+
+	if len(objIDs) > 0 {
+		if _, err := s.users.UpdateMany(ctx, bson.M{
+			"tenant_id": tenantID,
+			"_id":       bson.M{"$in": objIDs},
+			"groups":    bson.M{"$ne": groupID},
+		}, bson.M{
+			"$addToSet": bson.M{"groups": groupID},
+			"$set":      bson.M{"updated_at": now},
+			"$inc":      bson.M{"version": 1},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return s.syncGroup(ctx, groupID, tenantID)
+}
+
+// Reconcile repairs memberships in a tenant (all tenants when tenantID is empty):
+// dangling group IDs are dropped from users and member lists are rebuilt from the users.
+func (s *MembershipService) Reconcile(tenantID string) (*MembershipReport, error) {
+	return s.reconcile(tenantID, false)
+}
+
+// MigrateGroupReferences converts legacy data to the ID-based model. Group names stored on
+// users are resolved to IDs and users listed only in a group's member list are added to it.
+func (s *MembershipService) MigrateGroupReferences() (*MembershipReport, error) {
+	return s.reconcile("", true)
+}
+
+func (s *MembershipService) reconcile(tenantID string, migrateLegacy bool) (*MembershipReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	filter := bson.M{}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	groupCursor, err := s.groups.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*models.Group
+	if err := groupCursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	userCursor, err := s.users.Find(ctx, filter, options.Find().SetProjection(bson.M{"tenant_id": 1, "groups": 1, "active": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []*models.User
+	if err := userCursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	report := &MembershipReport{}
+	now := time.Now()
+
+	var listedIn map[string][]*models.Group
+	if migrateLegacy {
+		listedIn = make(map[string][]*models.Group)
+		for _, group := range groups {
+			for _, member := range group.Members {
+				listedIn[member] = append(listedIn[member], group)
+			}
+		}
+	}
+
+	for _, user := range users {
+		groupIDs := normalizeUserGroups(user, groups, listedIn[user.ID.Hex()], migrateLegacy)
+		if stringSetsEqual(groupIDs, user.Groups) && len(groupIDs) == len(user.Groups) {
+			continue
+		}
+
+		if _, err := s.users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+			"$set": bson.M{"groups": groupIDs, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}); err != nil {
+			return report, err
+		}
+		user.Groups = groupIDs
+		report.UsersUpdated++
+	}
+
+	members := expectedGroupMembers(users)
+	for _, group := range groups {
+		expected := members[group.ID.Hex()]
+		if expected == nil {
+			expected = []string{}
+		}
+		if stringSetsEqual(expected, group.Members) && len(expected) == len(group.Members) {
+			continue
+		}
+
+		if _, err := s.groups.UpdateOne(ctx, bson.M{"_id": group.ID}, bson.M{
+			"$set": bson.M{"members": expected, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}); err != nil {
+			return report, err
+		}
+		report.GroupsUpdated++
+	}
+
+	if report.UsersUpdated > 0 || report.GroupsUpdated > 0 {
+		log.Printf("Group membership reconciliation updated %d users and %d groups", report.UsersUpdated, report.GroupsUpdated)
+	}
+
+	return report, nil
+}
+
+// EnsureIndexes creates the index used to find expired memberships
+func (s *MembershipService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "group_expirations.expires_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// ExpireMemberships removes users from the groups whose membership expired and records a
+// group_member_expired audit event for each. It returns the number of memberships removed.
+func (s *MembershipService) ExpireMemberships(auditService *AuditService) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := s.users.Find(ctx, bson.M{"group_expirations.expires_at": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+	var users []*models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, user := range users {
+		expired := []string{}
+		kept := []models.GroupExpiration{}
+		for _, expiration := range user.GroupExpirations {
+			if expiration.ExpiresAt.After(now) {
+				kept = append(kept, expiration)
+			} else {
+				expired = append(expired, expiration.GroupID)
+			}
+		}
+
+		groups := []string{}
+		for _, id := range user.Groups {
+			if !containsString(expired, id) {
+				groups = append(groups, id)
+			}
+		}
+
+		// The user's version guards against a concurrent change, e.g. the membership being extended
+		result, err := s.users.UpdateOne(ctx, bson.M{"_id": user.ID, "version": user.Version}, bson.M{
+			"$set": bson.M{"groups": groups, "group_expirations": kept, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			return removed, err
+		}
+		if result.MatchedCount == 0 {
+			continue
+		}
+		user.Groups = groups
+		if err := s.SyncUser(user); err != nil {
+			return removed, err
+		}
+
+		for _, groupID := range expired {
+			removed++
+			if auditService != nil {
+				auditService.Record(&models.AuditEvent{TenantID: user.TenantID, Type: models.AuditEventGroupMemberExpired, UserID: user.ID.Hex(), Target: groupID})
+			}
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d expired group memberships", removed)
+	}
+	return removed, nil
+}
+
+func (s *MembershipService) getGroupMember(groupID, userID, tenantID string) (*models.User, error) {
+	if _, err := NewGroupService(s.db).GetGroupByID(groupID, tenantID); err != nil {
+		return nil, err
+	}
+
+	user, err := NewUserService(s.db).GetUserByIDAndTenant(userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *MembershipService) setUserGroups(user *models.User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	expirations := user.GroupExpirations
+	if expirations == nil {
+		expirations = []models.GroupExpiration{}
+	}
+	if _, err := s.users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"groups": user.Groups, "group_expirations": expirations, "updated_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}); err != nil {
+		return err
+	}
+
+	return s.SyncUser(user)
+}
+
+// syncGroup rebuilds a single group's member list from its active users
+func (s *MembershipService) syncGroup(ctx context.Context, groupID, tenantID string) error {
+	objID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := s.users.Find(ctx, bson.M{"tenant_id": tenantID, "groups": groupID, "active": true},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var users []*models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	members := make([]string, 0, len(users))
+	for _, user := range users {
+		members = append(members, user.ID.Hex())
+	}
+	sort.Strings(members)
+
+	_, err = s.groups.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"members": members, "updated_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
+	return err
+}
+
+// normalizeUserGroups returns the user's valid group IDs, dropping references to groups that
+// do not exist in the user's tenant. When migrating, group names are resolved to IDs and the
+// groups that list the user as a member are included.
+func normalizeUserGroups(user *models.User, groups []*models.Group, listedIn []*models.Group, migrateLegacy bool) []string {
+	byID := make(map[string]*models.Group, len(groups))
+	byName := make(map[string]*models.Group, len(groups))
+	for _, group := range groups {
+		if group.TenantID != user.TenantID {
+			continue
+		}
+		byID[group.ID.Hex()] = group
+		byName[group.Name] = group
+	}
+
+	result := []string{}
+	add := func(id string) {
+		if !containsString(result, id) {
+			result = append(result, id)
+		}
+	}
+
+	for _, ref := range user.Groups {
+		if _, ok := byID[ref]; ok {
+			add(ref)
+		} else if group, ok := byName[ref]; ok && migrateLegacy {
+			add(group.ID.Hex())
+		}
+	}
+
+	if migrateLegacy {
+		for _, group := range listedIn {
+			if _, ok := byID[group.ID.Hex()]; ok {
+				add(group.ID.Hex())
+			}
+		}
+	}
+
+	return result
+}
+
+// expectedGroupMembers derives each group's member list from the active users' group IDs
+func expectedGroupMembers(users []*models.User) map[string][]string {
+	members := make(map[string][]string)
+	for _, user := range users {
+		if !user.Active {
+			continue
+		}
+		for _, groupID := range user.Groups {
+			members[groupID] = append(members[groupID], user.ID.Hex())
+		}
+	}
+	for groupID := range members {
+		sort.Strings(members[groupID])
+	}
+	return members
+}
+
+// withoutGroupExpiration returns the expirations without the group's
+func withoutGroupExpiration(expirations []models.GroupExpiration, groupID string) []models.GroupExpiration {
+	result := []models.GroupExpiration{}
+	for _, expiration := range expirations {
+		if expiration.GroupID != groupID {
+			result = append(result, expiration)
+		}
+	}
+	return result
+}
+
+// stringSetsEqual reports whether a and b contain the same values, ignoring order and duplicates
+func stringSetsEqual(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, v := range a {
+		set[v] = true
+	}
+	other := make(map[string]bool, len(b))
+	for _, v := range b {
+		if !set[v] {
+			return false
+		}
+		other[v] = true
+	}
+	return len(set) == len(other)
+}
+
+func uniqueObjectIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	result := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
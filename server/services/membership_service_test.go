@@ -0,0 +1,69 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeUserGroups(t *testing.T) {
+	admins := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Administrators"}
+	readers := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Read Only"}
+	foreign := &models.Group{ID: primitive.NewObjectID(), TenantID: "t2", Name: "Administrators"}
+	groups := []*models.Group{admins, readers, foreign}
+
+	user := &models.User{
+		ID:       primitive.NewObjectID(),
+		TenantID: "t1",
+		Groups:   []string{"Administrators", readers.ID.Hex(), foreign.ID.Hex(), primitive.NewObjectID().Hex(), readers.ID.Hex()},
+	}
+
+	got := normalizeUserGroups(user, groups, nil, false)
+	if want := []string{readers.ID.Hex()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v without migration, got %v", want, got)
+	}
+
+	got = normalizeUserGroups(user, groups, []*models.Group{foreign}, true)
+	if want := []string{admins.ID.Hex(), readers.ID.Hex()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v with migration, got %v", want, got)
+	}
+}
+
+func TestNormalizeUserGroupsMergesLegacyMemberLists(t *testing.T) {
+	group := &models.Group{ID: primitive.NewObjectID(), TenantID: "t1", Name: "Standard Users"}
+	user := &models.User{ID: primitive.NewObjectID(), TenantID: "t1"}
+
+	got := normalizeUserGroups(user, []*models.Group{group}, []*models.Group{group}, true)
+	if want := []string{group.ID.Hex()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestExpectedGroupMembersSkipsInactiveUsers(t *testing.T) {
+	active := &models.User{ID: primitive.NewObjectID(), Active: true, Groups: []string{"g1", "g2"}}
+	inactive := &models.User{ID: primitive.NewObjectID(), Active: false, Groups: []string{"g1"}}
+
+	members := expectedGroupMembers([]*models.User{active, inactive})
+
+	if want := []string{active.ID.Hex()}; !reflect.DeepEqual(members["g1"], want) {
+		t.Errorf("Expected g1 members %v, got %v", want, members["g1"])
+	}
+	if len(members["g2"]) != 1 {
+		t.Errorf("Expected one g2 member, got %v", members["g2"])
+	}
+}
+
+func TestStringSetsEqual(t *testing.T) {
+	if !stringSetsEqual([]string{"a", "b"}, []string{"b", "a"}) {
+		t.Error("Expected sets with different order to be equal")
+	}
+	if stringSetsEqual([]string{"a"}, []string{"a", "b"}) {
+		t.Error("Expected sets with different values to differ")
+	}
+	if !stringSetsEqual(nil, []string{}) {
+		t.Error("Expected empty sets to be equal")
+	}
+}
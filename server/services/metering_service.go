@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	meteringExportBatchSize = 500
+	// metering events are kept for a little over a year so invoices can be re-run
+	meteringRetention = 400 * 24 * time.Hour
+)
+
+// MeteringExporter delivers metering events to a billing system
+type MeteringExporter interface {
+	Export(events []*models.MeteringEvent) error
+}
+
+// MeteringService records billable usage events per tenant and exports them to the billing
+// system. Recording never fails the calling flow; events stay in the outbox until every
+// exporter has accepted them.
+type MeteringService struct {
+	db         *database.MongoDB
+	collection *mongo.Collection
+	exporters  []MeteringExporter
+	breaker    *database.CircuitBreaker // suspends metering while MongoDB times out
+}
+
+// MeteringRollup is a tenant's billable usage in a calendar month
+type MeteringRollup struct {
+	TenantID         string `json:"tenant_id"`
+	Period           string `json:"period"`
+	ActiveUsers      int64  `json:"active_users"`
+	TokensIssued     int64  `json:"tokens_issued"`
+	MFAVerifications int64  `json:"mfa_verifications"`
+}
+
+func NewMeteringService(db *database.MongoDB, exporters ...MeteringExporter) *MeteringService {
+	return &MeteringService{
+		db:         db,
+		collection: db.GetCollection("metering_events"),
+		exporters:  exporters,
+		breaker:    db.Breaker("metering_events"),
+	}
+}
+
+// EnsureIndexes creates the indexes for rollups, the export outbox and the monthly active user
+// de-duplication, and expires old events
+func (s *MeteringService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "period", Value: 1}, {Key: "tenant_id", Value: 1}, {Key: "type", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "exported_at", Value: 1}, {Key: "occurred_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "period", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"type": models.MeteringEventActiveUser}),
+		},
+		{
+			Keys:    bson.D{{Key: "occurred_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(meteringRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// RecordTokenIssued meters an access token issued to a user and marks the user active for the month
+func (s *MeteringService) RecordTokenIssued(tenantID, clientID, userID string) {
+	if tenantID == "" {
+		return
+	}
+
+	s.insert(&models.MeteringEvent{
+		TenantID: tenantID,
+		Type:     models.MeteringEventTokenIssued,
+		UserID:   userID,
+		ClientID: clientID,
+	})
+	s.recordActiveUser(tenantID, userID)
+}
+
+// RecordMFAVerification meters a successful second factor verification
+func (s *MeteringService) RecordMFAVerification(tenantID, userID, method string) {
+	if tenantID == "" {
+		return
+	}
+
+	s.insert(&models.MeteringEvent{
+		TenantID: tenantID,
+		Type:     models.MeteringEventMFAVerification,
+		UserID:   userID,
+		Method:   method,
+	})
+}
+
+// recordActiveUser emits a user.active event the first time a user is seen in a month
+func (s *MeteringService) recordActiveUser(tenantID, userID string) {
+	if userID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	period, _, _ := usagePeriod(now)
+
+	err := s.breaker.Do(func() error {
+		_, err := s.collection.UpdateOne(ctx, bson.M{
+			"tenant_id": tenantID,
+			"user_id":   userID,
+			"period":    period,
+			"type":      models.MeteringEventActiveUser,
+		}, bson.M{
+			"$setOnInsert": bson.M{"occurred_at": now},
+		}, options.Update().SetUpsert(true))
+		return err
+	})
+	if err != nil && err != database.ErrCircuitOpen && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Warning: Failed to record active user %s for tenant %s: %v", userID, tenantID, err)
+	}
+}
+
+func (s *MeteringService) insert(event *models.MeteringEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	event.OccurredAt = time.Now().UTC()
+	event.Period, _, _ = usagePeriod(event.OccurredAt)
+
+	err := s.breaker.Do(func() error {
+		_, err := s.collection.InsertOne(ctx, event)
+		return err
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to record %s metering event for tenant %s: %v", event.Type, event.TenantID, err)
+	}
+}
+
+// ExportPending delivers events that have not been exported yet, oldest first, and returns how
+// many were exported. It does nothing if no exporter is configured.
+func (s *MeteringService) ExportPending() (int, error) {
+	if len(s.exporters) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	exported := 0
+	for {
+		cursor, err := s.collection.Find(ctx, bson.M{"exported_at": bson.M{"$exists": false}},
+			options.Find().SetSort(bson.D{{Key: "occurred_at", Value: 1}}).SetLimit(meteringExportBatchSize))
+		if err != nil {
+			return exported, err
+		}
+
+		var events []*models.MeteringEvent
+		if err := cursor.All(ctx, &events); err != nil {
+			return exported, err
+		}
+		if len(events) == 0 {
+			return exported, nil
+		}
+
+		for _, exporter := range s.exporters {
+			if err := exporter.Export(events); err != nil {
+				return exported, err
+			}
+		}
+
+		ids := make([]interface{}, len(events))
+		for i, event := range events {
+			ids[i] = event.ID
+		}
+		if _, err := s.collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{
+			"$set": bson.M{"exported_at": time.Now().UTC()},
+		}); err != nil {
+			return exported, err
+		}
+
+		exported += len(events)
+		if len(events) < meteringExportBatchSize {
+			return exported, nil
+		}
+	}
+}
+
+// GetRollups returns the billable usage per tenant for a period ("YYYY-MM", empty for the
+// current month). If tenantID is set only that tenant is included.
+func (s *MeteringService) GetRollups(tenantID, period string) ([]*MeteringRollup, error) {
+	start, err := parseUsagePeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	period, _, _ = usagePeriod(start)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	match := bson.M{"period": period}
+	if tenantID != "" {
+		match["tenant_id"] = tenantID
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"tenant_id": "$tenant_id", "type": "$type"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []meteringCount
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	return buildMeteringRollups(period, counts), nil
+}
+
+type meteringCount struct {
+	Key struct {
+		TenantID string `bson:"tenant_id"`
+		Type     string `bson:"type"`
+	} `bson:"_id"`
+	Count int64 `bson:"count"`
+}
+
+// buildMeteringRollups folds per-type event counts into one rollup per tenant, ordered by tenant
+func buildMeteringRollups(period string, counts []meteringCount) []*MeteringRollup {
+	byTenant := map[string]*MeteringRollup{}
+	rollups := []*MeteringRollup{}
+	for _, count := range counts {
+		rollup, ok := byTenant[count.Key.TenantID]
+		if !ok {
+			rollup = &MeteringRollup{TenantID: count.Key.TenantID, Period: period}
+			byTenant[count.Key.TenantID] = rollup
+			rollups = append(rollups, rollup)
+		}
+
+		switch count.Key.Type {
+		case models.MeteringEventActiveUser:
+			rollup.ActiveUsers += count.Count
+		case models.MeteringEventTokenIssued:
+			rollup.TokensIssued += count.Count
+		case models.MeteringEventMFAVerification:
+			rollup.MFAVerifications += count.Count
+		}
+	}
+
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].TenantID < rollups[j].TenantID
+	})
+	return rollups
+}
+
+// WebhookMeteringExporter posts batches of metering events as JSON to an HTTP endpoint
+type WebhookMeteringExporter struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewWebhookMeteringExporter creates an exporter that posts {"events": [...]} to the given URL
+func NewWebhookMeteringExporter(url string) *WebhookMeteringExporter {
+	return &WebhookMeteringExporter{
+		url:        url,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Export posts the events to the webhook URL
+func (e *WebhookMeteringExporter) Export(events []*models.MeteringEvent) error {
+	return postMeteringJSON(e.httpClient, e.url, "application/json", map[string]interface{}{
+		"events": events,
+	})
+}
+
+// KafkaMeteringExporter produces metering events to a Kafka topic through a Kafka REST proxy
+// (v2 API), keyed by tenant so a tenant's events stay ordered within a partition
+type KafkaMeteringExporter struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewKafkaMeteringExporter creates an exporter for the REST proxy at restURL and the given topic
+func NewKafkaMeteringExporter(restURL, topic string) *KafkaMeteringExporter {
+	return &KafkaMeteringExporter{
+		url:        strings.TrimRight(restURL, "/") + "/topics/" + url.PathEscape(topic),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type kafkaRecord struct {
+	Key   string                `json:"key"`
+	Value *models.MeteringEvent `json:"value"`
+}
+
+// Export produces one record per event
+func (e *KafkaMeteringExporter) Export(events []*models.MeteringEvent) error {
+	records := make([]kafkaRecord, len(events))
+	for i, event := range events {
+		records[i] = kafkaRecord{Key: event.TenantID, Value: event}
+	}
+
+	return postMeteringJSON(e.httpClient, e.url, "application/vnd.kafka.json.v2+json", map[string]interface{}{
+		"records": records,
+	})
+}
+
+func postMeteringJSON(httpClient *http.Client, endpoint, contentType string, payload interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("metering exporter %s returned status %d", endpoint, resp.StatusCode)
+	}
+	return nil
+}
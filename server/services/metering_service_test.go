@@ -0,0 +1,87 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func meteringCountOf(tenantID, eventType string, count int64) meteringCount {
+	var c meteringCount
+	c.Key.TenantID = tenantID
+	c.Key.Type = eventType
+	c.Count = count
+	return c
+}
+
+func TestBuildMeteringRollups(t *testing.T) {
+	rollups := buildMeteringRollups("2026-10", []meteringCount{
+		meteringCountOf("t2", models.MeteringEventTokenIssued, 5),
+		meteringCountOf("t1", models.MeteringEventActiveUser, 3),
+		meteringCountOf("t1", models.MeteringEventTokenIssued, 40),
+		meteringCountOf("t1", models.MeteringEventMFAVerification, 7),
+	})
+
+	if len(rollups) != 2 {
+		t.Fatalf("Expected one rollup per tenant, got %d", len(rollups))
+	}
+	t1 := rollups[0]
+	if t1.TenantID != "t1" || t1.Period != "2026-10" {
+		t.Fatalf("Expected rollups ordered by tenant, got %+v", t1)
+	}
+	if t1.ActiveUsers != 3 || t1.TokensIssued != 40 || t1.MFAVerifications != 7 {
+		t.Errorf("Unexpected counts for t1: %+v", t1)
+	}
+	if rollups[1].TokensIssued != 5 || rollups[1].ActiveUsers != 0 {
+		t.Errorf("Unexpected counts for t2: %+v", rollups[1])
+	}
+}
+
+func TestKafkaMeteringExporter(t *testing.T) {
+	var contentType, path string
+	var payload struct {
+		Records []struct {
+			Key   string               `json:"key"`
+			Value models.MeteringEvent `json:"value"`
+		} `json:"records"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		path = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	exporter := NewKafkaMeteringExporter(server.URL+"/", "metering")
+	err := exporter.Export([]*models.MeteringEvent{
+		{TenantID: "t1", Type: models.MeteringEventTokenIssued, Period: "2026-10"},
+	})
+	if err != nil {
+		t.Fatalf("Expected export to succeed, got %v", err)
+	}
+
+	if path != "/topics/metering" || contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("Unexpected request to %s with content type %s", path, contentType)
+	}
+	if len(payload.Records) != 1 || payload.Records[0].Key != "t1" || payload.Records[0].Value.Type != models.MeteringEventTokenIssued {
+		t.Errorf("Expected one record keyed by tenant, got %+v", payload.Records)
+	}
+}
+
+func TestWebhookMeteringExporterFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := NewWebhookMeteringExporter(server.URL).Export([]*models.MeteringEvent{{TenantID: "t1"}})
+	if err == nil {
+		t.Error("Expected an error so the events stay in the outbox")
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMFARequirementMandated(t *testing.T) {
+	tests := []struct {
+		requirement MFARequirement
+		want        bool
+	}{
+		{MFARequirement{}, false},
+		{MFARequirement{Required: true, Source: MFASourceUser}, false},
+		{MFARequirement{Required: true, Source: MFASourceTenant}, true},
+		{MFARequirement{Required: true, Source: MFASourceGroup, Subject: "Administrators"}, true},
+		{MFARequirement{Required: true, Source: MFASourceClient, Subject: "billing"}, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.requirement.Mandated(); got != tt.want {
+			t.Errorf("%+v.Mandated() = %v, want %v", tt.requirement, got, tt.want)
+		}
+	}
+}
+
+// TestEvaluateMFAPolicyPrecedence checks that a client policy wins over a group policy, which
+// wins over the tenant setting and the user's own enrollment.
+func TestEvaluateMFAPolicyPrecedence(t *testing.T) {
+	db := dbtest.New(t)
+	now := time.Now()
+
+	tenantID := primitive.NewObjectID()
+	dbtest.Insert(t, db, "tenants", &models.Tenant{ID: tenantID, Name: "Acme", Active: true, Settings: models.TenantSettings{RequireTwoFactor: true}, CreatedAt: now, UpdatedAt: now})
+
+	adminsID, staffID := primitive.NewObjectID(), primitive.NewObjectID()
+	dbtest.Insert(t, db, "groups",
+		&models.Group{ID: adminsID, TenantID: tenantID.Hex(), Name: "Administrators", RequireMFA: true, CreatedAt: now, UpdatedAt: now},
+		&models.Group{ID: staffID, TenantID: tenantID.Hex(), Name: "Staff", CreatedAt: now, UpdatedAt: now},
+	)
+	dbtest.Insert(t, db, "clients",
+		&models.Client{ID: primitive.NewObjectID(), TenantID: tenantID.Hex(), ClientID: "billing", RequireMFA: true, Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.Client{ID: primitive.NewObjectID(), TenantID: tenantID.Hex(), ClientID: "wiki", Active: true, CreatedAt: now, UpdatedAt: now},
+	)
+
+	admin, staff, other := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	otherTenant := primitive.NewObjectID().Hex()
+	dbtest.Insert(t, db, "users",
+		&models.User{ID: admin, TenantID: tenantID.Hex(), Email: "admin@example.com", Groups: []string{staffID.Hex(), adminsID.Hex()}, TwoFactorEnabled: true, Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.User{ID: staff, TenantID: tenantID.Hex(), Email: "staff@example.com", Groups: []string{staffID.Hex()}, Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.User{ID: other, TenantID: otherTenant, Email: "other@example.com", TwoFactorEnabled: true, Active: true, CreatedAt: now, UpdatedAt: now},
+	)
+
+	service := NewTwoFactorService(db, DefaultLifetimes())
+
+	tests := []struct {
+		name     string
+		userID   string
+		clientID string
+		want     MFARequirement
+	}{
+		{"client policy wins", admin.Hex(), "billing", MFARequirement{Required: true, Source: MFASourceClient, Subject: "billing", Enrolled: true}},
+		{"group policy", admin.Hex(), "wiki", MFARequirement{Required: true, Source: MFASourceGroup, Subject: "Administrators", Enrolled: true}},
+		{"tenant setting", staff.Hex(), "wiki", MFARequirement{Required: true, Source: MFASourceTenant}},
+		{"user enrollment", other.Hex(), "", MFARequirement{Required: true, Source: MFASourceUser, Enrolled: true}},
+	}
+
+	for _, tt := range tests {
+		got, err := service.EvaluateMFAPolicy(tt.userID, tt.clientID)
+		if err != nil {
+			t.Fatalf("%s: EvaluateMFAPolicy returned error: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
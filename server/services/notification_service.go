@@ -0,0 +1,105 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notification is a message delivered to a user or operator through a Notifier
+type Notification struct {
+	Type      string                 `json:"type"`
+	TenantID  string                 `json:"tenant_id"`
+	Recipient string                 `json:"recipient"`
+	Subject   string                 `json:"subject"`
+	Message   string                 `json:"message"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// Notifier delivers notifications over a specific channel (log, webhook, push, email...)
+type Notifier interface {
+	Notify(notification *Notification) error
+}
+
+// LogNotifier writes notifications to the server log. Used when no delivery channel is configured.
+type LogNotifier struct{}
+
+// NewLogNotifier creates a notifier that only logs notifications
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+// Notify logs the notification
+func (n *LogNotifier) Notify(notification *Notification) error {
+	log.Printf("Notification [%s] to %s (tenant %s): %s", notification.Type, notification.Recipient, notification.TenantID, notification.Message)
+	return nil
+}
+
+// WebhookNotifier posts notifications as JSON to an HTTP endpoint (e.g. a push gateway)
+type WebhookNotifier struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewWebhookNotifier creates a notifier that posts to the given URL
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify posts the notification to the webhook URL
+func (n *WebhookNotifier) Notify(notification *Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// MultiNotifier fans a notification out to several notifiers
+type MultiNotifier struct {
+	notifiers []Notifier
+}
+
+// NewMultiNotifier creates a notifier that delivers to every given notifier
+func NewMultiNotifier(notifiers ...Notifier) *MultiNotifier {
+	return &MultiNotifier{notifiers: notifiers}
+}
+
+// Notify delivers the notification to all notifiers and returns the first error
+func (n *MultiNotifier) Notify(notification *Notification) error {
+	var firstErr error
+	for _, notifier := range n.notifiers {
+		if err := notifier.Notify(notification); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
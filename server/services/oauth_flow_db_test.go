@@ -0,0 +1,57 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestAuthorizationCodeFlowEndToEnd runs the PKCE code flow against a real database: issue a
+// code, exchange it once, and check that a replay fails and both show up in the client metrics.
+func TestAuthorizationCodeFlowEndToEnd(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, Email: "jane@example.com", Scopes: []string{"openid", "read"}, Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), ClientID: "spa", Name: "SPA", RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+
+	verifier := "a-sufficiently-long-code-verifier-for-the-test-0123456789"
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+
+	code, err := oauthService.CreateAuthorizationCode("spa", userID.Hex(), "", "https://app.example.com/cb", []string{"openid", "read"}, challenge, "S256", CodeBinding{}, ACRMultiFactor)
+	if err != nil {
+		t.Fatalf("Failed to create authorization code: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/oauth/token", nil)
+	tokens, err := oauthService.ExchangeCodeForTokensPKCE(code, "spa", verifier, "https://app.example.com/cb", "", req)
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.IDToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("Expected access, ID and refresh tokens, got %+v", tokens)
+	}
+
+	if _, err := oauthService.ExchangeCodeForTokensPKCE(code, "spa", verifier, "https://app.example.com/cb", "", req); err == nil {
+		t.Error("Expected a replayed code to be rejected")
+	}
+
+	report, err := NewClientMetricsService(db).GetReport("spa", 1)
+	if err != nil {
+		t.Fatalf("Failed to load client metrics: %v", err)
+	}
+	if report.Totals.CodesIssued != 1 || report.Totals.CodesExchanged != 1 || report.ExchangeFailures[ExchangeFailureInvalidCode] != 1 {
+		t.Errorf("Unexpected client metrics: %+v %v", report.Totals, report.ExchangeFailures)
+	}
+}
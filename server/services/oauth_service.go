@@ -2,10 +2,13 @@ package services
 
 import (
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -17,18 +20,35 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrTenantMismatch is returned when a grant is presented to the token endpoint of another tenant
+var ErrTenantMismatch = errors.New("grant was issued for another tenant")
+
 type OAuthService struct {
 	db                  *database.MongoDB
 	clientCollection    *mongo.Collection
 	codeCollection      *mongo.Collection
 	tokenCollection     *mongo.Collection
+	tokenReads          *mongo.Collection // access_tokens with the token validation read preference
 	refreshCollection   *mongo.Collection
 	jwtSecret           string
 	accessTokenExpiry   time.Duration
 	refreshTokenExpiry  time.Duration
-	authCodeExpiry      time.Duration
+	lifetimes           Lifetimes
+	scopeUsage          *ScopeUsageService
+	clientMetrics       *ClientMetricsService
+	quotas              *QuotaService
+	rateLimits          *ClientRateLimitService
+	metering            *MeteringService
+	consent             *ConsentService
+	signingKeys         signingKeySource // the tenant keys of RS256 and ES256 ID tokens
+}
+
+// signingKeySource picks the published key a tenant's new tokens are signed with
+type signingKeySource interface {
+	SigningKey(ctx context.Context, tenantID, keyType string) (*models.CryptoKey, error)
 }
 
 type TokenResponse struct {
@@ -52,36 +72,96 @@ type Claims struct {
 type IDTokenClaims struct {
 	UserID   string   `json:"sub"`
 	TenantID string   `json:"tenant_id"`
-	Email    string   `json:"email"`
-	Groups   []string `json:"groups"`
-	Scopes   []string `json:"scopes"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Picture  string   `json:"picture,omitempty"`  // profile scope
+	Locale   string   `json:"locale,omitempty"`   // profile scope
+	Zoneinfo string   `json:"zoneinfo,omitempty"` // profile scope
+	Scopes   []string `json:"scopes,omitempty"` // the user's scopes, for clients with IDTokenRoles
+	ACR      string   `json:"acr,omitempty"` // ACRSingleFactor or ACRMultiFactor
+	AtHash   string   `json:"at_hash,omitempty"` // hash of the access token issued with the ID token
+	CHash    string   `json:"c_hash,omitempty"`  // hash of the authorization code the ID token was issued for
+
+	// Distributed claims: the groups endpoint replaces the group names in oversized tokens
+	ClaimNames   map[string]string      `json:"_claim_names,omitempty"`
+	ClaimSources map[string]ClaimSource `json:"_claim_sources,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func NewOAuthService(db *database.MongoDB, jwtSecret string) *OAuthService {
+func NewOAuthService(db *database.MongoDB, jwtSecret string, lifetimes Lifetimes) *OAuthService {
 	return &OAuthService{
 		db:                  db,
 		clientCollection:    db.GetCollection("clients"),
 		codeCollection:      db.GetCollection("authorization_codes"),
 		tokenCollection:     db.GetCollection("access_tokens"),
+		tokenReads:          db.ReadCollection(database.ReadPathTokenValidation, "access_tokens"),
 		refreshCollection:   db.GetCollection("refresh_tokens"),
 		jwtSecret:           jwtSecret,
 		accessTokenExpiry:   time.Hour * 1,
 		refreshTokenExpiry:  time.Hour * 24 * 30,
-		authCodeExpiry:      time.Minute * 10,
+		lifetimes:           lifetimes,
+		scopeUsage:          NewScopeUsageService(db),
+		clientMetrics:       NewClientMetricsService(db),
+		quotas:              NewQuotaService(db),
+		rateLimits:          NewClientRateLimitService(db),
+		metering:            NewMeteringService(db),
+		consent:             NewConsentService(db),
+		signingKeys:         NewCryptoKeyService(db),
+	}
+}
+
+// Lifetimes returns the lifetimes of login artifacts in the tenant
+func (s *OAuthService) Lifetimes(tenantID string) Lifetimes {
+	return s.lifetimes.ForTenant(s.tenantSettings(tenantID).Lifetimes)
+}
+
+// tenantSettings returns the settings of the tenant, or zero settings if it can't be loaded
+func (s *OAuthService) tenantSettings(tenantID string) models.TenantSettings {
+	tenant, err := NewTenantService(s.db).GetTenantByID(tenantID)
+	if err != nil {
+		return models.TenantSettings{}
 	}
+	return tenant.Settings
+}
+
+// ValidateAuthorizeClient checks that an authorization request names an active client of the
+// tenant and one of its registered redirect URIs. Until it passes, errors must not be redirected.
+func (s *OAuthService) ValidateAuthorizeClient(clientID, redirectURI, tenantID string) error {
+	if clientID == "" || redirectURI == "" {
+		return errors.New("client_id and redirect_uri are required")
+	}
+	return NewClientService(s.db).ValidateRedirectURI(clientID, redirectURI, tenantID)
+}
+
+// AuthorizeClient returns the client of an authorization request, e.g. to show its name, logo and
+// policy links on the consent screen
+func (s *OAuthService) AuthorizeClient(clientID, tenantID string) (*models.Client, error) {
+	return NewClientService(s.db).GetClientByClientID(clientID, tenantID)
+}
+
+// HasApplicationAccess reports whether the user is assigned to the client (see AppAssignmentService)
+func (s *OAuthService) HasApplicationAccess(clientID string, user *models.User) bool {
+	return NewAppAssignmentService(s.db).HasAccess(clientID, user)
+}
+
+// TenantBranding returns the branding used on the tenant's browser-facing pages
+func (s *OAuthService) TenantBranding(tenantID string) models.TenantBranding {
+	return s.tenantSettings(tenantID).CustomBranding
+}
+
+// RecordClientEvent counts an authorization funnel event (see ClientMetricAuthorizeRequests etc.)
+func (s *OAuthService) RecordClientEvent(clientID, event string) {
+	s.clientMetrics.Record(clientID, event)
+}
+
+// RecordRequestedScopes counts the scopes a client asked for in an authorization request
+func (s *OAuthService) RecordRequestedScopes(tenantID, clientID string, scopes []string) {
+	s.scopeUsage.RecordRequested(tenantID, clientID, scopes)
 }
 
 // getBaseURL extracts the base URL from the HTTP request (same as autodiscovery)
 func (s *OAuthService) getBaseURL(r *http.Request) string {
-	scheme := "https"
-	if r.Header.Get("X-Forwarded-Proto") != "" {
-		scheme = r.Header.Get("X-Forwarded-Proto")
-	} else if r.TLS == nil {
-		scheme = "http"
-	}
-	
-	return scheme + "://" + r.Host
+	return RequestBaseURL(r)
 }
 
 // generateIssuer creates the appropriate issuer URL based on tenant context
@@ -92,15 +172,16 @@ func (s *OAuthService) generateIssuer(baseURL, tenantID string) string {
 	return baseURL
 }
 
+// ValidateClient authenticates a confidential client. During a secret rotation window both the
+// current and the previous secret are accepted; expired secrets are rejected.
 func (s *OAuthService) ValidateClient(clientID, clientSecret string) (*models.Client, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var client models.Client
 	err := s.clientCollection.FindOne(ctx, bson.M{
-		"client_id":     clientID,
-		"client_secret": clientSecret,
-		"active":        true,
+		"client_id": clientID,
+		"active":    true,
 	}).Decode(&client)
 
 	if err != nil {
@@ -110,13 +191,47 @@ func (s *OAuthService) ValidateClient(clientID, clientSecret string) (*models.Cl
 		return nil, err
 	}
 
-	return &client, nil
+	if client.IsPublic() {
+		return nil, ErrPublicClientSecret
+	}
+	if clientSecret == "" {
+		return nil, errors.New("invalid client credentials")
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.ClientSecret)) == 1 {
+		if client.ClientSecretExpiresAt != nil && now.After(*client.ClientSecretExpiresAt) {
+			return nil, errors.New("client secret expired")
+		}
+		return &client, nil
+	}
+
+	if client.PreviousClientSecret != "" && client.PreviousSecretExpiresAt != nil && now.Before(*client.PreviousSecretExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.PreviousClientSecret)) == 1 {
+		return &client, nil
+	}
+
+	return nil, errors.New("invalid client credentials")
 }
 
-func (s *OAuthService) CreateAuthorizationCode(clientID, userID, tenantID, redirectURI string, scopes []string, codeChallenge, codeChallengeMethod string) (string, error) {
+// CreateAuthorizationCode issues an authorization code. If the tenant binds codes, the code is
+// bound to the device described by binding and can only be exchanged from it. acr records how the
+// user authenticated and is echoed in the ID token.
+func (s *OAuthService) CreateAuthorizationCode(clientID, userID, tenantID, redirectURI string, scopes []string, codeChallenge, codeChallengeMethod string, binding CodeBinding, acr string) (string, error) {
+	// Whatever path issues the code, it must satisfy the client's PKCE policy. The exception are
+	// the codes of direct social logins, which the server hands to its own web application.
+	if clientID != models.SystemClientDirectSocialLogin {
+		if client, err := NewClientService(s.db).GetClientByClientID(clientID, tenantID); err == nil {
+			if err := s.checkPKCE(client, codeChallenge, codeChallengeMethod); err != nil {
+				return "", err
+			}
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	settings := s.tenantSettings(tenantID)
 	code := s.generateRandomString(32)
 	authCode := &models.AuthorizationCode{
 		ID:                  primitive.NewObjectID(),
@@ -128,21 +243,32 @@ func (s *OAuthService) CreateAuthorizationCode(clientID, userID, tenantID, redir
 		Scopes:              scopes,
 		CodeChallenge:       codeChallenge,
 		CodeChallengeMethod: codeChallengeMethod,
-		ExpiresAt:           time.Now().Add(s.authCodeExpiry),
+		ACR:                 acr,
+		ExpiresAt:           time.Now().Add(s.lifetimes.ForTenant(settings.Lifetimes).AuthCode),
 		Used:                false,
 		CreatedAt:           time.Now(),
 	}
+	if mode := settings.CodeBinding; mode != "" && mode != CodeBindingOff {
+		authCode.BindingMode = mode
+		authCode.BindingHash = hashCodeBinding(mode, binding)
+	}
 
 	_, err := s.codeCollection.InsertOne(ctx, authCode)
 	if err != nil {
 		return "", err
 	}
+	s.clientMetrics.Record(clientID, ClientMetricCodesIssued)
 
 	return code, nil
 }
 
-func (s *OAuthService) ExchangeCodeForTokens(code, clientID, clientSecret, redirectURI string, r *http.Request) (*TokenResponse, error) {
-	_, err := s.ValidateClient(clientID, clientSecret)
+// ExchangeCodeForTokens exchanges the authorization code of a confidential client. tenantID is the
+// tenant the token endpoint was addressed to ("" for the unscoped endpoint); codes issued in another
+// tenant are refused with ErrTenantMismatch, as are refresh tokens in RefreshTokens.
+func (s *OAuthService) ExchangeCodeForTokens(code, clientID, clientSecret, redirectURI, tenantID string, r *http.Request) (response *TokenResponse, err error) {
+	defer func() { s.clientMetrics.RecordExchange(clientID, err) }()
+
+	_, err = s.ValidateClient(clientID, clientSecret)
 	if err != nil {
 		return nil, err
 	}
@@ -161,6 +287,10 @@ func (s *OAuthService) ExchangeCodeForTokens(code, clientID, clientSecret, redir
 		return nil, errors.New("invalid authorization code")
 	}
 
+	if !s.grantTenantMatches(authCode.TenantID, tenantID) {
+		return nil, ErrTenantMismatch
+	}
+
 	if time.Now().After(authCode.ExpiresAt) {
 		return nil, errors.New("authorization code expired")
 	}
@@ -169,6 +299,17 @@ func (s *OAuthService) ExchangeCodeForTokens(code, clientID, clientSecret, redir
 		return nil, errors.New("redirect URI mismatch")
 	}
 
+	// A code bound to a challenge can only be redeemed with its verifier
+	if authCode.CodeChallenge != "" {
+		return nil, ErrCodeVerifierRequired
+	}
+
+	if !codeBindingMatches(&authCode, RequestCodeBinding(r)) {
+		// A code presented from another device may have been intercepted; it can't be used again
+		s.codeCollection.UpdateOne(ctx, bson.M{"_id": authCode.ID}, bson.M{"$set": bson.M{"used": true}})
+		return nil, errors.New("authorization code binding mismatch")
+	}
+
 	_, err = s.codeCollection.UpdateOne(ctx, bson.M{"_id": authCode.ID}, bson.M{
 		"$set": bson.M{"used": true},
 	})
@@ -182,13 +323,13 @@ func (s *OAuthService) ExchangeCodeForTokens(code, clientID, clientSecret, redir
 		return nil, err
 	}
 
-	refreshToken, err := s.generateRefreshToken(accessToken, clientID, authCode.UserID, authCode.TenantID, authCode.Scopes)
+	refreshToken, err := s.generateRefreshToken(accessToken, clientID, authCode.UserID, authCode.TenantID, authCode.Scopes, authCode.ACR, r)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate ID token for OpenID Connect
-	idToken, err := s.generateIDToken(authCode.UserID, authCode.TenantID, clientID, baseURL, authCode.Scopes)
+	idToken, err := s.generateIDToken(authCode.UserID, authCode.TenantID, clientID, baseURL, authCode.Scopes, authCode.ACR, accessToken, code)
 	if err != nil {
 		return nil, err
 	}
@@ -203,14 +344,17 @@ func (s *OAuthService) ExchangeCodeForTokens(code, clientID, clientSecret, redir
 	}, nil
 }
 
-// ExchangeCodeForTokensPKCE exchanges an authorization code for tokens using PKCE
-func (s *OAuthService) ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, redirectURI string, r *http.Request) (*TokenResponse, error) {
+// ExchangeCodeForTokensPKCE exchanges an authorization code for tokens using PKCE. Codes of public
+// clients must have been issued for an S256 challenge.
+func (s *OAuthService) ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, redirectURI, tenantID string, r *http.Request) (response *TokenResponse, err error) {
+	defer func() { s.clientMetrics.RecordExchange(clientID, err) }()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	// Validate client exists (no secret required for PKCE)
 	var client models.Client
-	err := s.clientCollection.FindOne(ctx, bson.M{
+	err = s.clientCollection.FindOne(ctx, bson.M{
 		"client_id": clientID,
 		"active":    true,
 	}).Decode(&client)
@@ -232,6 +376,10 @@ func (s *OAuthService) ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, r
 		return nil, errors.New("invalid authorization code")
 	}
 
+	if !s.grantTenantMatches(authCode.TenantID, tenantID) {
+		return nil, ErrTenantMismatch
+	}
+
 	if time.Now().After(authCode.ExpiresAt) {
 		return nil, errors.New("authorization code expired")
 	}
@@ -240,12 +388,22 @@ func (s *OAuthService) ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, r
 		return nil, errors.New("redirect URI mismatch")
 	}
 
+	if !codeBindingMatches(&authCode, RequestCodeBinding(r)) {
+		// A code presented from another device may have been intercepted; it can't be used again
+		s.codeCollection.UpdateOne(ctx, bson.M{"_id": authCode.ID}, bson.M{"$set": bson.M{"used": true}})
+		return nil, errors.New("authorization code binding mismatch")
+	}
+
 	// Verify PKCE code_verifier against stored code_challenge
 	if authCode.CodeChallenge == "" {
 		return nil, errors.New("PKCE required but no code_challenge found")
 	}
+	// Codes issued before the policy was tightened are held to it as well
+	if err := s.checkPKCE(&client, authCode.CodeChallenge, authCode.CodeChallengeMethod); err != nil {
+		return nil, err
+	}
 
-	if !s.verifyPKCE(codeVerifier, authCode.CodeChallenge, authCode.CodeChallengeMethod) {
+	if !verifyPKCE(codeVerifier, authCode.CodeChallenge, authCode.CodeChallengeMethod) {
 		return nil, errors.New("invalid code_verifier")
 	}
 
@@ -264,13 +422,13 @@ func (s *OAuthService) ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, r
 		return nil, err
 	}
 
-	refreshToken, err := s.generateRefreshToken(accessToken, clientID, authCode.UserID, authCode.TenantID, authCode.Scopes)
+	refreshToken, err := s.generateRefreshToken(accessToken, clientID, authCode.UserID, authCode.TenantID, authCode.Scopes, authCode.ACR, r)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate ID token for OpenID Connect
-	idToken, err := s.generateIDToken(authCode.UserID, authCode.TenantID, clientID, baseURL, authCode.Scopes)
+	idToken, err := s.generateIDToken(authCode.UserID, authCode.TenantID, clientID, baseURL, authCode.Scopes, authCode.ACR, accessToken, code)
 	if err != nil {
 		return nil, err
 	}
@@ -285,14 +443,21 @@ func (s *OAuthService) ExchangeCodeForTokensPKCE(code, clientID, codeVerifier, r
 	}, nil
 }
 
-// ExchangeCodeForTokensDirectSocialLogin exchanges authorization code from direct social login
-func (s *OAuthService) ExchangeCodeForTokensDirectSocialLogin(code, clientID, redirectURI string, r *http.Request) (*TokenResponse, error) {
+// ExchangeCodeForTokensDirectSocialLogin exchanges an authorization code issued to the direct
+// social login system client, which authenticates neither with a secret nor with PKCE
+func (s *OAuthService) ExchangeCodeForTokensDirectSocialLogin(code, clientID, redirectURI, tenantID string, r *http.Request) (response *TokenResponse, err error) {
+	defer func() { s.clientMetrics.RecordExchange(clientID, err) }()
+
+	if clientID != models.SystemClientDirectSocialLogin {
+		return nil, errors.New("invalid client")
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Find the authorization code directly (skip client validation for direct social login)
+	// Find the authorization code directly; the system client is checked in the code's tenant
 	var authCode models.AuthorizationCode
-	err := s.codeCollection.FindOne(ctx, bson.M{
+	err = s.codeCollection.FindOne(ctx, bson.M{
 		"code":      code,
 		"client_id": clientID,
 		"used":      false,
@@ -301,6 +466,14 @@ func (s *OAuthService) ExchangeCodeForTokensDirectSocialLogin(code, clientID, re
 		return nil, errors.New("invalid authorization code")
 	}
 
+	if _, err := getTenantClient(ctx, s.clientCollection, clientID, authCode.TenantID); err != nil {
+		return nil, err
+	}
+
+	if !s.grantTenantMatches(authCode.TenantID, tenantID) {
+		return nil, ErrTenantMismatch
+	}
+
 	if time.Now().After(authCode.ExpiresAt) {
 		return nil, errors.New("authorization code expired")
 	}
@@ -309,6 +482,17 @@ func (s *OAuthService) ExchangeCodeForTokensDirectSocialLogin(code, clientID, re
 		return nil, errors.New("redirect URI mismatch")
 	}
 
+	// A code bound to a challenge can only be redeemed with its verifier
+	if authCode.CodeChallenge != "" {
+		return nil, ErrCodeVerifierRequired
+	}
+
+	if !codeBindingMatches(&authCode, RequestCodeBinding(r)) {
+		// A code presented from another device may have been intercepted; it can't be used again
+		s.codeCollection.UpdateOne(ctx, bson.M{"_id": authCode.ID}, bson.M{"$set": bson.M{"used": true}})
+		return nil, errors.New("authorization code binding mismatch")
+	}
+
 	// Mark code as used
 	_, err = s.codeCollection.UpdateOne(ctx, bson.M{"_id": authCode.ID}, bson.M{
 		"$set": bson.M{"used": true},
@@ -320,7 +504,7 @@ func (s *OAuthService) ExchangeCodeForTokensDirectSocialLogin(code, clientID, re
 	// Generate tokens
 	scopes := authCode.Scopes
 	userID := authCode.UserID
-	tenantID := authCode.TenantID
+	tenantID = authCode.TenantID
 	baseURL := s.getBaseURL(r)
 
 	accessToken, err := s.generateAccessToken(userID, tenantID, clientID, baseURL, scopes)
@@ -328,13 +512,13 @@ func (s *OAuthService) ExchangeCodeForTokensDirectSocialLogin(code, clientID, re
 		return nil, err
 	}
 
-	refreshToken, err := s.generateRefreshToken(accessToken, clientID, userID, tenantID, scopes)
+	refreshToken, err := s.generateRefreshToken(accessToken, clientID, userID, tenantID, scopes, authCode.ACR, r)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate ID token for OpenID Connect
-	idToken, err := s.generateIDToken(userID, tenantID, clientID, baseURL, scopes)
+	idToken, err := s.generateIDToken(userID, tenantID, clientID, baseURL, scopes, authCode.ACR, accessToken, code)
 	if err != nil {
 		return nil, err
 	}
@@ -349,27 +533,40 @@ func (s *OAuthService) ExchangeCodeForTokensDirectSocialLogin(code, clientID, re
 	}, nil
 }
 
-// verifyPKCE verifies the code_verifier against the stored code_challenge
-func (s *OAuthService) verifyPKCE(codeVerifier, codeChallenge, method string) bool {
-	if method == "" || method == "plain" {
-		return codeVerifier == codeChallenge
+func (s *OAuthService) generateAccessToken(userID, tenantID, clientID, baseURL string, scopes []string) (string, error) {
+	// Codes and backchannel requests issued before a deactivation must not yield tokens
+	if !s.userActive(userID) {
+		return "", ErrUserDeactivated
 	}
-	
-	if method == "S256" {
-		hash := sha256.Sum256([]byte(codeVerifier))
-		computed := base64.RawURLEncoding.EncodeToString(hash[:])
-		return computed == codeChallenge
+
+	// Regular tokens never carry the elevated scope, see IssueElevatedToken
+	if containsString(scopes, ElevatedScope) {
+		scopes = removeStrings(scopes, []string{ElevatedScope})
 	}
-	
-	return false
+	return s.signAccessToken(userID, tenantID, clientID, baseURL, scopes, s.accessTokenExpiry)
 }
 
-func (s *OAuthService) generateAccessToken(userID, tenantID, clientID, baseURL string, scopes []string) (string, error) {
+// signAccessToken signs and stores an access token valid for lifetime
+func (s *OAuthService) signAccessToken(userID, tenantID, clientID, baseURL string, scopes []string, lifetime time.Duration) (string, error) {
+	token, _, err := s.signTokenRecord(userID, tenantID, clientID, baseURL, scopes, lifetime, nil)
+	return token, err
+}
+
+// signTokenRecord signs and stores an access token. A vended token (see VendToken) records the
+// token it was narrowed from in child, whose audience goes into the aud claim.
+func (s *OAuthService) signTokenRecord(userID, tenantID, clientID, baseURL string, scopes []string, lifetime time.Duration, child *models.AccessToken) (string, *models.AccessToken, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if err := s.rateLimits.CheckActiveTokens(clientID); err != nil {
+		return "", nil, err
+	}
+	if err := s.quotas.ReserveToken(tenantID); err != nil {
+		return "", nil, err
+	}
+
 	tokenID := uuid.New().String()
-	expiresAt := time.Now().Add(s.accessTokenExpiry)
+	expiresAt := time.Now().Add(lifetime)
 
 	claims := &Claims{
 		UserID:   userID,
@@ -384,35 +581,44 @@ func (s *OAuthService) generateAccessToken(userID, tenantID, clientID, baseURL s
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
+	if child == nil {
+		child = &models.AccessToken{}
+	} else if len(child.Audience) > 0 {
+		claims.Audience = jwt.ClaimStrings(child.Audience)
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(s.jwtSecret))
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	accessToken := &models.AccessToken{
-		ID:        primitive.NewObjectID(),
-		TenantID:  tenantID,
-		Token:     tokenString,
-		ClientID:  clientID,
-		UserID:    userID,
-		Scopes:    scopes,
-		ExpiresAt: expiresAt,
-		Revoked:   false,
-		CreatedAt: time.Now(),
-	}
+	accessToken := child
+	accessToken.ID = primitive.NewObjectID()
+	accessToken.TenantID = tenantID
+	accessToken.Token = tokenString
+	accessToken.ClientID = clientID
+	accessToken.UserID = userID
+	accessToken.Scopes = scopes
+	accessToken.ExpiresAt = expiresAt
+	accessToken.Revoked = false
+	accessToken.CreatedAt = time.Now()
 
 	_, err = s.tokenCollection.InsertOne(ctx, accessToken)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return tokenString, nil
+	s.scopeUsage.RecordGranted(tenantID, clientID, scopes)
+	s.metering.RecordTokenIssued(tenantID, clientID, userID)
+
+	return tokenString, accessToken, nil
 }
 
 // generateIDToken creates an OpenID Connect ID token with user information
-func (s *OAuthService) generateIDToken(userID, tenantID, clientID, baseURL string, scopes []string) (string, error) {
+// generateIDToken creates a signed ID token. accessToken and code are the access token and
+// authorization code issued along with it, if any, and are bound to it through at_hash and c_hash.
+func (s *OAuthService) generateIDToken(userID, tenantID, clientID, baseURL string, scopes []string, acr, accessToken, code string) (string, error) {
 	// Get user information for the ID token
 	userService := NewUserService(s.db)
 	user, err := userService.GetUserByID(userID)
@@ -426,9 +632,7 @@ func (s *OAuthService) generateIDToken(userID, tenantID, clientID, baseURL strin
 	claims := &IDTokenClaims{
 		UserID:   userID,
 		TenantID: tenantID,
-		Email:    user.Email,
-		Groups:   user.Groups,
-		Scopes:   user.Scopes, // Use user's actual database scopes instead of OAuth request scopes
+		ACR:      acr,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        tokenID,
 			Issuer:    s.generateIssuer(baseURL, tenantID),
@@ -439,16 +643,214 @@ func (s *OAuthService) generateIDToken(userID, tenantID, clientID, baseURL strin
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
+	// Only the granted scopes release claims; role data is reserved to clients configured for it
+	client, clientErr := s.idTokenClient(clientID, tenantID)
+	var groupsClient *models.Client
+	if clientErr == nil {
+		groupsClient = client
+	}
+	if containsString(scopes, "email") {
+		claims.Email = user.Email
+	}
+	if containsString(scopes, "profile") {
+		claims.Picture, claims.Locale, claims.Zoneinfo = user.Picture, user.Locale, user.Zoneinfo
+	}
+	if containsString(scopes, "groups") || (clientErr == nil && client.IDTokenRoles) {
+		switch groupsClaimMode(groupsClient) {
+		case GroupsClaimOmit:
+			// The client doesn't use group names
+		case GroupsClaimReference:
+			claims.referenceGroups()
+		default:
+			claims.Groups = NewMembershipService(s.db).GetGroupNames(user.TenantID, clientGroupIDs(groupsClient, user.Groups))
+		}
+	}
+	if clientErr == nil && client.IDTokenRoles {
+		claims.Scopes = user.Scopes
+	}
+
+	// Leave out the claims the user declined to share with this client
+	withheld := s.consent.WithheldClaims(userID, clientID, tenantID)
+	if containsString(withheld, ClaimEmail) {
+		claims.Email = ""
+	}
+	if containsString(withheld, ClaimGroups) {
+		claims.Groups = nil
+		claims.ClaimNames, claims.ClaimSources = nil, nil
+	}
+
+	// ID tokens are signed with the client's own key, or the tenant's published key, so the client
+	// can verify them without knowing the server secret; internal clients without a client record
+	// use the server secret
+	signer := &idTokenSigner{method: jwt.SigningMethodHS256, key: []byte(s.jwtSecret)}
+	if clientErr == nil {
+		if signer, err = s.clientIDTokenSigner(client, tenantID); err != nil {
+			return "", err
+		}
+	}
+	claims.AtHash = oidcTokenHash(signer.method, accessToken)
+	claims.CHash = oidcTokenHash(signer.method, code)
+	token := jwt.NewWithClaims(signer.method, claims)
+	if signer.keyID != "" {
+		token.Header["kid"] = signer.keyID
+	}
+	signingKey := signer.key
+
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", err
 	}
 
+	// Users in many groups would produce tokens that break proxies' header limits
+	if len(tokenString) > idTokenSizeBudget(groupsClient) && len(claims.Groups) > 0 {
+		claims.referenceGroups()
+		token.Claims = claims
+		if tokenString, err = token.SignedString(signingKey); err != nil {
+			return "", err
+		}
+	}
+
 	return tokenString, nil
 }
 
-func (s *OAuthService) generateRefreshToken(accessToken, clientID, userID, tenantID string, scopes []string) (string, error) {
+// idTokenClient returns the client an ID token is issued to. System clients are looked up in the
+// tenant of the grant.
+func (s *OAuthService) idTokenClient(clientID, tenantID string) (*models.Client, error) {
+	if !IsSystemClient(clientID) {
+		return s.getActiveClient(clientID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return getTenantClient(ctx, s.clientCollection, clientID, tenantID)
+}
+
+// idTokenSigner is the algorithm, key and key ID an ID token is signed with
+type idTokenSigner struct {
+	method jwt.SigningMethod
+	key    interface{}
+	keyID  string
+}
+
+// clientIDTokenSigner returns how the client's ID tokens are signed: with the client's own HS256
+// key, or for clients registered for RS256 or ES256 with the tenant's current key of that type
+// (or the canary key, for its share of tokens while a canary rotation runs)
+func (s *OAuthService) clientIDTokenSigner(client *models.Client, tenantID string) (*idTokenSigner, error) {
+	var keyType string
+	switch client.IDTokenSignedResponseAlg {
+	case models.IDTokenAlgRS256:
+		keyType = "rsa"
+	case models.IDTokenAlgES256:
+		keyType = "ecdsa"
+	default:
+		key, keyID, err := s.ClientSigningKey(client)
+		if err != nil {
+			return nil, err
+		}
+		return &idTokenSigner{method: jwt.SigningMethodHS256, key: key, keyID: keyID}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cryptoKey, err := s.signingKeys.SigningKey(ctx, tenantID, keyType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the %s signing key: %v", keyType, err)
+	}
+	signer := &idTokenSigner{keyID: cryptoKey.KeyID}
+	if keyType == "rsa" {
+		signer.method = jwt.SigningMethodRS256
+		signer.key, err = jwt.ParseRSAPrivateKeyFromPEM(cryptoKey.PrivateKey)
+	} else {
+		signer.method = jwt.SigningMethodES256
+		signer.key, err = jwt.ParseECPrivateKeyFromPEM(cryptoKey.PrivateKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %v", cryptoKey.KeyID, err)
+	}
+	return signer, nil
+}
+
+// ClientSigningKey returns the symmetric key and key ID a client's HS256 ID tokens are signed
+// with, creating the key on first use
+func (s *OAuthService) ClientSigningKey(client *models.Client) ([]byte, string, error) {
+	if client.IDTokenSigningKey == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// Only set the key if none exists yet, so concurrent first uses agree on one key
+		_, err := s.clientCollection.UpdateOne(ctx, bson.M{
+			"_id":                  client.ID,
+			"id_token_signing_key": bson.M{"$in": bson.A{nil, ""}},
+		}, bson.M{
+			"$set": bson.M{"id_token_signing_key": s.generateRandomString(43)},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		var stored models.Client
+		if err := s.clientCollection.FindOne(ctx, bson.M{"_id": client.ID}).Decode(&stored); err != nil {
+			return nil, "", err
+		}
+		client.IDTokenSigningKey = stored.IDTokenSigningKey
+	}
+
+	key := []byte(client.IDTokenSigningKey)
+	hash := sha256.Sum256(key)
+	return key, "hs-" + base64.RawURLEncoding.EncodeToString(hash[:8]), nil
+}
+
+// oidcTokenHash computes an at_hash or c_hash value (OIDC Core 3.1.3.6): the base64url encoded
+// left half of the value's hash, using the hash function of the ID token's signing algorithm
+func oidcTokenHash(method jwt.SigningMethod, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	var hash crypto.Hash
+	switch m := method.(type) {
+	case *jwt.SigningMethodHMAC:
+		hash = m.Hash
+	case *jwt.SigningMethodRSA:
+		hash = m.Hash
+	case *jwt.SigningMethodRSAPSS:
+		hash = m.Hash
+	case *jwt.SigningMethodECDSA:
+		hash = m.Hash
+	default:
+		return ""
+	}
+	if !hash.Available() {
+		return ""
+	}
+
+	h := hash.New()
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+func (s *OAuthService) generateRefreshToken(accessToken, clientID, userID, tenantID string, scopes []string, acr string, r *http.Request) (string, error) {
+	policy := s.getRefreshTokenPolicy(clientID, tenantID)
+
+	expiry := s.refreshTokenExpiry
+	if policy.AbsoluteLifetimeDays > 0 {
+		expiry = time.Duration(policy.AbsoluteLifetimeDays) * 24 * time.Hour
+	}
+
+	if policy.MaxSessionsPerUser > 0 {
+		if err := s.enforceMaxSessions(userID, clientID, policy.MaxSessionsPerUser); err != nil {
+			return "", err
+		}
+	}
+
+	return s.storeRefreshToken(accessToken, clientID, userID, tenantID, scopes, acr, uuid.New().String(), time.Now().Add(expiry), r)
+}
+
+// storeRefreshToken persists a new refresh token belonging to the given token family, recording
+// the device it was issued to
+func (s *OAuthService) storeRefreshToken(accessToken, clientID, userID, tenantID string, scopes []string, acr, familyID string, expiresAt time.Time, r *http.Request) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -461,10 +863,16 @@ func (s *OAuthService) generateRefreshToken(accessToken, clientID, userID, tenan
 		ClientID:    clientID,
 		UserID:      userID,
 		Scopes:      scopes,
-		ExpiresAt:   time.Now().Add(s.refreshTokenExpiry),
+		FamilyID:    familyID,
+		ACR:         acr,
+		ExpiresAt:   expiresAt,
 		Revoked:     false,
 		CreatedAt:   time.Now(),
 	}
+	if r != nil {
+		refreshToken.IPAddress = ClientIP(r)
+		refreshToken.UserAgent = r.UserAgent()
+	}
 
 	_, err := s.refreshCollection.InsertOne(ctx, refreshToken)
 	if err != nil {
@@ -474,6 +882,229 @@ func (s *OAuthService) generateRefreshToken(accessToken, clientID, userID, tenan
 	return refreshTokenStr, nil
 }
 
+// getRefreshTokenPolicy returns the client's refresh token policy (zero value if the client is unknown)
+func (s *OAuthService) getRefreshTokenPolicy(clientID, tenantID string) models.RefreshTokenPolicy {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"client_id": clientID}
+	if IsSystemClient(clientID) {
+		filter["tenant_id"] = tenantID
+	}
+
+	var client models.Client
+	if err := s.clientCollection.FindOne(ctx, filter).Decode(&client); err != nil {
+		return models.RefreshTokenPolicy{}
+	}
+	return client.RefreshTokenPolicy
+}
+
+// enforceMaxSessions revokes the oldest refresh token families so a new session fits within the limit
+func (s *OAuthService) enforceMaxSessions(userID, clientID string, maxSessions int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.refreshCollection.Find(ctx, bson.M{
+		"user_id":    userID,
+		"client_id":  clientID,
+		"revoked":    false,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var active []models.RefreshToken
+	if err = cursor.All(ctx, &active); err != nil {
+		return err
+	}
+
+	for i := 0; i <= len(active)-maxSessions; i++ {
+		filter := bson.M{"_id": active[i].ID}
+		if active[i].FamilyID != "" {
+			filter = bson.M{"family_id": active[i].FamilyID}
+		}
+		if _, err := s.refreshCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RefreshTokens implements the refresh_token grant, applying the client's refresh token policy
+// (rotation, idle timeout and absolute lifetime). Presenting an already rotated refresh token is
+// treated as token theft and revokes the whole token family.
+func (s *OAuthService) RefreshTokens(refreshTokenStr, clientID, clientSecret, scope, tenantID string, r *http.Request) (*TokenResponse, error) {
+	var client *models.Client
+	var err error
+	if clientSecret != "" {
+		client, err = s.ValidateClient(clientID, clientSecret)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		client, err = s.getActiveClient(clientID)
+		if err != nil {
+			return nil, err
+		}
+		// Only public clients, which have no secret, may refresh without authenticating
+		if !client.IsPublic() {
+			return nil, errors.New("invalid client credentials")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stored models.RefreshToken
+	err = s.refreshCollection.FindOne(ctx, bson.M{
+		"token":     refreshTokenStr,
+		"client_id": client.ClientID,
+	}).Decode(&stored)
+	if err != nil {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	if !s.grantTenantMatches(stored.TenantID, tenantID) {
+		return nil, ErrTenantMismatch
+	}
+
+	now := time.Now()
+	if stored.Revoked {
+		if stored.RotatedAt != nil && stored.FamilyID != "" {
+			// Reuse of a rotated token: revoke the whole family
+			s.refreshCollection.UpdateMany(ctx, bson.M{"family_id": stored.FamilyID}, bson.M{"$set": bson.M{"revoked": true}})
+		}
+		return nil, errors.New("invalid refresh token")
+	}
+
+	if now.After(stored.ExpiresAt) {
+		return nil, errors.New("refresh token expired")
+	}
+
+	if !s.userActive(stored.UserID) {
+		s.refreshCollection.UpdateOne(ctx, bson.M{"_id": stored.ID}, bson.M{"$set": bson.M{"revoked": true}})
+		return nil, ErrUserDeactivated
+	}
+
+	// System clients exist once per tenant: apply the record of the token's tenant
+	if client.System {
+		if client.TenantID != stored.TenantID {
+			if client, err = getTenantClient(ctx, s.clientCollection, client.ClientID, stored.TenantID); err != nil {
+				return nil, err
+			}
+		}
+		if !containsString(client.GrantTypes, "refresh_token") {
+			return nil, errors.New("refresh_token grant not allowed for client")
+		}
+	}
+
+	policy := client.RefreshTokenPolicy
+	if policy.IdleTimeoutDays > 0 {
+		lastUsed := stored.CreatedAt
+		if stored.LastUsedAt != nil {
+			lastUsed = *stored.LastUsedAt
+		}
+		if now.Sub(lastUsed) > time.Duration(policy.IdleTimeoutDays)*24*time.Hour {
+			s.refreshCollection.UpdateOne(ctx, bson.M{"_id": stored.ID}, bson.M{"$set": bson.M{"revoked": true}})
+			return nil, errors.New("refresh token expired")
+		}
+	}
+
+	// A refresh may narrow, but never widen, the originally granted scopes
+	scopes := stored.Scopes
+	if requested := ParseScopes(scope); len(requested) > 0 {
+		for _, requestedScope := range requested {
+			if !containsString(stored.Scopes, requestedScope) {
+				return nil, errors.New("requested scope exceeds original grant")
+			}
+		}
+		scopes = requested
+	}
+
+	baseURL := s.getBaseURL(r)
+	accessToken, err := s.generateAccessToken(stored.UserID, stored.TenantID, client.ClientID, baseURL, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTokenOut := refreshTokenStr
+	if policy.RotateOnUse {
+		familyID := stored.FamilyID
+		if familyID == "" {
+			familyID = uuid.New().String()
+		}
+
+		result, err := s.refreshCollection.UpdateOne(ctx, bson.M{"_id": stored.ID, "revoked": false}, bson.M{
+			"$set": bson.M{"revoked": true, "rotated_at": now, "last_used_at": now, "family_id": familyID},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.ModifiedCount == 0 {
+			return nil, errors.New("invalid refresh token")
+		}
+
+		// The absolute lifetime is inherited so rotation never extends the session
+		refreshTokenOut, err = s.storeRefreshToken(accessToken, client.ClientID, stored.UserID, stored.TenantID, stored.Scopes, stored.ACR, familyID, stored.ExpiresAt, r)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = s.refreshCollection.UpdateOne(ctx, bson.M{"_id": stored.ID}, bson.M{
+			"$set": bson.M{"last_used_at": now, "access_token": accessToken, "ip_address": ClientIP(r), "user_agent": r.UserAgent()},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.accessTokenExpiry.Seconds()),
+		RefreshToken: refreshTokenOut,
+		Scope:        s.joinScopes(scopes),
+	}
+
+	if containsString(scopes, "openid") {
+		idToken, err := s.generateIDToken(stored.UserID, stored.TenantID, client.ClientID, baseURL, scopes, stored.ACR, accessToken, "")
+		if err != nil {
+			return nil, err
+		}
+		response.IDToken = idToken
+	}
+
+	return response, nil
+}
+
+// getActiveClient looks up an active client without authenticating it (public clients)
+// ReserveTokenRequest counts a token endpoint request against the client's per-minute rate limit
+func (s *OAuthService) ReserveTokenRequest(clientID string) error {
+	return s.rateLimits.ReserveTokenRequest(clientID)
+}
+
+func (s *OAuthService) getActiveClient(clientID string) (*models.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var client models.Client
+	err := s.clientCollection.FindOne(ctx, bson.M{
+		"client_id": clientID,
+		"active":    true,
+	}).Decode(&client)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("invalid client")
+		}
+		return nil, err
+	}
+
+	return &client, nil
+}
+
 func (s *OAuthService) ValidateAccessToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(s.jwtSecret), nil
@@ -488,10 +1119,10 @@ func (s *OAuthService) ValidateAccessToken(tokenString string) (*Claims, error)
 		defer cancel()
 
 		var accessToken models.AccessToken
-		err = s.tokenCollection.FindOne(ctx, bson.M{
+		err = s.tokenReads.FindOne(ctx, bson.M{
 			"token":   tokenString,
 			"revoked": false,
-		}).Decode(&accessToken)
+		}, options.FindOne().SetHint(s.db.Hint("access_tokens", AccessTokenLookupIndex))).Decode(&accessToken)
 
 		if err != nil {
 			return nil, errors.New("token not found or revoked")
@@ -501,6 +1132,11 @@ func (s *OAuthService) ValidateAccessToken(tokenString string) (*Claims, error)
 			return nil, errors.New("token expired")
 		}
 
+		// Vended tokens die with the tokens they were narrowed from
+		if len(accessToken.Ancestors) > 0 && s.ancestorRevoked(ctx, accessToken.Ancestors) {
+			return nil, errors.New("token not found or revoked")
+		}
+
 		return claims, nil
 	}
 
@@ -532,8 +1168,13 @@ func (s *OAuthService) generateRandomString(length int) string {
 }
 
 // GenerateDirectLoginTokens creates OAuth tokens for direct login (bypassing authorization code flow)
-func (s *OAuthService) GenerateDirectLoginTokens(userID, tenantID string, scopes []string, r *http.Request) (*TokenResponse, error) {
-	clientID := "direct-login-client" // Special client ID for direct login
+func (s *OAuthService) GenerateDirectLoginTokens(userID, tenantID string, scopes []string, acr string, r *http.Request) (*TokenResponse, error) {
+	return s.IssueTokens(userID, tenantID, models.SystemClientDirectLogin, scopes, acr, r)
+}
+
+// IssueTokens creates access, refresh and ID tokens for a user and client once a grant has been
+// validated. acr is left empty for grants that don't involve an interactive login.
+func (s *OAuthService) IssueTokens(userID, tenantID, clientID string, scopes []string, acr string, r *http.Request) (*TokenResponse, error) {
 	baseURL := s.getBaseURL(r)
 	
 	accessToken, err := s.generateAccessToken(userID, tenantID, clientID, baseURL, scopes)
@@ -541,13 +1182,13 @@ func (s *OAuthService) GenerateDirectLoginTokens(userID, tenantID string, scopes
 		return nil, err
 	}
 
-	refreshToken, err := s.generateRefreshToken(accessToken, clientID, userID, tenantID, scopes)
+	refreshToken, err := s.generateRefreshToken(accessToken, clientID, userID, tenantID, scopes, acr, r)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate ID token for OpenID Connect
-	idToken, err := s.generateIDToken(userID, tenantID, clientID, baseURL, scopes)
+	idToken, err := s.generateIDToken(userID, tenantID, clientID, baseURL, scopes, acr, accessToken, "")
 	if err != nil {
 		return nil, err
 	}
@@ -562,6 +1203,33 @@ func (s *OAuthService) GenerateDirectLoginTokens(userID, tenantID string, scopes
 	}, nil
 }
 
+// grantTenantMatches reports whether a code or refresh token issued in grantTenant may be redeemed
+// at the token endpoint of endpointTenant. The unscoped endpoint ("") accepts every tenant, and
+// grants without a tenant belong to the default tenant.
+func (s *OAuthService) grantTenantMatches(grantTenant, endpointTenant string) bool {
+	if endpointTenant == "" || grantTenant == endpointTenant {
+		return true
+	}
+	if grantTenant != "" {
+		return false
+	}
+	defaultTenant, err := NewTenantService(s.db).GetDefaultTenant()
+	return err == nil && defaultTenant.ID.Hex() == endpointTenant
+}
+
+// userActive reports whether the user exists and is active
+func (s *OAuthService) userActive(userID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false
+	}
+	count, err := s.db.GetCollection("users").CountDocuments(ctx, bson.M{"_id": objID, "active": true})
+	return err == nil && count > 0
+}
+
 func (s *OAuthService) joinScopes(scopes []string) string {
 	if len(scopes) == 0 {
 		return ""
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrProviderUnavailable is returned without contacting a provider while its circuit is open
+var ErrProviderUnavailable = errors.New("social provider is temporarily unavailable")
+
+// Circuit breaker states of a provider
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// OutboundPolicy configures timeouts, retries and circuit breaking of outbound calls
+type OutboundPolicy struct {
+	// Timeout bounds a single attempt, including reading the response headers
+	Timeout time.Duration
+	// MaxRetries is the number of attempts made after the first one fails
+	MaxRetries int
+	// BaseBackoff is doubled per retry; a random jitter of up to the same amount is added
+	BaseBackoff time.Duration
+	// FailureThreshold consecutive failures open the circuit of a provider
+	FailureThreshold int
+	// OpenDuration is how long an open circuit rejects calls before a trial call is let through
+	OpenDuration time.Duration
+}
+
+// DefaultOutboundPolicy keeps a login waiting at most a few seconds on a failing provider
+var DefaultOutboundPolicy = OutboundPolicy{
+	Timeout:          5 * time.Second,
+	MaxRetries:       2,
+	BaseBackoff:      200 * time.Millisecond,
+	FailureThreshold: 5,
+	OpenDuration:     30 * time.Second,
+}
+
+// ProviderHealth is the circuit state and call statistics of a provider since startup
+type ProviderHealth struct {
+	Provider            string     `json:"provider"`
+	State               string     `json:"state"`
+	Requests            int64      `json:"requests"`
+	Failures            int64      `json:"failures"`
+	Retries             int64      `json:"retries"`
+	Rejected            int64      `json:"rejected"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	AverageLatencyMs    int64      `json:"average_latency_ms"`
+	LastLatencyMs       int64      `json:"last_latency_ms"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+// providerCircuit tracks the health of one provider
+type providerCircuit struct {
+	health       ProviderHealth
+	totalLatency time.Duration
+	trialPending bool
+}
+
+// OutboundClient performs the HTTP calls to social providers. Idempotent calls are retried
+// with exponential backoff and jitter; calls that may have reached the provider are retried
+// only if the connection could not be established. Each provider has a circuit breaker so an
+// outage fails logins fast instead of hanging them.
+type OutboundClient struct {
+	client *http.Client
+	policy OutboundPolicy
+	now    func() time.Time
+	sleep  func(time.Duration)
+
+	mu       sync.Mutex
+	circuits map[string]*providerCircuit
+}
+
+// NewOutboundClient creates an outbound client with the given policy
+func NewOutboundClient(policy OutboundPolicy) *OutboundClient {
+	return &OutboundClient{
+		client:   &http.Client{Timeout: policy.Timeout},
+		policy:   policy,
+		now:      time.Now,
+		sleep:    time.Sleep,
+		circuits: make(map[string]*providerCircuit),
+	}
+}
+
+// providerHTTP is the outbound client shared by all social provider calls
+var providerHTTP = NewOutboundClient(DefaultOutboundPolicy)
+
+// SocialProviderHealth reports the health of the social providers contacted since startup
+func SocialProviderHealth() []ProviderHealth {
+	return providerHTTP.Health()
+}
+
+// Do sends req on behalf of provider. With idempotent unset the request is only retried when
+// it never reached the provider. A response is returned as is; 5xx and 429 answers count as
+// failures and are retried for idempotent requests.
+func (c *OutboundClient) Do(provider string, req *http.Request, idempotent bool) (*http.Response, error) {
+	if !c.allow(provider) {
+		log.Printf("Warning: outbound %s %s rejected: circuit open for provider %s", req.Method, redactedURL(req), provider)
+		return nil, ErrProviderUnavailable
+	}
+
+	traceID := uuid.New().String()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if req.Body != nil {
+				if req.GetBody == nil {
+					break
+				}
+				body, err := req.GetBody()
+				if err != nil {
+					break
+				}
+				req.Body = body
+			}
+			c.sleep(c.backoff(attempt))
+		}
+
+		start := c.now()
+		resp, err := c.client.Do(req)
+		latency := c.now().Sub(start)
+
+		failure := err
+		if err == nil && (resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests) {
+			failure = fmt.Errorf("provider answered with status %d", resp.StatusCode)
+		}
+		c.record(provider, latency, failure, attempt > 0)
+
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		log.Printf("outbound trace=%s provider=%s %s %s attempt=%d status=%d duration=%s", traceID, provider, req.Method, redactedURL(req), attempt+1, status, latency.Round(time.Millisecond))
+
+		retryable := failure != nil && (idempotent || isConnectError(err))
+		if !retryable || attempt >= c.policy.MaxRetries || !c.allow(provider) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+	return nil, ErrProviderUnavailable
+}
+
+// Get sends an idempotent GET request to provider
+func (c *OutboundClient) Get(provider string, req *http.Request) (*http.Response, error) {
+	return c.Do(provider, req, true)
+}
+
+// backoff returns the delay before the given retry
+func (c *OutboundClient) backoff(attempt int) time.Duration {
+	delay := c.policy.BaseBackoff << (attempt - 1)
+	if delay <= 0 {
+		return 0
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)))
+}
+
+// allow reports whether a call to provider may be made. An open circuit lets a single trial
+// call through once OpenDuration has passed.
+func (c *OutboundClient) allow(provider string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	circuit := c.circuit(provider)
+	switch circuit.health.State {
+	case CircuitOpen:
+		if c.now().Sub(*circuit.health.OpenedAt) < c.policy.OpenDuration {
+			circuit.health.Rejected++
+			return false
+		}
+		circuit.health.State = CircuitHalfOpen
+		circuit.trialPending = true
+		return true
+	case CircuitHalfOpen:
+		if circuit.trialPending {
+			circuit.health.Rejected++
+			return false
+		}
+		circuit.trialPending = true
+	}
+	return true
+}
+
+// record updates the statistics and circuit state of provider after an attempt
+func (c *OutboundClient) record(provider string, latency time.Duration, failure error, retry bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	circuit := c.circuit(provider)
+	health := &circuit.health
+	health.Requests++
+	if retry {
+		health.Retries++
+	}
+	circuit.totalLatency += latency
+	health.LastLatencyMs = latency.Milliseconds()
+	health.AverageLatencyMs = (circuit.totalLatency / time.Duration(health.Requests)).Milliseconds()
+	circuit.trialPending = false
+
+	if failure == nil {
+		health.ConsecutiveFailures = 0
+		health.State = CircuitClosed
+		health.OpenedAt = nil
+		return
+	}
+
+	now := c.now()
+	health.Failures++
+	health.ConsecutiveFailures++
+	health.LastError = failure.Error()
+	health.LastFailureAt = &now
+	if health.State == CircuitHalfOpen || health.ConsecutiveFailures >= c.policy.FailureThreshold {
+		if health.State != CircuitOpen {
+			log.Printf("Warning: circuit opened for social provider %s after %d consecutive failures: %v", provider, health.ConsecutiveFailures, failure)
+		}
+		health.State = CircuitOpen
+		health.OpenedAt = &now
+	}
+}
+
+// circuit returns the circuit of provider, creating it closed. c.mu must be held.
+func (c *OutboundClient) circuit(provider string) *providerCircuit {
+	circuit, ok := c.circuits[provider]
+	if !ok {
+		circuit = &providerCircuit{health: ProviderHealth{Provider: provider, State: CircuitClosed}}
+		c.circuits[provider] = circuit
+	}
+	return circuit
+}
+
+// Health returns a snapshot of the providers' health ordered by provider name
+func (c *OutboundClient) Health() []ProviderHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]ProviderHealth, 0, len(c.circuits))
+	for _, circuit := range c.circuits {
+		result = append(result, circuit.health)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Provider < result[j].Provider })
+	return result
+}
+
+// isConnectError reports whether err happened before the request was sent, so retrying it
+// cannot repeat a request the provider already processed
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// redactedURL returns the URL of req without its query, which may carry tokens
+func redactedURL(req *http.Request) string {
+	return req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+}
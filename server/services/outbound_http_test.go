@@ -0,0 +1,165 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestOutboundClient() (*OutboundClient, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := NewOutboundClient(OutboundPolicy{
+		Timeout:          time.Second,
+		MaxRetries:       2,
+		BaseBackoff:      time.Millisecond,
+		FailureThreshold: 3,
+		OpenDuration:     time.Minute,
+	})
+	client.now = func() time.Time { return now }
+	client.sleep = func(time.Duration) {}
+	return client, &now
+}
+
+func newStatusServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(statuses) {
+			n = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[n])
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestOutboundClientRetries(t *testing.T) {
+	t.Run("idempotent request is retried until it succeeds", func(t *testing.T) {
+		client, _ := newTestOutboundClient()
+		server, calls := newStatusServer(t, http.StatusBadGateway, http.StatusOK)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Get("google", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || *calls != 2 {
+			t.Fatalf("status %d after %d calls, want 200 after 2", resp.StatusCode, *calls)
+		}
+		health := client.Health()[0]
+		if health.Requests != 2 || health.Failures != 1 || health.Retries != 1 || health.State != CircuitClosed {
+			t.Fatalf("unexpected health %+v", health)
+		}
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		client, _ := newTestOutboundClient()
+		server, calls := newStatusServer(t, http.StatusServiceUnavailable)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Get("google", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable || *calls != 3 {
+			t.Fatalf("status %d after %d calls, want 503 after 3", resp.StatusCode, *calls)
+		}
+	})
+
+	t.Run("non-idempotent request is not retried after reaching the provider", func(t *testing.T) {
+		client, _ := newTestOutboundClient()
+		server, calls := newStatusServer(t, http.StatusBadGateway, http.StatusOK)
+
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("code=abc"))
+		resp, err := client.Do("google", req, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway || *calls != 1 {
+			t.Fatalf("status %d after %d calls, want 502 after 1", resp.StatusCode, *calls)
+		}
+	})
+
+	t.Run("client errors are not retried", func(t *testing.T) {
+		client, _ := newTestOutboundClient()
+		server, calls := newStatusServer(t, http.StatusUnauthorized)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Get("google", req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if *calls != 1 || client.Health()[0].Failures != 0 {
+			t.Fatalf("4xx answer was retried or counted as a failure")
+		}
+	})
+}
+
+func TestOutboundClientCircuitBreaker(t *testing.T) {
+	client, now := newTestOutboundClient()
+	client.policy.MaxRetries = 0
+	server, calls := newStatusServer(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+
+	get := func() error {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Get("github", req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := get(); err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i, err)
+		}
+	}
+	if state := client.Health()[0].State; state != CircuitOpen {
+		t.Fatalf("state %s after threshold failures, want open", state)
+	}
+
+	if err := get(); err != ErrProviderUnavailable {
+		t.Fatalf("open circuit: got %v, want ErrProviderUnavailable", err)
+	}
+	if *calls != 3 {
+		t.Fatalf("open circuit contacted the provider")
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if err := get(); err != nil {
+		t.Fatalf("trial call: unexpected error: %v", err)
+	}
+	health := client.Health()[0]
+	if health.State != CircuitClosed || health.Rejected != 1 || health.ConsecutiveFailures != 0 {
+		t.Fatalf("unexpected health after successful trial %+v", health)
+	}
+}
+
+func TestOutboundClientHalfOpenFailureReopens(t *testing.T) {
+	client, now := newTestOutboundClient()
+	client.policy.MaxRetries = 0
+	client.policy.FailureThreshold = 1
+	server, _ := newStatusServer(t, http.StatusBadGateway)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, _ := client.Get("facebook", req)
+	resp.Body.Close()
+
+	*now = now.Add(2 * time.Minute)
+	resp, _ = client.Get("facebook", req)
+	resp.Body.Close()
+
+	health := client.Health()[0]
+	if health.State != CircuitOpen || !health.OpenedAt.Equal(*now) {
+		t.Fatalf("failed trial should reopen the circuit, got %+v", health)
+	}
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+const (
+	// hibpProvider names the range API in outbound call logs and circuit breaker health
+	hibpProvider = "hibp"
+	// hibpPrefixLength is how many hex characters of a password's SHA-1 are sent to the range API
+	hibpPrefixLength = 5
+	// hibpCachedRanges bounds the range cache; a range answer is about 35 KB
+	hibpCachedRanges = 512
+	// passwordBreachTimeout bounds a breach lookup, including retries of the range API
+	passwordBreachTimeout = 10 * time.Second
+	// bloomFilterMagic starts every bloom filter file
+	bloomFilterMagic = "BRF1"
+)
+
+// Password breach policy modes of a tenant (TenantSettings.PasswordBreach.Mode)
+const (
+	PasswordBreachOff    = "off"
+	PasswordBreachReject = "reject"
+)
+
+var (
+	ErrPasswordBreached          = errors.New("this password has appeared in a data breach, choose a different one")
+	ErrPasswordBreachUnavailable = errors.New("breached passwords can't be looked up right now")
+	ErrInvalidBloomFilter        = errors.New("not a breached password bloom filter")
+)
+
+// PasswordBreachChecker looks passwords up in a corpus of passwords exposed in data breaches
+type PasswordBreachChecker interface {
+	// Occurrences returns how often the password was seen in breaches, 0 if never
+	Occurrences(ctx context.Context, password string) (int, error)
+}
+
+// PasswordBreachService refuses passwords found in data breaches according to each tenant's
+// policy. Checkers are asked in order until one answers, so a local bloom filter can stand in
+// while the range API is unreachable.
+type PasswordBreachService struct {
+	tenants  *TenantService
+	checkers []PasswordBreachChecker
+}
+
+// NewPasswordBreachService checks passwords with the given checkers
+func NewPasswordBreachService(tenants *TenantService, checkers ...PasswordBreachChecker) *PasswordBreachService {
+	return &PasswordBreachService{tenants: tenants, checkers: checkers}
+}
+
+// Check returns ErrPasswordBreached when the tenant refuses breached passwords and password is
+// one. When no checker answers the password is accepted, unless the policy fails closed.
+func (s *PasswordBreachService) Check(tenantID, password string) error {
+	if s == nil {
+		return nil
+	}
+	tenant, err := s.tenants.GetTenantByID(tenantID)
+	if err != nil {
+		return err
+	}
+	return s.check(tenant.Settings.PasswordBreach, password)
+}
+
+func (s *PasswordBreachService) check(policy models.PasswordBreachPolicy, password string) error {
+	if policy.Mode != PasswordBreachReject {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), passwordBreachTimeout)
+	defer cancel()
+
+	occurrences, err := s.occurrences(ctx, password)
+	if err != nil {
+		if policy.FailClosed {
+			return ErrPasswordBreachUnavailable
+		}
+		log.Printf("Warning: password breach check skipped: %v", err)
+		return nil
+	}
+
+	minOccurrences := policy.MinOccurrences
+	if minOccurrences < 1 {
+		minOccurrences = 1
+	}
+	if occurrences >= minOccurrences {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+// occurrences asks the checkers in order and returns the first answer
+func (s *PasswordBreachService) occurrences(ctx context.Context, password string) (int, error) {
+	err := errors.New("no breached password source is configured")
+	for _, checker := range s.checkers {
+		occurrences, checkErr := checker.Occurrences(ctx, password)
+		if checkErr == nil {
+			return occurrences, nil
+		}
+		err = checkErr
+	}
+	return 0, err
+}
+
+// passwordSHA1 returns the upper case hex SHA-1 of a password, as breach corpora list them
+func passwordSHA1(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// hibpRange is a cached range API answer: "SUFFIX:COUNT" lines of the hashes sharing a prefix
+type hibpRange struct {
+	body      string
+	fetchedAt time.Time
+}
+
+// HIBPRangeChecker looks passwords up with the Have I Been Pwned range API. Only the first five
+// hex characters of the password's SHA-1 leave the server (k-anonymity), and answers are padded
+// so their size doesn't give the prefix away either. Answers are cached per prefix.
+type HIBPRangeChecker struct {
+	baseURL  string
+	client   *OutboundClient
+	cacheTTL time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]hibpRange
+}
+
+// NewHIBPRangeChecker queries the range API at baseURL (e.g. https://api.pwnedpasswords.com or
+// a mirror) and caches answers for cacheTTL; 0 disables the cache
+func NewHIBPRangeChecker(baseURL string, cacheTTL time.Duration) *HIBPRangeChecker {
+	return &HIBPRangeChecker{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		client:   NewOutboundClient(DefaultOutboundPolicy),
+		cacheTTL: cacheTTL,
+		now:      time.Now,
+		cache:    make(map[string]hibpRange),
+	}
+}
+
+// Occurrences returns how often the range API saw the password in breaches
+func (c *HIBPRangeChecker) Occurrences(ctx context.Context, password string) (int, error) {
+	hash := passwordSHA1(password)
+	prefix, suffix := hash[:hibpPrefixLength], hash[hibpPrefixLength:]
+
+	body, err := c.fetchRange(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return rangeOccurrences(body, suffix), nil
+}
+
+// fetchRange returns the range of prefix from the cache or the API
+func (c *HIBPRangeChecker) fetchRange(ctx context.Context, prefix string) (string, error) {
+	if cached, ok := c.cachedRange(prefix); ok {
+		return cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/range/"+prefix, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "ims-authy")
+
+	resp, err := c.client.Get(hibpProvider, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("range API answered with status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	body := string(data)
+	c.storeRange(prefix, body)
+	return body, nil
+}
+
+func (c *HIBPRangeChecker) cachedRange(prefix string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.cache[prefix]
+	if !ok || c.now().Sub(cached.fetchedAt) >= c.cacheTTL {
+		return "", false
+	}
+	return cached.body, true
+}
+
+// storeRange caches a range, making room by dropping expired ranges and then the oldest one
+func (c *HIBPRangeChecker) storeRange(prefix, body string) {
+	if c.cacheTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.cache) >= hibpCachedRanges {
+		oldest := ""
+		for key, cached := range c.cache {
+			if now.Sub(cached.fetchedAt) >= c.cacheTTL {
+				delete(c.cache, key)
+				continue
+			}
+			if oldest == "" || cached.fetchedAt.Before(c.cache[oldest].fetchedAt) {
+				oldest = key
+			}
+		}
+		if len(c.cache) >= hibpCachedRanges {
+			delete(c.cache, oldest)
+		}
+	}
+	c.cache[prefix] = hibpRange{body: body, fetchedAt: now}
+}
+
+// rangeOccurrences finds suffix in a range answer. Padding lines have a count of 0.
+func rangeOccurrences(body, suffix string) int {
+	for _, line := range strings.Split(body, "\n") {
+		hashSuffix, count, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok || !strings.EqualFold(hashSuffix, suffix) {
+			continue
+		}
+		occurrences, err := strconv.Atoi(count)
+		if err != nil {
+			return 0
+		}
+		return occurrences
+	}
+	return 0
+}
+
+// BloomFilterChecker looks passwords up in a bloom filter of breached password SHA-1 hashes,
+// without any network access. It never misses a password added to the filter but reports a
+// clean password as breached at the filter's false positive rate. Breach counts aren't kept, so
+// breached passwords count as seen once.
+type BloomFilterChecker struct {
+	bits   []byte
+	size   uint64 // bits
+	hashes uint32 // bit positions per entry
+}
+
+// NewBloomFilterChecker sizes an empty filter for entries hashes at the given false positive rate
+func NewBloomFilterChecker(entries uint64, falsePositiveRate float64) *BloomFilterChecker {
+	if entries == 0 {
+		entries = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.001
+	}
+	size := uint64(math.Ceil(-float64(entries) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	size = (size + 7) / 8 * 8
+	hashes := uint32(math.Round(float64(size) / float64(entries) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilterChecker{bits: make([]byte, size/8), size: size, hashes: hashes}
+}
+
+// LoadBloomFilterChecker reads a filter written by BloomFilterChecker.WriteTo
+func LoadBloomFilterChecker(path string) (*BloomFilterChecker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, len(bloomFilterMagic)+12)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(bloomFilterMagic)]) != bloomFilterMagic {
+		return nil, ErrInvalidBloomFilter
+	}
+	size := binary.BigEndian.Uint64(header[len(bloomFilterMagic):])
+	hashes := binary.BigEndian.Uint32(header[len(bloomFilterMagic)+8:])
+	if size == 0 || size%8 != 0 || hashes == 0 {
+		return nil, ErrInvalidBloomFilter
+	}
+	if info, err := file.Stat(); err == nil && uint64(info.Size()) != uint64(len(header))+size/8 {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	filter := &BloomFilterChecker{bits: make([]byte, size/8), size: size, hashes: hashes}
+	if _, err := io.ReadFull(r, filter.bits); err != nil {
+		return nil, ErrInvalidBloomFilter
+	}
+	return filter, nil
+}
+
+// WriteTo writes the filter: the magic, the size in bits and the number of hashes, then the bits
+func (f *BloomFilterChecker) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, len(bloomFilterMagic)+12)
+	copy(header, bloomFilterMagic)
+	binary.BigEndian.PutUint64(header[len(bloomFilterMagic):], f.size)
+	binary.BigEndian.PutUint32(header[len(bloomFilterMagic)+8:], f.hashes)
+
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(f.bits)
+	return int64(n + m), err
+}
+
+// AddHash adds a breached password given as hex SHA-1, as breach corpora list them
+func (f *BloomFilterChecker) AddHash(hash string) error {
+	sum, err := hex.DecodeString(hash)
+	if err != nil || len(sum) != sha1.Size {
+		return fmt.Errorf("invalid SHA-1 hash %q", hash)
+	}
+	f.positions(sum, func(bit uint64) bool {
+		f.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+	return nil
+}
+
+// Occurrences returns 1 when the password is in the filter
+func (f *BloomFilterChecker) Occurrences(_ context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	found := f.positions(sum[:], func(bit uint64) bool {
+		return f.bits[bit/8]&(1<<(bit%8)) != 0
+	})
+	if found {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// positions calls visit with the bit positions of a SHA-1 until it returns false. The digest is
+// already uniformly distributed, so its halves serve as the two hashes of double hashing.
+func (f *BloomFilterChecker) positions(sum []byte, visit func(bit uint64) bool) bool {
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		if !visit((h1 + i*h2) % f.size) {
+			return false
+		}
+	}
+	return true
+}
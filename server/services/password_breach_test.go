@@ -0,0 +1,130 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+// "password" is the best known breached password
+const breachedPassword = "password"
+
+func TestHIBPRangeCheckerSendsOnlyPrefixAndCaches(t *testing.T) {
+	hash := passwordSHA1(breachedPassword)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path != "/range/"+hash[:5] {
+			t.Errorf("request path = %s, want only the 5 character prefix", r.URL.Path)
+		}
+		if r.Header.Get("Add-Padding") != "true" {
+			t.Error("request doesn't ask for padding")
+		}
+		fmt.Fprintf(w, "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n%s:9659365\r\n00D4F6E8FA6EECAD2A3AA415EEC418D38EC:0\r\n", hash[5:])
+	}))
+	defer server.Close()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	checker := NewHIBPRangeChecker(server.URL+"/", time.Hour)
+	checker.now = func() time.Time { return now }
+
+	occurrences, err := checker.Occurrences(context.Background(), breachedPassword)
+	if err != nil || occurrences != 9659365 {
+		t.Fatalf("Occurrences(breached) = %d, %v", occurrences, err)
+	}
+	if occurrences := rangeOccurrences("00D4F6E8FA6EECAD2A3AA415EEC418D38EC:0\r\n", "00D4F6E8FA6EECAD2A3AA415EEC418D38EC"); occurrences != 0 {
+		t.Errorf("padding entry counted %d times", occurrences)
+	}
+	if _, err := checker.Occurrences(context.Background(), breachedPassword); err != nil || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("cached lookup made %d calls, err = %v", calls, err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := checker.Occurrences(context.Background(), breachedPassword); err != nil || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("lookup after the cache expired made %d calls, err = %v", calls, err)
+	}
+}
+
+func TestBloomFilterCheckerRoundTrip(t *testing.T) {
+	filter := NewBloomFilterChecker(1000, 0.001)
+	if err := filter.AddHash(passwordSHA1(breachedPassword)); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.AddHash("not a hash"); err == nil {
+		t.Error("AddHash() accepted an invalid hash")
+	}
+
+	var buf bytes.Buffer
+	if _, err := filter.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "breached.bloom")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBloomFilterChecker(path)
+	if err != nil {
+		t.Fatalf("LoadBloomFilterChecker() error = %v", err)
+	}
+
+	if occurrences, _ := loaded.Occurrences(context.Background(), breachedPassword); occurrences != 1 {
+		t.Errorf("Occurrences(breached) = %d, want 1", occurrences)
+	}
+	if occurrences, _ := loaded.Occurrences(context.Background(), "correct horse battery staple 8f3k"); occurrences != 0 {
+		t.Errorf("Occurrences(clean) = %d, want 0", occurrences)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes()[:buf.Len()-1], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBloomFilterChecker(path); err != ErrInvalidBloomFilter {
+		t.Errorf("LoadBloomFilterChecker(truncated) error = %v", err)
+	}
+}
+
+type staticBreachChecker struct {
+	occurrences int
+	err         error
+}
+
+func (c staticBreachChecker) Occurrences(context.Context, string) (int, error) {
+	return c.occurrences, c.err
+}
+
+func TestPasswordBreachServicePolicy(t *testing.T) {
+	unavailable := staticBreachChecker{err: errors.New("offline")}
+	reject := models.PasswordBreachPolicy{Mode: PasswordBreachReject}
+
+	tests := []struct {
+		name     string
+		policy   models.PasswordBreachPolicy
+		checkers []PasswordBreachChecker
+		want     error
+	}{
+		{"off", models.PasswordBreachPolicy{}, []PasswordBreachChecker{staticBreachChecker{occurrences: 5}}, nil},
+		{"breached", reject, []PasswordBreachChecker{staticBreachChecker{occurrences: 5}}, ErrPasswordBreached},
+		{"clean", reject, []PasswordBreachChecker{staticBreachChecker{}}, nil},
+		{"below minimum", models.PasswordBreachPolicy{Mode: PasswordBreachReject, MinOccurrences: 10}, []PasswordBreachChecker{staticBreachChecker{occurrences: 5}}, nil},
+		{"fallback", reject, []PasswordBreachChecker{unavailable, staticBreachChecker{occurrences: 1}}, ErrPasswordBreached},
+		{"fail open", reject, []PasswordBreachChecker{unavailable}, nil},
+		{"fail closed", models.PasswordBreachPolicy{Mode: PasswordBreachReject, FailClosed: true}, []PasswordBreachChecker{unavailable}, ErrPasswordBreachUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewPasswordBreachService(nil, tt.checkers...)
+			if err := service.check(tt.policy, breachedPassword); err != tt.want {
+				t.Errorf("check() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pdfPageWidth    = 595 // A4 in points
+	pdfPageHeight   = 842
+	pdfMargin       = 50
+	pdfFontSize     = 10
+	pdfLineHeight   = 14
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+)
+
+// RenderTextPDF renders lines of plain text into a minimal multi-page PDF document using
+// the built-in Helvetica font. Characters outside Latin-1 are replaced with '?'.
+func RenderTextPDF(lines []string) []byte {
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+
+	var pages [][]string
+	for start := 0; start < len(lines); start += pdfLinesPerPage {
+		end := start + pdfLinesPerPage
+		if end > len(lines) {
+			end = len(lines)
+		}
+		pages = append(pages, lines[start:end])
+	}
+
+	// Object layout: 1 catalog, 2 page tree, 3 font, then a page and content object per page
+	var objects []string
+	objects = append(objects, "<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+
+	for i, pageLines := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range pageLines {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", escapePDFText(line))
+		}
+		content.WriteString("ET")
+
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+2*i))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xrefOffset := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefOffset)
+
+	return out.Bytes()
+}
+
+// escapePDFText escapes a string for use in a PDF literal string
+func escapePDFText(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
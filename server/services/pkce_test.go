@@ -0,0 +1,139 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPKCEPolicyCheck(t *testing.T) {
+	public := PKCEPolicy{Required: true, S256Only: true}
+	s256Only := PKCEPolicy{S256Only: true}
+	open := PKCEPolicy{}
+
+	tests := []struct {
+		name      string
+		policy    PKCEPolicy
+		challenge string
+		method    string
+		want      error
+	}{
+		{"public S256", public, "challenge", "S256", nil},
+		{"public plain", public, "challenge", "plain", ErrPKCERequired},
+		{"public without method", public, "challenge", "", ErrPKCERequired},
+		{"public without PKCE", public, "", "", ErrPKCERequired},
+		{"S256-only S256", s256Only, "challenge", "S256", nil},
+		{"S256-only plain", s256Only, "challenge", "plain", ErrPKCEMethodNotAllowed},
+		{"S256-only without method", s256Only, "challenge", "", ErrPKCEMethodNotAllowed},
+		{"S256-only without PKCE", s256Only, "", "", nil},
+		{"open plain", open, "challenge", "plain", nil},
+		{"open without PKCE", open, "", "", nil},
+		{"unknown method", open, "challenge", "S512", ErrInvalidCodeChallenge},
+		{"lowercase method", open, "challenge", "s256", ErrInvalidCodeChallenge},
+		{"method without challenge", open, "", "S256", ErrInvalidCodeChallenge},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.Check(tt.challenge, tt.method); got != tt.want {
+			t.Errorf("%s: Check(%q, %q) = %v, want %v", tt.name, tt.challenge, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestPKCEPolicyOf(t *testing.T) {
+	tests := []struct {
+		client   *models.Client
+		settings models.TenantSettings
+		want     PKCEPolicy
+	}{
+		{&models.Client{}, models.TenantSettings{}, PKCEPolicy{}},
+		{&models.Client{RequireS256PKCE: true}, models.TenantSettings{}, PKCEPolicy{S256Only: true}},
+		{&models.Client{}, models.TenantSettings{RequireS256PKCE: true}, PKCEPolicy{S256Only: true}},
+		{&models.Client{ClientType: models.ClientTypePublic}, models.TenantSettings{}, PKCEPolicy{Required: true, S256Only: true}},
+	}
+
+	for _, tt := range tests {
+		if got := pkcePolicy(tt.client, tt.settings); got != tt.want {
+			t.Errorf("pkcePolicy(%+v, %+v) = %+v, want %+v", tt.client, tt.settings, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "a-sufficiently-long-code-verifier-for-the-test-0123456789"
+	hash := sha256.Sum256([]byte(verifier))
+	s256 := base64.RawURLEncoding.EncodeToString(hash[:])
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		method    string
+		want      bool
+	}{
+		{"S256", verifier, s256, "S256", true},
+		{"plain", verifier, verifier, "plain", true},
+		{"plain without method", verifier, verifier, "", true},
+		{"S256 challenge checked as plain", s256, s256, "plain", true},
+		{"S256 challenge with plain verifier", verifier, s256, "plain", false},
+		{"plain challenge checked as S256", verifier, verifier, "S256", false},
+		{"wrong verifier", "other-verifier", s256, "S256", false},
+		{"empty verifier and challenge", "", "", "", false},
+		{"unknown method", verifier, verifier, "S512", false},
+	}
+
+	for _, tt := range tests {
+		if got := verifyPKCE(tt.verifier, tt.challenge, tt.method); got != tt.want {
+			t.Errorf("%s: verifyPKCE() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestPKCEDowngrade checks that an S256-only client can't get a plain code and that a code issued
+// for a challenge can't be redeemed without its verifier
+func TestPKCEDowngrade(t *testing.T) {
+	db := dbtest.New(t)
+	clientService := NewClientService(db)
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+
+	client := &models.Client{TenantID: "tenant-1", Name: "Web", RequireS256PKCE: true, RedirectURIs: []string{"https://app.example.com/cb"}}
+	if err := clientService.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient() error = %v", err)
+	}
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, TenantID: "tenant-1", Email: "jane@example.com", Active: true, CreatedAt: now, UpdatedAt: now})
+
+	verifier := "a-sufficiently-long-code-verifier-for-the-test-0123456789"
+	if err := oauthService.ValidateCodeChallenge(client.ClientID, "tenant-1", verifier, "plain"); !errors.Is(err, ErrPKCEMethodNotAllowed) {
+		t.Errorf("ValidateCodeChallenge() with a plain challenge error = %v, want ErrPKCEMethodNotAllowed", err)
+	}
+	if _, err := oauthService.CreateAuthorizationCode(client.ClientID, userID.Hex(), "tenant-1", "https://app.example.com/cb", []string{"openid"}, verifier, "plain", CodeBinding{}, ACRSingleFactor); !errors.Is(err, ErrPKCEMethodNotAllowed) {
+		t.Errorf("CreateAuthorizationCode() with a plain challenge error = %v, want ErrPKCEMethodNotAllowed", err)
+	}
+
+	hash := sha256.Sum256([]byte(verifier))
+	code, err := oauthService.CreateAuthorizationCode(client.ClientID, userID.Hex(), "tenant-1", "https://app.example.com/cb", []string{"openid"}, base64.RawURLEncoding.EncodeToString(hash[:]), "S256", CodeBinding{}, ACRSingleFactor)
+	if err != nil {
+		t.Fatalf("CreateAuthorizationCode() error = %v", err)
+	}
+	req := httptest.NewRequest("POST", "/oauth/token", nil)
+
+	// Redeeming with the client secret alone skips the challenge
+	if _, err := oauthService.ExchangeCodeForTokens(code, client.ClientID, client.ClientSecret, "https://app.example.com/cb", "tenant-1", req); !errors.Is(err, ErrCodeVerifierRequired) {
+		t.Errorf("ExchangeCodeForTokens() of a PKCE code error = %v, want ErrCodeVerifierRequired", err)
+	}
+	// Sending the S256 challenge itself as a plain verifier doesn't match either
+	if _, err := oauthService.ExchangeCodeForTokensPKCE(code, client.ClientID, base64.RawURLEncoding.EncodeToString(hash[:]), "https://app.example.com/cb", "tenant-1", req); err == nil {
+		t.Error("ExchangeCodeForTokensPKCE() accepted the challenge as verifier")
+	}
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"errors"
+
+	"oauth2-openid-server/models"
+)
+
+var (
+	ErrPublicClientSecret = errors.New("public clients have no client secret")
+	ErrPublicClientGrant  = errors.New("public clients only support the authorization_code and refresh_token grants")
+	ErrInvalidClientType  = errors.New("client_type must be confidential or public")
+)
+
+// publicClientGrantTypes are the grants a client without a secret can use
+var publicClientGrantTypes = []string{"authorization_code", "refresh_token"}
+
+// validateClientType checks the client type and the grant types of public clients
+func validateClientType(client *models.Client) error {
+	switch client.ClientType {
+	case "", models.ClientTypeConfidential:
+		return nil
+	case models.ClientTypePublic:
+		for _, grantType := range client.GrantTypes {
+			if !containsString(publicClientGrantTypes, grantType) {
+				return ErrPublicClientGrant
+			}
+		}
+		return nil
+	}
+	return ErrInvalidClientType
+}
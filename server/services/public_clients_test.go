@@ -0,0 +1,109 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidateClientType(t *testing.T) {
+	tests := []struct {
+		client *models.Client
+		want   error
+	}{
+		{&models.Client{GrantTypes: []string{"client_credentials"}}, nil},
+		{&models.Client{ClientType: models.ClientTypePublic, GrantTypes: []string{"authorization_code", "refresh_token"}}, nil},
+		{&models.Client{ClientType: models.ClientTypePublic, GrantTypes: []string{"client_credentials"}}, ErrPublicClientGrant},
+		{&models.Client{ClientType: models.ClientTypePublic, GrantTypes: []string{CIBAGrantType}}, ErrPublicClientGrant},
+		{&models.Client{ClientType: "native"}, ErrInvalidClientType},
+	}
+
+	for _, tt := range tests {
+		if got := validateClientType(tt.client); got != tt.want {
+			t.Errorf("validateClientType(%q, %v) = %v, want %v", tt.client.ClientType, tt.client.GrantTypes, got, tt.want)
+		}
+	}
+}
+
+// TestPublicClientFlow checks that a public client gets no secret, can't rotate or use one, and
+// exchanges codes only with S256 PKCE.
+func TestPublicClientFlow(t *testing.T) {
+	db := dbtest.New(t)
+	clientService := NewClientService(db)
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+
+	client := &models.Client{TenantID: "tenant-1", Name: "SPA", ClientType: models.ClientTypePublic, RedirectURIs: []string{"https://app.example.com/cb"}}
+	if err := clientService.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient() error = %v", err)
+	}
+	if client.ClientSecret != "" || client.ClientSecretExpiresAt != nil {
+		t.Errorf("public client got a secret: %+v", client)
+	}
+	if _, _, err := clientService.RegenerateClientSecret(client.ID.Hex(), "tenant-1"); !errors.Is(err, ErrPublicClientSecret) {
+		t.Errorf("RegenerateClientSecret() error = %v, want ErrPublicClientSecret", err)
+	}
+	if _, err := oauthService.ValidateClient(client.ClientID, "guessed"); !errors.Is(err, ErrPublicClientSecret) {
+		t.Errorf("ValidateClient() error = %v, want ErrPublicClientSecret", err)
+	}
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, TenantID: "tenant-1", Email: "jane@example.com", Active: true, CreatedAt: now, UpdatedAt: now})
+
+	if _, err := oauthService.CreateAuthorizationCode(client.ClientID, userID.Hex(), "tenant-1", "https://app.example.com/cb", []string{"openid"}, "verifier", "plain", CodeBinding{}, ACRSingleFactor); !errors.Is(err, ErrPKCERequired) {
+		t.Errorf("CreateAuthorizationCode() with a plain challenge error = %v, want ErrPKCERequired", err)
+	}
+
+	verifier := "a-sufficiently-long-code-verifier-for-the-test-0123456789"
+	hash := sha256.Sum256([]byte(verifier))
+	code, err := oauthService.CreateAuthorizationCode(client.ClientID, userID.Hex(), "tenant-1", "https://app.example.com/cb", []string{"openid"}, base64.RawURLEncoding.EncodeToString(hash[:]), "S256", CodeBinding{}, ACRSingleFactor)
+	if err != nil {
+		t.Fatalf("CreateAuthorizationCode() error = %v", err)
+	}
+	if _, err := oauthService.ExchangeCodeForTokensPKCE(code, client.ClientID, verifier, "https://app.example.com/cb", "tenant-1", httptest.NewRequest("POST", "/oauth/token", nil)); err != nil {
+		t.Errorf("ExchangeCodeForTokensPKCE() error = %v", err)
+	}
+}
+
+// TestRefreshTokenClientAuthentication checks that only public clients refresh tokens without a
+// secret: a confidential client's refresh token is useless without the client's secret.
+func TestRefreshTokenClientAuthentication(t *testing.T) {
+	db := dbtest.New(t)
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, TenantID: "tenant-1", Email: "jane@example.com", Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, db, "clients",
+		&models.Client{ID: primitive.NewObjectID(), TenantID: "tenant-1", ClientID: "backend", ClientSecret: "s3cret", ClientType: models.ClientTypeConfidential, GrantTypes: []string{"refresh_token"}, Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.Client{ID: primitive.NewObjectID(), TenantID: "tenant-1", ClientID: "spa", ClientType: models.ClientTypePublic, GrantTypes: []string{"refresh_token"}, Active: true, CreatedAt: now, UpdatedAt: now},
+	)
+	req := httptest.NewRequest("POST", "/oauth/token", nil)
+
+	backend, err := oauthService.IssueTokens(userID.Hex(), "tenant-1", "backend", []string{"openid"}, ACRSingleFactor, req)
+	if err != nil {
+		t.Fatalf("IssueTokens(backend) error = %v", err)
+	}
+	if _, err := oauthService.RefreshTokens(backend.RefreshToken, "backend", "", "", "tenant-1", req); err == nil || err.Error() != "invalid client credentials" {
+		t.Errorf("RefreshTokens() of a confidential client without a secret error = %v, want invalid client credentials", err)
+	}
+	if _, err := oauthService.RefreshTokens(backend.RefreshToken, "backend", "s3cret", "", "tenant-1", req); err != nil {
+		t.Errorf("RefreshTokens() of a confidential client with its secret error = %v", err)
+	}
+
+	spa, err := oauthService.IssueTokens(userID.Hex(), "tenant-1", "spa", []string{"openid"}, ACRSingleFactor, req)
+	if err != nil {
+		t.Fatalf("IssueTokens(spa) error = %v", err)
+	}
+	if _, err := oauthService.RefreshTokens(spa.RefreshToken, "spa", "", "", "tenant-1", req); err != nil {
+		t.Errorf("RefreshTokens() of a public client error = %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usagePeriodLayout = "2006-01"
+
+// ErrInvalidUsagePeriod is returned for usage periods not given as "YYYY-MM"
+var ErrInvalidUsagePeriod = errors.New("invalid period, expected YYYY-MM")
+
+// Quota resources reported in QuotaExceededError and TenantUsage
+const (
+	QuotaUsers   = "users"
+	QuotaClients = "clients"
+	QuotaTokens  = "tokens"
+)
+
+// QuotaExceededError is returned when creating a resource or issuing a token would exceed a
+// limit of the tenant's plan
+type QuotaExceededError struct {
+	Resource string
+	Limit    int64
+	Used     int64
+	ResetsAt *time.Time // start of the next period for monthly quotas
+}
+
+func (e *QuotaExceededError) Error() string {
+	switch e.Resource {
+	case QuotaTokens:
+		message := fmt.Sprintf("token quota exceeded: the tenant's plan allows %d tokens per month", e.Limit)
+		if e.ResetsAt != nil {
+			message += " (resets " + e.ResetsAt.Format("2006-01-02") + ")"
+		}
+		return message
+	case QuotaUsers:
+		return fmt.Sprintf("user quota exceeded: the tenant's plan allows at most %d users", e.Limit)
+	case QuotaClients:
+		return fmt.Sprintf("client quota exceeded: the tenant's plan allows at most %d clients", e.Limit)
+	}
+	return fmt.Sprintf("%s quota exceeded: limit is %d", e.Resource, e.Limit)
+}
+
+// IsQuotaExceeded reports whether err is a QuotaExceededError and returns it
+func IsQuotaExceeded(err error) (*QuotaExceededError, bool) {
+	var exceeded *QuotaExceededError
+	if errors.As(err, &exceeded) {
+		return exceeded, true
+	}
+	return nil, false
+}
+
+// QuotaService enforces the per-tenant plan limits and keeps the monthly token counters
+type QuotaService struct {
+	db               *database.MongoDB
+	usageCollection  *mongo.Collection
+	userCollection   *mongo.Collection
+	clientCollection *mongo.Collection
+	tenantService    *TenantService
+}
+
+// TenantUsage is the usage of a tenant against its quotas, as exposed for billing. Users and
+// clients are current totals; tokens are counted for the requested period.
+type TenantUsage struct {
+	TenantID    string     `json:"tenant_id"`
+	TenantName  string     `json:"tenant_name,omitempty"`
+	Period      string     `json:"period"`
+	PeriodStart time.Time  `json:"period_start"`
+	PeriodEnd   time.Time  `json:"period_end"`
+	Users       QuotaUsage `json:"users"`
+	Clients     QuotaUsage `json:"clients"`
+	Tokens      QuotaUsage `json:"tokens"`
+}
+
+// QuotaUsage is the consumption of a single quota. Limit and Remaining are omitted when unlimited.
+type QuotaUsage struct {
+	Used      int64  `json:"used"`
+	Limit     *int64 `json:"limit,omitempty"`
+	Remaining *int64 `json:"remaining,omitempty"`
+}
+
+func NewQuotaService(db *database.MongoDB) *QuotaService {
+	return &QuotaService{
+		db:               db,
+		usageCollection:  db.GetCollection("tenant_token_usage"),
+		userCollection:   db.GetCollection("users"),
+		clientCollection: db.GetCollection("clients"),
+		tenantService:    NewTenantService(db),
+	}
+}
+
+// EnsureIndexes creates the unique index the token counters rely on for atomic reservations
+func (s *QuotaService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.usageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "period", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// CheckUserQuota returns a QuotaExceededError if the tenant cannot have another user
+func (s *QuotaService) CheckUserQuota(tenantID string) error {
+	return s.checkCount(tenantID, QuotaUsers, s.userCollection, s.quotas(tenantID).MaxUsers)
+}
+
+// CheckClientQuota returns a QuotaExceededError if the tenant cannot have another client
+func (s *QuotaService) CheckClientQuota(tenantID string) error {
+	return s.checkCount(tenantID, QuotaClients, s.clientCollection, s.quotas(tenantID).MaxClients)
+}
+
+func (s *QuotaService) checkCount(tenantID, resource string, collection *mongo.Collection, limit int) error {
+	if tenantID == "" || limit <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Built-in system clients don't count towards a tenant's quota
+	count, err := collection.CountDocuments(ctx, bson.M{"tenant_id": tenantID, "system": bson.M{"$ne": true}})
+	if err != nil {
+		return err
+	}
+	if count >= int64(limit) {
+		return &QuotaExceededError{Resource: resource, Limit: int64(limit), Used: count}
+	}
+	return nil
+}
+
+// ReserveToken counts an access token against the tenant's monthly quota. The counter is only
+// incremented while below the limit, so concurrent token requests cannot overshoot it. Storage
+// failures are logged and do not block token issuance.
+func (s *QuotaService) ReserveToken(tenantID string) error {
+	if tenantID == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	period, _, periodEnd := usagePeriod(now)
+	limit := s.quotas(tenantID).MaxTokensPerMonth
+
+	filter := bson.M{"tenant_id": tenantID, "period": period}
+	if limit > 0 {
+		filter["tokens_issued"] = bson.M{"$lt": limit}
+	}
+
+	_, err := s.usageCollection.UpdateOne(ctx, filter, bson.M{
+		"$inc": bson.M{"tokens_issued": 1},
+		"$set": bson.M{"updated_at": now},
+	}, options.Update().SetUpsert(true))
+	if err == nil {
+		return nil
+	}
+
+	// The counter exists but is at the limit, so the upsert collided with the unique index
+	if limit > 0 && mongo.IsDuplicateKeyError(err) {
+		return &QuotaExceededError{Resource: QuotaTokens, Limit: int64(limit), Used: int64(limit), ResetsAt: &periodEnd}
+	}
+
+	log.Printf("Warning: Failed to count token issuance for tenant %s: %v", tenantID, err)
+	return nil
+}
+
+// GetTenantUsage returns a tenant's usage for a period ("YYYY-MM", empty for the current month)
+func (s *QuotaService) GetTenantUsage(tenantID, period string) (*TenantUsage, error) {
+	tenant, err := s.tenantService.GetTenantByID(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := s.collectUsage([]*models.Tenant{tenant}, period)
+	if err != nil {
+		return nil, err
+	}
+	return usage[0], nil
+}
+
+// GetAllTenantUsage returns the usage of every tenant for a period, e.g. for a billing export
+func (s *QuotaService) GetAllTenantUsage(period string) ([]*TenantUsage, error) {
+	tenants, err := s.tenantService.GetAllTenants()
+	if err != nil {
+		return nil, err
+	}
+	return s.collectUsage(tenants, period)
+}
+
+func (s *QuotaService) collectUsage(tenants []*models.Tenant, period string) ([]*TenantUsage, error) {
+	start, err := parseUsagePeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	period, periodStart, periodEnd := usagePeriod(start)
+
+	tenantIDs := make([]string, len(tenants))
+	for i, tenant := range tenants {
+		tenantIDs[i] = tenant.ID.Hex()
+	}
+
+	users, err := s.countByTenant(s.userCollection, tenantIDs)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := s.countByTenant(s.clientCollection, tenantIDs)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.tokensByTenant(tenantIDs, period)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]*TenantUsage, len(tenants))
+	for i, tenant := range tenants {
+		tenantID := tenantIDs[i]
+		quotas := tenant.Settings.Quotas
+		usage[i] = &TenantUsage{
+			TenantID:    tenantID,
+			TenantName:  tenant.Name,
+			Period:      period,
+			PeriodStart: periodStart,
+			PeriodEnd:   periodEnd,
+			Users:       quotaUsage(users[tenantID], quotas.MaxUsers),
+			Clients:     quotaUsage(clients[tenantID], quotas.MaxClients),
+			Tokens:      quotaUsage(tokens[tenantID], quotas.MaxTokensPerMonth),
+		}
+	}
+	return usage, nil
+}
+
+func (s *QuotaService) countByTenant(collection *mongo.Collection, tenantIDs []string) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"tenant_id": bson.M{"$in": tenantIDs}, "system": bson.M{"$ne": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$tenant_id", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		TenantID string `bson:"_id"`
+		Count    int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.TenantID] = row.Count
+	}
+	return counts, nil
+}
+
+func (s *QuotaService) tokensByTenant(tenantIDs []string, period string) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.usageCollection.Find(ctx, bson.M{"tenant_id": bson.M{"$in": tenantIDs}, "period": period})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var counters []models.TenantTokenUsage
+	if err := cursor.All(ctx, &counters); err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]int64, len(counters))
+	for _, counter := range counters {
+		tokens[counter.TenantID] += counter.TokensIssued
+	}
+	return tokens, nil
+}
+
+// quotas returns the tenant's limits; unknown tenants are unlimited
+func (s *QuotaService) quotas(tenantID string) models.TenantQuotas {
+	if tenantID == "" {
+		return models.TenantQuotas{}
+	}
+	tenant, err := s.tenantService.GetTenantByID(tenantID)
+	if err != nil {
+		return models.TenantQuotas{}
+	}
+	return tenant.Settings.Quotas
+}
+
+// parseUsagePeriod returns the start of a "YYYY-MM" period; an empty period means the current month
+func parseUsagePeriod(period string) (time.Time, error) {
+	if period == "" {
+		return time.Now().UTC(), nil
+	}
+	start, err := time.Parse(usagePeriodLayout, period)
+	if err != nil {
+		return time.Time{}, ErrInvalidUsagePeriod
+	}
+	return start, nil
+}
+
+// usagePeriod returns the calendar month (UTC) containing t and its bounds
+func usagePeriod(t time.Time) (string, time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start.Format(usagePeriodLayout), start, start.AddDate(0, 1, 0)
+}
+
+func quotaUsage(used int64, limit int) QuotaUsage {
+	usage := QuotaUsage{Used: used}
+	if limit > 0 {
+		max := int64(limit)
+		remaining := max - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage.Limit = &max
+		usage.Remaining = &remaining
+	}
+	return usage
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsagePeriod(t *testing.T) {
+	period, start, end := usagePeriod(time.Date(2026, time.December, 31, 23, 59, 0, 0, time.UTC))
+
+	if period != "2026-12" {
+		t.Errorf("Expected period 2026-12, got %s", period)
+	}
+	if !start.Equal(time.Date(2026, time.December, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the period to start on the first of the month, got %v", start)
+	}
+	if !end.Equal(time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the period to end on the first of the next month, got %v", end)
+	}
+}
+
+func TestQuotaUsage(t *testing.T) {
+	unlimited := quotaUsage(42, 0)
+	if unlimited.Used != 42 || unlimited.Limit != nil || unlimited.Remaining != nil {
+		t.Errorf("Expected no limit for an unlimited quota, got %+v", unlimited)
+	}
+
+	limited := quotaUsage(12, 10)
+	if limited.Limit == nil || *limited.Limit != 10 {
+		t.Fatalf("Expected limit 10, got %+v", limited)
+	}
+	if *limited.Remaining != 0 {
+		t.Errorf("Expected remaining to stay at 0 when over the limit, got %d", *limited.Remaining)
+	}
+}
+
+func TestQuotaExceededError(t *testing.T) {
+	resetsAt := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	var err error = &QuotaExceededError{Resource: QuotaTokens, Limit: 1000, Used: 1000, ResetsAt: &resetsAt}
+
+	if !strings.Contains(err.Error(), "1000 tokens per month") || !strings.Contains(err.Error(), "2026-11-01") {
+		t.Errorf("Expected the limit and reset date in the message, got %q", err.Error())
+	}
+
+	exceeded, ok := IsQuotaExceeded(fmt.Errorf("issue tokens: %w", err))
+	if !ok || exceeded.Resource != QuotaTokens {
+		t.Errorf("Expected a wrapped quota error to be detected, got %v", exceeded)
+	}
+	if _, ok := IsQuotaExceeded(fmt.Errorf("other")); ok {
+		t.Error("Expected other errors not to be quota errors")
+	}
+}
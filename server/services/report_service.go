@@ -0,0 +1,471 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// MaxReportPeriod is the longest period a single report may cover
+	MaxReportPeriod    = 366 * 24 * time.Hour
+	weeklyReportPeriod = 7 * 24 * time.Hour
+	reportDateFormat   = "2006-01-02"
+)
+
+// ReportService builds tenant activity reports and sends the scheduled weekly summaries
+type ReportService struct {
+	db            *database.MongoDB
+	runs          *mongo.Collection
+	auditService  *AuditService
+	tenantService *TenantService
+}
+
+// TenantReport summarizes a tenant's activity in the period [From, To)
+type TenantReport struct {
+	TenantID    string              `json:"tenant_id"`
+	TenantName  string              `json:"tenant_name"`
+	From        time.Time           `json:"from"`
+	To          time.Time           `json:"to"`
+	GeneratedAt time.Time           `json:"generated_at"`
+	Summary     ReportSummary       `json:"summary"`
+	Daily       []DailyReportStats  `json:"daily"`
+	AuditEvents []AuditEventCount   `json:"audit_events"`
+	TopClients  []ReportClientUsage `json:"top_clients"`
+}
+
+// ReportSummary holds the period totals and the current resource counts
+type ReportSummary struct {
+	Logins        int64 `json:"logins"`
+	FailedLogins  int64 `json:"failed_logins"`
+	NewUsers      int64 `json:"new_users"`
+	TokensIssued  int64 `json:"tokens_issued"`
+	TotalUsers    int64 `json:"total_users"`
+	ActiveUsers   int64 `json:"active_users"`
+	TotalGroups   int64 `json:"total_groups"`
+	TotalClients  int64 `json:"total_clients"`
+	ActiveClients int64 `json:"active_clients"`
+}
+
+// DailyReportStats holds the activity counters for a single UTC day
+type DailyReportStats struct {
+	Date         string `json:"date"`
+	Logins       int64  `json:"logins"`
+	FailedLogins int64  `json:"failed_logins"`
+	NewUsers     int64  `json:"new_users"`
+	TokensIssued int64  `json:"tokens_issued"`
+}
+
+// ReportClientUsage is the number of tokens issued to a client in the period
+type ReportClientUsage struct {
+	ClientID   string `bson:"_id" json:"client_id"`
+	ClientName string `bson:"-" json:"client_name"`
+	Tokens     int64  `bson:"count" json:"tokens"`
+}
+
+// reportRun remembers when a scheduled report was last sent to a tenant
+type reportRun struct {
+	TenantID   string    `bson:"tenant_id"`
+	Type       string    `bson:"type"`
+	LastSentAt time.Time `bson:"last_sent_at"`
+}
+
+func NewReportService(db *database.MongoDB, auditService *AuditService, tenantService *TenantService) *ReportService {
+	return &ReportService{
+		db:            db,
+		runs:          db.GetCollection("report_runs"),
+		auditService:  auditService,
+		tenantService: tenantService,
+	}
+}
+
+// BuildReport collects a tenant's activity between from (inclusive) and to (exclusive)
+func (s *ReportService) BuildReport(tenantID string, from, to time.Time) (*TenantReport, error) {
+	from = from.UTC()
+	to = to.UTC()
+	if !to.After(from) {
+		return nil, errors.New("report period end must be after its start")
+	}
+	if to.Sub(from) > MaxReportPeriod {
+		return nil, fmt.Errorf("report period must not exceed %d days", int(MaxReportPeriod.Hours()/24))
+	}
+
+	report := &TenantReport{
+		TenantID:    tenantID,
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now().UTC(),
+	}
+	if tenant, err := s.tenantService.GetTenantByID(tenantID); err == nil {
+		report.TenantName = tenant.Name
+	}
+
+	if err := s.countResources(tenantID, &report.Summary); err != nil {
+		return nil, err
+	}
+
+	auditEvents, err := s.auditService.CountByType(tenantID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	report.AuditEvents = auditEvents
+
+	logins, err := s.auditService.CountByDay(tenantID, models.AuditEventLoginSuccess, from, to)
+	if err != nil {
+		return nil, err
+	}
+	failedLogins, err := s.auditService.CountByDay(tenantID, models.AuditEventLoginFailure, from, to)
+	if err != nil {
+		return nil, err
+	}
+	newUsers, err := s.countCreatedByDay("users", tenantID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.countCreatedByDay("access_tokens", tenantID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Daily = buildDailyStats(from, to, logins, failedLogins, newUsers, tokens)
+	for _, day := range report.Daily {
+		report.Summary.Logins += day.Logins
+		report.Summary.FailedLogins += day.FailedLogins
+		report.Summary.NewUsers += day.NewUsers
+		report.Summary.TokensIssued += day.TokensIssued
+	}
+
+	topClients, err := s.topClients(tenantID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	report.TopClients = topClients
+
+	return report, nil
+}
+
+func (s *ReportService) countResources(tenantID string, summary *ReportSummary) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	counts := []struct {
+		collection string
+		filter     bson.M
+		target     *int64
+	}{
+		{"users", bson.M{"tenant_id": tenantID}, &summary.TotalUsers},
+		{"users", bson.M{"tenant_id": tenantID, "active": true}, &summary.ActiveUsers},
+		{"groups", bson.M{"tenant_id": tenantID}, &summary.TotalGroups},
+		{"clients", bson.M{"tenant_id": tenantID}, &summary.TotalClients},
+		{"clients", bson.M{"tenant_id": tenantID, "active": true}, &summary.ActiveClients},
+	}
+
+	for _, c := range counts {
+		count, err := s.db.ReadCollection(database.ReadPathDashboard, c.collection).CountDocuments(ctx, c.filter)
+		if err != nil {
+			return err
+		}
+		*c.target = count
+	}
+
+	return nil
+}
+
+// countCreatedByDay counts a tenant's documents per UTC day of their created_at
+func (s *ReportService) countCreatedByDay(collection, tenantID string, from, to time.Time) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"tenant_id":  tenantID,
+			"created_at": bson.M{"$gte": from, "$lt": to},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+
+	return aggregateDailyCounts(ctx, s.db.ReadCollection(database.ReadPathDashboard, collection), pipeline)
+}
+
+func (s *ReportService) topClients(tenantID string, from, to time.Time) ([]ReportClientUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"tenant_id":  tenantID,
+			"created_at": bson.M{"$gte": from, "$lt": to},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$client_id", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"count": -1}}},
+		{{Key: "$limit", Value: 10}},
+	}
+
+	cursor, err := s.db.ReadCollection(database.ReadPathDashboard, "access_tokens").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	clients := []ReportClientUsage{}
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+
+	clientService := NewClientService(s.db)
+	for i := range clients {
+		clients[i].ClientName = clients[i].ClientID
+		if client, err := clientService.GetClientByClientID(clients[i].ClientID, tenantID); err == nil {
+			clients[i].ClientName = client.Name
+		}
+	}
+
+	return clients, nil
+}
+
+// SendWeeklyReports emails the last week's summary to the administrators of every tenant that
+// enabled weekly reports and has not received one in the last seven days
+func (s *ReportService) SendWeeklyReports(notifier Notifier) error {
+	tenants, err := s.tenantService.GetAllTenants()
+	if err != nil {
+		return err
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.Add(-weeklyReportPeriod)
+
+	for _, tenant := range tenants {
+		if !tenant.Settings.Reports.WeeklyEnabled {
+			continue
+		}
+
+		tenantID := tenant.ID.Hex()
+		lastSent, err := s.lastSent(tenantID, "weekly")
+		if err != nil {
+			return err
+		}
+		if !lastSent.IsZero() && time.Since(lastSent) < weeklyReportPeriod {
+			continue
+		}
+
+		report, err := s.BuildReport(tenantID, from, to)
+		if err != nil {
+			log.Printf("Warning: Failed to build weekly report for tenant %s: %v", tenantID, err)
+			continue
+		}
+
+		recipients, err := s.reportRecipients(tenant)
+		if err != nil {
+			return err
+		}
+
+		for _, recipient := range recipients {
+			if err := notifier.Notify(&Notification{
+				Type:      "weekly_report",
+				TenantID:  tenantID,
+				Recipient: recipient,
+				Subject:   fmt.Sprintf("Weekly summary for %s (%s - %s)", tenant.Name, from.Format(reportDateFormat), to.AddDate(0, 0, -1).Format(reportDateFormat)),
+				Message:   strings.Join(report.TextLines(), "\n"),
+				Data: map[string]interface{}{
+					"from":          from,
+					"to":            to,
+					"logins":        report.Summary.Logins,
+					"failed_logins": report.Summary.FailedLogins,
+					"new_users":     report.Summary.NewUsers,
+					"tokens_issued": report.Summary.TokensIssued,
+				},
+			}); err != nil {
+				log.Printf("Warning: Failed to send weekly report to %s: %v", recipient, err)
+			}
+		}
+
+		if err := s.markSent(tenantID, "weekly"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reportRecipients returns the emails of the tenant's active administrators and configured recipients
+func (s *ReportService) reportRecipients(tenant *models.Tenant) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tenantID := tenant.ID.Hex()
+	recipients := []string{}
+
+	if adminGroup, err := NewGroupService(s.db).GetGroupByName("Administrators", tenantID); err == nil {
+		cursor, err := s.db.GetCollection("users").Find(ctx, bson.M{
+			"tenant_id": tenantID,
+			"groups":    adminGroup.ID.Hex(),
+			"active":    true,
+		}, options.Find().SetProjection(bson.M{"email": 1}))
+		if err != nil {
+			return nil, err
+		}
+
+		var admins []*models.User
+		if err := cursor.All(ctx, &admins); err != nil {
+			return nil, err
+		}
+		for _, admin := range admins {
+			if admin.Email != "" && !containsString(recipients, admin.Email) {
+				recipients = append(recipients, admin.Email)
+			}
+		}
+	}
+
+	for _, recipient := range tenant.Settings.Reports.Recipients {
+		if recipient != "" && !containsString(recipients, recipient) {
+			recipients = append(recipients, recipient)
+		}
+	}
+
+	return recipients, nil
+}
+
+func (s *ReportService) lastSent(tenantID, reportType string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var run reportRun
+	err := s.runs.FindOne(ctx, bson.M{"tenant_id": tenantID, "type": reportType}).Decode(&run)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return run.LastSentAt, err
+}
+
+func (s *ReportService) markSent(tenantID, reportType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.runs.UpdateOne(ctx,
+		bson.M{"tenant_id": tenantID, "type": reportType},
+		bson.M{"$set": bson.M{"last_sent_at": time.Now()}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// buildDailyStats produces one row per UTC day in [from, to), filling days without activity with zeros
+func buildDailyStats(from, to time.Time, logins, failedLogins, newUsers, tokens map[string]int64) []DailyReportStats {
+	days := []DailyReportStats{}
+	for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(reportDateFormat)
+		days = append(days, DailyReportStats{
+			Date:         date,
+			Logins:       logins[date],
+			FailedLogins: failedLogins[date],
+			NewUsers:     newUsers[date],
+			TokensIssued: tokens[date],
+		})
+	}
+	return days
+}
+
+// CSV renders the report as CSV with one section per table, separated by blank lines
+func (r *TenantReport) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	itoa := func(v int64) string { return strconv.FormatInt(v, 10) }
+
+	rows := [][]string{
+		{"Tenant", r.TenantName},
+		{"From", r.From.Format(reportDateFormat)},
+		{"To", r.To.AddDate(0, 0, -1).Format(reportDateFormat)},
+		{"Generated at", r.GeneratedAt.Format(time.RFC3339)},
+		{},
+		{"Metric", "Value"},
+		{"Logins", itoa(r.Summary.Logins)},
+		{"Failed logins", itoa(r.Summary.FailedLogins)},
+		{"New users", itoa(r.Summary.NewUsers)},
+		{"Tokens issued", itoa(r.Summary.TokensIssued)},
+		{"Total users", itoa(r.Summary.TotalUsers)},
+		{"Active users", itoa(r.Summary.ActiveUsers)},
+		{"Total groups", itoa(r.Summary.TotalGroups)},
+		{"Total clients", itoa(r.Summary.TotalClients)},
+		{"Active clients", itoa(r.Summary.ActiveClients)},
+		{},
+		{"Date", "Logins", "Failed logins", "New users", "Tokens issued"},
+	}
+	for _, day := range r.Daily {
+		rows = append(rows, []string{day.Date, itoa(day.Logins), itoa(day.FailedLogins), itoa(day.NewUsers), itoa(day.TokensIssued)})
+	}
+
+	rows = append(rows, []string{}, []string{"Audit event", "Count"})
+	for _, event := range r.AuditEvents {
+		rows = append(rows, []string{event.Type, itoa(event.Count)})
+	}
+
+	rows = append(rows, []string{}, []string{"Client ID", "Client", "Tokens issued"})
+	for _, client := range r.TopClients {
+		rows = append(rows, []string{client.ClientID, client.ClientName, itoa(client.Tokens)})
+	}
+
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PDF renders the report as a plain text PDF document
+func (r *TenantReport) PDF() []byte {
+	return RenderTextPDF(r.TextLines())
+}
+
+// TextLines renders the report as plain text, used for PDF export and email summaries
+func (r *TenantReport) TextLines() []string {
+	lines := []string{
+		fmt.Sprintf("Activity report: %s", r.TenantName),
+		fmt.Sprintf("Period: %s to %s", r.From.Format(reportDateFormat), r.To.AddDate(0, 0, -1).Format(reportDateFormat)),
+		fmt.Sprintf("Generated: %s", r.GeneratedAt.Format(time.RFC1123)),
+		"",
+		"Summary",
+		fmt.Sprintf("  Logins:          %d", r.Summary.Logins),
+		fmt.Sprintf("  Failed logins:   %d", r.Summary.FailedLogins),
+		fmt.Sprintf("  New users:       %d", r.Summary.NewUsers),
+		fmt.Sprintf("  Tokens issued:   %d", r.Summary.TokensIssued),
+		fmt.Sprintf("  Users:           %d (%d active)", r.Summary.TotalUsers, r.Summary.ActiveUsers),
+		fmt.Sprintf("  Groups:          %d", r.Summary.TotalGroups),
+		fmt.Sprintf("  Clients:         %d (%d active)", r.Summary.TotalClients, r.Summary.ActiveClients),
+		"",
+		"Daily activity (date, logins, failed logins, new users, tokens issued)",
+	}
+	for _, day := range r.Daily {
+		lines = append(lines, fmt.Sprintf("  %s   %6d %6d %6d %6d", day.Date, day.Logins, day.FailedLogins, day.NewUsers, day.TokensIssued))
+	}
+
+	if len(r.AuditEvents) > 0 {
+		lines = append(lines, "", "Audit events")
+		for _, event := range r.AuditEvents {
+			lines = append(lines, fmt.Sprintf("  %-24s %d", event.Type, event.Count))
+		}
+	}
+
+	if len(r.TopClients) > 0 {
+		lines = append(lines, "", "Top clients by tokens issued")
+		for _, client := range r.TopClients {
+			lines = append(lines, fmt.Sprintf("  %-32s %d", client.ClientName, client.Tokens))
+		}
+	}
+
+	return lines
+}
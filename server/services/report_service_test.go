@@ -0,0 +1,106 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDailyStatsFillsEmptyDays(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 3)
+
+	days := buildDailyStats(from, to,
+		map[string]int64{"2024-03-01": 4},
+		map[string]int64{"2024-03-03": 2},
+		map[string]int64{},
+		map[string]int64{"2024-03-01": 7, "2024-03-04": 9},
+	)
+
+	if len(days) != 3 {
+		t.Fatalf("Expected 3 days, got %d", len(days))
+	}
+	if days[0].Date != "2024-03-01" || days[0].Logins != 4 || days[0].TokensIssued != 7 {
+		t.Errorf("Unexpected first day: %+v", days[0])
+	}
+	if days[1].Logins != 0 || days[1].FailedLogins != 0 {
+		t.Errorf("Expected empty second day, got %+v", days[1])
+	}
+	if days[2].FailedLogins != 2 {
+		t.Errorf("Unexpected last day: %+v", days[2])
+	}
+}
+
+func TestTenantReportCSV(t *testing.T) {
+	report := &TenantReport{
+		TenantName: "Acme, Inc.",
+		From:       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:         time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC),
+		Summary:    ReportSummary{Logins: 12, FailedLogins: 3},
+		Daily:      []DailyReportStats{{Date: "2024-03-01", Logins: 12, FailedLogins: 3}},
+		AuditEvents: []AuditEventCount{
+			{Type: "login_failure", Count: 3},
+		},
+	}
+
+	data, err := report.CSV()
+	if err != nil {
+		t.Fatalf("CSV failed: %v", err)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Generated CSV is invalid: %v", err)
+	}
+
+	if rows[0][1] != "Acme, Inc." {
+		t.Errorf("Expected tenant name to round-trip, got %q", rows[0][1])
+	}
+	if rows[2][1] != "2024-03-07" {
+		t.Errorf("Expected inclusive end date 2024-03-07, got %q", rows[2][1])
+	}
+	if !strings.Contains(string(data), "Failed logins,3") {
+		t.Errorf("Expected failed logins total in CSV:\n%s", data)
+	}
+}
+
+func TestRenderTextPDF(t *testing.T) {
+	lines := make([]string, pdfLinesPerPage+5)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("Line %d (with parens) and \\ backslash – dash", i)
+	}
+
+	pdf := RenderTextPDF(lines)
+	text := string(pdf)
+
+	if !strings.HasPrefix(text, "%PDF-1.4\n") || !strings.HasSuffix(text, "%%EOF\n") {
+		t.Fatal("Expected PDF header and trailer")
+	}
+	if !strings.Contains(text, "/Count 2") {
+		t.Error("Expected two pages")
+	}
+	if !strings.Contains(text, `(Line 0 \(with parens\) and \\ backslash ? dash) Tj`) {
+		t.Error("Expected escaped text")
+	}
+
+	// The startxref offset must point at the xref table and each entry at its object
+	startxref := text[strings.LastIndex(text, "startxref\n")+len("startxref\n"):]
+	offset, err := strconv.Atoi(strings.TrimSpace(strings.Split(startxref, "\n")[0]))
+	if err != nil || !strings.HasPrefix(text[offset:], "xref\n") {
+		t.Fatalf("startxref does not point at the xref table")
+	}
+
+	entries := strings.Split(text[offset:], "\n")[3:]
+	for i := 0; i < 7; i++ {
+		objOffset, _ := strconv.Atoi(entries[i][:10])
+		if want := fmt.Sprintf("%d 0 obj", i+1); !strings.HasPrefix(text[objOffset:], want) {
+			t.Errorf("xref entry %d points at %q", i+1, text[objOffset:objOffset+10])
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Scheduler runs background maintenance jobs at fixed intervals
+type Scheduler struct {
+	jobs []*scheduledJob
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		stop: make(chan struct{}),
+	}
+}
+
+// Every registers a job to run once at start-up and then at the given interval
+func (s *Scheduler) Every(name string, interval time.Duration, job func() error) {
+	s.jobs = append(s.jobs, &scheduledJob{
+		name:     name,
+		interval: interval,
+		run:      job,
+	})
+}
+
+// Start launches all registered jobs in the background
+func (s *Scheduler) Start() {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+	}
+}
+
+// Stop signals all jobs to stop and waits for running jobs to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(job *scheduledJob) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+
+	s.runJob(job)
+	for {
+		select {
+		case <-ticker.C:
+			s.runJob(job)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Scheduler) runJob(job *scheduledJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Warning: scheduled job %s panicked: %v", job.name, r)
+		}
+	}()
+
+	if err := job.run(); err != nil {
+		log.Printf("Warning: scheduled job %s failed: %v", job.name, err)
+	}
+}
@@ -10,6 +10,26 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// AdminScope grants full administration access, including endpoints that reveal token details
+const AdminScope = "admin"
+
+// UserManagementScope grants the administration of all users and groups of the tenant; group
+// managers administer the members of their groups without it
+const UserManagementScope = "user_management"
+
+// SupportScope marks tokens of support staff. Requests carrying it are read-only on all
+// administration endpoints and their responses are stripped of secrets.
+const SupportScope = "support"
+
+// ElevatedScope is only carried by short-lived elevated tokens, issued after the user re-enters
+// their password and second factor. Destructive administration requests require it.
+const ElevatedScope = "admin:system"
+
+// PlatformOperatorScope marks the platform operator, who manages the tenant lifecycle and
+// platform-wide settings. It only counts on tokens of the default tenant; admins of other tenants
+// manage their own tenant only.
+const PlatformOperatorScope = "platform:operator"
+
 type ScopeService struct {
 	collection *mongo.Collection
 }
@@ -125,6 +145,10 @@ func (s *ScopeService) InitializeDefaultScopes(tenantID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	return s.initializeDefaultScopes(ctx, tenantID)
+}
+
+func (s *ScopeService) initializeDefaultScopes(ctx context.Context, tenantID string) error {
 	// Check if any scopes already exist for this tenant
 	filter := bson.M{}
 	if tenantID != "" {
@@ -157,14 +181,21 @@ func (s *ScopeService) InitializeDefaultScopes(tenantID string) error {
 			Active:      true,
 		},
 		{
-			Name:        "admin",
+			Name:        AdminScope,
 			DisplayName: "Administrator",
 			Description: "Full system administration access",
 			Category:    "administrative",
 			Active:      true,
 		},
 		{
-			Name:        "user_management",
+			Name:        SupportScope,
+			DisplayName: "Support",
+			Description: "Read-only access to all administration endpoints for support staff",
+			Category:    "administrative",
+			Active:      true,
+		},
+		{
+			Name:        UserManagementScope,
 			DisplayName: "User Management",
 			Description: "Manage user accounts and groups",
 			Category:    "management",
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultScopeUsageDays is the reporting window used when none is given
+	DefaultScopeUsageDays = 30
+	// MaxScopeUsageDays is the longest reporting window; older counters expire
+	MaxScopeUsageDays   = 365
+	scopeUsageRetention = (MaxScopeUsageDays + 35) * 24 * time.Hour
+)
+
+type ScopeUsageService struct {
+	db         *database.MongoDB
+	collection *mongo.Collection
+	breaker    *database.CircuitBreaker // suspends counting while MongoDB times out
+}
+
+// ScopeUsageReport summarizes scope usage per client over a reporting window
+type ScopeUsageReport struct {
+	Days    int                 `json:"days"`
+	Since   time.Time           `json:"since"`
+	Clients []*ClientScopeUsage `json:"clients"`
+}
+
+// ClientScopeUsage lists a client's scope usage and the allowed scopes it has not used
+type ClientScopeUsage struct {
+	ClientID     string               `json:"client_id"`
+	ClientName   string               `json:"client_name,omitempty"`
+	Scopes       []*ScopeUsageSummary `json:"scopes"`
+	UnusedScopes []string             `json:"unused_scopes"`
+}
+
+// ScopeUsageSummary holds the aggregated counters for one client and scope
+type ScopeUsageSummary struct {
+	Scope           string     `bson:"scope" json:"scope"`
+	Requested       int64      `bson:"requested" json:"requested"`
+	Granted         int64      `bson:"granted" json:"granted"`
+	LastRequestedAt *time.Time `bson:"last_requested_at,omitempty" json:"last_requested_at,omitempty"`
+	LastGrantedAt   *time.Time `bson:"last_granted_at,omitempty" json:"last_granted_at,omitempty"`
+	Allowed         bool       `bson:"-" json:"allowed"`
+}
+
+func NewScopeUsageService(db *database.MongoDB) *ScopeUsageService {
+	return &ScopeUsageService{
+		db:         db,
+		collection: db.GetCollection("scope_usage"),
+		breaker:    db.Breaker("scope_usage"),
+	}
+}
+
+// EnsureIndexes creates the counter lookup index and expires old daily buckets
+func (s *ScopeUsageService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "client_id", Value: 1}, {Key: "scope", Value: 1}, {Key: "day", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "day", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(scopeUsageRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// RecordRequested counts scopes a client asked for in an authorization request
+func (s *ScopeUsageService) RecordRequested(tenantID, clientID string, scopes []string) {
+	s.record(tenantID, clientID, scopes, "requested", "last_requested_at")
+}
+
+// RecordGranted counts scopes issued to a client in a token
+func (s *ScopeUsageService) RecordGranted(tenantID, clientID string, scopes []string) {
+	s.record(tenantID, clientID, scopes, "granted", "last_granted_at")
+}
+
+// record increments the daily counters. Failures are logged and never fail the calling flow.
+func (s *ScopeUsageService) record(tenantID, clientID string, scopes []string, counter, lastField string) {
+	if clientID == "" || len(scopes) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	day := now.Truncate(24 * time.Hour)
+
+	writes := make([]mongo.WriteModel, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope == "" {
+			continue
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"tenant_id": tenantID, "client_id": clientID, "scope": scope, "day": day}).
+			SetUpdate(bson.M{
+				"$inc": bson.M{counter: 1},
+				"$max": bson.M{lastField: now},
+			}).
+			SetUpsert(true))
+	}
+	if len(writes) == 0 {
+		return
+	}
+
+	err := s.breaker.Do(func() error {
+		_, err := s.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		return err
+	})
+	if err != nil && err != database.ErrCircuitOpen {
+		log.Printf("Warning: Failed to record scope usage for client %s: %v", clientID, err)
+	}
+}
+
+// GetUsageReport aggregates scope usage for the tenant's clients over the last days and flags
+// allowed scopes that were never granted in that window. clientID optionally limits the report.
+func (s *ScopeUsageService) GetUsageReport(tenantID, clientID string, days int) (*ScopeUsageReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if days <= 0 {
+		days = DefaultScopeUsageDays
+	}
+	if days > MaxScopeUsageDays {
+		days = MaxScopeUsageDays
+	}
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+
+	match := bson.M{"tenant_id": tenantID, "day": bson.M{"$gte": since}}
+	if clientID != "" {
+		match["client_id"] = clientID
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               bson.M{"client_id": "$client_id", "scope": "$scope"},
+			"requested":         bson.M{"$sum": "$requested"},
+			"granted":           bson.M{"$sum": "$granted"},
+			"last_requested_at": bson.M{"$max": "$last_requested_at"},
+			"last_granted_at":   bson.M{"$max": "$last_granted_at"},
+		}}},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID struct {
+			ClientID string `bson:"client_id"`
+			Scope    string `bson:"scope"`
+		} `bson:"_id"`
+		ScopeUsageSummary `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string][]*ScopeUsageSummary)
+	for i := range rows {
+		summary := rows[i].ScopeUsageSummary
+		summary.Scope = rows[i].ID.Scope
+		usage[rows[i].ID.ClientID] = append(usage[rows[i].ID.ClientID], &summary)
+	}
+
+	var clients []*models.Client
+	if clientID != "" {
+		if client, err := NewClientService(s.db).GetClientByClientID(clientID, tenantID); err == nil {
+			clients = []*models.Client{client}
+		}
+	} else {
+		clients, err = NewClientService(s.db).GetAllClients(tenantID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ScopeUsageReport{
+		Days:    days,
+		Since:   since,
+		Clients: buildClientScopeUsage(clients, usage),
+	}, nil
+}
+
+// buildClientScopeUsage merges aggregated counters with each client's allowed scopes.
+// Clients without usage are included so that their allowed scopes are flagged as unused.
+func buildClientScopeUsage(clients []*models.Client, usage map[string][]*ScopeUsageSummary) []*ClientScopeUsage {
+	result := []*ClientScopeUsage{}
+	seen := make(map[string]bool)
+
+	for _, client := range clients {
+		seen[client.ClientID] = true
+
+		summaries := usage[client.ClientID]
+		granted := make(map[string]bool)
+		for _, summary := range summaries {
+			summary.Allowed = containsString(client.Scopes, summary.Scope)
+			if summary.Granted > 0 {
+				granted[summary.Scope] = true
+			}
+		}
+
+		unused := []string{}
+		for _, scope := range client.Scopes {
+			if !granted[scope] && !containsString(unused, scope) {
+				unused = append(unused, scope)
+			}
+		}
+		sort.Strings(unused)
+
+		result = append(result, &ClientScopeUsage{
+			ClientID:     client.ClientID,
+			ClientName:   client.Name,
+			Scopes:       sortedScopeSummaries(summaries),
+			UnusedScopes: unused,
+		})
+	}
+
+	// Usage recorded for clients that are not registered in the tenant (e.g. direct login)
+	var others []string
+	for clientID := range usage {
+		if !seen[clientID] {
+			others = append(others, clientID)
+		}
+	}
+	sort.Strings(others)
+	for _, clientID := range others {
+		result = append(result, &ClientScopeUsage{
+			ClientID:     clientID,
+			Scopes:       sortedScopeSummaries(usage[clientID]),
+			UnusedScopes: []string{},
+		})
+	}
+
+	return result
+}
+
+func sortedScopeSummaries(summaries []*ScopeUsageSummary) []*ScopeUsageSummary {
+	if summaries == nil {
+		return []*ScopeUsageSummary{}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Scope < summaries[j].Scope
+	})
+	return summaries
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestBuildClientScopeUsageFlagsUnusedScopes(t *testing.T) {
+	clients := []*models.Client{
+		{ClientID: "app", Name: "App", Scopes: []string{"write", "openid", "read"}},
+		{ClientID: "idle", Name: "Idle", Scopes: []string{"read"}},
+	}
+	usage := map[string][]*ScopeUsageSummary{
+		"app": {
+			{Scope: "read", Requested: 3, Granted: 3},
+			{Scope: "openid", Requested: 2, Granted: 2},
+			{Scope: "admin", Requested: 1, Granted: 0},
+			{Scope: "write", Requested: 1, Granted: 0},
+		},
+		"direct-login-client": {
+			{Scope: "read", Granted: 5},
+		},
+	}
+
+	report := buildClientScopeUsage(clients, usage)
+	if len(report) != 3 {
+		t.Fatalf("Expected 3 clients, got %d", len(report))
+	}
+
+	app := report[0]
+	if want := []string{"write"}; !reflect.DeepEqual(app.UnusedScopes, want) {
+		t.Errorf("Expected unused scopes %v, got %v", want, app.UnusedScopes)
+	}
+	if app.Scopes[0].Scope != "admin" || app.Scopes[0].Allowed {
+		t.Errorf("Expected admin to be reported first and not allowed, got %+v", app.Scopes[0])
+	}
+	if !app.Scopes[1].Allowed {
+		t.Errorf("Expected openid to be allowed, got %+v", app.Scopes[1])
+	}
+
+	idle := report[1]
+	if want := []string{"read"}; !reflect.DeepEqual(idle.UnusedScopes, want) || len(idle.Scopes) != 0 {
+		t.Errorf("Expected idle client to flag read as unused, got %+v", idle)
+	}
+
+	if report[2].ClientID != "direct-login-client" || len(report[2].UnusedScopes) != 0 {
+		t.Errorf("Expected unregistered client usage to be reported last, got %+v", report[2])
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// InvalidScopeError is returned when a request asks for scopes the tenant does not define or the
+// client is not allowed to request
+type InvalidScopeError struct {
+	Unknown    []string // not defined in the tenant's scopes
+	NotAllowed []string // not among the client's allowed scopes
+}
+
+func (e *InvalidScopeError) Error() string {
+	var parts []string
+	if len(e.Unknown) > 0 {
+		parts = append(parts, "unknown scope: "+strings.Join(e.Unknown, " "))
+	}
+	if len(e.NotAllowed) > 0 {
+		parts = append(parts, "scope not allowed for client: "+strings.Join(e.NotAllowed, " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// IsInvalidScope reports whether err is an InvalidScopeError
+func IsInvalidScope(err error) bool {
+	var invalid *InvalidScopeError
+	return errors.As(err, &invalid)
+}
+
+// ParseScopes splits a space-delimited scope parameter (RFC 6749 section 3.3) into its scopes,
+// dropping duplicates and keeping the order of first appearance
+func ParseScopes(scope string) []string {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if !containsString(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// GrantUserScopes keeps the requested scopes the user has been given. If none remain, the user
+// gets minimal read access.
+func GrantUserScopes(requested []string, user *models.User) []string {
+	var granted []string
+	for _, scope := range requested {
+		if containsString(user.Scopes, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	if len(granted) == 0 {
+		granted = []string{"read"}
+	}
+	return granted
+}
+
+// RequestedScopes parses the scope parameter of a request by clientID and validates it, see ValidateScopes
+func (s *OAuthService) RequestedScopes(tenantID, clientID, scope string) ([]string, error) {
+	client, err := s.getActiveClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	scopes := ParseScopes(scope)
+	if err := s.ValidateScopes(tenantID, client, scopes); err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+// ValidateScopes checks that every requested scope is defined in the tenant and allowed for the
+// client. A tenant without scope definitions and a client without a scope list accept any scope.
+func (s *OAuthService) ValidateScopes(tenantID string, client *models.Client, requested []string) error {
+	tenantScopes, err := s.tenantScopeNames(tenantID)
+	if err != nil {
+		return err
+	}
+	return checkScopes(requested, tenantScopes, client.Scopes)
+}
+
+// tenantScopeNames returns the names of the tenant's active scopes
+func (s *OAuthService) tenantScopeNames(tenantID string) ([]string, error) {
+	if tenantID == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	names, err := s.db.GetCollection("scopes").Distinct(ctx, "name", bson.M{"tenant_id": tenantID, "active": true})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if str, ok := name.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result, nil
+}
+
+func checkScopes(requested, tenantScopes, clientScopes []string) error {
+	invalid := &InvalidScopeError{}
+	for _, scope := range requested {
+		if len(tenantScopes) > 0 && !containsString(tenantScopes, scope) {
+			invalid.Unknown = append(invalid.Unknown, scope)
+		} else if len(clientScopes) > 0 && !containsString(clientScopes, scope) {
+			invalid.NotAllowed = append(invalid.NotAllowed, scope)
+		}
+	}
+	if len(invalid.Unknown) > 0 || len(invalid.NotAllowed) > 0 {
+		return invalid
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  []string
+	}{
+		{"", []string{}},
+		{"read:users", []string{"read:users"}},
+		{"openid  read:users\tread", []string{"openid", "read:users", "read"}},
+		{"openid openid profile", []string{"openid", "profile"}},
+		{"custom:scope", []string{"custom:scope"}},
+	}
+	for _, tt := range tests {
+		if got := ParseScopes(tt.scope); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseScopes(%q) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	tenantScopes := []string{"openid", "profile", "read", "read:users"}
+	clientScopes := []string{"openid", "read"}
+
+	if err := checkScopes([]string{"openid", "read"}, tenantScopes, clientScopes); err != nil {
+		t.Errorf("Expected allowed scopes to pass, got %v", err)
+	}
+
+	err := checkScopes([]string{"openid", "read:users", "admin:everything"}, tenantScopes, clientScopes)
+	invalid, ok := err.(*InvalidScopeError)
+	if !ok {
+		t.Fatalf("Expected an InvalidScopeError, got %v", err)
+	}
+	if !reflect.DeepEqual(invalid.Unknown, []string{"admin:everything"}) || !reflect.DeepEqual(invalid.NotAllowed, []string{"read:users"}) {
+		t.Errorf("Unexpected invalid scopes: %+v", invalid)
+	}
+	if !IsInvalidScope(err) {
+		t.Error("Expected IsInvalidScope to recognize the error")
+	}
+
+	if err := checkScopes([]string{"anything"}, nil, nil); err != nil {
+		t.Errorf("Expected a tenant and client without scope lists to accept any scope, got %v", err)
+	}
+}
+
+func TestGrantUserScopes(t *testing.T) {
+	user := &models.User{Scopes: []string{"openid", "read:users"}}
+
+	if got := GrantUserScopes([]string{"openid", "read", "admin"}, user); !reflect.DeepEqual(got, []string{"openid"}) {
+		t.Errorf("GrantUserScopes() = %v, want [openid]", got)
+	}
+	if got := GrantUserScopes([]string{"admin"}, user); !reflect.DeepEqual(got, []string{"read"}) {
+		t.Errorf("GrantUserScopes() without a match = %v, want [read]", got)
+	}
+}
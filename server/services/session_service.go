@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrSessionNotFound is returned when a session does not exist or belongs to another user
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionService gives users a view of where they are signed in. A session is a refresh token
+// grant, where rotated refresh tokens of the same grant share a family and count as one session,
+// or a browser's SSO session. Revocations are announced on the cluster event bus (see
+// ApplyRevocationEvent).
+type SessionService struct {
+	db                *database.MongoDB
+	refreshCollection *mongo.Collection
+	tokenCollection   *mongo.Collection
+	clientCollection  *mongo.Collection
+	eventCollection   *mongo.Collection
+	ssoSessions       *SSOSessionService
+}
+
+// Types of user sessions
+const (
+	UserSessionTypeGrant = "grant" // refresh token grant of a client
+	UserSessionTypeSSO   = "sso"   // browser signed in at the authorization server
+)
+
+// UserSession is an active refresh token grant or SSO session of a user
+type UserSession struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	ClientID   string     `json:"client_id"`
+	ClientName string     `json:"client_name,omitempty"`
+	Device     string     `json:"device,omitempty"` // user agent the session was last used from
+	IPAddress  string     `json:"ip_address,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+func NewSessionService(db *database.MongoDB) *SessionService {
+	return &SessionService{
+		db:                db,
+		refreshCollection: db.GetCollection("refresh_tokens"),
+		tokenCollection:   db.GetCollection("access_tokens"),
+		clientCollection:  db.GetCollection("clients"),
+		eventCollection:   clusterEventCollection(db),
+		// Only lists and ends SSO sessions, so the timeouts don't matter
+		ssoSessions: NewSSOSessionService(db, 0, 0),
+	}
+}
+
+// ListUserSessions returns the user's active grants and SSO sessions, most recently used first
+func (s *SessionService) ListUserSessions(userID, tenantID string) ([]*UserSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.refreshCollection.Find(ctx, bson.M{
+		"user_id":    userID,
+		"tenant_id":  tenantID,
+		"revoked":    false,
+		"expires_at": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []models.RefreshToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	ssoSessions, err := s.ssoSessions.ListForUser(userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	clientIDs := []string{}
+	for _, token := range tokens {
+		clientIDs = append(clientIDs, token.ClientID)
+	}
+	for _, ssoSession := range ssoSessions {
+		if ssoSession.DirectClientID != "" {
+			clientIDs = append(clientIDs, ssoSession.DirectClientID)
+		}
+	}
+	clientNames := s.clientNames(ctx, clientIDs)
+
+	sessions := make([]*UserSession, 0, len(tokens)+len(ssoSessions))
+	for _, token := range tokens {
+		sessions = append(sessions, &UserSession{
+			ID:         sessionID(token),
+			Type:       UserSessionTypeGrant,
+			ClientID:   token.ClientID,
+			ClientName: clientNames[token.ClientID],
+			Device:     token.UserAgent,
+			IPAddress:  token.IPAddress,
+			Scopes:     token.Scopes,
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+			ExpiresAt:  token.ExpiresAt,
+		})
+	}
+
+	for i := range ssoSessions {
+		ssoSession := &ssoSessions[i]
+		sessions = append(sessions, &UserSession{
+			ID:         ssoSession.ID.Hex(),
+			Type:       UserSessionTypeSSO,
+			ClientID:   ssoSession.DirectClientID,
+			ClientName: clientNames[ssoSession.DirectClientID],
+			CreatedAt:  ssoSession.CreatedAt,
+			LastUsedAt: &ssoSession.LastActiveAt,
+			ExpiresAt:  ssoSession.ExpiresAt,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessionActivity(sessions[i]).After(sessionActivity(sessions[j]))
+	})
+	return sessions, nil
+}
+
+// RevokeUserSession signs the user out of one session: the refresh tokens of a grant and the
+// access tokens issued with them are revoked, an SSO session is ended
+func (s *SessionService) RevokeUserSession(userID, tenantID, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.revokeSession(ctx, userID, tenantID, id); err != nil {
+		return err
+	}
+	s.announceRevocation(ctx, tenantID, map[string]interface{}{"user_id": userID, "session_id": id})
+	return nil
+}
+
+func (s *SessionService) revokeSession(ctx context.Context, userID, tenantID, id string) error {
+	filter := bson.M{"user_id": userID, "tenant_id": tenantID, "family_id": id}
+	if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+		delete(filter, "family_id")
+		filter["$or"] = []bson.M{{"family_id": id}, {"_id": objID}}
+	}
+
+	cursor, err := s.refreshCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var tokens []models.RefreshToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		if err := s.ssoSessions.End(userID, tenantID, id); err != nil {
+			if err == ErrSSOSessionNotFound {
+				return ErrSessionNotFound
+			}
+			return err
+		}
+		return nil
+	}
+
+	accessTokens := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token.AccessToken != "" {
+			accessTokens = append(accessTokens, token.AccessToken)
+		}
+	}
+
+	if _, err := s.refreshCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		return err
+	}
+	if len(accessTokens) > 0 {
+		if _, err := s.tokenCollection.UpdateMany(ctx, bson.M{"token": bson.M{"$in": accessTokens}}, bson.M{
+			"$set": bson.M{"revoked": true},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeAllUserSessions signs the user out everywhere: all of their refresh and access tokens
+// are revoked and their SSO sessions ended
+func (s *SessionService) RevokeAllUserSessions(userID, tenantID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.revokeAll(ctx, userID, tenantID); err != nil {
+		return err
+	}
+	s.announceRevocation(ctx, tenantID, map[string]interface{}{"user_id": userID})
+	return nil
+}
+
+func (s *SessionService) revokeAll(ctx context.Context, userID, tenantID string) error {
+	filter := bson.M{"user_id": userID, "tenant_id": tenantID, "revoked": false}
+	if _, err := s.refreshCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		return err
+	}
+	if _, err := s.tokenCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		return err
+	}
+	return s.ssoSessions.RevokeAllForUser(userID, tenantID)
+}
+
+// ApplyRevocationEvent applies a session revocation another instance announced. The tokens are
+// usually revoked already; applying it again makes the revocation win over a copy of the tokens
+// that another region wrote concurrently (see ResolveRefreshTokenConflict).
+func (s *SessionService) ApplyRevocationEvent(event *models.ClusterEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userID, _ := event.Data["user_id"].(string)
+	if userID == "" {
+		return fmt.Errorf("revocation event without user_id")
+	}
+	sessionID, _ := event.Data["session_id"].(string)
+	if sessionID == "" {
+		return s.revokeAll(ctx, userID, event.TenantID)
+	}
+	if err := s.revokeSession(ctx, userID, event.TenantID, sessionID); err != nil && err != ErrSessionNotFound {
+		return err
+	}
+	return nil
+}
+
+// announceRevocation publishes a revocation to the other instances. The revocation itself has
+// already been stored, so a failure is only logged.
+func (s *SessionService) announceRevocation(ctx context.Context, tenantID string, data map[string]interface{}) {
+	if err := publishClusterEvent(ctx, s.eventCollection, s.db.HomeRegion(), ClusterEventSessionRevoked, tenantID, data); err != nil {
+		log.Printf("Warning: Failed to announce session revocation of user %v: %v", data["user_id"], err)
+	}
+}
+
+func (s *SessionService) clientNames(ctx context.Context, clientIDs []string) map[string]string {
+	names := map[string]string{}
+	if len(clientIDs) == 0 {
+		return names
+	}
+
+	cursor, err := s.clientCollection.Find(ctx, bson.M{"client_id": bson.M{"$in": clientIDs}})
+	if err != nil {
+		return names
+	}
+	var clients []models.Client
+	if err := cursor.All(ctx, &clients); err != nil {
+		return names
+	}
+	for _, client := range clients {
+		names[client.ClientID] = client.Name
+	}
+	return names
+}
+
+// sessionID identifies a session by its token family, which survives refresh token rotation
+func sessionID(token models.RefreshToken) string {
+	if token.FamilyID != "" {
+		return token.FamilyID
+	}
+	return token.ID.Hex()
+}
+
+func sessionActivity(session *UserSession) time.Time {
+	if session.LastUsedAt != nil {
+		return *session.LastUsedAt
+	}
+	return session.CreatedAt
+}
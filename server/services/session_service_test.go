@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSessionIDUsesTokenFamily(t *testing.T) {
+	token := models.RefreshToken{ID: primitive.NewObjectID(), FamilyID: "family-1"}
+	if id := sessionID(token); id != "family-1" {
+		t.Errorf("Expected the family ID to identify the session, got %s", id)
+	}
+
+	token.FamilyID = ""
+	if id := sessionID(token); id != token.ID.Hex() {
+		t.Errorf("Expected tokens without a family to be identified by their ID, got %s", id)
+	}
+}
+
+func TestSessionActivity(t *testing.T) {
+	created := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	used := created.Add(time.Hour)
+
+	if got := sessionActivity(&UserSession{CreatedAt: created}); !got.Equal(created) {
+		t.Errorf("Expected an unused session to be dated by its creation, got %v", got)
+	}
+	if got := sessionActivity(&UserSession{CreatedAt: created, LastUsedAt: &used}); !got.Equal(used) {
+		t.Errorf("Expected a used session to be dated by its last use, got %v", got)
+	}
+}
@@ -25,22 +25,27 @@ type SetupService struct {
 	groupService          *GroupService
 	socialProviderService *SocialProviderService
 	clientService         *ClientService
+	webBaseURL            string
+	sealer                *FlowSealer // issues setup tokens every replica accepts, nil keeps the token in this process
 	setupToken            string
 	setupTokenExpiry      time.Time
 }
 
 type SetupRequest struct {
-	SetupToken      string                `json:"setup_token"`
-	TenantName      string                `json:"tenant_name"`
-	TenantDomain    string                `json:"tenant_domain"`
-	TenantSubdomain string                `json:"tenant_subdomain"`
-	AdminEmail      string                `json:"admin_email"`
-	AdminPassword   string                `json:"admin_password"`
-	AdminFirstName  string                `json:"admin_first_name"`
-	AdminLastName   string                `json:"admin_last_name"`
+	SetupToken      string                `json:"setup_token" validate:"required"`
+	TenantName      string                `json:"tenant_name" validate:"required,max=100"`
+	TenantDomain    string                `json:"tenant_domain" validate:"required,hostname"`
+	TenantSubdomain string                `json:"tenant_subdomain" validate:"required,slug,max=63"`
+	AdminEmail      string                `json:"admin_email" validate:"required,email,max=254"`
+	AdminPassword   string                `json:"admin_password" validate:"required,min=8,max=72"`
+	AdminFirstName  string                `json:"admin_first_name" validate:"max=100"`
+	AdminLastName   string                `json:"admin_last_name" validate:"max=100"`
 	Settings        models.TenantSettings `json:"settings"`
 }
 
+// setupTokenPurpose is sealed into setup tokens and checked when they are opened
+const setupTokenPurpose = "setup"
+
 func NewSetupService(
 	db *database.MongoDB,
 	tenantService *TenantService,
@@ -49,7 +54,15 @@ func NewSetupService(
 	groupService *GroupService,
 	socialProviderService *SocialProviderService,
 	clientService *ClientService,
+	webBaseURL string,
+	flowStateKey string,
 ) *SetupService {
+	// Setup tokens get a sealer of their own: any blob sealed with the flow state key itself (social
+	// login states, authorize flows) must not pass as a setup token
+	var sealer *FlowSealer
+	if flowStateKey != "" {
+		sealer = NewFlowSealer("setup-token\x00" + flowStateKey)
+	}
 	return &SetupService{
 		db:                    db,
 		tenantService:         tenantService,
@@ -58,6 +71,8 @@ func NewSetupService(
 		groupService:          groupService,
 		socialProviderService: socialProviderService,
 		clientService:         clientService,
+		webBaseURL:            webBaseURL,
+		sealer:                sealer,
 	}
 }
 
@@ -119,9 +134,21 @@ func (s *SetupService) GenerateSetupToken() (string, error) {
 	}
 
 	token := hex.EncodeToString(bytes)
-	s.setupToken = token
 	s.setupTokenExpiry = time.Now().Add(1 * time.Hour) // Token expires in 1 hour
 
+	// Behind a load balancer the wizard may reach another replica, so the token carries its expiry
+	// sealed instead of being remembered by this process
+	if s.sealer != nil {
+		payload, err := bson.Marshal(bson.M{"purpose": setupTokenPurpose, "nonce": token, "expires_at": s.setupTokenExpiry})
+		if err != nil {
+			return "", err
+		}
+		if token, err = s.sealer.Seal(payload); err != nil {
+			return "", err
+		}
+	}
+	s.setupToken = token
+
 	log.Printf("\n" + strings.Repeat("=", 80))
 	log.Printf("SETUP WIZARD TOKEN GENERATED")
 	log.Printf(strings.Repeat("=", 80))
@@ -136,6 +163,10 @@ func (s *SetupService) GenerateSetupToken() (string, error) {
 }
 
 func (s *SetupService) ValidateSetupToken(token string) bool {
+	if s.sealer != nil {
+		return s.validateSealedSetupToken(token)
+	}
+
 	if s.setupToken == "" {
 		return false
 	}
@@ -148,6 +179,30 @@ func (s *SetupService) ValidateSetupToken(token string) bool {
 	return s.setupToken == token
 }
 
+// validateSealedSetupToken accepts the unexpired setup tokens sealed by any replica. Completing
+// the setup can't clear the token on other replicas, so it is only accepted while setup is required.
+func (s *SetupService) validateSealedSetupToken(token string) bool {
+	payload, err := s.sealer.Open(token)
+	if err != nil {
+		return false
+	}
+
+	var sealed struct {
+		Purpose   string    `bson:"purpose"`
+		ExpiresAt time.Time `bson:"expires_at"`
+	}
+	if err := bson.Unmarshal(payload, &sealed); err != nil || sealed.Purpose != setupTokenPurpose {
+		return false
+	}
+	if time.Now().After(sealed.ExpiresAt) {
+		log.Printf("Setup token has expired. Please restart the server to generate a new token.")
+		return false
+	}
+
+	required, err := s.IsSetupRequired()
+	return err == nil && required
+}
+
 func (s *SetupService) PerformInitialSetup(req *SetupRequest) error {
 	if !s.ValidateSetupToken(req.SetupToken) {
 		return errors.New("invalid or expired setup token")
@@ -188,6 +243,10 @@ func (s *SetupService) PerformInitialSetup(req *SetupRequest) error {
 	} else {
 		log.Printf("Initialized default groups for tenant: %s", tenantID)
 	}
+	// The administrators of the default tenant operate the platform
+	if err := s.groupService.GrantPlatformOperator(tenantID); err != nil {
+		log.Printf("Warning: Failed to grant the platform operator role: %v", err)
+	}
 
 	// Step 4: Initialize default social providers
 	if err := s.socialProviderService.InitializeDefaultProviders(tenantID); err != nil {
@@ -202,6 +261,9 @@ func (s *SetupService) PerformInitialSetup(req *SetupRequest) error {
 	} else {
 		log.Printf("Initialized default OAuth clients for tenant: %s", tenantID)
 	}
+	if err := s.clientService.InitializeSystemClients(tenantID, s.webBaseURL); err != nil {
+		log.Printf("Warning: Failed to initialize system clients: %v", err)
+	}
 
 	// Step 6: Create default admin user
 	if err := s.createDefaultAdminUser(tenantID, req); err != nil {
@@ -231,8 +293,7 @@ func (s *SetupService) createDefaultAdminUser(tenantID string, req *SetupRequest
 		PasswordHash: req.AdminPassword, // Will be hashed by CreateUser
 		FirstName:    req.AdminFirstName,
 		LastName:     req.AdminLastName,
-		// Store group names instead of internal IDs to match API expectations
-		Groups: []string{adminGroup.Name},
+		Groups: []string{adminGroup.ID.Hex()},
 		Scopes: adminGroup.Scopes, // Inherit all admin scopes
 		Active: true,
 	}
@@ -241,6 +302,10 @@ func (s *SetupService) createDefaultAdminUser(tenantID string, req *SetupRequest
 		return fmt.Errorf("failed to create admin user: %w", err)
 	}
 
+	if err := NewMembershipService(s.db).SyncUser(adminUser); err != nil {
+		return fmt.Errorf("failed to add admin user to group: %w", err)
+	}
+
 	log.Printf("Created admin user: %s in tenant: %s", adminUser.Email, tenantID)
 	return nil
 }
@@ -297,17 +362,19 @@ func (s *SetupService) initializeDefaultClients(tenantID, domain string) error {
 		}
 	}
 
-	// Create default frontend client for social login and PKCE flows
+	// Create default frontend client for social login and PKCE flows. It runs in the browser,
+	// so it is a public client without a secret.
 	frontendClient := &models.Client{
 		TenantID:     tenantID,
 		ClientID:     "frontend-client",
-		ClientSecret: "frontend-secret",
+		ClientType:   models.ClientTypePublic,
 		Name:         "Frontend Client",
 		Description:  "Default frontend client for social login and PKCE flows",
 		RedirectURIs: redirectURIs,
 		Scopes:       []string{"read", "write", "openid", "profile", "email"},
 		GrantTypes:   []string{"authorization_code", "refresh_token"},
 		Active:       true,
+		IDTokenRoles: true, // the web application reads the user's scopes and groups from the ID token
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestSealedSetupTokenPurpose checks that blobs sealed for other flows with the same flow state
+// key don't pass as setup tokens
+func TestSealedSetupTokenPurpose(t *testing.T) {
+	t.Setenv("FORCE_SETUP", "true")
+	const key = "flow-state-key"
+	setup := NewSetupService(nil, nil, nil, nil, nil, nil, nil, "https://app.example.com", key)
+
+	token, err := setup.GenerateSetupToken()
+	if err != nil {
+		t.Fatalf("GenerateSetupToken() error = %v", err)
+	}
+	if !setup.ValidateSetupToken(token) {
+		t.Error("ValidateSetupToken() rejected the setup token")
+	}
+
+	// A social login state or authorize flow ID sealed with the flow state key carries an expiry too
+	flowState, err := bson.Marshal(bson.M{"state": "abc", "expires_at": time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flowBlob, err := NewFlowSealer(key).Seal(flowState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup.ValidateSetupToken(flowBlob) {
+		t.Error("ValidateSetupToken() accepted a sealed flow state")
+	}
+
+	// Blobs of the setup sealer still need the setup purpose
+	unbound, err := setup.sealer.Seal(flowState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup.ValidateSetupToken(unbound) {
+		t.Error("ValidateSetupToken() accepted a sealed blob without the setup purpose")
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SMSGateway sends text messages to phone numbers
+type SMSGateway interface {
+	SendSMS(to, message string) error
+}
+
+// LogSMSGateway writes text messages to the server log. Used when no SMS provider is configured.
+type LogSMSGateway struct{}
+
+// NewLogSMSGateway creates a gateway that only logs messages
+func NewLogSMSGateway() *LogSMSGateway {
+	return &LogSMSGateway{}
+}
+
+// SendSMS logs the message
+func (g *LogSMSGateway) SendSMS(to, message string) error {
+	log.Printf("SMS to %s: %s", to, message)
+	return nil
+}
+
+// twilioAPIURL is the base URL of the Twilio REST API
+const twilioAPIURL = "https://api.twilio.com"
+
+// TwilioSMSGateway sends text messages through the Twilio Messages API
+type TwilioSMSGateway struct {
+	accountSID string
+	authToken  string
+	from       string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewTwilioSMSGateway creates a gateway sending from the given Twilio number
+func NewTwilioSMSGateway(accountSID, authToken, from string) *TwilioSMSGateway {
+	return &TwilioSMSGateway{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		baseURL:    twilioAPIURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SendSMS creates a Twilio message
+func (g *TwilioSMSGateway) SendSMS(to, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", g.from)
+	form.Set("Body", message)
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", g.baseURL, url.PathEscape(g.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(g.accountSID, g.authToken)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("twilio returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
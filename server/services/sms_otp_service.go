@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// smsCodeLifetime is how long an SMS one-time code stays valid
+	smsCodeLifetime = 5 * time.Minute
+	// smsCodeResendInterval is the minimum time between two codes for the same purpose
+	smsCodeResendInterval = 30 * time.Second
+	// maxSMSCodeAttempts is the number of wrong guesses after which a code is discarded
+	maxSMSCodeAttempts = 5
+)
+
+var (
+	ErrPhoneRequired    = errors.New("no phone number on the account")
+	ErrPhoneNotVerified = errors.New("phone number is not verified")
+	ErrSMSTwoFactorOff  = errors.New("SMS two-factor authentication is not enabled for this tenant")
+	ErrSMSCodeThrottled = errors.New("a code was sent recently, please wait before requesting another")
+	ErrInvalidSMSCode   = errors.New("invalid or expired code")
+)
+
+// SMSOTPService verifies phone numbers and sends SMS one-time codes used as second factor
+type SMSOTPService struct {
+	db             *database.MongoDB
+	collection     *mongo.Collection
+	userCollection *mongo.Collection
+	tenantService  *TenantService
+	gateway        SMSGateway
+}
+
+func NewSMSOTPService(db *database.MongoDB, gateway SMSGateway) *SMSOTPService {
+	return &SMSOTPService{
+		db:             db,
+		collection:     db.GetCollection("sms_codes"),
+		userCollection: db.GetCollection("users"),
+		tenantService:  NewTenantService(db),
+		gateway:        gateway,
+	}
+}
+
+// EnsureIndexes creates the code lookup index and expires codes automatically
+func (s *SMSOTPService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// SendPhoneVerification sends a code proving ownership of the user's phone number
+func (s *SMSOTPService) SendPhoneVerification(userID, tenantID string) error {
+	user, err := s.getUser(userID, tenantID)
+	if err != nil {
+		return err
+	}
+	if user.Phone == "" {
+		return ErrPhoneRequired
+	}
+
+	return s.sendCode(user, models.SMSCodePurposeVerifyPhone, "Your verification code is %s")
+}
+
+// VerifyPhone marks the user's phone number as verified if the code matches
+func (s *SMSOTPService) VerifyPhone(userID, tenantID, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := s.getUser(userID, tenantID)
+	if err != nil {
+		return err
+	}
+
+	// A code sent to a previous number doesn't verify the current one
+	if err := verifySMSCode(ctx, s.collection, user.ID.Hex(), models.SMSCodePurposeVerifyPhone, user.Phone, code); err != nil {
+		return err
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx, bson.M{"_id": user.ID, "phone": user.Phone}, bson.M{
+		"$set": bson.M{"phone_verified": true, "updated_at": time.Now()},
+	})
+	return err
+}
+
+// EnableSMSTwoFactor lets the user sign in with SMS codes as second factor. The phone number
+// must be verified and the tenant must allow SMS codes.
+func (s *SMSOTPService) EnableSMSTwoFactor(userID string) error {
+	user, err := s.getUser(userID, "")
+	if err != nil {
+		return err
+	}
+	if !s.smsAllowed(user.TenantID) {
+		return ErrSMSTwoFactorOff
+	}
+	if user.Phone == "" {
+		return ErrPhoneRequired
+	}
+	if !user.PhoneVerified {
+		return ErrPhoneNotVerified
+	}
+
+	return s.setSMSTwoFactor(user.ID, true)
+}
+
+// DisableSMSTwoFactor stops accepting SMS codes as the user's second factor
+func (s *SMSOTPService) DisableSMSTwoFactor(userID string) error {
+	user, err := s.getUser(userID, "")
+	if err != nil {
+		return err
+	}
+
+	return s.setSMSTwoFactor(user.ID, false)
+}
+
+// SendTwoFactorCode sends a sign-in code to a user who enabled SMS two-factor authentication
+func (s *SMSOTPService) SendTwoFactorCode(userID string) error {
+	user, err := s.getUser(userID, "")
+	if err != nil {
+		return err
+	}
+	if !user.SMSTwoFactor || !s.smsAllowed(user.TenantID) {
+		return ErrSMSTwoFactorOff
+	}
+	if !user.PhoneVerified {
+		return ErrPhoneNotVerified
+	}
+
+	return s.sendCode(user, models.SMSCodePurposeTwoFactor, "Your sign-in code is %s")
+}
+
+func (s *SMSOTPService) sendCode(user *models.User, purpose, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userID := user.ID.Hex()
+	now := time.Now()
+
+	recent, err := s.collection.CountDocuments(ctx, bson.M{
+		"user_id":    userID,
+		"purpose":    purpose,
+		"created_at": bson.M{"$gt": now.Add(-smsCodeResendInterval)},
+	})
+	if err != nil {
+		return err
+	}
+	if recent > 0 {
+		return ErrSMSCodeThrottled
+	}
+
+	code, err := generateSMSCode()
+	if err != nil {
+		return err
+	}
+
+	// Only the latest code of a purpose is valid
+	if _, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose}); err != nil {
+		return err
+	}
+	if _, err := s.collection.InsertOne(ctx, &models.SMSCode{
+		ID:        primitive.NewObjectID(),
+		TenantID:  user.TenantID,
+		UserID:    userID,
+		Purpose:   purpose,
+		Phone:     user.Phone,
+		CodeHash:  hashSMSCode(code),
+		ExpiresAt: now.Add(smsCodeLifetime),
+		CreatedAt: now,
+	}); err != nil {
+		return err
+	}
+
+	return s.gateway.SendSMS(user.Phone, fmt.Sprintf(message, code))
+}
+
+func (s *SMSOTPService) setSMSTwoFactor(userID primitive.ObjectID, enabled bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.userCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{"sms_two_factor": enabled, "updated_at": time.Now()},
+	})
+	return err
+}
+
+func (s *SMSOTPService) getUser(userID, tenantID string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	filter := bson.M{"_id": objID}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	var user models.User
+	if err := s.userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+		return nil, errors.New("user not found")
+	}
+	return &user, nil
+}
+
+func (s *SMSOTPService) smsAllowed(tenantID string) bool {
+	return smsTwoFactorAllowed(s.tenantService, tenantID)
+}
+
+// smsTwoFactorAllowed reports whether the tenant accepts SMS codes as second factor
+func smsTwoFactorAllowed(tenantService *TenantService, tenantID string) bool {
+	tenant, err := tenantService.GetTenantByID(tenantID)
+	return err == nil && tenant.Settings.AllowSMSTwoFactor
+}
+
+// verifySMSCode checks a code against the latest code of the purpose sent to phone and consumes
+// it on success. Wrong guesses count against the code's attempts.
+func verifySMSCode(ctx context.Context, collection *mongo.Collection, userID, purpose, phone, code string) error {
+	var stored models.SMSCode
+	err := collection.FindOne(ctx, bson.M{
+		"user_id":    userID,
+		"purpose":    purpose,
+		"phone":      phone,
+		"expires_at": bson.M{"$gt": time.Now()},
+		"attempts":   bson.M{"$lt": maxSMSCodeAttempts},
+	}).Decode(&stored)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrInvalidSMSCode
+		}
+		return err
+	}
+
+	if !smsCodeMatches(stored.CodeHash, code) {
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": stored.ID}, bson.M{"$inc": bson.M{"attempts": 1}}); err != nil {
+			return err
+		}
+		return ErrInvalidSMSCode
+	}
+
+	_, err = collection.DeleteOne(ctx, bson.M{"_id": stored.ID})
+	return err
+}
+
+// generateSMSCode returns a random six-digit code
+func generateSMSCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func hashSMSCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func smsCodeMatches(codeHash, code string) bool {
+	return code != "" && hashSMSCode(code) == codeHash
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestGenerateSMSCode(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9]{6}$`)
+	for i := 0; i < 20; i++ {
+		code, err := generateSMSCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("Expected a six-digit code, got %q", code)
+		}
+	}
+}
+
+func TestSMSCodeMatches(t *testing.T) {
+	hash := hashSMSCode("123456")
+
+	if !smsCodeMatches(hash, "123456") {
+		t.Error("Expected the code to match its hash")
+	}
+	if smsCodeMatches(hash, "654321") || smsCodeMatches(hash, "") {
+		t.Error("Expected other codes not to match")
+	}
+}
+
+func TestTwilioSMSGateway(t *testing.T) {
+	var path, user, password, to, from, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, password, _ = r.BasicAuth()
+		r.ParseForm()
+		to, from, body = r.PostForm.Get("To"), r.PostForm.Get("From"), r.PostForm.Get("Body")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	gateway := NewTwilioSMSGateway("AC123", "secret", "+15550000000")
+	gateway.baseURL = server.URL
+
+	if err := gateway.SendSMS("+15551234567", "Your sign-in code is 123456"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/2010-04-01/Accounts/AC123/Messages.json" {
+		t.Errorf("Unexpected path: %s", path)
+	}
+	if user != "AC123" || password != "secret" {
+		t.Errorf("Expected basic auth with the account credentials, got %s:%s", user, password)
+	}
+	if to != "+15551234567" || from != "+15550000000" || body != "Your sign-in code is 123456" {
+		t.Errorf("Unexpected message: to=%s from=%s body=%s", to, from, body)
+	}
+}
+
+func TestTwilioSMSGatewayError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	gateway := NewTwilioSMSGateway("AC123", "secret", "+15550000000")
+	gateway.baseURL = server.URL
+
+	if err := gateway.SendSMS("+15551234567", "hello"); err == nil {
+		t.Error("Expected an error for a rejected message")
+	}
+}
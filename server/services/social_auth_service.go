@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -12,9 +13,16 @@ import (
 	"oauth2-openid-server/database"
 	"oauth2-openid-server/models"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var (
+	ErrSocialDomainNotAllowed     = errors.New("email domain is not allowed to sign in")
+	ErrSocialProvisioningDisabled = errors.New("automatic account creation is disabled")
+	ErrSocialIdentityInUse        = errors.New("the provider account is linked to another user")
+)
+
 // SimpleTokenResponse represents a simple OAuth token response
 type SimpleTokenResponse struct {
 	AccessToken string `json:"access_token"`
@@ -28,12 +36,17 @@ type SocialAuthService struct {
 }
 
 type SocialUserInfo struct {
-	ID        string `json:"id"`
-	Email     string `json:"email"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Name      string `json:"name"`
-	Provider  string `json:"provider"`
+	ID        string   `json:"id"`
+	Email     string   `json:"email"`
+	FirstName string   `json:"first_name"`
+	LastName  string   `json:"last_name"`
+	Name      string   `json:"name"`
+	Provider  string   `json:"provider"`
+	UPN       string   `json:"upn,omitempty"`    // Microsoft user principal name
+	Picture   string   `json:"picture,omitempty"`
+	Locale    string   `json:"locale,omitempty"`
+	Zoneinfo  string   `json:"zoneinfo,omitempty"`
+	Groups    []string `json:"groups,omitempty"` // group memberships reported by the provider
 }
 
 type GoogleUserInfo struct {
@@ -70,9 +83,10 @@ func NewSocialAuthService(userService *UserService, db *database.MongoDB) *Socia
 	}
 }
 
-// GetAuthURL generates the OAuth authorization URL for the specified provider
-func (s *SocialAuthService) GetAuthURL(provider, state, tenantID string) (string, error) {
-	socialProvider, err := s.socialProviderService.GetProviderByName(provider, tenantID)
+// GetAuthURL generates the OAuth authorization URL for the specified provider. A non-empty
+// loginHint (the user's email address) is passed on to providers that accept one.
+func (s *SocialAuthService) GetAuthURL(provider, state, tenantID, loginHint string) (string, error) {
+	socialProvider, err := s.socialProviderService.ResolveProvider(provider, tenantID)
 	if err != nil {
 		return "", fmt.Errorf("provider '%s' not found", provider)
 	}
@@ -85,11 +99,11 @@ func (s *SocialAuthService) GetAuthURL(provider, state, tenantID string) (string
 		return "", fmt.Errorf("provider '%s' is not properly configured", provider)
 	}
 
-	return s.buildAuthURL(socialProvider, state), nil
+	return s.buildAuthURL(socialProvider, state, loginHint), nil
 }
 
 // buildAuthURL constructs the OAuth authorization URL
-func (s *SocialAuthService) buildAuthURL(provider *models.SocialProvider, state string) string {
+func (s *SocialAuthService) buildAuthURL(provider *models.SocialProvider, state, loginHint string) string {
 	params := url.Values{}
 	params.Add("client_id", provider.ClientID)
 	params.Add("redirect_uri", provider.RedirectURL)
@@ -108,6 +122,21 @@ func (s *SocialAuthService) buildAuthURL(provider *models.SocialProvider, state
 		params.Add("prompt", "consent")
 	case "apple":
 		params.Add("response_mode", "form_post")
+	case "microsoft":
+		params.Add("response_mode", "query")
+		params.Add("prompt", "select_account")
+	}
+
+	// Pre-fill the provider's sign-in with the account the user already named. Facebook, Apple and
+	// LinkedIn have no such parameter.
+	if loginHint != "" {
+		switch provider.Name {
+		case "github":
+			params.Add("login", loginHint)
+		case "facebook", "apple", "linkedin":
+		default:
+			params.Add("login_hint", loginHint)
+		}
 	}
 
 	return fmt.Sprintf("%s?%s", provider.AuthURL, params.Encode())
@@ -115,7 +144,7 @@ func (s *SocialAuthService) buildAuthURL(provider *models.SocialProvider, state
 
 // HandleCallback processes the OAuth callback and returns user information
 func (s *SocialAuthService) HandleCallback(provider, code, state, tenantID string) (*models.User, error) {
-	socialProvider, err := s.socialProviderService.GetProviderByName(provider, tenantID)
+	socialProvider, err := s.socialProviderService.ResolveProvider(provider, tenantID)
 	if err != nil {
 		return nil, fmt.Errorf("provider '%s' not found", provider)
 	}
@@ -124,11 +153,11 @@ func (s *SocialAuthService) HandleCallback(provider, code, state, tenantID strin
 		return nil, fmt.Errorf("provider '%s' is not enabled", provider)
 	}
 
-	return s.handleProviderCallback(socialProvider, code, state)
+	return s.handleProviderCallback(socialProvider, code, state, tenantID)
 }
 
 // handleProviderCallback handles OAuth callback for any provider
-func (s *SocialAuthService) handleProviderCallback(provider *models.SocialProvider, code, state string) (*models.User, error) {
+func (s *SocialAuthService) handleProviderCallback(provider *models.SocialProvider, code, state, tenantID string) (*models.User, error) {
 	// Exchange code for access token
 	tokenResp, err := s.exchangeCodeForToken(provider, code)
 	if err != nil {
@@ -141,47 +170,324 @@ func (s *SocialAuthService) handleProviderCallback(provider *models.SocialProvid
 		return nil, err
 	}
 
+	if provider.Name == "microsoft" && provider.SyncGroups {
+		if userInfo.Groups, err = s.getMicrosoftGroups(microsoftGraphURL, tokenResp.AccessToken); err != nil {
+			return nil, fmt.Errorf("failed to read group memberships: %v", err)
+		}
+	}
+
 	// Create or get existing user
-	return s.createOrGetSocialUser(userInfo, provider.Name)
+	user, err := s.createOrGetSocialUser(userInfo, provider.Name, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	s.recordIdentity(user, provider.Name, userInfo)
+
+	if provider.SyncGroups || provider.GroupsClaim != "" {
+		if err := s.storeExternalGroups(user, userInfo.Groups); err != nil {
+			return nil, err
+		}
+	}
+	if len(provider.GroupMappings) > 0 {
+		if err := s.applyGroupMappings(user, provider, tenantID, userInfo.Groups); err != nil {
+			return nil, fmt.Errorf("failed to map upstream groups: %v", err)
+		}
+	}
+	return user, nil
 }
 
+// LinkIdentity links the provider account that authorized code to a signed-in user, so it signs
+// in as that user even when its email address differs. The account can be linked to one user of
+// the tenant only.
+func (s *SocialAuthService) LinkIdentity(provider, code, tenantID, userID string) (*models.SocialIdentity, error) {
+	socialProvider, err := s.socialProviderService.ResolveProvider(provider, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("provider '%s' not found", provider)
+	}
+	if !socialProvider.Enabled {
+		return nil, fmt.Errorf("provider '%s' is not enabled", provider)
+	}
 
-// exchangeCodeForToken exchanges authorization code for access token
-func (s *SocialAuthService) exchangeCodeForToken(provider *models.SocialProvider, code string) (*SimpleTokenResponse, error) {
-	data := url.Values{}
-	data.Set("client_id", provider.ClientID)
-	data.Set("client_secret", provider.ClientSecret)
-	data.Set("code", code)
-	data.Set("grant_type", "authorization_code")
-	data.Set("redirect_uri", provider.RedirectURL)
+	user, err := s.userService.GetUserByIDAndTenant(userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
 
-	var req *http.Request
-	var err error
+	tokenResp, err := s.exchangeCodeForToken(socialProvider, code)
+	if err != nil {
+		return nil, err
+	}
+	userInfo, err := s.getUserInfo(socialProvider, tokenResp.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if userInfo.ID == "" {
+		return nil, fmt.Errorf("provider '%s' did not report an account ID", provider)
+	}
 
-	if provider.Name == "github" {
-		req, err = http.NewRequest("POST", provider.TokenURL, strings.NewReader(data.Encode()))
+	if owner, err := s.userService.GetUserBySocialIdentity(tenantID, socialProvider.Name, userInfo.ID); err == nil {
+		if owner.ID != user.ID {
+			return nil, ErrSocialIdentityInUse
+		}
+		for _, identity := range owner.SocialIdentities {
+			if identity.Provider == socialProvider.Name && identity.Subject == userInfo.ID {
+				return &identity, nil
+			}
+		}
+	}
+
+	identity, err := s.addIdentity(user, socialProvider.Name, userInfo)
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// recordIdentity remembers the provider account a user signed in with, so later logins find the
+// user even if either email address changes
+func (s *SocialAuthService) recordIdentity(user *models.User, provider string, socialUser *SocialUserInfo) {
+	if socialUser.ID == "" {
+		return
+	}
+	for _, identity := range user.SocialIdentities {
+		if identity.Provider == provider && identity.Subject == socialUser.ID {
+			return
+		}
+	}
+	if _, err := s.addIdentity(user, provider, socialUser); err != nil {
+		fmt.Printf("Warning: failed to record %s identity of %s: %v\n", provider, user.Email, err)
+	}
+}
+
+// addIdentity appends a social identity to the user unless it is already there
+func (s *SocialAuthService) addIdentity(user *models.User, provider string, socialUser *SocialUserInfo) (*models.SocialIdentity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	identity := models.SocialIdentity{
+		Provider: provider,
+		Subject:  socialUser.ID,
+		Email:    socialUser.Email,
+		LinkedAt: time.Now(),
+	}
+	_, err := s.db.GetCollection("users").UpdateOne(ctx, bson.M{
+		"_id":               user.ID,
+		"social_identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": socialUser.ID}}},
+	}, bson.M{
+		"$push": bson.M{"social_identities": identity},
+		"$set":  bson.M{"updated_at": identity.LinkedAt},
+		"$inc":  bson.M{"version": 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.SocialIdentities = append(user.SocialIdentities, identity)
+	return &identity, nil
+}
+
+// applyGroupMappings makes the user's membership in mapped local groups match the upstream
+// groups of this login: mapped groups are added, groups whose upstream group is gone are removed.
+// Groups not named by any mapping are left alone.
+func (s *SocialAuthService) applyGroupMappings(user *models.User, provider *models.SocialProvider, tenantID string, externalGroups []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	groupService := NewGroupService(s.db)
+	matched := mapExternalGroups(provider.GroupMappings, externalGroups)
+
+	managedIDs := []string{}
+	matchedIDs := []string{}
+	for _, mapping := range provider.GroupMappings {
+		group, err := groupService.GetGroupByID(mapping.Group, tenantID)
+		if err != nil {
+			group, err = groupService.GetGroupByName(mapping.Group, tenantID)
+		}
+		if err != nil {
+			if !provider.CreateMissingGroups || !containsString(matched, mapping.Group) {
+				continue
+			}
+			group = &models.Group{
+				TenantID:    tenantID,
+				Name:        mapping.Group,
+				Description: "Mapped from " + provider.DisplayName,
+				Scopes:      []string{},
+				Members:     []string{},
+			}
+			if err := groupService.CreateGroup(group); err != nil {
+				return err
+			}
+		}
+		id := group.ID.Hex()
+		if !containsString(managedIDs, id) {
+			managedIDs = append(managedIDs, id)
+		}
+		if containsString(matched, mapping.Group) && !containsString(matchedIDs, id) {
+			matchedIDs = append(matchedIDs, id)
+		}
+	}
+
+	groups := []string{}
+	for _, id := range user.Groups {
+		if !containsString(managedIDs, id) {
+			groups = append(groups, id)
+		}
+	}
+	for _, id := range matchedIDs {
+		if !containsString(groups, id) {
+			groups = append(groups, id)
+		}
+	}
+	if sameStringSet(groups, user.Groups) {
+		return nil
+	}
+
+	user.Groups = groups
+	if _, err := s.db.GetCollection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"groups": groups, "updated_at": time.Now()},
+	}); err != nil {
+		return err
+	}
+
+	if user.TenantID == "" {
+		return nil
+	}
+	if err := NewMembershipService(s.db).SyncUser(user); err != nil {
+		fmt.Printf("Warning: failed to sync group membership for %s: %v\n", user.Email, err)
+	}
+	return nil
+}
+
+// mapExternalGroups returns the local group references the upstream groups map to. Upstream
+// group names are compared case-insensitively.
+func mapExternalGroups(mappings []models.GroupMapping, externalGroups []string) []string {
+	matched := []string{}
+	for _, mapping := range mappings {
+		for _, external := range externalGroups {
+			if strings.EqualFold(mapping.External, external) {
+				if !containsString(matched, mapping.Group) {
+					matched = append(matched, mapping.Group)
+				}
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// sameStringSet reports whether a and b contain the same values
+func sameStringSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, v := range a {
+		if !containsString(b, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimValues reads a groups or roles claim, which providers send as an array or a
+// space-separated string
+func claimValues(data map[string]interface{}, claim string) []string {
+	values := []string{}
+	switch v := data[claim].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if str, ok := item.(string); ok && str != "" {
+				values = append(values, str)
+			}
+		}
+	case string:
+		values = append(values, strings.Fields(v)...)
+	}
+	return values
+}
+
+// getMicrosoftGroups reads the display names of the groups the user is a direct member of from
+// Microsoft Graph. It requires the GroupMember.Read.All permission.
+func (s *SocialAuthService) getMicrosoftGroups(graphURL, accessToken string) ([]string, error) {
+	groups := []string{}
+
+	next := graphURL + "/v1.0/me/memberOf/microsoft.graph.group?$select=displayName&$top=100"
+	for page := 0; next != "" && page < maxMicrosoftGroupPages; page++ {
+		req, err := http.NewRequest(http.MethodGet, next, nil)
 		if err != nil {
 			return nil, err
 		}
-		req.Header.Set("Accept", "application/json")
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	} else {
-		resp, err := http.PostForm(provider.TokenURL, data)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		resp, err := providerHTTP.Get("microsoft", req)
 		if err != nil {
 			return nil, err
 		}
-		defer resp.Body.Close()
 
-		var tokenResp SimpleTokenResponse
-		if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		var result struct {
+			Value []struct {
+				DisplayName string `json:"displayName"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("microsoft graph answered with status %d", resp.StatusCode)
+		}
+		if err != nil {
 			return nil, err
 		}
-		return &tokenResp, nil
+
+		for _, group := range result.Value {
+			if group.DisplayName != "" {
+				groups = append(groups, group.DisplayName)
+			}
+		}
+		next = result.NextLink
 	}
 
-	// Handle GitHub-specific token exchange
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	return groups, nil
+}
+
+// maxMicrosoftGroupPages bounds the Microsoft Graph pages read per login (100 groups each)
+const maxMicrosoftGroupPages = 10
+
+// storeExternalGroups records the groups the provider reported for the user
+func (s *SocialAuthService) storeExternalGroups(user *models.User, groups []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if groups == nil {
+		groups = []string{}
+	}
+	user.ExternalGroups = groups
+	_, err := s.db.GetCollection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"external_groups": groups},
+	})
+	return err
+}
+
+
+// exchangeCodeForToken exchanges authorization code for access token
+func (s *SocialAuthService) exchangeCodeForToken(provider *models.SocialProvider, code string) (*SimpleTokenResponse, error) {
+	data := url.Values{}
+	data.Set("client_id", provider.ClientID)
+	data.Set("client_secret", provider.ClientSecret)
+	data.Set("code", code)
+	data.Set("grant_type", "authorization_code")
+	data.Set("redirect_uri", provider.RedirectURL)
+
+	req, err := http.NewRequest("POST", provider.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if provider.Name == "github" {
+		// GitHub answers with a form-encoded body unless JSON is asked for
+		req.Header.Set("Accept", "application/json")
+	}
+
+	// The code is single use, so the exchange is not idempotent
+	resp, err := providerHTTP.Do(provider.Name, req, false)
 	if err != nil {
 		return nil, err
 	}
@@ -203,8 +509,7 @@ func (s *SocialAuthService) getUserInfo(provider *models.SocialProvider, accessT
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := providerHTTP.Get(provider.Name, req)
 	if err != nil {
 		return nil, err
 	}
@@ -216,7 +521,14 @@ func (s *SocialAuthService) getUserInfo(provider *models.SocialProvider, accessT
 	}
 
 	// Parse user info based on provider
-	return s.parseUserInfo(userInfo, provider.Name, accessToken)
+	info, err := s.parseUserInfo(userInfo, provider.Name, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if provider.GroupsClaim != "" {
+		info.Groups = append(info.Groups, claimValues(userInfo, provider.GroupsClaim)...)
+	}
+	return info, nil
 }
 
 // parseUserInfo parses user information from different providers
@@ -242,6 +554,8 @@ func (s *SocialAuthService) parseUserInfo(data map[string]interface{}, providerN
 		if familyName, ok := data["family_name"].(string); ok {
 			userInfo.LastName = familyName
 		}
+		userInfo.Picture, _ = data["picture"].(string)
+		userInfo.Locale, _ = data["locale"].(string)
 
 	case "github":
 		if id, ok := data["id"].(float64); ok {
@@ -261,6 +575,7 @@ func (s *SocialAuthService) parseUserInfo(data map[string]interface{}, providerN
 			userInfo.FirstName = firstName
 			userInfo.LastName = lastName
 		}
+		userInfo.Picture, _ = data["avatar_url"].(string)
 
 	case "facebook":
 		if id, ok := data["id"].(string); ok {
@@ -278,14 +593,80 @@ func (s *SocialAuthService) parseUserInfo(data map[string]interface{}, providerN
 		if lastName, ok := data["last_name"].(string); ok {
 			userInfo.LastName = lastName
 		}
+		// The picture field is an object: {"data": {"url": ...}}
+		if picture, ok := data["picture"].(map[string]interface{}); ok {
+			if pictureData, ok := picture["data"].(map[string]interface{}); ok {
+				userInfo.Picture, _ = pictureData["url"].(string)
+			}
+		}
+		userInfo.Locale = strings.ReplaceAll(stringClaim(data, "locale"), "_", "-")
+
+	case "microsoft":
+		// Microsoft Graph /me: id is the object ID (oid) of the user in its directory
+		if id, ok := data["id"].(string); ok {
+			userInfo.ID = id
+		}
+		if upn, ok := data["userPrincipalName"].(string); ok {
+			userInfo.UPN = upn
+		}
+		if email, ok := data["mail"].(string); ok && email != "" {
+			userInfo.Email = email
+		} else {
+			userInfo.Email = userInfo.UPN
+		}
+		if name, ok := data["displayName"].(string); ok {
+			userInfo.Name = name
+		}
+		if givenName, ok := data["givenName"].(string); ok {
+			userInfo.FirstName = givenName
+		}
+		if surname, ok := data["surname"].(string); ok {
+			userInfo.LastName = surname
+		}
+		userInfo.Locale, _ = data["preferredLanguage"].(string)
+
+	case "linkedin":
+		// LinkedIn OpenID Connect userinfo
+		if sub, ok := data["sub"].(string); ok {
+			userInfo.ID = sub
+		}
+		if email, ok := data["email"].(string); ok {
+			userInfo.Email = email
+		}
+		if name, ok := data["name"].(string); ok {
+			userInfo.Name = name
+		}
+		if givenName, ok := data["given_name"].(string); ok {
+			userInfo.FirstName = givenName
+		}
+		if familyName, ok := data["family_name"].(string); ok {
+			userInfo.LastName = familyName
+		}
+		userInfo.Picture, _ = data["picture"].(string)
+		// The locale is either a tag or an object: {"country": "US", "language": "en"}
+		if locale, ok := data["locale"].(map[string]interface{}); ok {
+			userInfo.Locale = stringClaim(locale, "language")
+			if country := stringClaim(locale, "country"); userInfo.Locale != "" && country != "" {
+				userInfo.Locale += "-" + country
+			}
+		} else {
+			userInfo.Locale = stringClaim(data, "locale")
+		}
 
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", providerName)
 	}
+	userInfo.Zoneinfo = stringClaim(data, "zoneinfo")
 
 	return userInfo, nil
 }
 
+// stringClaim returns a string value of a provider's user info, or "" if it is missing
+func stringClaim(data map[string]interface{}, name string) string {
+	value, _ := data[name].(string)
+	return value
+}
+
 
 
 func (s *SocialAuthService) getGitHubUserEmail(accessToken string) string {
@@ -293,8 +674,7 @@ func (s *SocialAuthService) getGitHubUserEmail(accessToken string) string {
 	req, _ := http.NewRequest("GET", emailURL, nil)
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := providerHTTP.Get("github", req)
 	if err != nil {
 		return ""
 	}
@@ -325,31 +705,76 @@ func (s *SocialAuthService) getGitHubUserEmail(accessToken string) string {
 
 
 
-// Helper function to create or get existing social user
-func (s *SocialAuthService) createOrGetSocialUser(socialUser *SocialUserInfo, provider string) (*models.User, error) {
+// Helper function to create or get existing social user. Unknown users are provisioned according
+// to the tenant's provisioning policy.
+func (s *SocialAuthService) createOrGetSocialUser(socialUser *SocialUserInfo, provider, tenantID string) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	policy := s.provisioningPolicy(tenantID)
+	if !emailDomainAllowed(policy, socialUser.Email) {
+		return nil, ErrSocialDomainNotAllowed
+	}
+
+	// A linked provider account signs in as its user whatever its email address
+	if socialUser.ID != "" {
+		if linkedUser, err := s.userService.GetUserBySocialIdentity(tenantID, provider, socialUser.ID); err == nil {
+			s.fillProfileAttributes(linkedUser, socialUser)
+			return linkedUser, nil
+		}
+	}
+
 	// Check if user already exists by email
 	if existingUser, err := s.userService.GetUserByEmail(socialUser.Email); err == nil {
-		// User exists, update provider info if needed
+		s.fillProfileAttributes(existingUser, socialUser)
 		return existingUser, nil
 	}
 
+	// The address may belong to an account that was merged into another user
+	if mergedUser, err := s.userService.GetUserByLinkedEmail(socialUser.Email); err == nil {
+		s.fillProfileAttributes(mergedUser, socialUser)
+		return mergedUser, nil
+	}
+
+	if policy.Mode == "disabled" {
+		return nil, ErrSocialProvisioningDisabled
+	}
+
+	groupRefs, scopes := provisionEntitlements(policy, provider, socialUser.Email, socialUser.Groups)
+	groups := []string{}
+	membershipService := NewMembershipService(s.db)
+	for _, ref := range groupRefs {
+		ids, err := membershipService.ResolveGroupIDs(tenantID, []string{ref})
+		if err != nil {
+			fmt.Printf("Warning: skipping provisioning group %s: %v\n", ref, err)
+			continue
+		}
+		if !containsString(groups, ids[0]) {
+			groups = append(groups, ids[0])
+		}
+	}
+
 	// Create new user from social login
+	profile := validProfileAttributes(socialUser.Picture, socialUser.Locale, socialUser.Zoneinfo)
 	user := &models.User{
 		ID:           primitive.NewObjectID(),
+		TenantID:     tenantID,
 		Email:        socialUser.Email,
 		Username:     socialUser.Email, // Use email as username for social users
 		FirstName:    socialUser.FirstName,
 		LastName:     socialUser.LastName,
-		Groups:       []string{"social-users", socialUser.Provider + "-users"},
-		Scopes:       []string{"read", "openid", "profile", "email"},
+		Picture:      profile["picture"],
+		Locale:       profile["locale"],
+		Zoneinfo:     profile["zoneinfo"],
+		Groups:       groups,
+		Scopes:       scopes,
 		Active:       true,
+		Status:       models.UserStatusActive,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 		PasswordHash: "", // No password for social users
 	}
+	setSearchKeys(user)
 
 	collection := s.db.GetCollection("users")
 	_, err := collection.InsertOne(ctx, user)
@@ -357,9 +782,104 @@ func (s *SocialAuthService) createOrGetSocialUser(socialUser *SocialUserInfo, pr
 		return nil, err
 	}
 
+	if len(groups) > 0 {
+		if err := membershipService.SyncUser(user); err != nil {
+			fmt.Printf("Warning: failed to sync group membership for %s: %v\n", user.Email, err)
+		}
+	}
+
 	return user, nil
 }
 
+// fillProfileAttributes copies the profile attributes the provider reported to an existing user
+// who has not set them
+func (s *SocialAuthService) fillProfileAttributes(user *models.User, socialUser *SocialUserInfo) {
+	if err := s.userService.FillProfileAttributes(user, socialUser.Picture, socialUser.Locale, socialUser.Zoneinfo); err != nil {
+		fmt.Printf("Warning: failed to store profile attributes of %s: %v\n", user.Email, err)
+	}
+}
+
+// provisioningPolicy returns the tenant's provisioning policy with its social default scopes
+// resolved, falling back to the default tenant
+func (s *SocialAuthService) provisioningPolicy(tenantID string) models.ProvisioningPolicy {
+	tenantService := NewTenantService(s.db)
+	var tenant *models.Tenant
+	var err error
+	if tenantID != "" {
+		tenant, err = tenantService.GetTenantByID(tenantID)
+	} else {
+		tenant, err = tenantService.GetDefaultTenant()
+	}
+	if err != nil {
+		return models.ProvisioningPolicy{}
+	}
+	policy := tenant.Settings.Provisioning
+	policy.DefaultScopes = resolveDefaultScopes(tenant.Settings, DefaultScopesSocial)
+	return policy
+}
+
+// emailDomainAllowed reports whether the policy lets the address sign in
+func emailDomainAllowed(policy models.ProvisioningPolicy, email string) bool {
+	if len(policy.AllowedDomains) == 0 {
+		return true
+	}
+	domain := emailDomain(email)
+	for _, allowed := range policy.AllowedDomains {
+		if domain != "" && strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// provisionEntitlements returns the group references and scopes a newly provisioned user receives:
+// the policy defaults plus those of every matching rule
+func provisionEntitlements(policy models.ProvisioningPolicy, provider, email string, externalGroups []string) ([]string, []string) {
+	groups := []string{}
+	scopes := []string{}
+	add := func(dst []string, values []string) []string {
+		for _, v := range values {
+			if v != "" && !containsString(dst, v) {
+				dst = append(dst, v)
+			}
+		}
+		return dst
+	}
+
+	groups = add(groups, policy.DefaultGroups)
+	if len(policy.DefaultScopes) > 0 {
+		scopes = add(scopes, policy.DefaultScopes)
+	} else {
+		scopes = add(scopes, defaultSocialScopes)
+	}
+
+	domain := emailDomain(email)
+	for _, rule := range policy.Rules {
+		if rule.Provider != "" && rule.Provider != provider {
+			continue
+		}
+		if rule.EmailDomain != "" && !strings.EqualFold(rule.EmailDomain, domain) {
+			continue
+		}
+		if rule.ExternalGroup != "" && !containsString(externalGroups, rule.ExternalGroup) {
+			continue
+		}
+		groups = add(groups, rule.Groups)
+		scopes = add(scopes, rule.Scopes)
+	}
+
+	return groups, scopes
+}
+
+// emailDomain returns the lowercased domain part of an email address
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
 // Helper function to parse full name into first and last name
 func (s *SocialAuthService) parseName(fullName string) (string, string) {
 	if fullName == "" {
@@ -381,31 +901,73 @@ func (s *SocialAuthService) parseName(fullName string) (string, string) {
 
 // GetEnabledProviders returns a list of enabled social providers
 func (s *SocialAuthService) GetEnabledProviders(tenantID string) []string {
+	var enabledProviderNames []string
+	for _, provider := range s.GetEnabledProviderConfigs(tenantID) {
+		enabledProviderNames = append(enabledProviderNames, provider.Name)
+	}
+
+	return enabledProviderNames
+}
+
+// GetEnabledProviderConfigs returns the tenant's providers users can sign in with
+func (s *SocialAuthService) GetEnabledProviderConfigs(tenantID string) []models.SocialProvider {
 	providers, err := s.socialProviderService.GetEnabledProviders(tenantID)
 	if err != nil {
-		return []string{}
+		return []models.SocialProvider{}
 	}
 
-	var enabledProviderNames []string
+	enabled := []models.SocialProvider{}
 	for _, provider := range providers {
-		enabledProviderNames = append(enabledProviderNames, provider.Name)
+		// Providers using the global catalog also need the catalog entry to be enabled
+		if provider.UseGlobal && !s.IsProviderEnabled(provider.Name, tenantID) {
+			continue
+		}
+		enabled = append(enabled, provider)
 	}
 
-	return enabledProviderNames
+	return enabled
 }
 
 // IsProviderEnabled checks if a specific provider is enabled
 func (s *SocialAuthService) IsProviderEnabled(provider, tenantID string) bool {
-	enabled, err := s.socialProviderService.IsProviderEnabled(provider, tenantID)
+	socialProvider, err := s.socialProviderService.ResolveProvider(provider, tenantID)
 	if err != nil {
 		return false
 	}
-	return enabled
+	return socialProvider.Enabled
+}
+
+// UsesGlobalProvider reports whether the tenant signs in with the provider's shared credentials
+// from the global catalog
+func (s *SocialAuthService) UsesGlobalProvider(provider, tenantID string) bool {
+	socialProvider, err := s.socialProviderService.GetProviderByName(provider, tenantID)
+	return err == nil && socialProvider.UseGlobal
+}
+
+// sharedStatePrefix marks provider state values that carry the tenant. Providers from the global
+// catalog redirect every tenant to the same callback, so the tenant travels in the state.
+const sharedStatePrefix = "t."
+
+// SharedProviderState embeds the tenant into the state sent to a shared provider
+func SharedProviderState(tenantID, state string) string {
+	return sharedStatePrefix + tenantID + "." + state
+}
+
+// TenantFromSharedState returns the tenant embedded by SharedProviderState
+func TenantFromSharedState(state string) (string, bool) {
+	if !strings.HasPrefix(state, sharedStatePrefix) {
+		return "", false
+	}
+	tenantID, _, found := strings.Cut(strings.TrimPrefix(state, sharedStatePrefix), ".")
+	if !found || tenantID == "" {
+		return "", false
+	}
+	return tenantID, true
 }
 
 // GetProviderClientID returns the client ID for a specific provider
 func (s *SocialAuthService) GetProviderClientID(provider, tenantID string) string {
-	socialProvider, err := s.socialProviderService.GetProviderByName(provider, tenantID)
+	socialProvider, err := s.socialProviderService.ResolveProvider(provider, tenantID)
 	if err != nil {
 		return ""
 	}
@@ -414,7 +976,7 @@ func (s *SocialAuthService) GetProviderClientID(provider, tenantID string) strin
 
 // IsProviderConfigured checks if a provider has all required configuration
 func (s *SocialAuthService) IsProviderConfigured(provider, tenantID string) bool {
-	socialProvider, err := s.socialProviderService.GetProviderByName(provider, tenantID)
+	socialProvider, err := s.socialProviderService.ResolveProvider(provider, tenantID)
 	if err != nil {
 		return false
 	}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestParseMicrosoftUserInfo(t *testing.T) {
+	s := &SocialAuthService{}
+
+	info, err := s.parseUserInfo(map[string]interface{}{
+		"id":                "7c1e5b0e-oid",
+		"userPrincipalName": "jane@contoso.com",
+		"mail":              nil,
+		"displayName":       "Jane Doe",
+		"givenName":         "Jane",
+		"surname":           "Doe",
+	}, "microsoft", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.ID != "7c1e5b0e-oid" || info.UPN != "jane@contoso.com" {
+		t.Errorf("Expected oid and upn to be mapped, got %+v", info)
+	}
+	if info.Email != "jane@contoso.com" {
+		t.Errorf("Expected the upn to be used without a mail address, got %s", info.Email)
+	}
+	if info.FirstName != "Jane" || info.LastName != "Doe" {
+		t.Errorf("Expected names to be mapped, got %+v", info)
+	}
+}
+
+func TestParseLinkedInUserInfo(t *testing.T) {
+	s := &SocialAuthService{}
+
+	info, err := s.parseUserInfo(map[string]interface{}{
+		"sub":         "li-123",
+		"email":       "jane@example.com",
+		"given_name":  "Jane",
+		"family_name": "Doe",
+	}, "linkedin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.ID != "li-123" || info.Email != "jane@example.com" || info.LastName != "Doe" {
+		t.Errorf("Expected OpenID Connect claims to be mapped, got %+v", info)
+	}
+}
+
+func TestGetMicrosoftGroupsFollowsPages(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("page") == "2" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"value": []map[string]string{{"displayName": "Admins"}},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"value":           []map[string]string{{"displayName": "Engineering"}},
+			"@odata.nextLink": server.URL + "/v1.0/me/memberOf?page=2",
+		})
+	}))
+	defer server.Close()
+
+	groups, err := (&SocialAuthService{}).getMicrosoftGroups(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []string{"Engineering", "Admins"}) {
+		t.Errorf("Expected groups of all pages, got %v", groups)
+	}
+}
+
+func TestBuildAuthURLPropagatesLoginHint(t *testing.T) {
+	s := &SocialAuthService{}
+	tests := []struct {
+		provider string
+		param    string
+	}{
+		{"google", "login_hint"},
+		{"microsoft", "login_hint"},
+		{"acme-okta", "login_hint"},
+		{"github", "login"},
+		{"apple", ""},
+	}
+	for _, tt := range tests {
+		authURL := s.buildAuthURL(&models.SocialProvider{Name: tt.provider, AuthURL: "https://idp.example.com/authorize"}, "state", "jane@acme.com")
+		parsed, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatalf("buildAuthURL(%s) = %q: %v", tt.provider, authURL, err)
+		}
+		query := parsed.Query()
+		for _, param := range []string{"login_hint", "login"} {
+			want := ""
+			if param == tt.param {
+				want = "jane@acme.com"
+			}
+			if got := query.Get(param); got != want {
+				t.Errorf("buildAuthURL(%s) %s = %q, want %q", tt.provider, param, got, want)
+			}
+		}
+	}
+
+	authURL := s.buildAuthURL(&models.SocialProvider{Name: "google", AuthURL: "https://idp.example.com/authorize"}, "state", "")
+	if parsed, _ := url.Parse(authURL); parsed.Query().Has("login_hint") {
+		t.Errorf("buildAuthURL() without a hint = %q", authURL)
+	}
+}
+
+func TestEmailDomainAllowed(t *testing.T) {
+	policy := models.ProvisioningPolicy{AllowedDomains: []string{"acme.com"}}
+
+	if !emailDomainAllowed(policy, "jane@ACME.com") {
+		t.Error("Expected the allowed domain to match case-insensitively")
+	}
+	if emailDomainAllowed(policy, "jane@evil.com") || emailDomainAllowed(policy, "jane@sub.acme.com") {
+		t.Error("Expected other domains to be rejected")
+	}
+	if emailDomainAllowed(policy, "") {
+		t.Error("Expected a missing email to be rejected")
+	}
+	if !emailDomainAllowed(models.ProvisioningPolicy{}, "jane@evil.com") {
+		t.Error("Expected any domain to be allowed without restrictions")
+	}
+}
+
+func TestProvisionEntitlements(t *testing.T) {
+	groups, scopes := provisionEntitlements(models.ProvisioningPolicy{}, "google", "jane@acme.com", nil)
+	if len(groups) != 0 || !reflect.DeepEqual(scopes, defaultSocialScopes) {
+		t.Errorf("Expected the default scopes without a policy, got %v %v", groups, scopes)
+	}
+
+	policy := models.ProvisioningPolicy{
+		DefaultGroups: []string{"users"},
+		DefaultScopes: []string{"openid", "email"},
+		Rules: []models.ProvisioningRule{
+			{EmailDomain: "acme.com", Groups: []string{"staff"}, Scopes: []string{"read"}},
+			{Provider: "github", Groups: []string{"developers"}},
+			{ExternalGroup: "Admins", Groups: []string{"admins", "staff"}, Scopes: []string{"admin"}},
+		},
+	}
+
+	groups, scopes = provisionEntitlements(policy, "microsoft", "jane@acme.com", []string{"Admins"})
+	if !reflect.DeepEqual(groups, []string{"users", "staff", "admins"}) {
+		t.Errorf("Unexpected groups: %v", groups)
+	}
+	if !reflect.DeepEqual(scopes, []string{"openid", "email", "read", "admin"}) {
+		t.Errorf("Unexpected scopes: %v", scopes)
+	}
+
+	groups, scopes = provisionEntitlements(policy, "github", "jane@example.com", nil)
+	if !reflect.DeepEqual(groups, []string{"users", "developers"}) || !reflect.DeepEqual(scopes, []string{"openid", "email"}) {
+		t.Errorf("Expected only the provider rule to match, got %v %v", groups, scopes)
+	}
+}
+
+func TestMapExternalGroups(t *testing.T) {
+	mappings := []models.GroupMapping{
+		{External: "Engineering", Group: "developers"},
+		{External: "eng-leads", Group: "developers"},
+		{External: "Finance", Group: "finance"},
+		{External: "Admins", Group: "admins"},
+	}
+
+	matched := mapExternalGroups(mappings, []string{"engineering", "eng-leads", "Admins", "Sales"})
+	if !reflect.DeepEqual(matched, []string{"developers", "admins"}) {
+		t.Errorf("Unexpected mapped groups: %v", matched)
+	}
+
+	if matched := mapExternalGroups(mappings, nil); len(matched) != 0 {
+		t.Errorf("Expected no groups without upstream groups, got %v", matched)
+	}
+}
+
+func TestClaimValues(t *testing.T) {
+	data := map[string]interface{}{
+		"groups": []interface{}{"admins", "", 42, "staff"},
+		"roles":  "reader writer",
+	}
+
+	if values := claimValues(data, "groups"); !reflect.DeepEqual(values, []string{"admins", "staff"}) {
+		t.Errorf("Unexpected array claim values: %v", values)
+	}
+	if values := claimValues(data, "roles"); !reflect.DeepEqual(values, []string{"reader", "writer"}) {
+		t.Errorf("Unexpected string claim values: %v", values)
+	}
+	if values := claimValues(data, "missing"); len(values) != 0 {
+		t.Errorf("Expected no values for a missing claim, got %v", values)
+	}
+}
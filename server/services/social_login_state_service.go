@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSocialStateNotFound = errors.New("social login state not found or expired")
+
+// sealedStateMarker precedes a state record sealed into the state itself
+const sealedStateMarker = "sealed~"
+
+// SocialLoginStateService stores the state of social logins server-side so callbacks can be
+// validated when the browser drops the state cookie (private browsing, tracking prevention).
+// With a sealer the record travels sealed in the state instead, so replicas need no shared store.
+type SocialLoginStateService struct {
+	db         *database.MongoDB
+	collection *mongo.Collection
+	sealer     *FlowSealer
+}
+
+// NewSocialLoginStateService creates the service. A nil sealer stores states in the database.
+func NewSocialLoginStateService(db *database.MongoDB, sealer *FlowSealer) *SocialLoginStateService {
+	return &SocialLoginStateService{
+		db:         db,
+		collection: db.GetCollection("social_login_states"),
+		sealer:     sealer,
+	}
+}
+
+// EnsureIndexes expires state records once their lifetime is over
+func (s *SocialLoginStateService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Save records the state of a social login started in a tenant, along with the OAuth parameters
+// of the client that started it. Only a hash of the state is stored.
+func (s *SocialLoginStateService) Save(state, provider, tenantID string, params map[string]string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	stateHash := hashSocialState(state)
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"_id": stateHash}, &models.SocialLoginState{
+		StateHash: stateHash,
+		Provider:  provider,
+		TenantID:  tenantID,
+		Params:    params,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Issue records the state of a social login and returns the state to send to the provider. With
+// a store that is the given state; with a sealer it is the record sealed behind the tenant prefix
+// of shared provider states, so TenantFromSharedState keeps working.
+func (s *SocialLoginStateService) Issue(state, provider, tenantID string, params map[string]string, ttl time.Duration) (string, error) {
+	if s.sealer == nil {
+		return state, s.Save(state, provider, tenantID, params, ttl)
+	}
+
+	now := time.Now().UTC()
+	payload, err := bson.Marshal(&models.SocialLoginState{
+		StateHash: hashSocialState(state),
+		Provider:  provider,
+		TenantID:  tenantID,
+		Params:    params,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+	sealed, err := s.sealer.Seal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := ""
+	if sharedTenantID, ok := TenantFromSharedState(state); ok {
+		prefix = SharedProviderState(sharedTenantID, "")
+	}
+	return prefix + sealedStateMarker + sealed, nil
+}
+
+// Consume returns and deletes the unexpired state record of a provider callback, so each state
+// can be used once. ErrSocialStateNotFound is returned for unknown, replayed or expired states.
+func (s *SocialLoginStateService) Consume(state, provider string) (*models.SocialLoginState, error) {
+	if state == "" {
+		return nil, ErrSocialStateNotFound
+	}
+	if s.sealer != nil {
+		if i := strings.LastIndex(state, sealedStateMarker); i >= 0 {
+			return s.openSealed(state[i+len(sealedStateMarker):], provider)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var record models.SocialLoginState
+	err := s.collection.FindOneAndDelete(ctx, bson.M{
+		"_id":        hashSocialState(state),
+		"provider":   provider,
+		"expires_at": bson.M{"$gt": time.Now().UTC()},
+	}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSocialStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// openSealed returns the record of a sealed state. Sealed states can't be deleted, so unlike
+// stored ones they stay valid until they expire.
+func (s *SocialLoginStateService) openSealed(sealed, provider string) (*models.SocialLoginState, error) {
+	payload, err := s.sealer.Open(sealed)
+	if err != nil {
+		return nil, ErrSocialStateNotFound
+	}
+
+	var record models.SocialLoginState
+	if err := bson.Unmarshal(payload, &record); err != nil {
+		return nil, ErrSocialStateNotFound
+	}
+	if record.Provider != provider || !time.Now().Before(record.ExpiresAt) {
+		return nil, ErrSocialStateNotFound
+	}
+	return &record, nil
+}
+
+func hashSocialState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
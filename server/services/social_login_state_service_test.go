@@ -0,0 +1,42 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+)
+
+// TestSocialLoginStateConsume checks that a stored state validates a callback once, only for its
+// provider and only before it expires.
+func TestSocialLoginStateConsume(t *testing.T) {
+	db := dbtest.New(t)
+	states := NewSocialLoginStateService(db, nil)
+
+	params := map[string]string{"client_id": "spa", "original_state": "xyz"}
+	if err := states.Save("state-1", "google", "tenant-1", params, time.Minute); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if _, err := states.Consume("state-1", "github"); !errors.Is(err, ErrSocialStateNotFound) {
+		t.Errorf("Consume() for another provider error = %v, want ErrSocialStateNotFound", err)
+	}
+
+	stored, err := states.Consume("state-1", "google")
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if stored.TenantID != "tenant-1" || stored.Params["client_id"] != "spa" {
+		t.Errorf("Consume() = %+v, want the saved tenant and params", stored)
+	}
+	if _, err := states.Consume("state-1", "google"); !errors.Is(err, ErrSocialStateNotFound) {
+		t.Errorf("replayed Consume() error = %v, want ErrSocialStateNotFound", err)
+	}
+
+	if err := states.Save("state-2", "google", "tenant-1", nil, -time.Second); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if _, err := states.Consume("state-2", "google"); !errors.Is(err, ErrSocialStateNotFound) {
+		t.Errorf("expired Consume() error = %v, want ErrSocialStateNotFound", err)
+	}
+}
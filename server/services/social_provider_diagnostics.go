@@ -0,0 +1,241 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+// Statuses of a provider diagnostic check
+const (
+	ProviderCheckPass = "pass"
+	ProviderCheckWarn = "warn"
+	ProviderCheckFail = "fail"
+	ProviderCheckSkip = "skip"
+)
+
+// ProviderCheck is the outcome of one provider diagnostic
+type ProviderCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// ProviderDiagnostics is the result of testing a social provider configuration. Success is false
+// if any check failed; warnings do not fail the test.
+type ProviderDiagnostics struct {
+	Provider string          `json:"provider"`
+	Success  bool            `json:"success"`
+	Checks   []ProviderCheck `json:"checks"`
+}
+
+// providerDiscoveryURLs are the OpenID Connect discovery documents of providers that publish one
+var providerDiscoveryURLs = map[string]string{
+	"google":   "https://accounts.google.com/.well-known/openid-configuration",
+	"apple":    "https://appleid.apple.com/.well-known/openid-configuration",
+	"linkedin": "https://www.linkedin.com/oauth/.well-known/openid-configuration",
+}
+
+// providerDiscoveryURL returns the discovery document of a provider, if it publishes one.
+// Microsoft publishes one per directory.
+func providerDiscoveryURL(provider *models.SocialProvider) string {
+	if provider.Name == "microsoft" {
+		return strings.TrimSuffix(provider.AuthURL, "/oauth2/v2.0/authorize") + "/v2.0/.well-known/openid-configuration"
+	}
+	return providerDiscoveryURLs[provider.Name]
+}
+
+// providerTestClient performs the outbound requests of provider diagnostics
+var providerTestClient = &http.Client{Timeout: 10 * time.Second}
+
+// TestProvider validates a provider configuration against the provider itself: its endpoints
+// and discovery metadata are fetched and the redirect URL is checked. With checkCredentials set
+// the client credentials are sent to the token endpoint with a dummy authorization code; the
+// error the provider answers with tells whether it accepted the client.
+func (s *SocialAuthService) TestProvider(provider, tenantID string, checkCredentials bool) (*ProviderDiagnostics, error) {
+	socialProvider, err := s.socialProviderService.ResolveProvider(provider, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("provider '%s' not found", provider)
+	}
+	return diagnoseProvider(providerTestClient, socialProvider, providerDiscoveryURL(socialProvider), checkCredentials), nil
+}
+
+func diagnoseProvider(client *http.Client, provider *models.SocialProvider, discoveryURL string, checkCredentials bool) *ProviderDiagnostics {
+	diagnostics := &ProviderDiagnostics{Provider: provider.Name}
+	add := func(check ProviderCheck) {
+		diagnostics.Checks = append(diagnostics.Checks, check)
+	}
+
+	configured := provider.ClientID != "" && provider.ClientSecret != ""
+	if configured {
+		add(ProviderCheck{Name: "configuration", Status: ProviderCheckPass, Message: "Client ID and secret are set"})
+	} else {
+		add(ProviderCheck{Name: "configuration", Status: ProviderCheckFail, Message: "Client ID and secret are required"})
+	}
+
+	add(checkRedirectURL(provider))
+	add(checkProviderMetadata(client, provider, discoveryURL))
+
+	switch {
+	case !checkCredentials:
+		add(ProviderCheck{Name: "credentials", Status: ProviderCheckSkip, Message: "Credential check not requested"})
+	case !configured:
+		add(ProviderCheck{Name: "credentials", Status: ProviderCheckSkip, Message: "Provider is not configured"})
+	default:
+		add(checkProviderCredentials(client, provider))
+	}
+
+	diagnostics.Success = true
+	for _, check := range diagnostics.Checks {
+		if check.Status == ProviderCheckFail {
+			diagnostics.Success = false
+		}
+	}
+	return diagnostics
+}
+
+// checkRedirectURL verifies the redirect URL has the form providers accept and points at a
+// callback of this server
+func checkRedirectURL(provider *models.SocialProvider) ProviderCheck {
+	check := ProviderCheck{Name: "redirect_url"}
+
+	redirectURL, err := url.Parse(provider.RedirectURL)
+	if err != nil || !redirectURL.IsAbs() || redirectURL.Host == "" {
+		check.Status, check.Message = ProviderCheckFail, "Redirect URL must be an absolute URL"
+		return check
+	}
+	if redirectURL.Fragment != "" {
+		check.Status, check.Message = ProviderCheckFail, "Redirect URL must not contain a fragment"
+		return check
+	}
+
+	local := redirectURL.Hostname() == "localhost" || redirectURL.Hostname() == "127.0.0.1"
+	if redirectURL.Scheme != "https" && !local {
+		check.Status, check.Message = ProviderCheckFail, "Redirect URL must use https"
+		return check
+	}
+	if !strings.HasSuffix(redirectURL.Path, "/"+provider.Name+"/callback") {
+		check.Status, check.Message = ProviderCheckWarn, "Redirect URL does not point at /"+provider.Name+"/callback of this server"
+		return check
+	}
+
+	check.Status, check.Message = ProviderCheckPass, "Redirect URL "+provider.RedirectURL+" must be registered with the provider"
+	return check
+}
+
+// checkProviderMetadata compares the configured endpoints with the provider's discovery document,
+// or checks that the authorization endpoint is reachable if the provider publishes none
+func checkProviderMetadata(client *http.Client, provider *models.SocialProvider, discoveryURL string) ProviderCheck {
+	check := ProviderCheck{Name: "metadata"}
+
+	if discoveryURL == "" {
+		resp, err := client.Get(provider.AuthURL)
+		if err != nil {
+			check.Status, check.Message = ProviderCheckFail, "Authorization endpoint is unreachable: "+err.Error()
+			return check
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			check.Status, check.Message = ProviderCheckFail, fmt.Sprintf("Authorization endpoint answered with status %d", resp.StatusCode)
+			return check
+		}
+		check.Status, check.Message = ProviderCheckPass, "Authorization endpoint is reachable"
+		return check
+	}
+
+	resp, err := client.Get(discoveryURL)
+	if err != nil {
+		check.Status, check.Message = ProviderCheckFail, "Discovery document is unreachable: "+err.Error()
+		return check
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		check.Status, check.Message = ProviderCheckFail, fmt.Sprintf("Discovery document answered with status %d", resp.StatusCode)
+		return check
+	}
+
+	var metadata struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&metadata); err != nil {
+		check.Status, check.Message = ProviderCheckFail, "Discovery document is not valid JSON"
+		return check
+	}
+
+	mismatches := []string{}
+	if metadata.AuthorizationEndpoint != "" && metadata.AuthorizationEndpoint != provider.AuthURL {
+		mismatches = append(mismatches, "authorization endpoint is "+metadata.AuthorizationEndpoint)
+	}
+	if metadata.TokenEndpoint != "" && metadata.TokenEndpoint != provider.TokenURL {
+		mismatches = append(mismatches, "token endpoint is "+metadata.TokenEndpoint)
+	}
+	if len(mismatches) > 0 {
+		check.Status, check.Message = ProviderCheckWarn, "Configured endpoints differ from the discovery document: "+strings.Join(mismatches, ", ")
+		return check
+	}
+
+	check.Status, check.Message = ProviderCheckPass, "Endpoints match the provider's discovery document"
+	return check
+}
+
+// checkProviderCredentials exchanges a dummy authorization code. A provider that knows the client
+// rejects the code (invalid_grant); one that does not rejects the client.
+func checkProviderCredentials(client *http.Client, provider *models.SocialProvider) ProviderCheck {
+	check := ProviderCheck{Name: "credentials"}
+
+	data := url.Values{}
+	data.Set("client_id", provider.ClientID)
+	data.Set("client_secret", provider.ClientSecret)
+	data.Set("code", "connection-test")
+	data.Set("grant_type", "authorization_code")
+	data.Set("redirect_uri", provider.RedirectURL)
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		check.Status, check.Message = ProviderCheckFail, "Token endpoint is invalid: "+err.Error()
+		return check
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		check.Status, check.Message = ProviderCheckFail, "Token endpoint is unreachable: "+err.Error()
+		return check
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+
+	switch body.Error {
+	case "invalid_grant", "bad_verification_code":
+		check.Status, check.Message = ProviderCheckPass, "Provider accepted the client credentials"
+	case "invalid_client", "unauthorized_client", "incorrect_client_credentials":
+		check.Status, check.Message = ProviderCheckFail, "Provider rejected the client credentials ("+body.Error+")"
+	case "redirect_uri_mismatch":
+		check.Status, check.Message = ProviderCheckFail, "Provider does not accept the redirect URL"
+	default:
+		if resp.StatusCode == http.StatusUnauthorized {
+			check.Status, check.Message = ProviderCheckFail, "Provider rejected the client credentials"
+			return check
+		}
+		message := fmt.Sprintf("Could not determine whether the credentials are valid (status %d", resp.StatusCode)
+		if body.Error != "" {
+			message += ", error " + body.Error
+		}
+		check.Status, check.Message = ProviderCheckWarn, message+")"
+	}
+	return check
+}
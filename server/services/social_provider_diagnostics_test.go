@@ -0,0 +1,112 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func newTestProviderServer(t *testing.T, tokenError string) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"authorization_endpoint": server.URL + "/authorize",
+				"token_endpoint":         server.URL + "/token",
+			})
+		case "/token":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tokenError})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testProvider(server *httptest.Server) *models.SocialProvider {
+	return &models.SocialProvider{
+		Name:         "google",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://idp.example.com/auth/google/callback",
+		AuthURL:      server.URL + "/authorize",
+		TokenURL:     server.URL + "/token",
+	}
+}
+
+func findCheck(diagnostics *ProviderDiagnostics, name string) ProviderCheck {
+	for _, check := range diagnostics.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return ProviderCheck{}
+}
+
+func TestDiagnoseProviderAcceptedCredentials(t *testing.T) {
+	server := newTestProviderServer(t, "invalid_grant")
+
+	diagnostics := diagnoseProvider(server.Client(), testProvider(server), server.URL+"/.well-known/openid-configuration", true)
+
+	if !diagnostics.Success {
+		t.Fatalf("Expected the provider test to pass, got %+v", diagnostics.Checks)
+	}
+	if check := findCheck(diagnostics, "metadata"); check.Status != ProviderCheckPass {
+		t.Errorf("Expected endpoints to match the discovery document, got %+v", check)
+	}
+	if check := findCheck(diagnostics, "credentials"); check.Status != ProviderCheckPass {
+		t.Errorf("Expected the credentials to be accepted, got %+v", check)
+	}
+}
+
+func TestDiagnoseProviderRejectedCredentials(t *testing.T) {
+	server := newTestProviderServer(t, "invalid_client")
+
+	diagnostics := diagnoseProvider(server.Client(), testProvider(server), "", true)
+
+	if diagnostics.Success {
+		t.Fatal("Expected the provider test to fail")
+	}
+	if check := findCheck(diagnostics, "credentials"); check.Status != ProviderCheckFail {
+		t.Errorf("Expected the credentials to be rejected, got %+v", check)
+	}
+}
+
+func TestDiagnoseProviderSkipsCredentialsUnlessRequested(t *testing.T) {
+	server := newTestProviderServer(t, "invalid_client")
+
+	diagnostics := diagnoseProvider(server.Client(), testProvider(server), "", false)
+
+	if check := findCheck(diagnostics, "credentials"); check.Status != ProviderCheckSkip {
+		t.Errorf("Expected the credential check to be skipped, got %+v", check)
+	}
+}
+
+func TestCheckRedirectURL(t *testing.T) {
+	tests := []struct {
+		redirectURL string
+		status      string
+	}{
+		{"https://idp.example.com/auth/google/callback", ProviderCheckPass},
+		{"http://localhost:8080/auth/google/callback", ProviderCheckPass},
+		{"http://idp.example.com/auth/google/callback", ProviderCheckFail},
+		{"/auth/google/callback", ProviderCheckFail},
+		{"https://idp.example.com/other", ProviderCheckWarn},
+	}
+
+	for _, tt := range tests {
+		check := checkRedirectURL(&models.SocialProvider{Name: "google", RedirectURL: tt.redirectURL})
+		if check.Status != tt.status {
+			t.Errorf("checkRedirectURL(%q) = %s, want %s", tt.redirectURL, check.Status, tt.status)
+		}
+	}
+}
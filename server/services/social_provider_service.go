@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"errors"
+	"net/url"
 	"time"
 
 	"oauth2-openid-server/database"
@@ -13,15 +14,20 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// SocialProviderService manages social login providers. Besides each tenant's own providers
+// there is a global catalog maintained by the platform operator: a tenant provider with
+// UseGlobal set signs in with the catalog's shared credentials instead of its own.
 type SocialProviderService struct {
 	db                 *database.MongoDB
 	providerCollection *mongo.Collection
+	globalCollection   *mongo.Collection
 }
 
 func NewSocialProviderService(db *database.MongoDB) *SocialProviderService {
 	return &SocialProviderService{
 		db:                 db,
 		providerCollection: db.GetCollection("social_providers"),
+		globalCollection:   db.GetCollection("global_social_providers"),
 	}
 }
 
@@ -30,7 +36,148 @@ func (s *SocialProviderService) InitializeDefaultProviders(tenantID string) erro
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	defaultProviders := []models.SocialProvider{
+	return s.initializeDefaultProviders(ctx, tenantID)
+}
+
+func (s *SocialProviderService) initializeDefaultProviders(ctx context.Context, tenantID string) error {
+	for _, provider := range defaultSocialProviders() {
+		// Check if provider already exists for this tenant
+		filter := bson.M{"name": provider.Name}
+		if tenantID != "" {
+			filter["tenant_id"] = tenantID
+		}
+
+		count, err := s.providerCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		// Only insert if it doesn't exist for this tenant
+		if count == 0 {
+			provider.TenantID = tenantID
+			_, err = s.providerCollection.InsertOne(ctx, provider)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// InitializeGlobalCatalog adds the default providers missing from the global catalog. Catalog
+// entries start disabled and without credentials.
+func (s *SocialProviderService) InitializeGlobalCatalog() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, provider := range defaultSocialProviders() {
+		count, err := s.globalCollection.CountDocuments(ctx, bson.M{"name": provider.Name})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			if _, err := s.globalCollection.InsertOne(ctx, provider); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetGlobalProviders returns the providers of the global catalog
+func (s *SocialProviderService) GetGlobalProviders() ([]models.SocialProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.globalCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var providers []models.SocialProvider
+	if err = cursor.All(ctx, &providers); err != nil {
+		return nil, err
+	}
+
+	return providers, nil
+}
+
+// GetGlobalProvider returns a provider of the global catalog by name
+func (s *SocialProviderService) GetGlobalProvider(name string) (*models.SocialProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var provider models.SocialProvider
+	err := s.globalCollection.FindOne(ctx, bson.M{"name": name}).Decode(&provider)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("provider not found")
+		}
+		return nil, err
+	}
+
+	return &provider, nil
+}
+
+// UpdateGlobalProvider updates a provider of the global catalog
+func (s *SocialProviderService) UpdateGlobalProvider(provider *models.SocialProvider) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provider.TenantID = ""
+	provider.UseGlobal = false
+	provider.UpdatedAt = time.Now()
+
+	_, err := s.globalCollection.UpdateOne(ctx, bson.M{"_id": provider.ID}, bson.M{
+		"$set": provider,
+	})
+	return err
+}
+
+// ResolveProvider returns the provider a tenant signs in with. For a tenant provider that uses
+// the global catalog, credentials and endpoints come from the catalog entry and the provider is
+// only enabled if both the tenant and the catalog enabled it.
+func (s *SocialProviderService) ResolveProvider(name, tenantID string) (*models.SocialProvider, error) {
+	provider, err := s.GetProviderByName(name, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if provider.UseGlobal {
+		global, err := s.GetGlobalProvider(name)
+		if err != nil {
+			return nil, errors.New("provider not available in the global catalog")
+		}
+		provider = inheritGlobalProvider(provider, global)
+	}
+
+	applyProviderDirectory(provider)
+	return provider, nil
+}
+
+// inheritGlobalProvider combines a tenant provider with the catalog entry it opted into
+func inheritGlobalProvider(tenant, global *models.SocialProvider) *models.SocialProvider {
+	resolved := *tenant
+	resolved.Enabled = tenant.Enabled && global.Enabled
+	resolved.ClientID = global.ClientID
+	resolved.ClientSecret = global.ClientSecret
+	resolved.RedirectURL = global.RedirectURL
+	resolved.Scopes = global.Scopes
+	resolved.AuthURL = global.AuthURL
+	resolved.TokenURL = global.TokenURL
+	resolved.UserInfoURL = global.UserInfoURL
+	resolved.SyncGroups = global.SyncGroups
+	// A tenant may restrict a shared multi-tenant app to its own directory
+	if resolved.DirectoryTenant == "" {
+		resolved.DirectoryTenant = global.DirectoryTenant
+	}
+	return &resolved
+}
+
+func defaultSocialProviders() []models.SocialProvider {
+	return []models.SocialProvider{
 		{
 			ID:           primitive.NewObjectID(),
 			Name:         "google",
@@ -72,7 +219,7 @@ func (s *SocialProviderService) InitializeDefaultProviders(tenantID string) erro
 			Scopes:       []string{"email"},
 			AuthURL:      "https://www.facebook.com/v18.0/dialog/oauth",
 			TokenURL:     "https://graph.facebook.com/v18.0/oauth/access_token",
-			UserInfoURL:  "https://graph.facebook.com/me?fields=id,name,email,first_name,last_name",
+			UserInfoURL:  "https://graph.facebook.com/me?fields=id,name,email,first_name,last_name,picture",
 			CreatedAt:    time.Now(),
 			UpdatedAt:    time.Now(),
 		},
@@ -91,31 +238,59 @@ func (s *SocialProviderService) InitializeDefaultProviders(tenantID string) erro
 			CreatedAt:    time.Now(),
 			UpdatedAt:    time.Now(),
 		},
+		{
+			ID:              primitive.NewObjectID(),
+			Name:            "microsoft",
+			DisplayName:     "Microsoft",
+			ClientID:        "",
+			ClientSecret:    "",
+			RedirectURL:     "https://oauth2.imsc.eu/auth/microsoft/callback",
+			Enabled:         false,
+			Scopes:          []string{"openid", "profile", "email", "User.Read"},
+			AuthURL:         microsoftLoginURL + "/common/oauth2/v2.0/authorize",
+			TokenURL:        microsoftLoginURL + "/common/oauth2/v2.0/token",
+			UserInfoURL:     microsoftGraphURL + "/v1.0/me",
+			DirectoryTenant: "common",
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+		},
+		{
+			ID:           primitive.NewObjectID(),
+			Name:         "linkedin",
+			DisplayName:  "LinkedIn",
+			ClientID:     "",
+			ClientSecret: "",
+			RedirectURL:  "https://oauth2.imsc.eu/auth/linkedin/callback",
+			Enabled:      false,
+			Scopes:       []string{"openid", "profile", "email"},
+			AuthURL:      "https://www.linkedin.com/oauth/v2/authorization",
+			TokenURL:     "https://www.linkedin.com/oauth/v2/accessToken",
+			UserInfoURL:  "https://api.linkedin.com/v2/userinfo",
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		},
 	}
+}
 
-	for _, provider := range defaultProviders {
-		// Check if provider already exists for this tenant
-		filter := bson.M{"name": provider.Name}
-		if tenantID != "" {
-			filter["tenant_id"] = tenantID
-		}
-
-		count, err := s.providerCollection.CountDocuments(ctx, filter)
-		if err != nil {
-			return err
-		}
+// Microsoft identity platform and Microsoft Graph base URLs
+const (
+	microsoftLoginURL = "https://login.microsoftonline.com"
+	microsoftGraphURL = "https://graph.microsoft.com"
+)
 
-		// Only insert if it doesn't exist for this tenant
-		if count == 0 {
-			provider.TenantID = tenantID
-			_, err = s.providerCollection.InsertOne(ctx, provider)
-			if err != nil {
-				return err
-			}
-		}
+// applyProviderDirectory points a Microsoft provider's endpoints at its directory: "common"
+// (work, school and personal accounts), "organizations", "consumers", or a single Entra tenant ID
+// or domain, which restricts sign-in to that tenant's accounts
+func applyProviderDirectory(provider *models.SocialProvider) {
+	if provider.Name != "microsoft" {
+		return
 	}
-
-	return nil
+	directory := provider.DirectoryTenant
+	if directory == "" {
+		directory = "common"
+	}
+	provider.AuthURL = microsoftLoginURL + "/" + url.PathEscape(directory) + "/oauth2/v2.0/authorize"
+	provider.TokenURL = microsoftLoginURL + "/" + url.PathEscape(directory) + "/oauth2/v2.0/token"
 }
 
 // GetAllProviders returns all social providers
@@ -212,6 +387,37 @@ func (s *SocialProviderService) UpdateProvider(id, tenantID string, provider *mo
 	return err
 }
 
+// UpdateGroupMappings replaces the upstream group mapping table of a tenant's provider
+func (s *SocialProviderService) UpdateGroupMappings(name, tenantID, groupsClaim string, mappings []models.GroupMapping, createMissing bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if mappings == nil {
+		mappings = []models.GroupMapping{}
+	}
+
+	filter := bson.M{"name": name}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	result, err := s.providerCollection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"groups_claim":          groupsClaim,
+			"group_mappings":        mappings,
+			"create_missing_groups": createMissing,
+			"updated_at":            time.Now(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("provider not found")
+	}
+	return nil
+}
+
 // CreateProvider creates a new social provider
 func (s *SocialProviderService) CreateProvider(provider *models.SocialProvider) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
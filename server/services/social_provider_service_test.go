@@ -0,0 +1,59 @@
+package services
+
+import (
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestInheritGlobalProvider(t *testing.T) {
+	tenant := &models.SocialProvider{Name: "google", TenantID: "t1", Enabled: true, UseGlobal: true, ClientID: "own"}
+	global := &models.SocialProvider{Name: "google", Enabled: true, ClientID: "shared", ClientSecret: "secret", RedirectURL: "https://idp.example.com/auth/google/callback"}
+
+	resolved := inheritGlobalProvider(tenant, global)
+	if resolved.ClientID != "shared" || resolved.ClientSecret != "secret" || resolved.RedirectURL != global.RedirectURL {
+		t.Errorf("Expected the shared credentials to be used, got %+v", resolved)
+	}
+	if resolved.TenantID != "t1" || !resolved.Enabled {
+		t.Errorf("Expected the tenant's provider to stay enabled, got %+v", resolved)
+	}
+	if tenant.ClientID != "own" {
+		t.Error("Expected the tenant's own credentials to be left untouched")
+	}
+
+	global.Enabled = false
+	if inheritGlobalProvider(tenant, global).Enabled {
+		t.Error("Expected a provider disabled in the catalog to be disabled for the tenant")
+	}
+}
+
+func TestSharedProviderState(t *testing.T) {
+	state := SharedProviderState("64b7f0c2a1e4", "abc.def")
+
+	tenantID, ok := TenantFromSharedState(state)
+	if !ok || tenantID != "64b7f0c2a1e4" {
+		t.Errorf("Expected the tenant to be recovered from the state, got %q %v", tenantID, ok)
+	}
+
+	if _, ok := TenantFromSharedState("abc.def"); ok {
+		t.Error("Expected plain states not to carry a tenant")
+	}
+}
+
+func TestApplyProviderDirectory(t *testing.T) {
+	provider := &models.SocialProvider{Name: "microsoft", DirectoryTenant: "contoso.onmicrosoft.com"}
+	applyProviderDirectory(provider)
+
+	if provider.AuthURL != "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/authorize" {
+		t.Errorf("Expected sign-in to be restricted to the directory, got %s", provider.AuthURL)
+	}
+	if provider.TokenURL != "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/token" {
+		t.Errorf("Expected the directory's token endpoint, got %s", provider.TokenURL)
+	}
+
+	provider = &models.SocialProvider{Name: "microsoft"}
+	applyProviderDirectory(provider)
+	if provider.AuthURL != "https://login.microsoftonline.com/common/oauth2/v2.0/authorize" {
+		t.Errorf("Expected the common endpoint by default, got %s", provider.AuthURL)
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SSOSessionCookie carries the browser's SSO session
+const SSOSessionCookie = "sso_session"
+
+// Allowed SSO session timeouts in seconds
+const (
+	MinSSOSessionIdleTimeout = 300
+	MaxSSOSessionIdleTimeout = 86400
+	MinSSOSessionLifetime    = 900
+	MaxSSOSessionLifetime    = 30 * 86400
+)
+
+// ssoSessionTouchInterval throttles the writes extending a session's idle timeout
+const ssoSessionTouchInterval = 30 * time.Second
+
+var ErrSSOSessionNotFound = errors.New("no active SSO session")
+
+// SSOSessionService keeps track of browsers signed in at the authorization server. A session ends
+// after the idle timeout without activity (sign-ins, silent renewals and heartbeats count) and at
+// the latest when its absolute lifetime is over.
+type SSOSessionService struct {
+	collection  *mongo.Collection
+	idleTimeout time.Duration
+	lifetime    time.Duration
+}
+
+// NewSSOSessionService creates the service with timeouts in seconds. Zero selects the defaults of
+// 30 minutes idle and 12 hours absolute; other values are clamped into the allowed ranges.
+func NewSSOSessionService(db *database.MongoDB, idleTimeoutSeconds, maxLifetimeSeconds int) *SSOSessionService {
+	return &SSOSessionService{
+		collection:  db.GetCollection("sso_sessions"),
+		idleTimeout: lifetimeSeconds(idleTimeoutSeconds, 1800, MinSSOSessionIdleTimeout, MaxSSOSessionIdleTimeout),
+		lifetime:    lifetimeSeconds(maxLifetimeSeconds, 43200, MinSSOSessionLifetime, MaxSSOSessionLifetime),
+	}
+}
+
+// EnsureIndexes creates the cookie lookup index and removes ended sessions once they expire
+func (s *SSOSessionService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// IdleTimeout is how long a session lasts without activity
+func (s *SSOSessionService) IdleTimeout() time.Duration {
+	return s.idleTimeout
+}
+
+// Create starts a session for a user who just signed in and returns it with its cookie value.
+// directClientID names the client of a direct login, empty for the authorization endpoints.
+func (s *SSOSessionService) Create(tenantID, userID, acr, directClientID string) (*models.SSOSession, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	session := &models.SSOSession{
+		ID:             primitive.NewObjectID(),
+		TenantID:       tenantID,
+		UserID:         userID,
+		TokenHash:      hashSSOSessionToken(token),
+		ACR:            acr,
+		DirectClientID: directClientID,
+		CreatedAt:      now,
+		LastActiveAt:   now,
+		MaxExpiresAt:   now.Add(s.lifetime),
+	}
+	session.ExpiresAt = s.idleExpiry(session, now)
+
+	if _, err := s.collection.InsertOne(ctx, session); err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+// Find returns the active session of a cookie value in the tenant
+func (s *SSOSessionService) Find(token, tenantID string) (*models.SSOSession, error) {
+	if token == "" {
+		return nil, ErrSSOSessionNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var session models.SSOSession
+	err := s.collection.FindOne(ctx, bson.M{
+		"token_hash": hashSSOSessionToken(token),
+		"tenant_id":  tenantID,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSSOSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Touch records activity in the session, extending its idle timeout up to its absolute lifetime
+func (s *SSOSessionService) Touch(session *models.SSOSession) error {
+	now := time.Now()
+	if now.Sub(session.LastActiveAt) < ssoSessionTouchInterval {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session.LastActiveAt = now
+	session.ExpiresAt = s.idleExpiry(session, now)
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": session.ID}, bson.M{"$set": bson.M{
+		"last_active_at": session.LastActiveAt,
+		"expires_at":     session.ExpiresAt,
+	}})
+	return err
+}
+
+// ListForUser returns the user's active sessions in the tenant
+func (s *SSOSessionService) ListForUser(userID, tenantID string) ([]models.SSOSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"user_id":    userID,
+		"tenant_id":  tenantID,
+		"expires_at": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.SSOSession{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// End signs a browser out: the user's session with the ID ends, so its cookie no longer renews
+// tokens silently
+func (s *SSOSessionService) End(userID, tenantID, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrSSOSessionNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": objID, "user_id": userID, "tenant_id": tenantID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrSSOSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAllForUser ends all of the user's sessions in the tenant, e.g. when their password was
+// reset or their account deactivated
+func (s *SSOSessionService) RevokeAllForUser(userID, tenantID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userID, "tenant_id": tenantID})
+	return err
+}
+
+// idleExpiry is when the session ends without further activity after now
+func (s *SSOSessionService) idleExpiry(session *models.SSOSession, now time.Time) time.Time {
+	expiry := now.Add(s.idleTimeout)
+	if expiry.After(session.MaxExpiresAt) {
+		return session.MaxExpiresAt
+	}
+	return expiry
+}
+
+func hashSSOSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
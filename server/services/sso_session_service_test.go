@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+)
+
+func TestSSOSessionIdleExpiryStopsAtLifetime(t *testing.T) {
+	s := &SSOSessionService{idleTimeout: 30 * time.Minute, lifetime: time.Hour}
+	now := time.Now()
+	session := &models.SSOSession{MaxExpiresAt: now.Add(time.Hour)}
+
+	if got := s.idleExpiry(session, now); !got.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("idleExpiry() = %v, want the idle timeout", got)
+	}
+	if got := s.idleExpiry(session, now.Add(45*time.Minute)); !got.Equal(session.MaxExpiresAt) {
+		t.Errorf("idleExpiry() near the end = %v, want the absolute lifetime %v", got, session.MaxExpiresAt)
+	}
+}
+
+// TestSSOSessionLifecycle starts a session and finds it by its cookie value in its tenant only
+func TestSSOSessionLifecycle(t *testing.T) {
+	db := dbtest.New(t)
+	service := NewSSOSessionService(db, 0, 0)
+	if err := service.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+
+	session, token, err := service.Create("t1", "u1", ACRMultiFactor, "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if session.TokenHash == token {
+		t.Error("Create() stored the cookie value instead of its hash")
+	}
+
+	found, err := service.Find(token, "t1")
+	if err != nil || found.UserID != "u1" || found.ACR != ACRMultiFactor {
+		t.Fatalf("Find() = %+v, %v", found, err)
+	}
+	if _, err := service.Find(token, "t2"); !errors.Is(err, ErrSSOSessionNotFound) {
+		t.Errorf("Find() in another tenant error = %v, want ErrSSOSessionNotFound", err)
+	}
+	if _, err := service.Find("unknown", "t1"); !errors.Is(err, ErrSSOSessionNotFound) {
+		t.Errorf("Find(unknown) error = %v, want ErrSSOSessionNotFound", err)
+	}
+
+	found.LastActiveAt = found.LastActiveAt.Add(-time.Minute)
+	if err := service.Touch(found); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	if !found.ExpiresAt.After(session.ExpiresAt) {
+		t.Errorf("Touch() expiry = %v, want later than %v", found.ExpiresAt, session.ExpiresAt)
+	}
+}
+
+// TestSSOSessionsEndWithUserSessions lists SSO sessions among the user's sessions and ends them
+// when the user signs out of one or everywhere
+func TestSSOSessionsEndWithUserSessions(t *testing.T) {
+	db := dbtest.New(t)
+	ssoSessions := NewSSOSessionService(db, 0, 0)
+	sessions := NewSessionService(db)
+
+	first, firstToken, err := ssoSessions.Create("t1", "u1", ACRSingleFactor, "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	_, secondToken, err := ssoSessions.Create("t1", "u1", ACRSingleFactor, "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	_, otherToken, err := ssoSessions.Create("t1", "u2", ACRSingleFactor, "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	listed, err := sessions.ListUserSessions("u1", "t1")
+	if err != nil {
+		t.Fatalf("ListUserSessions() error = %v", err)
+	}
+	if len(listed) != 2 || listed[0].Type != UserSessionTypeSSO {
+		t.Fatalf("ListUserSessions() = %+v, want the two SSO sessions", listed)
+	}
+
+	if err := sessions.RevokeUserSession("u2", "t1", first.ID.Hex()); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("RevokeUserSession() of another user's session error = %v, want ErrSessionNotFound", err)
+	}
+	if err := sessions.RevokeUserSession("u1", "t1", first.ID.Hex()); err != nil {
+		t.Fatalf("RevokeUserSession() error = %v", err)
+	}
+	if _, err := ssoSessions.Find(firstToken, "t1"); !errors.Is(err, ErrSSOSessionNotFound) {
+		t.Errorf("Find() of an ended session error = %v, want ErrSSOSessionNotFound", err)
+	}
+
+	if err := sessions.RevokeAllUserSessions("u1", "t1"); err != nil {
+		t.Fatalf("RevokeAllUserSessions() error = %v", err)
+	}
+	if _, err := ssoSessions.Find(secondToken, "t1"); !errors.Is(err, ErrSSOSessionNotFound) {
+		t.Errorf("Find() after signing out everywhere error = %v, want ErrSSOSessionNotFound", err)
+	}
+	if _, err := ssoSessions.Find(otherToken, "t1"); err != nil {
+		t.Errorf("Find() of another user's session error = %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// stagingCloneBatchSize is how many documents are inserted into the staging database at once
+const stagingCloneBatchSize = 500
+
+var (
+	ErrStagingTargetIsSource = errors.New("the staging database must not be the source database")
+	ErrStagingTenantNotFound = errors.New("tenant not found")
+)
+
+// Pseudonyms of names are picked from these lists, so staging data looks like real data
+var (
+	stagingFirstNames = []string{"Alex", "Maria", "Jonas", "Elena", "Samuel", "Nina", "Daniel", "Sofia", "Lukas", "Anna",
+		"Martin", "Clara", "David", "Eva", "Peter", "Laura", "Georgi", "Julia", "Thomas", "Lea"}
+	stagingLastNames = []string{"Smith", "Müller", "Ivanova", "Martin", "Schmidt", "Dubois", "Petrov", "Weber", "Bernard", "Fischer",
+		"Georgieva", "Wagner", "Moreau", "Becker", "Dimitrov", "Hoffmann", "Laurent", "Schulz", "Todorova", "Richter"}
+)
+
+// StagingCloneOptions tune a staging refresh
+type StagingCloneOptions struct {
+	// Password every cloned user can sign in with. Empty: users have no password and must use
+	// the password setup flow.
+	Password string
+	// Key of the pseudonyms. Runs with the same key give every value the same pseudonym; empty
+	// picks a random key, so pseudonyms can't be linked across refreshes.
+	Key []byte
+}
+
+// StagingCloneReport counts the documents copied per collection
+type StagingCloneReport struct {
+	TenantID    string           `json:"tenant_id"`
+	Collections map[string]int64 `json:"collections"`
+}
+
+// stagingCollection is a collection copied by a staging refresh and how its documents are scrubbed
+type stagingCollection struct {
+	name   string
+	filter func(tenantID string) bson.M
+	scrub  func(p *pseudonymizer, doc bson.M)
+}
+
+func byTenantID(tenantID string) bson.M { return bson.M{"tenant_id": tenantID} }
+
+// stagingCollections are the collections copied to staging. Tokens, codes, sessions, pending
+// flows and verification requests, signing keys, API keys and bypass keys are never copied: they
+// are credentials or short-lived, and staging issues its own.
+var stagingCollections = []stagingCollection{
+	{name: "tenants", filter: func(tenantID string) bson.M {
+		id, _ := primitive.ObjectIDFromHex(tenantID)
+		return bson.M{"_id": id}
+	}, scrub: func(p *pseudonymizer, doc bson.M) {
+		// Staging keeps every tenant in its shared collections
+		delete(doc, "storage")
+	}},
+	{name: "users", filter: byTenantID, scrub: (*pseudonymizer).scrubUser},
+	{name: "groups", filter: byTenantID},
+	{name: "clients", filter: byTenantID, scrub: func(p *pseudonymizer, doc bson.M) {
+		doc["client_secret"] = ""
+		delete(doc, "previous_client_secret")
+		delete(doc, "id_token_signing_key")
+		p.replaceList(doc, "contacts", p.email)
+	}},
+	{name: "scopes", filter: byTenantID},
+	{name: "social_providers", filter: byTenantID, scrub: func(p *pseudonymizer, doc bson.M) {
+		doc["client_secret"] = ""
+	}},
+	{name: "tenant_translations", filter: byTenantID},
+	{name: "bot_protection_policies", filter: func(tenantID string) bson.M {
+		return bson.M{"_id": tenantID}
+	}, scrub: func(p *pseudonymizer, doc bson.M) {
+		doc["secret_key"] = ""
+	}},
+	{name: "audit_events", filter: byTenantID, scrub: func(p *pseudonymizer, doc bson.M) {
+		p.replace(doc, "email", p.email)
+		p.replace(doc, "ip_address", p.ip)
+	}},
+	{name: "consents", filter: byTenantID},
+	{name: "consent_receipts", filter: byTenantID},
+}
+
+// StagingCloneService copies tenants from production into a staging database for load tests.
+// Emails, names, usernames, phone numbers and IP addresses are replaced with consistent
+// pseudonyms (the same address gets the same pseudonym everywhere in a run, and email domains
+// are kept), and secrets are removed, so the data keeps its shape without exposing anyone.
+type StagingCloneService struct {
+	source     *database.MongoDB
+	target     *database.MongoDB
+	pseudonyms *pseudonymizer
+}
+
+func NewStagingCloneService(source, target *database.MongoDB, opts StagingCloneOptions) (*StagingCloneService, error) {
+	if source.Client == target.Client && source.Database.Name() == target.Database.Name() {
+		return nil, ErrStagingTargetIsSource
+	}
+
+	key := opts.Key
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	passwordHash := ""
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		passwordHash = string(hash)
+	}
+
+	return &StagingCloneService{
+		source:     source,
+		target:     target,
+		pseudonyms: &pseudonymizer{key: key, passwordHash: passwordHash},
+	}, nil
+}
+
+// CloneTenant replaces the tenant's data in the staging database with a scrubbed copy of its
+// production data
+func (s *StagingCloneService) CloneTenant(tenantID string) (*StagingCloneReport, error) {
+	objectID, err := primitive.ObjectIDFromHex(tenantID)
+	if err != nil {
+		return nil, errors.New("invalid tenant ID")
+	}
+
+	// Nothing is removed from staging for a tenant that doesn't exist
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	found, err := s.source.GetCollection("tenants").CountDocuments(ctx, bson.M{"_id": objectID})
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, ErrStagingTenantNotFound
+	}
+
+	report := &StagingCloneReport{TenantID: tenantID, Collections: map[string]int64{}}
+	for _, c := range stagingCollections {
+		count, err := s.cloneCollection(c, tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone %s: %w", c.name, err)
+		}
+		report.Collections[c.name] = count
+	}
+	return report, nil
+}
+
+func (s *StagingCloneService) cloneCollection(c stagingCollection, tenantID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	filter := c.filter(tenantID)
+	target := s.target.TenantCollection(tenantID, c.name)
+	if _, err := target.DeleteMany(ctx, filter); err != nil {
+		return 0, err
+	}
+
+	cursor, err := s.source.TenantCollection(tenantID, c.name).Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	copied := int64(0)
+	batch := make([]interface{}, 0, stagingCloneBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := target.InsertMany(ctx, batch); err != nil {
+			return err
+		}
+		copied += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return copied, err
+		}
+		if c.scrub != nil {
+			c.scrub(s.pseudonyms, doc)
+		}
+		batch = append(batch, doc)
+		if len(batch) == stagingCloneBatchSize {
+			if err := flush(); err != nil {
+				return copied, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return copied, err
+	}
+	return copied, flush()
+}
+
+// pseudonymizer derives stable pseudonyms from keyed hashes of the original values
+type pseudonymizer struct {
+	key          []byte
+	passwordHash string
+}
+
+// digest is the keyed hash of a value of a kind, ignoring case
+func (p *pseudonymizer) digest(kind, value string) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(kind + ":" + strings.ToLower(strings.TrimSpace(value))))
+	return mac.Sum(nil)
+}
+
+func (p *pseudonymizer) email(value string) string {
+	domain := "example.com"
+	if at := strings.LastIndex(value, "@"); at >= 0 {
+		domain = strings.ToLower(value[at+1:])
+	}
+	return "user." + hex.EncodeToString(p.digest("email", value))[:12] + "@" + domain
+}
+
+func (p *pseudonymizer) username(value string) string {
+	return "user_" + hex.EncodeToString(p.digest("username", value))[:10]
+}
+
+func (p *pseudonymizer) firstName(value string) string {
+	return stagingFirstNames[binary.BigEndian.Uint32(p.digest("first_name", value))%uint32(len(stagingFirstNames))]
+}
+
+func (p *pseudonymizer) lastName(value string) string {
+	return stagingLastNames[binary.BigEndian.Uint32(p.digest("last_name", value))%uint32(len(stagingLastNames))]
+}
+
+// phone returns a +1 555 number, a prefix reserved for fiction
+func (p *pseudonymizer) phone(value string) string {
+	return fmt.Sprintf("+1555%07d", binary.BigEndian.Uint32(p.digest("phone", value))%10000000)
+}
+
+// ip returns an address of the private 10.0.0.0/8 range
+func (p *pseudonymizer) ip(value string) string {
+	digest := p.digest("ip", value)
+	return fmt.Sprintf("10.%d.%d.%d", digest[0], digest[1], digest[2])
+}
+
+// replace pseudonymizes a non-empty string field
+func (p *pseudonymizer) replace(doc bson.M, field string, pseudonym func(string) string) {
+	if value, ok := doc[field].(string); ok && value != "" {
+		doc[field] = pseudonym(value)
+	}
+}
+
+// replaceList pseudonymizes the strings of an array field
+func (p *pseudonymizer) replaceList(doc bson.M, field string, pseudonym func(string) string) {
+	values, ok := doc[field].(bson.A)
+	if !ok {
+		return
+	}
+	for i, value := range values {
+		if s, ok := value.(string); ok && s != "" {
+			values[i] = pseudonym(s)
+		}
+	}
+}
+
+// scrubUser replaces a user's personal data and removes their credentials. Second factors are
+// turned off, since their secrets and phone numbers are gone.
+func (p *pseudonymizer) scrubUser(doc bson.M) {
+	p.replace(doc, "email", p.email)
+	p.replace(doc, "pending_email", p.email)
+	p.replaceList(doc, "linked_emails", p.email)
+	p.replace(doc, "username", p.username)
+	p.replace(doc, "phone", p.phone)
+	p.replace(doc, "first_name", p.firstName)
+	p.replace(doc, "last_name", p.lastName)
+	field := func(name string) string {
+		value, _ := doc[name].(string)
+		return value
+	}
+	doc["search_values"], doc["search_words"] = userSearchKeys(field("email"), field("username"), field("first_name"), field("last_name"))
+	delete(doc, "picture")
+	delete(doc, "social_identities")
+
+	doc["password_hash"] = p.passwordHash
+	doc["two_factor_secret"] = ""
+	doc["backup_codes"] = bson.A{}
+	doc["two_factor_enabled"] = false
+	doc["sms_two_factor"] = false
+}
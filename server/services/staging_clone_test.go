@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestStagingCloneTenant clones a tenant and checks that personal data is pseudonymized
+// consistently and secrets are gone
+func TestStagingCloneTenant(t *testing.T) {
+	source := dbtest.New(t)
+	target := dbtest.New(t)
+
+	tenantID := primitive.NewObjectID()
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, source, "tenants", &models.Tenant{ID: tenantID, Name: "Acme", Domain: "acme.com", Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, source, "users", &models.User{
+		ID: userID, TenantID: tenantID.Hex(), Email: "Jane.Doe@acme.com", Username: "jdoe", Phone: "+4915112345678",
+		FirstName: "Jane", LastName: "Doe", PasswordHash: "hash", TwoFactorEnabled: true, TwoFactorSecret: "TOTPSECRET",
+		BackupCodes: []string{"code"}, Status: models.UserStatusActive, Active: true, CreatedAt: now, UpdatedAt: now,
+	})
+	dbtest.Insert(t, source, "clients", &models.Client{ID: primitive.NewObjectID(), TenantID: tenantID.Hex(), ClientID: "portal", ClientSecret: "s3cr3t", Contacts: []string{"jane.doe@acme.com"}, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, source, "audit_events", &models.AuditEvent{TenantID: tenantID.Hex(), Type: models.AuditEventLoginSuccess, UserID: userID.Hex(), Email: "jane.doe@acme.com", IPAddress: "203.0.113.7", CreatedAt: now})
+	dbtest.Insert(t, source, "access_tokens", bson.M{"tenant_id": tenantID.Hex(), "token": "abc"})
+
+	if _, err := NewStagingCloneService(source, source, StagingCloneOptions{}); !errors.Is(err, ErrStagingTargetIsSource) {
+		t.Errorf("NewStagingCloneService(source, source) error = %v, want ErrStagingTargetIsSource", err)
+	}
+	service, err := NewStagingCloneService(source, target, StagingCloneOptions{Password: "staging-password"})
+	if err != nil {
+		t.Fatalf("NewStagingCloneService() error = %v", err)
+	}
+	if _, err := service.CloneTenant(primitive.NewObjectID().Hex()); !errors.Is(err, ErrStagingTenantNotFound) {
+		t.Errorf("CloneTenant(unknown) error = %v, want ErrStagingTenantNotFound", err)
+	}
+
+	// A second run replaces the first one's copy
+	for run := 0; run < 2; run++ {
+		report, err := service.CloneTenant(tenantID.Hex())
+		if err != nil {
+			t.Fatalf("CloneTenant() error = %v", err)
+		}
+		if report.Collections["users"] != 1 || report.Collections["audit_events"] != 1 {
+			t.Errorf("CloneTenant() report = %v", report.Collections)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := target.GetCollection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		t.Fatalf("cloned user not found: %v", err)
+	}
+	if strings.Contains(user.Email, "jane") || !strings.HasSuffix(user.Email, "@acme.com") {
+		t.Errorf("email = %q, want a pseudonym at acme.com", user.Email)
+	}
+	if user.Username == "jdoe" || user.Phone == "+4915112345678" || user.FirstName == "Jane" || user.LastName == "Doe" {
+		t.Errorf("personal data kept: %+v", user)
+	}
+	if user.TwoFactorSecret != "" || len(user.BackupCodes) != 0 || user.TwoFactorEnabled {
+		t.Errorf("second factor kept: %+v", user)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("staging-password")) != nil {
+		t.Error("cloned user can't sign in with the staging password")
+	}
+
+	var client models.Client
+	if err := target.GetCollection("clients").FindOne(ctx, bson.M{"client_id": "portal"}).Decode(&client); err != nil {
+		t.Fatalf("cloned client not found: %v", err)
+	}
+	if client.ClientSecret != "" || len(client.Contacts) != 1 || client.Contacts[0] != user.Email {
+		t.Errorf("client = %+v, want no secret and the user's pseudonym as contact", client)
+	}
+
+	var event models.AuditEvent
+	if err := target.GetCollection("audit_events").FindOne(ctx, bson.M{"user_id": userID.Hex()}).Decode(&event); err != nil {
+		t.Fatalf("cloned audit event not found: %v", err)
+	}
+	if event.Email != user.Email || !strings.HasPrefix(event.IPAddress, "10.") {
+		t.Errorf("audit event = %+v, want the user's pseudonym and a private IP", event)
+	}
+
+	if count, _ := target.GetCollection("access_tokens").CountDocuments(ctx, bson.M{}); count != 0 {
+		t.Errorf("%d access tokens copied, want none", count)
+	}
+}
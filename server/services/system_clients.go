@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrSystemClient       = errors.New("system clients can't be deleted")
+	ErrPlaygroundDisabled = errors.New("the playground is not enabled for this tenant")
+)
+
+// IsSystemClient reports whether a client ID is one of the built-in system clients
+func IsSystemClient(clientID string) bool {
+	return clientID == models.SystemClientDirectLogin || clientID == models.SystemClientDirectSocialLogin ||
+		clientID == models.SystemClientPlayground
+}
+
+// systemClients returns the built-in clients of a tenant. Direct logins get tokens straight from
+// the login endpoint; direct social logins receive a code on the web application's callback page.
+func systemClients(tenantID, webBaseURL string) []*models.Client {
+	scopes := defaultLoginScopes
+	policy := models.RefreshTokenPolicy{RotateOnUse: true, IdleTimeoutDays: 14, AbsoluteLifetimeDays: 30}
+
+	return []*models.Client{
+		{
+			TenantID:           tenantID,
+			ClientID:           models.SystemClientDirectLogin,
+			Name:               "Direct Login",
+			Description:        "Built-in client of the login page",
+			RedirectURIs:       []string{},
+			Scopes:             scopes,
+			GrantTypes:         []string{"refresh_token"},
+			RefreshTokenPolicy: policy,
+		},
+		{
+			TenantID:           tenantID,
+			ClientID:           models.SystemClientDirectSocialLogin,
+			Name:               "Direct Social Login",
+			Description:        "Built-in client of social logins started from the login page",
+			RedirectURIs:       []string{strings.TrimSuffix(webBaseURL, "/") + "/callback"},
+			Scopes:             scopes,
+			GrantTypes:         []string{"authorization_code", "refresh_token"},
+			RefreshTokenPolicy: policy,
+		},
+	}
+}
+
+// InitializeSystemClients creates the built-in clients of a tenant that don't exist yet. Their
+// grant types and redirect URIs are reset on every run, and they always get role claims in ID
+// tokens since the web application reads them; scopes and the refresh token policy are
+// only set on creation so administrators can tune them. They are public clients: the browser
+// refreshes their tokens without a secret.
+func (s *ClientService) InitializeSystemClients(tenantID, webBaseURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.initializeSystemClients(ctx, tenantID, webBaseURL)
+}
+
+func (s *ClientService) initializeSystemClients(ctx context.Context, tenantID, webBaseURL string) error {
+	now := time.Now()
+	for _, client := range systemClients(tenantID, webBaseURL) {
+		_, err := s.collection.UpdateOne(ctx,
+			bson.M{"tenant_id": tenantID, "client_id": client.ClientID},
+			bson.M{
+				"$set": bson.M{
+					"system":         true,
+					"client_type":    models.ClientTypePublic,
+					"grant_types":    client.GrantTypes,
+					"redirect_uris":  client.RedirectURIs,
+					"id_token_roles": true,
+				},
+				"$setOnInsert": bson.M{
+					"_id":                  primitive.NewObjectID(),
+					"client_secret":        "",
+					"name":                 client.Name,
+					"description":          client.Description,
+					"scopes":               client.Scopes,
+					"contacts":             []string{},
+					"active":               true,
+					"refresh_token_policy": client.RefreshTokenPolicy,
+					"version":              int64(1),
+					"created_at":           now,
+					"updated_at":           now,
+				},
+			},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InitializePlaygroundClient creates the public client of the tenant's playground page and points
+// it at redirectURI, the page's own URL. Like the issuer, the URL follows the host the server is
+// reached under. The client can only start authorizations while the tenant has the playground
+// enabled (see ValidateRedirectURI).
+func (s *ClientService) InitializePlaygroundClient(tenantID, redirectURI string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"tenant_id": tenantID, "client_id": models.SystemClientPlayground},
+		bson.M{
+			"$set": bson.M{
+				"system":            true,
+				"client_type":       models.ClientTypePublic,
+				"grant_types":       []string{"authorization_code"},
+				"require_s256_pkce": true,
+				"redirect_uris":     []string{redirectURI},
+				"active":            true,
+			},
+			"$setOnInsert": bson.M{
+				"_id":           primitive.NewObjectID(),
+				"client_secret": "",
+				"name":          "Playground",
+				"description":   "Built-in client of the tenant's playground page",
+				"scopes":        defaultLoginScopes,
+				"contacts":      []string{},
+				"version":       int64(1),
+				"created_at":    now,
+				"updated_at":    now,
+			},
+		},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// playgroundEnabled reports whether the tenant turned the playground on
+func (s *ClientService) playgroundEnabled(tenantID string) bool {
+	tenant, err := NewTenantService(s.db).GetTenantByID(tenantID)
+	return err == nil && tenant.Settings.PlaygroundEnabled
+}
+
+// getTenantClient returns a tenant's active client. System clients share their client ID across
+// tenants, so they must be looked up with the tenant of the grant.
+func getTenantClient(ctx context.Context, clients *mongo.Collection, clientID, tenantID string) (*models.Client, error) {
+	var client models.Client
+	err := clients.FindOne(ctx, bson.M{"client_id": clientID, "tenant_id": tenantID, "active": true}).Decode(&client)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.New("invalid client")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
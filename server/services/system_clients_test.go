@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSystemClients(t *testing.T) {
+	clients := systemClients("tenant-1", "https://app.example.com/")
+	if len(clients) != 2 {
+		t.Fatalf("systemClients() returned %d clients, want 2", len(clients))
+	}
+	for _, client := range clients {
+		if !IsSystemClient(client.ClientID) {
+			t.Errorf("IsSystemClient(%q) = false", client.ClientID)
+		}
+		if client.TenantID != "tenant-1" || !containsString(client.GrantTypes, "refresh_token") {
+			t.Errorf("system client %q = %+v, want tenant-1 with the refresh_token grant", client.ClientID, client)
+		}
+	}
+	if got := clients[1].RedirectURIs; len(got) != 1 || got[0] != "https://app.example.com/callback" {
+		t.Errorf("direct social login redirect URIs = %v, want the web callback page", got)
+	}
+	if !IsSystemClient(models.SystemClientPlayground) {
+		t.Error("IsSystemClient(playground-client) = false")
+	}
+	if IsSystemClient("frontend-client") {
+		t.Error("IsSystemClient(frontend-client) = true")
+	}
+}
+
+// TestDirectLoginRefresh checks that tokens issued to the direct login system client can be
+// refreshed in each tenant the client was seeded in.
+func TestDirectLoginRefresh(t *testing.T) {
+	db := dbtest.New(t)
+	clientService := NewClientService(db)
+	for _, tenantID := range []string{"tenant-1", "tenant-2"} {
+		if err := clientService.InitializeSystemClients(tenantID, "https://app.example.com"); err != nil {
+			t.Fatalf("InitializeSystemClients(%s) error = %v", tenantID, err)
+		}
+	}
+	// Seeding again must not duplicate the clients
+	if err := clientService.InitializeSystemClients("tenant-1", "https://app.example.com"); err != nil {
+		t.Fatalf("InitializeSystemClients() again error = %v", err)
+	}
+	if clients, _ := clientService.GetAllClients("tenant-1"); len(clients) != 2 {
+		t.Errorf("tenant-1 has %d clients, want 2", len(clients))
+	}
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, TenantID: "tenant-2", Email: "jane@example.com", Active: true, CreatedAt: now, UpdatedAt: now})
+
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+	req := httptest.NewRequest("POST", "/login", nil)
+	tokens, err := oauthService.GenerateDirectLoginTokens(userID.Hex(), "tenant-2", []string{"openid", "read"}, ACRSingleFactor, req)
+	if err != nil {
+		t.Fatalf("GenerateDirectLoginTokens() error = %v", err)
+	}
+
+	refreshed, err := oauthService.RefreshTokens(tokens.RefreshToken, models.SystemClientDirectLogin, "", "", "tenant-2", req)
+	if err != nil {
+		t.Fatalf("RefreshTokens() error = %v", err)
+	}
+	if refreshed.RefreshToken == tokens.RefreshToken {
+		t.Error("RefreshTokens() kept the refresh token, want it rotated by the system client policy")
+	}
+
+	if err := clientService.DeleteClient(systemClientObjectID(t, clientService, "tenant-2"), "tenant-2"); err != ErrSystemClient {
+		t.Errorf("DeleteClient() of a system client error = %v, want ErrSystemClient", err)
+	}
+}
+
+func systemClientObjectID(t *testing.T, clientService *ClientService, tenantID string) string {
+	t.Helper()
+	client, err := clientService.GetClientByClientID(models.SystemClientDirectLogin, tenantID)
+	if err != nil {
+		t.Fatalf("GetClientByClientID() error = %v", err)
+	}
+	return client.ID.Hex()
+}
+
+// TestPlaygroundClient checks that the playground client only authorizes while the tenant has the
+// playground enabled
+func TestPlaygroundClient(t *testing.T) {
+	db := dbtest.New(t)
+	clientService := NewClientService(db)
+
+	tenantID := primitive.NewObjectID()
+	dbtest.Insert(t, db, "tenants", &models.Tenant{ID: tenantID, Name: "Acme", Active: true, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	redirectURI := "https://auth.example.com/tenant/" + tenantID.Hex() + "/playground"
+
+	if err := clientService.InitializePlaygroundClient(tenantID.Hex(), redirectURI); err != nil {
+		t.Fatalf("InitializePlaygroundClient() error = %v", err)
+	}
+	client, err := clientService.GetClientByClientID(models.SystemClientPlayground, tenantID.Hex())
+	if err != nil {
+		t.Fatalf("GetClientByClientID() error = %v", err)
+	}
+	if !client.System || !client.IsPublic() || !client.RequireS256PKCE {
+		t.Errorf("playground client = %+v, want a public system client requiring S256 PKCE", client)
+	}
+	if err := clientService.ValidateRedirectURI(models.SystemClientPlayground, redirectURI, tenantID.Hex()); err != ErrPlaygroundDisabled {
+		t.Errorf("ValidateRedirectURI() with the playground disabled error = %v, want ErrPlaygroundDisabled", err)
+	}
+
+	if _, err := db.GetCollection("tenants").UpdateOne(context.Background(), bson.M{"_id": tenantID}, bson.M{"$set": bson.M{"settings.playground_enabled": true}}); err != nil {
+		t.Fatalf("Failed to enable the playground: %v", err)
+	}
+	if err := clientService.ValidateRedirectURI(models.SystemClientPlayground, redirectURI, tenantID.Hex()); err != nil {
+		t.Errorf("ValidateRedirectURI() with the playground enabled error = %v", err)
+	}
+}
+
+// TestMigrateFrontendClientRoles checks that frontend clients seeded before role claims were
+// opt-in get them, while explicit choices and other clients are left alone
+func TestMigrateFrontendClientRoles(t *testing.T) {
+	db := dbtest.New(t)
+	dbtest.Insert(t, db, "clients",
+		bson.M{"tenant_id": "t1", "client_id": "frontend-client", "name": "Frontend Client"},
+		bson.M{"tenant_id": "t2", "client_id": "frontend-client", "name": "Frontend Client", "id_token_roles": false},
+		bson.M{"tenant_id": "t1", "client_id": "portal", "name": "Portal"},
+	)
+
+	clientService := NewClientService(db)
+	if err := clientService.MigrateFrontendClientRoles(); err != nil {
+		t.Fatalf("MigrateFrontendClientRoles() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		tenantID, clientID string
+		want               bool
+	}{
+		{"t1", "frontend-client", true},
+		{"t2", "frontend-client", false},
+		{"t1", "portal", false},
+	} {
+		client, err := clientService.GetClientByClientID(tt.clientID, tt.tenantID)
+		if err != nil {
+			t.Fatalf("GetClientByClientID(%s, %s) error = %v", tt.clientID, tt.tenantID, err)
+		}
+		if client.IDTokenRoles != tt.want {
+			t.Errorf("%s of %s: id_token_roles = %v, want %v", tt.clientID, tt.tenantID, client.IDTokenRoles, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TenantBootstrapRequest describes a tenant to create together with its first admin and client
+type TenantBootstrapRequest struct {
+	Name      string                `json:"name" validate:"required,max=100"`
+	Domain    string                `json:"domain" validate:"required,hostname"`
+	Subdomain string                `json:"subdomain" validate:"required,slug,max=63"`
+	Region    string                `json:"region" validate:"slug,max=32"` // data residency region
+	Settings  models.TenantSettings `json:"settings"`
+	Admin     TenantBootstrapAdmin  `json:"admin"`
+	Client    TenantBootstrapClient `json:"client"`
+}
+
+type TenantBootstrapAdmin struct {
+	Email     string `json:"email" validate:"required,email,max=254"`
+	Password  string `json:"password" validate:"min=8,max=72"` // generated when empty
+	FirstName string `json:"first_name" validate:"max=100"`
+	LastName  string `json:"last_name" validate:"max=100"`
+}
+
+type TenantBootstrapClient struct {
+	Name         string   `json:"name" validate:"max=100"` // defaults to the tenant name
+	Description  string   `json:"description" validate:"max=500"`
+	RedirectURIs []string `json:"redirect_uris" validate:"required,dive,url"`
+	Scopes       []string `json:"scopes" validate:"dive,max=100"`
+	GrantTypes   []string `json:"grant_types" validate:"dive,oneof=authorization_code refresh_token client_credentials urn:openid:params:grant-type:ciba"`
+	ClientType   string   `json:"client_type" validate:"oneof=confidential public"`
+}
+
+// TenantBootstrapResult holds everything created by a bootstrap. The generated admin password
+// and the client secret are not stored in plain text and can't be read again later.
+type TenantBootstrapResult struct {
+	Tenant        *models.Tenant
+	AdminUser     *models.User
+	AdminPassword string // only set when generated
+	Client        *models.Client
+}
+
+var ErrTenantExists = errors.New("a tenant with this domain or subdomain already exists")
+
+// TenantBootstrapService creates a working tenant in one step: the tenant with its default
+// scopes, groups, social providers and system clients, an admin user in the Administrators
+// group and a first OAuth client. Either everything is created or nothing is.
+type TenantBootstrapService struct {
+	db                    *database.MongoDB
+	tenantService         *TenantService
+	userService           *UserService
+	scopeService          *ScopeService
+	groupService          *GroupService
+	socialProviderService *SocialProviderService
+	clientService         *ClientService
+	membershipService     *MembershipService
+	webBaseURL            string
+}
+
+func NewTenantBootstrapService(
+	db *database.MongoDB,
+	tenantService *TenantService,
+	userService *UserService,
+	scopeService *ScopeService,
+	groupService *GroupService,
+	socialProviderService *SocialProviderService,
+	clientService *ClientService,
+	webBaseURL string,
+) *TenantBootstrapService {
+	return &TenantBootstrapService{
+		db:                    db,
+		tenantService:         tenantService,
+		userService:           userService,
+		scopeService:          scopeService,
+		groupService:          groupService,
+		socialProviderService: socialProviderService,
+		clientService:         clientService,
+		membershipService:     NewMembershipService(db),
+		webBaseURL:            webBaseURL,
+	}
+}
+
+// Bootstrap creates the tenant and everything it needs in one transaction, so a failed step
+// leaves nothing behind and the request can simply be retried. The unique domain and subdomain
+// indexes reject a tenant that already exists, even when two bootstraps race.
+func (s *TenantBootstrapService) Bootstrap(req *TenantBootstrapRequest) (*TenantBootstrapResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, err := s.db.Client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	var result *TenantBootstrapResult
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		tenant := &models.Tenant{
+			Name:      req.Name,
+			Domain:    req.Domain,
+			Subdomain: req.Subdomain,
+			Settings:  req.Settings,
+			Storage:   models.TenantStorage{Region: req.Region},
+		}
+		if err := s.tenantService.createTenant(sc, tenant); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, ErrTenantExists
+			}
+			return nil, fmt.Errorf("failed to create tenant: %w", err)
+		}
+
+		var err error
+		result, err = s.populate(sc, tenant, req)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tenant := result.Tenant
+	s.db.SetTenantPlacement(tenant.ID.Hex(), storagePlacement(tenant.Storage))
+	log.Printf("Bootstrapped tenant %s (ID: %s) with admin %s", tenant.Name, tenant.ID.Hex(), result.AdminUser.Email)
+	return result, nil
+}
+
+// populate creates the tenant's defaults, admin and client within the bootstrap transaction
+func (s *TenantBootstrapService) populate(ctx context.Context, tenant *models.Tenant, req *TenantBootstrapRequest) (*TenantBootstrapResult, error) {
+	tenantID := tenant.ID.Hex()
+
+	if err := s.scopeService.initializeDefaultScopes(ctx, tenantID); err != nil {
+		return nil, fmt.Errorf("failed to initialize default scopes: %w", err)
+	}
+	if err := s.groupService.initializeDefaultGroups(ctx, tenantID); err != nil {
+		return nil, fmt.Errorf("failed to initialize default groups: %w", err)
+	}
+	if err := s.socialProviderService.initializeDefaultProviders(ctx, tenantID); err != nil {
+		return nil, fmt.Errorf("failed to initialize default social providers: %w", err)
+	}
+	if err := s.clientService.initializeSystemClients(ctx, tenantID, s.webBaseURL); err != nil {
+		return nil, fmt.Errorf("failed to initialize system clients: %w", err)
+	}
+
+	adminGroup, err := s.groupService.getGroupByName(ctx, "Administrators", tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("administrators group not found: %w", err)
+	}
+
+	result := &TenantBootstrapResult{Tenant: tenant}
+
+	password := req.Admin.Password
+	if password == "" {
+		if password, err = generateBootstrapPassword(); err != nil {
+			return nil, err
+		}
+		result.AdminPassword = password
+	}
+
+	admin := &models.User{
+		TenantID:     tenantID,
+		Email:        req.Admin.Email,
+		Username:     req.Admin.Email,
+		PasswordHash: password, // hashed by createUser
+		FirstName:    req.Admin.FirstName,
+		LastName:     req.Admin.LastName,
+		Groups:       []string{adminGroup.ID.Hex()},
+		Scopes:       adminGroup.Scopes,
+	}
+	if err := s.userService.createUser(ctx, admin); err != nil {
+		return nil, fmt.Errorf("failed to create admin user: %w", err)
+	}
+	if err := s.membershipService.syncUser(ctx, admin); err != nil {
+		return nil, fmt.Errorf("failed to add admin user to group: %w", err)
+	}
+	result.AdminUser = admin
+
+	client := &models.Client{
+		TenantID:     tenantID,
+		Name:         req.Client.Name,
+		Description:  req.Client.Description,
+		RedirectURIs: req.Client.RedirectURIs,
+		Scopes:       req.Client.Scopes,
+		GrantTypes:   req.Client.GrantTypes,
+		ClientType:   req.Client.ClientType,
+	}
+	if client.Name == "" {
+		client.Name = tenant.Name
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = []string{"openid", "profile", "email"}
+	}
+	// The tenant isn't committed yet, so createClient can't look up its secret policy
+	if maxAge := req.Settings.ClientSecretPolicy.MaxAgeDays; maxAge > 0 {
+		expiresAt := time.Now().AddDate(0, 0, maxAge)
+		client.ClientSecretExpiresAt = &expiresAt
+	}
+	if err := s.clientService.createClient(ctx, client); err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	result.Client = client
+
+	return result, nil
+}
+
+func generateBootstrapPassword() (string, error) {
+	bytes := make([]byte, 18)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/database/dbtest"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newTestTenantBootstrapService(db *database.MongoDB) *TenantBootstrapService {
+	return NewTenantBootstrapService(db, NewTenantService(db), NewUserService(db), NewScopeService(db.Database),
+		NewGroupService(db), NewSocialProviderService(db), NewClientService(db), "https://app.example.com")
+}
+
+func testTenantBootstrapRequest() *TenantBootstrapRequest {
+	return &TenantBootstrapRequest{
+		Name:      "Acme",
+		Domain:    "acme.com",
+		Subdomain: "acme",
+		Admin:     TenantBootstrapAdmin{Email: "admin@acme.com"},
+		Client:    TenantBootstrapClient{RedirectURIs: []string{"https://portal.acme.com/callback"}},
+	}
+}
+
+func TestTenantBootstrap(t *testing.T) {
+	db := dbtest.New(t)
+	if err := NewTenantService(db).EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+	service := newTestTenantBootstrapService(db)
+
+	result, err := service.Bootstrap(testTenantBootstrapRequest())
+	if err != nil {
+		t.Fatalf("Bootstrap() error = %v", err)
+	}
+	tenantID := result.Tenant.ID.Hex()
+
+	admin, err := NewUserService(db).GetUserByEmailAndTenant("admin@acme.com", tenantID)
+	if err != nil {
+		t.Fatalf("admin user not found: %v", err)
+	}
+	if result.AdminPassword == "" || !NewUserService(db).ValidatePassword(admin, result.AdminPassword) {
+		t.Error("generated admin password does not match the stored hash")
+	}
+	adminGroup, err := NewGroupService(db).GetGroupByName("Administrators", tenantID)
+	if err != nil || len(admin.Groups) != 1 || admin.Groups[0] != adminGroup.ID.Hex() {
+		t.Errorf("admin groups = %v, want the Administrators group", admin.Groups)
+	}
+
+	client, err := NewClientService(db).GetClientByClientID(result.Client.ClientID, tenantID)
+	if err != nil {
+		t.Fatalf("client not found: %v", err)
+	}
+	if client.Name != "Acme" || client.ClientSecret == "" || client.ClientSecret != result.Client.ClientSecret {
+		t.Errorf("client = %+v, want the tenant name and the returned secret", client)
+	}
+
+	// A second bootstrap of the same domain is rejected by the unique index
+	if _, err := service.Bootstrap(testTenantBootstrapRequest()); !errors.Is(err, ErrTenantExists) {
+		t.Errorf("Bootstrap() of a taken domain error = %v, want ErrTenantExists", err)
+	}
+}
+
+func TestTenantBootstrapRollback(t *testing.T) {
+	db := dbtest.New(t)
+	service := newTestTenantBootstrapService(db)
+
+	// The client is created last; a public client can't use client credentials
+	req := testTenantBootstrapRequest()
+	req.Admin.Password = "correct-horse"
+	req.Client.ClientType = "public"
+	req.Client.GrantTypes = []string{"client_credentials"}
+	if _, err := service.Bootstrap(req); !errors.Is(err, ErrPublicClientGrant) {
+		t.Fatalf("Bootstrap() error = %v, want ErrPublicClientGrant", err)
+	}
+
+	for _, name := range []string{"tenants", "users", "clients", "groups", "scopes", "social_providers"} {
+		count, err := db.GetCollection(name).CountDocuments(context.Background(), bson.M{})
+		if err != nil || count != 0 {
+			t.Errorf("%s holds %d documents after the failed bootstrap, want 0 (err %v)", name, count, err)
+		}
+	}
+
+	// Nothing is left behind, so the corrected request succeeds
+	req.Client.ClientType = ""
+	req.Client.GrantTypes = nil
+	result, err := service.Bootstrap(req)
+	if err != nil {
+		t.Fatalf("Bootstrap() retry error = %v", err)
+	}
+	if result.AdminPassword != "" {
+		t.Error("AdminPassword is set although a password was given")
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// TenantDomainRecordPrefix is prepended to a claimed domain to name the TXT record that proves
+	// the claim, e.g. _oauth2-verification.acme.com
+	TenantDomainRecordPrefix = "_oauth2-verification."
+
+	// DiscoveryRequestsPerMinute is how many discovery requests a client address may make per minute
+	DiscoveryRequestsPerMinute = 20
+)
+
+var (
+	ErrTenantDomainNotFound   = errors.New("domain not found")
+	ErrTenantDomainClaimed    = errors.New("the domain is already verified for another tenant")
+	ErrTenantDomainUnverified = errors.New("the verification record was not found in DNS")
+	ErrTenantNotDiscovered    = errors.New("no tenant for this email address")
+	ErrInvalidDiscoveryEmail  = errors.New("a valid email address is required")
+)
+
+// DiscoveryRateLimitError is returned when a client address makes too many discovery requests.
+// RetryAfter is when it may try again.
+type DiscoveryRateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *DiscoveryRateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded: at most %d discovery requests per minute", DiscoveryRequestsPerMinute)
+}
+
+// TenantDiscoveryService finds the tenant of an email address through the email domains tenants
+// have verified, so a single login box can send users to their tenant. Tenants prove domain
+// ownership with a DNS TXT record. Lookups are rate limited per client address across all server
+// instances.
+type TenantDiscoveryService struct {
+	db               *database.MongoDB
+	domains          *mongo.Collection
+	windowCollection *mongo.Collection
+	tenantService    *TenantService
+	lookupTXT        func(ctx context.Context, name string) ([]string, error)
+}
+
+func NewTenantDiscoveryService(db *database.MongoDB) *TenantDiscoveryService {
+	return &TenantDiscoveryService{
+		db:               db,
+		domains:          db.GetCollection("tenant_domains"),
+		windowCollection: db.GetCollection("tenant_discovery_windows"),
+		tenantService:    NewTenantService(db),
+		lookupTXT:        net.DefaultResolver.LookupTXT,
+	}
+}
+
+// EnsureIndexes makes a domain verifiable for one tenant only, keeps one claim per tenant and
+// domain, and expires the per-minute discovery counters
+func (s *TenantDiscoveryService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.domains.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "domain", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "domain", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"verified_at": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.windowCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "address", Value: 1}, {Key: "window", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "window", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(rateLimitWindowRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// ListDomains returns the domains the tenant has claimed
+func (s *TenantDiscoveryService) ListDomains(tenantID string) ([]models.TenantDomain, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.domains.Find(ctx, bson.M{"tenant_id": tenantID}, options.Find().SetSort(bson.D{{Key: "domain", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	domains := []models.TenantDomain{}
+	if err := cursor.All(ctx, &domains); err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
+
+// AddDomain claims a domain for the tenant and returns the claim with its verification token.
+// Claiming a domain again returns the existing claim.
+func (s *TenantDiscoveryService) AddDomain(tenantID, domain string) (*models.TenantDomain, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if err := s.domains.FindOne(ctx, bson.M{
+		"domain": domain, "tenant_id": bson.M{"$ne": tenantID}, "verified_at": bson.M{"$exists": true},
+	}).Err(); err == nil {
+		return nil, ErrTenantDomainClaimed
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	token, err := generateDomainVerificationToken()
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.domains.UpdateOne(ctx, bson.M{"tenant_id": tenantID, "domain": domain}, bson.M{
+		"$setOnInsert": bson.M{"verification_token": token, "created_at": time.Now()},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+	return s.domain(ctx, tenantID, domain)
+}
+
+// VerifyDomain checks DNS for the claim's TXT record and marks the domain verified when it is
+// published
+func (s *TenantDiscoveryService) VerifyDomain(tenantID, domain string) (*models.TenantDomain, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claim, err := s.domain(ctx, tenantID, domain)
+	if err != nil {
+		return nil, err
+	}
+	if claim.VerifiedAt != nil {
+		return claim, nil
+	}
+
+	records, err := s.lookupTXT(ctx, TenantDomainRecordPrefix+claim.Domain)
+	if err != nil {
+		log.Printf("Failed to look up the verification record of %s: %v", claim.Domain, err)
+		return nil, ErrTenantDomainUnverified
+	}
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == TenantDomainRecordValue(claim.VerificationToken) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrTenantDomainUnverified
+	}
+
+	_, err = s.domains.UpdateOne(ctx, bson.M{"_id": claim.ID}, bson.M{"$set": bson.M{"verified_at": time.Now()}})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrTenantDomainClaimed
+	} else if err != nil {
+		return nil, err
+	}
+	return s.domain(ctx, tenantID, claim.Domain)
+}
+
+// RemoveDomain drops the tenant's claim on a domain
+func (s *TenantDiscoveryService) RemoveDomain(tenantID, domain string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.domains.DeleteOne(ctx, bson.M{"tenant_id": tenantID, "domain": strings.ToLower(domain)})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrTenantDomainNotFound
+	}
+	return nil
+}
+
+// Discover returns the active tenant that verified the domain of the email address. Only the
+// domain is looked at, so the result doesn't depend on whether the user exists.
+func (s *TenantDiscoveryService) Discover(email string) (*models.Tenant, error) {
+	local, domain, found := strings.Cut(strings.TrimSpace(email), "@")
+	if !found || local == "" || domain == "" || strings.ContainsAny(domain, "@/ ") {
+		return nil, ErrInvalidDiscoveryEmail
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var claim models.TenantDomain
+	err := s.domains.FindOne(ctx, bson.M{
+		"domain": strings.ToLower(strings.TrimSuffix(domain, ".")), "verified_at": bson.M{"$exists": true},
+	}).Decode(&claim)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTenantNotDiscovered
+	} else if err != nil {
+		return nil, err
+	}
+
+	tenant, err := s.tenantService.GetTenantByID(claim.TenantID)
+	if err != nil {
+		return nil, ErrTenantNotDiscovered
+	}
+	return tenant, nil
+}
+
+// ReserveDiscovery counts a discovery request against the client address's per-minute limit.
+// Storage failures are not throttled.
+func (s *TenantDiscoveryService) ReserveDiscovery(address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	window := now.Truncate(time.Minute)
+	_, err := s.windowCollection.UpdateOne(ctx, bson.M{
+		"address":  address,
+		"window":   window,
+		"requests": bson.M{"$lt": DiscoveryRequestsPerMinute},
+	}, bson.M{"$inc": bson.M{"requests": 1}}, options.Update().SetUpsert(true))
+	if err == nil {
+		return nil
+	}
+
+	// The counter exists but is at the limit, so the upsert collided with the unique index
+	if mongo.IsDuplicateKeyError(err) {
+		return &DiscoveryRateLimitError{RetryAfter: window.Add(time.Minute).Sub(now)}
+	}
+
+	log.Printf("Warning: Failed to count discovery request of %s: %v", address, err)
+	return nil
+}
+
+func (s *TenantDiscoveryService) domain(ctx context.Context, tenantID, domain string) (*models.TenantDomain, error) {
+	var claim models.TenantDomain
+	err := s.domains.FindOne(ctx, bson.M{"tenant_id": tenantID, "domain": strings.ToLower(domain)}).Decode(&claim)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTenantDomainNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+// TenantDomainRecordValue is the TXT record value that proves a domain claim with the token
+func TenantDomainRecordValue(token string) string {
+	return "oauth2-verification=" + token
+}
+
+func generateDomainVerificationToken() (string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
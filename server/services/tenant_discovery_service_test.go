@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestTenantDiscovery verifies a domain through DNS and discovers its tenant from email addresses
+func TestTenantDiscovery(t *testing.T) {
+	db := dbtest.New(t)
+
+	acmeID := primitive.NewObjectID()
+	otherID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "tenants",
+		&models.Tenant{ID: acmeID, Name: "Acme", Domain: "acme", Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.Tenant{ID: otherID, Name: "Other", Domain: "other", Active: true, CreatedAt: now, UpdatedAt: now},
+	)
+
+	service := NewTenantDiscoveryService(db)
+	if err := service.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+	records := map[string][]string{}
+	service.lookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		return records[name], nil
+	}
+
+	claim, err := service.AddDomain(acmeID.Hex(), "Acme.com")
+	if err != nil {
+		t.Fatalf("AddDomain() error = %v", err)
+	}
+	if _, err := service.Discover("jane@acme.com"); !errors.Is(err, ErrTenantNotDiscovered) {
+		t.Errorf("Discover() of an unverified domain error = %v, want ErrTenantNotDiscovered", err)
+	}
+	if _, err := service.VerifyDomain(acmeID.Hex(), "acme.com"); !errors.Is(err, ErrTenantDomainUnverified) {
+		t.Errorf("VerifyDomain() without a record error = %v, want ErrTenantDomainUnverified", err)
+	}
+
+	records[TenantDomainRecordPrefix+"acme.com"] = []string{"v=spf1 -all", TenantDomainRecordValue(claim.VerificationToken)}
+	if claim, err = service.VerifyDomain(acmeID.Hex(), "acme.com"); err != nil || claim.VerifiedAt == nil {
+		t.Fatalf("VerifyDomain() = %+v, %v, want a verified domain", claim, err)
+	}
+
+	tenant, err := service.Discover("Jane.Doe@ACME.com")
+	if err != nil || tenant.ID != acmeID {
+		t.Errorf("Discover() = %v, %v, want tenant %s", tenant, err, acmeID.Hex())
+	}
+	if _, err := service.Discover("not-an-email"); !errors.Is(err, ErrInvalidDiscoveryEmail) {
+		t.Errorf("Discover() of an invalid address error = %v, want ErrInvalidDiscoveryEmail", err)
+	}
+
+	// Another tenant can't take over a verified domain
+	if _, err := service.AddDomain(otherID.Hex(), "acme.com"); !errors.Is(err, ErrTenantDomainClaimed) {
+		t.Errorf("AddDomain() of a verified domain error = %v, want ErrTenantDomainClaimed", err)
+	}
+}
+
+// TestDiscoveryRateLimit throttles a client address after DiscoveryRequestsPerMinute requests
+func TestDiscoveryRateLimit(t *testing.T) {
+	db := dbtest.New(t)
+
+	service := NewTenantDiscoveryService(db)
+	if err := service.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes() error = %v", err)
+	}
+
+	for i := 0; i < DiscoveryRequestsPerMinute; i++ {
+		if err := service.ReserveDiscovery("203.0.113.7"); err != nil {
+			t.Fatalf("ReserveDiscovery() #%d error = %v", i+1, err)
+		}
+	}
+	var limited *DiscoveryRateLimitError
+	if err := service.ReserveDiscovery("203.0.113.7"); !errors.As(err, &limited) || limited.RetryAfter <= 0 {
+		t.Errorf("ReserveDiscovery() over the limit error = %v, want a DiscoveryRateLimitError", err)
+	}
+	if err := service.ReserveDiscovery("203.0.113.8"); err != nil {
+		t.Errorf("ReserveDiscovery() of another address error = %v", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type TenantService struct {
@@ -42,7 +43,22 @@ func (s *TenantService) CreateTenant(tenant *models.Tenant) error {
 		return errors.New("tenant with this subdomain already exists")
 	}
 
+	if err := s.createTenant(ctx, tenant); err != nil {
+		return err
+	}
+	s.db.SetTenantPlacement(tenant.ID.Hex(), storagePlacement(tenant.Storage))
+	return nil
+}
+
+// createTenant stores a new tenant; the unique domain and subdomain indexes reject duplicates of
+// an active tenant
+func (s *TenantService) createTenant(ctx context.Context, tenant *models.Tenant) error {
+	if err := s.db.CheckResidency(storagePlacement(tenant.Storage)); err != nil {
+		return err
+	}
+
 	tenant.ID = primitive.NewObjectID()
+	tenant.Version = 1
 	tenant.CreatedAt = time.Now()
 	tenant.UpdatedAt = time.Now()
 	tenant.Active = true
@@ -56,6 +72,26 @@ func (s *TenantService) CreateTenant(tenant *models.Tenant) error {
 	return err
 }
 
+// EnsureIndexes creates the unique indexes on the domain and subdomain of active tenants
+func (s *TenantService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.tenantCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "domain", Value: 1}},
+			Options: options.Index().SetName("unique_active_domain").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"domain": bson.M{"$gt": ""}, "active": true}),
+		},
+		{
+			Keys: bson.D{{Key: "subdomain", Value: 1}},
+			Options: options.Index().SetName("unique_active_subdomain").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"subdomain": bson.M{"$gt": ""}, "active": true}),
+		},
+	})
+	return err
+}
+
 func (s *TenantService) GetTenantByID(tenantID string) (*models.Tenant, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -147,7 +183,8 @@ func (s *TenantService) GetAllTenants() ([]*models.Tenant, error) {
 	return tenants, nil
 }
 
-func (s *TenantService) UpdateTenant(tenantID string, tenant *models.Tenant) error {
+// UpdateTenant updates a tenant if its stored version equals expectedVersion (AnyVersion skips the check)
+func (s *TenantService) UpdateTenant(tenantID string, tenant *models.Tenant, expectedVersion int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -166,15 +203,17 @@ func (s *TenantService) UpdateTenant(tenantID string, tenant *models.Tenant) err
 			"settings":   tenant.Settings,
 			"updated_at": tenant.UpdatedAt,
 		},
+		"$inc": bson.M{"version": 1},
 	}
 
-	result, err := s.tenantCollection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
+	filter := bson.M{"_id": objectID}
+	result, err := s.tenantCollection.UpdateOne(ctx, withExpectedVersion(filter, expectedVersion), update)
 	if err != nil {
 		return err
 	}
 
 	if result.MatchedCount == 0 {
-		return errors.New("tenant not found")
+		return versionedUpdateError(ctx, s.tenantCollection, filter, errors.New("tenant not found"))
 	}
 
 	return nil
@@ -195,6 +234,7 @@ func (s *TenantService) DeleteTenant(tenantID string) error {
 			"active":     false,
 			"updated_at": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	}
 
 	result, err := s.tenantCollection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
@@ -0,0 +1,388 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrSettingsChangeNotFound = errors.New("settings change not found")
+	ErrSettingsChangeState    = errors.New("settings change is not in a state that allows this")
+)
+
+// SettingsImpact describes what a tenant settings update would change and whom it affects
+type SettingsImpact struct {
+	ChangedSettings []string              `json:"changed_settings"` // JSON names of the settings that differ
+	TwoFactor       *TwoFactorImpact      `json:"two_factor,omitempty"`
+	Sessions        *SessionTimeoutImpact `json:"sessions,omitempty"`
+	Warnings        []string              `json:"warnings"`
+}
+
+// TwoFactorImpact counts the active users who would be required to use a second factor
+type TwoFactorImpact struct {
+	ActiveUsers     int64 `json:"active_users"`
+	UsersWithoutMFA int64 `json:"users_without_mfa"` // must enroll before they can sign in again
+	UsersLosingSMS  int64 `json:"users_losing_sms"`  // relied on SMS codes, which would no longer be accepted
+}
+
+// SessionTimeoutImpact counts the sessions that have been idle longer than a shorter timeout
+type SessionTimeoutImpact struct {
+	ActiveSessions   int64 `json:"active_sessions"`
+	ExceedingTimeout int64 `json:"exceeding_timeout"` // idle longer than the new session timeout
+}
+
+// TenantSettingsChangeService previews tenant settings updates and applies them at a scheduled
+// time with the option to roll them back
+type TenantSettingsChangeService struct {
+	db      *database.MongoDB
+	changes *mongo.Collection
+	tenants *mongo.Collection
+}
+
+func NewTenantSettingsChangeService(db *database.MongoDB) *TenantSettingsChangeService {
+	return &TenantSettingsChangeService{
+		db:      db,
+		changes: db.GetCollection("tenant_settings_changes"),
+		tenants: db.GetCollection("tenants"),
+	}
+}
+
+// Preview reports the impact of replacing the tenant's settings with proposed, without changing anything
+func (s *TenantSettingsChangeService) Preview(tenantID string, proposed models.TenantSettings) (*SettingsImpact, error) {
+	tenant, err := NewTenantService(s.db).GetTenantByID(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	current := tenant.Settings
+	impact := &SettingsImpact{ChangedSettings: changedSettings(current, proposed), Warnings: []string{}}
+	users := s.db.GetCollection("users")
+
+	losesSMS := current.AllowSMSTwoFactor && !proposed.AllowSMSTwoFactor
+	if proposed.RequireTwoFactor && (!current.RequireTwoFactor || losesSMS) {
+		activeUsers := bson.M{"tenant_id": tenantID, "active": true}
+		twoFactor := &TwoFactorImpact{}
+		if twoFactor.ActiveUsers, err = users.CountDocuments(ctx, activeUsers); err != nil {
+			return nil, err
+		}
+		enrolled, err := users.CountDocuments(ctx, withFilter(activeUsers, enrolledFilter(proposed.AllowSMSTwoFactor)))
+		if err != nil {
+			return nil, err
+		}
+		twoFactor.UsersWithoutMFA = twoFactor.ActiveUsers - enrolled
+		if losesSMS {
+			if twoFactor.UsersLosingSMS, err = users.CountDocuments(ctx, withFilter(activeUsers, smsOnlyFilter())); err != nil {
+				return nil, err
+			}
+		}
+		impact.TwoFactor = twoFactor
+	} else if losesSMS {
+		count, err := users.CountDocuments(ctx, withFilter(bson.M{"tenant_id": tenantID, "active": true}, smsOnlyFilter()))
+		if err != nil {
+			return nil, err
+		}
+		impact.TwoFactor = &TwoFactorImpact{UsersLosingSMS: count}
+	}
+
+	if proposed.SessionTimeout > 0 && (current.SessionTimeout == 0 || proposed.SessionTimeout < current.SessionTimeout) {
+		now := time.Now()
+		active := bson.M{"tenant_id": tenantID, "revoked": false, "expires_at": bson.M{"$gt": now}}
+		sessions := &SessionTimeoutImpact{}
+		refreshTokens := s.db.GetCollection("refresh_tokens")
+		if sessions.ActiveSessions, err = refreshTokens.CountDocuments(ctx, active); err != nil {
+			return nil, err
+		}
+		cutoff := now.Add(-time.Duration(proposed.SessionTimeout) * time.Minute)
+		idle := withFilter(active, bson.M{"$or": bson.A{
+			bson.M{"last_used_at": bson.M{"$lt": cutoff}},
+			bson.M{"last_used_at": nil, "created_at": bson.M{"$lt": cutoff}},
+		}})
+		if sessions.ExceedingTimeout, err = refreshTokens.CountDocuments(ctx, idle); err != nil {
+			return nil, err
+		}
+		impact.Sessions = sessions
+	}
+
+	impact.Warnings = settingsWarnings(impact)
+	return impact, nil
+}
+
+// Schedule records a settings change to be applied at applyAt. Changes due now are applied
+// immediately, so they can be rolled back like scheduled ones.
+func (s *TenantSettingsChangeService) Schedule(tenantID string, settings models.TenantSettings, applyAt time.Time, createdBy string) (*models.TenantSettingsChange, error) {
+	if _, err := NewTenantService(s.db).GetTenantByID(tenantID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	if applyAt.IsZero() {
+		applyAt = now
+	}
+	change := &models.TenantSettingsChange{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantID,
+		Status:    models.SettingsChangeScheduled,
+		Settings:  settings,
+		ApplyAt:   applyAt,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := s.changes.InsertOne(ctx, change); err != nil {
+		return nil, err
+	}
+
+	if !applyAt.After(now) {
+		if err := s.apply(change); err != nil {
+			return change, err
+		}
+	}
+	return change, nil
+}
+
+// List returns the tenant's settings changes, newest first
+func (s *TenantSettingsChangeService) List(tenantID string) ([]*models.TenantSettingsChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.changes.Find(ctx, bson.M{"tenant_id": tenantID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	changes := []*models.TenantSettingsChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Cancel withdraws a change that has not been applied yet
+func (s *TenantSettingsChangeService) Cancel(tenantID, changeID string) (*models.TenantSettingsChange, error) {
+	change, err := s.get(tenantID, changeID)
+	if err != nil {
+		return nil, err
+	}
+	if change.Status != models.SettingsChangeScheduled {
+		return nil, ErrSettingsChangeState
+	}
+
+	if err := s.setStatus(change, models.SettingsChangeScheduled, bson.M{"status": models.SettingsChangeCancelled}); err != nil {
+		return nil, err
+	}
+	change.Status = models.SettingsChangeCancelled
+	return change, nil
+}
+
+// Rollback restores the settings an applied change replaced. It fails with a VersionConflictError
+// if the tenant has been modified since the change was applied.
+func (s *TenantSettingsChangeService) Rollback(tenantID, changeID string) (*models.TenantSettingsChange, error) {
+	change, err := s.get(tenantID, changeID)
+	if err != nil {
+		return nil, err
+	}
+	if change.Status != models.SettingsChangeApplied || change.PreviousSettings == nil {
+		return nil, ErrSettingsChangeState
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(tenantID)
+	if err != nil {
+		return nil, errors.New("invalid tenant ID")
+	}
+	filter := bson.M{"_id": objectID}
+	result, err := s.tenants.UpdateOne(ctx, withExpectedVersion(filter, change.AppliedVersion), bson.M{
+		"$set": bson.M{"settings": change.PreviousSettings, "updated_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, versionedUpdateError(ctx, s.tenants, filter, errors.New("tenant not found"))
+	}
+
+	now := time.Now()
+	if err := s.setStatus(change, models.SettingsChangeApplied, bson.M{"status": models.SettingsChangeRolledBack, "rolled_back_at": now}); err != nil {
+		return nil, err
+	}
+	change.Status = models.SettingsChangeRolledBack
+	change.RolledBackAt = &now
+	return change, nil
+}
+
+// ApplyDueChanges applies the scheduled changes whose time has come, oldest first
+func (s *TenantSettingsChangeService) ApplyDueChanges() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.changes.Find(ctx, bson.M{
+		"status":   models.SettingsChangeScheduled,
+		"apply_at": bson.M{"$lte": time.Now()},
+	}, options.Find().SetSort(bson.D{{Key: "apply_at", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var due []*models.TenantSettingsChange
+	if err := cursor.All(ctx, &due); err != nil {
+		return err
+	}
+
+	for _, change := range due {
+		if err := s.apply(change); err != nil {
+			log.Printf("Warning: Failed to apply settings change %s of tenant %s: %v", change.ID.Hex(), change.TenantID, err)
+		}
+	}
+	return nil
+}
+
+// apply replaces the tenant's settings, remembering the previous ones for a rollback
+func (s *TenantSettingsChangeService) apply(change *models.TenantSettingsChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(change.TenantID)
+	if err != nil {
+		return errors.New("invalid tenant ID")
+	}
+
+	var previous models.Tenant
+	err = s.tenants.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, bson.M{
+		"$set": bson.M{"settings": change.Settings, "updated_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}).Decode(&previous)
+	if err != nil {
+		s.setStatus(change, models.SettingsChangeScheduled, bson.M{"status": models.SettingsChangeFailed, "error": err.Error()})
+		change.Status = models.SettingsChangeFailed
+		return err
+	}
+
+	now := time.Now()
+	change.Status = models.SettingsChangeApplied
+	change.PreviousSettings = &previous.Settings
+	change.AppliedAt = &now
+	change.AppliedVersion = previous.Version + 1
+	return s.setStatus(change, models.SettingsChangeScheduled, bson.M{
+		"status":            change.Status,
+		"previous_settings": change.PreviousSettings,
+		"applied_at":        now,
+		"applied_version":   change.AppliedVersion,
+	})
+}
+
+func (s *TenantSettingsChangeService) get(tenantID, changeID string) (*models.TenantSettingsChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(changeID)
+	if err != nil {
+		return nil, ErrSettingsChangeNotFound
+	}
+
+	var change models.TenantSettingsChange
+	if err := s.changes.FindOne(ctx, bson.M{"_id": objectID, "tenant_id": tenantID}).Decode(&change); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSettingsChangeNotFound
+		}
+		return nil, err
+	}
+	return &change, nil
+}
+
+// setStatus updates a change if it is still in the expected status
+func (s *TenantSettingsChangeService) setStatus(change *models.TenantSettingsChange, expected string, fields bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fields["updated_at"] = time.Now()
+	result, err := s.changes.UpdateOne(ctx, bson.M{"_id": change.ID, "status": expected}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSettingsChangeState
+	}
+	return nil
+}
+
+// enrolledFilter matches users with a second factor they could sign in with
+func enrolledFilter(smsAllowed bool) bson.M {
+	if !smsAllowed {
+		return bson.M{"two_factor_enabled": true}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"two_factor_enabled": true},
+		bson.M{"sms_two_factor": true, "phone_verified": true},
+	}}
+}
+
+// smsOnlyFilter matches users whose only second factor is SMS
+func smsOnlyFilter() bson.M {
+	return bson.M{"sms_two_factor": true, "phone_verified": true, "two_factor_enabled": bson.M{"$ne": true}}
+}
+
+// withFilter combines two filters
+func withFilter(filter, extra bson.M) bson.M {
+	return bson.M{"$and": bson.A{filter, extra}}
+}
+
+// changedSettings lists the JSON names of the top-level settings that differ
+func changedSettings(current, proposed models.TenantSettings) []string {
+	before, after := settingsFields(current), settingsFields(proposed)
+
+	changed := []string{}
+	for name, value := range after {
+		if !reflect.DeepEqual(before[name], value) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func settingsFields(settings models.TenantSettings) map[string]interface{} {
+	fields := map[string]interface{}{}
+	data, _ := json.Marshal(settings)
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// settingsWarnings explains the impact in words for administrators
+func settingsWarnings(impact *SettingsImpact) []string {
+	warnings := []string{}
+	if impact.TwoFactor != nil && impact.TwoFactor.UsersWithoutMFA > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d of %d active users have no second factor and will have to enroll before they can sign in", impact.TwoFactor.UsersWithoutMFA, impact.TwoFactor.ActiveUsers))
+	}
+	if impact.TwoFactor != nil && impact.TwoFactor.UsersLosingSMS > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d users only have SMS codes as second factor, which will no longer be accepted", impact.TwoFactor.UsersLosingSMS))
+	}
+	if impact.Sessions != nil && impact.Sessions.ExceedingTimeout > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d of %d active sessions have been idle longer than the new session timeout", impact.Sessions.ExceedingTimeout, impact.Sessions.ActiveSessions))
+	}
+	return warnings
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestChangedSettings(t *testing.T) {
+	current := models.TenantSettings{SessionTimeout: 60, DefaultLocale: "en"}
+	proposed := current
+	proposed.SessionTimeout = 15
+	proposed.RequireTwoFactor = true
+	proposed.CustomBranding.PrimaryColor = "#000000"
+
+	got := changedSettings(current, proposed)
+	want := []string{"custom_branding", "require_two_factor", "session_timeout"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changedSettings() = %v, want %v", got, want)
+	}
+
+	if got := changedSettings(current, current); len(got) != 0 {
+		t.Errorf("changedSettings() of identical settings = %v, want none", got)
+	}
+}
+
+func TestSettingsWarnings(t *testing.T) {
+	if got := settingsWarnings(&SettingsImpact{}); len(got) != 0 {
+		t.Errorf("settingsWarnings() without impact = %v, want none", got)
+	}
+
+	impact := &SettingsImpact{
+		TwoFactor: &TwoFactorImpact{ActiveUsers: 10, UsersWithoutMFA: 4, UsersLosingSMS: 1},
+		Sessions:  &SessionTimeoutImpact{ActiveSessions: 8, ExceedingTimeout: 0},
+	}
+	got := settingsWarnings(impact)
+	if len(got) != 2 {
+		t.Fatalf("settingsWarnings() = %v, want two warnings", got)
+	}
+	if got[0] != "4 of 10 active users have no second factor and will have to enroll before they can sign in" {
+		t.Errorf("unexpected warning %q", got[0])
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// storageMoveTimeout bounds copying a tenant's documents to its new storage
+const storageMoveTimeout = 30 * time.Minute
+
+func storagePlacement(storage models.TenantStorage) database.Placement {
+	return database.Placement{Region: storage.Region, Database: storage.Database, CollectionPrefix: storage.CollectionPrefix}
+}
+
+// Regions lists the data residency regions tenants can be placed in on this instance
+func (s *TenantService) Regions() []string {
+	return s.db.Regions()
+}
+
+// RouteTenant checks that this instance can serve a tenant's data and routes tenants it doesn't
+// know the placement of yet, such as tenants created in a region on another instance since the
+// last reload. Tenants of regions without a cluster here get database.ErrRegionUnavailable.
+func (s *TenantService) RouteTenant(tenant *models.Tenant) error {
+	placement := storagePlacement(tenant.Storage)
+	if !s.db.ServesRegion(placement.Region) {
+		return database.ErrRegionUnavailable
+	}
+	if !placement.IsShared() && !s.db.HasTenantPlacement(tenant.ID.Hex()) {
+		s.db.SetTenantPlacement(tenant.ID.Hex(), placement)
+	}
+	return nil
+}
+
+// LoadStoragePlacements routes the tenant-scoped collections of every tenant to its storage. It
+// runs at startup and periodically, so moves made on another instance are picked up.
+func (s *TenantService) LoadStoragePlacements() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Deactivated tenants keep their placement: their documents stay where they were moved
+	cursor, err := s.tenantCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"storage": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var tenant models.Tenant
+		if err := cursor.Decode(&tenant); err != nil {
+			return err
+		}
+		s.db.SetTenantPlacement(tenant.ID.Hex(), storagePlacement(tenant.Storage))
+	}
+	return cursor.Err()
+}
+
+// MoveStorage moves a tenant's tenant-scoped collections to new storage and records it on the
+// tenant. A move that failed half-way can be repeated with the same storage.
+func (s *TenantService) MoveStorage(tenantID string, storage models.TenantStorage) (*models.Tenant, error) {
+	tenant, err := s.GetTenantByID(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageMoveTimeout)
+	defer cancel()
+
+	// The tenant is saved after the documents were moved, so a failed move is retried from the
+	// old storage and copies whatever is still left there
+	if err := s.db.MoveTenant(ctx, tenantID, storagePlacement(tenant.Storage), storagePlacement(storage)); err != nil {
+		return nil, err
+	}
+
+	tenant.Storage = storage
+	tenant.UpdatedAt = time.Now()
+	result, err := s.tenantCollection.UpdateOne(ctx, bson.M{"_id": tenant.ID}, bson.M{
+		"$set": bson.M{"storage": storage, "updated_at": tenant.UpdatedAt},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("tenant not found")
+	}
+	tenant.Version++
+	return tenant, nil
+}
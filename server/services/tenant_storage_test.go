@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestValidatePlacement(t *testing.T) {
+	valid := []database.Placement{
+		{},
+		{Database: "authy_acme"},
+		{CollectionPrefix: "acme_"},
+		{Database: "tenant-42", CollectionPrefix: "t42_"},
+	}
+	for _, placement := range valid {
+		if err := database.ValidatePlacement(placement); err != nil {
+			t.Errorf("ValidatePlacement(%+v) = %v, want nil", placement, err)
+		}
+	}
+
+	invalid := []database.Placement{
+		{Database: "admin"},
+		{Database: "acme.audit"},
+		{Database: "a b"},
+		{CollectionPrefix: "acme."},
+		{CollectionPrefix: "$acme_"},
+	}
+	for _, placement := range invalid {
+		if err := database.ValidatePlacement(placement); !errors.Is(err, database.ErrInvalidPlacement) {
+			t.Errorf("ValidatePlacement(%+v) = %v, want ErrInvalidPlacement", placement, err)
+		}
+	}
+}
+
+// TestMoveStorageRoutesTenantCollections checks that moving a tenant to a collection prefix moves
+// its audit events and consents, routes new ones there and leaves other tenants alone.
+func TestMoveStorageRoutesTenantCollections(t *testing.T) {
+	db := dbtest.New(t)
+	now := time.Now()
+
+	acmeID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+	dbtest.Insert(t, db, "tenants",
+		&models.Tenant{ID: acmeID, Name: "Acme", Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.Tenant{ID: otherID, Name: "Other", Active: true, CreatedAt: now, UpdatedAt: now},
+	)
+	acme, other := acmeID.Hex(), otherID.Hex()
+
+	auditService := NewAuditService(db)
+	consentService := NewConsentService(db)
+	auditService.Record(&models.AuditEvent{TenantID: acme, UserID: "u1", Type: models.AuditEventLoginSuccess})
+	auditService.Record(&models.AuditEvent{TenantID: other, UserID: "u2", Type: models.AuditEventLoginSuccess})
+	if err := consentService.GrantConsent("u1", "app", acme, ConsentDecision{ApprovedScopes: []string{"openid"}}); err != nil {
+		t.Fatalf("GrantConsent() error = %v", err)
+	}
+
+	tenantService := NewTenantService(db)
+	tenant, err := tenantService.MoveStorage(acme, models.TenantStorage{CollectionPrefix: "acme_"})
+	if err != nil {
+		t.Fatalf("MoveStorage() error = %v", err)
+	}
+	if tenant.Storage.CollectionPrefix != "acme_" {
+		t.Errorf("MoveStorage() storage = %+v, want prefix acme_", tenant.Storage)
+	}
+
+	auditService.Record(&models.AuditEvent{TenantID: acme, UserID: "u1", Type: models.AuditEventLoginSuccess})
+
+	// Only the other tenant's event stays in the shared collections
+	counts := map[string]int64{"audit_events": 1, "acme_audit_events": 2, "consents": 0, "acme_consents": 1}
+	for name, want := range counts {
+		got, err := db.GetCollection(name).CountDocuments(context.Background(), bson.M{})
+		if err != nil {
+			t.Fatalf("CountDocuments(%s) error = %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s holds %d documents, want %d", name, got, want)
+		}
+	}
+
+	logins, err := auditService.ListUserLogins("u1", acme, 10)
+	if err != nil || len(logins) != 2 {
+		t.Errorf("ListUserLogins() = %d events, %v; want 2", len(logins), err)
+	}
+	if !consentService.HasConsent("u1", "app", acme, []string{"openid"}) {
+		t.Error("HasConsent() = false after the move, want true")
+	}
+
+	// A fresh instance routes the tenant from the stored placement
+	restarted := database.NewMongoDBWithClient(db.Client, db.Database.Name())
+	if err := NewTenantService(restarted).LoadStoragePlacements(); err != nil {
+		t.Fatalf("LoadStoragePlacements() error = %v", err)
+	}
+	if got := restarted.TenantCollection(acme, "consents").Name(); got != "acme_consents" {
+		t.Errorf("TenantCollection() after reload = %s, want acme_consents", got)
+	}
+	if got := restarted.TenantCollection(other, "consents").Name(); got != "consents" {
+		t.Errorf("TenantCollection() of an unplaced tenant = %s, want consents", got)
+	}
+}
+
+// TestRegionPlacement refuses to place tenants in another region, keeps the scoped collections of
+// tenants placed there before on their region's cluster and refuses to serve tenants of regions
+// without a cluster
+func TestRegionPlacement(t *testing.T) {
+	db := dbtest.New(t)
+	ctx := context.Background()
+	euDatabase := db.Client.Database(db.Database.Name() + "_eu")
+	t.Cleanup(func() { euDatabase.Drop(context.Background()) })
+	db.AddRegion("eu", euDatabase)
+
+	tenantService := NewTenantService(db)
+	for _, region := range []string{"eu", "ap"} {
+		tenant := &models.Tenant{Name: "Acme " + region, Domain: "acme." + region, Subdomain: "acme-" + region, Storage: models.TenantStorage{Region: region}}
+		if err := tenantService.CreateTenant(tenant); !errors.Is(err, database.ErrResidencyUnsupported) {
+			t.Errorf("CreateTenant() in region %s error = %v, want ErrResidencyUnsupported", region, err)
+		}
+	}
+
+	euTenant := &models.Tenant{ID: primitive.NewObjectID(), Name: "Acme EU", Domain: "acme.eu", Subdomain: "acme-eu", Storage: models.TenantStorage{Region: "eu"}}
+	dbtest.Insert(t, db, "tenants", euTenant)
+	if err := tenantService.LoadStoragePlacements(); err != nil {
+		t.Fatalf("LoadStoragePlacements() error = %v", err)
+	}
+
+	NewAuditService(db).Record(&models.AuditEvent{TenantID: euTenant.ID.Hex(), UserID: "u1", Type: models.AuditEventLoginSuccess})
+	for regionDatabase, want := range map[*mongo.Database]int64{db.Database: 0, euDatabase: 1} {
+		got, err := regionDatabase.Collection("audit_events").CountDocuments(ctx, bson.M{})
+		if err != nil || got != want {
+			t.Errorf("%s.audit_events holds %d documents (%v), want %d", regionDatabase.Name(), got, err, want)
+		}
+	}
+
+	// An instance without the EU cluster neither serves the tenant nor falls back to its own cluster
+	usOnly := database.NewMongoDBWithClient(db.Client, db.Database.Name())
+	usTenants := NewTenantService(usOnly)
+	if err := usTenants.RouteTenant(euTenant); !errors.Is(err, database.ErrRegionUnavailable) {
+		t.Errorf("RouteTenant() without the region's cluster error = %v, want ErrRegionUnavailable", err)
+	}
+	if err := usTenants.LoadStoragePlacements(); err != nil {
+		t.Fatalf("LoadStoragePlacements() error = %v", err)
+	}
+	if _, err := usOnly.TenantCollection(euTenant.ID.Hex(), "audit_events").CountDocuments(ctx, bson.M{}); err == nil {
+		t.Error("Expected queries on a region without a cluster to fail")
+	}
+
+	// Moving the tenant out of its region needs the region's cluster, and takes its data along
+	if _, err := usTenants.MoveStorage(euTenant.ID.Hex(), models.TenantStorage{}); !errors.Is(err, database.ErrRegionUnavailable) {
+		t.Errorf("MoveStorage() from an unserved region error = %v, want ErrRegionUnavailable", err)
+	}
+	if _, err := tenantService.MoveStorage(euTenant.ID.Hex(), models.TenantStorage{}); err != nil {
+		t.Fatalf("MoveStorage() error = %v", err)
+	}
+	if got, _ := db.Database.Collection("audit_events").CountDocuments(ctx, bson.M{}); got != 1 {
+		t.Errorf("shared audit_events holds %d documents after the move, want 1", got)
+	}
+	if _, err := tenantService.MoveStorage(euTenant.ID.Hex(), models.TenantStorage{Region: "eu"}); !errors.Is(err, database.ErrResidencyUnsupported) {
+		t.Errorf("MoveStorage() into a region error = %v, want ErrResidencyUnsupported", err)
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+// Token documents can be written in two regions at once in an active-active deployment, e.g. a
+// refresh token used in one region while the user signs out in the other. A replicator that finds
+// two versions of the same document resolves them with these functions, field by field, so the
+// result doesn't depend on which write arrived last:
+//
+//   - revocation wins: a token revoked in either version stays revoked
+//   - the earlier expiry wins, so no region extends a token another region shortened
+//   - the first rotation wins: a refresh token can't be rotated into two families
+//   - usage metadata (last use, address, user agent) comes from the latest use
+
+// ResolveAccessTokenConflict merges two versions of an access token
+func ResolveAccessTokenConflict(a, b models.AccessToken) models.AccessToken {
+	resolved := a
+	resolved.Revoked = a.Revoked || b.Revoked
+	resolved.ExpiresAt = earlierTime(a.ExpiresAt, b.ExpiresAt)
+	return resolved
+}
+
+// ResolveRefreshTokenConflict merges two versions of a refresh token
+func ResolveRefreshTokenConflict(a, b models.RefreshToken) models.RefreshToken {
+	// The version rotated first keeps its family and rotation time
+	resolved := a
+	if b.RotatedAt != nil && (a.RotatedAt == nil || b.RotatedAt.Before(*a.RotatedAt)) {
+		resolved = b
+	}
+
+	resolved.Revoked = a.Revoked || b.Revoked
+	resolved.ExpiresAt = earlierTime(a.ExpiresAt, b.ExpiresAt)
+
+	latest := a
+	if b.LastUsedAt != nil && (a.LastUsedAt == nil || b.LastUsedAt.After(*a.LastUsedAt)) {
+		latest = b
+	}
+	resolved.LastUsedAt = latest.LastUsedAt
+	resolved.IPAddress = latest.IPAddress
+	resolved.UserAgent = latest.UserAgent
+	return resolved
+}
+
+func earlierTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
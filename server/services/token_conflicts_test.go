@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oauth2-openid-server/models"
+)
+
+func TestResolveRefreshTokenConflict(t *testing.T) {
+	base := time.Date(2026, time.October, 1, 12, 0, 0, 0, time.UTC)
+	firstRotation := base.Add(time.Minute)
+	secondRotation := base.Add(2 * time.Minute)
+	laterUse := base.Add(3 * time.Minute)
+
+	// Region A rotated the token first; region B rotated it later and saw a newer use
+	a := models.RefreshToken{Token: "rt", FamilyID: "family-a", ExpiresAt: base.Add(24 * time.Hour), RotatedAt: &firstRotation, LastUsedAt: &firstRotation, IPAddress: "10.0.0.1"}
+	b := models.RefreshToken{Token: "rt", FamilyID: "family-b", ExpiresAt: base.Add(time.Hour), RotatedAt: &secondRotation, LastUsedAt: &laterUse, IPAddress: "10.0.0.2", Revoked: true}
+
+	for _, resolved := range []models.RefreshToken{ResolveRefreshTokenConflict(a, b), ResolveRefreshTokenConflict(b, a)} {
+		if resolved.FamilyID != "family-a" || !resolved.RotatedAt.Equal(firstRotation) {
+			t.Errorf("Expected the first rotation to win, got family %s rotated at %v", resolved.FamilyID, resolved.RotatedAt)
+		}
+		if !resolved.Revoked {
+			t.Error("Expected the revocation to win")
+		}
+		if !resolved.ExpiresAt.Equal(base.Add(time.Hour)) {
+			t.Errorf("Expected the earlier expiry to win, got %v", resolved.ExpiresAt)
+		}
+		if !resolved.LastUsedAt.Equal(laterUse) || resolved.IPAddress != "10.0.0.2" {
+			t.Errorf("Expected usage from the latest use, got %v from %s", resolved.LastUsedAt, resolved.IPAddress)
+		}
+	}
+}
+
+func TestResolveAccessTokenConflict(t *testing.T) {
+	base := time.Date(2026, time.October, 1, 12, 0, 0, 0, time.UTC)
+	a := models.AccessToken{Token: "at", ExpiresAt: base.Add(time.Hour), Revoked: true}
+	b := models.AccessToken{Token: "at", ExpiresAt: base.Add(30 * time.Minute)}
+
+	resolved := ResolveAccessTokenConflict(b, a)
+	if !resolved.Revoked || !resolved.ExpiresAt.Equal(base.Add(30*time.Minute)) {
+		t.Errorf("Expected a revoked token expiring at the earlier time, got revoked=%v expires=%v", resolved.Revoked, resolved.ExpiresAt)
+	}
+}
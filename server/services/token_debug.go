@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Kinds of tokens DebugToken recognizes
+const (
+	TokenKindAccess  = "access_token"
+	TokenKindRefresh = "refresh_token"
+	TokenKindID      = "id_token"
+	TokenKindUnknown = "unknown"
+)
+
+// ErrDebugTokenNotFound is returned for tokens of other tenants, so they can't be probed
+var ErrDebugTokenNotFound = errors.New("token not found in this tenant")
+
+// TokenDebugInfo explains a token for support investigations. Problems lists every reason the
+// server would reject it; it is empty for a token that is currently accepted.
+type TokenDebugInfo struct {
+	Kind      string                 `json:"kind"`
+	Format    string                 `json:"format"` // jwt or opaque
+	Algorithm string                 `json:"algorithm,omitempty"`
+	KeyID     string                 `json:"key_id,omitempty"`
+	Signature string                 `json:"signature,omitempty"` // valid, invalid or unverifiable
+	Claims    map[string]interface{} `json:"claims,omitempty"`
+	Record    *TokenRecordStatus     `json:"record,omitempty"`
+	User      *TokenDebugSubject     `json:"user,omitempty"`
+	Client    *TokenDebugSubject     `json:"client,omitempty"`
+	Tenant    *TokenDebugSubject     `json:"tenant,omitempty"`
+	Problems  []string               `json:"problems"`
+}
+
+// TokenRecordStatus is the state of the token's database record
+type TokenRecordStatus struct {
+	Found     bool       `json:"found"`
+	Revoked   bool       `json:"revoked"`
+	Expired   bool       `json:"expired"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+// TokenDebugSubject is a user, client or tenant a token belongs to
+type TokenDebugSubject struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Found  bool   `json:"found"`
+	Active bool   `json:"active"`
+}
+
+// DebugToken decodes a JWT or looks up an opaque token and reports its claims, signature, database
+// record and the user, client and tenant it belongs to. Tokens of other tenants are reported as
+// not found unless allTenants is set (platform operators).
+func (s *OAuthService) DebugToken(token, tenantID string, allTenants bool) (*TokenDebugInfo, error) {
+	info := &TokenDebugInfo{Kind: TokenKindUnknown, Format: "opaque", Problems: []string{}}
+
+	var userID, clientID, tokenTenantID string
+	if strings.Count(token, ".") == 2 {
+		claims, ok := s.debugJWT(token, info)
+		if !ok {
+			return info, nil
+		}
+		userID, clientID, tokenTenantID = claimString(claims, "user_id", "sub"), claimClientID(claims), claimString(claims, "tenant_id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if info.Format == "jwt" && info.Kind != TokenKindID {
+		var stored models.AccessToken
+		if err := s.tokenCollection.FindOne(ctx, bson.M{"token": token}).Decode(&stored); err == nil {
+			info.Kind = TokenKindAccess
+			info.Record = recordStatus(stored.Revoked, stored.ExpiresAt, stored.CreatedAt, nil)
+			userID, clientID, tokenTenantID = stored.UserID, stored.ClientID, stored.TenantID
+			if !stored.Revoked && s.ancestorRevoked(ctx, stored.Ancestors) {
+				info.Problems = append(info.Problems, "a token this token was vended from was revoked or expired")
+			}
+		} else if err == mongo.ErrNoDocuments {
+			info.Kind = TokenKindAccess
+			info.Record = &TokenRecordStatus{}
+			info.Problems = append(info.Problems, "no access token record: the token wasn't issued by this server or was removed")
+		} else {
+			return nil, err
+		}
+	} else if info.Format == "opaque" {
+		var stored models.RefreshToken
+		err := s.refreshCollection.FindOne(ctx, bson.M{"token": token}).Decode(&stored)
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrDebugTokenNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		info.Kind = TokenKindRefresh
+		info.Record = recordStatus(stored.Revoked, stored.ExpiresAt, stored.CreatedAt, stored.RotatedAt)
+		userID, clientID, tokenTenantID = stored.UserID, stored.ClientID, stored.TenantID
+	}
+
+	if !allTenants && tokenTenantID != tenantID {
+		return nil, ErrDebugTokenNotFound
+	}
+
+	if info.Record != nil && info.Record.Found {
+		if info.Record.Revoked {
+			if info.Record.RotatedAt != nil {
+				info.Problems = append(info.Problems, "the refresh token was rotated; using it again revokes its whole family")
+			} else {
+				info.Problems = append(info.Problems, "the token was revoked")
+			}
+		}
+		if info.Record.Expired {
+			info.Problems = append(info.Problems, "the token expired")
+		}
+	}
+
+	s.debugSubjects(info, userID, clientID, tokenTenantID)
+	return info, nil
+}
+
+// debugJWT decodes a JWT and verifies its signature. It returns false when the token isn't a
+// parsable JWT.
+func (s *OAuthService) debugJWT(token string, info *TokenDebugInfo) (jwt.MapClaims, bool) {
+	claims := jwt.MapClaims{}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil {
+		info.Problems = append(info.Problems, "the token is not a well-formed JWT")
+		return nil, false
+	}
+
+	info.Format = "jwt"
+	info.Claims = claims
+	info.Algorithm, _ = parsed.Header["alg"].(string)
+	info.KeyID, _ = parsed.Header["kid"].(string)
+	if _, hasNonce := claims["nonce"]; hasNonce || strings.HasPrefix(info.KeyID, "hs-") || (claims["sub"] != nil && claims["user_id"] == nil) {
+		info.Kind = TokenKindID
+	}
+
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil && time.Now().After(exp.Time) {
+		info.Problems = append(info.Problems, "the exp claim is in the past")
+	}
+	if nbf, err := claims.GetNotBefore(); err == nil && nbf != nil && time.Now().Before(nbf.Time) {
+		info.Problems = append(info.Problems, "the nbf claim is in the future")
+	}
+
+	key, err := s.debugVerificationKey(info.Kind, info.KeyID, claims)
+	if err != nil {
+		info.Signature = "unverifiable"
+		info.Problems = append(info.Problems, "the signing key can't be found: "+err.Error())
+		return claims, true
+	}
+	_, err = jwt.NewParser(jwt.WithoutClaimsValidation(), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()})).
+		Parse(token, func(*jwt.Token) (interface{}, error) { return key, nil })
+	if err != nil {
+		info.Signature = "invalid"
+		info.Problems = append(info.Problems, "the signature doesn't verify: "+err.Error())
+	} else {
+		info.Signature = "valid"
+	}
+	return claims, true
+}
+
+// debugVerificationKey returns the key a token must be signed with: the client's key for ID tokens
+// with a client key ID, the server secret otherwise
+func (s *OAuthService) debugVerificationKey(kind, keyID string, claims jwt.MapClaims) ([]byte, error) {
+	if kind != TokenKindID || keyID == "" {
+		return []byte(s.jwtSecret), nil
+	}
+
+	client, err := s.getActiveClient(claimClientID(claims))
+	if err != nil {
+		return nil, err
+	}
+	key, clientKeyID, err := s.ClientSigningKey(client)
+	if err != nil {
+		return nil, err
+	}
+	if clientKeyID != keyID {
+		return nil, errors.New("key " + keyID + " is not the client's current ID token key")
+	}
+	return key, nil
+}
+
+// debugSubjects looks up the user, client and tenant of a token
+func (s *OAuthService) debugSubjects(info *TokenDebugInfo, userID, clientID, tenantID string) {
+	if tenantID != "" {
+		info.Tenant = &TokenDebugSubject{ID: tenantID}
+		if tenant, err := NewTenantService(s.db).GetTenantByID(tenantID); err == nil {
+			info.Tenant.Name, info.Tenant.Found, info.Tenant.Active = tenant.Name, true, tenant.Active
+		} else {
+			info.Problems = append(info.Problems, "the tenant doesn't exist or is deactivated")
+		}
+	}
+
+	if clientID != "" {
+		info.Client = &TokenDebugSubject{ID: clientID}
+		if client, err := NewClientService(s.db).GetClientByClientID(clientID, tenantID); err == nil {
+			info.Client.Name, info.Client.Found, info.Client.Active = client.Name, true, client.Active
+			if !client.Active {
+				info.Problems = append(info.Problems, "the client is deactivated")
+			}
+		} else if !IsSystemClient(clientID) {
+			info.Problems = append(info.Problems, "the client doesn't exist in the tenant")
+		}
+	}
+
+	if userID != "" {
+		info.User = &TokenDebugSubject{ID: userID}
+		if user, err := NewUserService(s.db).GetUserByIDAndTenant(userID, tenantID); err == nil {
+			info.User.Name, info.User.Found, info.User.Active = user.Email, true, user.Active
+			if !user.Active {
+				info.Problems = append(info.Problems, "the user is deactivated")
+			}
+		} else {
+			info.Problems = append(info.Problems, "the user doesn't exist in the tenant")
+		}
+	}
+}
+
+func recordStatus(revoked bool, expiresAt, createdAt time.Time, rotatedAt *time.Time) *TokenRecordStatus {
+	return &TokenRecordStatus{
+		Found:     true,
+		Revoked:   revoked,
+		Expired:   time.Now().After(expiresAt),
+		ExpiresAt: &expiresAt,
+		CreatedAt: &createdAt,
+		RotatedAt: rotatedAt,
+	}
+}
+
+// claimString returns the first of the claims that is a non-empty string
+func claimString(claims jwt.MapClaims, names ...string) string {
+	for _, name := range names {
+		if value, ok := claims[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// claimClientID returns the client of an access token (client_id) or ID token (aud)
+func claimClientID(claims jwt.MapClaims) string {
+	if clientID := claimString(claims, "client_id"); clientID != "" {
+		return clientID
+	}
+	if audience, err := claims.GetAudience(); err == nil && len(audience) > 0 {
+		return audience[0]
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestClaimClientID(t *testing.T) {
+	if got := claimClientID(jwt.MapClaims{"client_id": "spa", "aud": "other"}); got != "spa" {
+		t.Errorf("claimClientID() of an access token = %q, want spa", got)
+	}
+	if got := claimClientID(jwt.MapClaims{"aud": []interface{}{"spa"}}); got != "spa" {
+		t.Errorf("claimClientID() of an ID token = %q, want spa", got)
+	}
+	if got := claimClientID(jwt.MapClaims{}); got != "" {
+		t.Errorf("claimClientID() without a client = %q, want empty", got)
+	}
+}
+
+// TestDebugToken explains the tokens of a code exchange, a tampered token and a revoked refresh
+// token, and hides them from other tenants
+func TestDebugToken(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, Email: "jane@example.com", Scopes: []string{"openid", "read"}, Active: true, CreatedAt: now, UpdatedAt: now})
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), ClientID: "app", ClientSecret: "s3cret", Name: "App", RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+	code, err := oauthService.CreateAuthorizationCode("app", userID.Hex(), "", "https://app.example.com/cb", []string{"openid", "read"}, "", "", CodeBinding{}, "")
+	if err != nil {
+		t.Fatalf("CreateAuthorizationCode() error = %v", err)
+	}
+	req := httptest.NewRequest("POST", "/oauth/token", nil)
+	tokens, err := oauthService.ExchangeCodeForTokens(code, "app", "s3cret", "https://app.example.com/cb", "", req)
+	if err != nil {
+		t.Fatalf("ExchangeCodeForTokens() error = %v", err)
+	}
+
+	info, err := oauthService.DebugToken(tokens.AccessToken, "", false)
+	if err != nil {
+		t.Fatalf("DebugToken(access token) error = %v", err)
+	}
+	if info.Kind != TokenKindAccess || info.Signature != "valid" || info.Record == nil || !info.Record.Found || len(info.Problems) != 0 {
+		t.Errorf("DebugToken(access token) = %+v, want a valid access token without problems", info)
+	}
+	if info.User == nil || info.User.Name != "jane@example.com" || info.Client == nil || info.Client.Name != "App" {
+		t.Errorf("DebugToken(access token) user/client = %+v/%+v", info.User, info.Client)
+	}
+
+	if _, err := oauthService.DebugToken(tokens.AccessToken, primitive.NewObjectID().Hex(), false); !errors.Is(err, ErrDebugTokenNotFound) {
+		t.Errorf("DebugToken() from another tenant error = %v, want ErrDebugTokenNotFound", err)
+	}
+	if _, err := oauthService.DebugToken(tokens.AccessToken, primitive.NewObjectID().Hex(), true); err != nil {
+		t.Errorf("DebugToken() across tenants error = %v, want nil", err)
+	}
+
+	tampered := tokens.AccessToken[:len(tokens.AccessToken)-4] + "AAAA"
+	if info, err := oauthService.DebugToken(tampered, "", false); err != nil || info.Signature != "invalid" {
+		t.Errorf("DebugToken(tampered) = %+v, %v; want an invalid signature", info, err)
+	}
+
+	if info, err := oauthService.DebugToken(tokens.IDToken, "", false); err != nil || info.Kind != TokenKindID {
+		t.Errorf("DebugToken(ID token) = %+v, %v; want an ID token", info, err)
+	}
+
+	if _, err := oauthService.RefreshTokens(tokens.RefreshToken, "app", "s3cret", "", "", req); err != nil {
+		t.Fatalf("RefreshTokens() error = %v", err)
+	}
+	info, err = oauthService.DebugToken(tokens.RefreshToken, "", false)
+	if err != nil {
+		t.Fatalf("DebugToken(refresh token) error = %v", err)
+	}
+	if info.Kind != TokenKindRefresh || !info.Record.Revoked || len(info.Problems) == 0 {
+		t.Errorf("DebugToken(rotated refresh token) = %+v, want a revoked refresh token", info)
+	}
+
+	if _, err := oauthService.DebugToken("not-a-token", "", false); !errors.Is(err, ErrDebugTokenNotFound) {
+		t.Errorf("DebugToken(unknown) error = %v, want ErrDebugTokenNotFound", err)
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultVendedTokenLifetime is the lifetime of vended tokens that don't ask for one
+	DefaultVendedTokenLifetime = 15 * time.Minute
+	// MaxVendedTokenLifetime caps vended tokens; they never outlive their parent either
+	MaxVendedTokenLifetime = 12 * time.Hour
+	// maxVendingDepth limits how many times a token can be narrowed in a row
+	maxVendingDepth = 5
+)
+
+var (
+	ErrParentTokenInvalid   = errors.New("the parent token is revoked or expired")
+	ErrScopeNotHeld         = errors.New("the parent token does not hold all requested scopes")
+	ErrAudienceNotHeld      = errors.New("the parent token is not valid for all requested audiences")
+	ErrVendingDepthExceeded = errors.New("the parent token was narrowed too many times")
+	ErrVendedTokenNotFound  = errors.New("vended token not found")
+)
+
+// VendTokenRequest describes a narrowed child token
+type VendTokenRequest struct {
+	Scopes   []string      // a subset of the parent's scopes
+	Audience []string      // resource servers the token is for; a subset of the parent's, if it has one
+	Lifetime time.Duration // DefaultVendedTokenLifetime when 0
+	Purpose  string
+}
+
+// VendedToken is a narrowed, short-lived access token minted from another token. Like elevated
+// tokens, it has no refresh token.
+type VendedToken struct {
+	ID          string    `json:"id"`
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int       `json:"expires_in"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Scope       string    `json:"scope"`
+	Audience    []string  `json:"audience,omitempty"`
+	ParentID    string    `json:"parent_id"`
+}
+
+// VendToken mints a child of parentToken carrying a subset of its scopes, valid for at most the
+// parent's remaining lifetime. The child records its lineage, so revoking the parent or any other
+// ancestor revokes it too. The elevated scope is never vended.
+func (s *OAuthService) VendToken(parentToken string, req VendTokenRequest, r *http.Request) (*VendedToken, error) {
+	parent, err := s.activeTokenRecord(parentToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Scopes) == 0 || containsString(req.Scopes, ElevatedScope) {
+		return nil, ErrScopeNotHeld
+	}
+	for _, scope := range req.Scopes {
+		if !containsString(parent.Scopes, scope) {
+			return nil, ErrScopeNotHeld
+		}
+	}
+	audience := req.Audience
+	if len(parent.Audience) > 0 {
+		if len(audience) == 0 {
+			audience = parent.Audience
+		}
+		for _, aud := range audience {
+			if !containsString(parent.Audience, aud) {
+				return nil, ErrAudienceNotHeld
+			}
+		}
+	}
+	if len(parent.Ancestors) >= maxVendingDepth {
+		return nil, ErrVendingDepthExceeded
+	}
+
+	lifetime := req.Lifetime
+	if lifetime <= 0 {
+		lifetime = DefaultVendedTokenLifetime
+	}
+	if lifetime > MaxVendedTokenLifetime {
+		lifetime = MaxVendedTokenLifetime
+	}
+	if remaining := time.Until(parent.ExpiresAt); lifetime > remaining {
+		lifetime = remaining
+	}
+
+	child := &models.AccessToken{
+		Audience:  audience,
+		ParentID:  &parent.ID,
+		Ancestors: append(append([]primitive.ObjectID{}, parent.Ancestors...), parent.ID),
+		Purpose:   req.Purpose,
+	}
+	accessToken, record, err := s.signTokenRecord(parent.UserID, parent.TenantID, parent.ClientID, s.getBaseURL(r), req.Scopes, lifetime, child)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VendedToken{
+		ID:          record.ID.Hex(),
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(lifetime.Seconds()),
+		ExpiresAt:   record.ExpiresAt,
+		Scope:       s.joinScopes(req.Scopes),
+		Audience:    audience,
+		ParentID:    parent.ID.Hex(),
+	}, nil
+}
+
+// ListVendedTokens returns the descendants of a token, newest first
+func (s *OAuthService) ListVendedTokens(parentToken string) ([]*models.AccessToken, error) {
+	parent, err := s.activeTokenRecord(parentToken)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(500).SetProjection(bson.M{"token": 0}).
+		SetHint(s.db.Hint("access_tokens", AccessTokenLineageIndex))
+	cursor, err := s.tokenCollection.Find(ctx, bson.M{"ancestors": parent.ID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []*models.AccessToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeVendedToken revokes a descendant of parentToken along with its own descendants
+func (s *OAuthService) RevokeVendedToken(parentToken, id string) error {
+	parent, err := s.activeTokenRecord(parentToken)
+	if err != nil {
+		return err
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrVendedTokenNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.tokenCollection.UpdateOne(ctx, bson.M{"_id": objectID, "ancestors": parent.ID}, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVendedTokenNotFound
+	}
+	// Descendants are rejected through their lineage anyway; marking them keeps listings accurate
+	_, err = s.tokenCollection.UpdateMany(ctx, bson.M{"ancestors": objectID, "revoked": false}, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+// activeTokenRecord returns the stored record of an unrevoked, unexpired access token
+func (s *OAuthService) activeTokenRecord(tokenString string) (*models.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var record models.AccessToken
+	err := s.tokenCollection.FindOne(ctx, bson.M{"token": tokenString, "revoked": false}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrParentTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(record.ExpiresAt) || s.ancestorRevoked(ctx, record.Ancestors) {
+		return nil, ErrParentTokenInvalid
+	}
+	return &record, nil
+}
+
+// ancestorRevoked reports whether any of a vended token's ancestors was revoked. Ancestors that
+// can't be checked count as revoked.
+func (s *OAuthService) ancestorRevoked(ctx context.Context, ancestors []primitive.ObjectID) bool {
+	if len(ancestors) == 0 {
+		return false
+	}
+	count, err := s.tokenReads.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": ancestors}, "revoked": false})
+	return err != nil || count < int64(len(ancestors))
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestVendToken narrows a token twice and checks that revoking the root revokes the whole lineage
+func TestVendToken(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users", &models.User{ID: userID, Email: "batch@example.com", Active: true, CreatedAt: now, UpdatedAt: now})
+
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+	req := httptest.NewRequest("POST", "/api/v1/tokens/vend", nil)
+
+	root, err := oauthService.signAccessToken(userID.Hex(), "t1", "service", "", []string{"read", "write", "reports"}, time.Hour)
+	if err != nil {
+		t.Fatalf("signAccessToken() error = %v", err)
+	}
+
+	if _, err := oauthService.VendToken(root, VendTokenRequest{Scopes: []string{"admin"}}, req); !errors.Is(err, ErrScopeNotHeld) {
+		t.Errorf("VendToken() with a scope the parent lacks error = %v, want ErrScopeNotHeld", err)
+	}
+
+	child, err := oauthService.VendToken(root, VendTokenRequest{Scopes: []string{"read", "reports"}, Audience: []string{"reports-api"}, Lifetime: 2 * time.Hour}, req)
+	if err != nil {
+		t.Fatalf("VendToken() error = %v", err)
+	}
+	if child.ExpiresIn > int(time.Hour.Seconds()) {
+		t.Errorf("VendToken() expires_in = %d, want at most the parent's remaining lifetime", child.ExpiresIn)
+	}
+	claims, err := oauthService.ValidateAccessToken(child.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken(child) error = %v", err)
+	}
+	if len(claims.Scopes) != 2 || len(claims.Audience) != 1 || claims.Audience[0] != "reports-api" {
+		t.Errorf("child claims = %v %v, want the narrowed scopes and audience", claims.Scopes, claims.Audience)
+	}
+
+	if _, err := oauthService.VendToken(child.AccessToken, VendTokenRequest{Scopes: []string{"read"}, Audience: []string{"other-api"}}, req); !errors.Is(err, ErrAudienceNotHeld) {
+		t.Errorf("VendToken() widening the audience error = %v, want ErrAudienceNotHeld", err)
+	}
+	grandchild, err := oauthService.VendToken(child.AccessToken, VendTokenRequest{Scopes: []string{"read"}}, req)
+	if err != nil {
+		t.Fatalf("VendToken() of a grandchild error = %v", err)
+	}
+
+	descendants, err := oauthService.ListVendedTokens(root)
+	if err != nil || len(descendants) != 2 {
+		t.Fatalf("ListVendedTokens() = %d tokens, %v, want 2", len(descendants), err)
+	}
+	if descendants[0].Token != "" {
+		t.Error("ListVendedTokens() should not return the tokens themselves")
+	}
+
+	// Revoking the root through any path revokes every descendant
+	if _, err := db.GetCollection("access_tokens").UpdateOne(context.Background(), bson.M{"token": root}, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		t.Fatalf("Failed to revoke the root token: %v", err)
+	}
+	for name, token := range map[string]string{"child": child.AccessToken, "grandchild": grandchild.AccessToken} {
+		if _, err := oauthService.ValidateAccessToken(token); err == nil {
+			t.Errorf("ValidateAccessToken(%s) after revoking the root succeeded, want an error", name)
+		}
+	}
+}
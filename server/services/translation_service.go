@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/i18n"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TranslationService struct {
+	db            *database.MongoDB
+	collection    *mongo.Collection
+	tenantService *TenantService
+}
+
+func NewTranslationService(db *database.MongoDB, tenantService *TenantService) *TranslationService {
+	return &TranslationService{
+		db:            db,
+		collection:    db.GetCollection("tenant_translations"),
+		tenantService: tenantService,
+	}
+}
+
+// GetOverrides returns the tenant's translation overrides for a locale as a key/value map
+func (s *TranslationService) GetOverrides(tenantID, locale string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	overrides := make(map[string]string)
+
+	var translations models.TenantTranslations
+	err := s.collection.FindOne(ctx, bson.M{"tenant_id": tenantID, "locale": locale}).Decode(&translations)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return overrides, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range translations.Entries {
+		overrides[entry.Key] = entry.Value
+	}
+
+	return overrides, nil
+}
+
+// GetAllOverrides returns the tenant's translation overrides for every locale
+func (s *TranslationService) GetAllOverrides(tenantID string) ([]*models.TenantTranslations, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{"tenant_id": tenantID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var translations []*models.TenantTranslations
+	if err = cursor.All(ctx, &translations); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// SetOverrides merges the given messages into the tenant's overrides for a locale
+func (s *TranslationService) SetOverrides(tenantID, locale string, messages map[string]string) error {
+	if !i18n.IsSupported(locale) {
+		return errors.New("unsupported locale: " + locale)
+	}
+
+	for key := range messages {
+		if !i18n.HasKey(key) {
+			return errors.New("unknown message key: " + key)
+		}
+	}
+
+	overrides, err := s.GetOverrides(tenantID, locale)
+	if err != nil {
+		return err
+	}
+	for key, value := range messages {
+		overrides[key] = value
+	}
+
+	return s.saveOverrides(tenantID, locale, overrides)
+}
+
+// DeleteOverride removes a single overridden message, restoring the built-in translation
+func (s *TranslationService) DeleteOverride(tenantID, locale, key string) error {
+	overrides, err := s.GetOverrides(tenantID, locale)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := overrides[key]; !ok {
+		return errors.New("translation override not found")
+	}
+	delete(overrides, key)
+
+	return s.saveOverrides(tenantID, locale, overrides)
+}
+
+func (s *TranslationService) saveOverrides(tenantID, locale string, overrides map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entries := make([]models.TranslationEntry, 0, len(overrides))
+	for key, value := range overrides {
+		entries = append(entries, models.TranslationEntry{Key: key, Value: value})
+	}
+
+	now := time.Now()
+	_, err := s.collection.UpdateOne(ctx, bson.M{
+		"tenant_id": tenantID,
+		"locale":    locale,
+	}, bson.M{
+		"$set": bson.M{
+			"entries":    entries,
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}, options.Update().SetUpsert(true))
+
+	return err
+}
+
+// GetTenantDefaultLocale returns the tenant's configured default locale, if any
+func (s *TranslationService) GetTenantDefaultLocale(tenantID string) string {
+	var tenant *models.Tenant
+	var err error
+	if tenantID != "" {
+		tenant, err = s.tenantService.GetTenantByID(tenantID)
+	} else {
+		tenant, err = s.tenantService.GetDefaultTenant()
+	}
+	if err != nil {
+		return ""
+	}
+	return tenant.Settings.DefaultLocale
+}
+
+// LocalizerForRequest negotiates the locale for a request (ui_locales, Accept-Language, tenant
+// default) and returns a localizer that applies the tenant's overrides
+func (s *TranslationService) LocalizerForRequest(r *http.Request, tenantID string) *i18n.Localizer {
+	locale := i18n.Negotiate(r.URL.Query().Get("ui_locales"), r.Header.Get("Accept-Language"), s.GetTenantDefaultLocale(tenantID))
+
+	overrides, err := s.GetOverrides(tenantID, locale)
+	if err != nil {
+		overrides = nil
+	}
+
+	return i18n.NewLocalizer(locale, overrides)
+}
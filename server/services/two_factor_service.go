@@ -26,7 +26,10 @@ type TwoFactorService struct {
 	db                    *database.MongoDB
 	userCollection        *mongo.Collection
 	twoFactorCollection   *mongo.Collection
-	sessionExpiry         time.Duration
+	lifetimes             Lifetimes
+	metering              *MeteringService
+	smsCodes              *mongo.Collection
+	tenantService         *TenantService
 }
 
 type SetupTwoFactorResponse struct {
@@ -41,12 +44,15 @@ type VerifyTwoFactorRequest struct {
 	Code   string `json:"code"`
 }
 
-func NewTwoFactorService(db *database.MongoDB) *TwoFactorService {
+func NewTwoFactorService(db *database.MongoDB, lifetimes Lifetimes) *TwoFactorService {
 	return &TwoFactorService{
 		db:                  db,
 		userCollection:      db.GetCollection("users"),
 		twoFactorCollection: db.GetCollection("two_factor_sessions"),
-		sessionExpiry:       time.Minute * 10,
+		lifetimes:           lifetimes,
+		metering:            NewMeteringService(db),
+		smsCodes:            db.GetCollection("sms_codes"),
+		tenantService:       NewTenantService(db),
 	}
 }
 
@@ -167,20 +173,85 @@ func (s *TwoFactorService) VerifyTwoFactor(userID, code string) (bool, error) {
 		return false, errors.New("user not found")
 	}
 
-	if !user.TwoFactorEnabled {
+	smsActive := s.smsFactorActive(&user)
+	if !user.TwoFactorEnabled && !smsActive {
 		return false, errors.New("two-factor authentication not enabled")
 	}
 
-	if s.isBackupCode(code, user.BackupCodes) {
+	if user.TwoFactorEnabled && s.isBackupCode(code, user.BackupCodes) {
 		err = s.removeBackupCode(userID, code)
 		if err != nil {
 			return false, err
 		}
+		s.metering.RecordMFAVerification(user.TenantID, userID, "backup_code")
 		return true, nil
 	}
 
-	valid := totp.Validate(code, user.TwoFactorSecret)
-	return valid, nil
+	if user.TwoFactorEnabled && totp.Validate(code, user.TwoFactorSecret) {
+		s.metering.RecordMFAVerification(user.TenantID, userID, "totp")
+		return true, nil
+	}
+
+	if smsActive {
+		err := verifySMSCode(ctx, s.smsCodes, userID, models.SMSCodePurposeTwoFactor, user.Phone, code)
+		if err == nil {
+			s.metering.RecordMFAVerification(user.TenantID, userID, "sms")
+			return true, nil
+		}
+		if err != ErrInvalidSMSCode {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// sessionLifetime returns how long a two-factor session of the user's tenant stays valid
+func (s *TwoFactorService) sessionLifetime(userID string) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if objectID, err := primitive.ObjectIDFromHex(userID); err == nil {
+		s.userCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	}
+
+	var policy models.FlowLifetimes
+	if tenant, err := s.tenantService.GetTenantByID(user.TenantID); err == nil {
+		policy = tenant.Settings.Lifetimes
+	}
+	return s.lifetimes.ForTenant(policy).TwoFactorSession
+}
+
+// smsFactorActive reports whether SMS codes count as the user's second factor
+func (s *TwoFactorService) smsFactorActive(user *models.User) bool {
+	return user.SMSTwoFactor && user.PhoneVerified && smsTwoFactorAllowed(s.tenantService, user.TenantID)
+}
+
+// TwoFactorMethods lists the second factors the user can sign in with: "totp" and/or "sms"
+func (s *TwoFactorService) TwoFactorMethods(userID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	var user models.User
+	err = s.userCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	methods := []string{}
+	if user.TwoFactorEnabled {
+		methods = append(methods, "totp")
+	}
+	if s.smsFactorActive(&user) {
+		methods = append(methods, "sms")
+	}
+	return methods, nil
 }
 
 func (s *TwoFactorService) CreateTwoFactorSession(userID, clientID string) (string, error) {
@@ -194,7 +265,7 @@ func (s *TwoFactorService) CreateTwoFactorSession(userID, clientID string) (stri
 		ClientID:  clientID,
 		SessionID: sessionID,
 		Verified:  false,
-		ExpiresAt: time.Now().Add(s.sessionExpiry),
+		ExpiresAt: time.Now().Add(s.sessionLifetime(userID)),
 		CreatedAt: time.Now(),
 	}
 
@@ -283,7 +354,7 @@ func (s *TwoFactorService) IsTwoFactorRequired(userID string) (bool, error) {
 		return false, errors.New("user not found")
 	}
 
-	return user.TwoFactorEnabled, nil
+	return user.TwoFactorEnabled || s.smsFactorActive(&user), nil
 }
 
 func (s *TwoFactorService) HasBackupCodes(userID string) (bool, error) {
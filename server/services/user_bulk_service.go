@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Bulk user operations
+const (
+	BulkUserActivate           = "activate"
+	BulkUserDeactivate         = "deactivate"
+	BulkUserAddGroup           = "add_group"
+	BulkUserRemoveGroup        = "remove_group"
+	BulkUserAddScope           = "add_scope"
+	BulkUserRemoveScope        = "remove_scope"
+	BulkUserForcePasswordReset = "force_password_reset"
+)
+
+// MaxBulkUsers is how many users one bulk operation may change
+const MaxBulkUsers = 500
+
+// Outcomes of a bulk operation for a single user
+const (
+	BulkUserUpdated   = "updated"
+	BulkUserUnchanged = "unchanged" // the user already was in the requested state
+	BulkUserFailed    = "failed"
+)
+
+var (
+	ErrInvalidBulkOperation = errors.New("unknown bulk operation")
+	ErrBulkGroupRequired    = errors.New("the operation needs a group")
+	ErrBulkScopeRequired    = errors.New("the operation needs a scope")
+)
+
+// BulkUserOperation is a change applied to many users at once. Group may be a group name or ID.
+type BulkUserOperation struct {
+	Operation string
+	Group     string
+	Scope     string
+	Reason    string // recorded with activations and deactivations
+}
+
+// BulkUserResult is the outcome of a bulk operation for one user
+type BulkUserResult struct {
+	UserID string `json:"user_id"`
+	Status string `json:"status"` // see BulkUserUpdated
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkUserReport lists the outcome of a bulk operation for every user, in request order
+type BulkUserReport struct {
+	Operation string           `json:"operation"`
+	Target    string           `json:"target,omitempty"` // group ID or scope the operation concerns
+	Updated   int              `json:"updated"`
+	Unchanged int              `json:"unchanged"`
+	Failed    int              `json:"failed"`
+	Results   []BulkUserResult `json:"results"`
+}
+
+// UserBulkService applies one change to many users of a tenant. Each user is changed on its own,
+// with the same single-document updates as the individual endpoints, so a failure for one user
+// neither rolls back nor stops the others.
+type UserBulkService struct {
+	users       *mongo.Collection
+	lifecycle   *UserLifecycleService
+	membership  *MembershipService
+	accountLink *AccountLinkService
+	sessions    *SessionService
+}
+
+func NewUserBulkService(db *database.MongoDB, lifecycle *UserLifecycleService, accountLink *AccountLinkService, sessions *SessionService) *UserBulkService {
+	return &UserBulkService{
+		users:       db.GetCollection("users"),
+		lifecycle:   lifecycle,
+		membership:  NewMembershipService(db),
+		accountLink: accountLink,
+		sessions:    sessions,
+	}
+}
+
+// Apply runs the operation for each of the users. actorID is the admin making the change. Errors
+// concerning the operation itself, e.g. an unknown group, are returned before any user is changed;
+// errors for single users are reported in their results.
+func (s *UserBulkService) Apply(tenantID, actorID string, op BulkUserOperation, userIDs []string, r *http.Request) (*BulkUserReport, error) {
+	report := &BulkUserReport{Operation: op.Operation, Results: make([]BulkUserResult, 0, len(userIDs))}
+
+	switch op.Operation {
+	case BulkUserActivate, BulkUserDeactivate, BulkUserForcePasswordReset:
+	case BulkUserAddGroup, BulkUserRemoveGroup:
+		if op.Group == "" {
+			return nil, ErrBulkGroupRequired
+		}
+		groupIDs, err := s.membership.ResolveGroupIDs(tenantID, []string{op.Group})
+		if err != nil {
+			return nil, err
+		}
+		report.Target = groupIDs[0]
+	case BulkUserAddScope, BulkUserRemoveScope:
+		if op.Scope == "" {
+			return nil, ErrBulkScopeRequired
+		}
+		report.Target = op.Scope
+	default:
+		return nil, ErrInvalidBulkOperation
+	}
+
+	seen := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		result := BulkUserResult{UserID: userID, Status: BulkUserUpdated}
+		changed, err := s.applyOne(tenantID, actorID, op, report.Target, userID, r)
+		switch {
+		case err != nil:
+			result.Status, result.Error = BulkUserFailed, err.Error()
+			report.Failed++
+		case !changed:
+			result.Status = BulkUserUnchanged
+			report.Unchanged++
+		default:
+			report.Updated++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// applyOne changes a single user and reports whether anything changed
+func (s *UserBulkService) applyOne(tenantID, actorID string, op BulkUserOperation, target, userID string, r *http.Request) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, ErrLifecycleUserNotFound
+	}
+	filter := bson.M{"_id": objID, "tenant_id": tenantID}
+
+	var user models.User
+	if err := s.users.FindOne(ctx, filter).Decode(&user); err == mongo.ErrNoDocuments {
+		return false, ErrLifecycleUserNotFound
+	} else if err != nil {
+		return false, err
+	}
+
+	switch op.Operation {
+	case BulkUserActivate, BulkUserDeactivate:
+		to := models.UserStatusActive
+		if op.Operation == BulkUserDeactivate {
+			to = models.UserStatusSuspended
+		}
+		if user.LifecycleStatus() == to {
+			return false, nil
+		}
+		_, err := s.lifecycle.Transition(tenantID, userID, to, op.Reason, actorID, r)
+		return err == nil, err
+
+	case BulkUserAddGroup:
+		filter["groups"] = bson.M{"$ne": target}
+		return s.updateUser(ctx, filter, bson.M{"$addToSet": bson.M{"groups": target}}, true)
+
+	case BulkUserRemoveGroup:
+		filter["groups"] = target
+		return s.updateUser(ctx, filter, bson.M{"$pull": bson.M{"groups": target}}, true)
+
+	case BulkUserAddScope:
+		filter["scopes"] = bson.M{"$ne": target}
+		return s.updateUser(ctx, filter, bson.M{"$addToSet": bson.M{"scopes": target}}, false)
+
+	case BulkUserRemoveScope:
+		filter["scopes"] = target
+		return s.updateUser(ctx, filter, bson.M{"$pull": bson.M{"scopes": target}}, false)
+
+	case BulkUserForcePasswordReset:
+		if _, err := s.accountLink.RequirePasswordReset(userID, tenantID); err != nil {
+			return false, err
+		}
+		// Sessions signed in with the old password end as well
+		return true, s.sessions.RevokeAllUserSessions(userID, tenantID)
+	}
+	return false, ErrInvalidBulkOperation
+}
+
+// updateUser applies update to the user if it matches filter, which excludes users that already
+// have the change. Group changes are synced to the group member lists.
+func (s *UserBulkService) updateUser(ctx context.Context, filter, update bson.M, syncGroups bool) (bool, error) {
+	update["$set"] = bson.M{"updated_at": time.Now()}
+	update["$inc"] = bson.M{"version": 1}
+
+	var user models.User
+	err := s.users.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if syncGroups {
+		return true, s.membership.SyncUser(&user)
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestUserBulkOperations applies operations to several users and checks the per-user report
+func TestUserBulkOperations(t *testing.T) {
+	db := dbtest.New(t)
+
+	janeID := primitive.NewObjectID()
+	johnID := primitive.NewObjectID()
+	goneID := primitive.NewObjectID()
+	groupID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users",
+		&models.User{ID: janeID, TenantID: "t1", Email: "jane@example.com", PasswordHash: "hash", Status: models.UserStatusActive, Active: true, Scopes: []string{"read"}, CreatedAt: now, UpdatedAt: now},
+		&models.User{ID: johnID, TenantID: "t1", Email: "john@example.com", PasswordHash: "hash", Status: models.UserStatusSuspended, Scopes: []string{}, CreatedAt: now, UpdatedAt: now},
+		&models.User{ID: goneID, TenantID: "t1", Email: "gone@example.com", Status: models.UserStatusDeprovisioned, CreatedAt: now, UpdatedAt: now},
+	)
+	dbtest.Insert(t, db, "groups", &models.Group{ID: groupID, TenantID: "t1", Name: "engineering", Members: []string{}, CreatedAt: now, UpdatedAt: now})
+
+	notifier := &recordingNotifier{}
+	sessions := NewSessionService(db)
+	lifecycle := NewUserLifecycleService(db, NewUserDeactivationService(db, sessions, NewAuditService(db), notifier))
+	service := NewUserBulkService(db, lifecycle, NewAccountLinkService(db, notifier, "https://example.com", nil), sessions)
+	req := httptest.NewRequest("POST", "/api/v1/users/bulk", nil)
+	users := []string{janeID.Hex(), johnID.Hex(), goneID.Hex(), primitive.NewObjectID().Hex()}
+
+	report, err := service.Apply("t1", "admin", BulkUserOperation{Operation: BulkUserActivate}, users, req)
+	if err != nil {
+		t.Fatalf("Apply(activate) error = %v", err)
+	}
+	want := []string{BulkUserUnchanged, BulkUserUpdated, BulkUserFailed, BulkUserFailed}
+	for i, result := range report.Results {
+		if result.Status != want[i] {
+			t.Errorf("activate result %d = %+v, want %s", i, result, want[i])
+		}
+	}
+	if report.Updated != 1 || report.Unchanged != 1 || report.Failed != 2 {
+		t.Errorf("activate report = %d updated, %d unchanged, %d failed", report.Updated, report.Unchanged, report.Failed)
+	}
+
+	report, err = service.Apply("t1", "admin", BulkUserOperation{Operation: BulkUserAddGroup, Group: "engineering"}, users[:2], req)
+	if err != nil || report.Updated != 2 || report.Target != groupID.Hex() {
+		t.Fatalf("Apply(add_group) = %+v, %v", report, err)
+	}
+	group, err := NewGroupService(db).GetGroupByID(groupID.Hex(), "t1")
+	if err != nil || len(group.Members) != 2 {
+		t.Errorf("group members after add_group = %v, %v, want both users", group, err)
+	}
+
+	report, err = service.Apply("t1", "admin", BulkUserOperation{Operation: BulkUserAddScope, Scope: "read"}, users[:2], req)
+	if err != nil || report.Updated != 1 || report.Unchanged != 1 {
+		t.Errorf("Apply(add_scope) = %+v, %v, want only john updated", report, err)
+	}
+
+	report, err = service.Apply("t1", "admin", BulkUserOperation{Operation: BulkUserForcePasswordReset}, users[:1], req)
+	if err != nil || report.Updated != 1 {
+		t.Fatalf("Apply(force_password_reset) = %+v, %v", report, err)
+	}
+	jane, err := NewUserService(db).GetUserByIDAndTenant(janeID.Hex(), "t1")
+	if err != nil || jane.PasswordHash != "" {
+		t.Errorf("password after force_password_reset = %v, %v, want none", jane, err)
+	}
+
+	if _, err := service.Apply("t1", "admin", BulkUserOperation{Operation: BulkUserAddGroup, Group: "unknown"}, users, req); err == nil {
+		t.Error("Apply() with an unknown group succeeded")
+	}
+	if _, err := service.Apply("t1", "admin", BulkUserOperation{Operation: BulkUserRemoveScope}, users, req); !errors.Is(err, ErrBulkScopeRequired) {
+		t.Errorf("Apply() without a scope error = %v, want ErrBulkScopeRequired", err)
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrUserDeactivated is returned when tokens are requested for a deactivated or deleted user
+var ErrUserDeactivated = errors.New("user is deactivated")
+
+// Notification types sent when a user loses or regains access
+const (
+	NotificationUserDeactivated   = "user.deactivated"
+	NotificationUserDeleted       = "user.deleted"
+	NotificationUserDeprovisioned = "user.deprovisioned"
+	NotificationUserReactivated   = "user.reactivated"
+)
+
+// UserDeactivationService cuts off a user's access when the account is deactivated or deleted.
+// Deactivation keeps the account so it can be reactivated; deletion removes it for good. Both
+// revoke every access and refresh token (and with them the user's sessions) and unused
+// authorization codes immediately instead of letting them run until expiry.
+type UserDeactivationService struct {
+	db             *database.MongoDB
+	codeCollection *mongo.Collection
+	sessions       *SessionService
+	audit          *AuditService
+	notifier       Notifier
+}
+
+func NewUserDeactivationService(db *database.MongoDB, sessions *SessionService, audit *AuditService, notifier Notifier) *UserDeactivationService {
+	return &UserDeactivationService{
+		db:             db,
+		codeCollection: db.GetCollection("authorization_codes"),
+		sessions:       sessions,
+		audit:          audit,
+		notifier:       notifier,
+	}
+}
+
+// Deactivated revokes the access of a user that was just deactivated (suspended) and announces it
+func (s *UserDeactivationService) Deactivated(user *models.User, reason string, r *http.Request) error {
+	return s.cutOff(user, models.AuditEventUserDeactivated, NotificationUserDeactivated, "was deactivated", reason, r)
+}
+
+// Deprovisioned revokes the access of a user that was just deprovisioned and announces it
+func (s *UserDeactivationService) Deprovisioned(user *models.User, reason string, r *http.Request) error {
+	return s.cutOff(user, models.AuditEventUserDeprovisioned, NotificationUserDeprovisioned, "was deprovisioned", reason, r)
+}
+
+// Deleted revokes the access of a user that was just deleted and announces it
+func (s *UserDeactivationService) Deleted(user *models.User, r *http.Request) error {
+	return s.cutOff(user, models.AuditEventUserDeleted, NotificationUserDeleted, "was deleted", "", r)
+}
+
+// Reactivated announces that a user may sign in again
+func (s *UserDeactivationService) Reactivated(user *models.User, reason string, r *http.Request) {
+	subject := "User " + user.Email + " was reactivated"
+	s.announce(user, models.AuditEventUserReactivated, NotificationUserReactivated, subject, subject+".", reason, r)
+}
+
+func (s *UserDeactivationService) cutOff(user *models.User, auditType, notificationType, what, reason string, r *http.Request) error {
+	userID := user.ID.Hex()
+
+	if err := s.sessions.RevokeAllUserSessions(userID, user.TenantID); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.codeCollection.UpdateMany(ctx, bson.M{"user_id": userID, "tenant_id": user.TenantID, "used": false}, bson.M{
+		"$set": bson.M{"used": true},
+	}); err != nil {
+		return err
+	}
+
+	subject := "User " + user.Email + " " + what
+	s.announce(user, auditType, notificationType, subject, subject+". All of their tokens and sessions have been revoked.", reason, r)
+	return nil
+}
+
+// announce records the change of a user's access in the audit log and notifies about it
+func (s *UserDeactivationService) announce(user *models.User, auditType, notificationType, subject, message, reason string, r *http.Request) {
+	userID := user.ID.Hex()
+	s.audit.RecordRequest(r, &models.AuditEvent{
+		TenantID: user.TenantID,
+		Type:     auditType,
+		UserID:   userID,
+		Email:    user.Email,
+		Reason:   reason,
+	})
+
+	data := map[string]interface{}{
+		"user_id": userID,
+		"email":   user.Email,
+	}
+	if reason != "" {
+		data["reason"] = reason
+	}
+	notification := &Notification{
+		Type:      notificationType,
+		TenantID:  user.TenantID,
+		Subject:   subject,
+		Message:   message,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+	if err := s.notifier.Notify(notification); err != nil {
+		log.Printf("Warning: Failed to send %s notification for user %s: %v", notificationType, userID, err)
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestDeactivationRevokesTokens checks that a deactivated user's tokens stop working at once and
+// that refresh grants and pending codes are refused.
+func TestDeactivationRevokesTokens(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	now := time.Now()
+	user := &models.User{ID: userID, TenantID: "tenant-1", Email: "jane@example.com", Scopes: []string{"openid", "read"}, Active: true, CreatedAt: now, UpdatedAt: now}
+	dbtest.Insert(t, db, "users", user)
+	dbtest.Insert(t, db, "clients", &models.Client{ID: primitive.NewObjectID(), ClientID: "spa", ClientSecret: "spa-secret", Name: "SPA", RedirectURIs: []string{"https://app.example.com/cb"}, Active: true, CreatedAt: now, UpdatedAt: now})
+
+	oauthService := NewOAuthService(db, "test-secret", DefaultLifetimes())
+	req := httptest.NewRequest("POST", "/oauth/token", nil)
+
+	tokens, err := oauthService.IssueTokens(userID.Hex(), "tenant-1", "spa", []string{"openid", "read"}, ACRSingleFactor, req)
+	if err != nil {
+		t.Fatalf("Failed to issue tokens: %v", err)
+	}
+	code, err := oauthService.CreateAuthorizationCode("spa", userID.Hex(), "tenant-1", "https://app.example.com/cb", []string{"openid"}, "", "", CodeBinding{}, ACRSingleFactor)
+	if err != nil {
+		t.Fatalf("Failed to create authorization code: %v", err)
+	}
+
+	if _, err := db.GetCollection("users").UpdateOne(context.Background(), bson.M{"_id": userID}, bson.M{"$set": bson.M{"active": false}}); err != nil {
+		t.Fatalf("Failed to deactivate user: %v", err)
+	}
+	deactivation := NewUserDeactivationService(db, NewSessionService(db), NewAuditService(db), NewLogNotifier())
+	if err := deactivation.Deactivated(user, "", req); err != nil {
+		t.Fatalf("Deactivated() failed: %v", err)
+	}
+
+	if _, err := oauthService.ValidateAccessToken(tokens.AccessToken); err == nil {
+		t.Error("Expected the access token of a deactivated user to be rejected")
+	}
+	if _, err := oauthService.RefreshTokens(tokens.RefreshToken, "spa", "spa-secret", "", "", req); err == nil {
+		t.Error("Expected the refresh token of a deactivated user to be rejected")
+	}
+
+	var stored models.AuthorizationCode
+	if err := db.GetCollection("authorization_codes").FindOne(context.Background(), bson.M{"code": code}).Decode(&stored); err != nil {
+		t.Fatalf("Failed to load authorization code: %v", err)
+	}
+	if !stored.Used {
+		t.Error("Expected the pending authorization code to be invalidated")
+	}
+
+	// Tokens are refused even if revocation was missed
+	if _, err := oauthService.IssueTokens(userID.Hex(), "tenant-1", "spa", []string{"openid"}, ACRSingleFactor, req); err != ErrUserDeactivated {
+		t.Errorf("Expected ErrUserDeactivated, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Login identifiers a tenant can accept
+const (
+	LoginIdentifierEmail    = "email"
+	LoginIdentifierUsername = "username"
+	LoginIdentifierPhone    = "phone"
+)
+
+// loginIdentifierFields maps login identifiers to the user fields that hold them
+var loginIdentifierFields = map[string]string{
+	LoginIdentifierEmail:    "email",
+	LoginIdentifierUsername: "username",
+	LoginIdentifierPhone:    "phone",
+}
+
+// EnsureIdentifierIndexes creates unique per-tenant indexes for every login identifier. Users
+// without a value for an identifier are not indexed. Each index is created separately so that
+// existing duplicates of one identifier don't prevent the others.
+func (s *UserService) EnsureIdentifierIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var failed []string
+	for _, identifier := range []string{LoginIdentifierEmail, LoginIdentifierUsername, LoginIdentifierPhone} {
+		field := loginIdentifierFields[identifier]
+		_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: field, Value: 1}, {Key: "tenant_id", Value: 1}},
+			Options: options.Index().
+				SetName("unique_login_" + identifier).
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{field: bson.M{"$gt": ""}}),
+		})
+		if err != nil {
+			failed = append(failed, identifier+": "+err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// GetUserByLoginIdentifier finds the user a login identifier belongs to, trying the identifiers
+// the tenant accepts in the configured order
+func (s *UserService) GetUserByLoginIdentifier(value, tenantID string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, filter := range loginIdentifierQueries(s.loginIdentifiers(tenantID), value) {
+		filter["tenant_id"] = tenantID
+
+		var user models.User
+		err := s.collection.FindOne(ctx, filter).Decode(&user)
+		if err == nil {
+			return &user, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("user not found")
+}
+
+// LoginIdentifierInUse reports whether another user of the tenant already has the value for
+// the identifier
+func (s *UserService) LoginIdentifierInUse(identifier, value, tenantID, excludeUserID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if identifier == LoginIdentifierPhone {
+		value = NormalizePhone(value)
+	}
+	if value == "" {
+		return false
+	}
+
+	filter := bson.M{loginIdentifierFields[identifier]: value, "tenant_id": tenantID}
+	if objID, err := primitive.ObjectIDFromHex(excludeUserID); err == nil {
+		filter["_id"] = bson.M{"$ne": objID}
+	}
+
+	count, err := s.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return err == nil && count > 0
+}
+
+// loginIdentifiers returns the identifiers the tenant accepts at login
+func (s *UserService) loginIdentifiers(tenantID string) []string {
+	tenant, err := NewTenantService(s.db).GetTenantByID(tenantID)
+	if err != nil || len(tenant.Settings.LoginIdentifiers) == 0 {
+		return []string{LoginIdentifierEmail}
+	}
+	return tenant.Settings.LoginIdentifiers
+}
+
+// loginIdentifierQueries builds one user filter per accepted identifier the value can match
+func loginIdentifierQueries(identifiers []string, value string) []bson.M {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	queries := []bson.M{}
+	for _, identifier := range identifiers {
+		field, ok := loginIdentifierFields[identifier]
+		if !ok {
+			continue
+		}
+		match := value
+		if identifier == LoginIdentifierPhone {
+			if match = NormalizePhone(value); match == "" {
+				continue
+			}
+		}
+		queries = append(queries, bson.M{field: match})
+	}
+	return queries
+}
+
+// NormalizePhone reduces a phone number to a leading "+" and digits, dropping spaces, dashes,
+// dots and parentheses. It returns "" if the value is not a phone number.
+func NormalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	var b strings.Builder
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return ""
+		}
+	}
+
+	normalized := b.String()
+	if len(strings.TrimPrefix(normalized, "+")) < 5 {
+		return ""
+	}
+	return normalized
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	cases := map[string]string{
+		"+1 (555) 123-4567": "+15551234567",
+		"0044.20.7946.0018": "00442079460018",
+		" +49 30 901820 ":   "+4930901820",
+		"jane@acme.com":     "",
+		"jane":              "",
+		"12+34567":          "",
+		"123":               "",
+		"":                  "",
+	}
+
+	for input, expected := range cases {
+		if got := NormalizePhone(input); got != expected {
+			t.Errorf("NormalizePhone(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestLoginIdentifierQueries(t *testing.T) {
+	queries := loginIdentifierQueries([]string{"username", "email", "phone"}, " jdoe ")
+	expected := []bson.M{{"username": "jdoe"}, {"email": "jdoe"}}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Errorf("Expected a non-phone value to skip the phone lookup, got %v", queries)
+	}
+
+	queries = loginIdentifierQueries([]string{"email", "phone"}, "+1 555 123 4567")
+	expected = []bson.M{{"email": "+1 555 123 4567"}, {"phone": "+15551234567"}}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Errorf("Expected the phone number to be normalized, got %v", queries)
+	}
+
+	if queries := loginIdentifierQueries([]string{"email", "nickname"}, "jane@acme.com"); len(queries) != 1 {
+		t.Errorf("Expected unknown identifiers to be ignored, got %v", queries)
+	}
+	if queries := loginIdentifierQueries([]string{"email"}, "  "); len(queries) != 0 {
+		t.Errorf("Expected no lookups for an empty value, got %v", queries)
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxUserStatusHistory is how many lifecycle transitions are kept on a user
+const maxUserStatusHistory = 20
+
+var (
+	ErrLifecycleUserNotFound = errors.New("user not found")
+	ErrInvalidUserTransition = errors.New("the user can't move from its current state to the requested one")
+)
+
+// UserLifecycleService moves users between lifecycle states (see models.UserStatusActive). Users
+// leaving the active state lose their tokens and sessions right away and drop out of group member
+// lists; every transition is audited and announced through the notifier.
+type UserLifecycleService struct {
+	db           *database.MongoDB
+	users        *mongo.Collection
+	deactivation *UserDeactivationService
+	membership   *MembershipService
+}
+
+func NewUserLifecycleService(db *database.MongoDB, deactivation *UserDeactivationService) *UserLifecycleService {
+	return &UserLifecycleService{
+		db:           db,
+		users:        db.GetCollection("users"),
+		deactivation: deactivation,
+		membership:   NewMembershipService(db),
+	}
+}
+
+// MigrateStatuses gives users stored before lifecycle states existed the state of their active
+// flag
+func (s *UserLifecycleService) MigrateStatuses() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for active, status := range map[bool]string{true: models.UserStatusActive, false: models.UserStatusSuspended} {
+		if _, err := s.users.UpdateMany(ctx, bson.M{"status": bson.M{"$exists": false}, "active": active}, bson.M{
+			"$set": bson.M{"status": status},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transition moves a user to another lifecycle state. actorID is the user making the change.
+// Deprovisioning also removes the user's password, second factors and linked social identities.
+func (s *UserLifecycleService) Transition(tenantID, userID, to, reason, actorID string, r *http.Request) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrLifecycleUserNotFound
+	}
+	filter := bson.M{"_id": objID, "tenant_id": tenantID}
+
+	var user models.User
+	if err := s.users.FindOne(ctx, filter).Decode(&user); err == mongo.ErrNoDocuments {
+		return nil, ErrLifecycleUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	from := user.LifecycleStatus()
+	if !models.CanTransitionUserStatus(from, to) {
+		return nil, ErrInvalidUserTransition
+	}
+
+	now := time.Now()
+	change := models.UserStatusChange{From: from, To: to, Reason: reason, ActorID: actorID, ChangedAt: now}
+	fields := bson.M{
+		"status":            to,
+		"active":            to == models.UserStatusActive,
+		"status_reason":     reason,
+		"status_changed_at": now,
+		"updated_at":        now,
+	}
+	update := bson.M{
+		"$set":  fields,
+		"$push": bson.M{"status_history": bson.M{"$each": []models.UserStatusChange{change}, "$slice": -maxUserStatusHistory}},
+		"$inc":  bson.M{"version": 1},
+	}
+	if to == models.UserStatusDeprovisioned {
+		fields["password_hash"] = ""
+		fields["two_factor_enabled"] = false
+		fields["two_factor_secret"] = ""
+		fields["sms_two_factor"] = false
+		fields["backup_codes"] = []string{}
+		update["$unset"] = bson.M{"social_identities": "", "linked_emails": ""}
+	}
+
+	// The transition only applies to the state it was checked against
+	result, err := s.users.UpdateOne(ctx, withExpectedVersion(filter, user.Version), update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, versionedUpdateError(ctx, s.users, filter, ErrLifecycleUserNotFound)
+	}
+
+	if err := s.users.FindOne(ctx, filter).Decode(&user); err != nil {
+		return nil, err
+	}
+
+	switch to {
+	case models.UserStatusSuspended:
+		err = s.deactivation.Deactivated(&user, reason, r)
+	case models.UserStatusDeprovisioned:
+		err = s.deactivation.Deprovisioned(&user, reason, r)
+	case models.UserStatusActive:
+		s.deactivation.Reactivated(&user, reason, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Inactive users are dropped from group member lists; reactivation restores them
+	if err := s.membership.SyncUser(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
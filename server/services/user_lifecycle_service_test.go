@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oauth2-openid-server/database/dbtest"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type recordingNotifier struct {
+	types []string
+}
+
+func (n *recordingNotifier) Notify(notification *Notification) error {
+	n.types = append(n.types, notification.Type)
+	return nil
+}
+
+// TestUserLifecycleTransitions walks a user through suspension, reactivation and deprovisioning
+func TestUserLifecycleTransitions(t *testing.T) {
+	db := dbtest.New(t)
+
+	userID := primitive.NewObjectID()
+	legacyID := primitive.NewObjectID()
+	now := time.Now()
+	dbtest.Insert(t, db, "users",
+		&models.User{ID: userID, TenantID: "t1", Email: "jane@example.com", PasswordHash: "hash", Status: models.UserStatusActive, Active: true, CreatedAt: now, UpdatedAt: now},
+		&models.User{ID: legacyID, TenantID: "t1", Email: "legacy@example.com", Active: false, CreatedAt: now, UpdatedAt: now},
+	)
+
+	notifier := &recordingNotifier{}
+	service := NewUserLifecycleService(db, NewUserDeactivationService(db, NewSessionService(db), NewAuditService(db), notifier))
+	req := httptest.NewRequest("POST", "/api/v1/users/x/suspend", nil)
+
+	if err := service.MigrateStatuses(); err != nil {
+		t.Fatalf("MigrateStatuses() error = %v", err)
+	}
+	if _, err := service.Transition("t1", legacyID.Hex(), models.UserStatusActive, "", "admin", req); err != nil {
+		t.Errorf("Transition() of a migrated inactive user to active error = %v", err)
+	}
+
+	user, err := service.Transition("t1", userID.Hex(), models.UserStatusSuspended, "left the company", "admin", req)
+	if err != nil {
+		t.Fatalf("Transition() to suspended error = %v", err)
+	}
+	if user.Active || user.Status != models.UserStatusSuspended || user.StatusReason != "left the company" {
+		t.Errorf("suspended user = active %v, status %q, reason %q", user.Active, user.Status, user.StatusReason)
+	}
+
+	if user, err = service.Transition("t1", userID.Hex(), models.UserStatusActive, "", "admin", req); err != nil || !user.Active {
+		t.Fatalf("Transition() to active = %v, %v, want an active user", user, err)
+	}
+
+	user, err = service.Transition("t1", userID.Hex(), models.UserStatusDeprovisioned, "", "admin", req)
+	if err != nil {
+		t.Fatalf("Transition() to deprovisioned error = %v", err)
+	}
+	if user.PasswordHash != "" || len(user.StatusHistory) != 3 {
+		t.Errorf("deprovisioned user has password %q and %d history entries, want none and 3", user.PasswordHash, len(user.StatusHistory))
+	}
+
+	// Deprovisioning is final
+	if _, err := service.Transition("t1", userID.Hex(), models.UserStatusActive, "", "admin", req); !errors.Is(err, ErrInvalidUserTransition) {
+		t.Errorf("Transition() out of deprovisioned error = %v, want ErrInvalidUserTransition", err)
+	}
+	if _, err := service.Transition("t2", userID.Hex(), models.UserStatusSuspended, "", "admin", req); !errors.Is(err, ErrLifecycleUserNotFound) {
+		t.Errorf("Transition() in another tenant error = %v, want ErrLifecycleUserNotFound", err)
+	}
+
+	want := []string{NotificationUserReactivated, NotificationUserDeactivated, NotificationUserReactivated, NotificationUserDeprovisioned}
+	if len(notifier.types) != len(want) {
+		t.Fatalf("notifications = %v, want %v", notifier.types, want)
+	}
+	for i := range want {
+		if notifier.types[i] != want[i] {
+			t.Errorf("notification %d = %q, want %q", i, notifier.types[i], want[i])
+		}
+	}
+}
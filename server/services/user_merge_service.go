@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"oauth2-openid-server/database"
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrMergeUserNotFound = errors.New("user not found")
+	ErrMergeSameUser     = errors.New("a user cannot be merged into itself")
+	ErrMergeNotConfirmed = errors.New("merge not confirmed: confirm must equal the duplicate user's email")
+)
+
+// UserMergeService consolidates duplicate accounts of the same person into one surviving user.
+// Merging is irreversible: the duplicate user is deleted.
+type UserMergeService struct {
+	db                *database.MongoDB
+	users             *mongo.Collection
+	membershipService *MembershipService
+	sessionService    *SessionService
+}
+
+// UserMergePlan describes what merging a duplicate into a surviving user changes. It is returned
+// unapplied by a dry run.
+type UserMergePlan struct {
+	SurvivorID     string   `json:"survivor_id"`
+	SurvivorEmail  string   `json:"survivor_email"`
+	DuplicateID    string   `json:"duplicate_id"`
+	DuplicateEmail string   `json:"duplicate_email"`
+	AddedGroups    []string `json:"added_groups"`  // group names the survivor gains
+	AddedScopes    []string `json:"added_scopes"`  // scopes the survivor gains
+	LinkedEmails   []string `json:"linked_emails"` // addresses whose social logins will sign in to the survivor
+	AuditEvents    int64    `json:"audit_events"`  // audit events re-attributed to the survivor
+	Consents       int64    `json:"consents"`      // consent grants moved to the survivor
+	// The survivor keeps its own two-factor settings; the duplicate's are discarded
+	TwoFactorEnabled          bool `json:"two_factor_enabled"`
+	DuplicateTwoFactorDropped bool `json:"duplicate_two_factor_dropped"`
+	Applied                   bool `json:"applied"`
+
+	addedGroupIDs []string
+}
+
+func NewUserMergeService(db *database.MongoDB) *UserMergeService {
+	return &UserMergeService{
+		db:                db,
+		users:             db.GetCollection("users"),
+		membershipService: NewMembershipService(db),
+		sessionService:    NewSessionService(db),
+	}
+}
+
+// PlanMerge computes the changes of merging the duplicate into the survivor without applying them
+func (s *UserMergeService) PlanMerge(tenantID, survivorID, duplicateID string) (*UserMergePlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	plan, _, _, err := s.plan(ctx, tenantID, survivorID, duplicateID)
+	return plan, err
+}
+
+// MergeUsers merges the duplicate into the survivor and deletes the duplicate. confirm must equal
+// the duplicate's email address, as the merge cannot be undone.
+//
+// The duplicate's sessions, audit events and consents are handed over first; these steps only
+// touch what still belongs to the duplicate, so a merge that fails part way can simply be
+// retried. The survivor's update, the duplicate's deletion and the group memberships are then
+// applied in one transaction.
+func (s *UserMergeService) MergeUsers(tenantID, survivorID, duplicateID, confirm string) (*UserMergePlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	plan, survivor, duplicate, err := s.plan(ctx, tenantID, survivorID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	if confirm == "" || confirm != duplicate.Email {
+		return nil, ErrMergeNotConfirmed
+	}
+
+	if err := s.sessionService.RevokeAllUserSessions(duplicateID, tenantID); err != nil {
+		return nil, err
+	}
+	if err := s.moveRecords(ctx, tenantID, survivorID, duplicateID); err != nil {
+		return nil, err
+	}
+
+	session, err := s.db.Client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	now := time.Now()
+	survivor.Groups = mergeScopes(append([]string{}, survivor.Groups...), plan.addedGroupIDs)
+	survivor.Scopes = mergeScopes(append([]string{}, survivor.Scopes...), plan.AddedScopes)
+	survivor.LinkedEmails = mergeScopes(append([]string{}, survivor.LinkedEmails...), plan.LinkedEmails)
+	survivor.SocialIdentities = append(survivor.SocialIdentities, duplicate.SocialIdentities...)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// A concurrent merge of the same users deleted the duplicate already
+		result, err := s.users.DeleteOne(sc, bson.M{"_id": duplicate.ID, "tenant_id": tenantID})
+		if err != nil {
+			return nil, err
+		}
+		if result.DeletedCount == 0 {
+			return nil, ErrMergeUserNotFound
+		}
+
+		if _, err := s.users.UpdateOne(sc, bson.M{"_id": survivor.ID}, bson.M{
+			"$set": bson.M{
+				"groups":            survivor.Groups,
+				"scopes":            survivor.Scopes,
+				"linked_emails":     survivor.LinkedEmails,
+				"social_identities": survivor.SocialIdentities,
+				"updated_at":        now,
+			},
+			"$inc": bson.M{"version": 1},
+		}); err != nil {
+			return nil, err
+		}
+		if err := s.membershipService.removeUser(sc, duplicateID, tenantID); err != nil {
+			return nil, err
+		}
+		return nil, s.membershipService.syncUser(sc, survivor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Applied = true
+	return plan, nil
+}
+
+// moveRecords re-attributes the duplicate's audit events and consents to the survivor. Tenants
+// with their own storage may keep these in another cluster, so they can't join the merge
+// transaction; repeating the move after a failure picks up whatever is left.
+func (s *UserMergeService) moveRecords(ctx context.Context, tenantID, survivorID, duplicateID string) error {
+	if _, err := s.db.TenantCollection(tenantID, "audit_events").UpdateMany(ctx, bson.M{"tenant_id": tenantID, "user_id": duplicateID}, bson.M{
+		"$set": bson.M{"user_id": survivorID},
+	}); err != nil {
+		return err
+	}
+
+	// Consents move only for clients the survivor has not decided on itself
+	survivorClients, err := s.consentedClients(ctx, tenantID, survivorID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.TenantCollection(tenantID, "consents").UpdateMany(ctx, bson.M{
+		"tenant_id": tenantID,
+		"user_id":   duplicateID,
+		"client_id": bson.M{"$nin": survivorClients},
+	}, bson.M{"$set": bson.M{"user_id": survivorID, "updated_at": time.Now()}}); err != nil {
+		return err
+	}
+	_, err = s.db.TenantCollection(tenantID, "consents").DeleteMany(ctx, bson.M{"tenant_id": tenantID, "user_id": duplicateID})
+	return err
+}
+
+func (s *UserMergeService) plan(ctx context.Context, tenantID, survivorID, duplicateID string) (*UserMergePlan, *models.User, *models.User, error) {
+	if survivorID == duplicateID {
+		return nil, nil, nil, ErrMergeSameUser
+	}
+
+	survivor, err := s.findUser(ctx, tenantID, survivorID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	duplicate, err := s.findUser(ctx, tenantID, duplicateID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	plan := planUserMerge(survivor, duplicate)
+	plan.AddedGroups = s.membershipService.GetGroupNames(tenantID, plan.addedGroupIDs)
+
+	if plan.AuditEvents, err = s.db.TenantCollection(tenantID, "audit_events").CountDocuments(ctx, bson.M{"tenant_id": tenantID, "user_id": duplicateID}); err != nil {
+		return nil, nil, nil, err
+	}
+	survivorClients, err := s.consentedClients(ctx, tenantID, survivorID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if plan.Consents, err = s.db.TenantCollection(tenantID, "consents").CountDocuments(ctx, bson.M{
+		"tenant_id": tenantID,
+		"user_id":   duplicateID,
+		"client_id": bson.M{"$nin": survivorClients},
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return plan, survivor, duplicate, nil
+}
+
+func (s *UserMergeService) findUser(ctx context.Context, tenantID, id string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrMergeUserNotFound
+	}
+	var user models.User
+	if err := s.users.FindOne(ctx, bson.M{"_id": objID, "tenant_id": tenantID}).Decode(&user); err != nil {
+		return nil, ErrMergeUserNotFound
+	}
+	return &user, nil
+}
+
+func (s *UserMergeService) consentedClients(ctx context.Context, tenantID, userID string) ([]string, error) {
+	clientIDs, err := s.db.TenantCollection(tenantID, "consents").Distinct(ctx, "client_id", bson.M{"tenant_id": tenantID, "user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, id := range clientIDs {
+		if clientID, ok := id.(string); ok {
+			result = append(result, clientID)
+		}
+	}
+	return result, nil
+}
+
+// planUserMerge computes the profile changes of merging the duplicate into the survivor
+func planUserMerge(survivor, duplicate *models.User) *UserMergePlan {
+	plan := &UserMergePlan{
+		SurvivorID:                survivor.ID.Hex(),
+		SurvivorEmail:             survivor.Email,
+		DuplicateID:               duplicate.ID.Hex(),
+		DuplicateEmail:            duplicate.Email,
+		AddedGroups:               []string{},
+		AddedScopes:               removeStrings(duplicate.Scopes, survivor.Scopes),
+		LinkedEmails:              []string{},
+		TwoFactorEnabled:          survivor.TwoFactorEnabled,
+		DuplicateTwoFactorDropped: duplicate.TwoFactorEnabled,
+		addedGroupIDs:             removeStrings(duplicate.Groups, survivor.Groups),
+	}
+
+	for _, email := range append([]string{duplicate.Email}, duplicate.LinkedEmails...) {
+		if email != "" && email != survivor.Email && !containsString(survivor.LinkedEmails, email) && !containsString(plan.LinkedEmails, email) {
+			plan.LinkedEmails = append(plan.LinkedEmails, email)
+		}
+	}
+
+	return plan
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPlanUserMerge(t *testing.T) {
+	survivor := &models.User{
+		ID:               primitive.NewObjectID(),
+		Email:            "jane@example.com",
+		Groups:           []string{"g1"},
+		Scopes:           []string{"openid", "read"},
+		LinkedEmails:     []string{"jane@old.example.com"},
+		TwoFactorEnabled: true,
+	}
+	duplicate := &models.User{
+		ID:               primitive.NewObjectID(),
+		Email:            "jane.doe@gmail.com",
+		Groups:           []string{"g1", "g2"},
+		Scopes:           []string{"openid", "write"},
+		LinkedEmails:     []string{"jane@old.example.com", "jane@example.com", "jd@example.org"},
+		TwoFactorEnabled: true,
+	}
+
+	plan := planUserMerge(survivor, duplicate)
+
+	if !reflect.DeepEqual(plan.addedGroupIDs, []string{"g2"}) {
+		t.Errorf("Expected only the missing group to be added, got %v", plan.addedGroupIDs)
+	}
+	if !reflect.DeepEqual(plan.AddedScopes, []string{"write"}) {
+		t.Errorf("Expected only the missing scope to be added, got %v", plan.AddedScopes)
+	}
+	if !reflect.DeepEqual(plan.LinkedEmails, []string{"jane.doe@gmail.com", "jd@example.org"}) {
+		t.Errorf("Expected the duplicate's new addresses to be linked, got %v", plan.LinkedEmails)
+	}
+	if !plan.TwoFactorEnabled || !plan.DuplicateTwoFactorDropped {
+		t.Errorf("Expected the survivor's two-factor settings to be kept, got %+v", plan)
+	}
+}
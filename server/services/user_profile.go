@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"oauth2-openid-server/models"
+	"oauth2-openid-server/validation"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProfileAttributes are the optional profile claims released with the profile scope. In updates
+// nil fields are left unchanged and empty strings clear the attribute.
+type ProfileAttributes struct {
+	Picture  *string `json:"picture,omitempty" validate:"url,max=2048"`
+	Locale   *string `json:"locale,omitempty" validate:"locale,max=35"`
+	Zoneinfo *string `json:"zoneinfo,omitempty" validate:"timezone,max=64"`
+}
+
+// ProfileClaims returns the user's non-empty profile attributes keyed by their OIDC claim names
+func ProfileClaims(user *models.User) map[string]string {
+	claims := map[string]string{}
+	for name, value := range map[string]string{"picture": user.Picture, "locale": user.Locale, "zoneinfo": user.Zoneinfo} {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+// UpdateProfileAttributes changes the profile attributes of a user and returns the updated user
+func (s *UserService) UpdateProfileAttributes(id, tenantID string, attributes ProfileAttributes) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	unset := bson.M{}
+	for field, value := range map[string]*string{"picture": attributes.Picture, "locale": attributes.Locale, "zoneinfo": attributes.Zoneinfo} {
+		switch {
+		case value == nil:
+		case *value == "":
+			unset[field] = ""
+		default:
+			set[field] = *value
+		}
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var user models.User
+	err = s.collection.FindOneAndUpdate(ctx, bson.M{"_id": objID, "tenant_id": tenantID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	return &user, nil
+}
+
+// FillProfileAttributes sets the attributes a social provider reported that the user has not
+// set yet, so the user's own choices are never overwritten. Invalid values are ignored.
+func (s *UserService) FillProfileAttributes(user *models.User, picture, locale, zoneinfo string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set := bson.M{}
+	fill := func(field string, current *string, value string) {
+		if *current == "" && value != "" {
+			set[field] = value
+			*current = value
+		}
+	}
+	valid := validProfileAttributes(picture, locale, zoneinfo)
+	fill("picture", &user.Picture, valid["picture"])
+	fill("locale", &user.Locale, valid["locale"])
+	fill("zoneinfo", &user.Zoneinfo, valid["zoneinfo"])
+	if len(set) == 0 {
+		return nil
+	}
+
+	set["updated_at"] = time.Now()
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": set, "$inc": bson.M{"version": 1}})
+	return err
+}
+
+// validProfileAttributes returns the values that pass the rules of ProfileAttributes
+func validProfileAttributes(picture, locale, zoneinfo string) map[string]string {
+	values := map[string]string{"picture": picture, "locale": locale, "zoneinfo": zoneinfo}
+	for _, fieldErr := range validation.Struct(ProfileAttributes{Picture: &picture, Locale: &locale, Zoneinfo: &zoneinfo}) {
+		delete(values, fieldErr.Field)
+	}
+	return values
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestParseProfileAttributes(t *testing.T) {
+	s := &SocialAuthService{}
+
+	info, err := s.parseUserInfo(map[string]interface{}{
+		"id":         "fb-123",
+		"email":      "jane@example.com",
+		"first_name": "Jane",
+		"last_name":  "Doe",
+		"picture":    map[string]interface{}{"data": map[string]interface{}{"url": "https://cdn.example.com/jane.jpg"}},
+		"locale":     "de_AT",
+	}, "facebook", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Picture != "https://cdn.example.com/jane.jpg" || info.Locale != "de-AT" {
+		t.Errorf("Expected the Facebook picture and locale to be mapped, got %+v", info)
+	}
+
+	info, err = s.parseUserInfo(map[string]interface{}{
+		"sub":    "li-123",
+		"email":  "jane@example.com",
+		"locale": map[string]interface{}{"country": "US", "language": "en"},
+	}, "linkedin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Locale != "en-US" {
+		t.Errorf("Expected the LinkedIn locale object to become a tag, got %q", info.Locale)
+	}
+}
+
+func TestValidProfileAttributes(t *testing.T) {
+	values := validProfileAttributes("/relative.png", "en-GB", "Mars/Olympus")
+	expected := map[string]string{"locale": "en-GB"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected invalid attributes to be dropped, got %v", values)
+	}
+}
+
+func TestProfileClaims(t *testing.T) {
+	claims := ProfileClaims(&models.User{Picture: "https://cdn.example.com/jane.jpg", Zoneinfo: "Europe/Vienna"})
+	expected := map[string]string{"picture": "https://cdn.example.com/jane.jpg", "zoneinfo": "Europe/Vienna"}
+	if !reflect.DeepEqual(claims, expected) {
+		t.Errorf("Expected only the set attributes, got %v", claims)
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"oauth2-openid-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultUserSearchLimit is used when the caller does not ask for a result size
+	DefaultUserSearchLimit = 10
+	// MaxUserSearchLimit caps the number of results a single search can return
+	MaxUserSearchLimit = 50
+)
+
+// userSearchFields are the user fields matched by SearchUsers, in display priority order
+var userSearchFields = []string{"email", "username", "first_name", "last_name"}
+
+// UserSearchResult is a lightweight user representation for autocomplete pickers
+type UserSearchResult struct {
+	ID        string        `json:"id"`
+	Email     string        `json:"email"`
+	Username  string        `json:"username"`
+	FirstName string        `json:"first_name"`
+	LastName  string        `json:"last_name"`
+	Active    bool          `json:"active"`
+	Matches   []SearchMatch `json:"matches"`
+}
+
+// SearchMatch describes where the query matched within a field, for highlighting
+type SearchMatch struct {
+	Field  string `json:"field"`
+	Start  int    `json:"start"`
+	Length int    `json:"length"`
+}
+
+// EnsureSearchIndexes creates the tenant-scoped indexes used by user search
+func (s *UserService) EnsureSearchIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "search_values", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "search_words", Value: 1}}},
+	})
+	return err
+}
+
+// BackfillSearchKeys stores the search keys of users saved before SearchUsers matched them
+func (s *UserService) BackfillSearchKeys() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{"search_values": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"email": 1, "username": 1, "first_name": 1, "last_name": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		values, words := userSearchKeys(user.Email, user.Username, user.FirstName, user.LastName)
+		if _, err := s.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+			"$set": bson.M{"search_values": values, "search_words": words},
+		}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// setSearchKeys stores the search keys of the user's current email, username and names
+func setSearchKeys(user *models.User) {
+	user.SearchValues, user.SearchWords = userSearchKeys(user.Email, user.Username, user.FirstName, user.LastName)
+}
+
+// refreshSearchKeys recomputes the search keys of the user matching filter, after a partial update
+// of the searchable fields
+func refreshSearchKeys(ctx context.Context, users *mongo.Collection, filter bson.M) error {
+	var user models.User
+	if err := users.FindOne(ctx, filter).Decode(&user); err != nil {
+		return err
+	}
+	values, words := userSearchKeys(user.Email, user.Username, user.FirstName, user.LastName)
+	_, err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"search_values": values, "search_words": words},
+	})
+	return err
+}
+
+// userSearchKeys returns the lowercased values of the searchable fields and the distinct words in
+// them. SearchUsers matches both with anchored, case-sensitive prefix patterns, which the search
+// indexes can answer.
+func userSearchKeys(fields ...string) (values, words []string) {
+	values, words = []string{}, []string{}
+	seen := map[string]bool{}
+	for _, field := range fields {
+		value := strings.ToLower(strings.TrimSpace(field))
+		if value == "" {
+			continue
+		}
+		values = append(values, value)
+		for _, word := range strings.FieldsFunc(value, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			if !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+	return values, words
+}
+
+// SearchUsers finds users in a tenant whose email, username or name matches the query.
+// Fields starting with the query are returned before fields with a word starting with it; the
+// result size is capped.
+func (s *UserService) SearchUsers(tenantID, query string, limit int) ([]*UserSearchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query = strings.TrimSpace(query)
+	if limit <= 0 {
+		limit = DefaultUserSearchLimit
+	}
+	if limit > MaxUserSearchLimit {
+		limit = MaxUserSearchLimit
+	}
+
+	results := []*UserSearchResult{}
+	var seen []primitive.ObjectID
+	prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.ToLower(query))}
+
+	for _, field := range []string{"search_values", "search_words"} {
+		remaining := limit - len(results)
+		if remaining <= 0 {
+			break
+		}
+
+		filter := bson.M{field: prefix}
+		if tenantID != "" {
+			filter["tenant_id"] = tenantID
+		}
+		if len(seen) > 0 {
+			filter["_id"] = bson.M{"$nin": seen}
+		}
+
+		opts := options.Find().
+			SetLimit(int64(remaining)).
+			SetSort(bson.M{"email": 1}).
+			SetProjection(bson.M{"email": 1, "username": 1, "first_name": 1, "last_name": 1, "active": 1})
+
+		cursor, err := s.collection.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var users []*models.User
+		err = cursor.All(ctx, &users)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range users {
+			seen = append(seen, user.ID)
+			results = append(results, &UserSearchResult{
+				ID:        user.ID.Hex(),
+				Email:     user.Email,
+				Username:  user.Username,
+				FirstName: user.FirstName,
+				LastName:  user.LastName,
+				Active:    user.Active,
+				Matches:   FindSearchMatches(user, query),
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// FindSearchMatches returns the case-insensitive match position of query within each searchable field
+func FindSearchMatches(user *models.User, query string) []SearchMatch {
+	matches := []SearchMatch{}
+	if query == "" {
+		return matches
+	}
+
+	values := map[string]string{
+		"email":      user.Email,
+		"username":   user.Username,
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+	}
+
+	needle := []rune(strings.ToLower(query))
+	for _, field := range userSearchFields {
+		haystack := []rune(strings.ToLower(values[field]))
+		if start := indexRunes(haystack, needle); start >= 0 {
+			matches = append(matches, SearchMatch{Field: field, Start: start, Length: len(needle)})
+		}
+	}
+
+	return matches
+}
+
+// indexRunes returns the rune offset of needle in haystack, or -1
+func indexRunes(haystack, needle []rune) int {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if string(haystack[i:i+len(needle)]) == string(needle) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"oauth2-openid-server/models"
+)
+
+func TestFindSearchMatches(t *testing.T) {
+	user := &models.User{
+		Email:     "Jane.Doe@example.com",
+		Username:  "jdoe",
+		FirstName: "Jane",
+		LastName:  "Doe",
+	}
+
+	matches := FindSearchMatches(user, "doe")
+
+	expected := map[string]SearchMatch{
+		"email":     {Field: "email", Start: 5, Length: 3},
+		"username":  {Field: "username", Start: 1, Length: 3},
+		"last_name": {Field: "last_name", Start: 0, Length: 3},
+	}
+
+	if len(matches) != len(expected) {
+		t.Fatalf("Expected %d matches, got %d: %+v", len(expected), len(matches), matches)
+	}
+
+	for _, match := range matches {
+		if match != expected[match.Field] {
+			t.Errorf("Unexpected match for %s: %+v", match.Field, match)
+		}
+	}
+}
+
+func TestFindSearchMatchesUsesRuneOffsets(t *testing.T) {
+	user := &models.User{FirstName: "Žana", LastName: "Иванова"}
+
+	matches := FindSearchMatches(user, "ива")
+	if len(matches) != 1 || matches[0].Field != "last_name" || matches[0].Start != 0 || matches[0].Length != 3 {
+		t.Errorf("Unexpected matches: %+v", matches)
+	}
+
+	matches = FindSearchMatches(user, "ana")
+	if len(matches) != 1 || matches[0].Start != 1 {
+		t.Errorf("Expected rune offset 1, got %+v", matches)
+	}
+}
+
+func TestFindSearchMatchesEmptyQuery(t *testing.T) {
+	if matches := FindSearchMatches(&models.User{Email: "a@b.c"}, ""); len(matches) != 0 {
+		t.Errorf("Expected no matches for empty query, got %+v", matches)
+	}
+}
+
+func TestUserSearchKeys(t *testing.T) {
+	values, words := userSearchKeys("Jane.Doe@Example.com", "jdoe", "Jane", " Doe-Smith ", "")
+
+	if want := []string{"jane.doe@example.com", "jdoe", "jane", "doe-smith"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+	if want := []string{"jane", "doe", "example", "com", "jdoe", "smith"}; !reflect.DeepEqual(words, want) {
+		t.Errorf("words = %v, want %v", words, want)
+	}
+}
@@ -17,12 +17,14 @@ import (
 type UserService struct {
 	db         *database.MongoDB
 	collection *mongo.Collection
+	quotas     *QuotaService
 }
 
 func NewUserService(db *database.MongoDB) *UserService {
 	return &UserService{
 		db:         db,
 		collection: db.GetCollection("users"),
+		quotas:     NewQuotaService(db),
 	}
 }
 
@@ -30,11 +32,19 @@ func (s *UserService) CreateUser(user *models.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	return s.createUser(ctx, user)
+}
+
+func (s *UserService) createUser(ctx context.Context, user *models.User) error {
 	// Validate tenant ID is provided
 	if user.TenantID == "" {
 		return errors.New("tenant ID is required")
 	}
 
+	if err := s.quotas.CheckUserQuota(user.TenantID); err != nil {
+		return err
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.PasswordHash), bcrypt.DefaultCost)
 	if err != nil {
 		return err
@@ -42,9 +52,16 @@ func (s *UserService) CreateUser(user *models.User) error {
 
 	user.ID = primitive.NewObjectID()
 	user.PasswordHash = string(hashedPassword)
+	user.Phone = NormalizePhone(user.Phone)
+	user.Version = 1
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
-	user.Active = true
+	if user.Status == "" {
+		user.Status = models.UserStatusActive
+	}
+	user.Active = user.Status == models.UserStatusActive
+	user.StatusChangedAt = &user.CreatedAt
+	setSearchKeys(user)
 
 	_, err = s.collection.InsertOne(ctx, user)
 	return err
@@ -66,6 +83,43 @@ func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
+// GetUserByLinkedEmail gets the user an account with the given email was merged into
+func (s *UserService) GetUserByLinkedEmail(email string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := s.collection.FindOne(ctx, bson.M{"linked_emails": email}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUserBySocialIdentity gets the user a social provider account is linked to
+func (s *UserService) GetUserBySocialIdentity(tenantID, provider, subject string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := s.collection.FindOne(ctx, bson.M{
+		"tenant_id":         tenantID,
+		"social_identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 // GetUserByEmailAndTenant gets user by email within a specific tenant
 func (s *UserService) GetUserByEmailAndTenant(email, tenantID string) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -172,14 +226,19 @@ func (s *UserService) UpdateUser(id string, user *models.User) error {
 	}
 
 	user.UpdatedAt = time.Now()
+	setSearchKeys(user)
 	update := bson.M{"$set": user}
 
 	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
 	return err
 }
 
-// UpdateUserInTenant updates user within a specific tenant
-func (s *UserService) UpdateUserInTenant(id, tenantID string, user *models.User) error {
+// UpdateUserInTenant updates the profile fields of a user within a specific tenant. The email
+// address is not changed here; see EmailChangeService, nor is the lifecycle state; see
+// UserLifecycleService. The update is
+// only applied if the stored version equals expectedVersion (AnyVersion skips the check);
+// otherwise a VersionConflictError is returned.
+func (s *UserService) UpdateUserInTenant(id, tenantID string, user *models.User, expectedVersion int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -188,11 +247,44 @@ func (s *UserService) UpdateUserInTenant(id, tenantID string, user *models.User)
 		return err
 	}
 
+	filter := bson.M{"_id": objID, "tenant_id": tenantID}
+
 	user.UpdatedAt = time.Now()
-	update := bson.M{"$set": user}
+	fields := bson.M{
+		"username":   user.Username,
+		"phone":      NormalizePhone(user.Phone),
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"groups":     user.Groups,
+		"scopes":     user.Scopes,
+		"updated_at": user.UpdatedAt,
+	}
 
-	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": objID, "tenant_id": tenantID}, update)
-	return err
+	var current models.User
+	if err := s.collection.FindOne(ctx, filter).Decode(&current); err == nil {
+		// A new phone number has to be verified again before it can receive sign-in codes
+		if NormalizePhone(current.Phone) != fields["phone"] {
+			fields["phone_verified"] = false
+			fields["sms_two_factor"] = false
+		}
+		fields["search_values"], fields["search_words"] = userSearchKeys(current.Email, user.Username, user.FirstName, user.LastName)
+	}
+
+	update := bson.M{
+		"$set": fields,
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := s.collection.UpdateOne(ctx, withExpectedVersion(filter, expectedVersion), update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return versionedUpdateError(ctx, s.collection, filter, errors.New("user not found"))
+	}
+
+	return nil
 }
 
 func (s *UserService) DeleteUser(id string) error {
@@ -220,4 +312,8 @@ func (s *UserService) DeleteUserInTenant(id, tenantID string) error {
 
 	_, err = s.collection.DeleteOne(ctx, bson.M{"_id": objID, "tenant_id": tenantID})
 	return err
-}
\ No newline at end of file
+}
+// GetGroupNames returns the names of the groups the user belongs to
+func (s *UserService) GetGroupNames(user *models.User) []string {
+	return NewMembershipService(s.db).GetGroupNames(user.TenantID, user.Groups)
+}
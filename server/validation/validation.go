@@ -0,0 +1,258 @@
+// Package validation checks request DTOs against rules declared in a `validate` struct tag
+// given as a comma-separated list:
+//
+//	required   the value must be present (non-blank string, non-empty slice, non-nil pointer)
+//	email      a plain email address
+//	url        an absolute URL with a scheme and host
+//	weburl     an absolute http or https URL, safe to link to from a page
+//	hostname   a DNS host name such as "acme.com"
+//	slug       lowercase letters, digits and hyphens
+//	locale     a BCP 47 language tag such as "en" or "de-AT"
+//	timezone   an IANA time zone name such as "Europe/Vienna"
+//	min=N      minimum length for strings and slices, minimum value for numbers
+//	max=N      maximum length for strings and slices, maximum value for numbers
+//	oneof=a b  one of the space-separated values
+//	dive       apply the remaining rules to every element of a slice
+//
+// Apart from required, rules are skipped for empty (zero) values and pointers to them. Nested
+// structs are validated recursively and fields are reported by their JSON names, e.g.
+// "settings.session_timeout" or "redirect_uris[1]"; fields of embedded structs keep their own name.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	hostnamePattern = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+	slugPattern     = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	localePattern   = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+// FieldError describes a single violated rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// Errors is the list of field violations of a request. It is nil when the request is valid.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add appends a violation, for checks that cannot be expressed as tags
+func (e *Errors) Add(field, rule, message string) {
+	*e = append(*e, FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// Struct validates v, a struct or pointer to a struct, against its `validate` tags
+func Struct(v interface{}) Errors {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs Errors
+	validateStruct(value, "", &errs)
+	return errs
+}
+
+func validateStruct(value reflect.Value, prefix string, errs *Errors) {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		// Embedded structs without a JSON name are flattened, as encoding/json does
+		if field.Anonymous && field.Tag.Get("json") == "" && field.Type.Kind() == reflect.Struct {
+			validateStruct(value.Field(i), prefix, errs)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		fieldValue := value.Field(i)
+		if tag := field.Tag.Get("validate"); tag != "" {
+			validateValue(fieldValue, name, strings.Split(tag, ","), errs)
+		}
+
+		nested := fieldValue
+		if nested.Kind() == reflect.Ptr && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && nested.Type() != timeType {
+			validateStruct(nested, name, errs)
+		}
+	}
+}
+
+func validateValue(value reflect.Value, name string, rules []string, errs *Errors) {
+	for i, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		ruleName, param, _ := strings.Cut(rule, "=")
+
+		if ruleName == "required" {
+			if isEmpty(value) {
+				errs.Add(name, ruleName, name+" is required")
+				return
+			}
+			continue
+		}
+
+		if ruleName == "dive" {
+			if value.Kind() == reflect.Slice {
+				for j := 0; j < value.Len(); j++ {
+					validateValue(value.Index(j), fmt.Sprintf("%s[%d]", name, j), rules[i+1:], errs)
+				}
+			}
+			return
+		}
+
+		if isEmpty(value) || (value.Kind() == reflect.Ptr && isEmpty(value.Elem())) {
+			continue
+		}
+
+		if message := checkRule(value, name, ruleName, param); message != "" {
+			*errs = append(*errs, FieldError{Field: name, Rule: ruleName, Param: param, Message: message})
+		}
+	}
+}
+
+// checkRule returns the violation message of a non-empty value, or "" if the rule holds
+func checkRule(value reflect.Value, name, rule, param string) string {
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	switch rule {
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return name + " must be a valid email address"
+		}
+	case "url":
+		parsed, err := url.Parse(value.String())
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return name + " must be an absolute URL"
+		}
+	case "weburl":
+		parsed, err := url.Parse(value.String())
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return name + " must be an http or https URL"
+		}
+	case "hostname":
+		if len(value.String()) > 253 || !hostnamePattern.MatchString(value.String()) {
+			return name + " must be a valid host name"
+		}
+	case "slug":
+		if !slugPattern.MatchString(value.String()) {
+			return name + " may only contain lowercase letters, digits and hyphens"
+		}
+	case "locale":
+		if !localePattern.MatchString(value.String()) {
+			return name + " must be a language tag such as en-US"
+		}
+	case "timezone":
+		if _, err := time.LoadLocation(value.String()); err != nil || value.String() == "Local" {
+			return name + " must be an IANA time zone such as Europe/Vienna"
+		}
+	case "oneof":
+		allowed := strings.Fields(param)
+		for _, option := range allowed {
+			if value.String() == option {
+				return ""
+			}
+		}
+		return name + " must be one of: " + strings.Join(allowed, ", ")
+	case "min", "max":
+		limit, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: invalid %s parameter %q on %s", rule, param, name))
+		}
+		return checkBound(value, name, rule, limit)
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q on %s", rule, name))
+	}
+	return ""
+}
+
+func checkBound(value reflect.Value, name, rule string, limit int64) string {
+	switch value.Kind() {
+	case reflect.String:
+		length := int64(utf8.RuneCountInString(value.String()))
+		if rule == "min" && length < limit {
+			return fmt.Sprintf("%s must be at least %d characters", name, limit)
+		}
+		if rule == "max" && length > limit {
+			return fmt.Sprintf("%s must be at most %d characters", name, limit)
+		}
+	case reflect.Slice, reflect.Map:
+		length := int64(value.Len())
+		if rule == "min" && length < limit {
+			return fmt.Sprintf("%s must contain at least %d items", name, limit)
+		}
+		if rule == "max" && length > limit {
+			return fmt.Sprintf("%s must contain at most %d items", name, limit)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rule == "min" && value.Int() < limit {
+			return fmt.Sprintf("%s must be at least %d", name, limit)
+		}
+		if rule == "max" && value.Int() > limit {
+			return fmt.Sprintf("%s must be at most %d", name, limit)
+		}
+	}
+	return ""
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	}
+	return false
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,124 @@
+package validation
+
+import (
+	"testing"
+)
+
+type testPolicy struct {
+	MaxAgeDays int `json:"max_age_days" validate:"min=0,max=365"`
+}
+
+type testRequest struct {
+	Name         string     `json:"name" validate:"required,max=5"`
+	Email        string     `json:"email" validate:"email"`
+	Subdomain    string     `json:"subdomain" validate:"slug"`
+	Domain       string     `json:"domain" validate:"hostname"`
+	Locale       string     `json:"locale" validate:"locale"`
+	Zoneinfo     string     `json:"zoneinfo" validate:"timezone"`
+	RedirectURIs []string   `json:"redirect_uris" validate:"required,dive,url"`
+	Homepage     string     `json:"homepage" validate:"weburl"`
+	GrantTypes   []string   `json:"grant_types" validate:"dive,oneof=authorization_code refresh_token"`
+	Policy       testPolicy `json:"policy"`
+	Ignored      string     `json:"-" validate:"required"`
+}
+
+func fieldRules(errs Errors) map[string]string {
+	rules := make(map[string]string, len(errs))
+	for _, err := range errs {
+		rules[err.Field] = err.Rule
+	}
+	return rules
+}
+
+func TestStructValid(t *testing.T) {
+	req := &testRequest{
+		Name:         "acme",
+		Email:        "admin@acme.com",
+		Subdomain:    "acme-eu",
+		Domain:       "acme.com",
+		Locale:       "de-AT",
+		Zoneinfo:     "Europe/Vienna",
+		RedirectURIs: []string{"https://app.acme.com/callback"},
+		Homepage:     "https://acme.com",
+		GrantTypes:   []string{"authorization_code"},
+		Policy:       testPolicy{MaxAgeDays: 90},
+	}
+
+	if errs := Struct(req); errs != nil {
+		t.Fatalf("Expected no errors, got %v", errs)
+	}
+}
+
+func TestStructReportsFieldErrors(t *testing.T) {
+	req := testRequest{
+		Name:         "  ",
+		Email:        "Admin <admin@acme.com>",
+		Subdomain:    "Acme_EU",
+		Domain:       "acme..com",
+		Locale:       "german",
+		Zoneinfo:     "Mars/Olympus",
+		RedirectURIs: []string{"https://ok.example.com", "/relative"},
+		Homepage:     "javascript://acme.com/%0aalert(1)",
+		GrantTypes:   []string{"password"},
+		Policy:       testPolicy{MaxAgeDays: -1},
+	}
+
+	rules := fieldRules(Struct(req))
+	expected := map[string]string{
+		"name":                "required",
+		"email":               "email",
+		"subdomain":           "slug",
+		"domain":              "hostname",
+		"locale":              "locale",
+		"zoneinfo":            "timezone",
+		"redirect_uris[1]":    "url",
+		"homepage":            "weburl",
+		"grant_types[0]":      "oneof",
+		"policy.max_age_days": "min",
+	}
+
+	if len(rules) != len(expected) {
+		t.Errorf("Expected %d errors, got %v", len(expected), rules)
+	}
+	for field, rule := range expected {
+		if rules[field] != rule {
+			t.Errorf("Expected %s to fail %s, got %q", field, rule, rules[field])
+		}
+	}
+}
+
+func TestStructRequiredSliceAndLength(t *testing.T) {
+	rules := fieldRules(Struct(testRequest{Name: "toolong"}))
+
+	if rules["name"] != "max" {
+		t.Errorf("Expected name to fail max, got %q", rules["name"])
+	}
+	if rules["redirect_uris"] != "required" {
+		t.Errorf("Expected redirect_uris to be required, got %q", rules["redirect_uris"])
+	}
+	if _, ok := rules["email"]; ok {
+		t.Error("Expected empty optional fields to be skipped")
+	}
+}
+
+func TestMaxCountsRunes(t *testing.T) {
+	if errs := Struct(testRequest{Name: "Ærøåä", RedirectURIs: []string{"https://a.b"}}); errs != nil {
+		t.Errorf("Expected 5 runes to satisfy max=5, got %v", errs)
+	}
+}
+
+type testLinks struct {
+	Homepage string `json:"homepage" validate:"weburl"`
+}
+
+type testEmbeddingRequest struct {
+	testLinks
+	Name string `json:"name" validate:"required"`
+}
+
+func TestEmbeddedStructFieldsAreFlattened(t *testing.T) {
+	rules := fieldRules(Struct(testEmbeddingRequest{testLinks: testLinks{Homepage: "ftp://acme.com"}, Name: "acme"}))
+	if len(rules) != 1 || rules["homepage"] != "weburl" {
+		t.Errorf("Expected homepage to fail weburl, got %v", rules)
+	}
+}